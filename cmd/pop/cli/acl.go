@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var aclArgs struct {
+	private bool
+	public  bool
+	allow   string
+	deny    string
+	delete  bool
+}
+
+var aclCmd = &ffcli.Command{
+	Name:       "acl",
+	ShortUsage: "acl [-private|-public] [-allow <ids>] [-deny <ids>] <cid|tag> | acl -d <cid|tag> | acl",
+	ShortHelp:  "Control which peers may retrieve a ref from this pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop acl' command sets the access rules of a ref served by this pop. Private refs are only served to
+the peers listed with -allow while public refs are served to any peer not listed with -deny. Peers which
+may not retrieve a ref don't get any reply to their queries. The -acl-* flags of 'pop start' set the rules
+applying to every ref and the operator peers bypassing them. Without flags it shows the rules of the ref,
+without arguments it lists the refs which have rules.
+
+`),
+	Exec: runACL,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("acl", flag.ExitOnError)
+		fs.BoolVar(&aclArgs.private, "private", false, "only serve the ref to the allowed peers")
+		fs.BoolVar(&aclArgs.public, "public", false, "serve the ref to any peer which isn't denied")
+		fs.StringVar(&aclArgs.allow, "allow", "", "peer IDs allowed to retrieve the ref if private separated by commas")
+		fs.StringVar(&aclArgs.deny, "deny", "", "peer IDs never served the ref separated by commas")
+		fs.BoolVar(&aclArgs.delete, "d", false, "delete the rules of the ref so it gets the default visibility")
+		return fs
+	})(),
+}
+
+func runACL(ctx context.Context, args []string) error {
+	if aclArgs.private && aclArgs.public {
+		return errors.New("a ref cannot be both private and public")
+	}
+	aargs := &node.ACLArgs{
+		Delete: aclArgs.delete,
+		List:   len(args) == 0,
+	}
+	switch {
+	case aargs.List:
+	case len(args) == 1:
+		aargs.Root = args[0]
+	default:
+		return flag.ErrHelp
+	}
+	if aclArgs.private {
+		aargs.Visibility = "private"
+	}
+	if aclArgs.public {
+		aargs.Visibility = "public"
+	}
+	if aclArgs.allow != "" {
+		aargs.Allow = strings.Split(aclArgs.allow, ",")
+	}
+	if aclArgs.deny != "" {
+		aargs.Deny = strings.Split(aclArgs.deny, ",")
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	arc := make(chan *node.ACLResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ar := n.ACLResult; ar != nil {
+			arc <- ar
+			if ar.Last || ar.Err != "" {
+				close(arc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.ACL(aargs)
+	for ar := range arc {
+		if ar.Err != "" {
+			return errors.New(ar.Err)
+		}
+		if aargs.List && ar.Root == "" {
+			fmt.Printf("No access rules\n")
+			continue
+		}
+		fmt.Printf("==> %s is %s\n", ar.Root, ar.Visibility)
+		if len(ar.Allow) > 0 {
+			fmt.Printf("    allow %s\n", strings.Join(ar.Allow, ", "))
+		}
+		if len(ar.Deny) > 0 {
+			fmt.Printf("    deny %s\n", strings.Join(ar.Deny, ", "))
+		}
+	}
+	return nil
+}
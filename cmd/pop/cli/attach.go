@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var attachCmd = &ffcli.Command{
+	Name:       "attach",
+	ShortUsage: "attach <session>",
+	ShortHelp:  "Reattach to a commit or a get still running in the daemon",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop attach' command follows a 'pop commit' or 'pop get' after the client was disconnected or
+interrupted with -detach. The daemon keeps running the operation and prints a session token when it
+starts. Attaching with the token shows the progress sent so far and waits until the operation is done.
+
+`),
+	Exec:    runAttach,
+	FlagSet: flag.NewFlagSet("attach", flag.ExitOnError),
+}
+
+func runAttach(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: attach <session>")
+	}
+	token := args[0]
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	nc := make(chan node.Notify, 16)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		sr := n.SessionResult
+		if n.Session != token && (sr == nil || sr.Token != token) {
+			return
+		}
+		nc <- n
+	})
+	go receive(ctx, cc, c)
+
+	cc.Attach(&node.AttachArgs{Token: token})
+
+	var ref string
+	for {
+		select {
+		case n := <-nc:
+			if sr := n.SessionResult; sr != nil {
+				if sr.Err != "" {
+					return errors.New(sr.Err)
+				}
+				if sr.Done {
+					return nil
+				}
+				if ref == "" {
+					ref = sr.Ref
+					fmt.Printf("==> Reattached to %s %s\n", sr.Op, sr.Ref)
+				}
+				continue
+			}
+			if gr := n.GetResult; gr != nil {
+				// The ref of a get session is the root we may have to confirm the cost of
+				if _, err := handleGetResult(cc, ref, gr); err != nil {
+					return err
+				}
+			}
+			if cr := n.CommResult; cr != nil {
+				if err := handleCommResult(cr, 0); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sessionFilter follows the session started by a command and drops the notifications
+// of the operations other clients are running
+type sessionFilter struct {
+	op string
+
+	mu    sync.Mutex
+	token string
+}
+
+// accept returns true if the notification belongs to our session or to no session
+func (sf *sessionFilter) accept(n node.Notify) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sr := n.SessionResult; sr != nil && sf.token == "" && sr.Op == sf.op && !sr.Done {
+		sf.token = sr.Token
+		fmt.Printf("==> Session %s\n", sf.token)
+	}
+	return n.Session == "" || sf.token == "" || n.Session == sf.token
+}
+
+// interrupted cancels the session when the client stops before the operation is done or tells how
+// to reattach to it if the operation should keep running in the daemon
+func (sf *sessionFilter) interrupted(cc *node.CommandClient, detach bool, err error) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.token == "" {
+		return err
+	}
+	if detach {
+		return fmt.Errorf("%w, the daemon is still running it: pop attach %s", err, sf.token)
+	}
+	cc.Cancel(&node.CancelArgs{Token: sf.token})
+	return fmt.Errorf("%w, cancelled session %s", err, sf.token)
+}
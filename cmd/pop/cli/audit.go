@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var auditArgs struct {
+	peer   string
+	root   string
+	since  time.Duration
+	limit  int
+	export string
+	verify bool
+	check  string
+}
+
+var auditCmd = &ffcli.Command{
+	Name:       "audit",
+	ShortUsage: "audit [-peer <id>] [-root <cid>] [-since <duration>] | audit -export <path> | audit -verify | audit -check <path>",
+	ShortHelp:  "Query the log of the retrievals served by this pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop audit' command prints the retrievals served by this pop with the requester, root, bytes sent,
+funds and vouchers received. Each entry is chained to the previous one by its hash so the log can be
+exported with -export and checked by anyone with -check, which doesn't need a running pop. Compare the
+head hash with the one printed by -verify to make sure the log wasn't rewritten.
+
+`),
+	Exec: runAudit,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("audit", flag.ExitOnError)
+		fs.StringVar(&auditArgs.peer, "peer", "", "only print the retrievals requested by this peer")
+		fs.StringVar(&auditArgs.root, "root", "", "only print the retrievals of this root")
+		fs.DurationVar(&auditArgs.since, "since", 0, "only print the retrievals which ended within this duration")
+		fs.IntVar(&auditArgs.limit, "limit", 100, "maximum number of recent retrievals to print")
+		fs.StringVar(&auditArgs.export, "export", "", "write the whole log as JSON lines to the path")
+		fs.BoolVar(&auditArgs.verify, "verify", false, "check the hash chain of the log and print its head")
+		fs.StringVar(&auditArgs.check, "check", "", "check the hash chain of an exported log")
+		return fs
+	})(),
+}
+
+func runAudit(ctx context.Context, args []string) error {
+	if auditArgs.check != "" {
+		f, err := os.Open(auditArgs.check)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, head, err := exchange.VerifyAudit(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("==> Verified %d entries, head %s\n", n, head)
+		return nil
+	}
+	aargs := &node.AuditArgs{
+		Peer:   auditArgs.peer,
+		Root:   auditArgs.root,
+		Since:  auditArgs.since,
+		Limit:  auditArgs.limit,
+		Verify: auditArgs.verify,
+	}
+	if auditArgs.export != "" {
+		// The daemon may not run in the same directory
+		path, err := filepath.Abs(auditArgs.export)
+		if err != nil {
+			return err
+		}
+		aargs.Export = path
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	arc := make(chan *node.AuditResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ar := n.AuditResult; ar != nil {
+			arc <- ar
+			if ar.Last || ar.Err != "" {
+				close(arc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Audit(aargs)
+	for ar := range arc {
+		if ar.Err != "" {
+			return errors.New(ar.Err)
+		}
+		switch {
+		case aargs.Export != "":
+			fmt.Printf("==> Exported %d entries to %s, head %s\n", ar.Len, ar.Path, ar.Head)
+		case aargs.Verify:
+			fmt.Printf("==> Verified %d entries, head %s\n", ar.Len, ar.Head)
+		default:
+			fmt.Printf("==> #%d %s %s deal %d from %s\n", ar.Seq, ar.Time.Format(time.RFC3339), ar.Status, ar.Deal, ar.Peer)
+			fmt.Printf("    %s sent of %s, paid %s in %d vouchers\n", ar.Size, ar.Root, ar.Paid, ar.Vouchers)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var benchSelfArgs struct {
+	size   string
+	dryRun bool
+}
+
+var benchCmd = &ffcli.Command{
+	Name:       "bench",
+	ShortUsage: "bench <subcommand>",
+	ShortHelp:  "Measure the performance of this pop",
+	Subcommands: []*ffcli.Command{
+		benchSelfCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var benchSelfCmd = &ffcli.Command{
+	Name:       "self",
+	ShortUsage: "bench self [-size <size>] [-dry-run]",
+	ShortHelp:  "Measure disk, chunking and loopback transfer rates",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop bench self' command measures the disk throughput of the repo, the speed at which content is chunked
+and the transfer rate between two hosts over the loopback interface. The capability report is saved in the repo
+and applied the next time the pop starts: the capacity is reduced if the disk can't write it in a few hours and
+the number and bandwidth of replications are set to what the slowest of the measures can sustain. Run it while
+the daemon is stopped for accurate results.
+
+`),
+	Exec: runBenchSelf,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("self", flag.ExitOnError)
+		fs.StringVar(&benchSelfArgs.size, "size", units.BytesSize(node.DefaultBenchSize), "amount of data used by each measure")
+		fs.BoolVar(&benchSelfArgs.dryRun, "dry-run", false, "print the report without saving it in the repo")
+		return fs
+	})(),
+}
+
+func runBenchSelf(ctx context.Context, args []string) error {
+	size, err := units.RAMInBytes(benchSelfArgs.size)
+	if err != nil {
+		return err
+	}
+	path, err := utils.FullPath(utils.RepoPath())
+	if err != nil {
+		return err
+	}
+	exists, err := utils.RepoExists(path)
+	if err != nil {
+		return err
+	}
+	dir := path
+	if !exists {
+		// Measure the default temporary directory until a repo is created
+		dir = os.TempDir()
+	}
+
+	fmt.Printf("==> Running self benchmark with %s in %s\n", units.BytesSize(float64(size)), dir)
+	c, err := node.RunSelfBench(ctx, dir, size)
+	if err != nil {
+		return err
+	}
+
+	rate := func(r uint64) string {
+		return units.BytesSize(float64(r)) + "/s"
+	}
+	fmt.Printf("==> Disk write %s\n", rate(c.DiskWrite))
+	fmt.Printf("==> Disk read %s\n", rate(c.DiskRead))
+	fmt.Printf("==> Chunking %s\n", rate(c.Chunking))
+	fmt.Printf("==> Loopback transfer %s\n", rate(c.Loopback))
+	fmt.Printf("==> Replications %d at %s\n", c.MaxReplications(), rate(c.ReplicationBandwidth()))
+
+	if benchSelfArgs.dryRun {
+		return nil
+	}
+	if !exists {
+		fmt.Println("==> No repo to save the report in, run 'pop start' first")
+		return nil
+	}
+	if err := node.SaveCapability(path, *c); err != nil {
+		return err
+	}
+	fmt.Println("==> Saved report, restart your pop to apply it")
+	return nil
+}
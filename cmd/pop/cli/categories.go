@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var categoriesCmd = &ffcli.Command{
+	Name:      "categories",
+	ShortHelp: "List how much of its quota each category of content uses",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop categories' command prints the categories the capacity of this pop is partitioned between with the
+number of roots and the size they use. Categories are read from a label of the content, set with
+'pop commit -label category=video' for instance, and content without a listed category counts as other.
+Each category evicts its own least used content once it goes over its quota so a traffic spike of one
+content type doesn't evict everything else. Quotas are set with the -category-quotas flag of 'pop start'.
+
+`),
+	Exec: runCategories,
+}
+
+func runCategories(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	crc := make(chan *node.CategoriesResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if cr := n.CategoriesResult; cr != nil {
+			crc <- cr
+			if cr.Last || cr.Err != "" {
+				close(crc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Categories(&node.CategoriesArgs{})
+	for cr := range crc {
+		if cr.Err != "" {
+			return errors.New(cr.Err)
+		}
+		fmt.Printf("==> %s %d roots %s (%.0f%% of %.0f%% quota)\n", cr.Category, cr.Refs, cr.Size, cr.Share*100, cr.Quota*100)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
@@ -9,11 +10,13 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/myelnet/pop/build"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v2/ffcli"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,7 +26,7 @@ func Run(args []string) error {
 		fmt.Println(build.Version)
 		return nil
 	}
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	logging.SetOutput(os.Stderr, false)
 
 	rootfs := flag.NewFlagSet("pop", flag.ExitOnError)
 
@@ -37,12 +40,46 @@ change until a first stable release. To get started run 'pop start'.
 `),
 		Subcommands: []*ffcli.Command{
 			startCmd,
+			stopCmd,
+			restartCmd,
 			pingCmd,
 			putCmd,
+			importCmd,
+			exportCmd,
 			statusCmd,
 			commCmd,
+			pushCmd,
+			unstageCmd,
 			getCmd,
 			listCmd,
+			lsCmd,
+			inspectCmd,
+			peersCmd,
+			publishersCmd,
+			categoriesCmd,
+			leaseCmd,
+			searchCmd,
+			warmCmd,
+			indexCmd,
+			testnetCmd,
+			gcCmd,
+			usageCmd,
+			trackCmd,
+			attachCmd,
+			benchCmd,
+			recordCmd,
+			replayCmd,
+			tokenCmd,
+			auditCmd,
+			receiptsCmd,
+			walletCmd,
+			configCmd,
+			tagCmd,
+			aclCmd,
+			statsCmd,
+			probesCmd,
+			snapshotsCmd,
+			logCmd,
 		},
 		FlagSet: rootfs,
 		Exec:    func(context.Context, []string) error { return flag.ErrHelp },
@@ -59,6 +96,42 @@ change until a first stable release. To get started run 'pop start'.
 	return err
 }
 
+// Exit codes let scripts branch on why a command failed
+const (
+	ExitFailure           = 1
+	ExitNoOffers          = 3
+	ExitOfferTooExpensive = 4
+	ExitTransferStalled   = 5
+	ExitStoreFull         = 6
+	ExitNotFoundRemote    = 7
+	ExitPaymentFailed     = 8
+)
+
+// ExitCode returns the process exit code for an error returned by Run
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, exchange.ErrNoOffers):
+		return ExitNoOffers
+	case errors.Is(err, exchange.ErrOfferTooExpensive):
+		return ExitOfferTooExpensive
+	case errors.Is(err, exchange.ErrTransferStalled):
+		return ExitTransferStalled
+	case errors.Is(err, exchange.ErrStoreFull):
+		return ExitStoreFull
+	case errors.Is(err, exchange.ErrNotFoundRemote):
+		return ExitNotFoundRemote
+	case errors.Is(err, exchange.ErrPaymentFailed):
+		return ExitPaymentFailed
+	}
+	return ExitFailure
+}
+
+// interruptGrace is how long a command has to notify the daemon when it is interrupted before the
+// connection is closed
+const interruptGrace = 500 * time.Millisecond
+
 func connect(ctx context.Context) (net.Conn, *node.CommandClient, context.Context, context.CancelFunc) {
 	c, err := node.SocketConnect()
 	if err != nil {
@@ -75,8 +148,10 @@ func connect(ctx context.Context) (net.Conn, *node.CommandClient, context.Contex
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
 		<-interrupt
-		c.Close()
+		// Cancelling first lets the command tell the daemon to abort its operation
 		cancel()
+		time.Sleep(interruptGrace)
+		c.Close()
 	}()
 
 	cc := node.NewCommandClient(clientToServer)
@@ -5,11 +5,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"net"
 	"strings"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/exchange"
 	fil "github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v2/ffcli"
@@ -21,6 +22,12 @@ var commArgs struct {
 	storageRF int
 	duration  time.Duration
 	maxPrice  uint64
+	labels    labelFlags
+	timeout   time.Duration
+	detach    bool
+	ipns      string
+	uplink    string
+	deadline  time.Duration
 }
 
 var commCmd = &ffcli.Command{
@@ -31,19 +38,32 @@ var commCmd = &ffcli.Command{
 
 The 'pop commit' command deploys a DAG archive initialized with one or multiple 'put' on the Filecoin storage
 with a given level of cashing. By default it will attempt multiple storage deals for 6 months with caching in the initial regions.
+The -ipns flag publishes the root under the IPNS name of a key so 'pop get /ipns/<name>' always retrieves the latest commit.
+The -uplink and -deadline flags pace the transfers to the cache providers e.g. '-uplink 50Mbps -deadline 6h' sends the
+copies within 6 hours using at most 50 Mbps. Providers receive the content at the same time if the budget allows it,
+one after the other otherwise.
 
 `),
-	Exec: runCommit,
-	FlagSet: (func() *flag.FlagSet {
-		fs := flag.NewFlagSet("commit", flag.ExitOnError)
-		fs.IntVar(&commArgs.cacheRF, "cache-rf", 2, "number of cache providers to dispatch to")
-		fs.IntVar(&commArgs.storageRF, "storage-rf", 2, "number of storage providers to start deals with")
-		fs.DurationVar(&commArgs.duration, "duration", 24*time.Hour*time.Duration(180), "duration we need the content stored for")
-		fs.BoolVar(&commArgs.cacheOnly, "cache-only", false, "only dispatch content for caching")
-		// MaxStoragePrice is our price ceiling to filter out bad storage miners who charge too much
-		fs.Uint64Var(&commArgs.maxPrice, "max-storage-price", uint64(20_000_000_000), "maximum price per byte our node is willing to pay for storage")
-		return fs
-	})(),
+	Exec:    runCommit,
+	FlagSet: commitFlagSet("commit"),
+}
+
+// commitFlagSet returns the flags shared by the commands committing a transaction
+func commitFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.IntVar(&commArgs.cacheRF, "cache-rf", 2, "number of cache providers to dispatch to")
+	fs.IntVar(&commArgs.storageRF, "storage-rf", 2, "number of storage providers to start deals with")
+	fs.DurationVar(&commArgs.duration, "duration", 24*time.Hour*time.Duration(180), "duration we need the content stored for")
+	fs.BoolVar(&commArgs.cacheOnly, "cache-only", false, "only dispatch content for caching")
+	fs.Var(&commArgs.labels, "label", "label to save with the content formatted as key=value, can be repeated")
+	// MaxStoragePrice is our price ceiling to filter out bad storage miners who charge too much
+	fs.Uint64Var(&commArgs.maxPrice, "max-storage-price", uint64(20_000_000_000), "maximum price per byte our node is willing to pay for storage")
+	fs.DurationVar(&commArgs.timeout, "timeout", 0, "abort the commit if it isn't completed in time, 0 means no timeout")
+	fs.BoolVar(&commArgs.detach, "detach", false, "keep committing in the daemon if the command is interrupted")
+	fs.StringVar(&commArgs.ipns, "ipns", "", "publish the root under the IPNS name of this keystore key, self is the node identity")
+	fs.StringVar(&commArgs.uplink, "uplink", "", "maximum bit rate used to send the content to cache providers e.g. 50Mbps, unlimited if empty")
+	fs.DurationVar(&commArgs.deadline, "deadline", 0, "time within which the cache providers should receive the content with the uplink budget")
+	return fs
 }
 
 func runCommit(ctx context.Context, args []string) error {
@@ -51,29 +71,50 @@ func runCommit(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		ref = args[0]
 	}
+	return commit(ctx, ref, nil)
+}
+
+// commit commits the given keys of the ongoing transaction or all its entries if keys is empty
+func commit(ctx context.Context, ref string, keys []string) error {
+	labels := make(map[string]string, len(commArgs.labels))
+	for _, l := range commArgs.labels {
+		label, err := exchange.ParseLabel(l)
+		if err != nil {
+			return err
+		}
+		labels[label.Key] = label.Value
+	}
+	uplink, err := parseBitRate(commArgs.uplink)
+	if err != nil {
+		return err
+	}
 
 	c, cc, ctx, cancel := connect(ctx)
 	defer cancel()
 
-	crc := make(chan *node.CommResult, 1)
-	cc.SetNotifyCallback(func(n node.Notify) {
-		if cr := n.CommResult; cr != nil {
-			crc <- cr
-		}
-	})
 	go receive(ctx, cc, c)
 
 	var miners map[string]bool
-	var err error
 
 	// When only pushing content to caches we don't ask for a quote
 	if !commArgs.cacheOnly {
-		miners, err = runQuote(ctx, c, cc, ref)
+		miners, err = runQuote(ctx, cc, ref)
 		if err != nil {
 			return err
 		}
 	}
 
+	sf := &sessionFilter{op: "commit"}
+	crc := make(chan *node.CommResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if !sf.accept(n) {
+			return
+		}
+		if cr := n.CommResult; cr != nil {
+			crc <- cr
+		}
+	})
+
 	cc.Commit(&node.CommArgs{
 		Ref:       ref,
 		CacheOnly: commArgs.cacheOnly,
@@ -81,43 +122,58 @@ func runCommit(ctx context.Context, args []string) error {
 		StorageRF: commArgs.storageRF,
 		Duration:  commArgs.duration,
 		Miners:    miners,
+		Labels:    labels,
+		Keys:      keys,
+		Timeout:   commArgs.timeout,
+		IPNS:      commArgs.ipns,
+		Uplink:    uplink,
+		Deadline:  commArgs.deadline,
 	})
 	received := 0
 	for {
 		select {
 		case cr := <-crc:
-			if cr.Err != "" {
-				return errors.New(cr.Err)
-			}
-			if len(cr.Miners) > 0 {
-				fmt.Printf("Started storage deals with %s\n", cr.Miners)
-				if commArgs.cacheRF > 0 {
-					// Wait for the result of our cache dispatch
-					fmt.Printf("Dispatching to caches...\n")
-					continue
-				}
-			}
-			if len(cr.Caches) > 0 {
-				fmt.Printf("Cached by %s\n", cr.Caches)
-				received += len(cr.Caches)
+			if err := handleCommResult(cr, commArgs.cacheRF); err != nil {
+				return err
 			}
+			received += len(cr.Caches)
 			if received == commArgs.cacheRF {
 				return nil
 			}
 		case <-ctx.Done():
-			return ctx.Err()
+			return sf.interrupted(cc, commArgs.detach, ctx.Err())
+		}
+	}
+}
+
+// handleCommResult prints the progress of a commit
+func handleCommResult(cr *node.CommResult, cacheRF int) error {
+	if cr.Err != "" {
+		return exchange.ErrorFromCode(cr.Code, cr.Err)
+	}
+	if len(cr.Miners) > 0 {
+		fmt.Printf("Started storage deals with %s\n", cr.Miners)
+		if cacheRF > 0 {
+			// Wait for the result of our cache dispatch
+			fmt.Printf("Dispatching to caches...\n")
 		}
 	}
+	if cr.IPNS != "" {
+		fmt.Printf("Published as %s\n", cr.IPNS)
+	}
+	if len(cr.Caches) > 0 {
+		fmt.Printf("Cached by %s\n", cr.Caches)
+	}
+	return nil
 }
 
-func runQuote(ctx context.Context, c net.Conn, cc *node.CommandClient, ref string) (map[string]bool, error) {
+func runQuote(ctx context.Context, cc *node.CommandClient, ref string) (map[string]bool, error) {
 	qrc := make(chan *node.QuoteResult, 1)
 	cc.SetNotifyCallback(func(n node.Notify) {
 		if qr := n.QuoteResult; qr != nil {
 			qrc <- qr
 		}
 	})
-	go receive(ctx, cc, c)
 
 	fmt.Printf("Calculating storage price...\n")
 
@@ -177,3 +233,16 @@ func runQuote(ctx context.Context, c net.Conn, cc *node.CommandClient, ref strin
 	}
 	return miners, nil
 }
+
+// parseBitRate parses a bit rate such as 50Mbps into a number of bytes per second, an empty rate is 0
+func parseBitRate(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.TrimSuffix(strings.ToLower(s), "bps")
+	bits, err := units.FromHumanSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bit rate %q: %w", s, err)
+	}
+	return uint64(bits) / 8, nil
+}
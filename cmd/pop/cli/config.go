@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var configCmd = &ffcli.Command{
+	Name:       "config",
+	ShortUsage: "config <subcommand>",
+	ShortHelp:  "Change the settings of this pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop config' commands change the settings saved in the PopConfig.json file of the repo. The file
+holds the flags of 'pop start' and can be edited by hand, run 'pop config reload' or send a SIGHUP to
+the pop process to apply the changes.
+
+`),
+	Subcommands: []*ffcli.Command{
+		configSetCmd,
+		configReloadCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var configSetCmd = &ffcli.Command{
+	Name:       "set",
+	ShortUsage: "config set <key> [value]",
+	ShortHelp:  "Save a setting and apply it to the running pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop config set' command saves a setting for the next time pop starts and applies it right away if
+a pop is running. An empty value resets the key to its default. These keys apply to a running pop:
+
+  capacity                     storage space allocated for the node e.g. 10GB
+  publisher-share              fraction of the capacity the content of a single publisher can use
+  bootstrap                    p2p addresses of the bootstrap pops separated by commas
+  min-peers                    number of pop peers below which we bootstrap again
+  max-memory                   memory usage above which new transfers are rejected e.g. 512MB, only
+                               if pop started with a resource budget
+  max-goroutines               number of goroutines above which new transfers are rejected, only if
+                               pop started with a resource budget
+  upload-limit                 bytes per second sent to all the peers e.g. 10MB, unlimited if empty
+  download-limit               bytes per second received from all the peers
+  peer-upload-limit            bytes per second sent to each peer
+  peer-download-limit          bytes per second received from each peer
+  acl-default                  visibility of the refs without access rules, public or private
+  acl-allow                    peer IDs allowed to retrieve public refs separated by commas
+  acl-deny                     peer IDs never served any ref separated by commas
+  acl-operators                peer IDs bypassing the access rules separated by commas
+
+The pricing keys set the price of the retrievals served by this pop:
+
+  pricing.price                price per GiB e.g. 0.0001FIL, defaults to the price of the region
+  pricing.free-below           size under which content is served for free e.g. 1MB
+  pricing.free-peers           peer IDs retrieving for free separated by commas
+  pricing.max-load             number of retrievals served at the same time at which the price
+                               reaches the max load multiplier
+  pricing.max-load-multiplier  factor applied to the price at max load e.g. 2
+
+The address keys only apply the next time pop starts:
+
+  listen                       multiaddresses the libp2p host listens on separated by commas, add
+                               /ip4/0.0.0.0/tcp/41505/ws so browsers can connect over WebSocket or
+                               /ip4/0.0.0.0/udp/41504/quic for peers dialing over QUIC
+  announce                     multiaddresses advertised to other peers separated by commas e.g.
+                               the public address of a port forwarded by the router
+
+`),
+	Exec: runConfigSet,
+}
+
+func runConfigSet(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: config set <key> [value]")
+	}
+	key, value := args[0], ""
+	if len(args) == 2 {
+		value = args[1]
+	}
+	path, err := utils.FullPath(utils.RepoPath())
+	if err != nil {
+		return err
+	}
+	confPath := filepath.Join(path, node.ConfigFile)
+
+	// The host addresses can't change while pop is running
+	switch key {
+	case "listen", "announce":
+		var addrs []string
+		if value != "" {
+			addrs = strings.Split(value, ",")
+		}
+		validate := node.ValidateListenAddrs
+		if key == "announce" {
+			validate = node.ValidateAnnounceAddrs
+		}
+		if err := validate(addrs); err != nil {
+			return err
+		}
+		if err := saveConfigKey(confPath, key, value); err != nil {
+			return err
+		}
+		fmt.Printf("==> Saved %s, it applies the next time pop starts\n", key)
+		return nil
+	}
+
+	// Check the value before saving it so the next start doesn't fail
+	if err := node.SetLiveKey(&node.LiveConfig{}, key, value); err != nil {
+		return err
+	}
+	if err := saveConfigKey(confPath, key, value); err != nil {
+		return err
+	}
+
+	c, err := node.SocketConnect()
+	if err != nil {
+		fmt.Printf("==> Saved %s, it applies the next time pop starts\n", key)
+		return nil
+	}
+	c.Close()
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	crc := make(chan *node.ConfigResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if cr := n.ConfigResult; cr != nil {
+			crc <- cr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Config(&node.ConfigArgs{
+		Key:   key,
+		Value: value,
+	})
+	select {
+	case cr := <-crc:
+		if cr.Err != "" {
+			return errors.New(cr.Err)
+		}
+		if len(cr.Restart) > 0 {
+			fmt.Printf("==> Saved %s, it applies the next time pop starts\n", cr.Key)
+			return nil
+		}
+		fmt.Printf("==> Saved and applied %s\n", cr.Key)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var configReloadCmd = &ffcli.Command{
+	Name:       "reload",
+	ShortUsage: "config reload",
+	ShortHelp:  "Apply the changes of the config file to the running pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop config reload' command applies the keys of the PopConfig.json file which changed since pop
+started or the last reload. The keys listed by 'pop config set' apply right away, the other keys such as
+the regions or the listen addresses apply the next time pop starts. Keys removed from the file keep
+their value until pop restarts. Sending a SIGHUP to the pop process reloads the config file too.
+
+`),
+	Exec: runConfigReload,
+}
+
+func runConfigReload(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	crc := make(chan *node.ConfigResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if cr := n.ConfigResult; cr != nil {
+			crc <- cr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Config(&node.ConfigArgs{Reload: true})
+	select {
+	case cr := <-crc:
+		if cr.Err != "" {
+			return errors.New(cr.Err)
+		}
+		if len(cr.Applied) == 0 && len(cr.Restart) == 0 {
+			fmt.Printf("==> No change to apply\n")
+		}
+		if len(cr.Applied) > 0 {
+			fmt.Printf("==> Applied %s\n", strings.Join(cr.Applied, ", "))
+		}
+		if len(cr.Restart) > 0 {
+			fmt.Printf("==> Restart pop to apply %s\n", strings.Join(cr.Restart, ", "))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// saveConfigKey sets a flag in the JSON config file read by pop start, an empty value removes it
+func saveConfigKey(path, key, value string) error {
+	conf := make(map[string]interface{})
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return err
+		}
+	}
+	if value == "" {
+		delete(conf, key)
+	} else {
+		conf[key] = value
+	}
+	data, err = json.MarshalIndent(conf, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+const (
+	// pidFile holds the process ID of the pop running on the repo
+	pidFile = "pop.pid"
+	// lockFile is held by the pop running on the repo so it can't start twice
+	lockFile = "pop.lock"
+	// logFile receives the output of a detached pop
+	logFile = "pop.log"
+	// detachedEnv is set in the environment of a detached pop so it doesn't detach again
+	detachedEnv = "POP_DETACHED"
+)
+
+// detachReadyTimeout is how long we wait for a detached pop to answer before giving up
+const detachReadyTimeout = 2 * time.Minute
+
+var stopArgs struct {
+	timeout time.Duration
+}
+
+var stopCmd = &ffcli.Command{
+	Name:      "stop",
+	ShortHelp: "Stop the pop running on the repo",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop stop' command asks the pop running on the repo to shut down and waits until it exits. It works
+for pops started in the foreground as well as with 'pop start -detach'.
+
+`),
+	Exec: func(ctx context.Context, args []string) error {
+		path, err := utils.FullPath(utils.RepoPath())
+		if err != nil {
+			return err
+		}
+		pid, err := stopDaemon(path, stopArgs.timeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("==> Stopped pop with pid %d\n", pid)
+		return nil
+	},
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("stop", flag.ExitOnError)
+		fs.DurationVar(&stopArgs.timeout, "timeout", 30*time.Second, "time to wait for pop to shut down")
+		return fs
+	})(),
+}
+
+var restartCmd = &ffcli.Command{
+	Name:       "restart",
+	ShortUsage: "restart [start flags]",
+	ShortHelp:  "Restart the pop running on the repo in the background",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop restart' command stops the pop running on the repo if any then starts it again in the
+background like 'pop start -detach'. It accepts the flags of 'pop start', the flags saved in the
+PopConfig.json file of the repo apply as usual.
+
+`),
+	Exec: func(ctx context.Context, args []string) error {
+		path, err := utils.FullPath(utils.RepoPath())
+		if err != nil {
+			return err
+		}
+		pid, err := stopDaemon(path, stopArgs.timeout)
+		if errors.Is(err, errNotRunning) {
+			fmt.Printf("==> pop wasn't running\n")
+		} else if err != nil {
+			return err
+		} else {
+			fmt.Printf("==> Stopped pop with pid %d\n", pid)
+		}
+		return detach(ctx, path, commandArgs("restart"))
+	},
+	// The flags are checked like start flags then passed on to the detached pop
+	FlagSet: startCmd.FlagSet,
+	Options: startCmd.Options,
+}
+
+// errNotRunning is returned when stopping a repo no pop is running on
+var errNotRunning = errors.New("pop isn't running")
+
+// commandArgs returns the arguments given after a subcommand on the command line
+func commandArgs(name string) []string {
+	for i, arg := range os.Args {
+		if i > 0 && arg == name {
+			return os.Args[i+1:]
+		}
+	}
+	return nil
+}
+
+// isDetached returns whether we are the background process of pop start -detach
+func isDetached() bool {
+	return os.Getenv(detachedEnv) != ""
+}
+
+// detach starts pop in the background with the given start flags, its output is written to the log
+// file of the repo. It returns once the pop answers or fails to start.
+func detach(ctx context.Context, path string, args []string) error {
+	if pid, err := readPid(path); err == nil && processAlive(pid) {
+		return fmt.Errorf("pop is already running on %s with pid %d", path, pid)
+	}
+	if exists, err := utils.RepoExists(path); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("no repo at %s, run pop start in the foreground once to set it up", path)
+	}
+	// A detached pop can't prompt for the settings
+	if startArgs.temp {
+		return errors.New("a temporary repo can't be used in the background")
+	}
+	if startArgs.regions == "" {
+		return fmt.Errorf("set the regions with -regions or in %s to start pop in the background", node.ConfigFile)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logPath := filepath.Join(path, logFile)
+	logf, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logf.Close()
+
+	cmd := exec.Command(exe, append([]string{"start"}, withoutDetach(args)...)...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	cmd.SysProcAttr = detachAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, detachReadyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("pop failed to start (%v), see %s", err, logPath)
+		case <-ticker.C:
+			if pingDaemon(ctx) == nil {
+				fmt.Printf("==> Started pop in the background with pid %d, logs are in %s\n", cmd.Process.Pid, logPath)
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("pop with pid %d isn't answering yet, see %s", cmd.Process.Pid, logPath)
+		}
+	}
+}
+
+// withoutDetach removes the detach flag from start flags
+func withoutDetach(args []string) []string {
+	var out []string
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == "detach" || strings.HasPrefix(name, "detach=") {
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// pingDaemon returns nil if the daemon answers a ping
+func pingDaemon(ctx context.Context) error {
+	c, err := node.SocketConnect()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	cc := node.NewCommandClient(func(b []byte) {
+		node.WriteMsg(c, b)
+	})
+	prc := make(chan *node.PingResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PingResult; pr != nil {
+			select {
+			case prc <- pr:
+			default:
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+	cc.Ping("")
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopDaemon terminates the pop running on the repo and waits until it exits. It returns the process ID
+// of the pop.
+func stopDaemon(path string, timeout time.Duration) (int, error) {
+	pid, err := readPid(path)
+	if os.IsNotExist(err) {
+		return 0, errNotRunning
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !processAlive(pid) {
+		// The pop crashed without cleaning up
+		os.Remove(filepath.Join(path, pidFile))
+		return 0, errNotRunning
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+	if err := terminate(p); err != nil {
+		return 0, err
+	}
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("pop with pid %d is still shutting down after %s", pid, timeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return pid, nil
+}
+
+// lockRepo makes sure no other pop runs on the repo and records our process ID until the returned
+// function is called
+func lockRepo(path string) (func(), error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	f, err := openLockFile(filepath.Join(path, lockFile))
+	if err != nil {
+		if pid, perr := readPid(path); perr == nil {
+			return nil, fmt.Errorf("pop is already running on %s with pid %d", path, pid)
+		}
+		return nil, fmt.Errorf("failed to lock %s, is pop already running? %w", path, err)
+	}
+	pidPath := filepath.Join(path, pidFile)
+	if err := ioutil.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		unlockFile(f)
+		return nil, err
+	}
+	return func() {
+		os.Remove(pidPath)
+		unlockFile(f)
+	}, nil
+}
+
+// readPid reads the process ID of the pop running on the repo
+func readPid(path string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, pidFile))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachAttr starts the detached pop in a new session so it outlives the terminal
+func detachAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// openLockFile opens the file with an exclusive lock which is released when the process exits
+func openLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// terminate asks the process to shut down gracefully
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// processAlive returns whether a process with the given ID is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
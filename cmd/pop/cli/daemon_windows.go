@@ -0,0 +1,53 @@
+//go:build windows
+// +build windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachAttr starts the detached pop without a console window
+func detachAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{HideWindow: true}
+}
+
+// openLockFile opens the file without sharing it so no other process can open it until we exit
+func openLockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+func unlockFile(f *os.File) {
+	f.Close()
+}
+
+// terminate stops the process, Windows can't deliver a termination signal
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
+
+// processAlive returns whether a process with the given ID is running
+func processAlive(pid int) bool {
+	const processQueryLimitedInformation = 0x1000
+	const stillActive = 259
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
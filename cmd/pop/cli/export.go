@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var exportArgs struct {
+	output string
+	key    string
+}
+
+var exportCmd = &ffcli.Command{
+	Name:       "export",
+	ShortUsage: "export <cid> -o <car-path>",
+	ShortHelp:  "Write content stored by this pop to a CAR archive",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop export' command writes the DAG of a root stored by this pop to a CARv1 archive. The blocks are
+always written in the same order so exporting the same content twice produces identical archives. The
+archive can be used as a backup or handed to Filecoin storage deal tooling. Adding a key flag exports a
+single entry of the root.
+
+`),
+	Exec:    runExport,
+	FlagSet: exportFlags,
+}
+
+var exportFlags = (func() *flag.FlagSet {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&exportArgs.output, "o", "", "path of the CAR archive to write")
+	fs.StringVar(&exportArgs.key, "key", "", "only export the entry with this key")
+	return fs
+})()
+
+func runExport(ctx context.Context, args []string) error {
+	// Flags may follow the cid
+	if len(args) > 1 {
+		if err := exportFlags.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append(args[:1], exportFlags.Args()...)
+	}
+	if len(args) != 1 || exportArgs.output == "" {
+		return errors.New("usage: export <cid> -o <car-path>")
+	}
+	// The daemon may not run in the same directory
+	path, err := filepath.Abs(exportArgs.output)
+	if err != nil {
+		return err
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	erc := make(chan *node.ExportResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if er := n.ExportResult; er != nil {
+			erc <- er
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Export(&node.ExportArgs{
+		Cid:  args[0],
+		Key:  exportArgs.key,
+		Path: path,
+	})
+	select {
+	case er := <-erc:
+		if er.Err != "" {
+			return errors.New(er.Err)
+		}
+		fmt.Printf("==> Exported %s to %s\n", er.Size, er.Path)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
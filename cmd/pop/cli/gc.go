@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var gcArgs struct {
+	dryRun bool
+}
+
+var gcCmd = &ffcli.Command{
+	Name:       "gc",
+	ShortUsage: "gc [-dry-run]",
+	ShortHelp:  "Delete stored content no longer referenced by the index",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop gc' command deletes the stores which no indexed root points to such as content left behind
+by evictions which failed mid-way or transactions which were never committed. Content staged by
+transactions still in progress is kept. Use -dry-run to only report how much space would be reclaimed.
+
+`),
+	Exec: runGC,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("gc", flag.ExitOnError)
+		fs.BoolVar(&gcArgs.dryRun, "dry-run", false, "report the content to delete without deleting it")
+		return fs
+	})(),
+}
+
+func runGC(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	grc := make(chan *node.GCResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if gr := n.GCResult; gr != nil {
+			grc <- gr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.GC(&node.GCArgs{DryRun: gcArgs.dryRun})
+	select {
+	case gr := <-grc:
+		if gr.Err != "" {
+			return errors.New(gr.Err)
+		}
+		if gr.DryRun {
+			fmt.Printf("==> %d orphaned stores, %s would be reclaimed\n", gr.Stores, gr.Reclaimed)
+			return nil
+		}
+		fmt.Printf("==> Deleted %d orphaned stores, reclaimed %s\n", gr.Stores, gr.Reclaimed)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
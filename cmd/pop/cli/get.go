@@ -5,30 +5,42 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"path/filepath"
 	"strings"
 
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v2/ffcli"
 )
 
 var getArgs struct {
-	selector string
-	output   string
-	timeout  int
-	verbose  bool
-	miner    string
-	strategy string
+	selector     string
+	output       string
+	timeout      int
+	verbose      bool
+	miner        string
+	strategy     string
+	confirmAbove string
+	maxPrice     string
+	detach       bool
+	delegate     string
 }
 
 var getCmd = &ffcli.Command{
 	Name:       "get",
-	ShortUsage: "get <cid>",
+	ShortUsage: "get [-o <dest>] <cid|tag|domain|/ipns/name>[/<key>]",
 	ShortHelp:  "Retrieve content from the network",
 	LongHelp: strings.TrimSpace(`
 
-The 'pop get' command retrieves blocks with a given root cid and an optional selector
-(defaults retrieves all the linked blocks). Passing an output flag with a path will write the
-data to disk. Adding a miner flag will fallback to miner if content is not available on the secondary market.
+The 'pop get' command retrieves blocks with a given root cid, tag, IPNS name or domain with a DNSLink record
+and an optional selector (defaults retrieves all the linked blocks). Passing an output flag with a path will write the
+files and directories to disk, inside the path if it is an existing directory. Offers costing more
+than the max price are declined. Adding a miner flag will fallback to miner if content is not available
+on the secondary market. Passing a delegate flag asks a trusted pop to retrieve and pay for the content
+then send it back so the node doesn't need a funded wallet.
 
 `),
 	Exec: runGet,
@@ -36,20 +48,55 @@ data to disk. Adding a miner flag will fallback to miner if content is not avail
 		fs := flag.NewFlagSet("get", flag.ExitOnError)
 		fs.StringVar(&getArgs.selector, "selector", "all", "select blocks to retrieve for a root cid")
 		fs.StringVar(&getArgs.output, "output", "", "write the file to the path")
+		fs.StringVar(&getArgs.output, "o", "", "shorthand for -output")
 		fs.IntVar(&getArgs.timeout, "timeout", 60, "timeout before the request should be cancelled by the node (in minutes)")
 		fs.BoolVar(&getArgs.verbose, "verbose", false, "print the state transitions")
 		fs.StringVar(&getArgs.miner, "miner", "", "ask storage miner and use as fallback if network does not have the content")
-		fs.StringVar(&getArgs.strategy, "strategy", "SelectFirst", "strategy for selecting offers from providers")
+		fs.StringVar(&getArgs.strategy, "strategy", "SelectFirst", "strategy for selecting offers from providers (SelectFirst, SelectCheapest, SelectFirstLowerThan or SelectByReputation)")
+		fs.StringVar(&getArgs.confirmAbove, "confirm-above", "0.01", "ask for confirmation if the retrieval is expected to cost more than this amount of FIL")
+		fs.StringVar(&getArgs.maxPrice, "maxprice", "", "decline offers expected to cost more than this amount of FIL")
+		fs.BoolVar(&getArgs.detach, "detach", false, "keep retrieving in the daemon if the command is interrupted")
+		fs.StringVar(&getArgs.delegate, "delegate", "", "p2p address or peer ID of a trusted pop retrieving the content on our behalf")
 		return fs
 	})(),
 }
 
 func runGet(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get [-o <dest>] <cid|tag|domain|/ipns/name>[/<key>]")
+	}
+	// Fail before reaching the daemon if the path is invalid. Tags and names are resolved by the daemon.
+	if err := exchange.CheckPath(args[0]); err != nil {
+		return err
+	}
+	if getArgs.maxPrice != "" {
+		if _, err := filecoin.ParseFIL(getArgs.maxPrice); err != nil {
+			return err
+		}
+	}
+	if getArgs.delegate != "" {
+		if _, err := node.ParseDelegate(getArgs.delegate); err != nil {
+			return err
+		}
+	}
+	out := getArgs.output
+	if out != "" {
+		// The daemon may not run in the same directory
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			return err
+		}
+		out = abs
+	}
 	c, cc, ctx, cancel := connect(ctx)
 	defer cancel()
 
+	sf := &sessionFilter{op: "get"}
 	grc := make(chan *node.GetResult)
 	cc.SetNotifyCallback(func(n node.Notify) {
+		if !sf.accept(n) {
+			return
+		}
 		if gr := n.GetResult; gr != nil {
 			grc <- gr
 		}
@@ -57,45 +104,139 @@ func runGet(ctx context.Context, args []string) error {
 	go receive(ctx, cc, c)
 
 	cc.Get(&node.GetArgs{
-		Cid:      args[0],
-		Timeout:  getArgs.timeout,
-		Sel:      getArgs.selector,
-		Out:      getArgs.output,
-		Verbose:  getArgs.verbose,
-		Miner:    getArgs.miner,
-		Strategy: getArgs.strategy,
+		Cid:          args[0],
+		Timeout:      getArgs.timeout,
+		Sel:          getArgs.selector,
+		Out:          out,
+		Verbose:      getArgs.verbose,
+		Miner:        getArgs.miner,
+		Strategy:     getArgs.strategy,
+		ConfirmAbove: getArgs.confirmAbove,
+		MaxPrice:     getArgs.maxPrice,
+		Delegate:     getArgs.delegate,
 	})
 
 	for {
 		select {
 		case gr := <-grc:
-			if gr.Err != "" {
-				return errors.New(gr.Err)
-			}
-			if gr.DealID != "" && gr.TotalPrice == "0" {
-				fmt.Printf("==> Started free transfer\n")
-				continue
-			}
-			if gr.DealID != "" {
-				fmt.Printf("==> Started retrieval deal %s for a total of %s (%s/b)\n", gr.DealID, gr.TotalPrice, gr.PricePerByte)
-				continue
-			}
-			if gr.Local {
-				fmt.Printf("Blocks already in store\n")
-				return nil
+			done, err := handleGetResult(cc, args[0], gr)
+			if done || err != nil {
+				return err
 			}
+		case <-ctx.Done():
+			return sf.interrupted(cc, getArgs.detach, ctx.Err())
+		}
+	}
+}
 
-			fmt.Printf("==> Completed\n")
-			if gr.TotalPrice != "0" {
-				fmt.Printf("Routing: %fs, Transfer: %fs, Total: %fs\n", gr.DiscLatSeconds, gr.TransLatSeconds, gr.DiscLatSeconds+gr.TransLatSeconds)
-			}
+// handleGetResult prints the progress of a retrieval and returns true once it is completed
+func handleGetResult(cc *node.CommandClient, root string, gr *node.GetResult) (bool, error) {
+	if gr.Progress != nil {
+		printProgress(gr.Progress)
+		return false, nil
+	}
+	// Any other message is printed below the progress bar
+	endProgress()
+	if gr.Err != "" {
+		return true, exchange.ErrorFromCode(gr.Code, gr.Err)
+	}
+	if gr.NeedsConfirm {
+		accept := false
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf(
+				"Retrieving %s will cost up to %s (%s transfer + %s gas), continue?",
+				gr.PieceSize,
+				gr.EstimatedTotal,
+				gr.TotalPrice,
+				gr.GasPrice,
+			),
+		}
+		if err := survey.AskOne(prompt, &accept); err != nil {
+			accept = false
+		}
+		cc.Confirm(&node.ConfirmArgs{
+			Cid:    root,
+			Accept: accept,
+		})
+		return false, nil
+	}
+	if gr.DealID != "" && gr.TotalPrice == "0" {
+		fmt.Printf("==> Started free transfer\n")
+		return false, nil
+	}
+	if gr.DealID != "" {
+		fmt.Printf("==> Started retrieval deal %s for a total of %s (%s/b)\n", gr.DealID, gr.TotalPrice, gr.PricePerByte)
+		if gr.GasPrice != "0" {
+			fmt.Printf("==> Estimated total including gas: %s\n", gr.EstimatedTotal)
+		}
+		return false, nil
+	}
+	if gr.Local {
+		fmt.Printf("Blocks already in store\n")
+		return true, nil
+	}
 
-			if getArgs.output != "" {
-				fmt.Printf("==> Exported content to disk\n")
-			}
-			return nil
-		case <-ctx.Done():
-			return fmt.Errorf("Get operation timed out")
+	fmt.Printf("==> Completed\n")
+	if gr.TotalPrice != "0" {
+		fmt.Printf("Routing: %fs, Transfer: %fs, Total: %fs\n", gr.DiscLatSeconds, gr.TransLatSeconds, gr.DiscLatSeconds+gr.TransLatSeconds)
+	}
+
+	if getArgs.output != "" {
+		fmt.Printf("==> Exported content to disk\n")
+	}
+	return true, nil
+}
+
+// progressWidth is the number of characters in the progress bar
+const progressWidth = 24
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// progressLine is the state of the progress line rewritten at each update
+var progressLine struct {
+	frame int
+	width int
+}
+
+// printProgress overwrites the current line with a progress bar if the size of the content is known
+// or a spinner otherwise
+func printProgress(p *node.GetProgress) {
+	var b strings.Builder
+	if p.Size > 0 {
+		frac := float64(p.Received) / float64(p.Size)
+		if frac > 1 {
+			frac = 1
 		}
+		fill := int(frac * progressWidth)
+		fmt.Fprintf(&b, "[%s%s] %3.0f%% %s/%s",
+			strings.Repeat("=", fill),
+			strings.Repeat(" ", progressWidth-fill),
+			frac*100,
+			units.BytesSize(float64(p.Received)),
+			units.BytesSize(float64(p.Size)),
+		)
+	} else {
+		fmt.Fprintf(&b, "%s %s", spinnerFrames[progressLine.frame%len(spinnerFrames)], units.BytesSize(float64(p.Received)))
+		progressLine.frame++
+	}
+	fmt.Fprintf(&b, " | %d blocks | %d vouchers (%s)", p.Blocks, p.Vouchers, p.Spent)
+	if len(p.Provider) > 8 {
+		fmt.Fprintf(&b, " | from ...%s", p.Provider[len(p.Provider)-8:])
+	}
+	line := b.String()
+	// Pad with spaces to erase any longer previous line
+	pad := progressLine.width - len(line)
+	if pad < 0 {
+		pad = 0
+	}
+	fmt.Printf("\r%s%s", line, strings.Repeat(" ", pad))
+	progressLine.width = len(line)
+}
+
+// endProgress moves to a new line if a progress line was printed
+func endProgress() {
+	if progressLine.width > 0 {
+		fmt.Println()
+		progressLine.width = 0
 	}
 }
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var importCmd = &ffcli.Command{
+	Name:       "import",
+	ShortUsage: "import <car-path>",
+	ShortHelp:  "Import the DAGs of a CAR archive into an exchange transaction",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop import' command loads the blocks of a CARv1 or CARv2 archive exported by tools such as Lotus or IPFS
+into a pending or new storage transaction without re-chunking them. Each root of the archive is staged
+as an entry keyed by its cid.
+
+`),
+	Exec: runImport,
+}
+
+func runImport(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: import <car-path>")
+	}
+	// The daemon may not run in the same directory
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	irc := make(chan *node.ImportResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ir := n.ImportResult; ir != nil {
+			irc <- ir
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Import(&node.ImportArgs{Path: path})
+	select {
+	case ir := <-irc:
+		if ir.Err != "" {
+			return errors.New(ir.Err)
+		}
+		fmt.Printf("==> Imported %s in tx with root %s\n", ir.Size, ir.Root)
+		for _, r := range ir.Roots {
+			fmt.Printf("%s\n", r)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
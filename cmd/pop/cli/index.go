@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var indexCmd = &ffcli.Command{
+	Name:       "index",
+	ShortUsage: "index <subcommand>",
+	ShortHelp:  "Export, import and inspect the content index",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop index' commands write the index of this pop to a snapshot file and load snapshots created by
+other pops. Snapshots hold every indexed root with its read frequency but not the content. Importing a
+snapshot adds the roots found in the local stores to the index and adds the others to the interest list
+so the most popular content is retrieved when the pop refreshes its index. Use them to migrate a pop
+to new hardware or to seed a new pop with a warm index.
+
+With -demand they write and load demand snapshots instead. They only hold the interest list and the read
+frequency and daily reads of each root so a pop deployed in a new region or fleet can inherit the demand
+observed by another one without storing the same content. Imported frequencies can be scaled with -weight.
+
+'pop index inspect' opens the repo directly while the daemon isn't running. It prints statistics about the
+index, walks the HAMT to find the entries which can't be read and checks the content of every ref is in
+its store. With -dump it writes the refs it could read as JSON lines and with -repair it rebuilds the HAMT
+without the corrupt refs so a pop whose index fails to load can start again.
+
+`),
+	Subcommands: []*ffcli.Command{
+		indexExportCmd,
+		indexImportCmd,
+		indexInspectCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var indexArgs struct {
+	demand bool
+	weight float64
+}
+
+var indexExportCmd = &ffcli.Command{
+	Name:       "export",
+	ShortUsage: "index export [-demand] <path>",
+	ShortHelp:  "Write a snapshot of the index to a file",
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		fs.BoolVar(&indexArgs.demand, "demand", false, "only write the interest list and the popularity of the roots")
+		return fs
+	})(),
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: index export [-demand] <path>")
+		}
+		return runIndex(ctx, args[0], func(cc *node.CommandClient, path string) {
+			cc.IndexExport(&node.IndexExportArgs{Path: path, Demand: indexArgs.demand})
+		})
+	},
+}
+
+var indexImportCmd = &ffcli.Command{
+	Name:       "import",
+	ShortUsage: "index import [-demand [-weight <w>]] <path>",
+	ShortHelp:  "Load the roots from an index snapshot file",
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		fs.BoolVar(&indexArgs.demand, "demand", false, "load a demand snapshot")
+		fs.Float64Var(&indexArgs.weight, "weight", 1, "factor applied to the frequencies of a demand snapshot")
+		return fs
+	})(),
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: index import [-demand [-weight <w>]] <path>")
+		}
+		return runIndex(ctx, args[0], func(cc *node.CommandClient, path string) {
+			cc.IndexImport(&node.IndexImportArgs{
+				Path:   path,
+				Demand: indexArgs.demand,
+				Weight: indexArgs.weight,
+			})
+		})
+	},
+}
+
+var indexInspectArgs struct {
+	repo      string
+	publisher bool
+	dump      bool
+	repair    bool
+}
+
+var indexInspectCmd = &ffcli.Command{
+	Name:       "inspect",
+	ShortUsage: "index inspect [-repo <path>] [-publisher] [-dump] [-repair]",
+	ShortHelp:  "Check the index of a repo while the daemon isn't running",
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+		path, err := utils.FullPath(utils.RepoPath())
+		if err != nil {
+			path = ""
+		}
+		fs.StringVar(&indexInspectArgs.repo, "repo", path, "path of the repo")
+		fs.BoolVar(&indexInspectArgs.publisher, "publisher", false, "check the index of the separate publisher")
+		fs.BoolVar(&indexInspectArgs.dump, "dump", false, "write the refs as JSON lines")
+		fs.BoolVar(&indexInspectArgs.repair, "repair", false, "rebuild the HAMT without the corrupt refs and the refs whose content is missing")
+		return fs
+	})(),
+	Exec: runIndexInspect,
+}
+
+func runIndexInspect(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: index inspect [-repo <path>] [-publisher] [-dump] [-repair]")
+	}
+	check, err := node.CheckIndex(indexInspectArgs.repo, indexInspectArgs.publisher, indexInspectArgs.repair)
+	if err != nil {
+		return err
+	}
+	if indexInspectArgs.dump {
+		enc := json.NewEncoder(os.Stdout)
+		for _, ref := range check.Refs {
+			if err := enc.Encode(ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	root := "none"
+	if check.Root.Defined() {
+		root = check.Root.String()
+	}
+	fmt.Printf("==> Root %s\n", root)
+	fmt.Printf("==> %d refs (%s), %d roots in the interest list\n", len(check.Refs), units.BytesSize(float64(check.Size)), check.Interest)
+	fmt.Printf("==> %d stores, %d orphaned\n", check.Stores, len(check.OrphanedStores))
+	if check.Pending > 0 {
+		fmt.Printf("==> %d operations pending in the log\n", check.Pending)
+	}
+	if check.HAMTError != nil {
+		fmt.Printf("==> HAMT walk failed: %v\n", check.HAMTError)
+	}
+	if check.InterestError != nil {
+		fmt.Printf("==> Interest list unreadable: %v\n", check.InterestError)
+	}
+	for _, k := range check.Corrupt {
+		fmt.Printf("==> Corrupt entry %s\n", k)
+	}
+	for _, c := range check.MissingContent {
+		fmt.Printf("==> Missing content %s\n", c)
+	}
+	switch {
+	case check.OK():
+		fmt.Printf("==> Index OK\n")
+	case indexInspectArgs.repair:
+		fmt.Printf("==> Index repaired\n")
+	default:
+		fmt.Printf("==> Run with -repair to rebuild the index without the refs above\n")
+	}
+	return nil
+}
+
+func runIndex(ctx context.Context, path string, send func(*node.CommandClient, string)) error {
+	// The daemon may not run in the same directory
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	irc := make(chan *node.IndexResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ir := n.IndexResult; ir != nil {
+			irc <- ir
+		}
+	})
+	go receive(ctx, cc, c)
+
+	send(cc, path)
+	select {
+	case ir := <-irc:
+		if ir.Err != "" {
+			return errors.New(ir.Err)
+		}
+		fmt.Printf("==> %s: %d roots indexed, %d roots in the interest list\n", ir.Path, ir.Refs, ir.Interest)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
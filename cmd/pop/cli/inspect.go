@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var inspectCmd = &ffcli.Command{
+	Name:       "inspect",
+	ShortUsage: "inspect <cid>",
+	ShortHelp:  "Show the structure of a ref held in the cache",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop inspect' command prints the index metadata of a ref and walks its DAG in the local store to report
+the number of blocks and their total size, for the whole DAG and for each entry under the root.
+
+`),
+	Exec: runInspect,
+}
+
+func runInspect(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: inspect <cid>")
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	irc := make(chan *node.InspectResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ir := n.InspectResult; ir != nil {
+			irc <- ir
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Inspect(&node.InspectArgs{Cid: args[0]})
+	select {
+	case ir := <-irc:
+		if ir.Err != "" {
+			return errors.New(ir.Err)
+		}
+		fmt.Printf("==> Root %s\n", ir.Root)
+		fmt.Printf("==> Size %s (%s in %d blocks)\n", units.BytesSize(float64(ir.Size)), units.BytesSize(float64(ir.DAGSize)), ir.Blocks)
+		indexed := ""
+		if ir.Created > 0 {
+			indexed = fmt.Sprintf(", indexed %s ago", age(ir.Created))
+		}
+		fmt.Printf("==> Frequency %d, bucket %d, store %d%s\n", ir.Freq, ir.BucketID, ir.StoreID, indexed)
+		if ir.Publisher != "" {
+			fmt.Printf("==> Publisher %s\n", ir.Publisher)
+		}
+		if len(ir.Labels) > 0 {
+			fmt.Printf("==> Labels %s\n", formatLabels(ir.Labels))
+		}
+		if len(ir.Entries) == 0 {
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tCID\tSIZE\tBLOCKS")
+		for _, e := range ir.Entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", e.Key, e.Cid, units.BytesSize(float64(e.Size)), e.Blocks)
+		}
+		return w.Flush()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
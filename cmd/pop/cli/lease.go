@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var leaseCmd = &ffcli.Command{
+	Name:       "lease",
+	ShortUsage: "lease <subcommand>",
+	ShortHelp:  "Purchase and list storage leases",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop lease' commands manage storage leases. A lease pays a provider to keep a root from being evicted
+until the lease expires. Buying a lease for a root already under lease extends it.
+
+`),
+	Subcommands: []*ffcli.Command{
+		leaseBuyCmd,
+		leaseListCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var leaseBuyCmd = &ffcli.Command{
+	Name:       "buy",
+	ShortUsage: "lease buy <peer> <cid> <duration>",
+	ShortHelp:  "Pay a provider to keep content for a duration e.g. 72h",
+	Exec:       runLeaseBuy,
+}
+
+var leaseListCmd = &ffcli.Command{
+	Name:      "list",
+	ShortHelp: "List the content this pop stores under lease",
+	Exec:      runLeaseList,
+}
+
+func runLeaseBuy(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: lease buy <peer> <cid> <duration>")
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	lrc := make(chan *node.LeaseResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if lr := n.LeaseResult; lr != nil {
+			lrc <- lr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Lease(&node.LeaseArgs{
+		Peer:     args[0],
+		Cid:      args[1],
+		Duration: args[2],
+	})
+	select {
+	case lr := <-lrc:
+		if lr.Err != "" {
+			return exchange.ErrorFromCode(lr.Code, lr.Err)
+		}
+		fmt.Printf("==> Leased %s from %s until %s\n", lr.Root, lr.Peer, lr.Expiry)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runLeaseList(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	lrc := make(chan *node.LeaseResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if lr := n.LeaseResult; lr != nil {
+			lrc <- lr
+			if lr.Last || lr.Err != "" {
+				close(lrc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Leases(&node.LeasesArgs{})
+	for lr := range lrc {
+		if lr.Err != "" {
+			return exchange.ErrorFromCode(lr.Code, lr.Err)
+		}
+		fmt.Printf("==> %s %s until %s\n", lr.Root, filecoin.SizeStr(filecoin.NewInt(uint64(lr.Size))), lr.Expiry)
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package cli
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/myelnet/pop/filecoin"
@@ -11,19 +13,67 @@ import (
 	"github.com/peterbourgon/ff/v2/ffcli"
 )
 
+var listArgs struct {
+	filters labelFlags
+}
+
 var listCmd = &ffcli.Command{
-	Name:      "list",
-	ShortHelp: "List all content indexed in this pop",
+	Name:       "list",
+	ShortUsage: "list [-filter key=value]",
+	ShortHelp:  "List all content indexed in this pop",
 	LongHelp: strings.TrimSpace(`
 
 The 'pop list' command prints root CIDs for all the indexed content currently provided by this pop. Content is
-indexed by DAG root so usage frequencies is compiled by root too.
+indexed by DAG root so usage frequencies is compiled by root too. Labels set when committing are printed after
+each root and the list can be filtered to the content with given labels.
 
 `),
 	Exec: runList,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		fs.Var(&listArgs.filters, "filter", "only list content with the label formatted as key=value, can be repeated")
+		return fs
+	})(),
+}
+
+// labelFlags collects labels formatted as key=value from a repeated flag
+type labelFlags []string
+
+func (lf *labelFlags) String() string {
+	return strings.Join(*lf, ",")
+}
+
+func (lf *labelFlags) Set(v string) error {
+	*lf = append(*lf, v)
+	return nil
+}
+
+// formatLabels prints labels sorted by key
+func formatLabels(labels map[string]string) string {
+	kv := make([]string, 0, len(labels))
+	for k, v := range labels {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, " ")
 }
 
 func runList(ctx context.Context, args []string) error {
+	return listRefs(ctx, listArgs.filters, func(ref *node.ListResult) {
+		verified := ""
+		if ref.Verified {
+			verified = " (verified)"
+		}
+		labels := ""
+		if len(ref.Labels) > 0 {
+			labels = " [" + formatLabels(ref.Labels) + "]"
+		}
+		fmt.Printf("==> %s %s %d%s%s\n", ref.Root, filecoin.SizeStr(filecoin.NewInt(uint64(ref.Size))), ref.Freq, verified, labels)
+	})
+}
+
+// listRefs calls fn with each ref stored by the daemon with all the given labels
+func listRefs(ctx context.Context, filters []string, fn func(*node.ListResult)) error {
 	c, cc, ctx, cancel := connect(ctx)
 	defer cancel()
 
@@ -38,12 +88,12 @@ func runList(ctx context.Context, args []string) error {
 	})
 	go receive(ctx, cc, c)
 
-	cc.List(&node.ListArgs{})
+	cc.List(&node.ListArgs{Labels: filters})
 	for ref := range lrc {
 		if ref.Err != "" {
 			return errors.New(ref.Err)
 		}
-		fmt.Printf("==> %s %s %d\n", ref.Root, filecoin.SizeStr(filecoin.NewInt(uint64(ref.Size))), ref.Freq)
+		fn(ref)
 	}
 	return nil
 }
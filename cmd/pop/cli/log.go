@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var logCmd = &ffcli.Command{
+	Name:       "log",
+	ShortUsage: "log <subcommand>",
+	ShortHelp:  "Manage the logs of the running pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop log' commands change how much the subsystems of the running pop log without restarting it.
+The levels set when pop starts come from the -log-level flag of 'pop start'.
+
+`),
+	Subcommands: []*ffcli.Command{
+		logLevelCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var logLevelCmd = &ffcli.Command{
+	Name:       "level",
+	ShortUsage: "log level [<module> <level>]",
+	ShortHelp:  "Show or set the log level of the subsystems",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop log level' command prints the log level of every subsystem of the running pop or sets the level
+of a subsystem until pop restarts. The subsystems are:
+
+  index      the content index and its evictions
+  tx         the transactions dispatching and retrieving content
+  retrieval  the data transfers and retrieval deals
+  payments   the payment channels and vouchers
+  discovery  the peer exchange, the announcements and the DHT
+  all        every subsystem at once
+
+The levels from the most to the least verbose are trace, debug, info, warn, error and off.
+
+`),
+	Exec: runLogLevel,
+}
+
+func runLogLevel(ctx context.Context, args []string) error {
+	if len(args) != 0 && len(args) != 2 {
+		return errors.New("usage: log level [<module> <level>]")
+	}
+	largs := &node.LogArgs{}
+	if len(args) == 2 {
+		largs.Module, largs.Level = args[0], args[1]
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	lrc := make(chan *node.LogResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if lr := n.LogResult; lr != nil {
+			lrc <- lr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Log(largs)
+	select {
+	case lr := <-lrc:
+		if lr.Err != "" {
+			return errors.New(lr.Err)
+		}
+		modules := make([]string, 0, len(lr.Levels))
+		for m := range lr.Levels {
+			modules = append(modules, m)
+		}
+		sort.Strings(modules)
+		for _, m := range modules {
+			fmt.Printf("%s\t%s\n", m, lr.Levels[m])
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
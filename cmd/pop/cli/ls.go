@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var lsArgs struct {
+	filters labelFlags
+}
+
+var lsCmd = &ffcli.Command{
+	Name:       "ls",
+	ShortUsage: "ls [-filter key=value]",
+	ShortHelp:  "Print a table of the refs held in the cache",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop ls' command prints every ref indexed by this pop with its size, read frequency, LFU bucket,
+store ID and the time since it was first indexed. Use 'pop inspect <cid>' to see the entries of a ref.
+
+`),
+	Exec: runLs,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("ls", flag.ExitOnError)
+		fs.Var(&lsArgs.filters, "filter", "only list content with the label formatted as key=value, can be repeated")
+		return fs
+	})(),
+}
+
+func runLs(ctx context.Context, args []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOT\tSIZE\tFREQ\tBUCKET\tSTORE\tAGE\tLABELS")
+	err := listRefs(ctx, lsArgs.filters, func(ref *node.ListResult) {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			ref.Root, units.BytesSize(float64(ref.Size)), ref.Freq, ref.BucketID, ref.StoreID, age(ref.Created), formatLabels(ref.Labels))
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// age formats the time elapsed since a unix time, refs indexed before it was recorded have no age
+func age(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return units.HumanDuration(time.Since(time.Unix(unix, 0)))
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var peersCmd = &ffcli.Command{
+	Name:      "peers",
+	ShortHelp: "List the state and reputation of the peers this pop knows",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop peers' command prints the pops this pop shares a region with, best first, with their latency, the
+pop protocols they support and how many of our greetings, queries and requests they answered. Peers failing
+to answer several requests in a row are pruned and the most responsive ones are dispatched to first. The
+providers this pop retrieved content from outside of its regions are listed after.
+
+Each peer also shows its reputation as a provider, based on the ratio of successful retrievals and penalized
+by payment disputes. Use the SelectByReputation strategy with 'pop get' to prefer providers with the best
+reputation.
+
+`),
+	Exec: runPeers,
+}
+
+func runPeers(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PeersResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PeersResult; pr != nil {
+			prc <- pr
+			if pr.Last || pr.Err != "" {
+				close(prc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Peers(&node.PeersArgs{})
+	for p := range prc {
+		if p.Err != "" {
+			return errors.New(p.Err)
+		}
+		fmt.Printf("==> %s %.2f %d/%d successes %d disputes %s\n", p.ID, p.Score, p.Successes, p.Successes+p.Failures, p.Disputes, p.Throughput)
+		if p.Tracked {
+			printPeerState(p)
+		}
+	}
+	return nil
+}
+
+func printPeerState(p *node.PeersResult) {
+	latency := "unknown"
+	if p.LatencySeconds > 0 {
+		latency = seconds(p.LatencySeconds).String()
+	}
+	lastSeen := "never"
+	if !p.LastSeen.IsZero() {
+		lastSeen = p.LastSeen.Format("2006-01-02 15:04:05")
+	}
+	fmt.Printf("\tregions %s latency %s\n", strings.Join(p.Regions, ","), latency)
+	fmt.Printf("\tanswered %d/%d (%.2f) strikes %d last seen %s\n", p.Responses, p.Responses+p.Unanswered, p.Responsiveness, p.Strikes, lastSeen)
+	if len(p.Protocols) > 0 {
+		fmt.Printf("\tprotocols %s\n", strings.Join(p.Protocols, " "))
+	}
+}
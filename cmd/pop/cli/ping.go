@@ -61,6 +61,12 @@ Peers          %s
 Latency (s)    %f
 Version        %s
 		`, pr.ID, pr.Addrs, pr.Peers, pr.LatencySeconds, pr.Version)
+		if pr.PublisherID != "" {
+			fmt.Printf(`
+Publisher      %s
+Publisher addr %s
+		`, pr.PublisherID, pr.PublisherAddress)
+		}
 
 	case <-ctx.Done():
 		return ctx.Err()
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var probesArgs struct {
+	run bool
+}
+
+var probesCmd = &ffcli.Command{
+	Name:       "probes",
+	ShortUsage: "probes [-run]",
+	ShortHelp:  "Show the health of the network measured by retrieving canaries",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop probes' command prints the service level indicators of the network measured by the prober: the
+success rate, time to first byte and throughput of the recent retrievals of small canary objects from random
+providers, followed by the last probes. Canaries are set with the -probe-canaries flag of 'pop start' and
+the same indicators are exposed as metrics when the metrics server is enabled.
+
+`),
+	Exec: runProbes,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("probes", flag.ExitOnError)
+		fs.BoolVar(&probesArgs.run, "run", false, "probe a canary right away before reporting")
+		return fs
+	})(),
+}
+
+func runProbes(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.ProbesResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.ProbesResult; pr != nil {
+			prc <- pr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Probes(&node.ProbesArgs{Run: probesArgs.run})
+	select {
+	case pr := <-prc:
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		printProbes(pr)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func printProbes(pr *node.ProbesResult) {
+	if pr.Probes == 0 {
+		fmt.Printf("==> No probe yet\n")
+		return
+	}
+	fmt.Printf("==> %d probes, %.0f%% success\n", pr.Probes, pr.SuccessRate*100)
+	fmt.Printf("TTFB p50 %s p90 %s\n", seconds(pr.TTFB50Seconds), seconds(pr.TTFB90Seconds))
+	fmt.Printf("Throughput %s/s\n", filecoin.SizeStr(filecoin.NewInt(uint64(pr.Throughput))))
+	fmt.Printf("\nTime\t\t\tProvider\tTTFB\tThroughput\n")
+	for _, s := range pr.Recent {
+		if s.Err != "" {
+			fmt.Printf("%s\t%s\t%s\n", s.Time.Format("2006-01-02 15:04:05"), s.Provider, s.Err)
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s/s\n", s.Time.Format("2006-01-02 15:04:05"), s.Provider, seconds(s.TTFBSeconds), filecoin.SizeStr(filecoin.NewInt(uint64(s.Throughput))))
+	}
+}
+
+// seconds formats a number of seconds as a rounded duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var publishersCmd = &ffcli.Command{
+	Name:      "publishers",
+	ShortHelp: "List how much of the cache is used by each publisher",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop publishers' command prints the publishers who dispatched content to this pop with the number of roots
+and the size they use, largest first. No publisher can use more than a share of the capacity so one prolific
+publisher can't monopolize the cache. Once a publisher goes over its share its least used content is evicted first.
+
+`),
+	Exec: runPublishers,
+}
+
+func runPublishers(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	prc := make(chan *node.PublishersResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if pr := n.PublishersResult; pr != nil {
+			prc <- pr
+			if pr.Last || pr.Err != "" {
+				close(prc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Publishers(&node.PublishersArgs{})
+	for p := range prc {
+		if p.Err != "" {
+			return errors.New(p.Err)
+		}
+		fmt.Printf("==> %s %d roots %s (%.0f%% of share)\n", p.ID, p.Refs, p.Size, p.Share*100)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var pushCmd = &ffcli.Command{
+	Name:       "push",
+	ShortUsage: "push [flags] <file>...",
+	ShortHelp:  "Commit only some of the staged files",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop push' command commits the given files of the ongoing transaction like 'pop commit' with the same flags.
+Files are named as they were put. The other staged files are left in the transaction for a later push or commit
+and can be amended by putting them again or removed with 'pop unstage'.
+
+`),
+	Exec:    runPush,
+	FlagSet: commitFlagSet("push"),
+}
+
+func runPush(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	keys := make([]string, len(args))
+	for i, a := range args {
+		keys[i] = exchange.FileKey(a).String()
+	}
+	return commit(ctx, "", keys)
+}
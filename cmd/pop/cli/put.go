@@ -12,23 +12,41 @@ import (
 )
 
 var putArgs struct {
-	chunkSize int
+	chunkSize  int
+	recursive  bool
+	include    string
+	exclude    string
+	chunker    string
+	layout     string
+	rawLeaves  bool
+	cidVersion int
+	hash       string
 }
 
 var putCmd = &ffcli.Command{
 	Name:       "put",
-	ShortUsage: "put <file-path>",
+	ShortUsage: "put <file-path|dir-path>",
 	ShortHelp:  "Put a file into an exchange transaction for storage",
 	LongHelp: strings.TrimSpace(`
 
 The 'pop put' command opens a given file, chunks it, links it as an ipld DAG and 
 stores the blocks in the block store. The DAG is then staged in a pending or new storage transaction.
+Directories can be added with the recursive flag, their hierarchy is preserved as nested UnixFS directories.
+Use -chunker ipfs to chunk and link files like ipfs add does so CIDs match the content pinned with go-ipfs.
 
 `),
 	Exec: runPut,
 	FlagSet: (func() *flag.FlagSet {
 		fs := flag.NewFlagSet("put", flag.ExitOnError)
 		fs.IntVar(&putArgs.chunkSize, "chunk-size", 1024, "chunk size in bytes")
+		fs.BoolVar(&putArgs.recursive, "recursive", false, "add a directory and all its content")
+		fs.StringVar(&putArgs.include, "include", "", "only add files matching these glob patterns separated by commas")
+		fs.StringVar(&putArgs.exclude, "exclude", "", "skip files matching these glob patterns separated by commas")
+		fs.StringVar(&putArgs.chunker, "chunker", "", "size-<bytes>, rabin, rabin-<min>-<avg>-<max>, buzhash or ipfs for the ipfs add defaults")
+		fs.StringVar(&putArgs.layout, "layout", "", "DAG layout: balanced or trickle")
+		fs.BoolVar(&putArgs.rawLeaves, "raw-leaves", true, "store file data in raw leaves")
+		fs.IntVar(&putArgs.cidVersion, "cid-version", 1, "CID version, CIDv0 uses sha2-256")
+		fs.StringVar(&putArgs.hash, "hash", "", "hash function of CIDv1 blocks: sha2-256 or blake2b-256")
 		return fs
 	})(),
 }
@@ -45,19 +63,46 @@ func runPut(ctx context.Context, args []string) error {
 	})
 	go receive(ctx, cc, c)
 
-	cc.Put(&node.PutArgs{
+	var include, exclude []string
+	if putArgs.include != "" {
+		include = strings.Split(putArgs.include, ",")
+	}
+	if putArgs.exclude != "" {
+		exclude = strings.Split(putArgs.exclude, ",")
+	}
+
+	pargs := &node.PutArgs{
 		Path:      args[0],
 		ChunkSize: putArgs.chunkSize,
-	})
-	select {
-	case pr := <-prc:
-		if pr.Err != "" {
-			return errors.New(pr.Err)
+		Recursive: putArgs.recursive,
+		Include:   include,
+		Exclude:   exclude,
+		Chunker:   putArgs.chunker,
+		Layout:    putArgs.layout,
+		Hash:      putArgs.hash,
+	}
+	// The ipfs chunker sets its own leaves and CID version
+	if putArgs.chunker != "ipfs" {
+		pargs.RawLeaves = &putArgs.rawLeaves
+		pargs.CidVersion = &putArgs.cidVersion
+	}
+	cc.Put(pargs)
+	for {
+		select {
+		case pr := <-prc:
+			if pr.Err != "" {
+				return errors.New(pr.Err)
+			}
+			// Progress for a single file in a directory
+			if pr.Root == "" {
+				fmt.Printf("%s  %s  %s\n", pr.Key, pr.Cid, pr.Size)
+				continue
+			}
+			fmt.Printf("==> Put new file in tx with root %s\n", pr.Root)
+			fmt.Printf("%s  %s  %s  %d blk\n", args[0], pr.Cid, pr.Size, pr.NumBlocks)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		fmt.Printf("==> Put new file in tx with root %s\n", pr.Root)
-		fmt.Printf("%s  %s  %s  %d blk\n", args[0], pr.Cid, pr.Size, pr.NumBlocks)
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
 	}
 }
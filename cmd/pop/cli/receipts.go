@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var receiptsArgs struct {
+	provider string
+	root     string
+	since    time.Duration
+	limit    int
+	export   string
+	check    string
+}
+
+var receiptsCmd = &ffcli.Command{
+	Name:       "receipts",
+	ShortUsage: "receipts [-provider <id>] [-root <cid>] [-since <duration>] [-export <path>] | receipts -check <path>",
+	ShortHelp:  "Query the receipts of the retrievals paid by this pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop receipts' command prints a receipt for every paid retrieval with the provider, root, bytes
+received, price and the vouchers sent. Receipts are signed with the peer ID of this pop so they can be
+exported with -export and reconciled against the invoices of a provider. Anyone can check the signatures
+of an export with -check, which doesn't need a running pop.
+
+`),
+	Exec: runReceipts,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("receipts", flag.ExitOnError)
+		fs.StringVar(&receiptsArgs.provider, "provider", "", "only print the retrievals from this provider")
+		fs.StringVar(&receiptsArgs.root, "root", "", "only print the retrievals of this root")
+		fs.DurationVar(&receiptsArgs.since, "since", 0, "only print the retrievals which completed within this duration")
+		fs.IntVar(&receiptsArgs.limit, "limit", 100, "maximum number of recent receipts to print")
+		fs.StringVar(&receiptsArgs.export, "export", "", "write the matching receipts as JSON lines to the path")
+		fs.StringVar(&receiptsArgs.check, "check", "", "check the signatures of exported receipts")
+		return fs
+	})(),
+}
+
+func runReceipts(ctx context.Context, args []string) error {
+	if receiptsArgs.check != "" {
+		f, err := os.Open(receiptsArgs.check)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := exchange.VerifyReceipts(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("==> Verified %d receipts\n", n)
+		return nil
+	}
+	rargs := &node.ReceiptsArgs{
+		Provider: receiptsArgs.provider,
+		Root:     receiptsArgs.root,
+		Since:    receiptsArgs.since,
+		Limit:    receiptsArgs.limit,
+	}
+	if receiptsArgs.export != "" {
+		// The daemon may not run in the same directory
+		path, err := filepath.Abs(receiptsArgs.export)
+		if err != nil {
+			return err
+		}
+		rargs.Export = path
+		// Exports include every matching receipt
+		rargs.Limit = 0
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	rrc := make(chan *node.ReceiptsResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if rr := n.ReceiptsResult; rr != nil {
+			rrc <- rr
+			if rr.Last || rr.Err != "" {
+				close(rrc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Receipts(rargs)
+	for rr := range rrc {
+		if rr.Err != "" {
+			return errors.New(rr.Err)
+		}
+		if rargs.Export != "" {
+			fmt.Printf("==> Exported %d receipts to %s\n", rr.Len, rr.Path)
+			continue
+		}
+		fmt.Printf("==> %s deal %d from %s\n", rr.Time.Format(time.RFC3339), rr.Deal, rr.Provider)
+		fmt.Printf("    %s received of %s, paid %s in %d vouchers\n", rr.Size, rr.Root, rr.Paid, rr.Vouchers)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var recordArgs struct {
+	window time.Duration
+	stop   bool
+}
+
+var recordCmd = &ffcli.Command{
+	Name:       "record",
+	ShortUsage: "record [-window <duration>] <path> | record -stop",
+	ShortHelp:  "Record the protocol messages and decisions of the pop to a log file",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop record' command captures the queries, offers, greetings, data transfers, retrieval deal
+transitions and index changes of the running pop into a log file for a time window. The log starts
+with a snapshot of the index so 'pop replay' can reconstruct why content was cached or evicted.
+Recording is off unless started with this command.
+
+`),
+	Exec: runRecord,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("record", flag.ExitOnError)
+		fs.DurationVar(&recordArgs.window, "window", exchange.DefaultRecordWindow, "duration of the recording")
+		fs.BoolVar(&recordArgs.stop, "stop", false, "stop the running recording")
+		return fs
+	})(),
+}
+
+func runRecord(ctx context.Context, args []string) error {
+	rargs := &node.RecordArgs{Window: recordArgs.window, Stop: recordArgs.stop}
+	if !rargs.Stop {
+		if len(args) != 1 {
+			return errors.New("usage: record [-window <duration>] <path>")
+		}
+		// The daemon may not run in the same directory
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		rargs.Path = path
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	rrc := make(chan *node.RecordResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if rr := n.RecordResult; rr != nil {
+			rrc <- rr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Record(rargs)
+	select {
+	case rr := <-rrc:
+		if rr.Err != "" {
+			return errors.New(rr.Err)
+		}
+		if rargs.Stop {
+			fmt.Printf("==> Recording stopped\n")
+			return nil
+		}
+		fmt.Printf("==> Recording to %s until %s\n", rr.Path, rr.Until.Format(time.Kitchen))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
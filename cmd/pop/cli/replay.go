@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/exchange"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var replayCmd = &ffcli.Command{
+	Name:       "replay",
+	ShortUsage: "replay <path>",
+	ShortHelp:  "Reconstruct the decisions of a pop from a recorded log",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop replay' command reads a log written by 'pop record' and prints the decisions of the pop in
+order: queries answered, offers received, transfers, deals and the content cached or evicted. The
+index is rebuilt from the snapshot at the start of the log so evictions which kept less frequently
+used content are pointed out. It doesn't need a running pop.
+
+`),
+	Exec: runReplay,
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: replay <path>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	events, err := exchange.ReadEvents(f)
+	if err != nil {
+		return err
+	}
+	rep, err := exchange.Replay(events)
+	if err != nil {
+		return err
+	}
+	for _, d := range rep.Decisions {
+		fmt.Printf("#%d %s %s %s\n", d.Seq, d.Time.Format("15:04:05.000"), d.Kind, d.Summary)
+		if d.Note != "" {
+			fmt.Printf("    (%s)\n", d.Note)
+		}
+	}
+	fmt.Printf("==> %d events replayed, %d refs indexed for %s of %s\n", len(events), rep.Refs, units.BytesSize(float64(rep.Size)), units.BytesSize(float64(rep.Upper)))
+	return nil
+}
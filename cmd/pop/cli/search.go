@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var searchCmd = &ffcli.Command{
+	Name:       "search",
+	ShortUsage: "search <query>",
+	ShortHelp:  "Find content by entry name or publisher",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop search' command prints the roots stored by this pop or advertised by its peers with entry names or
+publisher IDs matching every word of the query. Words match any name starting with them so 'pop search vid'
+finds 'video.mp4'. Roots which aren't stored locally can be retrieved with 'pop get'.
+
+`),
+	Exec: runSearch,
+}
+
+func runSearch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing search query")
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.SearchResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.SearchResult; sr != nil {
+			src <- sr
+			if sr.Last || sr.Err != "" {
+				close(src)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Search(&node.SearchArgs{Query: strings.Join(args, " ")})
+	for res := range src {
+		if res.Err != "" {
+			return errors.New(res.Err)
+		}
+		location := "remote"
+		if res.Cached {
+			location = "local"
+		}
+		fmt.Printf("==> %s %s %s\n", res.Root, filecoin.SizeStr(filecoin.NewInt(uint64(res.Size))), location)
+		for _, k := range res.Keys {
+			fmt.Printf("    %s\n", k)
+		}
+	}
+	return nil
+}
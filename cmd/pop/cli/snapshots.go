@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var snapshotsArgs struct {
+	run string
+}
+
+var snapshotsCmd = &ffcli.Command{
+	Name:       "snapshots",
+	ShortUsage: "snapshots [-run <collection>]",
+	ShortHelp:  "Show the collections published as periodic snapshots",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop snapshots' command prints the collections this pop exports on a schedule with the latest snapshot
+of each and the number of snapshots still stored. Collections are set with the -snapshots flag of 'pop start'
+and each snapshot is committed as a new root labeled with its collection. The tag named after the collection
+always points to the latest snapshot so 'pop get <collection>' retrieves it, and older snapshots expire by
+the keep and max-age policy of the collection.
+
+`),
+	Exec: runSnapshots,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("snapshots", flag.ExitOnError)
+		fs.StringVar(&snapshotsArgs.run, "run", "", "export and publish a snapshot of the collection right away")
+		return fs
+	})(),
+}
+
+func runSnapshots(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.SnapshotsResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.SnapshotsResult; sr != nil {
+			src <- sr
+			if sr.Last || sr.Err != "" {
+				close(src)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Snapshots(&node.SnapshotsArgs{Run: snapshotsArgs.run})
+	for sr := range src {
+		if sr.Err != "" {
+			return errors.New(sr.Err)
+		}
+		if sr.Root == "" {
+			fmt.Printf("%s\tno snapshot yet\n", sr.Collection)
+		} else {
+			fmt.Printf("%s\t%s\t%s\t%d snapshots\n", sr.Collection, sr.Root, sr.Time.Format(time.RFC3339), sr.Snapshots)
+		}
+		if sr.LastError != "" {
+			fmt.Printf("\tlast export failed: %s\n", sr.LastError)
+		}
+	}
+	return nil
+}
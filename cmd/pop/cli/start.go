@@ -11,10 +11,15 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/docker/go-units"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/node"
 	"github.com/peterbourgon/ff/v2"
 	"github.com/peterbourgon/ff/v2/ffcli"
@@ -24,9 +29,64 @@ import (
 // PopConfig is the json config object we generate with the init command
 type PopConfig struct {
 	temp        bool
+	detach      bool
 	privKeyPath string
 	regions     string
+	shards      int
+	private     bool
 	capacity    string
+	pubShare    float64
+	catQuotas   string
+	catLabel    string
+	maxRefs     int
+	maxBuckets  int
+	fastStart   bool
+	gateway     string
+	transforms  string
+	tenants     string
+	quotaPeriod time.Duration
+	gatewayPaid bool
+	variants    bool
+	metrics     string
+	grpc        string
+	admin       string
+	maxMemory   string
+	upLimit     string
+	downLimit   string
+	peerUp      string
+	peerDown    string
+	maxRoutines int
+	leasePrice  string
+	redeemAt    string
+	pricing     map[string]*string
+	aclDefault  string
+	aclAllow    string
+	aclDeny     string
+	aclOps      string
+	contracts   string
+	maxRestarts uint
+	transfer    exchange.TransferPolicy
+	stallTime   time.Duration
+	stallTries  int
+	slowBlock   time.Duration
+	standbyOf   string
+	standbys    string
+	delegators  string
+	canaries    string
+	minPeers    int
+	probeEvery  time.Duration
+	dnsServers  string
+	miners      string
+	bitswap     bool
+	dht         bool
+	separatePub bool
+	snapshots   string
+	autoRelay   bool
+	relays      string
+	listen      string
+	announce    string
+	logLevel    string
+	logJSON     bool
 	// Exported fields can be set by survey.Ask
 	Bootstrap    string `json:"bootstrap"`
 	FilEndpoint  string `json:"fil-endpoint"`
@@ -48,13 +108,76 @@ The 'pop start' command starts a pop daemon service.
 	FlagSet: (func() *flag.FlagSet {
 		fs := flag.NewFlagSet("start", flag.ExitOnError)
 		fs.BoolVar(&startArgs.temp, "temp-repo", false, "create a temporary repo for debugging")
-		fs.StringVar(&startArgs.Bootstrap, "bootstrap", "", "bootstrap peer to discover others")
+		fs.BoolVar(&startArgs.detach, "detach", false, "run pop in the background with its output written to pop.log in the repo, stop it with pop stop")
+		fs.StringVar(&startArgs.Bootstrap, "bootstrap", "", "p2p addresses of the bootstrap pops to discover others separated by commas")
+		fs.IntVar(&startArgs.minPeers, "min-peers", exchange.DefaultMinPeers, "number of pop peers in our regions below which we bootstrap again")
+		fs.StringVar(&startArgs.listen, "listen", "", "multiaddresses the libp2p host listens on separated by commas, add a WebSocket address such as /ip4/0.0.0.0/tcp/41505/ws for browsers")
+		fs.StringVar(&startArgs.announce, "announce", "", "multiaddresses advertised to other peers instead of the listen addresses separated by commas e.g. /ip4/<public ip>/tcp/41504")
+		fs.BoolVar(&startArgs.autoRelay, "auto-relay", false, "let peers reach this pop through a circuit relay v1 when AutoNAT detects it is behind a NAT")
+		fs.StringVar(&startArgs.relays, "relays", "", "p2p addresses of the static relays used by -auto-relay separated by commas, relays are discovered in the DHT if empty")
 		fs.StringVar(&startArgs.FilEndpoint, "fil-endpoint", "", "endpoint to reach a filecoin api")
 		fs.StringVar(&startArgs.FilToken, "fil-token", "", "token to authorize filecoin api access")
 		fs.StringVar(&startArgs.FilTokenType, "fil-token-type", "Bearer", "auth token type")
 		fs.StringVar(&startArgs.privKeyPath, "privkey", "", "path to private key to use by default")
 		fs.StringVar(&startArgs.regions, "regions", "", "provider regions separated by commas")
-		fs.StringVar(&startArgs.capacity, "capacity", "10GB", "storage space allocated for the node")
+		fs.IntVar(&startArgs.shards, "topic-shards", 0, "number of gossip topics the queries of each region are spread over, every pop of a region must use the same number")
+		fs.BoolVar(&startArgs.private, "private-queries", false, "publish a salted hash of the roots we query so gossip listeners can't read what we retrieve")
+		fs.StringVar(&startArgs.capacity, "capacity", node.DefaultCapacity, "storage space allocated for the node")
+		fs.Float64Var(&startArgs.pubShare, "publisher-share", node.DefaultPublisherShare, "fraction of the capacity the content of a single publisher can use")
+		fs.StringVar(&startArgs.catQuotas, "category-quotas", "", "fractions of the capacity each category of content can use e.g. video=60%,software=30%,other=10%")
+		fs.StringVar(&startArgs.catLabel, "category-label", exchange.DefaultCategoryLabel, "label holding the category of content")
+		fs.IntVar(&startArgs.maxRefs, "max-refs", 0, "number of refs above which the least used content is evicted regardless of the capacity, 0 is unbounded")
+		fs.IntVar(&startArgs.maxBuckets, "max-index-buckets", 0, "length of the index frequency list above which the least used content is evicted, 0 is unbounded")
+		fs.BoolVar(&startArgs.fastStart, "fast-start", false, "load the index lazily and validate it in the background")
+		fs.StringVar(&startArgs.gateway, "gateway", "", "address to serve cached content over HTTP e.g. :8080")
+		fs.StringVar(&startArgs.transforms, "gateway-transforms", "gzip", "transforms applied to content served by the gateway separated by commas (gzip, preview)")
+		fs.StringVar(&startArgs.tenants, "gateway-tenants", "", "JSON file listing the tenants allowed to use the gateway with their token, rate and quota")
+		fs.DurationVar(&startArgs.quotaPeriod, "gateway-quota-period", node.DefaultQuotaPeriod, "period after which the bandwidth used by gateway tenants is reset")
+		fs.BoolVar(&startArgs.gatewayPaid, "gateway-payments", false, "require light clients to pay for the content served by the gateway with payment channel vouchers")
+		fs.BoolVar(&startArgs.variants, "cache-variants", false, "cache the content converted by gateway transforms")
+		fs.StringVar(&startArgs.metrics, "metrics", "", "address to expose prometheus metrics e.g. :9090")
+		fs.StringVar(&startArgs.grpc, "grpc", "", "address to serve the gRPC API e.g. :9091, calls need the token given by pop token")
+		fs.StringVar(&startArgs.admin, "admin", "", "address to serve the REST admin API e.g. :9092, requests need the token given by pop token")
+		fs.StringVar(&startArgs.maxMemory, "max-memory", "", "memory usage above which new transfers are rejected e.g. 512MB")
+		fs.IntVar(&startArgs.maxRoutines, "max-goroutines", 0, "number of goroutines above which new transfers are rejected")
+		fs.StringVar(&startArgs.upLimit, "upload-limit", "", "bytes per second sent to all the peers e.g. 10MB, unlimited if empty")
+		fs.StringVar(&startArgs.downLimit, "download-limit", "", "bytes per second received from all the peers e.g. 10MB, unlimited if empty")
+		fs.StringVar(&startArgs.peerUp, "peer-upload-limit", "", "bytes per second sent to each peer e.g. 1MB, unlimited if empty")
+		fs.StringVar(&startArgs.peerDown, "peer-download-limit", "", "bytes per second received from each peer e.g. 1MB, unlimited if empty")
+		fs.StringVar(&startArgs.leasePrice, "lease-price", "", "price per GiB per hour to sell storage leases e.g. 0.0001FIL, leases aren't sold if empty")
+		startArgs.pricing = map[string]*string{
+			"pricing.price":               fs.String("pricing.price", "", "price per GiB retrieved from this pop e.g. 0.0001FIL, defaults to the price of the region"),
+			"pricing.free-below":          fs.String("pricing.free-below", "", "size under which content is served for free e.g. 1MB"),
+			"pricing.free-peers":          fs.String("pricing.free-peers", "", "peer IDs retrieving for free separated by commas"),
+			"pricing.max-load":            fs.String("pricing.max-load", "", "number of retrievals served at the same time at which the price reaches the max load multiplier"),
+			"pricing.max-load-multiplier": fs.String("pricing.max-load-multiplier", "", "factor applied to the price at max load e.g. 2"),
+		}
+		fs.StringVar(&startArgs.aclDefault, "acl-default", "public", "visibility of the refs without access rules, public or private")
+		fs.StringVar(&startArgs.aclAllow, "acl-allow", "", "peer IDs allowed to retrieve public refs separated by commas, any peer if empty")
+		fs.StringVar(&startArgs.aclDeny, "acl-deny", "", "peer IDs never served any ref separated by commas")
+		fs.StringVar(&startArgs.aclOps, "acl-operators", "", "peer IDs run by the operator of this pop bypassing the access rules separated by commas")
+		fs.StringVar(&startArgs.redeemAt, "redeem-threshold", "", "value of the vouchers received on a payment channel after which they are redeemed before the channel settles e.g. 0.01FIL")
+		fs.StringVar(&startArgs.contracts, "contracts", "", "JSON file listing the contracted providers tried first for dispatch and retrieval with their agreed price")
+		fs.UintVar(&startArgs.maxRestarts, "transfer-max-restarts", 0, "number of consecutive restarts before failing a data transfer")
+		fs.DurationVar(&startArgs.transfer.RestartBackoff, "transfer-restart-backoff", 0, "minimum time between two restarts of a data transfer")
+		fs.DurationVar(&startArgs.transfer.AcceptTimeout, "transfer-accept-timeout", 0, "time to wait for a peer to accept a data transfer before restarting it")
+		fs.DurationVar(&startArgs.transfer.CompleteTimeout, "transfer-complete-timeout", 0, "time to wait for a peer to complete a data transfer once all the data was sent")
+		fs.DurationVar(&startArgs.stallTime, "stall-timeout", exchange.DefaultStallTimeout, "time a retrieval may go without any update before its data transfer is restarted, negative disables the watchdog")
+		fs.IntVar(&startArgs.stallTries, "stall-restarts", exchange.DefaultStallRestarts, "number of restarts of a stalled retrieval before trying another provider, negative tries another provider right away")
+		fs.DurationVar(&startArgs.slowBlock, "slow-block", exchange.DefaultSlowBlockThreshold, "block reads slower than this are logged with their CID and store ID, negative disables the logs")
+		fs.StringVar(&startArgs.standbyOf, "standby-of", "", "p2p address of a primary pop to keep a warm copy of e.g. /ip4/1.2.3.4/tcp/41504/p2p/<id>")
+		fs.StringVar(&startArgs.standbys, "standbys", "", "peer IDs allowed to replicate the state of this pop separated by commas")
+		fs.StringVar(&startArgs.delegators, "delegators", "", "peer IDs of the light clients allowed to make this pop retrieve and pay for content on their behalf separated by commas")
+		fs.StringVar(&startArgs.canaries, "probe-canaries", "", "CIDs of small canary objects retrieved from random providers to measure the network health separated by commas, nothing is probed if empty")
+		fs.DurationVar(&startArgs.probeEvery, "probe-interval", exchange.DefaultProbeInterval, "how often a canary is probed")
+		fs.BoolVar(&startArgs.bitswap, "bitswap-fallback", false, "retrieve content from the public IPFS network over Bitswap when no provider offers it")
+		fs.BoolVar(&startArgs.dht, "dht", false, "advertise the content of this pop in the DHT and look for providers there when no pop in the gossip network offers it")
+		fs.BoolVar(&startArgs.separatePub, "separate-publisher", false, "publish content from a separate peer ID and Filecoin address than the ones serving the cache")
+		fs.StringVar(&startArgs.dnsServers, "dns-servers", "", "DNS servers resolving DNSLink names separated by commas e.g. 1.1.1.1:53, defaults to the system resolver")
+		fs.StringVar(&startArgs.miners, "storage-miners", "", "addresses of the Filecoin miners to archive content with separated by commas, defaults to the miners of the regions")
+		fs.StringVar(&startArgs.logLevel, "log-level", "info", "log level of every subsystem followed by the levels of single subsystems separated by commas e.g. info,tx=debug, change it while running with pop log level")
+		fs.BoolVar(&startArgs.logJSON, "log-json", false, "write the logs as JSON lines instead of formatting them for a console")
+		fs.StringVar(&startArgs.snapshots, "snapshots", "", "collections exported on a schedule and published as new snapshots separated by semicolons e.g. name=users,file=/var/lib/users.db,every=1h,keep=5;name=orders,cmd=pg_dump orders,out=orders.sql")
 
 		return fs
 	})(),
@@ -64,7 +187,7 @@ The 'pop start' command starts a pop daemon service.
 			path = ""
 		}
 		return []ff.Option{
-			ff.WithConfigFile(filepath.Join(path, "PopConfig.json")),
+			ff.WithConfigFile(filepath.Join(path, node.ConfigFile)),
 			ff.WithConfigFileParser(ff.JSONParser),
 			ff.WithAllowMissingConfigFile(true),
 		}
@@ -72,6 +195,19 @@ The 'pop start' command starts a pop daemon service.
 }
 
 func runStart(ctx context.Context, args []string) error {
+	if startArgs.detach && !isDetached() {
+		path, err := utils.FullPath(utils.RepoPath())
+		if err != nil {
+			return err
+		}
+		return detach(ctx, path, commandArgs("start"))
+	}
+
+	logging.SetOutput(os.Stderr, startArgs.logJSON)
+	if err := logging.SetLevels(startArgs.logLevel); err != nil {
+		return err
+	}
+
 	fmt.Printf(`
 . 　　   .  　 *  ✵ 　 　　 ✦ 
 　 　　　　　
@@ -113,10 +249,76 @@ Manage your Myel point of presence from the command line.
 		defer os.RemoveAll(path)
 	}
 
+	// Refuse to start twice on the same repo
+	unlock, err := lockRepo(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	privKey := setupWallet(init)
 
 	regions := setupRegions()
 
+	transforms, err := node.ParseTransforms(startArgs.transforms)
+	if err != nil {
+		return err
+	}
+
+	quotas, err := exchange.ParseCategoryQuotas(startArgs.catQuotas)
+	if err != nil {
+		return err
+	}
+
+	var tenants []node.Tenant
+	if startArgs.tenants != "" {
+		tenants, err = node.LoadTenants(startArgs.tenants)
+		if err != nil {
+			return err
+		}
+	}
+
+	var contracts []exchange.Contract
+	if startArgs.contracts != "" {
+		contracts, err = node.LoadContracts(startArgs.contracts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var standbyOf *peer.AddrInfo
+	if startArgs.standbyOf != "" {
+		standbyOf, err = node.ParseStandbyOf(startArgs.standbyOf)
+		if err != nil {
+			return err
+		}
+	}
+	standbys, err := node.ParseStandbys(startArgs.standbys)
+	if err != nil {
+		return err
+	}
+	delegators, err := node.ParseDelegators(startArgs.delegators)
+	if err != nil {
+		return err
+	}
+	canaries, err := node.ParseCanaries(startArgs.canaries)
+	if err != nil {
+		return err
+	}
+	collections, err := node.ParseCollections(startArgs.snapshots)
+	if err != nil {
+		return err
+	}
+
+	// Apply the last self benchmark if any
+	capability, err := node.LoadCapability(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if capability != nil {
+		fmt.Printf("==> Using capability report from %s\n", capability.Time.Format(time.RFC1123))
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	interrupt := make(chan os.Signal, 1)
@@ -134,9 +336,33 @@ Manage your Myel point of presence from the command line.
 
 	filToken := utils.FormatToken(startArgs.FilToken, startArgs.FilTokenType)
 
+	var dnsServers []string
+	if startArgs.dnsServers != "" {
+		dnsServers = strings.Split(startArgs.dnsServers, ",")
+	}
+	var miners []string
+	if startArgs.miners != "" {
+		miners = strings.Split(startArgs.miners, ",")
+	}
+	var relays []string
+	if startArgs.relays != "" {
+		relays = strings.Split(startArgs.relays, ",")
+	}
+	var listenAddrs []string
+	if startArgs.listen != "" {
+		listenAddrs = strings.Split(startArgs.listen, ",")
+	}
+	var announceAddrs []string
+	if startArgs.announce != "" {
+		announceAddrs = strings.Split(startArgs.announce, ",")
+	}
+
+	// The interactive setup separates the bootstrap peers with new lines
 	var bAddrs []string
-	if startArgs.Bootstrap != "" {
-		bAddrs = append(bAddrs, startArgs.Bootstrap)
+	for _, addr := range strings.FieldsFunc(startArgs.Bootstrap, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			bAddrs = append(bAddrs, addr)
+		}
 	}
 
 	var capacity uint64
@@ -146,14 +372,121 @@ Manage your Myel point of presence from the command line.
 		fmt.Println("failed to parse capacity")
 	}
 
+	var maxMemory uint64
+	if startArgs.maxMemory != "" {
+		if size, err := units.FromHumanSize(startArgs.maxMemory); err == nil {
+			maxMemory = uint64(size)
+		} else {
+			fmt.Println("failed to parse max memory")
+		}
+	}
+
+	var bandwidth node.LiveConfig
+	for k, v := range map[string]string{
+		"upload-limit":        startArgs.upLimit,
+		"download-limit":      startArgs.downLimit,
+		"peer-upload-limit":   startArgs.peerUp,
+		"peer-download-limit": startArgs.peerDown,
+	} {
+		if err := node.SetLiveKey(&bandwidth, k, v); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+	}
+
+	var leasePrice filecoin.BigInt
+	if startArgs.leasePrice != "" {
+		price, err := filecoin.ParseFIL(startArgs.leasePrice)
+		if err != nil {
+			return err
+		}
+		// Convert to a price per byte
+		leasePrice = filecoin.BigDiv(filecoin.BigInt(price), filecoin.NewInt(1<<30))
+	}
+
+	var redeemThreshold filecoin.BigInt
+	if startArgs.redeemAt != "" {
+		amt, err := filecoin.ParseFIL(startArgs.redeemAt)
+		if err != nil {
+			return err
+		}
+		redeemThreshold = filecoin.BigInt(amt)
+	}
+
+	var pricing exchange.PricingConfig
+	for k, v := range startArgs.pricing {
+		if err := node.SetPricingKey(&pricing, k, *v); err != nil {
+			return err
+		}
+	}
+
+	acl, err := node.ParseACLConfig(startArgs.aclDefault, startArgs.aclAllow, startArgs.aclDeny, startArgs.aclOps)
+	if err != nil {
+		return err
+	}
+
+	startArgs.transfer.MaxRestarts = uint32(startArgs.maxRestarts)
+
+	// The flags were read from the config file of the default repo
+	var confPath string
+	if repo, err := utils.FullPath(utils.RepoPath()); err == nil {
+		confPath = filepath.Join(repo, node.ConfigFile)
+	}
+
 	opts := node.Options{
-		RepoPath:       path,
-		BootstrapPeers: bAddrs,
-		FilEndpoint:    startArgs.FilEndpoint,
-		FilToken:       filToken,
-		PrivKey:        privKey,
-		Regions:        regions,
-		Capacity:       capacity,
+		RepoPath:           path,
+		BootstrapPeers:     bAddrs,
+		MinPeers:           startArgs.minPeers,
+		ListenAddrs:        listenAddrs,
+		AnnounceAddrs:      announceAddrs,
+		EnableAutoRelay:    startArgs.autoRelay,
+		Relays:             relays,
+		FilEndpoint:        startArgs.FilEndpoint,
+		FilToken:           filToken,
+		PrivKey:            privKey,
+		Regions:            regions,
+		TopicShards:        startArgs.shards,
+		PrivateQueries:     startArgs.private,
+		Capacity:           capacity,
+		PublisherShare:     startArgs.pubShare,
+		CategoryQuotas:     quotas,
+		CategoryLabel:      startArgs.catLabel,
+		MaxRefs:            startArgs.maxRefs,
+		MaxIndexBuckets:    startArgs.maxBuckets,
+		FastStart:          startArgs.fastStart,
+		GatewayAddr:        startArgs.gateway,
+		GatewayTransforms:  transforms,
+		GatewayTenants:     tenants,
+		GatewayQuotaPeriod: startArgs.quotaPeriod,
+		GatewayPayments:    startArgs.gatewayPaid,
+		CacheVariants:      startArgs.variants,
+		MetricsAddr:        startArgs.metrics,
+		GRPCAddr:           startArgs.grpc,
+		AdminAddr:          startArgs.admin,
+		MaxMemory:          maxMemory,
+		MaxGoroutines:      startArgs.maxRoutines,
+		Bandwidth:          bandwidth.Bandwidth,
+		LeasePrice:         leasePrice,
+		RedeemThreshold:    redeemThreshold,
+		Pricing:            pricing,
+		ACL:                acl,
+		Contracts:          contracts,
+		Capability:         capability,
+		TransferPolicy:     startArgs.transfer,
+		StallTimeout:       startArgs.stallTime,
+		StallRestarts:      startArgs.stallTries,
+		SlowBlockThreshold: startArgs.slowBlock,
+		StandbyOf:          standbyOf,
+		Standbys:           standbys,
+		Delegators:         delegators,
+		ProbeCanaries:      canaries,
+		ProbeInterval:      startArgs.probeEvery,
+		DNSServers:         dnsServers,
+		BitswapFallback:    startArgs.bitswap,
+		EnableDHT:          startArgs.dht,
+		SeparatePublisher:  startArgs.separatePub,
+		StorageMiners:      miners,
+		Collections:        collections,
+		ConfigPath:         confPath,
 	}
 
 	err = node.Run(ctx, opts)
@@ -255,7 +588,7 @@ func setupRepo() (string, bool, error) {
 	if err := e.Encode(startArgs); err != nil {
 		return path, false, err
 	}
-	c, err := os.Create(filepath.Join(path, "PopConfig.json"))
+	c, err := os.Create(filepath.Join(path, node.ConfigFile))
 	if err != nil {
 		return path, false, err
 	}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var statsArgs struct {
+	hours int
+	days  int
+}
+
+var statsCmd = &ffcli.Command{
+	Name:       "stats",
+	ShortUsage: "stats [-hours <n>] [-days <n>] <cid|tag> | stats bw",
+	ShortHelp:  "Show how often a root was read over time",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop stats' command shows the reads of a root by hour and by day, split between the reads of this pop
+(local) and the retrievals it served to other peers (remote). Statistics are kept for a while after the
+content is evicted to help deciding what to prefetch or pin. Run 'pop stats bw' for the bandwidth used.
+
+`),
+	Subcommands: []*ffcli.Command{
+		statsBwCmd,
+	},
+	Exec: runStats,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("stats", flag.ExitOnError)
+		fs.IntVar(&statsArgs.hours, "hours", node.DefaultStatsHours, "number of hours to show")
+		fs.IntVar(&statsArgs.days, "days", node.DefaultStatsDays, "number of days to show")
+		return fs
+	})(),
+}
+
+func runStats(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.StatsResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.StatsResult; sr != nil {
+			src <- sr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Stats(&node.StatsArgs{
+		Root:  args[0],
+		Hours: statsArgs.hours,
+		Days:  statsArgs.days,
+	})
+	select {
+	case sr := <-src:
+		if sr.Err != "" {
+			return errors.New(sr.Err)
+		}
+		printStats(sr)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func printStats(sr *node.StatsResult) {
+	if sr.Stored {
+		fmt.Printf("==> %s (frequency %d)\n", sr.Root, sr.Freq)
+	} else {
+		fmt.Printf("==> %s (not stored)\n", sr.Root)
+	}
+	fmt.Printf("\nHour\t\t\tLocal\tRemote\n")
+	for _, b := range sr.Hourly {
+		fmt.Printf("%s\t%d\t%d\n", b.Start.Format("2006-01-02 15:04"), b.Local, b.Remote)
+	}
+	fmt.Printf("\nDay\t\t\tLocal\tRemote\n")
+	for _, b := range sr.Daily {
+		fmt.Printf("%s\t\t%d\t%d\n", b.Start.UTC().Format("2006-01-02"), b.Local, b.Remote)
+	}
+}
+
+var statsBwArgs struct {
+	top int
+}
+
+var statsBwCmd = &ffcli.Command{
+	Name:       "bw",
+	ShortUsage: "stats bw [-top <n>]",
+	ShortHelp:  "Show the bandwidth used per peer and per ref",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop stats bw' command shows the bytes of the blocks sent and received since pop started with the
+peers and the refs which transferred the most, and the bandwidth limits set with the upload-limit,
+download-limit, peer-upload-limit and peer-download-limit keys of 'pop config set'.
+
+`),
+	Exec: runStatsBw,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("bw", flag.ExitOnError)
+		fs.IntVar(&statsBwArgs.top, "top", node.DefaultBandwidthTop, "number of peers and refs to show")
+		return fs
+	})(),
+}
+
+func runStatsBw(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	brc := make(chan *node.BandwidthResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if br := n.BandwidthResult; br != nil {
+			brc <- br
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Bandwidth(&node.BandwidthArgs{Top: statsBwArgs.top})
+	select {
+	case br := <-brc:
+		if br.Err != "" {
+			return errors.New(br.Err)
+		}
+		printBandwidth(br)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func printBandwidth(br *node.BandwidthResult) {
+	rate := func(limit uint64) string {
+		if limit == 0 {
+			return "unlimited"
+		}
+		return units.HumanSize(float64(limit)) + "/s"
+	}
+	fmt.Printf("==> Sent %s, received %s\n", units.HumanSize(float64(br.Sent)), units.HumanSize(float64(br.Received)))
+	fmt.Printf("Upload limit\t%s\t(%s per peer)\n", rate(br.UploadLimit), rate(br.PeerUploadLimit))
+	fmt.Printf("Download limit\t%s\t(%s per peer)\n", rate(br.DownloadLimit), rate(br.PeerDownloadLimit))
+	entries := func(title string, es []node.BandwidthEntry) {
+		fmt.Printf("\n%s\tSent\tReceived\n", title)
+		for _, e := range es {
+			fmt.Printf("%s\t%s\t%s\n", e.ID, units.HumanSize(float64(e.Sent)), units.HumanSize(float64(e.Received)))
+		}
+	}
+	entries("Peer", br.Peers)
+	entries("Ref", br.Refs)
+}
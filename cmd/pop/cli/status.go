@@ -12,11 +12,13 @@ import (
 
 var statusCmd = &ffcli.Command{
 	Name:      "status",
-	ShortHelp: "Print the state of any ongoing transaction",
+	ShortHelp: "Print the state of any ongoing transaction and the reachability of the node",
 	LongHelp: strings.TrimSpace(`
 
 The 'pop status' command prints all the files that have been added to a transaction DAG. Files that have
-been chunked and staged in the blockstore but not yet committed to be pushed to the network.
+been chunked and staged in the blockstore but not yet committed to be pushed to the network. It also prints
+whether other peers can dial this pop as detected by AutoNAT and the relayed addresses it advertises if
+AutoRelay is enabled with the -auto-relay flag of 'pop start'.
 
 `),
 	Exec: runStatus,
@@ -37,6 +39,12 @@ func runStatus(ctx context.Context, args []string) error {
 	cc.Status(&node.StatusArgs{})
 	select {
 	case sr := <-src:
+		if sr.Reachability != "" {
+			fmt.Printf("Reachability: %s\n", sr.Reachability)
+			for _, addr := range sr.RelayAddrs {
+				fmt.Printf("Relayed at %s\n", addr)
+			}
+		}
 		if sr.Err != "" {
 			return errors.New(sr.Err)
 		}
@@ -47,6 +55,9 @@ func runStatus(ctx context.Context, args []string) error {
 		fmt.Printf("Staged for storage:\n")
 		// Output is already formatted but should move it here
 		fmt.Printf("%s\n", sr.Entries)
+		if sr.Deduplicated != "" {
+			fmt.Printf("Deduplicated: %s already stored\n", sr.Deduplicated)
+		}
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
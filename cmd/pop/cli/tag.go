@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var tagArgs struct {
+	expect string
+	delete bool
+	list   bool
+}
+
+var tagCmd = &ffcli.Command{
+	Name:       "tag",
+	ShortUsage: "tag [-expect <cid>] <name> <cid|tag> | tag -d <name> | tag [-l]",
+	ShortHelp:  "Name a root to use instead of its CID",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop tag' command gives a name to a root so it can be used anywhere a root CID is expected, such as
+'pop get mydataset/file.txt'. Tagging a name again points it to the new root. For rolling updates pass the
+root the tag is expected to point to with -expect so the tag is only moved if nobody else moved it meanwhile.
+Without arguments it lists all the tags.
+
+`),
+	Exec: runTag,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("tag", flag.ExitOnError)
+		fs.StringVar(&tagArgs.expect, "expect", "", "only move the tag if it still points to this root")
+		fs.BoolVar(&tagArgs.delete, "d", false, "delete the tag")
+		fs.BoolVar(&tagArgs.list, "l", false, "list the tags")
+		return fs
+	})(),
+}
+
+func runTag(ctx context.Context, args []string) error {
+	targs := &node.TagArgs{
+		Expect: tagArgs.expect,
+		Delete: tagArgs.delete,
+		List:   tagArgs.list || len(args) == 0,
+	}
+	switch {
+	case targs.List:
+	case targs.Delete && len(args) == 1:
+		targs.Name = args[0]
+	case !targs.Delete && len(args) == 2:
+		targs.Name, targs.Root = args[0], args[1]
+	default:
+		return flag.ErrHelp
+	}
+	if targs.Name != "" {
+		if err := exchange.ValidateTag(targs.Name); err != nil {
+			return err
+		}
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	trc := make(chan *node.TagResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if tr := n.TagResult; tr != nil {
+			trc <- tr
+			if tr.Last || tr.Err != "" {
+				close(trc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Tag(targs)
+	for tr := range trc {
+		if tr.Err != "" {
+			return errors.New(tr.Err)
+		}
+		switch {
+		case targs.List && tr.Name == "":
+			fmt.Printf("No tags\n")
+		case targs.List:
+			fmt.Printf("%s\t%s\t%s\n", tr.Name, tr.Root, tr.Time.Format(time.RFC3339))
+		case targs.Delete:
+			fmt.Printf("==> Deleted tag %s (was %s)\n", tr.Name, tr.Prev)
+		case tr.Prev != "" && tr.Prev != tr.Root:
+			fmt.Printf("==> Moved tag %s from %s to %s\n", tr.Name, tr.Prev, tr.Root)
+		default:
+			fmt.Printf("==> Tagged %s as %s\n", tr.Root, tr.Name)
+		}
+	}
+	return nil
+}
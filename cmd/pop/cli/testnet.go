@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var testnetArgs struct {
+	nodes       int
+	seed        string
+	repo        string
+	port        int
+	gatewayPort int
+	regions     string
+	capacity    string
+	out         string
+}
+
+// testnetInfo is written to the output file so tests know how to reach the network
+type testnetInfo struct {
+	Addrs []string          `json:"addrs"`
+	Roots map[string]string `json:"roots"`
+}
+
+var testnetCmd = &ffcli.Command{
+	Name:       "testnet",
+	ShortUsage: "testnet [-nodes <n>] [-seed <dir>]",
+	ShortHelp:  "Run a local network of pop nodes for integration tests",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop testnet' command runs a network of pop nodes in a single process so applications can run
+integration tests against real pop behavior. Nodes listen on consecutive ports from -port and derive
+their identity from their index so peer addresses are the same on every run. The files in the -seed
+directory are added to the first node and dispatched to the others before the network is reported
+ready. Other pop commands are executed by the first node. Repos are temporary unless -repo is set.
+
+`),
+	Exec: runTestnet,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("testnet", flag.ExitOnError)
+		fs.IntVar(&testnetArgs.nodes, "nodes", 3, "number of nodes in the network")
+		fs.StringVar(&testnetArgs.seed, "seed", "", "directory of files to add to the network")
+		fs.StringVar(&testnetArgs.repo, "repo", "", "directory to keep the node repos in, a temporary directory is used if empty")
+		fs.IntVar(&testnetArgs.port, "port", node.DefaultTestnetPort, "libp2p port of the first node, the next nodes use the following ports")
+		fs.IntVar(&testnetArgs.gatewayPort, "gateway-port", 0, "HTTP gateway port of the first node, the next nodes use the following ports, disabled if 0")
+		fs.StringVar(&testnetArgs.regions, "regions", "Global", "regions joined by the nodes separated by commas")
+		fs.StringVar(&testnetArgs.capacity, "capacity", "1GB", "storage space allocated for each node")
+		fs.StringVar(&testnetArgs.out, "out", "", "file to write the node addresses and seeded roots to as JSON once the network is ready")
+		return fs
+	})(),
+}
+
+func runTestnet(ctx context.Context, args []string) error {
+	capacity, err := units.FromHumanSize(testnetArgs.capacity)
+	if err != nil {
+		return fmt.Errorf("failed to parse capacity: %w", err)
+	}
+
+	repo := testnetArgs.repo
+	if repo == "" {
+		repo, err = os.MkdirTemp("", ".pop-testnet")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(repo)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-interrupt:
+			fmt.Printf("Shutting down, reason: %s\n", s.String())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	opts := node.TestnetOptions{
+		Nodes:       testnetArgs.nodes,
+		RepoPath:    repo,
+		SeedPath:    testnetArgs.seed,
+		Port:        testnetArgs.port,
+		GatewayPort: testnetArgs.gatewayPort,
+		Regions:     strings.Split(testnetArgs.regions, ","),
+		Capacity:    uint64(capacity),
+		Ready: func(addrs []string, roots map[string]string) {
+			if testnetArgs.out == "" {
+				return
+			}
+			data, err := json.MarshalIndent(testnetInfo{Addrs: addrs, Roots: roots}, "", "    ")
+			if err != nil {
+				fmt.Println("failed to encode testnet info", err)
+				return
+			}
+			if err := os.WriteFile(testnetArgs.out, data, 0644); err != nil {
+				fmt.Println("failed to write testnet info", err)
+			}
+		},
+	}
+
+	err = node.RunTestnet(ctx, opts)
+	if err != nil && err != context.Canceled {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var tokenCmd = &ffcli.Command{
+	Name:       "token",
+	ShortUsage: "token",
+	ShortHelp:  "Print the bearer token of the admin and gRPC APIs",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop token' command prints the token remote clients must send in the Authorization header
+to use the REST admin API enabled with 'pop start -admin', or in the authorization metadata of
+gRPC calls when started with 'pop start -grpc'. The token is derived from a secret
+generated in the keystore of the repo. It doesn't need a running pop.
+
+`),
+	Exec: runToken,
+}
+
+func runToken(ctx context.Context, args []string) error {
+	path, err := utils.FullPath(utils.RepoPath())
+	if err != nil {
+		return err
+	}
+	ks, err := keystore.NewFSKeystore(filepath.Join(path, "keystore"))
+	if err != nil {
+		return err
+	}
+	token, err := node.AdminToken(ks)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bearer %s\n", token)
+	return nil
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var trackArgs struct {
+	untrack bool
+}
+
+var trackCmd = &ffcli.Command{
+	Name:       "track",
+	ShortUsage: "track [-untrack] [<name>]",
+	ShortHelp:  "Keep cached content published under a mutable name up to date",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop track' command resolves an IPNS or DNSLink name such as /ipns/example.com and checks
+periodically if it points to a new root. When it does, the new root is retrieved and the previous
+one is evicted before any other content. Without a name it lists the tracked names.
+
+`),
+	Exec: runTrack,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("track", flag.ExitOnError)
+		fs.BoolVar(&trackArgs.untrack, "untrack", false, "stop tracking the name")
+		return fs
+	})(),
+}
+
+func runTrack(ctx context.Context, args []string) error {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if trackArgs.untrack && name == "" {
+		return errors.New("missing name to untrack")
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	trc := make(chan *node.TrackResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if tr := n.TrackResult; tr != nil {
+			trc <- tr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Track(&node.TrackArgs{Name: name, Untrack: trackArgs.untrack})
+	select {
+	case tr := <-trc:
+		if tr.Err != "" {
+			return errors.New(tr.Err)
+		}
+		for _, b := range tr.Names {
+			fmt.Printf("==> %s -> %s checked %s\n", b.Name, b.Root, b.Checked.Format("2006-01-02 15:04"))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
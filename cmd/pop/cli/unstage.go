@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var unstageCmd = &ffcli.Command{
+	Name:       "unstage",
+	ShortUsage: "unstage <name>...",
+	ShortHelp:  "Remove files from the ongoing transaction",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop unstage' command removes the given files from the ongoing transaction so they are not committed.
+Files are named as they were put. It prints the files still staged afterwards.
+
+`),
+	Exec: runUnstage,
+}
+
+func runUnstage(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	keys := make([]string, len(args))
+	for i, a := range args {
+		keys[i] = exchange.FileKey(a).String()
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	src := make(chan *node.StatusResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if sr := n.StatusResult; sr != nil {
+			src <- sr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Unstage(&node.UnstageArgs{Keys: keys})
+	select {
+	case sr := <-src:
+		if sr.Err != "" {
+			return errors.New(sr.Err)
+		}
+		if sr.Entries == "" {
+			fmt.Printf("Nothing to pack, workdag clean.\n")
+			return nil
+		}
+		fmt.Printf("Staged for storage:\n")
+		fmt.Printf("%s\n", sr.Entries)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var usageCmd = &ffcli.Command{
+	Name:      "usage",
+	ShortHelp: "Print the gateway usage of each tenant",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop usage' command prints the number of requests and bytes served by the gateway to each tenant
+during the current quota period. Tenants are loaded from the file passed to 'pop start -gateway-tenants'
+and each of them can check its own usage with a GET request to /usage on the gateway.
+
+`),
+	Exec: runUsage,
+}
+
+func runUsage(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	urc := make(chan *node.UsageResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if ur := n.UsageResult; ur != nil {
+			urc <- ur
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Usage(&node.UsageArgs{})
+	select {
+	case ur := <-urc:
+		if ur.Err != "" {
+			return errors.New(ur.Err)
+		}
+		for _, t := range ur.Tenants {
+			quota := "unlimited"
+			if t.Quota > 0 {
+				quota = units.BytesSize(float64(t.Quota))
+			}
+			fmt.Printf("==> %s %d requests %s of %s served, %d rejected since %s\n",
+				t.Name, t.Requests, units.BytesSize(float64(t.Bytes)), quota, t.Rejected, t.PeriodStart.Format("2006-01-02 15:04"))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
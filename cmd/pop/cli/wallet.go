@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var walletCmd = &ffcli.Command{
+	Name:       "wallet",
+	ShortUsage: "wallet <subcommand>",
+	ShortHelp:  "Inspect the funds of this pop",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop wallet' commands inspect the Filecoin funds used to pay for and get paid for retrievals.
+
+`),
+	Subcommands: []*ffcli.Command{
+		walletChannelsCmd,
+	},
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+}
+
+var walletChannelsCmd = &ffcli.Command{
+	Name:      "channels",
+	ShortHelp: "List the payment channels with their balance and pending vouchers",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop wallet channels' command lists the payment channels we pay providers with (outbound) and the
+ones clients pay us with (inbound). Pending is the value of the vouchers not redeemed on chain yet, the
+vouchers of inbound channels are redeemed when they settle or once they reach the -redeem-threshold
+given to 'pop start'.
+
+`),
+	Exec: runWalletChannels,
+}
+
+func runWalletChannels(ctx context.Context, args []string) error {
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	crc := make(chan *node.ChannelsResult)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if cr := n.ChannelsResult; cr != nil {
+			crc <- cr
+			if cr.Last || cr.Err != "" {
+				close(crc)
+			}
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Channels(&node.ChannelsArgs{})
+	for cr := range crc {
+		if cr.Err != "" {
+			return errors.New(cr.Err)
+		}
+		status := ""
+		if cr.Settling {
+			status = " (settling)"
+		}
+		fmt.Printf("==> %s %s with %s%s\n", cr.Channel, cr.Direction, cr.Peer, status)
+		fmt.Printf("    funds %s, vouchered %s over %d lanes, pending %s in %d vouchers\n", cr.Amount, cr.Vouchered, cr.Lanes, cr.Pending, cr.PendingVouchers)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/node"
+	"github.com/peterbourgon/ff/v2/ffcli"
+)
+
+var warmArgs struct {
+	file string
+}
+
+var warmCmd = &ffcli.Command{
+	Name:       "warm",
+	ShortUsage: "warm [-file <path>] <cid>[:<weight>]...",
+	ShortHelp:  "Steer the cache with external demand signals",
+	LongHelp: strings.TrimSpace(`
+
+The 'pop warm' command feeds demand hints from an external system such as website analytics into the
+cache. Each hint is a root cid with an optional weight (defaults to 1). Roots stored by this pop are kept
+longer and the others are retrieved the next time the pop refreshes its index. Hints can be read from
+a file with one '<cid> <weight>' pair per line, '-' reads them from stdin.
+
+`),
+	Exec: runWarm,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("warm", flag.ExitOnError)
+		fs.StringVar(&warmArgs.file, "file", "", "read the hints from a file with one '<cid> <weight>' pair per line")
+		return fs
+	})(),
+}
+
+func runWarm(ctx context.Context, args []string) error {
+	var hints []node.DemandHint
+	for _, arg := range args {
+		h, err := parseHint(strings.SplitN(arg, ":", 2))
+		if err != nil {
+			return err
+		}
+		hints = append(hints, h)
+	}
+	if warmArgs.file != "" {
+		fh, err := readHints(warmArgs.file)
+		if err != nil {
+			return err
+		}
+		hints = append(hints, fh...)
+	}
+	if len(hints) == 0 {
+		return errors.New("missing demand hints")
+	}
+
+	c, cc, ctx, cancel := connect(ctx)
+	defer cancel()
+
+	wrc := make(chan *node.WarmResult, 1)
+	cc.SetNotifyCallback(func(n node.Notify) {
+		if wr := n.WarmResult; wr != nil {
+			wrc <- wr
+		}
+	})
+	go receive(ctx, cc, c)
+
+	cc.Warm(&node.WarmArgs{Hints: hints})
+	select {
+	case wr := <-wrc:
+		if wr.Err != "" {
+			return exchange.ErrorFromCode(wr.Code, wr.Err)
+		}
+		fmt.Printf("==> Boosted %d stored roots and added %d roots to the interest list\n", wr.Cached, wr.Interest)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readHints parses a file with one hint per line, the cid and the weight are separated by spaces or a comma
+func readHints(path string) ([]node.DemandHint, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var hints []node.DemandHint
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.FieldsFunc(s.Text(), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		h, err := parseHint(fields)
+		if err != nil {
+			return nil, err
+		}
+		hints = append(hints, h)
+	}
+	return hints, s.Err()
+}
+
+func parseHint(fields []string) (node.DemandHint, error) {
+	h := node.DemandHint{Cid: fields[0], Weight: 1}
+	if len(fields) > 1 {
+		w, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return h, fmt.Errorf("invalid weight for %s: %w", fields[0], err)
+		}
+		h.Weight = w
+	}
+	return h, nil
+}
@@ -0,0 +1,77 @@
+//go:build js && wasm
+// +build js,wasm
+
+// popjs exposes the light client to browser apps. Build it with:
+//
+//	GOOS=js GOARCH=wasm go build -o pop.wasm ./cmd/popjs
+//
+// and load it with the wasm_exec.js support file shipped with Go. It registers a global
+// popFetch(gateways, root, key) function returning a promise resolving with the verified content
+// of the entry as an Uint8Array. Gateways are separated by commas and the entry is fetched from the
+// cheapest and fastest one offering it for free.
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"syscall/js"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/myelnet/pop/lightclient"
+)
+
+func main() {
+	js.Global().Set("popFetch", js.FuncOf(fetch))
+	// Keep the runtime alive so the function can be called
+	select {}
+}
+
+func fetch(this js.Value, args []js.Value) interface{} {
+	handler := js.FuncOf(func(this js.Value, pargs []js.Value) interface{} {
+		resolve, reject := pargs[0], pargs[1]
+		go func() {
+			data, err := fetchEntry(args)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			arr := js.Global().Get("Uint8Array").New(len(data))
+			js.CopyBytesToJS(arr, data)
+			resolve.Invoke(arr)
+		}()
+		return nil
+	})
+	// The promise executor runs synchronously so the handler isn't needed after this
+	promise := js.Global().Get("Promise").New(handler)
+	handler.Release()
+	return promise
+}
+
+func fetchEntry(args []js.Value) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("usage: popFetch(gateways, root, key)")
+	}
+	root, err := cid.Decode(args[1].String())
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	key := args[2].String()
+	gateways := strings.Split(args[0].String(), ",")
+	content, err := lightclient.New(gateways).Fetch(ctx, root, key)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := content.File(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := nd.(files.File)
+	if !ok {
+		return nil, errors.New("entry is a directory")
+	}
+	return ioutil.ReadAll(f)
+}
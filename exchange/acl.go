@@ -0,0 +1,208 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// KACL is the datastore key prefix of the access rules of each ref
+const KACL = "/acl"
+
+// ErrAccessDenied is returned when a peer isn't allowed to query or retrieve a ref
+var ErrAccessDenied = errors.New("access denied")
+
+// ErrInvalidVisibility is returned when a visibility is neither public nor private
+var ErrInvalidVisibility = errors.New("visibility must be public or private")
+
+// Visibility decides who may retrieve a ref
+type Visibility string
+
+const (
+	// Public refs are served to any peer which isn't denied
+	Public Visibility = "public"
+	// Private refs are only served to the peers they allow
+	Private Visibility = "private"
+)
+
+// ParseVisibility parses public or private, an empty string is public
+func ParseVisibility(s string) (Visibility, error) {
+	switch Visibility(s) {
+	case "", Public:
+		return Public, nil
+	case Private:
+		return Private, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidVisibility, s)
+}
+
+// RefACL are the access rules of a ref
+type RefACL struct {
+	Root       cid.Cid    `json:"root"`
+	Visibility Visibility `json:"visibility"`
+	// Allow lists the peers which may retrieve the ref if it is private
+	Allow []peer.ID `json:"allow,omitempty"`
+	// Deny lists the peers which may never retrieve the ref
+	Deny []peer.ID `json:"deny,omitempty"`
+}
+
+// ACLConfig sets the access rules applying to every ref
+type ACLConfig struct {
+	// Default is the visibility of the refs without access rules. Default is public.
+	Default Visibility
+	// Allow restricts the peers served public refs to the ones listed if not empty
+	Allow []peer.ID
+	// Deny lists the peers which are never served any ref
+	Deny []peer.ID
+	// Operators are peers run by the operator of this node, they bypass every rule
+	Operators []peer.ID
+}
+
+// peerSet indexes a list of peers
+func peerSet(peers []peer.ID) map[peer.ID]bool {
+	set := make(map[peer.ID]bool, len(peers))
+	for _, p := range peers {
+		set[p] = true
+	}
+	return set
+}
+
+// ACL controls which peers may query and retrieve which refs. The global rules are checked first then the
+// rules of the ref, operator peers bypass all of them.
+type ACL struct {
+	ds datastore.Batching
+
+	mu        sync.RWMutex
+	def       Visibility
+	allow     map[peer.ID]bool
+	deny      map[peer.ID]bool
+	operators map[peer.ID]bool
+}
+
+// NewACL creates an ACL persisting the rules of each ref in the given datastore
+func NewACL(ds datastore.Batching, cfg ACLConfig) *ACL {
+	acl := &ACL{
+		ds: namespace.Wrap(ds, datastore.NewKey(KACL)),
+	}
+	acl.SetConfig(cfg)
+	return acl
+}
+
+// SetConfig replaces the global rules
+func (acl *ACL) SetConfig(cfg ACLConfig) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	acl.def = cfg.Default
+	if acl.def == "" {
+		acl.def = Public
+	}
+	acl.allow = peerSet(cfg.Allow)
+	acl.deny = peerSet(cfg.Deny)
+	acl.operators = peerSet(cfg.Operators)
+}
+
+// Set saves the access rules of a ref
+func (acl *ACL) Set(r RefACL) error {
+	if _, err := ParseVisibility(string(r.Visibility)); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return acl.ds.Put(datastore.NewKey(r.Root.String()), buf)
+}
+
+// Get returns the access rules of a ref, refs without rules get the default visibility
+func (acl *ACL) Get(root cid.Cid) (RefACL, error) {
+	buf, err := acl.ds.Get(datastore.NewKey(root.String()))
+	if err == datastore.ErrNotFound {
+		acl.mu.RLock()
+		defer acl.mu.RUnlock()
+		return RefACL{Root: root, Visibility: acl.def}, nil
+	}
+	if err != nil {
+		return RefACL{}, err
+	}
+	var r RefACL
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return RefACL{}, err
+	}
+	return r, nil
+}
+
+// Delete removes the access rules of a ref so it gets the default visibility
+func (acl *ACL) Delete(root cid.Cid) error {
+	return acl.ds.Delete(datastore.NewKey(root.String()))
+}
+
+// List returns the access rules of all the refs which have some
+func (acl *ACL) List() ([]RefACL, error) {
+	res, err := acl.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]RefACL, 0, len(entries))
+	for _, e := range entries {
+		var r RefACL
+		if err := json.Unmarshal(e.Value, &r); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Root.String() < list[j].Root.String()
+	})
+	return list, nil
+}
+
+// Check returns ErrAccessDenied if a peer may not query or retrieve a ref. Clients without a peer ID such
+// as the ones of the HTTP gateway check an empty ID so they are only served the public refs when no
+// allow list restricts them.
+func (acl *ACL) Check(p peer.ID, root cid.Cid) error {
+	acl.mu.RLock()
+	operator := acl.operators[p]
+	denied := acl.deny[p]
+	allowed := len(acl.allow) == 0 || acl.allow[p]
+	acl.mu.RUnlock()
+
+	if operator {
+		return nil
+	}
+	if denied {
+		return fmt.Errorf("%w: %s is denied", ErrAccessDenied, p)
+	}
+	r, err := acl.Get(root)
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Deny {
+		if d == p {
+			return fmt.Errorf("%w: %s is denied for %s", ErrAccessDenied, p, root)
+		}
+	}
+	if r.Visibility == Private {
+		for _, a := range r.Allow {
+			if a == p {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s is private", ErrAccessDenied, root)
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s is not allowed", ErrAccessDenied, p)
+	}
+	return nil
+}
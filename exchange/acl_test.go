@@ -0,0 +1,70 @@
+package exchange
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func randPeerID(t *testing.T) peer.ID {
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	return pid
+}
+
+func TestACL(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	alice, bob, eve, op := randPeerID(t), randPeerID(t), randPeerID(t), randPeerID(t)
+
+	acl := NewACL(ds, ACLConfig{
+		Deny:      []peer.ID{eve},
+		Operators: []peer.ID{op},
+	})
+
+	pub := blockGen.Next().Cid()
+	priv := blockGen.Next().Cid()
+
+	// Refs without rules are public
+	require.NoError(t, acl.Check(alice, pub))
+	require.True(t, errors.Is(acl.Check(eve, pub), ErrAccessDenied))
+
+	require.NoError(t, acl.Set(RefACL{Root: priv, Visibility: Private, Allow: []peer.ID{alice}}))
+	require.NoError(t, acl.Check(alice, priv))
+	require.True(t, errors.Is(acl.Check(bob, priv), ErrAccessDenied))
+	// Operators bypass every rule
+	require.NoError(t, acl.Check(op, priv))
+	require.NoError(t, acl.Check(op, pub))
+
+	require.NoError(t, acl.Set(RefACL{Root: pub, Visibility: Public, Deny: []peer.ID{bob}}))
+	require.True(t, errors.Is(acl.Check(bob, pub), ErrAccessDenied))
+	require.NoError(t, acl.Check(alice, pub))
+
+	require.Error(t, acl.Set(RefACL{Root: pub, Visibility: "secret"}))
+
+	// Rules are persisted
+	acl = NewACL(ds, ACLConfig{})
+	list, err := acl.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	r, err := acl.Get(priv)
+	require.NoError(t, err)
+	require.Equal(t, Private, r.Visibility)
+	require.Equal(t, []peer.ID{alice}, r.Allow)
+
+	require.NoError(t, acl.Delete(priv))
+	require.NoError(t, acl.Check(bob, priv))
+
+	// Refs without rules get the default visibility and public refs are only served to the allowed peers
+	acl.SetConfig(ACLConfig{Default: Private, Allow: []peer.ID{alice}})
+	require.True(t, errors.Is(acl.Check(alice, priv), ErrAccessDenied))
+	require.NoError(t, acl.Check(alice, pub))
+	require.True(t, errors.Is(acl.Check(eve, pub), ErrAccessDenied))
+}
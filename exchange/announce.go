@@ -0,0 +1,267 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+//go:generate cbor-gen-for IndexAnnouncement
+
+// IndexTopic is the gossip topic providers announce the changes to their index on
+const IndexTopic = "/myel/pop/index/1.0"
+
+// DefaultSnapshotInterval is the default number of announcements after which a full snapshot is announced
+const DefaultSnapshotInterval = 10
+
+// MaxDiffRefs is the maximum number of changed refs in a diff, a snapshot is announced instead beyond that
+const MaxDiffRefs = 256
+
+// maxIndexChanges is the number of changes kept in memory to compute diffs
+const maxIndexChanges = 1024
+
+// IndexAnnouncement advertises the changes to the index of a provider since its previous announcement.
+// Snapshots only carry the root of the full index which peers who missed some diffs can fetch.
+type IndexAnnouncement struct {
+	// Seq is the sequence number of the index once the changes are applied
+	Seq uint64
+	// Base is the sequence number the diff applies to
+	Base uint64
+	// Root is the root of the full index, nil if the index is empty
+	Root     *cid.Cid
+	Snapshot bool
+	Added    []DataRef
+	Removed  []cid.Cid
+}
+
+// indexChange records a ref added or removed from the index
+type indexChange struct {
+	seq uint64
+	key cid.Cid
+	// ref is nil when the ref was removed
+	ref *DataRef
+}
+
+// recordChange increments the sequence number of the index, callers must hold the lock
+func (idx *Index) recordChange(k cid.Cid, ref *DataRef) {
+	idx.seq++
+	idx.changes = append(idx.changes, indexChange{seq: idx.seq, key: k, ref: ref})
+	if len(idx.changes) > 2*maxIndexChanges {
+		idx.changes = append([]indexChange(nil), idx.changes[len(idx.changes)-maxIndexChanges:]...)
+	}
+}
+
+// Seq returns the sequence number of the index which is incremented every time a ref is added or removed
+func (idx *Index) Seq() uint64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.seq
+}
+
+// Diff returns an announcement of the refs added and removed since the given sequence number. It returns
+// false if the changes are too old to be known in which case a snapshot should be announced.
+func (idx *Index) Diff(base uint64) (IndexAnnouncement, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	msg := IndexAnnouncement{
+		Seq:  idx.seq,
+		Base: base,
+	}
+	if idx.rootCID != cid.Undef {
+		r := idx.rootCID
+		msg.Root = &r
+	}
+	if base > idx.seq {
+		return msg, false
+	}
+	if base == idx.seq {
+		return msg, true
+	}
+	if len(idx.changes) == 0 || idx.changes[0].seq > base+1 {
+		return msg, false
+	}
+	// Only the latest change to each ref matters
+	latest := make(map[cid.Cid]*DataRef)
+	var keys []cid.Cid
+	for _, c := range idx.changes {
+		if c.seq <= base {
+			continue
+		}
+		if _, ok := latest[c.key]; !ok {
+			keys = append(keys, c.key)
+		}
+		latest[c.key] = c.ref
+	}
+	for _, k := range keys {
+		ref := latest[k]
+		if ref == nil {
+			msg.Removed = append(msg.Removed, k)
+			continue
+		}
+		added := *ref
+		added.bucketNode = nil
+		msg.Added = append(msg.Added, added)
+	}
+	return msg, true
+}
+
+// IndexAnnouncer gossips compact diffs of our index instead of the full root every time it changes
+// and applies the diffs announced by other providers to our interest list
+type IndexAnnouncer struct {
+	h        host.Host
+	ps       *pubsub.PubSub
+	idx      *Index
+	top      *pubsub.Topic
+	interval time.Duration
+	// snapshotEvery is the number of announcements after which we announce a full snapshot
+	snapshotEvery int
+	// fetch retrieves the full index of a peer when we cannot apply its diffs
+	fetch func(peer.ID, cid.Cid)
+
+	mu sync.Mutex
+	// last is the sequence number of our last announcement
+	last  uint64
+	count int
+	// peers tracks the sequence number of the last announcement we applied for each peer
+	peers map[peer.ID]uint64
+}
+
+// NewIndexAnnouncer creates a new IndexAnnouncer service
+func NewIndexAnnouncer(h host.Host, ps *pubsub.PubSub, idx *Index, fetch func(peer.ID, cid.Cid)) *IndexAnnouncer {
+	a := &IndexAnnouncer{
+		h:             h,
+		ps:            ps,
+		idx:           idx,
+		interval:      60 * time.Second,
+		snapshotEvery: DefaultSnapshotInterval,
+		fetch:         fetch,
+		peers:         make(map[peer.ID]uint64),
+	}
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			delete(a.peers, c.RemotePeer())
+		},
+	})
+	return a
+}
+
+// Start joins the index topic and starts announcing the changes to our index
+func (a *IndexAnnouncer) Start(ctx context.Context) error {
+	top, err := a.ps.Join(IndexTopic)
+	if err != nil {
+		return err
+	}
+	a.top = top
+	sub, err := top.Subscribe()
+	if err != nil {
+		return err
+	}
+	go a.pump(ctx, sub)
+	go a.run(ctx)
+	return nil
+}
+
+func (a *IndexAnnouncer) run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Announce(ctx); err != nil {
+				discoveryLog.Warn().Err(err).Msg("failed to announce index")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *IndexAnnouncer) pump(ctx context.Context, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		p, err := peer.IDFromBytes(msg.GetFrom())
+		// We don't need to handle our own messages
+		if err != nil || p == a.h.ID() {
+			continue
+		}
+		var ann IndexAnnouncement
+		if err := ann.UnmarshalCBOR(bytes.NewReader(msg.Data)); err != nil {
+			continue
+		}
+		a.Handle(p, ann)
+	}
+}
+
+// Announce publishes the changes to our index since the last announcement if any
+func (a *IndexAnnouncer) Announce(ctx context.Context) error {
+	if a.top == nil {
+		return errors.New("announcer not started")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	msg, ok := a.next()
+	if !ok {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := msg.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	if err := a.top.Publish(ctx, buf.Bytes()); err != nil {
+		return err
+	}
+	a.last = msg.Seq
+	a.count++
+	return nil
+}
+
+// next prepares the next announcement, callers must hold the lock
+func (a *IndexAnnouncer) next() (IndexAnnouncement, bool) {
+	msg, ok := a.idx.Diff(a.last)
+	// The first announcement is always a snapshot so peers know where we start from
+	if a.count > 0 && msg.Seq == a.last {
+		return msg, false
+	}
+	if !ok || a.count%a.snapshotEvery == 0 || len(msg.Added)+len(msg.Removed) > MaxDiffRefs {
+		msg.Snapshot = true
+		msg.Base = 0
+		msg.Added = nil
+		msg.Removed = nil
+	}
+	return msg, true
+}
+
+// Handle applies an announcement from a peer. Refs added to its index are added to our interest list
+// while a full fetch of its index is requested if we missed any of its diffs. Removed refs stay in the
+// interest list as they may still be popular with other providers.
+func (a *IndexAnnouncer) Handle(p peer.ID, msg IndexAnnouncement) {
+	a.mu.Lock()
+	last, known := a.peers[p]
+	a.peers[p] = msg.Seq
+	a.mu.Unlock()
+
+	if msg.Snapshot || !known || last != msg.Base {
+		// A periodic snapshot doesn't need to be fetched if we applied every diff
+		if known && last == msg.Seq {
+			return
+		}
+		if msg.Root != nil && a.fetch != nil {
+			a.fetch(p, *msg.Root)
+		}
+		return
+	}
+	a.idx.AddInterest(msg.Added)
+}
@@ -0,0 +1,115 @@
+package exchange
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexAnnouncer(t *testing.T) {
+	newIndex := func() *Index {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		ms, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		idx, err := NewIndex(ds, ms)
+		require.NoError(t, err)
+		return idx
+	}
+	idx := newIndex()
+	ann := &IndexAnnouncer{
+		idx:           idx,
+		snapshotEvery: 3,
+		peers:         make(map[peer.ID]uint64),
+	}
+
+	// The first announcement is a snapshot
+	msg, ok := ann.next()
+	require.True(t, ok)
+	require.True(t, msg.Snapshot)
+	require.Nil(t, msg.Root)
+	ann.count++
+
+	// Nothing to announce until the index changes
+	_, ok = ann.next()
+	require.False(t, ok)
+
+	ref1 := &DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 10}
+	ref2 := &DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 10}
+	require.NoError(t, idx.SetRef(ref1))
+	require.NoError(t, idx.SetRef(ref2))
+	require.NoError(t, idx.DropRef(ref1.PayloadCID))
+
+	msg, ok = ann.next()
+	require.True(t, ok)
+	require.False(t, msg.Snapshot)
+	require.Equal(t, uint64(3), msg.Seq)
+	require.Equal(t, uint64(0), msg.Base)
+	require.Len(t, msg.Added, 1)
+	require.Equal(t, ref2.PayloadCID, msg.Added[0].PayloadCID)
+	require.Equal(t, []cid.Cid{ref1.PayloadCID}, msg.Removed)
+	require.Equal(t, idx.Root(), *msg.Root)
+
+	// Announcements survive the round trip
+	buf := new(bytes.Buffer)
+	require.NoError(t, msg.MarshalCBOR(buf))
+	var dec IndexAnnouncement
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.Equal(t, msg.Seq, dec.Seq)
+	require.Equal(t, msg.Added[0].PayloadCID, dec.Added[0].PayloadCID)
+	require.Equal(t, msg.Removed, dec.Removed)
+	ann.last = msg.Seq
+	ann.count++
+
+	ref3 := &DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 10}
+	require.NoError(t, idx.SetRef(ref3))
+	msg, ok = ann.next()
+	require.True(t, ok)
+	require.False(t, msg.Snapshot)
+	require.Equal(t, uint64(3), msg.Base)
+	require.Len(t, msg.Added, 1)
+	ann.last = msg.Seq
+	ann.count++
+
+	ref4 := &DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 10}
+	require.NoError(t, idx.SetRef(ref4))
+
+	// Every few announcements a snapshot is sent instead
+	msg, ok = ann.next()
+	require.True(t, ok)
+	require.True(t, msg.Snapshot)
+	require.Nil(t, msg.Added)
+
+	// Peers apply diffs to their interest list and fetch the full index when they miss one
+	var fetched []cid.Cid
+	other := newIndex()
+	rcv := &IndexAnnouncer{
+		idx:   other,
+		peers: make(map[peer.ID]uint64),
+		fetch: func(_ peer.ID, root cid.Cid) {
+			fetched = append(fetched, root)
+		},
+	}
+	p := peer.ID("provider")
+	root := idx.Root()
+	rcv.Handle(p, IndexAnnouncement{Seq: 3, Snapshot: true, Root: &root})
+	require.Len(t, fetched, 1)
+
+	rcv.Handle(p, IndexAnnouncement{Seq: 4, Base: 3, Root: &root, Added: []DataRef{*ref3}})
+	require.Len(t, fetched, 1)
+	require.Equal(t, 1, other.InterestLen())
+
+	// A snapshot matching what we have is ignored
+	rcv.Handle(p, IndexAnnouncement{Seq: 4, Snapshot: true, Root: &root})
+	require.Len(t, fetched, 1)
+
+	// A missing diff requires fetching the full index
+	rcv.Handle(p, IndexAnnouncement{Seq: 6, Base: 5, Root: &root, Added: []DataRef{*ref4}})
+	require.Len(t, fetched, 2)
+	require.Equal(t, 1, other.InterestLen())
+}
@@ -0,0 +1,81 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+// KArchives is the datastore key prefix under which the storage deals archiving our roots are persisted
+const KArchives = "archives"
+
+// ErrArchiveNotFound is returned when a root wasn't archived on Filecoin
+var ErrArchiveNotFound = errors.New("archive not found")
+
+// ArchiveDeal is a storage deal made with a miner to archive a root
+type ArchiveDeal struct {
+	Miner    address.Address
+	Proposal cid.Cid
+}
+
+// Archive records the storage deals keeping a root on Filecoin. It is kept after the root is evicted
+// so the content can be restored from the miners.
+type Archive struct {
+	Root        cid.Cid
+	PieceCID    cid.Cid
+	PieceSize   abi.PaddedPieceSize
+	PayloadSize int64
+	Deals       []ArchiveDeal
+	Created     time.Time
+	Duration    time.Duration
+}
+
+// Miners returns the addresses of the miners archiving the root
+func (a *Archive) Miners() []address.Address {
+	miners := make([]address.Address, len(a.Deals))
+	for i, d := range a.Deals {
+		miners[i] = d.Miner
+	}
+	return miners
+}
+
+func archiveKey(k cid.Cid) datastore.Key {
+	return datastore.NewKey(KArchives).ChildString(k.String())
+}
+
+// PutArchive persists the storage deals of a root next to its ref. Deals made later for the same root
+// are added to the previous ones.
+func (idx *Index) PutArchive(a *Archive) error {
+	prev, err := idx.GetArchive(a.Root)
+	if err == nil {
+		a.Deals = append(prev.Deals, a.Deals...)
+	} else if !errors.Is(err, ErrArchiveNotFound) {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return idx.ds.Put(archiveKey(a.Root), data)
+}
+
+// GetArchive returns the storage deals archiving a given root
+func (idx *Index) GetArchive(k cid.Cid) (*Archive, error) {
+	data, err := idx.ds.Get(archiveKey(k))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := new(Archive)
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
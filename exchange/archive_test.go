@@ -0,0 +1,58 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	root := blockGen.Next().Cid()
+	_, err = idx.GetArchive(root)
+	require.True(t, errors.Is(err, ErrArchiveNotFound))
+
+	m1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	require.NoError(t, idx.PutArchive(&Archive{
+		Root:        root,
+		PayloadSize: 1024,
+		Deals:       []ArchiveDeal{{Miner: m1, Proposal: blockGen.Next().Cid()}},
+		Created:     time.Now(),
+		Duration:    24 * time.Hour,
+	}))
+	// Deals made later are added to the previous ones
+	require.NoError(t, idx.PutArchive(&Archive{
+		Root:  root,
+		Deals: []ArchiveDeal{{Miner: m2, Proposal: blockGen.Next().Cid()}},
+	}))
+
+	// The archive outlives the ref so the content can be restored
+	a, err := idx.GetArchive(root)
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{m1, m2}, a.Miners())
+}
+
+func TestListStorageMiners(t *testing.T) {
+	e := &Exchange{opts: Options{StorageMiners: []string{"f01000"}, Regions: []Region{global}}}
+	miners, err := e.ListMiners(context.Background())
+	require.NoError(t, err)
+	m, err := address.NewFromString("f01000")
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{m}, miners)
+}
@@ -0,0 +1,315 @@
+package exchange
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+)
+
+// KAudit is the datastore namespace under which the audit log of served retrievals is persisted
+const KAudit = "/audit"
+
+// ErrAuditTampered is returned when an entry of the audit log doesn't match the hash chain
+var ErrAuditTampered = errors.New("audit log tampered")
+
+// AuditEntry records a retrieval we served. Each entry includes the hash of the previous one
+// so editing, removing or reordering entries breaks the chain.
+type AuditEntry struct {
+	Seq uint64 `json:"seq"`
+	// Time is when the deal ended in unix nanoseconds
+	Time int64  `json:"time"`
+	Peer string `json:"peer"`
+	Root string `json:"root"`
+	Deal uint64 `json:"deal"`
+	// Bytes is the number of bytes sent to the requester
+	Bytes uint64 `json:"bytes"`
+	// PricePerByte and Paid are amounts in attoFIL
+	PricePerByte string `json:"pricePerByte"`
+	Paid         string `json:"paid"`
+	// Vouchers is the number of payment vouchers received
+	Vouchers int    `json:"vouchers"`
+	Status   string `json:"status"`
+	// Prev is the hash of the previous entry, empty for the first one
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// digest hashes the JSON encoding of the entry without its own hash
+func (e AuditEntry) digest() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditQuery filters the entries of the audit log. Zero values match everything.
+type AuditQuery struct {
+	Peer  peer.ID
+	Root  cid.Cid
+	Since time.Time
+	Until time.Time
+	// Limit is the maximum number of entries returned, the most recent ones are kept
+	Limit int
+}
+
+func (q AuditQuery) match(e AuditEntry) bool {
+	switch {
+	case q.Peer != "" && e.Peer != q.Peer.String():
+		return false
+	case q.Root.Defined() && e.Root != q.Root.String():
+		return false
+	case !q.Since.IsZero() && e.Time < q.Since.UnixNano():
+		return false
+	case !q.Until.IsZero() && e.Time > q.Until.UnixNano():
+		return false
+	}
+	return true
+}
+
+// auditChain checks entries follow each other in the hash chain
+type auditChain struct {
+	seq  uint64
+	head string
+}
+
+func (c *auditChain) next(e AuditEntry) error {
+	if e.Seq != c.seq+1 {
+		return fmt.Errorf("%w: expected entry %d, got %d", ErrAuditTampered, c.seq+1, e.Seq)
+	}
+	if e.Prev != c.head {
+		return fmt.Errorf("%w: entry %d does not follow the previous entry", ErrAuditTampered, e.Seq)
+	}
+	h, err := e.digest()
+	if err != nil {
+		return err
+	}
+	if h != e.Hash {
+		return fmt.Errorf("%w: entry %d does not match its hash", ErrAuditTampered, e.Seq)
+	}
+	c.seq = e.Seq
+	c.head = e.Hash
+	return nil
+}
+
+// AuditLog is an append only log of every retrieval we served. Operators can query it to resolve
+// payment disputes or find abusive peers and export it for a third party to verify.
+type AuditLog struct {
+	ds datastore.Batching
+
+	mu    sync.Mutex
+	chain auditChain
+	// vouchers counts the payments received for the deals being served
+	vouchers map[deal.ProviderDealIdentifier]int
+}
+
+// NewAuditLog loads the head of the audit log persisted in the datastore
+func NewAuditLog(ds datastore.Batching) (*AuditLog, error) {
+	al := &AuditLog{
+		ds:       namespace.Wrap(ds, datastore.NewKey(KAudit)),
+		vouchers: make(map[deal.ProviderDealIdentifier]int),
+	}
+	res, err := al.ds.Query(dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKeyDescending{}},
+		Limit:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			return nil, err
+		}
+		al.chain = auditChain{seq: e.Seq, head: e.Hash}
+	}
+	return al, nil
+}
+
+// auditKey pads the sequence number so entries are sorted in order
+func auditKey(seq uint64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%020d", seq))
+}
+
+// Append links an entry to the head of the log and persists it
+func (al *AuditLog) Append(e AuditEntry) (AuditEntry, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	e.Seq = al.chain.seq + 1
+	e.Prev = al.chain.head
+	h, err := e.digest()
+	if err != nil {
+		return e, err
+	}
+	e.Hash = h
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	if err := al.ds.Put(auditKey(e.Seq), b); err != nil {
+		return e, err
+	}
+	al.chain = auditChain{seq: e.Seq, head: e.Hash}
+	return e, nil
+}
+
+// Head returns the number of entries and the hash of the last one. Publishing the head lets
+// a third party check the log wasn't rewritten since.
+func (al *AuditLog) Head() (uint64, string) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.chain.seq, al.chain.head
+}
+
+// each calls the function with every entry in order until it returns an error
+func (al *AuditLog) each(fn func(AuditEntry) error) error {
+	res, err := al.ds.Query(dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the entries matching the query, oldest first
+func (al *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := al.each(func(e AuditEntry) error {
+		if q.match(e) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// Export writes the whole log as JSON lines
+func (al *AuditLog) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return al.each(func(e AuditEntry) error {
+		return enc.Encode(e)
+	})
+}
+
+// Verify checks the hash chain of the persisted log and returns the number of entries
+func (al *AuditLog) Verify() (uint64, error) {
+	var c auditChain
+	if err := al.each(c.next); err != nil {
+		return c.seq, err
+	}
+	seq, head := al.Head()
+	if c.seq != seq || c.head != head {
+		return c.seq, fmt.Errorf("%w: log was truncated after entry %d", ErrAuditTampered, c.seq)
+	}
+	return c.seq, nil
+}
+
+// VerifyAudit checks the hash chain of an exported log and returns the number of entries and the hash
+// of the last one which should match the head published by the provider
+func VerifyAudit(r io.Reader) (uint64, string, error) {
+	var c auditChain
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return c.seq, c.head, err
+		}
+		if err := c.next(e); err != nil {
+			return c.seq, c.head, err
+		}
+	}
+	return c.seq, c.head, sc.Err()
+}
+
+// recordProviderDeal appends an entry for every deal we served once it reaches a final state
+func (al *AuditLog) recordProviderDeal(event provider.Event, state deal.ProviderState) {
+	id := state.Identifier()
+	al.mu.Lock()
+	n, ok := al.vouchers[id]
+	if event == provider.EventOpen && !ok {
+		al.vouchers[id] = 0
+		ok = true
+	}
+	switch event {
+	case provider.EventPaymentReceived, provider.EventPartialPaymentReceived:
+		if ok {
+			n++
+			al.vouchers[id] = n
+		}
+	}
+	final := false
+	switch state.Status {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored, deal.StatusRejected, deal.StatusDealNotFound:
+		final = ok
+		delete(al.vouchers, id)
+	}
+	al.mu.Unlock()
+	if !final {
+		return
+	}
+
+	paid := state.FundsReceived
+	if paid.Nil() {
+		paid = big.Zero()
+	}
+	ppb := state.PricePerByte
+	if ppb.Nil() {
+		ppb = big.Zero()
+	}
+	_, err := al.Append(AuditEntry{
+		Time:         time.Now().UnixNano(),
+		Peer:         state.Receiver.String(),
+		Root:         state.PayloadCID.String(),
+		Deal:         uint64(state.ID),
+		Bytes:        state.TotalSent,
+		PricePerByte: ppb.String(),
+		Paid:         paid.String(),
+		Vouchers:     n,
+		Status:       deal.Statuses[state.Status],
+	})
+	if err != nil {
+		txLog.Error().Err(err).Msg("failed to append to audit log")
+	}
+}
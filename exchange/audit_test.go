@@ -0,0 +1,124 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	al, err := NewAuditLog(ds)
+	require.NoError(t, err)
+
+	root := blocks.NewBlock([]byte("root")).Cid()
+	state := deal.ProviderState{
+		Proposal: deal.Proposal{
+			PayloadCID: root,
+			ID:         1,
+			Params:     deal.Params{PricePerByte: abi.NewTokenAmount(2)},
+		},
+		Receiver: peer.ID("client"),
+	}
+	// Deals we didn't see open are ignored
+	state.Status = deal.StatusCompleted
+	al.recordProviderDeal(provider.EventComplete, state)
+	seq, _ := al.Head()
+	require.Equal(t, uint64(0), seq)
+
+	state.Status = deal.StatusNew
+	al.recordProviderDeal(provider.EventOpen, state)
+	state.TotalSent = 1000
+	state.FundsReceived = abi.NewTokenAmount(1000)
+	al.recordProviderDeal(provider.EventPartialPaymentReceived, state)
+	state.TotalSent = 2000
+	state.FundsReceived = abi.NewTokenAmount(4000)
+	al.recordProviderDeal(provider.EventPaymentReceived, state)
+	state.Status = deal.StatusCompleted
+	al.recordProviderDeal(provider.EventComplete, state)
+	// Events after the final state don't add entries
+	al.recordProviderDeal(provider.EventCleanupComplete, state)
+
+	other := state
+	other.ID = 2
+	other.Receiver = peer.ID("abuser")
+	other.Status = deal.StatusNew
+	al.recordProviderDeal(provider.EventOpen, other)
+	other.Status = deal.StatusErrored
+	al.recordProviderDeal(provider.EventDataTransferError, other)
+
+	entries, err := al.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, AuditEntry{
+		Seq:          1,
+		Time:         entries[0].Time,
+		Peer:         peer.ID("client").String(),
+		Root:         root.String(),
+		Deal:         1,
+		Bytes:        2000,
+		PricePerByte: "2",
+		Paid:         "4000",
+		Vouchers:     2,
+		Status:       deal.Statuses[deal.StatusCompleted],
+		Hash:         entries[0].Hash,
+	}, entries[0])
+	require.Equal(t, entries[0].Hash, entries[1].Prev)
+
+	entries, err = al.Query(AuditQuery{Peer: peer.ID("abuser")})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(2), entries[0].Seq)
+
+	entries, err = al.Query(AuditQuery{Root: root, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(2), entries[0].Seq)
+
+	n, err := al.Verify()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+
+	// The chain continues after a restart
+	al, err = NewAuditLog(ds)
+	require.NoError(t, err)
+	e, err := al.Append(AuditEntry{Peer: "late"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), e.Seq)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, al.Export(buf))
+	n, head, err := VerifyAudit(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), n)
+	require.Equal(t, e.Hash, head)
+
+	// Editing an entry breaks the chain
+	entries, err = al.Query(AuditQuery{})
+	require.NoError(t, err)
+	edited := entries[1]
+	edited.Paid = "0"
+	b, err := json.Marshal(edited)
+	require.NoError(t, err)
+	require.NoError(t, al.ds.Put(auditKey(2), b))
+	_, err = al.Verify()
+	require.True(t, errors.Is(err, ErrAuditTampered))
+
+	// So does removing the last entry
+	b, err = json.Marshal(entries[1])
+	require.NoError(t, err)
+	require.NoError(t, al.ds.Put(auditKey(2), b))
+	require.NoError(t, al.ds.Delete(auditKey(3)))
+	_, err = al.Verify()
+	require.True(t, errors.Is(err, ErrAuditTampered))
+}
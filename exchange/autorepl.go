@@ -0,0 +1,178 @@
+package exchange
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultMaxReplications is the default number of content retrievals the auto replication runs at once
+const DefaultMaxReplications = 2
+
+// AutoReplicator retrieves the most popular content from the interest list in the background whenever
+// the index is read. Retrievals only start if we have enough storage available, they are paced to
+// stay within a bandwidth budget and a limited number of them can run at the same time.
+type AutoReplicator struct {
+	idx *Index
+	rtv RoutedRetriever
+	// bandwidth is the average number of bytes per second we allow retrieving, 0 is unlimited
+	bandwidth uint64
+	// sem caps the number of concurrent retrievals
+	sem chan struct{}
+	// retrieved is called after content is retrieved and added to the index
+	retrieved func(cid.Cid)
+	// market decides whether the content announced by other providers is worth hosting
+	market HostingMarket
+	wake   chan struct{}
+
+	mu sync.Mutex
+	// ongoing tracks the size of the content being retrieved
+	ongoing map[cid.Cid]uint64
+	// next is the earliest time the bandwidth budget allows a new retrieval
+	next time.Time
+}
+
+// NewAutoReplicator creates a new AutoReplicator with a bandwidth budget in bytes per second
+// and a maximum number of concurrent retrievals
+func NewAutoReplicator(idx *Index, rtv RoutedRetriever, bandwidth uint64, maxTransfers int) *AutoReplicator {
+	if maxTransfers <= 0 {
+		maxTransfers = DefaultMaxReplications
+	}
+	return &AutoReplicator{
+		idx:       idx,
+		rtv:       rtv,
+		bandwidth: bandwidth,
+		sem:       make(chan struct{}, maxTransfers),
+		wake:      make(chan struct{}, 1),
+		ongoing:   make(map[cid.Cid]uint64),
+	}
+}
+
+// Trigger asks the replicator to check if any content is worth retrieving. It never blocks so it can
+// be called after every index read.
+func (ar *AutoReplicator) Trigger() {
+	select {
+	case ar.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts retrievals every time the replicator is triggered until the context is cancelled
+func (ar *AutoReplicator) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ar.wake:
+			ar.replicate(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ongoing returns the number of retrievals currently running
+func (ar *AutoReplicator) Ongoing() int {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	return len(ar.ongoing)
+}
+
+func (ar *AutoReplicator) replicate(ctx context.Context) {
+	for _, ref := range ar.candidates() {
+		select {
+		case ar.sem <- struct{}{}:
+		default:
+			// Completed retrievals trigger a new round
+			return
+		}
+		if !bid(ctx, ar.market, HostingRequest{
+			Root:   ref.PayloadCID,
+			Size:   uint64(ref.PayloadSize),
+			Source: Announced,
+			Freq:   ref.Freq,
+		}) {
+			<-ar.sem
+			continue
+		}
+		wait, ok := ar.reserve(ref)
+		if !ok {
+			<-ar.sem
+			if wait > 0 {
+				// Try again once the bandwidth budget allows it
+				time.AfterFunc(wait, ar.Trigger)
+				return
+			}
+			continue
+		}
+		go ar.retrieve(ctx, ref)
+	}
+}
+
+// candidates returns the interesting refs, most popular first
+func (ar *AutoReplicator) candidates() []*DataRef {
+	refs, err := ar.idx.Interesting()
+	if err != nil {
+		return nil
+	}
+	list := make([]*DataRef, 0, len(refs))
+	for ref := range refs {
+		list = append(list, ref)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Freq > list[j].Freq
+	})
+	return list
+}
+
+// reserve accounts for a new retrieval if it fits in the available storage and the bandwidth budget.
+// It returns how long to wait if the budget is exhausted.
+func (ar *AutoReplicator) reserve(ref *DataRef) (time.Duration, bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if _, ok := ar.ongoing[ref.PayloadCID]; ok {
+		return 0, false
+	}
+	size := uint64(ref.PayloadSize)
+	pending := uint64(0)
+	for _, s := range ar.ongoing {
+		pending += s
+	}
+	// We don't replicate content if it means evicting something else
+	if ar.idx.Available() < pending+size {
+		return 0, false
+	}
+	if ar.bandwidth > 0 {
+		now := time.Now()
+		if wait := ar.next.Sub(now); wait > 0 {
+			return wait, false
+		}
+		ar.next = now.Add(time.Duration(float64(size) / float64(ar.bandwidth) * float64(time.Second)))
+	}
+	ar.ongoing[ref.PayloadCID] = size
+	return 0, true
+}
+
+func (ar *AutoReplicator) retrieve(ctx context.Context, ref *DataRef) {
+	err := ar.rtv.FindAndRetrieve(ctx, ref.PayloadCID)
+
+	ar.mu.Lock()
+	delete(ar.ongoing, ref.PayloadCID)
+	ar.mu.Unlock()
+	<-ar.sem
+
+	if err != nil {
+		// Failed retrievals are tried again on the next trigger
+		txLog.Warn().Err(err).Str("root", ref.PayloadCID.String()).Msg("failed to replicate")
+		return
+	}
+	if err := ar.idx.DropInterest(ref.PayloadCID); err != nil {
+		indexLog.Error().Err(err).Str("root", ref.PayloadCID.String()).Msg("failed to drop interest")
+	}
+	if ar.retrieved != nil {
+		ar.retrieved(ref.PayloadCID)
+	}
+	// We may have skipped refs while all the slots were taken
+	ar.Trigger()
+}
@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRetriever adds the content to the index once a retrieval is released
+type blockingRetriever struct {
+	idx     *Index
+	sizes   map[cid.Cid]int64
+	started chan cid.Cid
+	release chan struct{}
+}
+
+func (br blockingRetriever) FindAndRetrieve(ctx context.Context, root cid.Cid) error {
+	br.started <- root
+	select {
+	case <-br.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return br.idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: br.sizes[root],
+	})
+}
+
+func TestAutoReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 8000))
+	require.NoError(t, err)
+
+	rtv := blockingRetriever{
+		idx:     idx,
+		sizes:   make(map[cid.Cid]int64),
+		started: make(chan cid.Cid, 4),
+		release: make(chan struct{}),
+	}
+	// The largest ref doesn't fit in our storage
+	var refs []*DataRef
+	for i, size := range []int64{20000, 1000, 1000, 1000} {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: size,
+			Freq:        int64(i + 1),
+		}
+		rtv.sizes[ref.PayloadCID] = size
+		refs = append(refs, ref)
+		idx.imu.Lock()
+		idx.addInterest(ref.PayloadCID.String(), ref)
+		idx.imu.Unlock()
+	}
+
+	ar := NewAutoReplicator(idx, rtv, 0, 2)
+	go ar.Run(ctx)
+	ar.Trigger()
+
+	// The most popular refs are retrieved first
+	require.Equal(t, refs[3].PayloadCID, <-rtv.started)
+	require.Equal(t, refs[2].PayloadCID, <-rtv.started)
+	require.Equal(t, 2, ar.Ongoing())
+
+	// No more than 2 retrievals run at the same time
+	ar.Trigger()
+	select {
+	case <-rtv.started:
+		t.Fatal("too many concurrent retrievals")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Completing a retrieval starts the next one
+	rtv.release <- struct{}{}
+	require.Equal(t, refs[1].PayloadCID, <-rtv.started)
+	rtv.release <- struct{}{}
+	rtv.release <- struct{}{}
+
+	require.Eventually(t, func() bool {
+		return ar.Ongoing() == 0 && idx.Len() == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, idx.InterestLen())
+	_, err = idx.PeekRef(refs[0].PayloadCID)
+	require.Error(t, err)
+
+	// Retrievals are paced to stay within the bandwidth budget
+	ar = NewAutoReplicator(idx, rtv, 1000, 2)
+	wait, ok := ar.reserve(&DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 1000})
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), wait)
+	wait, ok = ar.reserve(&DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 1000})
+	require.False(t, ok)
+	require.True(t, wait > 0 && wait <= time.Second)
+}
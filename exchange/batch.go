@@ -0,0 +1,195 @@
+package exchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// WithFlushInterval persists the HAMT root at most once per interval instead of after every change so
+// hot read paths don't write the root on each read. Refs added or dropped since the last flush are
+// recovered from the log if the node stops abruptly but read frequencies and metadata updates are lost;
+// use Sync to persist them before shutting down.
+func WithFlushInterval(d time.Duration) IndexOption {
+	return func(idx *Index) {
+		idx.flushInterval = d
+	}
+}
+
+// IndexTx stages ref mutations which are applied at once by Index.Update
+type IndexTx struct {
+	idx *Index
+	ops []indexOp
+	// dropped are the refs whose content is deleted once the whole batch is applied
+	dropped []*DataRef
+}
+
+// indexOp is a staged mutation of the ref under a key
+type indexOp struct {
+	key   cid.Cid
+	apply func() error
+}
+
+// SetRef stages adding a ref to the index
+func (tx *IndexTx) SetRef(ref *DataRef) {
+	tx.ops = append(tx.ops, indexOp{key: ref.PayloadCID, apply: func() error {
+		return tx.idx.setRef(ref)
+	}})
+}
+
+// DropRef stages removing a ref and its content from the index
+func (tx *IndexTx) DropRef(k cid.Cid) {
+	tx.ops = append(tx.ops, indexOp{key: k, apply: func() error {
+		ref, err := tx.idx.unindexRef(k)
+		if err != nil {
+			return err
+		}
+		if ref != nil {
+			tx.dropped = append(tx.dropped, ref)
+		}
+		return nil
+	}})
+}
+
+// UpdateRef stages changes to the metadata of a ref
+func (tx *IndexTx) UpdateRef(k cid.Cid, fn func(*DataRef)) {
+	tx.ops = append(tx.ops, indexOp{key: k, apply: func() error {
+		return tx.idx.updateRef(k, fn)
+	}})
+}
+
+// Update applies the mutations staged by fn while holding the lock once and persists the HAMT root a
+// single time. Nothing is applied if fn returns an error. Mutations are applied in order and if one fails
+// the refs touched by the batch are reverted to what they were before it, content evicted to make room
+// for new refs stays evicted. The content of dropped refs is only deleted once every mutation applied.
+func (idx *Index) Update(fn func(tx *IndexTx) error) error {
+	tx := &IndexTx{idx: idx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// The refs as they were before the batch, nil if they weren't indexed
+	prev := make(map[cid.Cid]*DataRef)
+	var keys []cid.Cid
+	for _, op := range tx.ops {
+		if _, ok := prev[op.key]; !ok {
+			prev[op.key] = nil
+			if ref, ok := idx.lookup(op.key.String()); ok {
+				prev[op.key] = copyRef(ref)
+			}
+			keys = append(keys, op.key)
+		}
+		if err := op.apply(); err != nil {
+			idx.rollback(keys, prev)
+			return err
+		}
+	}
+	if err := idx.commit(); err != nil {
+		return err
+	}
+	for _, ref := range tx.dropped {
+		// The batch may have added the ref again with the same content
+		if cur, ok := idx.Refs[ref.PayloadCID.String()]; ok && cur.StoreID == ref.StoreID {
+			continue
+		}
+		if err := idx.deleteContent(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback reverts the refs touched by a failed batch, callers must hold the lock
+func (idx *Index) rollback(keys []cid.Cid, prev map[cid.Cid]*DataRef) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := idx.revertRef(keys[i], prev[keys[i]]); err != nil {
+			indexLog.Error().Err(err).Str("root", keys[i].String()).Msg("failed to revert ref")
+		}
+	}
+	// Persist the reverted root right away so the log doesn't replay the reverted mutations
+	if err := idx.Flush(); err != nil {
+		indexLog.Error().Err(err).Msg("failed to flush index")
+	}
+}
+
+// revertRef puts a ref back as it was or removes it if prev is nil, callers must hold the lock
+func (idx *Index) revertRef(k cid.Cid, prev *DataRef) error {
+	if cur, ok := idx.Refs[k.String()]; ok {
+		if cur.bucketNode != nil {
+			idx.remBlistEntry(cur.bucketNode, cur)
+			cur.bucketNode = nil
+		}
+		idx.removeUsage(cur)
+		idx.size -= idx.refSize(cur)
+		delete(idx.Refs, k.String())
+	}
+	if prev == nil {
+		if _, err := idx.root.Delete(context.TODO(), k.String()); err != nil {
+			return err
+		}
+		idx.forget(k.String())
+	} else {
+		if err := idx.root.Set(context.TODO(), k.String(), prev); err != nil {
+			return err
+		}
+		delete(idx.removed, k.String())
+		idx.load(prev)
+	}
+	idx.recordChange(k, prev)
+	idx.updateMetrics()
+	idx.invalidateSearch()
+	return nil
+}
+
+// copyRef returns a copy of a ref which the mutations of the original don't change
+func copyRef(ref *DataRef) *DataRef {
+	cp := *ref
+	cp.bucketNode = nil
+	if ref.Keys != nil {
+		cp.Keys = append([]string{}, ref.Keys...)
+	}
+	if ref.Labels != nil {
+		cp.Labels = make(map[string]string, len(ref.Labels))
+		for k, v := range ref.Labels {
+			cp.Labels[k] = v
+		}
+	}
+	return &cp
+}
+
+// Sync persists the changes waiting for the next flush if any
+func (idx *Index) Sync() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return nil
+	}
+	return idx.Flush()
+}
+
+// commit persists the HAMT root or schedules a flush if a flush interval is set, callers must hold the lock
+func (idx *Index) commit() error {
+	if idx.flushInterval <= 0 {
+		return idx.Flush()
+	}
+	idx.dirty = true
+	if idx.flushTimer == nil {
+		idx.flushTimer = time.AfterFunc(idx.flushInterval, func() {
+			idx.mu.Lock()
+			defer idx.mu.Unlock()
+			idx.flushTimer = nil
+			if !idx.dirty {
+				return
+			}
+			if err := idx.Flush(); err != nil {
+				indexLog.Error().Err(err).Msg("failed to flush index")
+			}
+		})
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexUpdate(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	var refs []*DataRef
+	for i := 0; i < 3; i++ {
+		refs = append(refs, &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 100,
+		})
+	}
+
+	// Nothing is applied if the batch fails
+	errAbort := errors.New("abort")
+	err = idx.Update(func(tx *IndexTx) error {
+		tx.SetRef(refs[0])
+		return errAbort
+	})
+	require.True(t, errors.Is(err, errAbort))
+	require.Equal(t, 0, idx.Len())
+
+	require.NoError(t, idx.Update(func(tx *IndexTx) error {
+		for _, ref := range refs {
+			tx.SetRef(ref)
+		}
+		tx.UpdateRef(refs[1].PayloadCID, func(ref *DataRef) {
+			ref.Keys = []string{"hello.txt"}
+		})
+		tx.DropRef(refs[2].PayloadCID)
+		return nil
+	}))
+	require.Equal(t, 2, idx.Len())
+
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 2, idx.Len())
+	ref, err := idx.PeekRef(refs[1].PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello.txt"}, ref.Keys)
+}
+
+func TestIndexUpdateRollback(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	var refs []*DataRef
+	for i := 0; i < 3; i++ {
+		id := ms.Next()
+		_, err := ms.Get(id)
+		require.NoError(t, err)
+		refs = append(refs, &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 100,
+			StoreID:     id,
+		})
+	}
+	require.NoError(t, idx.SetRef(refs[0]))
+	require.NoError(t, idx.SetRef(refs[1]))
+
+	// The last mutation fails so the ones applied before it are reverted
+	err = idx.Update(func(tx *IndexTx) error {
+		tx.SetRef(refs[2])
+		tx.UpdateRef(refs[0].PayloadCID, func(ref *DataRef) {
+			ref.Keys = []string{"hello.txt"}
+		})
+		tx.DropRef(refs[1].PayloadCID)
+		tx.DropRef(blockGen.Next().Cid())
+		return nil
+	})
+	require.True(t, errors.Is(err, ErrRefNotFound))
+
+	check := func(idx *Index) {
+		require.Equal(t, 2, idx.Len())
+		_, err := idx.PeekRef(refs[2].PayloadCID)
+		require.True(t, errors.Is(err, ErrRefNotFound))
+		ref, err := idx.PeekRef(refs[0].PayloadCID)
+		require.NoError(t, err)
+		require.Empty(t, ref.Keys)
+		_, err = idx.PeekRef(refs[1].PayloadCID)
+		require.NoError(t, err)
+	}
+	check(idx)
+	// The content of the ref which was dropped then restored is still there
+	require.Contains(t, ms.List(), refs[1].StoreID)
+	require.Equal(t, uint64(200), idx.size)
+
+	// The reverted root is persisted
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	check(idx)
+}
+
+func TestIndexFlushInterval(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+	}))
+
+	// The change isn't persisted yet
+	reloaded, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 0, reloaded.Len())
+
+	require.NoError(t, idx.Sync())
+	reloaded, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 1, reloaded.Len())
+
+	// Changes are flushed once the interval elapses
+	idx, err = NewIndex(ds, ms, WithFlushInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+	}))
+	require.Eventually(t, func() bool {
+		reloaded, err := NewIndex(ds, ms)
+		require.NoError(t, err)
+		return reloaded.Len() == 2
+	}, time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-bitswap"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/myelnet/pop/selectors"
+)
+
+// KBitswap is the datastore namespace of the blocks received over Bitswap before they are moved to
+// the store of a transaction
+const KBitswap = "/bitswap"
+
+// DefaultBitswapFallbackDelay is how long a transaction waits for an offer before retrieving the content
+// over Bitswap
+const DefaultBitswapFallbackDelay = 10 * time.Second
+
+type blockGetter interface {
+	GetBlock(context.Context, cid.Cid) (blocks.Block, error)
+}
+
+// BitswapFallback retrieves content from the public IPFS network over Bitswap when no pop provider
+// offers to serve it. Retrievals over Bitswap are free and don't go through the retrieval market.
+type BitswapFallback struct {
+	bs     blockGetter
+	bstore blockstore.Blockstore
+	delay  time.Duration
+}
+
+// NewBitswapFallback starts a Bitswap client on the host finding the providers of blocks with the content
+// routing. Only the peers we are connected to are asked if the content routing is nil.
+func NewBitswapFallback(ctx context.Context, h host.Host, cr routing.ContentRouting, ds datastore.Batching) *BitswapFallback {
+	if cr == nil {
+		cr = nullRouting{}
+	}
+	bstore := blockstore.NewBlockstore(namespace.Wrap(ds, datastore.NewKey(KBitswap)))
+	// We don't keep the blocks in the Bitswap store so they shouldn't be advertised
+	bs := bitswap.New(ctx, bsnet.NewFromIpfsHost(h, cr), bstore, bitswap.ProvideEnabled(false))
+	return &BitswapFallback{
+		bs:     bs,
+		bstore: bstore,
+		delay:  DefaultBitswapFallbackDelay,
+	}
+}
+
+// Fetch retrieves the blocks of a DAG reached by a selector into a blockstore and returns the number
+// of bytes received
+func (f *BitswapFallback) Fetch(ctx context.Context, to blockstore.Blockstore, root cid.Cid, sel ipld.Node) (uint64, error) {
+	var size uint64
+	err := fetchDAG(ctx, func(c cid.Cid) (blocks.Block, error) {
+		if blk, err := to.Get(c); err == nil {
+			return blk, nil
+		}
+		blk, err := f.bs.GetBlock(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		size += uint64(len(blk.RawData()))
+		// Bitswap keeps the blocks it receives but they only need to be in the destination store
+		if err := f.bstore.DeleteBlock(c); err != nil {
+			txLog.Warn().Err(err).Str("block", c.String()).Msg("failed to delete bitswap block")
+		}
+		return blk, nil
+	}, to, root, sel)
+	return size, err
+}
+
+// WithBitswapFallback retrieves the content over Bitswap if no provider offered to serve it after the
+// fallback delay
+func WithBitswapFallback(f *BitswapFallback) TxOption {
+	return func(tx *Tx) {
+		tx.bitswap = f
+	}
+}
+
+// fallbackBitswap retrieves the content over Bitswap if we received no offer after the fallback delay.
+// The content is registered in the index if the whole DAG was retrieved so we can serve it to other peers.
+func (tx *Tx) fallbackBitswap() {
+	timer := time.NewTimer(tx.bitswap.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-tx.ctx.Done():
+		return
+	}
+	if atomic.LoadInt32(&tx.offers) > 0 {
+		return
+	}
+	size, err := tx.bitswap.Fetch(tx.ctx, tx.store.Bstore, tx.root, tx.sel)
+	if err != nil {
+		txLog.Warn().Err(err).Str("root", tx.root.String()).Msg("failed to retrieve over bitswap")
+		return
+	}
+	if stats, err := Stat(tx.ctx, tx.store, tx.root, selectors.All()); err == nil {
+		err = tx.index.SetRef(&DataRef{
+			PayloadCID:  tx.root,
+			StoreID:     tx.storeID,
+			PayloadSize: int64(stats.Size),
+		})
+		if err != nil {
+			indexLog.Error().Err(err).Str("root", tx.root.String()).Msg("failed to index content retrieved over bitswap")
+		}
+	}
+	select {
+	case tx.done <- TxResult{Size: size, Spent: big.Zero()}:
+	default:
+	}
+}
+
+// nullRouting finds no providers so Bitswap only asks the peers we are connected to
+type nullRouting struct{}
+
+func (nullRouting) Provide(context.Context, cid.Cid, bool) error {
+	return nil
+}
+
+func (nullRouting) FindProvidersAsync(context.Context, cid.Cid, int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
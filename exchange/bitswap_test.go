@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// mockBitswap gets blocks from a remote blockstore and keeps them in its own like Bitswap does
+type mockBitswap struct {
+	remote blockstore.Blockstore
+	local  blockstore.Blockstore
+}
+
+func (b mockBitswap) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := b.remote.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	return blk, b.local.Put(blk)
+}
+
+func TestBitswapFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	// The content is only available on the IPFS network
+	p := n.CreateRandomFile(t, 64000)
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	remote := exch.Tx(ctx)
+	require.NoError(t, remote.PutFile(p))
+	root := remote.Root()
+	defer remote.Close()
+
+	local := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	f := &BitswapFallback{
+		bs:     mockBitswap{remote: remote.Store().Bstore, local: local},
+		bstore: local,
+		delay:  100 * time.Millisecond,
+	}
+
+	tx := exch.Tx(ctx, WithRoot(root), WithStrategy(SelectFirst), WithBitswapFallback(f))
+	defer tx.Close()
+	require.NoError(t, tx.Query(nil))
+	select {
+	case res := <-tx.Done():
+		require.NoError(t, res.Err)
+		require.NotZero(t, res.Size)
+	case <-ctx.Done():
+		t.Fatal("content was not retrieved over bitswap")
+	}
+
+	got, err := tx.GetFile(FileKey(p).String())
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(got.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, data, b)
+
+	// The content is indexed so we can serve it
+	ref, err := exch.Index().PeekRef(root)
+	require.NoError(t, err)
+	require.Equal(t, tx.StoreID(), ref.StoreID)
+
+	// The blocks aren't kept in the bitswap store
+	has, err := local.Has(root)
+	require.NoError(t, err)
+	require.False(t, has)
+}
@@ -0,0 +1,58 @@
+package exchange
+
+import (
+	"sync"
+
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// txDeals tracks the deals started by a transaction so the ones still running are cancelled when
+// the transaction is closed instead of transferring and paying in the background
+type txDeals struct {
+	mu      sync.Mutex
+	started map[deal.ID]bool
+	// ended records the deals which reached a final status, possibly before being started
+	ended map[deal.ID]bool
+}
+
+func newTxDeals() *txDeals {
+	return &txDeals{
+		started: make(map[deal.ID]bool),
+		ended:   make(map[deal.ID]bool),
+	}
+}
+
+// start records a deal executed by the transaction
+func (d *txDeals) start(id deal.ID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.started[id] = true
+}
+
+// observe records the deals reaching a final status
+func (d *txDeals) observe(state deal.ClientState) {
+	switch state.Status {
+	case deal.StatusCompleted,
+		deal.StatusCancelled,
+		deal.StatusErrored,
+		deal.StatusRejected,
+		deal.StatusDealNotFound:
+		d.mu.Lock()
+		d.ended[state.ID] = true
+		d.mu.Unlock()
+	}
+}
+
+// running returns the deals started which didn't end yet and forgets them
+func (d *txDeals) running() []deal.ID {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ids []deal.ID
+	for id := range d.started {
+		if !d.ended[id] {
+			ids = append(ids, id)
+		}
+	}
+	d.started = make(map[deal.ID]bool)
+	return ids
+}
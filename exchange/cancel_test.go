@@ -0,0 +1,23 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxDeals(t *testing.T) {
+	d := newTxDeals()
+
+	// A deal may complete before its ID is returned to the transaction
+	d.observe(deal.ClientState{Proposal: deal.Proposal{ID: 1}, Status: deal.StatusCompleted})
+	d.start(1)
+	d.start(2)
+	d.start(3)
+	d.observe(deal.ClientState{Proposal: deal.Proposal{ID: 2}, Status: deal.StatusOngoing})
+	d.observe(deal.ClientState{Proposal: deal.Proposal{ID: 3}, Status: deal.StatusErrored})
+
+	require.Equal(t, []deal.ID{2}, d.running())
+	require.Empty(t, d.running())
+}
@@ -0,0 +1,111 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/myelnet/pop/selectors"
+)
+
+// carV2Pragma is the fixed prefix identifying CARv2 archives
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// carV2HeaderSize is the length of the CARv2 header following the pragma
+const carV2HeaderSize = 40
+
+// carV1Reader returns a reader over a CARv1 payload. CARv2 archives are unwrapped to read the CARv1
+// payload they contain, the index is ignored.
+func carV1Reader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	pragma, err := br.Peek(len(carV2Pragma))
+	if err != nil || !bytes.Equal(pragma, carV2Pragma) {
+		// Not a CARv2, the CARv1 reader will report if it is invalid
+		return br, nil
+	}
+	if _, err := br.Discard(len(carV2Pragma)); err != nil {
+		return nil, err
+	}
+	header := make([]byte, carV2HeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	// The header starts with 16 bytes of characteristics followed by the offset and size of the payload
+	offset := binary.LittleEndian.Uint64(header[16:24])
+	size := binary.LittleEndian.Uint64(header[24:32])
+	read := uint64(len(carV2Pragma) + carV2HeaderSize)
+	if offset < read {
+		return nil, fmt.Errorf("invalid CARv2 data offset %d", offset)
+	}
+	if _, err := io.CopyN(ioutil.Discard, br, int64(offset-read)); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(br, int64(size)), nil
+}
+
+// PutCar imports the blocks of a CARv1 or CARv2 archive into the transaction store without re-chunking
+// them and adds an entry for every root of the archive keyed by the root CID. It returns the roots
+// of the archive.
+func (tx *Tx) PutCar(r io.Reader) ([]cid.Cid, error) {
+	if tx.Err != nil {
+		return nil, tx.Err
+	}
+	cr, err := carV1Reader(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := car.LoadCar(tx.store.Bstore, cr)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.Roots) == 0 {
+		return nil, errors.New("car has no roots")
+	}
+	entries := make([]Entry, len(h.Roots))
+	for i, root := range h.Roots {
+		stats, err := Stat(tx.ctx, tx.store, root, selectors.All())
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = Entry{
+			Key:   root.String(),
+			Value: root,
+			Size:  int64(stats.Size),
+		}
+	}
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
+	for _, e := range entries {
+		tx.entries[e.Key] = e
+	}
+	return h.Roots, tx.buildRoot()
+}
+
+// ExportCar writes a CARv1 of the DAG selected under a root to the writer. The root must be part of
+// the transaction or stored in the index. Blocks are written in traversal order so exporting the same
+// selection always produces the same archive. A nil selector selects the entire DAG.
+func (tx *Tx) ExportCar(root cid.Cid, w io.Writer, sel ipld.Node) error {
+	if sel == nil {
+		sel = selectors.All()
+	}
+	store := tx.store
+	if has, err := store.Bstore.Has(root); err != nil || !has {
+		ref, err := tx.index.PeekRef(root)
+		if err != nil {
+			return err
+		}
+		store, err = tx.index.OpenStore(ref.StoreID)
+		if err != nil {
+			return err
+		}
+	}
+	sc := car.NewSelectiveCar(tx.ctx, store.Bstore, []car.Dag{{Root: root, Selector: sel}})
+	return sc.Write(w)
+}
@@ -0,0 +1,118 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/ipld/go-car"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxPutCar(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	fname := n.CreateRandomFile(t, 128000)
+	link, storeID, origBytes := n.LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	store, err := n.Ms.Get(storeID)
+	require.NoError(t, err)
+
+	v1 := new(bytes.Buffer)
+	require.NoError(t, car.WriteCar(ctx, store.DAG, []cid.Cid{root}, v1))
+
+	// Wrap the same payload in a CARv2 archive with some padding before the payload
+	v2 := new(bytes.Buffer)
+	v2.Write(carV2Pragma)
+	header := make([]byte, carV2HeaderSize)
+	offset := uint64(len(carV2Pragma) + carV2HeaderSize + 8)
+	binary.LittleEndian.PutUint64(header[16:24], offset)
+	binary.LittleEndian.PutUint64(header[24:32], uint64(v1.Len()))
+	v2.Write(header)
+	v2.Write(make([]byte, 8))
+	v2.Write(v1.Bytes())
+
+	for _, archive := range [][]byte{v1.Bytes(), v2.Bytes()} {
+		tx := exch.Tx(ctx)
+		roots, err := tx.PutCar(bytes.NewReader(archive))
+		require.NoError(t, err)
+		require.Equal(t, []cid.Cid{root}, roots)
+
+		status, err := tx.Status()
+		require.NoError(t, err)
+		require.Equal(t, root, status[root.String()].Value)
+		require.NotEqual(t, cid.Undef, tx.Root())
+
+		f, err := tx.GetFile(root.String())
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(f.(files.File))
+		require.NoError(t, err)
+		require.Equal(t, origBytes, got)
+		tx.Close()
+	}
+
+	_, err = exch.Tx(ctx).PutCar(bytes.NewReader([]byte("not a car")))
+	require.Error(t, err)
+}
+
+func TestTxExportCar(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx)
+	f1 := n.CreateRandomFile(t, 64000)
+	f2 := n.CreateRandomFile(t, 32000)
+	require.NoError(t, tx.PutFile(f1))
+	require.NoError(t, tx.PutFile(f2))
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	// Committed content is exported from the index
+	tx = exch.Tx(ctx, WithRoot(root))
+	defer tx.Close()
+	out1 := new(bytes.Buffer)
+	require.NoError(t, tx.ExportCar(root, out1, nil))
+	out2 := new(bytes.Buffer)
+	require.NoError(t, tx.ExportCar(root, out2, nil))
+	require.Equal(t, out1.Bytes(), out2.Bytes())
+
+	// Selecting a single entry exports fewer blocks
+	key := FileKey(f2).String()
+	entry := new(bytes.Buffer)
+	require.NoError(t, tx.ExportCar(root, entry, selectors.Key(key)))
+	require.Less(t, entry.Len(), out1.Len())
+
+	// The archive can be imported back
+	tx2 := exch.Tx(ctx)
+	defer tx2.Close()
+	roots, err := tx2.PutCar(out1)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{root}, roots)
+
+	require.Error(t, tx2.ExportCar(blockGen.Next().Cid(), new(bytes.Buffer), nil))
+}
@@ -0,0 +1,190 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCategoryLabel is the label of a ref holding the category its quota is counted in
+const DefaultCategoryLabel = "category"
+
+// OtherCategory holds the content without a category or whose category has no quota
+const OtherCategory = "other"
+
+// ErrInvalidQuota is returned when category quotas are not fractions of the capacity adding up to at most 1
+var ErrInvalidQuota = errors.New("category quotas must be between 0 and 1 and add up to at most 1")
+
+// CategoryUsage reports how much of its partition of the cache a category uses
+type CategoryUsage struct {
+	Category string
+	// Quota is the fraction of the capacity the category can use
+	Quota float64
+	Refs  int
+	Size  uint64
+}
+
+// ParseCategoryQuotas parses quotas formatted as category=share separated by commas e.g.
+// video=0.6,software=0.3,other=0.1. Shares can also be written as percentages e.g. video=60%.
+func ParseCategoryQuotas(s string) (map[string]float64, error) {
+	quotas := make(map[string]float64)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		l, err := ParseLabel(kv)
+		if err != nil {
+			return nil, err
+		}
+		v := strings.TrimSpace(l.Value)
+		div := 1.0
+		if strings.HasSuffix(v, "%") {
+			v = strings.TrimSuffix(v, "%")
+			div = 100
+		}
+		q, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidQuota, kv)
+		}
+		quotas[strings.TrimSpace(l.Key)] = q / div
+	}
+	if err := ValidateCategoryQuotas(quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// ValidateCategoryQuotas checks every quota is a fraction of the capacity and they add up to at most 1
+func ValidateCategoryQuotas(quotas map[string]float64) error {
+	var total float64
+	for cat, q := range quotas {
+		if q <= 0 || q > 1 {
+			return fmt.Errorf("%w: %s=%v", ErrInvalidQuota, cat, q)
+		}
+		total += q
+	}
+	// Leave some room for rounding errors of shares written as decimals
+	if total > 1+1e-9 {
+		return fmt.Errorf("%w: total is %v", ErrInvalidQuota, total)
+	}
+	return nil
+}
+
+// WithCategoryQuotas partitions the bounds of the index between the categories of content found under the
+// given label so a traffic spike of one content type cannot evict everything else. Each category evicts
+// its own least frequently used content once it goes over its quota. Content whose category has no quota
+// belongs to the other category which gets whatever the listed quotas leave if it isn't listed itself, so
+// such content isn't cached if the listed quotas add up to 1.
+func WithCategoryQuotas(label string, quotas map[string]float64) IndexOption {
+	return func(idx *Index) {
+		// Should crash execution rather than running the index with sneaky bugs
+		if err := ValidateCategoryQuotas(quotas); err != nil {
+			panic(err.Error())
+		}
+		if len(quotas) == 0 {
+			return
+		}
+		if label == "" {
+			label = DefaultCategoryLabel
+		}
+		idx.catLabel = label
+		idx.cats = make(map[string]*CategoryUsage, len(quotas)+1)
+		var total float64
+		for cat, q := range quotas {
+			idx.cats[cat] = &CategoryUsage{Category: cat, Quota: q}
+			total += q
+		}
+		if _, ok := idx.cats[OtherCategory]; !ok {
+			idx.cats[OtherCategory] = &CategoryUsage{Category: OtherCategory, Quota: math.Max(1-total, 0)}
+		}
+	}
+}
+
+// Categories returns the usage of every category with a quota, largest quota first. It returns nothing if
+// the index isn't partitioned.
+func (idx *Index) Categories() []CategoryUsage {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	usage := make([]CategoryUsage, 0, len(idx.cats))
+	for _, u := range idx.cats {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Quota != usage[j].Quota {
+			return usage[i].Quota > usage[j].Quota
+		}
+		return usage[i].Category < usage[j].Category
+	})
+	return usage
+}
+
+// CategoryCap returns the maximum size the content of a category can use or 0 if uncapped
+func (idx *Index) CategoryCap(cat string) uint64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	u, ok := idx.cats[cat]
+	if !ok {
+		return 0
+	}
+	return idx.categoryCap(u)
+}
+
+func (idx *Index) categoryCap(u *CategoryUsage) uint64 {
+	return uint64(math.Round(float64(idx.ub) * u.Quota))
+}
+
+// category returns the usage of the partition a ref belongs to or nil if the index isn't partitioned
+func (idx *Index) category(ref *DataRef) *CategoryUsage {
+	if len(idx.cats) == 0 {
+		return nil
+	}
+	if u, ok := idx.cats[ref.Labels[idx.catLabel]]; ok {
+		return u
+	}
+	return idx.cats[OtherCategory]
+}
+
+// fitsCategory checks a ref isn't larger than the partition it belongs to, callers must hold the lock
+func (idx *Index) fitsCategory(ref *DataRef) error {
+	u := idx.category(ref)
+	if u == nil || idx.ub == 0 {
+		return nil
+	}
+	if limit := idx.categoryCap(u); uint64(ref.PayloadSize) > limit {
+		return fmt.Errorf("%w: %d bytes is over the %s quota of %d bytes", ErrStoreFull, ref.PayloadSize, u.Category, limit)
+	}
+	return nil
+}
+
+// addCategoryUsage accounts for a new ref in the usage of its category, callers must hold the lock
+func (idx *Index) addCategoryUsage(ref *DataRef) {
+	if u := idx.category(ref); u != nil {
+		u.Refs++
+		u.Size += uint64(ref.PayloadSize)
+	}
+}
+
+// removeCategoryUsage removes a ref from the usage of its category, callers must hold the lock
+func (idx *Index) removeCategoryUsage(ref *DataRef) {
+	if u := idx.category(ref); u != nil {
+		u.Refs--
+		u.Size -= uint64(ref.PayloadSize)
+	}
+}
+
+// enforceQuota evicts the least frequently used content of the category of a ref once it goes over its
+// quota until it is back under its share of the lower bound, callers must hold the lock
+func (idx *Index) enforceQuota(ref *DataRef) {
+	u := idx.category(ref)
+	if u == nil || idx.ub == 0 || u.Size <= idx.categoryCap(u) {
+		return
+	}
+	target := uint64(math.Round(float64(idx.lb) * u.Quota))
+	idx.evictWhere(u.Size-target, func(r *DataRef) bool {
+		return idx.category(r) == u
+	})
+}
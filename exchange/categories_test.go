@@ -0,0 +1,102 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCategoryQuotas(t *testing.T) {
+	quotas, err := ParseCategoryQuotas("video=60%, software=0.3")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"video": 0.6, "software": 0.3}, quotas)
+
+	quotas, err = ParseCategoryQuotas("")
+	require.NoError(t, err)
+	require.Empty(t, quotas)
+
+	_, err = ParseCategoryQuotas("video=0.8,software=0.3")
+	require.True(t, errors.Is(err, ErrInvalidQuota))
+	_, err = ParseCategoryQuotas("video=lots")
+	require.True(t, errors.Is(err, ErrInvalidQuota))
+	_, err = ParseCategoryQuotas("video")
+	require.True(t, errors.Is(err, ErrInvalidLabel))
+}
+
+func TestCategoryQuotas(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	// Video can use up to 6000 bytes, software 3000 and the rest 1000
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 9000), WithCategoryQuotas("", map[string]float64{
+		"video":    0.6,
+		"software": 0.3,
+	}))
+	require.NoError(t, err)
+
+	newRef := func(cat string, size int64) *DataRef {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: size,
+		}
+		if cat != "" {
+			ref.Labels = map[string]string{DefaultCategoryLabel: cat}
+		}
+		require.NoError(t, idx.SetRef(ref))
+		return ref
+	}
+
+	s1 := newRef("software", 1000)
+	s2 := newRef("software", 1000)
+	o1 := newRef("", 500)
+	v1 := newRef("video", 2000)
+	v2 := newRef("video", 1500)
+	v3 := newRef("video", 1500)
+	for _, ref := range []*DataRef{v2, v3} {
+		_, err = idx.GetRef(ref.PayloadCID)
+		require.NoError(t, err)
+	}
+
+	// A video spike only evicts the least used video until video is back under its share of the lower bound
+	v4 := newRef("video", 1500)
+	_, err = idx.PeekRef(v1.PayloadCID)
+	require.True(t, errors.Is(err, ErrRefNotFound))
+	for _, ref := range []*DataRef{s1, s2, o1, v2, v3, v4} {
+		_, err = idx.PeekRef(ref.PayloadCID)
+		require.NoError(t, err)
+	}
+
+	usage := idx.Categories()
+	require.Len(t, usage, 3)
+	require.Equal(t, "video", usage[0].Category)
+	require.Equal(t, 3, usage[0].Refs)
+	require.Equal(t, uint64(4500), usage[0].Size)
+	require.Equal(t, "software", usage[1].Category)
+	require.Equal(t, uint64(2000), usage[1].Size)
+	require.Equal(t, OtherCategory, usage[2].Category)
+	require.InDelta(t, 0.1, usage[2].Quota, 1e-9)
+	require.Equal(t, uint64(500), usage[2].Size)
+	require.Equal(t, uint64(6000), idx.CategoryCap("video"))
+
+	// Content larger than its partition is rejected and content without a listed category is other
+	err = idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1500,
+		Labels:      map[string]string{DefaultCategoryLabel: "music"},
+	})
+	require.True(t, errors.Is(err, ErrStoreFull))
+
+	// Relabeling content moves it to its new category
+	require.NoError(t, idx.UpdateRef(o1.PayloadCID, func(ref *DataRef) {
+		ref.Labels = map[string]string{DefaultCategoryLabel: "software"}
+	}))
+	usage = idx.Categories()
+	require.Equal(t, uint64(2500), usage[1].Size)
+	require.Equal(t, uint64(0), usage[2].Size)
+	require.Equal(t, 0, usage[2].Refs)
+}
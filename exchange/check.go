@@ -0,0 +1,181 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-hamt-ipld/v3"
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// IndexCheck reports the state of an index read without loading it
+type IndexCheck struct {
+	// Root is the CID of the HAMT root, undefined if the index was never flushed
+	Root cid.Cid
+	// Refs are the refs which could be decoded sorted by root
+	Refs []DataRef
+	// Size is the total payload size of the refs
+	Size uint64
+	// Interest is the number of roots in the interest list
+	Interest int
+	// Stores is the number of stores in the multistore
+	Stores int
+	// OrphanedStores are the stores no ref points to
+	OrphanedStores []multistore.StoreID
+	// Pending is the number of operations in the log which weren't flushed in the HAMT root yet.
+	// They are replayed when the index is loaded.
+	Pending int
+	// Corrupt are the keys of the HAMT entries which don't decode to a ref of the same root
+	Corrupt []string
+	// MissingContent are the refs whose content isn't in their store
+	MissingContent []cid.Cid
+	// HAMTError is why the HAMT couldn't be walked entirely, the refs found before it are still listed
+	HAMTError error
+	// InterestError is why the interest list couldn't be read
+	InterestError error
+}
+
+// OK returns whether the index can be loaded without losing refs. Orphaned stores and pending operations
+// are cleaned up when the node starts.
+func (c IndexCheck) OK() bool {
+	return c.HAMTError == nil && c.InterestError == nil && len(c.Corrupt) == 0 && len(c.MissingContent) == 0
+}
+
+// offlineIndex gives access to the index persisted in a datastore without loading the refs so an index
+// which fails to load can still be inspected
+func offlineIndex(ds datastore.Batching, ms *multistore.MultiStore) *Index {
+	idx := &Index{
+		ds: namespace.Wrap(ds, datastore.NewKey("/index")),
+		ms: ms,
+	}
+	idx.bstore = blockstore.NewBlockstore(idx.ds)
+	idx.store = cbor.NewCborStore(idx.bstore)
+	return idx
+}
+
+// CheckIndex walks the HAMT and the interest list of the index persisted in a datastore and verifies the
+// content of every ref is in its store. The node using the datastore must not be running.
+func CheckIndex(ds datastore.Batching, ms *multistore.MultiStore) (IndexCheck, error) {
+	return offlineIndex(ds, ms).check()
+}
+
+// RepairIndex rebuilds the HAMT of the index persisted in a datastore from the refs CheckIndex could
+// read, dropping the corrupt entries and the refs whose content is missing. An unreadable interest list
+// is reset. It returns the check made before repairing and does nothing if no problem was found.
+func RepairIndex(ds datastore.Batching, ms *multistore.MultiStore) (IndexCheck, error) {
+	idx := offlineIndex(ds, ms)
+	c, err := idx.check()
+	if err != nil || c.OK() {
+		return c, err
+	}
+	missing := make(map[cid.Cid]bool, len(c.MissingContent))
+	for _, k := range c.MissingContent {
+		missing[k] = true
+	}
+	idx.root, err = hamt.NewNode(idx.store, hamt.UseTreeBitWidth(5), hashOption)
+	if err != nil {
+		return c, err
+	}
+	for i := range c.Refs {
+		ref := &c.Refs[i]
+		if missing[ref.PayloadCID] {
+			continue
+		}
+		if err := idx.root.Set(context.TODO(), ref.PayloadCID.String(), ref); err != nil {
+			return c, err
+		}
+	}
+	if c.InterestError != nil {
+		if err := idx.ds.Delete(datastore.NewKey(KInterest)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return c, err
+		}
+	}
+	return c, idx.Flush()
+}
+
+func (idx *Index) check() (IndexCheck, error) {
+	var c IndexCheck
+	enc, err := idx.ds.Get(datastore.NewKey(KIndex))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return c, err
+	default:
+		c.Root, c.HAMTError = idx.walkRoot(enc, func(k string, val *cbg.Deferred) {
+			ref := new(DataRef)
+			if err := ref.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil || ref.PayloadCID.String() != k {
+				c.Corrupt = append(c.Corrupt, k)
+				return
+			}
+			c.Refs = append(c.Refs, *ref)
+			c.Size += uint64(ref.PayloadSize)
+		})
+	}
+	sort.Slice(c.Refs, func(i, j int) bool {
+		return c.Refs[i].PayloadCID.String() < c.Refs[j].PayloadCID.String()
+	})
+
+	enc, err = idx.ds.Get(datastore.NewKey(KInterest))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return c, err
+	default:
+		_, c.InterestError = idx.walkRoot(enc, func(string, *cbg.Deferred) {
+			c.Interest++
+		})
+	}
+
+	indexed := make(map[multistore.StoreID]bool, len(c.Refs))
+	for i := range c.Refs {
+		ref := &c.Refs[i]
+		indexed[ref.StoreID] = true
+		if !idx.hasContent(ref) {
+			c.MissingContent = append(c.MissingContent, ref.PayloadCID)
+		}
+	}
+	stores := idx.ms.List()
+	c.Stores = len(stores)
+	for _, id := range stores {
+		if !indexed[id] {
+			c.OrphanedStores = append(c.OrphanedStores, id)
+		}
+	}
+
+	res, err := idx.ds.Query(dsq.Query{Prefix: "/" + KWal, KeysOnly: true})
+	if err != nil {
+		return c, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return c, err
+	}
+	c.Pending = len(entries)
+	return c, nil
+}
+
+// walkRoot calls fn for every entry of the HAMT whose root CID is encoded in enc
+func (idx *Index) walkRoot(enc []byte, fn func(string, *cbg.Deferred)) (cid.Cid, error) {
+	r, err := cid.Cast(enc)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid root: %w", err)
+	}
+	root, err := idx.LoadRoot(r, idx.store)
+	if err != nil {
+		return r, err
+	}
+	return r, root.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
+		fn(k, val)
+		return nil
+	})
+}
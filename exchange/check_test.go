@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIndex(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	storeID := ms.Next()
+	store, err := ms.Get(storeID)
+	require.NoError(t, err)
+	blk := blockGen.Next()
+	require.NoError(t, store.Bstore.Put(blk))
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blk.Cid(),
+		PayloadSize: 100,
+		StoreID:     storeID,
+	}))
+	missing := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  missing,
+		PayloadSize: 200,
+		StoreID:     ms.Next(),
+	}))
+	orphan := ms.Next()
+	_, err = ms.Get(orphan)
+	require.NoError(t, err)
+
+	c, err := CheckIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, idx.Root(), c.Root)
+	require.Len(t, c.Refs, 2)
+	require.Equal(t, uint64(300), c.Size)
+	require.Equal(t, []multistore.StoreID{orphan}, c.OrphanedStores)
+	require.Equal(t, []cid.Cid{missing}, c.MissingContent)
+	require.False(t, c.OK())
+
+	// An entry stored under the wrong key
+	off := offlineIndex(ds, ms)
+	off.root, err = off.LoadRoot(c.Root, off.store)
+	require.NoError(t, err)
+	require.NoError(t, off.root.Set(context.TODO(), "bogus", &DataRef{PayloadCID: blk.Cid()}))
+	require.NoError(t, off.Flush())
+
+	c, err = RepairIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bogus"}, c.Corrupt)
+
+	c, err = CheckIndex(ds, ms)
+	require.NoError(t, err)
+	require.True(t, c.OK())
+	require.Len(t, c.Refs, 1)
+
+	// The HAMT root block is lost
+	off = offlineIndex(ds, ms)
+	require.NoError(t, off.bstore.DeleteBlock(c.Root))
+	_, err = NewIndex(ds, ms)
+	require.Error(t, err)
+
+	c, err = CheckIndex(ds, ms)
+	require.NoError(t, err)
+	require.Error(t, c.HAMTError)
+	require.False(t, c.OK())
+
+	_, err = RepairIndex(ds, ms)
+	require.NoError(t, err)
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 0, idx.Len())
+}
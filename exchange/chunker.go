@@ -0,0 +1,179 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipfs/go-unixfs/importer/trickle"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DefaultChunkSize is the size in bytes of the chunks files are split into by default
+const DefaultChunkSize = 256000
+
+const (
+	// LayoutBalanced builds DAGs where every leaf is at the same depth
+	LayoutBalanced = "balanced"
+	// LayoutTrickle builds DAGs optimized for reading files sequentially such as videos
+	LayoutTrickle = "trickle"
+)
+
+// ChunkerConfig describes how files are split into blocks and linked into a UnixFS DAG
+type ChunkerConfig struct {
+	// Splitter uses the go-ipfs chunker syntax: size-<bytes>, rabin, rabin-<avg>, rabin-<min>-<avg>-<max>
+	// or buzhash. Files are split in chunks of the transaction chunk size if empty.
+	Splitter string
+	// Layout is LayoutBalanced or LayoutTrickle, default is balanced
+	Layout string
+	// RawLeaves stores the file data in raw blocks instead of wrapping it in UnixFS nodes
+	RawLeaves bool
+	// CidVersion is 0 or 1, CIDv0 always uses sha2-256
+	CidVersion int
+	// HashFunction is the multihash code of CIDv1 blocks, default is DefaultHashFunction
+	HashFunction uint64
+	// MaxLinks is the maximum number of links per node, default is 1024
+	MaxLinks int
+	// CidBuilder builds the CIDs of the DAG nodes instead of CidVersion and HashFunction if set
+	CidBuilder cid.Builder
+}
+
+// DefaultChunkerConfig is used by transactions unless they are given a different chunker
+var DefaultChunkerConfig = ChunkerConfig{
+	Layout:       LayoutBalanced,
+	RawLeaves:    true,
+	CidVersion:   1,
+	HashFunction: DefaultHashFunction,
+	MaxLinks:     1024,
+}
+
+// IPFSChunkerConfig matches the defaults of ipfs add so the CIDs are the same as the content pinned
+// with go-ipfs
+var IPFSChunkerConfig = ChunkerConfig{
+	Splitter:   fmt.Sprintf("size-%d", chunk.DefaultBlockSize),
+	Layout:     LayoutBalanced,
+	CidVersion: 0,
+	MaxLinks:   helpers.DefaultLinksPerBlock,
+}
+
+// Validate checks the chunker can be used to import files
+func (c ChunkerConfig) Validate() error {
+	if c.Splitter != "" {
+		// The splitter is only created to validate its parameters
+		if _, err := chunk.FromString(nil, c.Splitter); err != nil {
+			return err
+		}
+	}
+	switch c.Layout {
+	case "", LayoutBalanced, LayoutTrickle:
+	default:
+		return fmt.Errorf("unknown DAG layout %s", c.Layout)
+	}
+	if c.CidVersion != 0 && c.CidVersion != 1 {
+		return fmt.Errorf("unknown CID version %d", c.CidVersion)
+	}
+	if c.HashFunction != 0 {
+		if _, ok := mh.Codes[c.HashFunction]; !ok {
+			return fmt.Errorf("unknown hash function %d", c.HashFunction)
+		}
+	}
+	return nil
+}
+
+// builder returns the CID builder of the nodes linking the DAG
+func (c ChunkerConfig) builder() (cid.Builder, error) {
+	if c.CidBuilder != nil {
+		return c.CidBuilder, nil
+	}
+	prefix, err := merkledag.PrefixForCidVersion(c.CidVersion)
+	if err != nil {
+		return nil, err
+	}
+	if c.CidVersion == 0 {
+		return prefix, nil
+	}
+	prefix.MhType = DefaultHashFunction
+	if c.HashFunction != 0 {
+		prefix.MhType = c.HashFunction
+	}
+	return prefix, nil
+}
+
+// Import chunks the content of a reader and writes the resulting UnixFS DAG into the given DAG service.
+// Content is split in chunks of DefaultChunkSize if the config has no splitter.
+func (c ChunkerConfig) Import(ctx context.Context, dag ipldformat.DAGService, r io.Reader) (ipldformat.Node, error) {
+	return importFile(ctx, dag, files.NewReaderFile(r), c, DefaultChunkSize)
+}
+
+// importFile chunks a file and writes the resulting UnixFS DAG into the given DAG service.
+// The chunk size is used if the config has no splitter.
+func importFile(ctx context.Context, dag ipldformat.DAGService, f files.File, c ChunkerConfig, chunkSize int64) (ipldformat.Node, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	bufferedDS := ipldformat.NewBufferedDAG(ctx, dag)
+
+	prefix, err := c.builder()
+	if err != nil {
+		return nil, err
+	}
+	maxLinks := c.MaxLinks
+	if maxLinks == 0 {
+		maxLinks = DefaultChunkerConfig.MaxLinks
+	}
+
+	params := helpers.DagBuilderParams{
+		Maxlinks:   maxLinks,
+		RawLeaves:  c.RawLeaves,
+		CidBuilder: prefix,
+		Dagserv:    bufferedDS,
+	}
+
+	var spl chunk.Splitter = chunk.NewSizeSplitter(f, chunkSize)
+	if c.Splitter != "" {
+		spl, err = chunk.FromString(f, c.Splitter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := params.New(spl)
+	if err != nil {
+		return nil, err
+	}
+
+	var n ipldformat.Node
+	if c.Layout == LayoutTrickle {
+		n, err = trickle.Layout(db)
+	} else {
+		n, err = balanced.Layout(db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = bufferedDS.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// countReader counts the bytes read from a reader
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,104 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkerValidate(t *testing.T) {
+	require.NoError(t, DefaultChunkerConfig.Validate())
+	require.NoError(t, IPFSChunkerConfig.Validate())
+	require.NoError(t, ChunkerConfig{Splitter: "rabin-262144-524288-1048576", Layout: LayoutTrickle}.Validate())
+	require.NoError(t, ChunkerConfig{Splitter: "buzhash", CidVersion: 1}.Validate())
+
+	require.Error(t, ChunkerConfig{Splitter: "fastcdc"}.Validate())
+	require.Error(t, ChunkerConfig{Splitter: "size-0"}.Validate())
+	require.Error(t, ChunkerConfig{Layout: "flat"}.Validate())
+	require.Error(t, ChunkerConfig{CidVersion: 2}.Validate())
+}
+
+func TestImportFileChunker(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	// The same CID as ipfs add
+	nd, err := importFile(ctx, dag, files.NewBytesFile([]byte("hello world\n")), IPFSChunkerConfig, 256000)
+	require.NoError(t, err)
+	require.Equal(t, "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", nd.Cid().String())
+
+	data := make([]byte, 600000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	cfgs := []ChunkerConfig{
+		DefaultChunkerConfig,
+		{Splitter: "size-1024", Layout: LayoutTrickle, RawLeaves: true, CidVersion: 1},
+		{Splitter: "rabin", CidVersion: 1, HashFunction: mh.SHA2_256},
+		{Splitter: "buzhash", CidVersion: 0},
+	}
+	roots := make(map[string]bool)
+	for _, cfg := range cfgs {
+		nd, err := importFile(ctx, dag, files.NewBytesFile(data), cfg, 256000)
+		require.NoError(t, err)
+		require.Equal(t, uint64(cfg.CidVersion), nd.Cid().Version())
+		roots[nd.Cid().String()] = true
+
+		// Every layout reads back the same content
+		f, err := unixfile.NewUnixfsFile(ctx, dag, nd)
+		require.NoError(t, err)
+		buf, err := io.ReadAll(f.(files.File))
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(data, buf))
+	}
+	require.Len(t, roots, len(cfgs))
+
+	_, err = importFile(ctx, dag, files.NewBytesFile(data), ChunkerConfig{Layout: "flat"}, 256000)
+	require.Error(t, err)
+}
+
+func TestTxPutReader(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx, WithChunker(IPFSChunkerConfig))
+	defer tx.Close()
+	require.NoError(t, tx.PutReader("hello.txt", strings.NewReader("hello world\n")))
+
+	status, err := tx.Status()
+	require.NoError(t, err)
+	require.Equal(t, "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", status["hello.txt"].Value.String())
+	require.Equal(t, int64(12), status["hello.txt"].Size)
+
+	require.Error(t, tx.SetChunker(ChunkerConfig{Splitter: "size-abc"}))
+
+	nd, err := tx.GetFile("hello.txt")
+	require.NoError(t, err)
+	buf, err := io.ReadAll(nd.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n", string(buf))
+}
@@ -0,0 +1,85 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Hash function names accepted by NewCidBuilder. blake3 is not one of them as our go-multihash version
+// doesn't implement it, it needs the go-multihash v0.1.0 upgrade.
+const (
+	HashSHA2_256   = "sha2-256"
+	HashBlake2b256 = "blake2b-256"
+)
+
+// ErrUnsupportedHash is returned when a hash function cannot be used to build CIDs
+var ErrUnsupportedHash = errors.New("unsupported hash function")
+
+// HashCode returns the multihash code of a hash function name and checks we can compute it
+func HashCode(name string) (uint64, error) {
+	code, ok := mh.Names[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedHash, name)
+	}
+	if _, err := mh.Sum(nil, code, -1); err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", ErrUnsupportedHash, name, err)
+	}
+	return code, nil
+}
+
+// NewCidBuilder returns a builder for the CIDs of the DAG nodes created in a transaction.
+// CIDv0 only supports sha2-256 and the default hash function is used if the name is empty.
+func NewCidBuilder(version int, hash string) (cid.Builder, error) {
+	switch version {
+	case 0:
+		if hash != "" && hash != HashSHA2_256 {
+			return nil, fmt.Errorf("%w: CIDv0 requires %s", ErrUnsupportedHash, HashSHA2_256)
+		}
+		return cid.V0Builder{}, nil
+	case 1:
+		code := DefaultHashFunction
+		if hash != "" {
+			var err error
+			code, err = HashCode(hash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return cid.Prefix{
+			Version:  1,
+			Codec:    cid.DagProtobuf,
+			MhType:   code,
+			MhLength: -1,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown CID version %d", version)
+	}
+}
+
+// rootPrefix returns the prefix of the transaction root, it is always a dag-cbor CIDv1
+// hashed with the function of the transaction CID builder if any
+func rootPrefix(b cid.Builder) cid.Prefix {
+	prefix := cid.Prefix{
+		Version:  1,
+		Codec:    0x71, // dag-cbor as per multicodec
+		MhType:   DefaultHashFunction,
+		MhLength: -1,
+	}
+	switch b := b.(type) {
+	case cid.Prefix:
+		prefix.MhType = b.MhType
+		prefix.MhLength = b.MhLength
+	case cid.V1Builder:
+		prefix.MhType = b.MhType
+		prefix.MhLength = b.MhLength
+	case cid.V0Builder:
+		prefix.MhType = mh.SHA2_256
+	}
+	if prefix.MhLength <= 0 {
+		prefix.MhLength = -1
+	}
+	return prefix
+}
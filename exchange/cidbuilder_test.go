@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCidBuilder(t *testing.T) {
+	b, err := NewCidBuilder(0, "")
+	require.NoError(t, err)
+	require.Equal(t, cid.V0Builder{}, b)
+
+	_, err = NewCidBuilder(0, HashBlake2b256)
+	require.True(t, errors.Is(err, ErrUnsupportedHash))
+
+	b, err = NewCidBuilder(1, HashSHA2_256)
+	require.NoError(t, err)
+	c, err := b.Sum([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), c.Version())
+	require.Equal(t, uint64(mh.SHA2_256), c.Prefix().MhType)
+
+	b, err = NewCidBuilder(1, "")
+	require.NoError(t, err)
+	require.Equal(t, DefaultHashFunction, b.(cid.Prefix).MhType)
+
+	_, err = NewCidBuilder(1, "sha2-257")
+	require.True(t, errors.Is(err, ErrUnsupportedHash))
+
+	_, err = NewCidBuilder(2, "")
+	require.Error(t, err)
+}
+
+func TestTxCidBuilder(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	b, err := NewCidBuilder(1, HashSHA2_256)
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx, WithCidBuilder(b))
+	defer tx.Close()
+	p := n.CreateRandomFile(t, 56000)
+	require.NoError(t, tx.PutFile(p))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+
+	status, err := tx.Status()
+	require.NoError(t, err)
+	for _, e := range status {
+		require.Equal(t, uint64(1), e.Value.Version())
+		require.Equal(t, uint64(mh.SHA2_256), e.Value.Prefix().MhType)
+	}
+	require.Equal(t, uint64(mh.SHA2_256), tx.Root().Prefix().MhType)
+	require.Equal(t, uint64(cid.DagCBOR), tx.Root().Prefix().Codec)
+}
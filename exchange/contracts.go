@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"sync/atomic"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// Contract is an agreement with a provider to cache our content and serve retrievals at an agreed price.
+// Contracted providers are tried first instead of selecting providers on the open market which we only
+// fall back to when they are unavailable.
+type Contract struct {
+	Peer peer.ID
+	// Addrs are used to reach the provider if it isn't in the peerstore
+	Addrs []ma.Multiaddr
+	// PricePerByte is the maximum price per byte agreed with the provider. Offers above it are declined.
+	PricePerByte abi.TokenAmount
+}
+
+// accepts returns true if the provider offers the content within the terms of the contract
+func (c Contract) accepts(res deal.QueryResponse) bool {
+	if res.Status != deal.QueryResponseAvailable {
+		return false
+	}
+	if c.PricePerByte.Nil() {
+		return true
+	}
+	return !c.PricePerByte.LessThan(res.MinPricePerByte)
+}
+
+// contractPeers returns the IDs of the contracted providers in order of preference
+func contractPeers(cs []Contract) []peer.ID {
+	peers := make([]peer.ID, len(cs))
+	for i, c := range cs {
+		peers[i] = c.Peer
+	}
+	return peers
+}
+
+// WithContracts sets the contracted providers tried first for retrieving and dispatching the content of
+// this transaction. Default is the contracts of the exchange options.
+func WithContracts(cs ...Contract) TxOption {
+	return func(tx *Tx) {
+		tx.contracts = cs
+	}
+}
+
+// retrieveContracted tries to retrieve the content from each contracted provider in order and falls back
+// to the open market if none of them could serve it. It doesn't return if a contracted provider completes
+// the transfer.
+func (tx *Tx) retrieveContracted() {
+	for _, c := range tx.contracts {
+		info := peer.AddrInfo{ID: c.Peer, Addrs: c.Addrs}
+		var res *deal.QueryResponse
+		err := tx.rou.QueryPeer(info, tx.root, func(p peer.AddrInfo, r deal.QueryResponse) {
+			res = &r
+		})
+		if err != nil || res == nil {
+			continue
+		}
+		atomic.AddInt32(&tx.offers, 1)
+		tx.rec.recordOffer(info, *res, tx.root.String())
+		if !c.accepts(*res) {
+			if res.Status == deal.QueryResponseAvailable {
+				atomic.AddInt32(&tx.expensive, 1)
+			}
+			continue
+		}
+		of := deal.Offer{Provider: info, Response: *res}
+		if !tx.Confirm(of) {
+			break
+		}
+		// Execute only returns if the transfer failed
+		if err := tx.Execute(of); err != nil {
+			txLog.Warn().Err(err).Str("peer", c.Peer.String()).Msg("contracted retrieval failed")
+		}
+		if tx.ctx.Err() != nil {
+			return
+		}
+	}
+	if err := tx.rou.Query(tx.ctx, tx.root, tx.sel); err != nil {
+		txLog.Warn().Err(err).Str("root", tx.root.String()).Msg("failed to query the network")
+	}
+}
@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/retrieval/deal"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContractAccepts(t *testing.T) {
+	c := Contract{PricePerByte: abi.NewTokenAmount(5)}
+	require.True(t, c.accepts(deal.QueryResponse{Status: deal.QueryResponseAvailable, MinPricePerByte: abi.NewTokenAmount(5)}))
+	require.True(t, c.accepts(deal.QueryResponse{Status: deal.QueryResponseAvailable, MinPricePerByte: abi.NewTokenAmount(0)}))
+	require.False(t, c.accepts(deal.QueryResponse{Status: deal.QueryResponseAvailable, MinPricePerByte: abi.NewTokenAmount(6)}))
+	require.False(t, c.accepts(deal.QueryResponse{Status: deal.QueryResponseUnavailable, MinPricePerByte: abi.NewTokenAmount(0)}))
+
+	// Any price is accepted if none was agreed
+	require.True(t, Contract{}.accepts(deal.QueryResponse{Status: deal.QueryResponseAvailable, MinPricePerByte: abi.NewTokenAmount(6)}))
+}
+
+func TestTxContracts(t *testing.T) {
+	bgCtx := context.Background()
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(bgCtx)
+	newNode := func() (*Exchange, *testutil.TestNode) {
+		n := testutil.NewTestNode(mn, t)
+		exch, err := New(ctx, n.Host, n.Ds, Options{
+			RepoPath: n.DTTmpDir,
+			Keystore: keystore.NewMemKeystore(),
+		})
+		require.NoError(t, err)
+		return exch, n
+	}
+
+	var providers []*Exchange
+	var pnodes []*testutil.TestNode
+	for i := 0; i < 5; i++ {
+		exch, n := newNode()
+		providers = append(providers, exch)
+		pnodes = append(pnodes, n)
+	}
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	contract := Contract{
+		Peer:         pnodes[4].Host.ID(),
+		PricePerByte: abi.NewTokenAmount(0),
+	}
+
+	fname := pnodes[0].CreateRandomFile(t, 56000)
+	tx := providers[0].Tx(ctx, WithContracts(contract))
+	require.NoError(t, tx.PutFile(fname))
+	tx.SetCacheRF(1)
+	require.NoError(t, tx.Commit())
+
+	// The contracted provider is selected over any other
+	var records []PRecord
+	tx.WatchDispatch(func(rec PRecord) {
+		records = append(records, rec)
+	})
+	require.Len(t, records, 1)
+	require.Equal(t, contract.Peer, records[0].Provider)
+	root := tx.Root()
+	tx.Close()
+
+	client, _ := newNode()
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	tx = client.Tx(ctx, WithRoot(root), WithStrategy(SelectFirst), WithContracts(contract))
+	defer tx.Close()
+	require.NoError(t, tx.Query(sel.Key(FileKey(fname).String())))
+	select {
+	case <-ctx.Done():
+		t.Fatal("tx timeout")
+	case res := <-tx.Done():
+		require.NoError(t, res.Err)
+	}
+	require.Equal(t, contract.Peer, tx.SelectedOffer().Provider.ID)
+}
@@ -0,0 +1,284 @@
+package exchange
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-graphsync/storeutil"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+)
+
+// KBlocks is the datastore key prefix of the store holding each block written through the index
+const KBlocks = "blocks"
+
+// KLinks is the datastore key prefix of the blocks a store reads from the store holding them
+const KLinks = "links"
+
+func blockKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey(KBlocks).ChildString(c.String())
+}
+
+func linksPrefix(holder multistore.StoreID) datastore.Key {
+	return datastore.NewKey(KLinks).ChildString(strconv.FormatUint(uint64(holder), 10))
+}
+
+func linkKey(holder, linker multistore.StoreID, c cid.Cid) datastore.Key {
+	return linksPrefix(holder).ChildString(strconv.FormatUint(uint64(linker), 10)).ChildString(c.String())
+}
+
+func storeIDBytes(id multistore.StoreID) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(id))
+	return buf[:n]
+}
+
+// dedupBlockstore doesn't write the blocks another store already holds. It records a link to the
+// holder instead and reads the linked blocks from there. Only linked blocks are visible so the blocks
+// of other stores are still written, and linked, when added to this one.
+type dedupBlockstore struct {
+	blockstore.Blockstore
+	idx *Index
+	id  multistore.StoreID
+	// saved is the number of bytes which were not written because another store held them
+	saved uint64
+}
+
+// holder returns the store holding a block if it isn't in this store
+func (bs *dedupBlockstore) holder(c cid.Cid) (multistore.StoreID, blockstore.Blockstore, bool) {
+	id, ok := bs.idx.blockHolder(c)
+	if !ok || id == bs.id {
+		return 0, nil, false
+	}
+	store, err := bs.idx.ms.Get(id)
+	if err != nil {
+		return 0, nil, false
+	}
+	return id, store.Bstore, true
+}
+
+// linked returns the store holding a block this store links to
+func (bs *dedupBlockstore) linked(c cid.Cid) (blockstore.Blockstore, bool) {
+	id, hs, ok := bs.holder(c)
+	if !ok {
+		return nil, false
+	}
+	has, err := bs.idx.ds.Has(linkKey(id, bs.id, c))
+	if err != nil || !has {
+		return nil, false
+	}
+	return hs, true
+}
+
+func (bs *dedupBlockstore) Has(c cid.Cid) (bool, error) {
+	has, err := bs.Blockstore.Has(c)
+	if err != nil || has {
+		return has, err
+	}
+	if hs, ok := bs.linked(c); ok {
+		return hs.Has(c)
+	}
+	return false, nil
+}
+
+// Get reads a block from the store or the store it links to. Reads are timed as every served block
+// goes through here.
+func (bs *dedupBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	start := time.Now()
+	blk, err := bs.get(c)
+	bs.idx.timeRead(c, bs.id, start, err)
+	return blk, err
+}
+
+func (bs *dedupBlockstore) get(c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(c)
+	if !errors.Is(err, blockstore.ErrNotFound) {
+		return blk, err
+	}
+	if hs, ok := bs.linked(c); ok {
+		return hs.Get(c)
+	}
+	return nil, err
+}
+
+func (bs *dedupBlockstore) GetSize(c cid.Cid) (int, error) {
+	size, err := bs.Blockstore.GetSize(c)
+	if !errors.Is(err, blockstore.ErrNotFound) {
+		return size, err
+	}
+	if hs, ok := bs.linked(c); ok {
+		return hs.GetSize(c)
+	}
+	return size, err
+}
+
+func (bs *dedupBlockstore) Put(blk blocks.Block) error {
+	c := blk.Cid()
+	if has, err := bs.Blockstore.Has(c); err == nil && has {
+		return nil
+	}
+	if id, hs, ok := bs.holder(c); ok {
+		if has, err := hs.Has(c); err == nil && has {
+			if err := bs.idx.ds.Put(linkKey(id, bs.id, c), nil); err != nil {
+				return err
+			}
+			atomic.AddUint64(&bs.saved, uint64(len(blk.RawData())))
+			return nil
+		}
+	}
+	if err := bs.Blockstore.Put(blk); err != nil {
+		return err
+	}
+	// Blocks are only recorded once the store holds them so the next stores can link to them
+	return bs.idx.ds.Put(blockKey(c), storeIDBytes(bs.id))
+}
+
+func (bs *dedupBlockstore) PutMany(blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := bs.Put(blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saved returns the number of bytes which were not written because another store held them
+func (bs *dedupBlockstore) Saved() uint64 {
+	return atomic.LoadUint64(&bs.saved)
+}
+
+// blockHolder returns the store holding a block if it still exists
+func (idx *Index) blockHolder(c cid.Cid) (multistore.StoreID, bool) {
+	v, err := idx.ds.Get(blockKey(c))
+	if err != nil {
+		return 0, false
+	}
+	id, n := binary.Uvarint(v)
+	if n <= 0 {
+		return 0, false
+	}
+	for _, sid := range idx.ms.List() {
+		if sid == multistore.StoreID(id) {
+			return sid, true
+		}
+	}
+	return 0, false
+}
+
+// OpenStore returns a store reading and writing blocks through the deduplication layer so blocks already
+// held by another store are not written twice. Stores must be opened this way to read linked blocks.
+func (idx *Index) OpenStore(id multistore.StoreID) (*multistore.Store, error) {
+	store, err := idx.ms.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	bs := &dedupBlockstore{
+		Blockstore: store.Bstore,
+		idx:        idx,
+		id:         id,
+	}
+	view := *store
+	view.Bstore = bs
+	view.DAG = merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	view.Loader = storeutil.LoaderForBlockstore(bs)
+	view.Storer = storeutil.StorerForBlockstore(bs)
+	return &view, nil
+}
+
+// unshare copies the blocks other stores link to into these stores before a store is deleted.
+// It doesn't take the lock so it can be called while deleting refs.
+func (idx *Index) unshare(id multistore.StoreID) error {
+	store, err := idx.ms.Get(id)
+	if err != nil {
+		return err
+	}
+	// Forget the blocks this store holds so no new link points to them
+	keys, err := store.Bstore.AllKeysChan(context.TODO())
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		if hid, ok := idx.blockHolder(k); ok && hid == id {
+			if err := idx.ds.Delete(blockKey(k)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+				return err
+			}
+		}
+	}
+
+	res, err := idx.ds.Query(dsq.Query{Prefix: linksPrefix(id).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	live := make(map[multistore.StoreID]bool)
+	for _, sid := range idx.ms.List() {
+		live[sid] = true
+	}
+	for _, e := range entries {
+		k := datastore.NewKey(e.Key)
+		ns := k.Namespaces()
+		if len(ns) != 4 {
+			continue
+		}
+		linker, err := strconv.ParseUint(ns[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		c, err := cid.Decode(ns[3])
+		if err != nil {
+			continue
+		}
+		if live[multistore.StoreID(linker)] && multistore.StoreID(linker) != id {
+			if err := idx.copyBlock(store, multistore.StoreID(linker), c); err != nil {
+				return fmt.Errorf("failed to copy linked block %s: %w", c, err)
+			}
+		}
+		if err := idx.ds.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBlock writes a block into the store linking to it which holds it from now on
+func (idx *Index) copyBlock(from *multistore.Store, to multistore.StoreID, c cid.Cid) error {
+	blk, err := from.Bstore.Get(c)
+	if errors.Is(err, blockstore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dst, err := idx.ms.Get(to)
+	if err != nil {
+		return err
+	}
+	if err := dst.Bstore.Put(blk); err != nil {
+		return err
+	}
+	return idx.ds.Put(blockKey(c), storeIDBytes(to))
+}
+
+// Deduplicated returns the number of bytes added to the transaction which were not written because
+// they were already stored
+func (tx *Tx) Deduplicated() uint64 {
+	if bs, ok := tx.store.Bstore.(*dedupBlockstore); ok {
+		return bs.Saved()
+	}
+	return 0
+}
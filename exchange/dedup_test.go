@@ -0,0 +1,75 @@
+package exchange
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxDedup(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	shared := n.CreateRandomFile(t, 1024000)
+	data := n.OrigBytes
+
+	tx1 := exch.Tx(ctx)
+	require.NoError(t, tx1.PutFile(shared))
+	require.Equal(t, uint64(0), tx1.Deduplicated())
+	tx1.SetCacheRF(0)
+	require.NoError(t, tx1.Commit())
+	r1 := tx1.Root()
+	tx1.Close()
+
+	// The same file added in a new transaction reuses the blocks of the first one
+	tx2 := exch.Tx(ctx)
+	require.NoError(t, tx2.PutFile(shared))
+	require.NoError(t, tx2.PutFile(n.CreateRandomFile(t, 1000)))
+	require.Greater(t, tx2.Deduplicated(), uint64(len(data)))
+	tx2.SetCacheRF(0)
+	require.NoError(t, tx2.Commit())
+	r2 := tx2.Root()
+	s2 := tx2.StoreID()
+	tx2.Close()
+
+	usage, err := exch.idx.storeUsage(s2)
+	require.NoError(t, err)
+	require.Less(t, usage, uint64(len(data)))
+
+	readShared := func() []byte {
+		tx := exch.Tx(ctx, WithRoot(r2))
+		defer tx.Close()
+		nd, err := tx.GetFile(FileKey(shared).String())
+		require.NoError(t, err)
+		buf, err := io.ReadAll(nd.(files.File))
+		require.NoError(t, err)
+		return buf
+	}
+	require.Equal(t, data, readShared())
+
+	// The linked blocks are copied before the store holding them is deleted
+	require.NoError(t, exch.idx.DropRef(r1))
+	require.Equal(t, data, readShared())
+	usage, err = exch.idx.storeUsage(s2)
+	require.NoError(t, err)
+	require.Greater(t, usage, uint64(len(data)))
+
+	// The next transaction links to the new holder
+	tx3 := exch.Tx(ctx)
+	defer tx3.Close()
+	require.NoError(t, tx3.PutFile(shared))
+	require.Greater(t, tx3.Deduplicated(), uint64(len(data)))
+}
@@ -0,0 +1,228 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/selectors"
+)
+
+// DelegateProtocol lets a light client ask a trusted pop to retrieve content on its behalf
+const DelegateProtocol = protocol.ID("/myel/pop/delegate/1.0")
+
+// DelegateProtocols are the versions of the delegate protocol we speak
+var DelegateProtocols = Protocols{
+	{ID: DelegateProtocol},
+}
+
+// DefaultDelegateTimeout bounds the time a delegate spends retrieving content for a client
+const DefaultDelegateTimeout = 10 * time.Minute
+
+// ErrNotDelegator is returned when a peer which isn't trusted asks us to retrieve content on its behalf
+var ErrNotDelegator = errors.New("peer may not delegate retrievals")
+
+// ErrDelegateFailed is returned when a delegate couldn't retrieve the content we asked for
+var ErrDelegateFailed = errors.New("delegated retrieval failed")
+
+// DelegateRequest asks a delegate for the entire DAG under a root
+type DelegateRequest struct {
+	Root cid.Cid `json:"root"`
+}
+
+// DelegateResponse precedes the CAR of the requested DAG or explains why it won't be sent
+type DelegateResponse struct {
+	// Size is the payload size of the DAG
+	Size int64 `json:"size,omitempty"`
+	// Message explains why the retrieval failed if not empty
+	Message string `json:"message,omitempty"`
+}
+
+// Delegation lets constrained devices retrieve content without a wallet or payment channels. A delegate
+// performs the market retrieval for the clients it trusts, paying the providers itself, then streams the
+// DAG to the client as a CAR. A client fetches from its delegate over a single stream.
+type Delegation struct {
+	h   host.Host
+	idx *Index
+	acl *ACL
+	// retrieve fetches a root from the market and adds it to the index
+	retrieve func(context.Context, cid.Cid) error
+	// delegators are the peers allowed to make us retrieve content
+	delegators map[peer.ID]bool
+	timeout    time.Duration
+}
+
+// NewDelegation creates a delegation service retrieving content for the given delegators. Nobody may
+// delegate retrievals to us if the list is empty but we can still delegate ours. Delegators are only
+// served the content the ACL lets them retrieve.
+func NewDelegation(h host.Host, idx *Index, acl *ACL, retrieve func(context.Context, cid.Cid) error, delegators []peer.ID) *Delegation {
+	d := &Delegation{
+		h:          h,
+		idx:        idx,
+		acl:        acl,
+		retrieve:   retrieve,
+		delegators: peerSet(delegators),
+		timeout:    DefaultDelegateTimeout,
+	}
+	if len(d.delegators) > 0 {
+		SetStreamHandlers(h, DelegateProtocols, d.handleStream)
+	}
+	return d
+}
+
+func (d *Delegation) handleStream(s network.Stream) {
+	defer s.Close()
+	br := bufio.NewReader(s)
+	frame, err := carutil.LdRead(br)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	var req DelegateRequest
+	if err := json.Unmarshal(frame, &req); err != nil || !req.Root.Defined() {
+		s.Reset()
+		return
+	}
+	w := bufio.NewWriter(s)
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if err := d.serve(ctx, s.Conn().RemotePeer(), req.Root, w); err != nil {
+		txLog.Warn().Err(err).Str("root", req.Root.String()).Msg("failed to serve delegated retrieval")
+		s.Reset()
+		return
+	}
+	if err := w.Flush(); err != nil {
+		s.Reset()
+	}
+}
+
+// serve retrieves a root if we don't have it yet and writes it to the client. Errors are written to the
+// client as a response message until the CAR starts being written.
+func (d *Delegation) serve(ctx context.Context, p peer.ID, root cid.Cid, w io.Writer) error {
+	reject := func(err error) error {
+		return writeDelegateResponse(w, DelegateResponse{Message: err.Error()})
+	}
+	if !d.delegators[p] {
+		return reject(ErrNotDelegator)
+	}
+	if err := d.acl.Check(p, root); err != nil {
+		return reject(err)
+	}
+	ref, err := d.idx.PeekRef(root)
+	if errors.Is(err, ErrRefNotFound) {
+		if err := d.retrieve(ctx, root); err != nil {
+			return reject(err)
+		}
+		ref, err = d.idx.PeekRef(root)
+	}
+	if err != nil {
+		return reject(err)
+	}
+	store, err := d.idx.OpenStore(ref.StoreID)
+	if err != nil {
+		return reject(err)
+	}
+	if err := d.idx.RemoteRead(root); err != nil && !errors.Is(err, ErrRefNotFound) {
+		indexLog.Warn().Err(err).Str("root", root.String()).Msg("failed to register delegated read")
+	}
+	if err := writeDelegateResponse(w, DelegateResponse{Size: ref.PayloadSize}); err != nil {
+		return err
+	}
+	sc := car.NewSelectiveCar(ctx, store.Bstore, []car.Dag{{Root: root, Selector: selectors.All()}})
+	return sc.Write(w)
+}
+
+func writeDelegateResponse(w io.Writer, res DelegateResponse) error {
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return carutil.LdWrite(w, buf)
+}
+
+// Fetch asks a delegate to retrieve the DAG under a root and writes it to w as a CARv1. It returns the
+// payload size of the DAG announced by the delegate.
+func (d *Delegation) Fetch(ctx context.Context, p peer.ID, root cid.Cid, w io.Writer) (int64, error) {
+	s, err := d.h.NewStream(ctx, p, DelegateProtocols.Active(time.Now())...)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	buf, err := json.Marshal(DelegateRequest{Root: root})
+	if err != nil {
+		return 0, err
+	}
+	if err := carutil.LdWrite(s, buf); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(s)
+	frame, err := carutil.LdRead(br)
+	if err != nil {
+		return 0, err
+	}
+	var res DelegateResponse
+	if err := json.Unmarshal(frame, &res); err != nil {
+		return 0, err
+	}
+	if res.Message != "" {
+		return 0, fmt.Errorf("%w: %s", ErrDelegateFailed, res.Message)
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		s.Reset()
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+// Delegate asks a trusted pop to retrieve the DAG under a root from the market on our behalf and adds
+// the DAG it sends back to the index. The delegate pays for the retrieval.
+func (e *Exchange) Delegate(ctx context.Context, p peer.AddrInfo, root cid.Cid) error {
+	if len(p.Addrs) > 0 {
+		e.h.Peerstore().AddAddrs(p.ID, p.Addrs, time.Hour)
+	}
+	tx := e.Tx(ctx)
+	defer tx.Close()
+	pr, pw := io.Pipe()
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := e.dlg.Fetch(ctx, p.ID, root, pw)
+		pw.CloseWithError(err)
+		fetched <- err
+	}()
+	roots, err := tx.PutCar(pr)
+	// Unblock the fetch if the archive was rejected before it was read entirely
+	pr.CloseWithError(err)
+	// The archive is incomplete if the fetch failed so its error explains best what went wrong
+	if ferr := <-fetched; ferr != nil {
+		return ferr
+	}
+	if err != nil {
+		return err
+	}
+	if len(roots) != 1 || roots[0] != root {
+		return fmt.Errorf("%w: delegate sent roots %v", ErrDelegateFailed, roots)
+	}
+	status, err := tx.Status()
+	if err != nil {
+		return err
+	}
+	return e.idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		StoreID:     tx.StoreID(),
+		PayloadSize: status[root.String()].Size,
+	})
+}
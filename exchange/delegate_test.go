@@ -0,0 +1,81 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelegate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	newExchange := func(n *testutil.TestNode, delegators ...peer.ID) *Exchange {
+		exch, err := New(ctx, n.Host, n.Ds, Options{
+			RepoPath:   n.DTTmpDir,
+			Keystore:   keystore.NewMemKeystore(),
+			Delegators: delegators,
+		})
+		require.NoError(t, err)
+		return exch
+	}
+	cn := testutil.NewTestNode(mn, t)
+	on := testutil.NewTestNode(mn, t)
+	dn := testutil.NewTestNode(mn, t)
+	delegate := newExchange(dn, cn.Host.ID())
+	cexch := newExchange(cn)
+	oexch := newExchange(on)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	// The delegate already has the content so it doesn't retrieve it
+	var retrieved []cid.Cid
+	delegate.dlg.retrieve = func(ctx context.Context, root cid.Cid) error {
+		retrieved = append(retrieved, root)
+		return errors.New("no offer")
+	}
+	tx := delegate.Tx(ctx)
+	fname := dn.CreateRandomFile(t, 64000)
+	require.NoError(t, tx.PutFile(fname))
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	require.NoError(t, cexch.Delegate(ctx, peer.AddrInfo{ID: dn.Host.ID()}, root))
+	require.Empty(t, retrieved)
+	ref, err := cexch.Index().PeekRef(root)
+	require.NoError(t, err)
+	require.NotZero(t, ref.PayloadSize)
+
+	// The client can read the files it received
+	key := FileKey(fname).String()
+	f, err := cexch.Tx(ctx, WithRoot(root)).GetFile(key)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	want, err := ioutil.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	// Content the delegate doesn't have is retrieved from the market
+	missing := blockGen.Next().Cid()
+	err = cexch.Delegate(ctx, peer.AddrInfo{ID: dn.Host.ID()}, missing)
+	require.True(t, errors.Is(err, ErrDelegateFailed))
+	require.Equal(t, []cid.Cid{missing}, retrieved)
+
+	// Peers which aren't trusted cannot delegate their retrievals
+	err = oexch.Delegate(ctx, peer.AddrInfo{ID: dn.Host.ID()}, root)
+	require.True(t, errors.Is(err, ErrDelegateFailed))
+	require.Len(t, retrieved, 1)
+}
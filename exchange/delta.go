@@ -0,0 +1,196 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/filecoin-project/go-multistore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-ipld-prime"
+	dagpb "github.com/ipld/go-ipld-prime-proto"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/myelnet/pop/selectors"
+)
+
+// DeltaRetriever retrieves the entries which changed in a new version of content we already hold
+type DeltaRetriever interface {
+	// FindAndRetrieveEntries retrieves the root block and the DAGs of the given entries into a new store
+	// and calls fn with it. The store isn't garbage collected before fn returns.
+	FindAndRetrieveEntries(ctx context.Context, root cid.Cid, keys []string, fn func(multistore.StoreID) error) error
+}
+
+// loadEntries returns the root CID of each entry under a transaction root
+func loadEntries(ctx context.Context, store *multistore.Store, root cid.Cid) (map[string]cid.Cid, error) {
+	lk := cidlink.Link{Cid: root}
+	nb := basicnode.Prototype.Map.NewBuilder()
+	if err := lk.Load(ctx, ipld.LinkContext{}, nb, store.Loader); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]cid.Cid)
+	it := nb.Build().MapIterator()
+	for !it.Done() {
+		kn, vn, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, err := kn.AsString()
+		if err != nil {
+			return nil, err
+		}
+		ln, err := vn.LookupByString("Value")
+		if err != nil {
+			return nil, err
+		}
+		l, err := ln.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = l.(cidlink.Link).Cid
+	}
+	return entries, nil
+}
+
+// ChangedEntries returns the sorted keys of the entries of the next version which are missing from
+// the previous one or point to different content
+func ChangedEntries(prev, next map[string]cid.Cid) []string {
+	var changed []string
+	for k, c := range next {
+		if p, ok := prev[k]; !ok || !p.Equals(c) {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// copyDAG writes all the blocks of a DAG from a blockstore into another
+func copyDAG(ctx context.Context, from, to blockstore.Blockstore, root cid.Cid) error {
+	return fetchDAG(ctx, from.Get, to, root, selectors.All())
+}
+
+// fetchDAG writes the blocks of a DAG reached by a selector into a blockstore, getting each block
+// with the given function
+func fetchDAG(ctx context.Context, get func(cid.Cid) (blocks.Block, error), to blockstore.Blockstore, root cid.Cid, sel ipld.Node) error {
+	link := cidlink.Link{Cid: root}
+	chooser := dagpb.AddDagPBSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+	nodeType, err := chooser(link, ipld.LinkContext{})
+	if err != nil {
+		return err
+	}
+	loader := func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		c, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("incorrect Link Type")
+		}
+		block, err := get(c.Cid)
+		if err != nil {
+			return nil, err
+		}
+		if err := to.Put(block); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(block.RawData()), nil
+	}
+	builder := nodeType.NewBuilder()
+	if err := link.Load(ctx, ipld.LinkContext{}, builder, loader); err != nil {
+		return err
+	}
+	s, err := selector.ParseSelector(sel)
+	if err != nil {
+		return err
+	}
+	return traversal.Progress{
+		Cfg: &traversal.Config{
+			LinkLoader:                     loader,
+			LinkTargetNodePrototypeChooser: chooser,
+		},
+	}.WalkMatching(builder.Build(), s, func(prog traversal.Progress, n ipld.Node) error {
+		return nil
+	})
+}
+
+// entryDelta computes the keys of the entries which changed between two versions we both hold
+func (idx *Index) entryDelta(ctx context.Context, prev, next cid.Cid) ([]string, error) {
+	pentries, err := idx.peekEntries(ctx, prev)
+	if err != nil {
+		return nil, err
+	}
+	nentries, err := idx.peekEntries(ctx, next)
+	if err != nil {
+		return nil, err
+	}
+	return ChangedEntries(pentries, nentries), nil
+}
+
+// peekEntries loads the entries of a root in the index without counting it as a read
+func (idx *Index) peekEntries(ctx context.Context, root cid.Cid) (map[string]cid.Cid, error) {
+	ref, err := idx.PeekRef(root)
+	if err != nil {
+		return nil, err
+	}
+	store, err := idx.OpenStore(ref.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	return loadEntries(ctx, store, root)
+}
+
+// ApplyDelta completes the next version of a root we hold in the given store by copying the entries
+// which didn't change from the previous version. The next version then replaces the previous one in a
+// single index update so the content is served without interruption.
+func (idx *Index) ApplyDelta(ctx context.Context, prev, next cid.Cid, id multistore.StoreID) error {
+	ref, err := idx.PeekRef(prev)
+	if err != nil {
+		return err
+	}
+	pstore, err := idx.OpenStore(ref.StoreID)
+	if err != nil {
+		return err
+	}
+	pentries, err := loadEntries(ctx, pstore, prev)
+	if err != nil {
+		return err
+	}
+	store, err := idx.OpenStore(id)
+	if err != nil {
+		return err
+	}
+	nentries, err := loadEntries(ctx, store, next)
+	if err != nil {
+		return err
+	}
+	for k, c := range nentries {
+		if p, ok := pentries[k]; !ok || !p.Equals(c) {
+			continue
+		}
+		// The dedup store only links the blocks held by the previous version
+		if err := copyDAG(ctx, pstore.Bstore, store.Bstore, c); err != nil {
+			return fmt.Errorf("failed to copy entry %s: %w", k, err)
+		}
+	}
+	stat, err := Stat(ctx, store, next, selectors.All())
+	if err != nil {
+		return err
+	}
+	return idx.Update(func(tx *IndexTx) error {
+		tx.SetRef(&DataRef{
+			PayloadCID:  next,
+			StoreID:     id,
+			PayloadSize: int64(stat.Size),
+			Publisher:   ref.Publisher,
+			Labels:      ref.Labels,
+		})
+		tx.DropRef(prev)
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedEntries(t *testing.T) {
+	c1 := blockGen.Next().Cid()
+	c2 := blockGen.Next().Cid()
+	c3 := blockGen.Next().Cid()
+
+	prev := map[string]cid.Cid{"a": c1, "b": c2, "c": c3}
+	next := map[string]cid.Cid{"a": c1, "b": c3, "d": c2}
+	require.Equal(t, []string{"b", "d"}, ChangedEntries(prev, next))
+	require.Empty(t, ChangedEntries(prev, prev))
+
+	// The delta goes over the wire with the invalidation
+	succ := blockGen.Next().Cid()
+	inv := Invalidation{
+		Method:    Amend,
+		Root:      c1,
+		Successor: &succ,
+		Changed:   ChangedEntries(prev, next),
+		Time:      time.Now().Unix(),
+	}
+	buf := new(bytes.Buffer)
+	require.NoError(t, inv.MarshalCBOR(buf))
+	var dec Invalidation
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.Equal(t, inv.Changed, dec.Changed)
+}
+
+func TestApplyDelta(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+	idx := exch.Index()
+
+	_, filepaths := genTestFiles(t)
+
+	tx1 := exch.Tx(ctx)
+	defer tx1.Close()
+	for _, p := range filepaths {
+		require.NoError(t, tx1.PutFile(p))
+	}
+	ref1 := tx1.Ref()
+	ref1.Publisher = n.Host.ID()
+	require.NoError(t, idx.SetRef(ref1))
+
+	// The next version changes an entry and adds another
+	dir := t.TempDir()
+	changed := filepath.Join(dir, FileKey(filepaths[0]).String())
+	require.NoError(t, os.WriteFile(changed, []byte("I shall be telling this with a sigh\n"), 0666))
+	added := filepath.Join(dir, "line9.txt")
+	require.NoError(t, os.WriteFile(added, []byte("Somewhere ages and ages hence:\n"), 0666))
+
+	tx2 := exch.Tx(ctx)
+	defer tx2.Close()
+	for _, p := range append([]string{changed, added}, filepaths[1:]...) {
+		require.NoError(t, tx2.PutFile(p))
+	}
+
+	prev, err := loadEntries(ctx, tx1.Store(), tx1.Root())
+	require.NoError(t, err)
+	entries, err := loadEntries(ctx, tx2.Store(), tx2.Root())
+	require.NoError(t, err)
+	delta := ChangedEntries(prev, entries)
+	require.Equal(t, []string{FileKey(changed).String(), "line9.txt"}, delta)
+
+	// Simulate the retrieval of the root block and the changed entries only
+	id := idx.ms.Next()
+	store, err := idx.OpenStore(id)
+	require.NoError(t, err)
+	blk, err := tx2.Store().Bstore.Get(tx2.Root())
+	require.NoError(t, err)
+	require.NoError(t, store.Bstore.Put(blk))
+	for _, k := range delta {
+		require.NoError(t, copyDAG(ctx, tx2.Store().Bstore, store.Bstore, entries[k]))
+	}
+	_, err = StatEntries(ctx, store, tx2.Root())
+	require.Error(t, err)
+
+	require.NoError(t, idx.ApplyDelta(ctx, ref1.PayloadCID, tx2.Root(), id))
+
+	// The previous version is replaced
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.True(t, errors.Is(err, ErrRefNotFound))
+	ref2, err := idx.PeekRef(tx2.Root())
+	require.NoError(t, err)
+	require.Equal(t, id, ref2.StoreID)
+	require.Equal(t, n.Host.ID(), ref2.Publisher)
+
+	// All the entries are served from the new store
+	store, err = idx.OpenStore(id)
+	require.NoError(t, err)
+	stats, err := StatEntries(ctx, store, tx2.Root())
+	require.NoError(t, err)
+	require.Len(t, stats, len(filepaths)+1)
+	total, err := Stat(ctx, store, tx2.Root(), sel.All())
+	require.NoError(t, err)
+	require.Equal(t, int64(total.Size), ref2.PayloadSize)
+}
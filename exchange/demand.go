@@ -0,0 +1,73 @@
+package exchange
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/metrics"
+)
+
+// MaxDemandWeight caps the weight of a single demand hint so one external signal cannot outweigh
+// all the reads registered by the index
+const MaxDemandWeight = 1000
+
+// DemandHint is a signal from an external system such as a website analytics that a root is in demand.
+// The weight is added to the read frequency of the root.
+type DemandHint struct {
+	Root   cid.Cid
+	Weight int64
+}
+
+// AddDemand warms the cache with external demand signals. The frequency of the roots we store is
+// increased so they are kept longer and the roots we don't store are added to the interest list
+// so they are retrieved the next time the index is refreshed. It returns the number of hints
+// applied to stored roots and to the interest list.
+func (idx *Index) AddDemand(hints []DemandHint) (cached int, interest int, err error) {
+	var pending []DemandHint
+	idx.mu.Lock()
+	for _, h := range hints {
+		if h.Weight <= 0 {
+			continue
+		}
+		if h.Weight > MaxDemandWeight {
+			h.Weight = MaxDemandWeight
+		}
+		k := h.Root.String()
+		ref, ok := idx.lookup(k)
+		if !ok {
+			pending = append(pending, h)
+			continue
+		}
+		for i := int64(0); i < h.Weight; i++ {
+			idx.increment(ref)
+		}
+		if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+			idx.mu.Unlock()
+			return cached, interest, err
+		}
+		cached++
+	}
+	if cached > 0 {
+		err = idx.commit()
+	}
+	idx.mu.Unlock()
+	if err != nil {
+		return cached, interest, err
+	}
+
+	if len(pending) == 0 {
+		return cached, interest, nil
+	}
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	for _, h := range pending {
+		idx.addInterest(h.Root.String(), &DataRef{
+			PayloadCID: h.Root,
+			Freq:       h.Weight,
+		})
+		interest++
+	}
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+	return cached, interest, nil
+}
@@ -0,0 +1,162 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// DefaultDHTFallbackDelay is how long a transaction waits for an offer from the gossip network before
+// looking for providers in the DHT
+const DefaultDHTFallbackDelay = 5 * time.Second
+
+// DefaultReprovideInterval is how often the provider records of the roots we store are published again
+// so they don't expire from the DHT
+const DefaultReprovideInterval = 12 * time.Hour
+
+// dhtCheckInterval is how often we look for roots added to the index to advertise them
+const dhtCheckInterval = time.Minute
+
+// dhtProvideTimeout bounds the time spent publishing a single provider record
+const dhtProvideTimeout = time.Minute
+
+// dhtMaxProviders is the number of providers of a root we look for in the DHT
+const dhtMaxProviders = 10
+
+// DHTRouting advertises the roots in our index as provider records in a DHT and finds the providers of
+// a root when the gossip network yields no offer so content can be found beyond the reach of gossip.
+type DHTRouting struct {
+	h        host.Host
+	cr       routing.ContentRouting
+	idx      *Index
+	delay    time.Duration
+	interval time.Duration
+	// provide is false if the roots we store aren't advertised, only the providers of others are found
+	provide bool
+
+	mu sync.Mutex
+	// provided records when we last published the provider record of each root
+	provided map[cid.Cid]time.Time
+}
+
+// NewDHTRouting creates a new DHTRouting service advertising the roots of the index
+func NewDHTRouting(h host.Host, cr routing.ContentRouting, idx *Index) *DHTRouting {
+	return &DHTRouting{
+		h:        h,
+		cr:       cr,
+		idx:      idx,
+		delay:    DefaultDHTFallbackDelay,
+		interval: DefaultReprovideInterval,
+		provide:  true,
+		provided: make(map[cid.Cid]time.Time),
+	}
+}
+
+// Start advertises the roots of the index and keeps advertising the new ones until the context is done
+func (d *DHTRouting) Start(ctx context.Context) {
+	if !d.provide {
+		return
+	}
+	go func() {
+		select {
+		case <-d.idx.Loaded():
+		case <-ctx.Done():
+			return
+		}
+		ticker := time.NewTicker(dhtCheckInterval)
+		defer ticker.Stop()
+		for {
+			d.reprovide(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stale returns the roots of the index whose provider record was never published or must be republished
+func (d *DHTRouting) stale(now time.Time) []cid.Cid {
+	keys := d.idx.Keys()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexed := make(map[cid.Cid]bool, len(keys))
+	var roots []cid.Cid
+	for _, k := range keys {
+		indexed[k] = true
+		if at, ok := d.provided[k]; !ok || now.Sub(at) >= d.interval {
+			roots = append(roots, k)
+		}
+	}
+	// Evicted roots are published again if we store them later
+	for k := range d.provided {
+		if !indexed[k] {
+			delete(d.provided, k)
+		}
+	}
+	return roots
+}
+
+func (d *DHTRouting) reprovide(ctx context.Context) {
+	for _, root := range d.stale(time.Now()) {
+		if err := d.Provide(ctx, root); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			discoveryLog.Warn().Err(err).Str("root", root.String()).Msg("failed to provide")
+		}
+	}
+}
+
+// Provide publishes a provider record for a root we store
+func (d *DHTRouting) Provide(ctx context.Context, root cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ctx, dhtProvideTimeout)
+	defer cancel()
+	if err := d.cr.Provide(ctx, root, true); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.provided[root] = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+// FindProviders calls fn with each provider of a root found in the DHT other than us until the context
+// is done or enough providers were found
+func (d *DHTRouting) FindProviders(ctx context.Context, root cid.Cid, fn func(peer.AddrInfo)) {
+	for p := range d.cr.FindProvidersAsync(ctx, root, dhtMaxProviders) {
+		if p.ID == d.h.ID() {
+			continue
+		}
+		fn(p)
+	}
+}
+
+// fallbackDHT queries the providers of the root found in the DHT if we received no offer after the
+// fallback delay
+func (tx *Tx) fallbackDHT() {
+	timer := time.NewTimer(tx.dht.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-tx.ctx.Done():
+		return
+	}
+	if atomic.LoadInt32(&tx.offers) > 0 {
+		return
+	}
+	tx.dht.FindProviders(tx.ctx, tx.root, func(p peer.AddrInfo) {
+		tx.rou.AddAddrs(p.ID, p.Addrs)
+		// Providers which aren't pops don't speak the query protocol
+		if err := tx.rou.QueryPeer(p, tx.root, tx.receiveOffer); err != nil {
+			discoveryLog.Debug().Err(err).Str("peer", p.ID.String()).Msg("failed to query DHT provider")
+		}
+	})
+}
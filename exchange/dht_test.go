@@ -0,0 +1,80 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type mockContentRouting struct {
+	mu        sync.Mutex
+	provided  []cid.Cid
+	providers []peer.AddrInfo
+}
+
+func (cr *mockContentRouting) Provide(ctx context.Context, root cid.Cid, announce bool) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.provided = append(cr.provided, root)
+	return nil
+}
+
+func (cr *mockContentRouting) FindProvidersAsync(ctx context.Context, root cid.Cid, n int) <-chan peer.AddrInfo {
+	ch := make(chan peer.AddrInfo, len(cr.providers))
+	for _, p := range cr.providers {
+		ch <- p
+	}
+	close(ch)
+	return ch
+}
+
+func TestDHTRouting(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	mn := mocknet.New(ctx)
+	n := testutil.NewTestNode(mn, t)
+	other := testutil.NewTestNode(mn, t)
+	cr := &mockContentRouting{
+		providers: []peer.AddrInfo{
+			{ID: n.Host.ID()},
+			{ID: other.Host.ID()},
+		},
+	}
+	d := NewDHTRouting(n.Host, cr, idx)
+
+	ref := &DataRef{PayloadCID: blockGen.Next().Cid(), PayloadSize: 100}
+	require.NoError(t, idx.SetRef(ref))
+
+	// Roots are advertised once until the records must be published again
+	d.reprovide(ctx)
+	d.reprovide(ctx)
+	require.Equal(t, []cid.Cid{ref.PayloadCID}, cr.provided)
+	require.Len(t, d.stale(time.Now().Add(DefaultReprovideInterval)), 1)
+
+	// Evicted roots are forgotten
+	require.NoError(t, idx.DropRef(ref.PayloadCID))
+	require.Len(t, d.stale(time.Now()), 0)
+	require.Len(t, d.provided, 0)
+
+	// We don't query ourselves
+	var found []peer.ID
+	d.FindProviders(ctx, ref.PayloadCID, func(p peer.AddrInfo) {
+		found = append(found, p.ID)
+	})
+	require.Equal(t, []peer.ID{other.Host.ID()}, found)
+}
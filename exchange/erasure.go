@@ -0,0 +1,311 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipld/go-car"
+	"github.com/klauspost/reedsolomon"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/selectors"
+)
+
+//go:generate cbor-gen-for ShardManifest
+
+// ErrShardsNotFound is returned when we have no shard manifest for a root
+var ErrShardsNotFound = errors.New("shard manifest not found")
+
+// ErrNotEnoughShards is returned when fewer shards than required to reconstruct the content could be retrieved
+var ErrNotEnoughShards = errors.New("not enough shards")
+
+// KShards is the datastore key prefix under which shard manifests are persisted
+const KShards = "shards"
+
+// ShardTimeout is how long we wait to retrieve a shard from the network before trying the next one
+var ShardTimeout = 30 * time.Second
+
+// ShardManifest lists the Reed-Solomon shards a root was split into when committing with erasure coding.
+// Any K of the N shards are enough to reconstruct the content.
+type ShardManifest struct {
+	Root cid.Cid
+	K    uint64
+	N    uint64
+	// Size is the length of the CAR archive of the root DAG encoded in the shards
+	Size uint64
+	// Shards are the roots of the UnixFS DAGs holding each shard, data shards first
+	Shards []cid.Cid
+}
+
+func shardsKey(k cid.Cid) datastore.Key {
+	return datastore.NewKey(KShards).ChildString(k.String())
+}
+
+// PutShards persists a shard manifest under the root it encodes. It is kept after the root is
+// evicted so the content can be reconstructed from the shards.
+func (idx *Index) PutShards(m *ShardManifest) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	return idx.ds.Put(shardsKey(m.Root), buf.Bytes())
+}
+
+// GetShards returns the shard manifest for a given root
+func (idx *Index) GetShards(k cid.Cid) (*ShardManifest, error) {
+	data, err := idx.ds.Get(shardsKey(k))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, ErrShardsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(ShardManifest)
+	if err := m.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// encodeShards archives a DAG as a CAR and splits it into k data shards and n-k parity shards
+func encodeShards(ctx context.Context, dag ipldformat.DAGService, root cid.Cid, k, n int) ([][]byte, uint64, error) {
+	if k <= 0 || n <= k {
+		return nil, 0, fmt.Errorf("invalid erasure coding %d of %d shards", k, n)
+	}
+	buf := new(bytes.Buffer)
+	if err := car.WriteCar(ctx, dag, []cid.Cid{root}, buf); err != nil {
+		return nil, 0, err
+	}
+	size := uint64(buf.Len())
+	enc, err := reedsolomon.New(k, n-k)
+	if err != nil {
+		return nil, 0, err
+	}
+	shards, err := enc.Split(buf.Bytes())
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, 0, err
+	}
+	return shards, size, nil
+}
+
+// decodeShards reconstructs the CAR archive from the shards. Missing shards must be nil.
+func decodeShards(shards [][]byte, m *ShardManifest) ([]byte, error) {
+	enc, err := reedsolomon.New(int(m.K), int(m.N-m.K))
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := enc.Join(buf, shards, int(m.Size)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WithErasureCoding makes Commit split the content into n Reed-Solomon shards dispatched to different
+// providers instead of replicating the whole DAG. Any k shards are enough to reconstruct the content.
+func WithErasureCoding(k, n int) TxOption {
+	return func(tx *Tx) {
+		tx.shardK = k
+		tx.shardN = n
+	}
+}
+
+// WithShards retrieves the content by reconstructing it from the shards listed in the manifest
+// when querying instead of retrieving the root DAG directly
+func WithShards(m *ShardManifest) TxOption {
+	return func(tx *Tx) {
+		tx.shards = m
+	}
+}
+
+// Shards returns the shard manifest created when committing with erasure coding
+func (tx *Tx) Shards() *ShardManifest {
+	return tx.shards
+}
+
+// commitShards encodes the root into shards, stores each shard in its own store and persists
+// the manifest. It returns the records of the providers confirming they received a shard.
+func (tx *Tx) commitShards(opts DispatchOptions) (chan PRecord, error) {
+	data, size, err := encodeShards(tx.ctx, tx.store.DAG, tx.root, tx.shardK, tx.shardN)
+	if err != nil {
+		return nil, err
+	}
+	m := &ShardManifest{
+		Root: tx.root,
+		K:    uint64(tx.shardK),
+		N:    uint64(tx.shardN),
+		Size: size,
+	}
+	sizes := make([]int64, len(data))
+	for i, d := range data {
+		storeID := tx.ms.Next()
+		store, err := tx.ms.Get(storeID)
+		if err != nil {
+			return nil, err
+		}
+		tx.index.hold(storeID)
+		defer tx.index.release(storeID)
+		nd, err := importFile(tx.ctx, store.DAG, files.NewBytesFile(d), DefaultChunkerConfig, tx.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = int64(len(d))
+		err = tx.index.SetRef(&DataRef{
+			PayloadCID:  nd.Cid(),
+			StoreID:     storeID,
+			PayloadSize: sizes[i],
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.Shards = append(m.Shards, nd.Cid())
+	}
+	if err := tx.index.PutShards(m); err != nil {
+		return nil, err
+	}
+	tx.shards = m
+
+	records := make(chan PRecord, len(m.Shards))
+	go func() {
+		defer close(records)
+		// Every shard goes to a different provider so a single eviction only loses one shard
+		var exclude []peer.ID
+		for i, s := range m.Shards {
+			opts.RF = 1
+			opts.Exclude = exclude
+			for rec := range tx.repl.Dispatch(s, uint64(sizes[i]), opts) {
+				exclude = append(exclude, rec.Provider)
+				records <- rec
+			}
+		}
+	}()
+	return records, nil
+}
+
+// reconstruct retrieves enough shards to rebuild the content into the transaction store.
+// Shards we store are used first and the others are retrieved one at a time.
+func (tx *Tx) reconstruct(m *ShardManifest) {
+	shards := make([][]byte, len(m.Shards))
+	var remote []int
+	have := 0
+	for i, s := range m.Shards {
+		data, err := tx.loadShard(s)
+		if err != nil {
+			remote = append(remote, i)
+			continue
+		}
+		shards[i] = data
+		have++
+	}
+	res := TxResult{Spent: big.Zero()}
+	for _, i := range remote {
+		if have >= int(m.K) {
+			break
+		}
+		data, r, err := tx.retrieveShard(m.Shards[i])
+		if err != nil {
+			txLog.Warn().Err(err).Str("shard", m.Shards[i].String()).Msg("failed to retrieve shard")
+			continue
+		}
+		shards[i] = data
+		res.Size += r.Size
+		if !r.Spent.Nil() {
+			res.Spent = big.Add(res.Spent, r.Spent)
+		}
+		have++
+	}
+	if have < int(m.K) {
+		res.Err = fmt.Errorf("%w: %d of %d", ErrNotEnoughShards, have, m.K)
+		tx.done <- res
+		return
+	}
+	data, err := decodeShards(shards, m)
+	if err != nil {
+		res.Err = err
+		tx.done <- res
+		return
+	}
+	header, err := car.LoadCar(tx.store.Bstore, bytes.NewReader(data))
+	if err != nil {
+		res.Err = err
+		tx.done <- res
+		return
+	}
+	if len(header.Roots) != 1 || header.Roots[0] != m.Root {
+		res.Err = fmt.Errorf("reconstructed content does not match root %s", m.Root)
+	}
+	tx.done <- res
+}
+
+// loadShard reads a shard we store
+func (tx *Tx) loadShard(k cid.Cid) ([]byte, error) {
+	ref, err := tx.index.PeekRef(k)
+	if err != nil {
+		return nil, err
+	}
+	store, err := tx.index.OpenStore(ref.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	return readShard(tx.ctx, store, k)
+}
+
+// retrieveShard retrieves a shard from the network in a new transaction and reads it
+func (tx *Tx) retrieveShard(k cid.Cid) ([]byte, TxResult, error) {
+	if tx.newTx == nil {
+		return nil, TxResult{}, ErrNoStrategy
+	}
+	ctx, cancel := context.WithTimeout(tx.ctx, ShardTimeout)
+	defer cancel()
+	sub := tx.newTx(ctx, WithRoot(k), WithStrategy(SelectFirst))
+	defer sub.Close()
+	// The shard is only needed until the content is reconstructed
+	defer func() {
+		_ = tx.index.deleteStore(sub.StoreID())
+	}()
+	if err := sub.Query(selectors.All()); err != nil {
+		return nil, TxResult{}, err
+	}
+	select {
+	case res := <-sub.Done():
+		if res.Err != nil {
+			return nil, res, res.Err
+		}
+		data, err := readShard(ctx, sub.Store(), k)
+		return data, res, err
+	case <-ctx.Done():
+		return nil, TxResult{}, sub.noOfferErr(ctx.Err())
+	}
+}
+
+func readShard(ctx context.Context, store *multistore.Store, k cid.Cid) ([]byte, error) {
+	nd, err := store.DAG.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	f, err := unixfile.NewUnixfsFile(ctx, store.DAG, nd)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := f.(files.File)
+	if !ok {
+		return nil, fmt.Errorf("shard %s is not a file", k)
+	}
+	return ioutil.ReadAll(file)
+}
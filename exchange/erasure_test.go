@@ -0,0 +1,72 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErasureCoding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	p := n.CreateRandomFile(t, 300000)
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx, WithErasureCoding(3, 5))
+	require.NoError(t, tx.PutFile(p))
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+
+	m, err := exch.Index().GetShards(root)
+	require.NoError(t, err)
+	require.Equal(t, tx.Shards(), m)
+	require.Equal(t, 5, len(m.Shards))
+	tx.Close()
+
+	// The root and two of the shards are evicted
+	require.NoError(t, exch.Index().DropRef(root))
+	require.NoError(t, exch.Index().DropRef(m.Shards[0]))
+	require.NoError(t, exch.Index().DropRef(m.Shards[3]))
+
+	tx = exch.Tx(ctx, WithRoot(root))
+	defer tx.Close()
+	require.NoError(t, tx.Query(nil))
+	select {
+	case res := <-tx.Done():
+		require.NoError(t, res.Err)
+	case <-ctx.Done():
+		t.Fatal("content was not reconstructed")
+	}
+	f, err := tx.GetFile(FileKey(p).String())
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	// Too many shards are gone to reconstruct the content
+	require.NoError(t, exch.Index().DropRef(m.Shards[1]))
+	tx = exch.Tx(ctx, WithRoot(root))
+	defer tx.Close()
+	tx.newTx = nil
+	require.NoError(t, tx.Query(nil))
+	res := <-tx.Done()
+	require.True(t, errors.Is(res.Err, ErrNotEnoughShards))
+}
@@ -0,0 +1,158 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// Errors returned by the exchange so callers can branch on the cause of a failure with errors.Is
+var (
+	// ErrNoOffers is returned when no provider offered to serve the content before the deadline
+	ErrNoOffers = errors.New("no offers")
+	// ErrOfferTooExpensive is returned when the offers received were above the price the client accepts
+	ErrOfferTooExpensive = errors.New("offer too expensive")
+	// ErrTransferStalled is returned when a transfer stopped making progress
+	ErrTransferStalled = errors.New("transfer stalled")
+	// ErrStoreFull is returned when content is larger than the space the index may use
+	ErrStoreFull = errors.New("store full")
+	// ErrNotFoundRemote is returned when a provider doesn't have the content we tried to retrieve
+	ErrNotFoundRemote = errors.New("content not found on provider")
+	// ErrPaymentFailed is returned when a retrieval or a lease could not be paid
+	ErrPaymentFailed = errors.New("payment failed")
+)
+
+// Error codes identify the exchange errors across process boundaries such as the node RPC
+const (
+	CodeNoOffers          = "no_offers"
+	CodeOfferTooExpensive = "offer_too_expensive"
+	CodeTransferStalled   = "transfer_stalled"
+	CodeStoreFull         = "store_full"
+	CodeNotFoundRemote    = "not_found_remote"
+	CodePaymentFailed     = "payment_failed"
+)
+
+var errorCodes = []struct {
+	code string
+	err  error
+}{
+	{CodeNoOffers, ErrNoOffers},
+	{CodeOfferTooExpensive, ErrOfferTooExpensive},
+	{CodeTransferStalled, ErrTransferStalled},
+	{CodeStoreFull, ErrStoreFull},
+	{CodeNotFoundRemote, ErrNotFoundRemote},
+	{CodePaymentFailed, ErrPaymentFailed},
+}
+
+// ErrorCode returns the code of the exchange error wrapped by err or an empty string if there is none
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// ErrorFromCode rebuilds an error received with its code so errors.Is matches the exchange error.
+// The message is returned as a plain error if the code is unknown.
+func ErrorFromCode(code, msg string) error {
+	for _, c := range errorCodes {
+		if c.code == code {
+			return &codedError{err: c.err, msg: msg}
+		}
+	}
+	return errors.New(msg)
+}
+
+type codedError struct {
+	err error
+	msg string
+}
+
+func (e *codedError) Error() string {
+	return e.msg
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// dealFailures remembers why retrieval deals started failing so the error reported once a deal
+// ends carries the cause. It also tracks when we last heard from a deal to detect stalled transfers.
+type dealFailures struct {
+	mu     sync.Mutex
+	causes map[deal.ID]error
+	last   time.Time
+	// dropped are the deals we gave up on which must not fail the transaction when they end
+	dropped map[deal.ID]bool
+}
+
+func newDealFailures() *dealFailures {
+	return &dealFailures{
+		causes:  make(map[deal.ID]error),
+		last:    time.Now(),
+		dropped: make(map[deal.ID]bool),
+	}
+}
+
+// observe records a client event and returns an error if the deal failed
+func (f *dealFailures) observe(event client.Event, state deal.ClientState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.dropped[state.ID] {
+		return nil
+	}
+	f.last = time.Now()
+	switch event {
+	case client.EventPaymentChannelErrored,
+		client.EventAllocateLaneErrored,
+		client.EventBadPaymentRequested,
+		client.EventCreateVoucherFailed,
+		client.EventWriteDealPaymentErrored:
+		f.causes[state.ID] = ErrPaymentFailed
+	}
+	switch state.Status {
+	case deal.StatusDealNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFoundRemote, state.Message)
+	case deal.StatusRejected:
+		return errors.New(state.Message)
+	case deal.StatusCancelled, deal.StatusErrored:
+		cause, ok := f.causes[state.ID]
+		delete(f.causes, state.ID)
+		if ok {
+			return fmt.Errorf("%w: %s", cause, state.Message)
+		}
+		return errors.New(deal.Statuses[state.Status])
+	}
+	return nil
+}
+
+// idle returns how long since we last heard from a deal
+func (f *dealFailures) idle() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Since(f.last)
+}
+
+// drop ignores the events of a deal we gave up on
+func (f *dealFailures) drop(id deal.ID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropped[id] = true
+	delete(f.causes, id)
+}
+
+// reset restarts the idle time when a new deal starts
+func (f *dealFailures) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last = time.Now()
+}
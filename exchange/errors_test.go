@@ -0,0 +1,55 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCode(t *testing.T) {
+	for _, c := range errorCodes {
+		err := fmt.Errorf("%w: something happened", c.err)
+		require.Equal(t, c.code, ErrorCode(err))
+
+		// The error received over RPC still matches the exchange error
+		rerr := ErrorFromCode(ErrorCode(err), err.Error())
+		require.True(t, errors.Is(rerr, c.err))
+		require.Equal(t, err.Error(), rerr.Error())
+	}
+
+	require.Equal(t, "", ErrorCode(nil))
+	require.Equal(t, "", ErrorCode(errors.New("unknown")))
+
+	rerr := ErrorFromCode("", "unknown")
+	require.Equal(t, "unknown", rerr.Error())
+	require.Equal(t, "", ErrorCode(rerr))
+}
+
+func TestDealFailures(t *testing.T) {
+	f := newDealFailures()
+
+	state := deal.ClientState{ID: deal.ID(1), Status: deal.StatusOngoing}
+	require.NoError(t, f.observe(client.EventBlocksReceived, state))
+
+	// A payment failure is the cause of the deal erroring
+	state.Status = deal.StatusFailing
+	require.NoError(t, f.observe(client.EventCreateVoucherFailed, state))
+	state.Status = deal.StatusErrored
+	require.True(t, errors.Is(f.observe(client.EventCancelComplete, state), ErrPaymentFailed))
+
+	// The cause is forgotten once the deal ended
+	state = deal.ClientState{ID: deal.ID(2), Status: deal.StatusErrored}
+	err := f.observe(client.EventCancelComplete, state)
+	require.Error(t, err)
+	require.Equal(t, "", ErrorCode(err))
+
+	state = deal.ClientState{ID: deal.ID(3), Status: deal.StatusDealNotFound, Message: "not found"}
+	require.True(t, errors.Is(f.observe(client.EventDealNotFound, state), ErrNotFoundRemote))
+
+	f.reset()
+	require.Less(t, int64(f.idle()), int64(DefaultStallTimeout))
+}
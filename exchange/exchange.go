@@ -2,18 +2,23 @@ package exchange
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"io"
+	"time"
 
 	"github.com/filecoin-project/go-address"
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-graphsync"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/payments"
 	"github.com/myelnet/pop/retrieval"
 	"github.com/myelnet/pop/retrieval/client"
@@ -23,6 +28,16 @@ import (
 	"github.com/myelnet/pop/wallet"
 )
 
+// Loggers of the subsystems implemented by the exchange
+var (
+	indexLog     = logging.Subsystem(logging.Index)
+	txLog        = logging.Subsystem(logging.Tx)
+	discoveryLog = logging.Subsystem(logging.Discovery)
+)
+
+// ErrPublishOnly is returned when a publish only exchange is asked to serve content
+var ErrPublishOnly = errors.New("exchange only publishes content")
+
 // Exchange is a financially incentivized IPLD  block exchange
 // powered by Filecoin and IPFS
 type Exchange struct {
@@ -41,6 +56,49 @@ type Exchange struct {
 	rpl *Replication
 	// Index keeps track of all content stored under this exchange
 	idx *Index
+	// Invalidator handles cache invalidations from content publishers
+	inv *Invalidator
+	// Announcer gossips the changes to our index
+	ann *IndexAnnouncer
+	// Reputation scores the providers we retrieve from
+	rep *Reputation
+	// Leases sells and buys storage leases
+	lea *Leases
+	// Delegation retrieves content on behalf of the light clients we trust
+	dlg *Delegation
+	// Revalidator checks if the names we track point to new roots
+	rev *Revalidator
+	// Recorder captures events for debugging when a recording is running
+	rec *Recorder
+	// Audit keeps a hash chained log of the retrievals we served
+	aud *AuditLog
+	// Receipts keeps a signed receipt of the retrievals we paid for
+	rct *Receipts
+	// Standby replicates our state to our standbys or the state of our primary
+	sby *Standby
+	// Tags name the roots of our content
+	tags *Tags
+	// IPNS publishes the roots we commit under the keys of our keystore
+	ipns *IPNS
+	// names caches the names resolved to retrieve content
+	names *NameCache
+	// bitswap retrieves the content no provider offers from the public IPFS network if enabled
+	bitswap *BitswapFallback
+	// blind matches the salted roots of private queries with our roots
+	blind *BlindIndex
+	// dht advertises our roots and finds the providers the gossip network doesn't reach if enabled
+	dht *DHTRouting
+	// pay manages the payment channels used during retrievals
+	pay *payments.Payments
+	// prc is the default pricing policy, pol the policy queries are priced with
+	prc *Pricing
+	pol PricingPolicy
+	// acl controls which peers may query and retrieve our refs
+	acl *ACL
+	// prb measures the network health by retrieving canaries
+	prb *Prober
+	// pex learns pop peers from the bootstrap pops and the peers we know
+	pex *PeerExchange
 }
 
 // New creates a long running exchange process from a libp2p host, an IPFS datastore and some optional
@@ -50,26 +108,105 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 	if err != nil {
 		return nil, err
 	}
-	idx, err := NewIndex(
-		ds,
-		opts.MultiStore,
-		// leave a 20% lower bound so we don't evict too frequently
-		WithBounds(opts.Capacity, opts.Capacity-uint64(math.Round(float64(opts.Capacity)*0.2))),
-	)
-	if err != nil {
-		return nil, err
-	}
 	// register a pubsub topic for each region
 	exch := &Exchange{
 		h:    h,
 		ds:   ds,
 		opts: opts,
-		idx:  idx,
-		rou:  NewGossipRouting(h, opts.PubSub, opts.GossipTracer, opts.Regions),
+		rou:  NewGossipRouting(h, opts.PubSub, opts.GossipTracer, opts.Regions, opts.TopicShards),
 		w:    wallet.NewFromKeystore(opts.Keystore, opts.FilecoinAPI),
+		rec:  &Recorder{},
+	}
+	idxOpts := []IndexOption{
+		WithBounds(capacityBounds(opts.Capacity)),
+		WithPublisherShare(opts.PublisherShare),
+		WithCategoryQuotas(opts.CategoryLabel, opts.CategoryQuotas),
+		WithInterestPolicy(opts.MaxInterest, opts.InterestHalfLife),
+		// reads make content more popular so we check if anything is worth replicating
+		WithUpdateFunc(exch.indexRead),
+		WithRecorder(exch.rec),
+		WithRemoteReadWeight(opts.RemoteReadWeight),
+		WithMemoryBounds(opts.MaxRefs, opts.MaxIndexBuckets),
+	}
+	if opts.DiskAccounting {
+		idxOpts = append(idxOpts, WithDiskAccounting())
+	}
+	if opts.EvictionVeto != nil {
+		idxOpts = append(idxOpts, WithEvictionVeto(opts.EvictionVeto))
+	}
+	if opts.FastStart {
+		idxOpts = append(idxOpts, WithFastStart())
+	}
+	if opts.IndexFlushInterval > 0 {
+		idxOpts = append(idxOpts, WithFlushInterval(opts.IndexFlushInterval))
+	}
+	if opts.SlowBlockThreshold != 0 {
+		idxOpts = append(idxOpts, WithSlowBlockThreshold(opts.SlowBlockThreshold))
+	}
+	idx, err := NewIndex(ds, opts.MultiStore, idxOpts...)
+	if err != nil {
+		return nil, err
 	}
+	// Contracted providers may not be discovered on the network
+	for _, c := range opts.Contracts {
+		if len(c.Addrs) > 0 {
+			h.Peerstore().AddAddrs(c.Peer, c.Addrs, peerstore.PermanentAddrTTL)
+		}
+	}
+	exch.idx = idx
+	// Serving content makes it more popular like local reads
+	opts.GraphSync.RegisterCompletedResponseListener(exch.responseCompleted)
 	exch.rpl = NewReplication(h, idx, opts.DataTransfer, exch, opts.Regions)
+	// Providers answering our queries are responsive peers to dispatch to
+	exch.rou.responded = exch.rpl.pm.RecordResponse
 	exch.rpl.interval = opts.RepInterval
+	exch.rpl.guard = opts.Guard
+	exch.rpl.bw = opts.Bandwidth
+	exch.rpl.auto = NewAutoReplicator(idx, exch, opts.ReplicationBandwidth, opts.MaxReplications)
+	exch.rpl.market = opts.HostingMarket
+	exch.rpl.auto.market = opts.HostingMarket
+	exch.inv = NewInvalidator(h, opts.PubSub, ds, idx, exch)
+	exch.ann = NewIndexAnnouncer(h, opts.PubSub, idx, exch.rpl.queueIndex)
+	exch.ann.interval = opts.RepInterval
+	exch.rep, err = NewReputation(ds)
+	if err != nil {
+		return nil, err
+	}
+	exch.rev, err = NewRevalidator(ds, idx, opts.NameResolver, exch)
+	if err != nil {
+		return nil, err
+	}
+	exch.rev.interval = opts.RevalidateInterval
+	exch.aud, err = NewAuditLog(ds)
+	if err != nil {
+		return nil, err
+	}
+	exch.tags = NewTags(ds)
+	exch.blind = NewBlindIndex(idx)
+	exch.rou.private = opts.PrivateQueries
+	exch.rou.saltPeriod = opts.SaltPeriod
+	exch.acl = NewACL(ds, opts.ACL)
+	exch.rct = NewReceipts(ds, h.ID(), h.Peerstore().PrivKey(h.ID()))
+	exch.prc = NewPricing(opts.Pricing)
+	exch.pol = opts.PricingPolicy
+	if exch.pol == nil {
+		exch.pol = exch.prc
+	}
+	// Tracked names are revalidated without the cache so a new root is noticed as soon as possible
+	exch.names = NewNameCache(opts.NameResolver, opts.NameCacheTTL)
+	if opts.EnableBitswapFallback {
+		exch.bitswap = NewBitswapFallback(ctx, h, opts.ContentRouting, ds)
+		exch.bitswap.delay = opts.BitswapFallbackDelay
+	}
+	if opts.ValueStore != nil {
+		exch.ipns = NewIPNS(opts.ValueStore)
+	}
+	if opts.EnableDHT {
+		exch.dht = NewDHTRouting(h, opts.ContentRouting, idx)
+		exch.dht.delay = opts.DHTFallbackDelay
+		// A publish only exchange doesn't serve the roots it stores
+		exch.dht.provide = !opts.PublishOnly
+	}
 	// Make a new default key to be sure we have an address where to receive our payments
 	if exch.w.DefaultAddress() == address.Undef {
 		_, err = exch.w.NewKey(ctx, wallet.KTSecp256k1)
@@ -77,11 +214,16 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 			return nil, err
 		}
 	}
+	var payOpts []payments.Option
+	if !opts.RedeemThreshold.Nil() {
+		payOpts = append(payOpts, payments.WithRedeemThreshold(opts.RedeemThreshold))
+	}
+	exch.pay = payments.New(ctx, opts.FilecoinAPI, exch.w, ds, opts.Blockstore, payOpts...)
 	exch.rtv, err = retrieval.New(
 		ctx,
 		opts.MultiStore,
 		ds,
-		payments.New(ctx, opts.FilecoinAPI, exch.w, ds, opts.Blockstore),
+		exch.pay,
 		opts.DataTransfer,
 		idx,
 		h.ID(),
@@ -89,16 +231,101 @@ func New(ctx context.Context, h host.Host, ds datastore.Batching, opts Options)
 	if err != nil {
 		return nil, err
 	}
+	exch.rtv.Provider().SetGuard(opts.Guard)
+	exch.rtv.Provider().SetBandwidth(opts.Bandwidth)
+	exch.rtv.Provider().SetAccessFilter(func(p peer.ID, k cid.Cid) error {
+		return exch.acl.Check(p, k)
+	})
+	exch.rtv.Client().SubscribeToEvents(exch.rep.handleClientEvent)
+	exch.rtv.Client().SubscribeToEvents(exch.rec.recordClientDeal)
+	exch.rtv.Client().SubscribeToEvents(exch.rct.recordClientDeal)
+	exch.rtv.Provider().SubscribeToEvents(exch.rec.recordProviderDeal)
+	exch.rtv.Provider().SubscribeToEvents(exch.aud.recordProviderDeal)
+	exch.rtv.Provider().SubscribeToEvents(exch.prc.recordProviderDeal)
+	opts.DataTransfer.SubscribeToEvents(exch.rec.recordTransfer)
+	if err := exch.rec.recordHeys(ctx, h.EventBus()); err != nil {
+		return nil, err
+	}
+	exch.lea = NewLeases(h, idx, exch.pay, exch.w, opts.LeasePrice)
+	exch.dlg = NewDelegation(h, idx, exch.acl, exch.FindAndRetrieve, opts.Delegators)
 	if err := exch.rpl.Start(ctx); err != nil {
 		return nil, err
 	}
-	if err := exch.rou.StartProviding(ctx, exch.handleQuery); err != nil {
+	exch.pex = NewPeerExchange(h, exch.rpl.pm, opts.Regions, opts.Bootstrap)
+	exch.pex.minPeers = opts.MinPeers
+	exch.pex.interval = opts.BootstrapInterval
+	exch.pex.Start(ctx)
+	if err := exch.rou.StartProviding(ctx, exch.rec.recordQuery(exch.handleQuery)); err != nil {
+		return nil, err
+	}
+	if opts.TopicShards > 0 {
+		go exch.provideShards(ctx)
+	}
+	if exch.dht != nil {
+		exch.dht.Start(ctx)
+	}
+	if err := exch.inv.Start(ctx); err != nil {
 		return nil, err
 	}
+	if err := exch.ann.Start(ctx); err != nil {
+		return nil, err
+	}
+	exch.rev.Start(ctx)
+	exch.prb = NewProber(exch, opts.ProbeCanaries)
+	exch.prb.interval = opts.ProbeInterval
+	exch.prb.Start(ctx)
+	exch.sby = NewStandby(h, ds, idx, opts.StandbyOf, opts.Standbys)
+	if opts.StandbyInterval > 0 {
+		exch.sby.interval = opts.StandbyInterval
+	}
+	exch.sby.Start(ctx)
+	if opts.IndexFlushInterval > 0 {
+		go func() {
+			<-ctx.Done()
+			// Persist the changes waiting for the next flush
+			if err := idx.Sync(); err != nil {
+				indexLog.Error().Err(err).Msg("failed to sync index")
+			}
+		}()
+	}
 	return exch, nil
 }
 
+// responseCompleted registers the roots served over graphsync as remote reads in the index
+func (e *Exchange) responseCompleted(p peer.ID, req graphsync.RequestData, status graphsync.ResponseStatusCode) {
+	if status != graphsync.RequestCompletedFull && status != graphsync.RequestCompletedPartial {
+		return
+	}
+	// The content may have been evicted while it was served
+	if err := e.idx.RemoteRead(req.Root()); err != nil && !errors.Is(err, ErrRefNotFound) {
+		indexLog.Warn().Err(err).Str("root", req.Root().String()).Msg("failed to register remote read")
+	}
+}
+
+// indexRead is called after every index read
+func (e *Exchange) indexRead() {
+	if e.rpl != nil {
+		e.rpl.Replicate()
+	}
+}
+
 func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.Query) (deal.QueryResponse, error) {
+	// Content is only served by the provider role
+	if e.opts.PublishOnly {
+		return deal.QueryResponse{}, ErrPublishOnly
+	}
+	// Private queries carry a salted hash of the root we match with the keys of our index hashed the same way
+	if IsBlinded(q.PayloadCID) {
+		root, err := e.blind.Resolve(q.PayloadCID)
+		if err != nil {
+			return deal.QueryResponse{}, err
+		}
+		q.PayloadCID = root
+	}
+	// We don't reply to peers which may not retrieve the content so private content stays hidden
+	if err := e.acl.Check(p, q.PayloadCID); err != nil {
+		return deal.QueryResponse{}, err
+	}
 	store, err := e.idx.GetStore(q.PayloadCID)
 	if err != nil {
 		return deal.QueryResponse{}, err
@@ -122,13 +349,19 @@ func (e *Exchange) handleQuery(ctx context.Context, p peer.ID, r Region, q deal.
 		Status:                     deal.QueryResponseAvailable,
 		Size:                       uint64(stats.Size),
 		PaymentAddress:             e.w.DefaultAddress(),
-		MinPricePerByte:            r.PPB, // TODO: dynamic pricing
 		MaxPaymentInterval:         deal.DefaultPaymentInterval,
 		MaxPaymentIntervalIncrease: deal.DefaultPaymentIntervalIncrease,
+		Region:                     r.Name,
 	}
+	resp.MinPricePerByte = e.pol.Price(PricingRequest{
+		Peer:   p,
+		Root:   q.PayloadCID,
+		Size:   resp.Size,
+		Region: r,
+	})
 	// We need to remember the offer we made so we can validate against it once
-	// clients start the retrieval
-	e.rtv.Provider().SetAsk(q.PayloadCID, resp)
+	// clients start the retrieval. The price may depend on who is asking.
+	e.rtv.Provider().SetPeerAsk(p, q.PayloadCID, resp)
 	return resp, nil
 }
 
@@ -139,12 +372,21 @@ func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 	// Track when the session is completed
 	done := make(chan TxResult, 1)
 	// Track any issues with the transfer
-	errs := make(chan deal.Status)
+	errs := make(chan error)
+	failures := newDealFailures()
+	progress := newTxProgress()
+	deals := newTxDeals()
+	ms := e.opts.MultiStore
+	storeID := ms.Next()
 	// Subscribe to client events to send to the channel
 	cl := e.rtv.Client()
 	unsubscribe := cl.SubscribeToEvents(func(event client.Event, state deal.ClientState) {
-		switch state.Status {
-		case deal.StatusCompleted:
+		// Only deals writing to our store report the progress of this transaction
+		if state.StoreID != nil && *state.StoreID == storeID {
+			progress.observe(event, state)
+		}
+		deals.observe(state)
+		if state.Status == deal.StatusCompleted {
 			select {
 			case done <- TxResult{
 				Size:  state.TotalReceived,
@@ -153,39 +395,56 @@ func (e *Exchange) Tx(ctx context.Context, opts ...TxOption) *Tx {
 			default:
 			}
 			return
-		case deal.StatusCancelled, deal.StatusErrored:
+		}
+		if err := failures.observe(event, state); err != nil {
 			select {
-			case errs <- state.Status:
+			case errs <- err:
 			default:
 			}
-			return
 		}
 	})
-	ms := e.opts.MultiStore
-	storeID := ms.Next()
-	store, err := ms.Get(storeID)
+	// Blocks added to the transaction are deduplicated with the content we already store
+	store, err := e.idx.OpenStore(storeID)
+	// The store isn't indexed until the transaction is committed
+	e.idx.hold(storeID)
 	tx := &Tx{
-		ctx:        ctx,
-		cancelCtx:  cancel,
-		ms:         e.opts.MultiStore,
-		rou:        e.rou,
-		retriever:  cl,
-		index:      e.idx,
-		repl:       e.rpl,
-		chunkSize:  256000,
-		cacheRF:    6,
-		clientAddr: e.w.DefaultAddress(),
-		sel:        selectors.All(),
-		done:       done,
-		errs:       errs,
-		ongoing:    make(chan DealRef),
+		ctx:           ctx,
+		cancelCtx:     cancel,
+		ms:            e.opts.MultiStore,
+		rou:           e.rou,
+		retriever:     cl,
+		index:         e.idx,
+		repl:          e.rpl,
+		chunkSize:     DefaultChunkSize,
+		chunker:       DefaultChunkerConfig,
+		stallTimeout:  e.opts.StallTimeout,
+		stallRestarts: e.opts.StallRestarts,
+		contracts:     e.opts.Contracts,
+		rec:           e.rec,
+		cacheRF:       6,
+		clientAddr:    e.w.DefaultAddress(),
+		sel:           selectors.All(),
+		done:          done,
+		errs:          errs,
+		failures:      failures,
+		progress:      progress,
+		deals:         deals,
+		ongoing:       make(chan DealRef),
 		// Triage should be manually activated with WithTriage option
 		// triage:  make(chan DealSelection),
-		entries: make(map[string]Entry),
-		unsub:   unsubscribe,
-		storeID: storeID,
-		store:   store,
-		Err:     err,
+		entries:  make(map[string]Entry),
+		unsub:    unsubscribe,
+		storeID:  storeID,
+		store:    store,
+		newTx:    e.Tx,
+		ipns:     e.ipns,
+		ks:       e.opts.Keystore,
+		names:    e.names,
+		bitswap:  e.bitswap,
+		dht:      e.dht,
+		filAPI:   e.opts.FilecoinAPI,
+		filDelay: e.opts.FilecoinFallbackDelay,
+		Err:      err,
 	}
 	for _, opt := range opts {
 		opt(tx)
@@ -215,7 +474,27 @@ func (e *Exchange) FindAndRetrieve(ctx context.Context, root cid.Cid) error {
 			PayloadSize: int64(res.Size),
 		})
 	case <-ctx.Done():
-		return ctx.Err()
+		return tx.noOfferErr(ctx.Err())
+	}
+}
+
+// FindAndRetrieveEntries retrieves the root block and the given entries of a DAG from the network
+// without adding it to the index. The store holding them is passed to fn before the transaction closes.
+func (e *Exchange) FindAndRetrieveEntries(ctx context.Context, root cid.Cid, keys []string, fn func(multistore.StoreID) error) error {
+	tx := e.Tx(ctx, WithRoot(root), WithStrategy(SelectFirst))
+	defer tx.Close()
+	err := tx.Query(sel.Keys(keys...))
+	if err != nil {
+		return err
+	}
+	select {
+	case res := <-tx.Done():
+		if res.Err != nil {
+			return res.Err
+		}
+		return fn(tx.StoreID())
+	case <-ctx.Done():
+		return tx.noOfferErr(ctx.Err())
 	}
 }
 
@@ -250,15 +529,171 @@ func (e *Exchange) R() *Replication {
 	return e.rpl
 }
 
+// Purge broadcasts a signed message asking caches we dispatched the given root to drop it
+func (e *Exchange) Purge(ctx context.Context, root cid.Cid) error {
+	return e.inv.Purge(ctx, root)
+}
+
+// Supersede broadcasts a signed message asking caches to replace a root we dispatched
+// with a newer version
+func (e *Exchange) Supersede(ctx context.Context, root cid.Cid, successor cid.Cid) error {
+	return e.inv.Supersede(ctx, root, successor)
+}
+
+// Track resolves a mutable name and keeps checking if it points to a new root so the previous
+// roots are evicted first and the new ones are retrieved
+func (e *Exchange) Track(ctx context.Context, name string) (cid.Cid, error) {
+	return e.rev.Track(ctx, name)
+}
+
+// Untrack stops checking a mutable name
+func (e *Exchange) Untrack(name string) error {
+	return e.rev.Untrack(name)
+}
+
+// Names returns the mutable names we track with the root they last pointed to
+func (e *Exchange) Names() []NameBinding {
+	return e.rev.Bindings()
+}
+
+// Lease pays a provider to keep a root from being evicted for a given duration and returns
+// when the lease expires
+func (e *Exchange) Lease(ctx context.Context, p peer.ID, root cid.Cid, d time.Duration) (time.Time, error) {
+	return e.lea.Lease(ctx, p, root, d)
+}
+
+// Search returns the roots we store or know about from the interest list with entry names
+// or publishers matching the query
+func (e *Exchange) Search(query string) ([]SearchResult, error) {
+	return e.idx.Search(query)
+}
+
+// Publishers returns how much of the cache is used by the content of each publisher, largest first
+func (e *Exchange) Publishers() []PublisherUsage {
+	return e.idx.Publishers()
+}
+
+// Categories returns how much of its quota each category of content uses if the capacity is partitioned
+func (e *Exchange) Categories() []CategoryUsage {
+	return e.idx.Categories()
+}
+
+// Peers returns the state of the peers we share a region with, best first
+func (e *Exchange) Peers() []PeerState {
+	return e.rpl.pm.List()
+}
+
+// Prober returns the service measuring the network health with canaries
+func (e *Exchange) Prober() *Prober {
+	return e.prb
+}
+
+// Reputation returns the store scoring the providers we retrieve from
+func (e *Exchange) Reputation() *Reputation {
+	return e.rep
+}
+
+// Standby returns the service replicating our state to our standbys or the state of our primary
+func (e *Exchange) Standby() *Standby {
+	return e.sby
+}
+
+// Audit returns the log of the retrievals we served
+func (e *Exchange) Audit() *AuditLog {
+	return e.aud
+}
+
+// Receipts returns the signed receipts of the retrievals we paid for
+func (e *Exchange) Receipts() *Receipts {
+	return e.rct
+}
+
+// Pricing returns the default pricing policy, it is used unless a custom policy was given
+func (e *Exchange) Pricing() *Pricing {
+	return e.prc
+}
+
+// Payments returns the manager of the payment channels used during retrievals
+func (e *Exchange) Payments() *payments.Payments {
+	return e.pay
+}
+
+// ACL returns the access rules deciding which peers may retrieve our refs
+func (e *Exchange) ACL() *ACL {
+	return e.acl
+}
+
+// Tags returns the names given to roots
+func (e *Exchange) Tags() *Tags {
+	return e.tags
+}
+
+// ResolvePath parses a path whose root may be a CID, a tag, an /ipns/ name or a domain with a DNSLink
+// record. Tags take precedence over domains with the same name.
+func (e *Exchange) ResolvePath(ctx context.Context, s string) (Path, error) {
+	s, err := resolveNamedPath(ctx, e.names, s)
+	if err != nil {
+		return Path{}, err
+	}
+	p, err := e.tags.ResolvePath(s)
+	if !errors.Is(err, ErrTagNotFound) {
+		return p, err
+	}
+	resolved, rerr := resolveDomainPath(ctx, e.names, s)
+	if rerr != nil {
+		return Path{}, rerr
+	}
+	if resolved == s {
+		return p, err
+	}
+	return ParsePath(resolved)
+}
+
+// IPNS returns the IPNS publisher if the exchange has a value store to publish records in
+func (e *Exchange) IPNS() *IPNS {
+	return e.ipns
+}
+
+// Record captures the protocol messages and state transitions of the exchange into the writer for the
+// duration of the window. The log starts with a snapshot of the index so it can be replayed.
+func (e *Exchange) Record(w io.WriteCloser, window time.Duration) error {
+	return e.idx.startRecording(e.rec, e.h.ID(), func() error {
+		return e.rec.Start(w, window)
+	})
+}
+
+// StopRecording ends the running recording if any
+func (e *Exchange) StopRecording() error {
+	return e.rec.Stop()
+}
+
+// Recording returns the time until which events are recorded if a recording is running
+func (e *Exchange) Recording() (time.Time, bool) {
+	return e.rec.Recording()
+}
+
+// PeerScores returns the reputation of all the providers we retrieved from, best first
+func (e *Exchange) PeerScores() []PeerScore {
+	return e.rep.Scores()
+}
+
+// PeerExchange returns the service learning pop peers from the bootstrap pops
+func (e *Exchange) PeerExchange() *PeerExchange {
+	return e.pex
+}
+
 // Index returns the exchange data index
 func (e *Exchange) Index() *Index {
 	return e.idx
 }
 
-// ListMiners returns a list of miners based on the regions this exchange is part of
-// We keep a context as this could also query a remote service or API
+// ListMiners returns the configured storage miners or a list of miners based on the regions this exchange
+// is part of. We keep a context as this could also query a remote service or API
 func (e *Exchange) ListMiners(ctx context.Context) ([]address.Address, error) {
-	var strList []string
+	strList := e.opts.StorageMiners
+	if len(strList) > 0 {
+		return parseMiners(strList)
+	}
 	for _, r := range e.opts.Regions {
 		// Global region is already a list of miners in all regions
 		if r.Name == "Global" {
@@ -267,6 +702,10 @@ func (e *Exchange) ListMiners(ctx context.Context) ([]address.Address, error) {
 		}
 		strList = append(strList, r.StorageMiners...)
 	}
+	return parseMiners(strList)
+}
+
+func parseMiners(strList []string) ([]address.Address, error) {
 	var addrList []address.Address
 	for _, s := range strList {
 		addr, err := address.NewFromString(s)
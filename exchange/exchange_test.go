@@ -93,6 +93,18 @@ func TestSelectionStrategies(t *testing.T) {
 			offers:   11,
 			failures: 4,
 		},
+		{
+			name:     "SelectCheapestWithin deadline",
+			strategy: SelectCheapestWithin(500 * time.Millisecond),
+			offers:   11,
+			failures: 0,
+		},
+		{
+			name:     "SelectCheapestWithin deadline failing",
+			strategy: SelectCheapestWithin(500 * time.Millisecond),
+			offers:   11,
+			failures: 3,
+		},
 		{
 			name:     "SelectFirstLowerThan",
 			strategy: SelectFirstLowerThan(abi.NewTokenAmount(5)),
@@ -138,6 +150,119 @@ func TestSelectionStrategies(t *testing.T) {
 	}
 }
 
+func TestSelectCheapestWithinTotalPrice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exec := testExecutor{
+		done: make(chan deal.Offer, 1),
+		err:  make(chan error, 1),
+	}
+	wq := SelectCheapestWithin(200 * time.Millisecond)(exec)
+	wq.Start()
+
+	offers := []struct {
+		id     string
+		ppb    int64
+		size   uint64
+		unseal int64
+	}{
+		// Cheapest price per byte but expensive to unseal
+		{"unsealing", 1, 1000, 5000},
+		{"expensive", 5, 1000, 0},
+		// Both offers have the same total price but the first one responded faster
+		{"fast", 2, 1000, 0},
+		{"slow", 2, 1000, 0},
+	}
+	for _, o := range offers {
+		wq.ReceiveResponse(peer.AddrInfo{ID: peer.ID(o.id)}, deal.QueryResponse{
+			Size:            o.size,
+			MinPricePerByte: abi.NewTokenAmount(o.ppb),
+			UnsealPrice:     abi.NewTokenAmount(o.unseal),
+		})
+	}
+	exec.SetError(nil)
+
+	select {
+	case of := <-exec.done:
+		require.Equal(t, peer.ID("fast"), of.Provider.ID)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	require.Equal(t, 3, len(wq.Close()))
+}
+
+// If no offer was received by the deadline we should execute the first one we get
+func TestSelectCheapestWithinNoOffers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exec := testExecutor{
+		done: make(chan deal.Offer, 1),
+		err:  make(chan error, 1),
+	}
+	wq := SelectCheapestWithin(50 * time.Millisecond)(exec)
+	wq.Start()
+
+	time.Sleep(100 * time.Millisecond)
+
+	wq.ReceiveResponse(peer.AddrInfo{ID: peer.ID("late")}, deal.QueryResponse{
+		MinPricePerByte: abi.NewTokenAmount(1),
+	})
+	exec.SetError(nil)
+
+	select {
+	case of := <-exec.done:
+		require.Equal(t, peer.ID("late"), of.Provider.ID)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	_ = wq.Close()
+}
+
+func TestSelectPreferredRegions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	exec := testExecutor{
+		done: make(chan deal.Offer, 1),
+		err:  make(chan error, 1),
+	}
+	tx := &Tx{regions: []Region{Regions["Europe"]}}
+	sw := SelectCheapestWithin(200 * time.Millisecond)(exec).(sessionWorker)
+	sw.rank = tx.preferRegions(sw.rank)
+	sw.Start()
+
+	offers := []struct {
+		id     string
+		ppb    int64
+		region string
+	}{
+		{"cheap", 1, "Asia"},
+		{"expensive", 5, "Europe"},
+		{"fair", 2, "Europe"},
+	}
+	for _, o := range offers {
+		sw.ReceiveResponse(peer.AddrInfo{ID: peer.ID(o.id)}, deal.QueryResponse{
+			Size:            1000,
+			MinPricePerByte: abi.NewTokenAmount(o.ppb),
+			Region:          o.region,
+		})
+	}
+	exec.SetError(nil)
+
+	select {
+	case of := <-exec.done:
+		// The cheapest offer in our preferred region wins
+		require.Equal(t, peer.ID("fair"), of.Provider.ID)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	rest := sw.Close()
+	require.Equal(t, 2, len(rest))
+	require.Equal(t, peer.ID("expensive"), rest[0].Provider.ID)
+}
+
 // Stress test strategies to make sure they scale well to handle hundreds of offers
 func BenchmarkStrategies(b *testing.B) {
 	testCases := []struct {
@@ -375,7 +500,7 @@ func TestExchangeJoiningNetwork(t *testing.T) {
 					ptx.WatchDispatch(func(rec PRecord) {
 						// No need to check
 					})
-					content[KeyFromPath(fname)] = ptx.Root()
+					content[FileKey(fname).String()] = ptx.Root()
 					ptx.Close()
 				}
 			}
@@ -420,3 +545,36 @@ func TestExchangeJoiningNetwork(t *testing.T) {
 		})
 	}
 }
+
+func TestPublishOnly(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath:    n.DTTmpDir,
+		Keystore:    keystore.NewMemKeystore(),
+		LeasePrice:  abi.NewTokenAmount(1),
+		PublishOnly: true,
+	})
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx)
+	defer tx.Close()
+	require.NoError(t, tx.PutFile(n.CreateRandomFile(t, 56000)))
+	require.NoError(t, tx.Commit())
+
+	// The content we publish isn't served to the peers querying it
+	params, err := deal.NewQueryParams(sel.All())
+	require.NoError(t, err)
+	_, err = exch.handleQuery(ctx, peer.ID("client"), global, deal.Query{
+		PayloadCID:  tx.Root(),
+		QueryParams: params,
+	})
+	require.True(t, errors.Is(err, ErrPublishOnly))
+
+	// Content dispatched by other peers is declined and leases aren't sold
+	require.False(t, bid(ctx, exch.opts.HostingMarket, HostingRequest{Root: tx.Root(), Source: Dispatched}))
+	require.True(t, exch.lea.price.Nil())
+}
@@ -0,0 +1,269 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MaxSharedBlocksSize is the maximum number of bytes of blocks kept in memory for each content dispatched
+// so providers pulling the same content at the same time don't each read the blocks from the store
+var MaxSharedBlocksSize uint64 = 32 << 20
+
+// LoaderSharer shares the blocks read from a store between the transfers sending the same content
+type LoaderSharer interface {
+	ShareLoader(cid.Cid, ipld.Loader) ipld.Loader
+}
+
+// sharedBlocks are the blocks recently read for a root being dispatched
+type sharedBlocks struct {
+	// users is the number of dispatches of the root in progress
+	users  int
+	blocks map[string][]byte
+	// order is the order in which blocks were read so the oldest are dropped first
+	order []string
+	size  uint64
+	// pace limits the rate at which the blocks are sent if the publisher set an uplink budget
+	pace *pacer
+}
+
+// fanout keeps the blocks of content being dispatched to several providers at once
+type fanout struct {
+	mu    sync.Mutex
+	roots map[cid.Cid]*sharedBlocks
+}
+
+func newFanout() *fanout {
+	return &fanout{
+		roots: make(map[cid.Cid]*sharedBlocks),
+	}
+}
+
+// open starts sharing the blocks of a root until close is called
+func (f *fanout) open(root cid.Cid) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, ok := f.roots[root]
+	if !ok {
+		sb = &sharedBlocks{blocks: make(map[string][]byte)}
+		f.roots[root] = sb
+	}
+	sb.users++
+}
+
+// close releases the blocks once no dispatch of the root is in progress
+func (f *fanout) close(root cid.Cid) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, ok := f.roots[root]
+	if !ok {
+		return
+	}
+	sb.users--
+	if sb.users <= 0 {
+		delete(f.roots, root)
+	}
+}
+
+// limit paces the blocks sent for a root to stay within a bandwidth budget shared by all its transfers
+func (f *fanout) limit(root cid.Cid, bandwidth uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, ok := f.roots[root]
+	if !ok {
+		return
+	}
+	sb.pace = newPacer(bandwidth)
+}
+
+// wait blocks until the budget of a root allows sending n bytes
+func (f *fanout) wait(root cid.Cid, n int) {
+	f.mu.Lock()
+	var pace *pacer
+	if sb, ok := f.roots[root]; ok {
+		pace = sb.pace
+	}
+	f.mu.Unlock()
+	if pace != nil {
+		pace.wait(n)
+	}
+}
+
+func (f *fanout) get(root cid.Cid, k string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, ok := f.roots[root]
+	if !ok {
+		return nil, false
+	}
+	data, ok := sb.blocks[k]
+	return data, ok
+}
+
+func (f *fanout) put(root cid.Cid, k string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb, ok := f.roots[root]
+	if !ok {
+		return
+	}
+	if _, ok := sb.blocks[k]; ok {
+		return
+	}
+	sb.blocks[k] = data
+	sb.order = append(sb.order, k)
+	sb.size += uint64(len(data))
+	for sb.size > MaxSharedBlocksSize && len(sb.order) > 0 {
+		old := sb.order[0]
+		sb.order = sb.order[1:]
+		sb.size -= uint64(len(sb.blocks[old]))
+		delete(sb.blocks, old)
+	}
+}
+
+// loader returns a loader reading each block from the store once for all the transfers of a root
+func (f *fanout) loader(root cid.Cid, load ipld.Loader) ipld.Loader {
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		k := lnk.String()
+		if data, ok := f.get(root, k); ok {
+			f.wait(root, len(data))
+			return bytes.NewReader(data), nil
+		}
+		r, err := load(lnk, lnkCtx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		f.put(root, k, data)
+		f.wait(root, len(data))
+		return bytes.NewReader(data), nil
+	}
+}
+
+// ShareLoader wraps the loader of a store so the blocks are shared between the providers pulling
+// the same content while it is being dispatched
+func (r *Replication) ShareLoader(root cid.Cid, load ipld.Loader) ipld.Loader {
+	return r.fan.loader(root, load)
+}
+
+// transferWatch tracks the progress of the transfers to each provider during a dispatch so
+// a provider which stops making progress can be cancelled without delaying the others
+type transferWatch struct {
+	mu       sync.Mutex
+	channels map[peer.ID]datatransfer.ChannelID
+	last     map[peer.ID]time.Time
+	// requested are the providers the dispatch sent a request to, other dispatches of the same root
+	// may transfer it to other providers at the same time
+	requested map[peer.ID]bool
+	// completed are the transfers already confirmed
+	completed map[datatransfer.ChannelID]bool
+}
+
+func newTransferWatch() *transferWatch {
+	return &transferWatch{
+		channels:  make(map[peer.ID]datatransfer.ChannelID),
+		last:      make(map[peer.ID]time.Time),
+		requested: make(map[peer.ID]bool),
+		completed: make(map[datatransfer.ChannelID]bool),
+	}
+}
+
+// request records the dispatch asked a provider to pull the content
+func (tw *transferWatch) request(p peer.ID) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.requested[p] = true
+}
+
+// owns returns whether the transfers to a provider belong to the dispatch
+func (tw *transferWatch) owns(p peer.ID) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.requested[p]
+}
+
+// complete returns true the first time a transfer is reported completed, the events following the
+// completion carry the same status
+func (tw *transferWatch) complete(chid datatransfer.ChannelID) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.completed[chid] {
+		return false
+	}
+	tw.completed[chid] = true
+	return true
+}
+
+// progress records a transfer made progress
+func (tw *transferWatch) progress(p peer.ID, chid datatransfer.ChannelID) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.channels[p] = chid
+	tw.last[p] = time.Now()
+}
+
+// inflight returns the number of transfers in progress plus the pending requests which haven't started yet
+func (tw *transferWatch) inflight(pending map[peer.ID]bool) int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	n := len(tw.last)
+	for p := range pending {
+		if _, ok := tw.last[p]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// done stops watching a transfer
+func (tw *transferWatch) done(p peer.ID) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	delete(tw.channels, p)
+	delete(tw.last, p)
+}
+
+// stalled returns the transfers which made no progress since the given time and stops watching them
+func (tw *transferWatch) stalled(since time.Time) map[peer.ID]datatransfer.ChannelID {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	stalled := make(map[peer.ID]datatransfer.ChannelID)
+	for p, t := range tw.last {
+		if t.Before(since) {
+			stalled[p] = tw.channels[p]
+			delete(tw.channels, p)
+			delete(tw.last, p)
+		}
+	}
+	return stalled
+}
+
+// cancelStalled regularly cancels the transfers which made no progress for the given timeout until stop
+// is closed
+func (r *Replication) cancelStalled(tw *transferWatch, timeout time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for p, chid := range tw.stalled(now.Add(-timeout)) {
+				if err := r.dt.CloseDataTransferChannel(context.TODO(), chid); err != nil {
+					txLog.Warn().Err(err).Str("peer", p.String()).Msg("failed to cancel stalled transfer")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanoutLoader(t *testing.T) {
+	blk := blockGen.Next()
+	root := blk.Cid()
+
+	var mu sync.Mutex
+	loads := 0
+	load := func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		return bytes.NewReader(blk.RawData()), nil
+	}
+
+	f := newFanout()
+	f.open(root)
+	f.open(root)
+
+	// Each provider transfer gets its own loader but blocks are only read once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := f.loader(root, load)(cidlink.Link{Cid: root}, ipld.LinkContext{})
+			require.NoError(t, err)
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, blk.RawData(), data)
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, loads, 5)
+	_, err := f.loader(root, load)(cidlink.Link{Cid: root}, ipld.LinkContext{})
+	require.NoError(t, err)
+	before := loads
+	_, err = f.loader(root, load)(cidlink.Link{Cid: root}, ipld.LinkContext{})
+	require.NoError(t, err)
+	require.Equal(t, before, loads)
+
+	// Blocks are kept until every dispatch of the root is over
+	f.close(root)
+	_, ok := f.get(root, root.String())
+	require.True(t, ok)
+	f.close(root)
+	_, ok = f.get(root, root.String())
+	require.False(t, ok)
+
+	// Without a dispatch in progress blocks are read from the store every time
+	_, err = f.loader(root, load)(cidlink.Link{Cid: root}, ipld.LinkContext{})
+	require.NoError(t, err)
+	require.Equal(t, before+1, loads)
+}
+
+func TestTransferWatch(t *testing.T) {
+	tw := newTransferWatch()
+	slow := peer.ID("slow")
+	fast := peer.ID("fast")
+	done := peer.ID("done")
+	tw.progress(slow, datatransfer.ChannelID{ID: 1})
+	tw.progress(done, datatransfer.ChannelID{ID: 3})
+	since := time.Now()
+	time.Sleep(time.Millisecond)
+	tw.progress(fast, datatransfer.ChannelID{ID: 2})
+	tw.done(done)
+
+	stalled := tw.stalled(since)
+	require.Len(t, stalled, 1)
+	require.Equal(t, datatransfer.ChannelID{ID: 1}, stalled[slow])
+
+	// Stalled transfers are only reported once
+	require.Len(t, tw.stalled(time.Now().Add(-time.Hour)), 0)
+
+	// Only the providers the dispatch requested count and each transfer completes once
+	tw.request(fast)
+	require.True(t, tw.owns(fast))
+	require.False(t, tw.owns(slow))
+	require.True(t, tw.complete(datatransfer.ChannelID{ID: 2}))
+	require.False(t, tw.complete(datatransfer.ChannelID{ID: 2}))
+}
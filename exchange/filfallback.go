@@ -0,0 +1,86 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// DefaultFilecoinFallbackDelay is how long a transaction waits for an offer from a pop provider before
+// querying Filecoin miners
+const DefaultFilecoinFallbackDelay = 15 * time.Second
+
+// ErrNoMinerPeer is returned when a miner doesn't publish a peer ID and addresses on chain
+var ErrNoMinerPeer = errors.New("no peer info for miner")
+
+// WithFilecoinFallback queries the given Filecoin miners if no pop provider offered to serve the content
+// after the fallback delay. The miners archiving the root are queried if none are given. Their offers go
+// through the selection strategy and are paid from the transaction wallet like any other offer.
+func WithFilecoinFallback(miners ...address.Address) TxOption {
+	return func(tx *Tx) {
+		tx.filFallback = true
+		tx.filMiners = miners
+	}
+}
+
+// fallbackFilecoin queries the fallback miners if we received no offer after the fallback delay
+func (tx *Tx) fallbackFilecoin() {
+	timer := time.NewTimer(tx.filDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-tx.ctx.Done():
+		return
+	}
+	if atomic.LoadInt32(&tx.offers) > 0 {
+		return
+	}
+	miners := tx.filMiners
+	if len(miners) == 0 {
+		a, err := tx.index.GetArchive(tx.root)
+		if err != nil {
+			return
+		}
+		miners = a.Miners()
+	}
+	if tx.filAPI == nil {
+		txLog.Warn().Str("root", tx.root.String()).Msg("no filecoin api to find the miners")
+		return
+	}
+	for _, m := range miners {
+		info, err := minerPeerInfo(tx.ctx, tx.filAPI, m)
+		if err != nil {
+			txLog.Warn().Err(err).Str("miner", m.String()).Msg("failed to find miner")
+			continue
+		}
+		if err := tx.rou.QueryPeer(info, tx.root, tx.receiveOffer); err != nil {
+			txLog.Warn().Err(err).Str("miner", m.String()).Msg("failed to query miner")
+		}
+	}
+}
+
+// minerPeerInfo reads the peer ID and addresses of a miner from the chain
+func minerPeerInfo(ctx context.Context, api filecoin.API, addr address.Address) (peer.AddrInfo, error) {
+	mi, err := api.StateMinerInfo(ctx, addr, filecoin.EmptyTSK)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	if mi.PeerId == nil || len(mi.Multiaddrs) == 0 {
+		return peer.AddrInfo{}, ErrNoMinerPeer
+	}
+	info := peer.AddrInfo{ID: *mi.PeerId}
+	for _, b := range mi.Multiaddrs {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return peer.AddrInfo{}, err
+		}
+		info.Addrs = append(info.Addrs, a)
+	}
+	return info, nil
+}
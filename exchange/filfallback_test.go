@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// minerAPI returns the same info for every miner and sends the addresses it is asked about
+type minerAPI struct {
+	*filecoin.MockLotusAPI
+	info   filecoin.MinerInfo
+	lookup chan address.Address
+}
+
+func (a minerAPI) StateMinerInfo(ctx context.Context, addr address.Address, tsk filecoin.TipSetKey) (filecoin.MinerInfo, error) {
+	select {
+	case a.lookup <- addr:
+	default:
+	}
+	return a.info, nil
+}
+
+func TestMinerPeerInfo(t *testing.T) {
+	ctx := context.Background()
+	pid := peer.ID("miner")
+	maddr, err := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/1234")
+	require.NoError(t, err)
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	api := minerAPI{MockLotusAPI: filecoin.NewMockLotusAPI(), info: filecoin.MinerInfo{
+		PeerId:     &pid,
+		Multiaddrs: []abi.Multiaddrs{maddr.Bytes()},
+	}}
+	info, err := minerPeerInfo(ctx, api, miner)
+	require.NoError(t, err)
+	require.Equal(t, pid, info.ID)
+	require.Equal(t, []ma.Multiaddr{maddr}, info.Addrs)
+
+	// Miners without a peer can't be retrieved from
+	api.info = filecoin.MinerInfo{}
+	_, err = minerPeerInfo(ctx, api, miner)
+	require.True(t, errors.Is(err, ErrNoMinerPeer))
+}
+
+func TestFilecoinFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	api := minerAPI{MockLotusAPI: filecoin.NewMockLotusAPI(), lookup: make(chan address.Address, 1)}
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath:              n.DTTmpDir,
+		Keystore:              keystore.NewMemKeystore(),
+		FilecoinAPI:           api,
+		FilecoinFallbackDelay: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	root := blockGen.Next().Cid()
+	miner, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	require.NoError(t, exch.Index().PutArchive(&Archive{
+		Root:  root,
+		Deals: []ArchiveDeal{{Miner: miner, Proposal: blockGen.Next().Cid()}},
+	}))
+
+	// No provider offers the content so the miners archiving it are queried
+	tx := exch.Tx(ctx, WithRoot(root), WithStrategy(SelectFirst), WithFilecoinFallback())
+	defer tx.Close()
+	require.NoError(t, tx.Query(nil))
+	select {
+	case addr := <-api.lookup:
+		require.Equal(t, miner, addr)
+	case <-ctx.Done():
+		t.Fatal("archive miners were not queried")
+	}
+}
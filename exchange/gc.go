@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-multistore"
+)
+
+// GCReport lists the stores no ref points to and the bytes their blocks use
+type GCReport struct {
+	Stores    []multistore.StoreID
+	Reclaimed uint64
+}
+
+// hold keeps a store from being garbage collected while content is staged in it before its ref is set
+func (idx *Index) hold(id multistore.StoreID) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.holds[id]++
+}
+
+// release lets a held store be garbage collected once no ref points to it
+func (idx *Index) release(id multistore.StoreID) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.holds[id]--
+	if idx.holds[id] <= 0 {
+		delete(idx.holds, id)
+	}
+}
+
+// orphans returns the stores which no ref points to and which aren't held, callers must hold the lock
+func (idx *Index) orphans() []multistore.StoreID {
+	stores := make(map[multistore.StoreID]bool)
+	for _, ref := range idx.Refs {
+		stores[ref.StoreID] = true
+	}
+	var orphans []multistore.StoreID
+	for _, id := range idx.ms.List() {
+		if stores[id] || idx.holds[id] > 0 {
+			continue
+		}
+		orphans = append(orphans, id)
+	}
+	return orphans
+}
+
+// Orphans reports the stores GC would delete without deleting them
+func (idx *Index) Orphans(ctx context.Context) (GCReport, error) {
+	return idx.gc(ctx, true)
+}
+
+// GC deletes the stores which no ref points to such as stores left behind by failed evictions or
+// transactions which were never committed. Stores of transactions still in progress are kept.
+func (idx *Index) GC(ctx context.Context) (GCReport, error) {
+	return idx.gc(ctx, false)
+}
+
+func (idx *Index) gc(ctx context.Context, dryRun bool) (GCReport, error) {
+	var report GCReport
+	// Refs which aren't loaded yet would be considered orphans
+	select {
+	case <-idx.Loaded():
+	case <-ctx.Done():
+		return report, ctx.Err()
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, id := range idx.orphans() {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		size, err := idx.storeUsage(id)
+		if err != nil {
+			return report, err
+		}
+		if !dryRun {
+			if err := idx.deleteStore(id); err != nil {
+				return report, err
+			}
+		}
+		report.Stores = append(report.Stores, id)
+		report.Reclaimed += size
+	}
+	return report, nil
+}
@@ -0,0 +1,59 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexGC(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	putBlock := func() (multistore.StoreID, int) {
+		storeID := ms.Next()
+		store, err := ms.Get(storeID)
+		require.NoError(t, err)
+		blk := blockGen.Next()
+		require.NoError(t, store.Bstore.Put(blk))
+		return storeID, len(blk.RawData())
+	}
+
+	indexed, _ := putBlock()
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+		StoreID:     indexed,
+	}))
+	orphan, size := putBlock()
+	// A transaction is staging content in this store
+	staged, _ := putBlock()
+	idx.hold(staged)
+
+	report, err := idx.Orphans(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multistore.StoreID{orphan}, report.Stores)
+	require.Equal(t, uint64(size), report.Reclaimed)
+	require.Len(t, ms.List(), 3)
+
+	report, err = idx.GC(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multistore.StoreID{orphan}, report.Stores)
+	require.ElementsMatch(t, []multistore.StoreID{indexed, staged}, ms.List())
+
+	// Once released the store can be collected
+	idx.release(staged)
+	report, err = idx.GC(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []multistore.StoreID{staged}, report.Stores)
+	require.Equal(t, []multistore.StoreID{indexed}, ms.List())
+}
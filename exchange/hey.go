@@ -13,12 +13,22 @@ import (
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 )
 
-//go:generate cbor-gen-for Hey
+//go:generate cbor-gen-for Hey heyV1
 
 // HeyProtocol identifies the supply greeter protocol
-const HeyProtocol = "/myel/pop/hey/1.0"
+const HeyProtocol = protocol.ID("/myel/pop/hey/1.1")
+
+// HeyProtocolV1 is the deprecated version of the hey protocol sending region codes only
+const HeyProtocolV1 = protocol.ID("/myel/pop/hey/1.0")
+
+// HeyProtocols are the versions of the hey protocol we speak
+var HeyProtocols = Protocols{
+	{ID: HeyProtocol},
+	{ID: HeyProtocolV1, Sunset: time.Date(2027, time.February, 1, 0, 0, 0, 0, time.UTC)},
+}
 
 // HeyReceiver is the interface the HeyService expects to receive the hey messages
 type HeyReceiver interface {
@@ -35,10 +45,17 @@ type LatencyRecorder interface {
 	RecordLatency(peer.ID, time.Duration) error
 }
 
+// ResponseRecorder is an interface to record whether peers answer our requests
+type ResponseRecorder interface {
+	RecordResponse(peer.ID)
+	RecordFailure(peer.ID)
+}
+
 // PeerManager all the methods to maintain an optimal list of peers
 type PeerManager interface {
 	HeyReceiver
 	LatencyRecorder
+	ResponseRecorder
 }
 
 // HeyService greets new peers upon connecting to learn more about their region
@@ -58,12 +75,20 @@ func NewHeyService(h host.Host, pm PeerManager, hg HeyGetter) *HeyService {
 type Hey struct {
 	Regions   []RegionCode
 	IndexRoot *cid.Cid // If the node has an empty index the root will be nil
+	// RegionNames label the regions so custom regions sharing the same code can be told apart
+	RegionNames []string
+}
+
+// heyV1 is the hey message exchanged over HeyProtocolV1
+type heyV1 struct {
+	Regions   []RegionCode
+	IndexRoot *cid.Cid
 }
 
 // Run starts a new goroutine in which we listen for new peers we successfully connected to
 // and sends a hey message
 func (hs *HeyService) Run(ctx context.Context) error {
-	hs.h.SetStreamHandler(HeyProtocol, hs.HandleStream)
+	SetStreamHandlers(hs.h, HeyProtocols, hs.HandleStream)
 
 	sub, err := hs.h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted), eventbus.BufSize(1024))
 	if err != nil {
@@ -85,10 +110,10 @@ func (hs *HeyService) Run(ctx context.Context) error {
 
 // HandleStream is the multistream handler for the Hey protocol, it reads a Hey message and handles it
 func (hs *HeyService) HandleStream(s network.Stream) {
-	var hmsg Hey
-	if err := cborutil.ReadCborRPC(s, &hmsg); err != nil {
+	hmsg, err := readHey(s)
+	if err != nil {
 		_ = s.Conn().Close()
-		fmt.Println("failed to read CBOR Hey msg", err)
+		discoveryLog.Debug().Err(err).Str("peer", s.Conn().RemotePeer().String()).Msg("failed to read hey message")
 		return
 	}
 	hs.pm.Receive(s.Conn().RemotePeer(), hmsg)
@@ -102,15 +127,16 @@ func (hs *HeyService) HandleStream(s network.Stream) {
 
 // SendHey message to a given peer
 func (hs *HeyService) SendHey(ctx context.Context, pid peer.ID) error {
-	s, err := hs.h.NewStream(ctx, pid, HeyProtocol)
+	s, err := hs.h.NewStream(ctx, pid, HeyProtocols.Active(time.Now())...)
 	if err != nil {
 		return err
 	}
+	recordProtocol(HeyProtocols, s)
 
 	hmsg := hs.hg.GetHey()
 
 	start := time.Now()
-	if err := cborutil.WriteCborRPC(s, &hmsg); err != nil {
+	if err := writeHey(s, hmsg); err != nil {
 		return err
 	}
 	go func() {
@@ -120,7 +146,9 @@ func (hs *HeyService) SendHey(ctx context.Context, pid peer.ID) error {
 		buf := make([]byte, 32)
 		_, err := io.ReadFull(s, buf)
 		if err != nil {
-			fmt.Println("failed to read pong msg", err)
+			discoveryLog.Debug().Err(err).Str("peer", pid.String()).Msg("failed to read pong message")
+			hs.pm.RecordFailure(pid)
+			return
 		}
 		now := time.Now()
 		lat := now.Sub(start)
@@ -128,3 +156,25 @@ func (hs *HeyService) SendHey(ctx context.Context, pid peer.ID) error {
 	}()
 	return nil
 }
+
+// readHey decodes a hey message in the format of the protocol version the stream was opened with
+func readHey(s network.Stream) (Hey, error) {
+	if s.Protocol() == HeyProtocolV1 {
+		var m heyV1
+		if err := cborutil.ReadCborRPC(s, &m); err != nil {
+			return Hey{}, err
+		}
+		return Hey{Regions: m.Regions, IndexRoot: m.IndexRoot}, nil
+	}
+	var m Hey
+	err := cborutil.ReadCborRPC(s, &m)
+	return m, err
+}
+
+// writeHey encodes a hey message in the format of the protocol version the stream was opened with
+func writeHey(s network.Stream, m Hey) error {
+	if s.Protocol() == HeyProtocolV1 {
+		return cborutil.WriteCborRPC(s, &heyV1{Regions: m.Regions, IndexRoot: m.IndexRoot})
+	}
+	return cborutil.WriteCborRPC(s, &m)
+}
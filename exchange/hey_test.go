@@ -5,7 +5,10 @@ import (
 	"testing"
 	"time"
 
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/myelnet/pop/internal/testutil"
 	"github.com/stretchr/testify/require"
@@ -25,6 +28,10 @@ func (pm *pmanager) RecordLatency(p peer.ID, l time.Duration) error {
 	return nil
 }
 
+func (pm *pmanager) RecordResponse(p peer.ID) {}
+
+func (pm *pmanager) RecordFailure(p peer.ID) {}
+
 type hgetter struct {
 	hey Hey
 }
@@ -53,7 +60,8 @@ func TestHey(t *testing.T) {
 	h2ch := make(chan Hey, 1)
 	l2ch := make(chan time.Duration, 1)
 	hey2 := Hey{
-		Regions: []RegionCode{GlobalRegion, EuropeRegion},
+		Regions:     []RegionCode{GlobalRegion, EuropeRegion, CustomRegion},
+		RegionNames: []string{"Global", "Europe", "eu-west"},
 	}
 	h2 := &HeyService{n2.Host, &pmanager{h2ch, l2ch}, &hgetter{hey2}}
 	require.NoError(t, h2.Run(ctx))
@@ -83,3 +91,57 @@ func TestHey(t *testing.T) {
 		}
 	}
 }
+
+func TestHeyDeprecatedProtocol(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+
+	hch := make(chan Hey, 1)
+	hey := Hey{
+		Regions:     []RegionCode{GlobalRegion, CustomRegion},
+		RegionNames: []string{"Global", "eu-west"},
+	}
+	h1 := &HeyService{n1.Host, &pmanager{hch, make(chan time.Duration, 1)}, &hgetter{hey}}
+	require.NoError(t, h1.Run(ctx))
+
+	// n2 hasn't upgraded and only speaks the previous version
+	v1ch := make(chan heyV1, 2)
+	n2.Host.SetStreamHandler(HeyProtocolV1, func(s network.Stream) {
+		defer s.Close()
+		var m heyV1
+		require.NoError(t, cborutil.ReadCborRPC(s, &m))
+		v1ch <- m
+		_, _ = s.Write(make([]byte, 32))
+	})
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	require.NoError(t, h1.SendHey(ctx, n2.Host.ID()))
+	select {
+	case m := <-v1ch:
+		// The region names cannot be sent with the previous version
+		require.Equal(t, hey.Regions, m.Regions)
+	case <-ctx.Done():
+		t.Fatal("didn't receive hey")
+	}
+
+	// n2 can still greet us with the previous version
+	s, err := n2.Host.NewStream(ctx, n1.Host.ID(), HeyProtocolV1)
+	require.NoError(t, err)
+	require.NoError(t, cborutil.WriteCborRPC(s, &heyV1{Regions: []RegionCode{EuropeRegion}}))
+	select {
+	case r := <-hch:
+		require.Equal(t, []RegionCode{EuropeRegion}, r.Regions)
+	case <-ctx.Done():
+		t.Fatal("didn't receive hey")
+	}
+
+	// Both versions are served until the deprecated one is sunset
+	require.Equal(t, []protocol.ID{HeyProtocol, HeyProtocolV1}, HeyProtocols.Active(time.Time{}))
+	require.Equal(t, []protocol.ID{HeyProtocol}, HeyProtocols.Active(HeyProtocols[1].Sunset))
+}
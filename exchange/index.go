@@ -6,7 +6,10 @@ import (
 	"context"
 	"crypto/sha256"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-hamt-ipld/v3"
 	"github.com/filecoin-project/go-multistore"
@@ -15,6 +18,8 @@ import (
 	"github.com/ipfs/go-datastore/namespace"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/metrics"
 	cbg "github.com/whyrusleeping/cbor-gen"
 )
 
@@ -51,21 +56,91 @@ type Index struct {
 	// updateFunc, if not nil, is called after every read transactions. The hook can be used
 	// to trigger request for new content and refreshing the index with new popular content
 	updateFunc func()
+	// pubShare is the fraction of the upper bound the content of a single publisher can use, 0 is uncapped
+	pubShare float64
+	// catLabel is the label holding the category of a ref if the bounds are partitioned between categories
+	catLabel string
+	// flushInterval delays persisting the HAMT root after changes, 0 flushes after every change
+	flushInterval time.Duration
+	// veto can keep refs from being evicted
+	veto EvictionVeto
+	// keep is the ref being set or updated which the eviction passes it triggers must leave indexed
+	keep *DataRef
+	// rec captures the changes of refs while a recording is running
+	rec *Recorder
+	// diskAccounting measures content from the blocks in its store instead of its payload size
+	diskAccounting bool
+	// lazy loads refs from the HAMT on first access while the rest is loaded in the background
+	lazy bool
+	// loadDone is closed once every ref is loaded in memory
+	loadDone chan struct{}
+	// slowBlock is the duration after which block reads are reported, negative disables the reports
+	slowBlock time.Duration
+	// slowBlockFn is called with the slow block reads if set, they are printed otherwise
+	slowBlockFn func(SlowBlock)
+	// remoteWeight is the number of buckets a ref moves up when served to a remote peer
+	remoteWeight int
+	// access records the reads of each root in time buckets
+	access *accessLog
+	// maxRefs is the number of refs after which we start evicting regardless of their size, 0 is unbounded
+	maxRefs int
+	// maxBuckets is the length of the LFU bucket list after which we start evicting, 0 is unbounded
+	maxBuckets int
 
 	mu sync.Mutex
 	// current size of content committed to the store
 	size uint64
+	// pubs tracks the content usage of each publisher
+	pubs map[peer.ID]*PublisherUsage
+	// cats tracks the content usage of each category with a quota, the index isn't partitioned if empty
+	cats map[string]*CategoryUsage
 	// linked list keeps track of all refs in least to most popular order to access as fast as possible
 	blist *list.List
 	// We still need to keep a map in memory
+	//
+	// Deprecated: the map is mutated under the index lock, use Range, Keys or Snapshot to iterate safely.
 	Refs    map[string]*DataRef
 	rootCID cid.Cid
+	// loaded is true once every ref from the HAMT is in memory
+	loaded bool
+	// removed are the refs dropped before the index was fully loaded
+	removed map[string]struct{}
+	// dirty is true when changes are waiting for the next flush
+	dirty bool
+	// flushTimer is the pending flush if any
+	flushTimer *time.Timer
+	// seq is incremented every time a ref is added or removed
+	seq uint64
+	// changes are the latest refs added or removed to announce diffs of the index
+	changes []indexChange
+	// pending are the keys of the logged operations waiting for the next flush
+	pending map[string]struct{}
+	// holds are the stores staging content before their ref is set
+	holds map[multistore.StoreID]int
+	// superseded are the roots replaced by a new version which are evicted first
+	superseded map[string]struct{}
 
 	imu sync.Mutex
 	// interest frequencies track the most popular content we don't have
 	freqs *list.List
 	// Interest is a map of interest ref pointers
 	interest map[string]*DataRef
+	// iroot is the HAMT persisting the interest list across restarts
+	iroot *hamt.Node
+	// idirty tracks the interest keys changed since the last flush
+	idirty map[string]bool
+	// maxInterest is the maximum number of refs in the interest list, 0 is unbounded
+	maxInterest int
+	// halfLife is the period after which interest frequencies are halved, 0 disables the decay
+	halfLife time.Duration
+	// decayed is the last time interest frequencies were decayed
+	decayed time.Time
+
+	smu sync.Mutex
+	// search is the inverted index of entry names and manifest metadata
+	search *searchIndex
+	// searchStale is set to 1 when the refs changed since the search index was built
+	searchStale int32
 }
 
 // DataRef encapsulates information about a content committed for storage
@@ -75,10 +150,25 @@ type DataRef struct {
 	StoreID     multistore.StoreID
 	Freq        int64
 	BucketID    int64
+	// Publisher is the peer who dispatched the content to us if any
+	Publisher peer.ID
+	// LeaseExpiry is the unix time until which a publisher paid for the content not to be evicted
+	LeaseExpiry int64
+	// Keys are the names of the entries under the root if known
+	Keys []string
+	// Labels are metadata set by the application which committed the content e.g. its content type
+	Labels map[string]string
+	// Created is the unix time at which the content was first indexed
+	Created int64
 	// do not serialize
 	bucketNode *list.Element
+	// diskSize is the measured size of the blocks in the store if disk accounting is enabled
+	diskSize uint64
 }
 
+// DefaultRemoteReadWeight is the number of buckets a ref moves up when it is served to a remote peer
+const DefaultRemoteReadWeight = 1
+
 // IndexOption customizes the behavior of the index
 type IndexOption func(*Index)
 
@@ -94,7 +184,71 @@ func WithBounds(up, lo uint64) IndexOption {
 	}
 }
 
-// WithUpdateFunc sets an UpdateFunc callback and a read interval after which to call it
+// capacityBounds returns the upper and lower bounds of an index with the given capacity. We leave a 20%
+// margin below the capacity so we don't evict too frequently.
+func capacityBounds(capacity uint64) (uint64, uint64) {
+	return capacity, capacity - uint64(math.Round(float64(capacity)*0.2))
+}
+
+// SetCapacity changes the capacity of the index and the fraction of it the content of a single publisher
+// can use. Content is evicted right away if the index is over its new capacity.
+func (idx *Index) SetCapacity(capacity uint64, pubShare float64) error {
+	if pubShare < 0 || pubShare > 1 {
+		return errors.New("publisher share must be between 0 and 1")
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.ub, idx.lb = capacityBounds(capacity)
+	idx.pubShare = pubShare
+	if idx.ub > 0 && idx.lb > 0 && idx.size > idx.ub {
+		idx.evict(idx.size - idx.lb)
+		idx.updateMetrics()
+		return idx.Flush()
+	}
+	return nil
+}
+
+// EvictionPressure describes how much content the index is trying to evict
+type EvictionPressure struct {
+	// Remaining is the number of bytes still to be freed
+	Remaining uint64
+	// Size is the current size of the content in the index
+	Size uint64
+	// UpperBound is the capacity of the index
+	UpperBound uint64
+	// Refs is the number of refs still to be evicted when the index is over its memory bounds
+	Refs int
+}
+
+// EvictionVeto is called with each candidate for eviction, returning false keeps the ref in the index.
+// It runs while the index is locked so it must not call methods of the index.
+type EvictionVeto func(DataRef, EvictionPressure) bool
+
+// WithEvictionVeto registers a callback to enforce custom rules about which content can be evicted
+// e.g. never evicting content under contract. Vetoed refs are skipped and the next least frequently
+// used ref is considered instead.
+func WithEvictionVeto(fn EvictionVeto) IndexOption {
+	return func(idx *Index) {
+		idx.veto = fn
+	}
+}
+
+// WithRemoteReadWeight sets the number of buckets a ref moves up when it is served to a remote peer
+// independently of local reads. 0 ignores remote reads.
+func WithRemoteReadWeight(w int) IndexOption {
+	return func(idx *Index) {
+		idx.remoteWeight = w
+	}
+}
+
+// WithRecorder captures the changes of refs in the recordings of the given recorder
+func WithRecorder(r *Recorder) IndexOption {
+	return func(idx *Index) {
+		idx.rec = r
+	}
+}
+
+// WithUpdateFunc sets an UpdateFunc callback called after every read
 func WithUpdateFunc(fn func()) IndexOption {
 	return func(idx *Index) {
 		idx.updateFunc = fn
@@ -104,13 +258,25 @@ func WithUpdateFunc(fn func()) IndexOption {
 // NewIndex creates a new Index instance, loading entries into a doubly linked list for faster read and writes
 func NewIndex(ds datastore.Batching, ms *multistore.MultiStore, opts ...IndexOption) (*Index, error) {
 	idx := &Index{
-		blist:    list.New(),
-		freqs:    list.New(),
-		ds:       namespace.Wrap(ds, datastore.NewKey("/index")),
-		ms:       ms,
-		Refs:     make(map[string]*DataRef),
-		interest: make(map[string]*DataRef),
-		rootCID:  cid.Undef,
+		blist:        list.New(),
+		freqs:        list.New(),
+		ds:           namespace.Wrap(ds, datastore.NewKey("/index")),
+		ms:           ms,
+		Refs:         make(map[string]*DataRef),
+		interest:     make(map[string]*DataRef),
+		idirty:       make(map[string]bool),
+		maxInterest:  DefaultMaxInterest,
+		halfLife:     DefaultInterestHalfLife,
+		pubs:         make(map[peer.ID]*PublisherUsage),
+		removed:      make(map[string]struct{}),
+		pending:      make(map[string]struct{}),
+		holds:        make(map[multistore.StoreID]int),
+		superseded:   make(map[string]struct{}),
+		loadDone:     make(chan struct{}),
+		slowBlock:    DefaultSlowBlockThreshold,
+		remoteWeight: DefaultRemoteReadWeight,
+		access:       newAccessLog(ds),
+		rootCID:      cid.Undef,
 	}
 	for _, o := range opts {
 		o(idx)
@@ -121,6 +287,32 @@ func NewIndex(ds datastore.Batching, ms *multistore.MultiStore, opts ...IndexOpt
 	if err := idx.loadFromStore(); err != nil {
 		return nil, err
 	}
+	// Complete the operations interrupted before the last flush
+	if err := idx.replayLog(); err != nil {
+		return nil, err
+	}
+	if err := idx.loadSuperseded(); err != nil {
+		return nil, err
+	}
+	// Drop the statistics of the roots nobody read for a long time
+	if err := idx.access.prune(); err != nil {
+		return nil, err
+	}
+
+	if idx.lazy && idx.rootCID != cid.Undef {
+		// Iterate over the HAMT as it is now while the live root is being modified
+		snapshot, err := idx.LoadRoot(idx.rootCID, idx.store)
+		if err != nil {
+			return nil, err
+		}
+		if err := idx.loadInterestList(); err != nil {
+			return nil, err
+		}
+		go idx.loadInBackground(snapshot)
+		return idx, nil
+	}
+	idx.loaded = true
+	close(idx.loadDone)
 
 	// // Loads the ref frequencies in a doubly linked list for faster access
 	err := idx.root.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
@@ -128,43 +320,56 @@ func NewIndex(ds datastore.Batching, ms *multistore.MultiStore, opts ...IndexOpt
 		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
 			return err
 		}
-		idx.Refs[v.PayloadCID.String()] = v
-		idx.size += uint64(v.PayloadSize)
-		if e := idx.blist.Front(); e == nil {
-			// insert the first element in the list
-			li := newBucket(v.BucketID)
-			li.entries[v] = 1
-			v.bucketNode = idx.blist.PushFront(li)
-			return nil
-		}
-		for e := idx.blist.Front(); e != nil; e = e.Next() {
-			b := e.Value.(*bucket)
-			if b.id == v.BucketID {
-				b.entries[v] = 1
-				v.bucketNode = e
-				return nil
-			}
-			if b.id > v.BucketID {
-				li := newBucket(v.BucketID)
-				li.entries[v] = 1
-				v.bucketNode = idx.blist.InsertBefore(li, e)
-				return nil
-			}
-		}
-		// if we're still here it means we're the highest ID in the list so we
-		// insert it at the back
-		li := newBucket(v.BucketID)
-		li.entries[v] = 1
-		v.bucketNode = idx.blist.PushBack(li)
+		idx.load(v)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	if idx.diskAccounting {
+		if err := idx.reconcile(); err != nil {
+			return nil, err
+		}
+	}
+	idx.updateMetrics()
+	if err := idx.loadInterestList(); err != nil {
+		return nil, err
+	}
 
 	return idx, nil
 }
 
+// insertRef places a ref in the bucket matching its BucketID without registering a read,
+// callers must hold the lock
+func (idx *Index) insertRef(v *DataRef) {
+	if e := idx.blist.Front(); e == nil {
+		// insert the first element in the list
+		li := newBucket(v.BucketID)
+		li.entries[v] = 1
+		v.bucketNode = idx.blist.PushFront(li)
+		return
+	}
+	for e := idx.blist.Front(); e != nil; e = e.Next() {
+		b := e.Value.(*bucket)
+		if b.id == v.BucketID {
+			b.entries[v] = 1
+			v.bucketNode = e
+			return
+		}
+		if b.id > v.BucketID {
+			li := newBucket(v.BucketID)
+			li.entries[v] = 1
+			v.bucketNode = idx.blist.InsertBefore(li, e)
+			return
+		}
+	}
+	// if we're still here it means we're the highest ID in the list so we
+	// insert it at the back
+	li := newBucket(v.BucketID)
+	li.entries[v] = 1
+	v.bucketNode = idx.blist.PushBack(li)
+}
+
 func (idx *Index) loadFromStore() error {
 	// var err error
 	enc, err := idx.ds.Get(datastore.NewKey(KIndex))
@@ -197,28 +402,35 @@ func (idx *Index) LoadRoot(r cid.Cid, store cbor.IpldStore) (*hamt.Node, error)
 	return hamt.LoadNode(context.TODO(), store, r, hamt.UseTreeBitWidth(5), hashOption)
 }
 
-// GetStoreID returns the StoreID of the store which has the given content
+// GetStoreID returns the StoreID of the store which has the given content. It doesn't register a read
+// as providers call it to serve the content to peers which are registered with RemoteRead.
 func (idx *Index) GetStoreID(id cid.Cid) (multistore.StoreID, error) {
-	ref, err := idx.GetRef(id)
+	ref, err := idx.PeekRef(id)
 	if err != nil {
 		return 0, err
 	}
 	return ref.StoreID, nil
 }
 
-// GetStore returns the store associated with a data CID
+// GetStore returns the store associated with a data CID without registering a read
 func (idx *Index) GetStore(id cid.Cid) (*multistore.Store, error) {
 	storeID, err := idx.GetStoreID(id)
 	if err != nil {
 		return nil, err
 	}
-	return idx.ms.Get(storeID)
+	return idx.OpenStore(storeID)
 }
 
 // Root returns the HAMT root CID
 func (idx *Index) Root() cid.Cid {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
+	// Changes waiting for the next flush must be part of the root
+	if idx.dirty {
+		if err := idx.Flush(); err != nil {
+			indexLog.Error().Err(err).Msg("failed to flush index")
+		}
+	}
 	return idx.rootCID
 }
 
@@ -245,93 +457,303 @@ func (idx *Index) Flush() error {
 		return err
 	}
 	idx.rootCID = r
-	return idx.ds.Put(datastore.NewKey(KIndex), r.Bytes())
+	if err := idx.ds.Put(datastore.NewKey(KIndex), r.Bytes()); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return idx.truncateLog()
 }
 
 // DropRef removes all content linked to a root CID and associated Refs
 func (idx *Index) DropRef(k cid.Cid) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	if found, err := idx.root.Delete(context.TODO(), k.String()); err != nil {
+	if err := idx.dropRef(k); err != nil {
 		return err
-	} else if !found {
-		return ErrRefNotFound
 	}
-	ref := idx.Refs[k.String()]
-	idx.remBlistEntry(ref.bucketNode, ref)
+	return idx.commit()
+}
 
-	err := idx.ms.Delete(ref.StoreID)
-	if err != nil {
+// dropRef removes a ref without persisting the HAMT root, callers must hold the lock
+func (idx *Index) dropRef(k cid.Cid) error {
+	ref, err := idx.unindexRef(k)
+	if err != nil || ref == nil {
 		return err
 	}
+	return idx.deleteContent(ref)
+}
+
+// unindexRef removes a ref from the HAMT and the LFU without deleting its content. It returns a nil ref
+// if the content was already missing from the stores. Callers must hold the lock.
+func (idx *Index) unindexRef(k cid.Cid) (*DataRef, error) {
+	ref, loaded := idx.lookup(k.String())
+	if found, err := idx.root.Delete(context.TODO(), k.String()); err != nil {
+		return nil, err
+	} else if !found {
+		return nil, ErrRefNotFound
+	}
+	idx.forget(k.String())
+	idx.recordChange(k, nil)
+	if !loaded {
+		// The content was already missing from the stores
+		return nil, nil
+	}
+	idx.rec.recordRef(EventIndexDrop, ref, idx.refSize(ref))
+	idx.remBlistEntry(ref.bucketNode, ref)
+	idx.removeUsage(ref)
+	idx.size -= idx.refSize(ref)
 
 	delete(idx.Refs, k.String())
-	return idx.Flush()
+	idx.updateMetrics()
+	idx.invalidateSearch()
+	return ref, nil
+}
+
+// deleteContent deletes the store of a ref removed from the index, callers must hold the lock
+func (idx *Index) deleteContent(ref *DataRef) error {
+	if err := idx.logRef(walDrop, ref); err != nil {
+		return err
+	}
+	if err := idx.deleteStore(ref.StoreID); err != nil {
+		return err
+	}
+	// The content isn't verified anymore once we drop it
+	if err := idx.ds.Delete(manifestKey(ref.PayloadCID)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		return err
+	}
+	idx.unmarkSuperseded(ref.PayloadCID)
+	return nil
 }
 
 // SetRef adds a ref in the index and increments the LFU queue
 func (idx *Index) SetRef(ref *DataRef) error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
+	if err := idx.setRef(ref); err != nil {
+		return err
+	}
+	return idx.commit()
+}
+
+// setRef adds a ref without persisting the HAMT root, callers must hold the lock
+func (idx *Index) setRef(ref *DataRef) error {
+	// Evicting everything else would still not make room for it
+	if idx.ub > 0 && uint64(ref.PayloadSize) > idx.ub {
+		return fmt.Errorf("%w: %d bytes is over the capacity of %d bytes", ErrStoreFull, ref.PayloadSize, idx.ub)
+	}
+	if err := idx.fitsCategory(ref); err != nil {
+		return err
+	}
 	k := ref.PayloadCID.String()
+	old, ok := idx.lookup(k)
+	if ok {
+		idx.removeUsage(old)
+		idx.size -= idx.refSize(old)
+		// Updating a ref doesn't reset its age
+		if ref.Created == 0 {
+			ref.Created = old.Created
+		}
+	}
+	if ok && old != ref {
+		// The new version takes the place of the old one in the LFU list so eviction never finds
+		// a ref which is no longer indexed
+		ref.bucketNode = old.bucketNode
+		if old.bucketNode != nil {
+			entries := old.bucketNode.Value.(*bucket).entries
+			delete(entries, old)
+			entries[ref] = 1
+			ref.BucketID = old.BucketID
+			ref.Freq = old.Freq
+		}
+		old.bucketNode = nil
+	} else if !ok {
+		// A copy of a dropped ref may still point to a bucket no longer in the list
+		ref.bucketNode = nil
+	}
+	if ref.Created == 0 {
+		ref.Created = time.Now().Unix()
+	}
 	idx.Refs[k] = ref
-	idx.size += uint64(ref.PayloadSize)
+	idx.measure(ref)
+	idx.size += idx.refSize(ref)
+	idx.addUsage(ref)
+	// The ref may have taken the LFU bucket of its previous version and be the least used one
+	idx.keep = ref
+	defer func() { idx.keep = nil }()
+	// Make room among the content of the publisher first if it is over its share
+	idx.enforceShare(ref.Publisher)
+	// Then among the content of its category so other categories keep their partition
+	idx.enforceQuota(ref)
 	if idx.ub > 0 && idx.lb > 0 {
 		if idx.size > idx.ub {
 			idx.evict(idx.size - idx.lb)
 		}
 	}
+	// Nodes with little memory also cap the metadata of small content
+	idx.enforceMemory()
 	// We evict the item before adding the new one
 	idx.increment(ref)
-	if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+	idx.recordChange(ref.PayloadCID, ref)
+	idx.rec.recordRef(EventIndexSet, ref, idx.refSize(ref))
+	idx.updateMetrics()
+	idx.invalidateSearch()
+	if err := idx.logRef(walSet, ref); err != nil {
 		return err
 	}
-	return idx.Flush()
+	return idx.root.Set(context.TODO(), k, ref)
 }
 
 // GetRef gets a ref in the index for a given root CID and increments the LFU list registering a Read
 func (idx *Index) GetRef(k cid.Cid) (*DataRef, error) {
+	ref, err := idx.readRef(k)
+	if err == nil && idx.updateFunc != nil {
+		idx.updateFunc()
+	}
+	return ref, err
+}
+
+func (idx *Index) readRef(k cid.Cid) (*DataRef, error) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	ref, ok := idx.Refs[k.String()]
+	ref, ok := idx.lookup(k.String())
 	if !ok {
+		metrics.IndexMisses.Inc()
 		return nil, ErrRefNotFound
 	}
+	metrics.IndexHits.Inc()
 	idx.increment(ref)
+	idx.recordAccess(k, false)
+	idx.rec.recordRef(EventIndexRead, ref, idx.refSize(ref))
 	// Update the freq
 	if err := idx.root.Set(context.TODO(), k.String(), ref); err != nil {
 		return nil, err
 	}
-	return ref, idx.Flush()
+	return ref, idx.commit()
+}
+
+// RemoteRead registers in the LFU that a ref was served to a remote peer. Remote demand moves the ref up
+// by the remote read weight and doesn't trigger the update function called after local reads.
+// Unknown refs return ErrRefNotFound.
+func (idx *Index) RemoteRead(k cid.Cid) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ref, ok := idx.lookup(k.String())
+	if !ok {
+		return ErrRefNotFound
+	}
+	// Remote reads are part of the access statistics even if they don't count in the LFU
+	idx.recordAccess(k, true)
+	if idx.remoteWeight <= 0 {
+		return nil
+	}
+	for i := 0; i < idx.remoteWeight; i++ {
+		idx.increment(ref)
+	}
+	idx.rec.recordRef(EventIndexRemoteRead, ref, idx.refSize(ref))
+	if err := idx.root.Set(context.TODO(), k.String(), ref); err != nil {
+		return err
+	}
+	return idx.commit()
+}
+
+// UpdateRef applies changes to the metadata of a ref without registering a read in the LFU
+// the payload size and store should not be modified as they are used for accounting
+func (idx *Index) UpdateRef(k cid.Cid, fn func(*DataRef)) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := idx.updateRef(k, fn); err != nil {
+		return err
+	}
+	return idx.commit()
+}
+
+// updateRef changes the metadata of a ref without persisting the HAMT root, callers must hold the lock
+func (idx *Index) updateRef(k cid.Cid, fn func(*DataRef)) error {
+	ref, ok := idx.lookup(k.String())
+	if !ok {
+		return ErrRefNotFound
+	}
+	// The publisher may change when content is superseded and the category when labels are updated
+	idx.removeUsage(ref)
+	fn(ref)
+	idx.addUsage(ref)
+	idx.keep = ref
+	defer func() { idx.keep = nil }()
+	idx.enforceShare(ref.Publisher)
+	idx.enforceQuota(ref)
+	idx.invalidateSearch()
+	return idx.root.Set(context.TODO(), k.String(), ref)
 }
 
 // PeekRef returns a ref from the index without actually registering a read in the LFU
 func (idx *Index) PeekRef(k cid.Cid) (*DataRef, error) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	ref := new(DataRef)
-	ref, ok := idx.Refs[k.String()]
+	ref, ok := idx.lookup(k.String())
 	if !ok {
 		return nil, ErrRefNotFound
 	}
 	return ref, nil
 }
 
-// ListRefs returns all the content refs currently stored on this node as well as their read frequencies
-func (idx *Index) ListRefs() ([]*DataRef, error) {
+// ListRefs returns all the content refs currently stored on this node as well as their read frequencies.
+// If labels are given only the refs with all of them are returned.
+func (idx *Index) ListRefs(labels ...Label) ([]*DataRef, error) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	refs := make([]*DataRef, len(idx.Refs))
-	i := 0
+	refs := make([]*DataRef, 0, len(idx.Refs))
 	for e := idx.blist.Front(); e != nil; e = e.Next() {
 		for k := range e.Value.(*bucket).entries {
-			refs[i] = k
-			i++
+			if k.HasLabels(labels...) {
+				refs = append(refs, k)
+			}
 		}
 	}
 	return refs, nil
 }
 
+// Snapshot returns a copy of every ref from least to most frequently used which can be read while
+// the index keeps changing
+func (idx *Index) Snapshot() []DataRef {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	refs := make([]DataRef, 0, len(idx.Refs))
+	for e := idx.blist.Front(); e != nil; e = e.Next() {
+		for ref := range e.Value.(*bucket).entries {
+			// Skip refs which were replaced by a newer version
+			if idx.Refs[ref.PayloadCID.String()] != ref {
+				continue
+			}
+			cp := *ref
+			cp.bucketNode = nil
+			refs = append(refs, cp)
+		}
+	}
+	return refs
+}
+
+// Range calls fn with a copy of each ref from least to most frequently used until it returns false.
+// The lock isn't held while fn runs so it may call other methods of the index.
+func (idx *Index) Range(fn func(*DataRef) bool) {
+	for _, ref := range idx.Snapshot() {
+		ref := ref
+		if !fn(&ref) {
+			return
+		}
+	}
+}
+
+// Keys returns the roots of all the content in the index
+func (idx *Index) Keys() []cid.Cid {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys := make([]cid.Cid, 0, len(idx.Refs))
+	for _, ref := range idx.Refs {
+		keys = append(keys, ref.PayloadCID)
+	}
+	return keys
+}
+
 // Len returns the number of roots this index is currently storing
 func (idx *Index) Len() int {
 	idx.mu.Lock()
@@ -339,6 +761,13 @@ func (idx *Index) Len() int {
 	return len(idx.Refs)
 }
 
+// updateMetrics reports the index usage, callers must take care of the mutex
+func (idx *Index) updateMetrics() {
+	metrics.IndexSize.Set(float64(idx.size))
+	metrics.IndexRefs.Set(float64(len(idx.Refs)))
+	metrics.IndexBuckets.Set(float64(idx.blist.Len()))
+}
+
 // Bstore returns the lower level blockstore storing the hamt
 func (idx *Index) Bstore() blockstore.Blockstore {
 	return idx.bstore
@@ -417,25 +846,93 @@ func (idx *Index) remFreqEntry(place *list.Element, entry *DataRef) {
 func (idx *Index) evict(size uint64) uint64 {
 	// No lock here so it can be called
 	// from within the lock (during Set)
+	// Content replaced by a new version goes first
+	evicted := idx.evictWhere(size, func(ref *DataRef) bool {
+		_, ok := idx.superseded[ref.PayloadCID.String()]
+		return ok
+	})
+	if evicted >= size {
+		return evicted
+	}
+	// Then content from publishers using more than their share
+	evicted += idx.evictWhere(size-evicted, func(ref *DataRef) bool {
+		return idx.overShare(ref.Publisher)
+	})
+	if evicted >= size {
+		return evicted
+	}
+	return evicted + idx.evictWhere(size-evicted, func(*DataRef) bool {
+		return true
+	})
+}
+
+// evictWhere evicts the least frequently used refs matching a condition until the given size is freed,
+// callers must hold the lock
+func (idx *Index) evictWhere(size uint64, match func(*DataRef) bool) uint64 {
 	var evicted uint64
-	for place := idx.blist.Front(); place != nil; place = place.Next() {
-		for entry := range place.Value.(*bucket).entries {
-			delete(idx.Refs, entry.PayloadCID.String())
+	idx.evictLFU(match, func() EvictionPressure {
+		return EvictionPressure{
+			Remaining:  size - evicted,
+			Size:       idx.size,
+			UpperBound: idx.ub,
+		}
+	}, func(entry *DataRef) bool {
+		evicted += idx.refSize(entry)
+		return evicted >= size
+	})
+	return evicted
+}
 
-			err := idx.ms.Delete(entry.StoreID)
-			if err != nil {
+// evictLFU evicts the least frequently used refs matching a condition until done returns true after
+// an eviction, callers must hold the lock
+func (idx *Index) evictLFU(match func(*DataRef) bool, pressure func() EvictionPressure, done func(*DataRef) bool) {
+	now := time.Now().Unix()
+	var next *list.Element
+	for place := idx.blist.Front(); place != nil; place = next {
+		// The bucket is removed from the list once empty
+		next = place.Next()
+		for entry := range place.Value.(*bucket).entries {
+			// Content under lease cannot be evicted until the lease expires
+			if entry == idx.keep || entry.LeaseExpiry > now || !match(entry) {
+				continue
+			}
+			if idx.veto != nil && !idx.veto(*entry, pressure()) {
+				continue
+			}
+			k := entry.PayloadCID.String()
+			// The deletions which can fail go first so the ref stays indexed if any of them does.
+			// Evicted refs should not come back when the index is reloaded.
+			if _, err := idx.root.Delete(context.TODO(), k); err != nil {
+				continue
+			}
+			if err := idx.deleteStore(entry.StoreID); err != nil {
+				// The content is still there so it remains indexed
+				if err := idx.root.Set(context.TODO(), k, entry); err != nil {
+					indexLog.Error().Err(err).Str("root", k).Msg("failed to restore ref after eviction failed")
+				}
 				continue
 			}
+			// The content is gone, the ref is removed even if the drop cannot be logged and the
+			// next flush persists it
+			if err := idx.logRef(walDrop, entry); err != nil {
+				indexLog.Warn().Err(err).Str("root", k).Msg("failed to log eviction")
+			}
+			delete(idx.Refs, k)
+			idx.forget(k)
+			_ = idx.ds.Delete(manifestKey(entry.PayloadCID))
+			idx.unmarkSuperseded(entry.PayloadCID)
 
 			idx.remBlistEntry(place, entry)
-			evicted += uint64(entry.PayloadSize)
-			idx.size -= uint64(entry.PayloadSize)
-			if evicted >= size {
-				return evicted
+			idx.removeUsage(entry)
+			idx.recordChange(entry.PayloadCID, nil)
+			idx.rec.recordRef(EventIndexEvict, entry, idx.refSize(entry))
+			metrics.IndexEvictions.Inc()
+			idx.size -= idx.refSize(entry)
+			if done(entry) {
+				return
 			}
 		}
 	}
-	return evicted
 }
 
 // ---------- Interest --------------
@@ -462,86 +959,126 @@ func (idx *Index) LoadInterest(r cid.Cid, store cbor.IpldStore) error {
 
 	idx.imu.Lock()
 	defer idx.imu.Unlock()
+	defer func() {
+		metrics.InterestRefs.Set(float64(len(idx.interest)))
+	}()
+	idx.invalidateSearch()
 	return root.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
 		idx.mu.Lock()
-		if _, ok := idx.Refs[k]; ok {
+		_, ok := idx.lookup(k)
+		idx.mu.Unlock()
+		if ok {
 			// If we already have it skip it
 			return nil
 		}
-		idx.mu.Unlock()
 
 		v := new(DataRef)
 		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
 			return err
 		}
 
-		// Check if this ref already is in the interest list
-		if ref, ok := idx.interest[k]; ok {
-			currentPlace := ref.bucketNode
-			// If it is, add the freqs
-			nextFreq := ref.Freq + v.Freq
-			// sometimes a node may have content with 0 reads in their index
-			if nextFreq == ref.Freq {
-				// no need to do anything
-				return nil
-			}
-			if nextFreq != ref.Freq {
-				// After we're done moving things around we can remove the previous entry
-				defer idx.remFreqEntry(currentPlace, ref)
-			}
-			ref.Freq = nextFreq
-			// starting from the current position iterate until either reaching the right bucket
-			// or a higher bucket
-			for np := ref.bucketNode; np != nil; np = np.Next() {
-				le := np.Value.(*listEntry)
-				if le.freq == nextFreq {
-					le.entries[ref] = 1
-					ref.bucketNode = np
-					return nil
-				}
-				// create a new bucket and insert it before the higher one
-				if le.freq > nextFreq {
-					e := newListEntry(nextFreq)
-					e.entries[ref] = 1
-					ref.bucketNode = idx.freqs.InsertBefore(e, np)
-					return nil
-				}
-			}
-			le := newListEntry(nextFreq)
-			le.entries[ref] = 1
-			ref.bucketNode = idx.freqs.PushBack(le)
-			return nil
+		idx.addInterest(k, v)
+		return nil
+	})
+}
+
+// AddInterest adds refs announced by a peer to the interest list unless we already have them
+func (idx *Index) AddInterest(refs []DataRef) {
+	idx.mu.Lock()
+	var missing []*DataRef
+	for i := range refs {
+		if _, ok := idx.lookup(refs[i].PayloadCID.String()); ok {
+			continue
 		}
+		missing = append(missing, &refs[i])
+	}
+	idx.mu.Unlock()
+	if len(missing) == 0 {
+		return
+	}
 
-		idx.interest[k] = v
-		if e := idx.freqs.Front(); e == nil {
-			// insert the first element in the list
-			li := newListEntry(v.Freq)
-			li.entries[v] = 1
-			v.bucketNode = idx.freqs.PushFront(li)
-			return nil
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	for _, ref := range missing {
+		// The content will be stored in a new store once retrieved
+		ref.StoreID = 0
+		idx.addInterest(ref.PayloadCID.String(), ref)
+	}
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+}
+
+// addInterest inserts a ref in the interest list or adds its frequency to the ref already listed
+// under the same key, callers must hold the interest lock
+func (idx *Index) addInterest(k string, v *DataRef) {
+	idx.idirty[k] = true
+	// Check if this ref already is in the interest list
+	if ref, ok := idx.interest[k]; ok {
+		currentPlace := ref.bucketNode
+		// If it is, add the freqs
+		nextFreq := ref.Freq + v.Freq
+		// sometimes a node may have content with 0 reads in their index
+		if nextFreq == ref.Freq {
+			// no need to do anything
+			return
+		}
+		if nextFreq != ref.Freq {
+			// After we're done moving things around we can remove the previous entry
+			defer idx.remFreqEntry(currentPlace, ref)
 		}
-		for e := idx.freqs.Front(); e != nil; e = e.Next() {
-			le := e.Value.(*listEntry)
-			if le.freq == v.Freq {
-				le.entries[v] = 1
-				v.bucketNode = e
-				return nil
+		ref.Freq = nextFreq
+		// starting from the current position iterate until either reaching the right bucket
+		// or a higher bucket
+		for np := ref.bucketNode; np != nil; np = np.Next() {
+			le := np.Value.(*listEntry)
+			if le.freq == nextFreq {
+				le.entries[ref] = 1
+				ref.bucketNode = np
+				return
 			}
-			if le.freq > v.Freq {
-				li := newListEntry(v.Freq)
-				li.entries[v] = 1
-				v.bucketNode = idx.freqs.InsertBefore(li, e)
-				return nil
+			// create a new bucket and insert it before the higher one
+			if le.freq > nextFreq {
+				e := newListEntry(nextFreq)
+				e.entries[ref] = 1
+				ref.bucketNode = idx.freqs.InsertBefore(e, np)
+				return
 			}
 		}
-		// if we're still here it means we're the highest frequency in the list so we
-		// insert it at the back
+		le := newListEntry(nextFreq)
+		le.entries[ref] = 1
+		ref.bucketNode = idx.freqs.PushBack(le)
+		return
+	}
+
+	idx.interest[k] = v
+	// Make room once the new ref is in place
+	defer idx.capInterest()
+	if e := idx.freqs.Front(); e == nil {
+		// insert the first element in the list
 		li := newListEntry(v.Freq)
 		li.entries[v] = 1
-		v.bucketNode = idx.freqs.PushBack(li)
-		return nil
-	})
+		v.bucketNode = idx.freqs.PushFront(li)
+		return
+	}
+	for e := idx.freqs.Front(); e != nil; e = e.Next() {
+		le := e.Value.(*listEntry)
+		if le.freq == v.Freq {
+			le.entries[v] = 1
+			v.bucketNode = e
+			return
+		}
+		if le.freq > v.Freq {
+			li := newListEntry(v.Freq)
+			li.entries[v] = 1
+			v.bucketNode = idx.freqs.InsertBefore(li, e)
+			return
+		}
+	}
+	// if we're still here it means we're the highest frequency in the list so we
+	// insert it at the back
+	li := newListEntry(v.Freq)
+	li.entries[v] = 1
+	v.bucketNode = idx.freqs.PushBack(li)
 }
 
 // Interesting returns a bucket of most interesting refs in the index that could be retrieved to improve
@@ -600,6 +1137,9 @@ func (idx *Index) DropInterest(k cid.Cid) error {
 		return errors.New("ref not found")
 	}
 	delete(idx.interest, k.String())
+	idx.idirty[k.String()] = true
 	idx.remFreqEntry(ref.bucketNode, ref)
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
 	return nil
 }
@@ -9,6 +9,7 @@ import (
 
 	multistore "github.com/filecoin-project/go-multistore"
 	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	xerrors "golang.org/x/xerrors"
 )
@@ -22,7 +23,7 @@ func (t *DataRef) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{165}); err != nil {
+	if _, err := w.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -125,6 +126,159 @@ func (t *DataRef) MarshalCBOR(w io.Writer) error {
 			return err
 		}
 	}
+
+	// t.Publisher (peer.ID) (string)
+	if len("Publisher") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Publisher\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Publisher"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Publisher")); err != nil {
+		return err
+	}
+
+	if len(t.Publisher) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Publisher was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Publisher))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Publisher)); err != nil {
+		return err
+	}
+
+	// t.LeaseExpiry (int64) (int64)
+	if len("LeaseExpiry") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"LeaseExpiry\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("LeaseExpiry"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("LeaseExpiry")); err != nil {
+		return err
+	}
+
+	if t.LeaseExpiry >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.LeaseExpiry)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.LeaseExpiry-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Keys ([]string) (slice)
+	if len("Keys") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Keys\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Keys"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Keys")); err != nil {
+		return err
+	}
+
+	if len(t.Keys) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Keys was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.Keys))); err != nil {
+		return err
+	}
+	for _, v := range t.Keys {
+		if len(v) > cbg.MaxLength {
+			return xerrors.Errorf("Value in field v was too long")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(v))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, string(v)); err != nil {
+			return err
+		}
+	}
+
+	// t.Labels (map[string]string) (map)
+	if len("Labels") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Labels\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Labels"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Labels")); err != nil {
+		return err
+	}
+
+	{
+		if len(t.Labels) > 4096 {
+			return xerrors.Errorf("cannot marshal t.Labels map too large")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajMap, uint64(len(t.Labels))); err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(t.Labels))
+		for k := range t.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := t.Labels[k]
+
+			if len(k) > cbg.MaxLength {
+				return xerrors.Errorf("Value in field k was too long")
+			}
+
+			if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(k))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, string(k)); err != nil {
+				return err
+			}
+
+			if len(v) > cbg.MaxLength {
+				return xerrors.Errorf("Value in field v was too long")
+			}
+
+			if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(v))); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, string(v)); err != nil {
+				return err
+			}
+
+		}
+	}
+
+	// t.Created (int64) (int64)
+	if len("Created") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Created\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Created"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Created")); err != nil {
+		return err
+	}
+
+	if t.Created >= 0 {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Created)); err != nil {
+			return err
+		}
+	} else {
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajNegativeInt, uint64(-t.Created-1)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -267,6 +421,144 @@ func (t *DataRef) UnmarshalCBOR(r io.Reader) error {
 
 				t.BucketID = int64(extraI)
 			}
+			// t.Publisher (peer.ID) (string)
+		case "Publisher":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Publisher = peer.ID(sval)
+			}
+			// t.LeaseExpiry (int64) (int64)
+		case "LeaseExpiry":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.LeaseExpiry = int64(extraI)
+			}
+			// t.Keys ([]string) (slice)
+		case "Keys":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Keys: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Keys = make([]string, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				{
+					sval, err := cbg.ReadStringBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					t.Keys[i] = string(sval)
+				}
+			}
+
+			// t.Labels (map[string]string) (map)
+		case "Labels":
+
+			maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+			if maj != cbg.MajMap {
+				return fmt.Errorf("expected a map (major type 5)")
+			}
+			if extra > 4096 {
+				return fmt.Errorf("t.Labels: map too large")
+			}
+
+			t.Labels = make(map[string]string, extra)
+
+			for i, l := 0, int(extra); i < l; i++ {
+
+				var k string
+
+				{
+					sval, err := cbg.ReadStringBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					k = string(sval)
+				}
+
+				var v string
+
+				{
+					sval, err := cbg.ReadStringBuf(br, scratch)
+					if err != nil {
+						return err
+					}
+
+					v = string(sval)
+				}
+
+				t.Labels[k] = v
+
+			}
+			// t.Created (int64) (int64)
+		case "Created":
+			{
+				maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+				var extraI int64
+				if err != nil {
+					return err
+				}
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative oveflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Created = int64(extraI)
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
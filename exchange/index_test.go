@@ -3,9 +3,11 @@ package exchange
 import (
 	"bytes"
 	"context"
+	"errors"
 	"math/rand"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/filecoin-project/go-multistore"
 	blocks "github.com/ipfs/go-block-format"
@@ -19,6 +21,7 @@ import (
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
 	sel "github.com/myelnet/pop/selectors"
 	"github.com/stretchr/testify/require"
 )
@@ -306,12 +309,34 @@ func BenchmarkFlush(b *testing.B) {
 			cid := blockGen.Next().Cid()
 			require.NoError(b, idx.SetRef(&DataRef{
 				PayloadCID:  cid,
-				PayloadSize: 100000,
+				PayloadSize: 100,
 				StoreID:     multistore.StoreID(1),
 				Freq:        3,
 			}))
 		}
 	})
+	b.Run("Update", func(b *testing.B) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		ms, err := multistore.NewMultiDstore(ds)
+		require.NoError(b, err)
+
+		idx, err := NewIndex(ds, ms, WithBounds(1000, 900))
+
+		b.ReportAllocs()
+		runtime.GC()
+
+		require.NoError(b, idx.Update(func(tx *IndexTx) error {
+			for i := 0; i < b.N; i++ {
+				tx.SetRef(&DataRef{
+					PayloadCID:  blockGen.Next().Cid(),
+					PayloadSize: 100,
+					StoreID:     multistore.StoreID(1),
+					Freq:        3,
+				})
+			}
+			return nil
+		}))
+	})
 }
 
 // This selector should query a HAMT without following the links
@@ -537,3 +562,477 @@ func TestLoadInterest(t *testing.T) {
 		require.Equal(t, reflist2[0].PayloadCID, k.PayloadCID)
 	}
 }
+
+func TestIndexAddDemand(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+
+	missing := blockGen.Next().Cid()
+	cached, interest, err := idx.AddDemand([]DemandHint{
+		{Root: ref2.PayloadCID, Weight: 5},
+		{Root: missing, Weight: 3},
+		{Root: missing, Weight: 2},
+		// Hints without demand are ignored
+		{Root: ref1.PayloadCID, Weight: 0},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, cached)
+	require.Equal(t, 2, interest)
+
+	// The stored root with demand is now the most frequently used
+	refs, err := idx.ListRefs()
+	require.NoError(t, err)
+	require.Equal(t, ref2.PayloadCID, refs[len(refs)-1].PayloadCID)
+	require.Equal(t, int64(5), refs[len(refs)-1].Freq)
+
+	// Weights for the same root add up in the interest list
+	require.Equal(t, 1, idx.InterestLen())
+	in, err := idx.Interesting()
+	require.NoError(t, err)
+	for ref := range in {
+		require.Equal(t, missing, ref.PayloadCID)
+		require.Equal(t, int64(5), ref.Freq)
+	}
+}
+
+func TestIndexSnapshot(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	// Only the first ref has its content in our stores
+	storeID := ms.Next()
+	store, err := ms.Get(storeID)
+	require.NoError(t, err)
+	blk := blockGen.Next()
+	require.NoError(t, store.Bstore.Put(blk))
+	stored := &DataRef{
+		PayloadCID:  blk.Cid(),
+		PayloadSize: 1000,
+		StoreID:     storeID,
+	}
+	require.NoError(t, idx.SetRef(stored))
+	remote := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 2000,
+		StoreID:     ms.Next(),
+	}
+	require.NoError(t, idx.SetRef(remote))
+	for i := 0; i < 3; i++ {
+		_, err = idx.GetRef(stored.PayloadCID)
+		require.NoError(t, err)
+		_, err = idx.GetRef(remote.PayloadCID)
+		require.NoError(t, err)
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, idx.Export(buf))
+
+	// A new node shares the stores but not the index
+	idx2, err := NewIndex(dss.MutexWrap(datastore.NewMapDatastore()), ms)
+	require.NoError(t, err)
+	require.NoError(t, idx2.Import(buf))
+
+	require.Equal(t, 1, idx2.Len())
+	ref, err := idx2.PeekRef(stored.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), ref.Freq)
+	require.Equal(t, storeID, ref.StoreID)
+
+	require.Equal(t, 1, idx2.InterestLen())
+	in, err := idx2.Interesting()
+	require.NoError(t, err)
+	for ref := range in {
+		require.Equal(t, remote.PayloadCID, ref.PayloadCID)
+		require.Equal(t, int64(3), ref.Freq)
+	}
+
+	// The imported refs persist
+	require.NotEqual(t, cid.Undef, idx2.Root())
+}
+
+func TestIndexPublisherShare(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	// Publishers can use up to 2000 bytes
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 9001), WithPublisherShare(0.2))
+	require.NoError(t, err)
+
+	pubA := peer.ID("publisherA")
+	pubB := peer.ID("publisherB")
+	newRef := func(p peer.ID, size int64) *DataRef {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: size,
+			Publisher:   p,
+		}
+		require.NoError(t, idx.SetRef(ref))
+		return ref
+	}
+
+	a1 := newRef(pubA, 1000)
+	a2 := newRef(pubA, 1000)
+	_, err = idx.GetRef(a2.PayloadCID)
+	require.NoError(t, err)
+
+	// The least used content of the publisher is evicted once it goes over its share
+	a3 := newRef(pubA, 1000)
+	_, err = idx.PeekRef(a1.PayloadCID)
+	require.Error(t, err)
+	_, err = idx.PeekRef(a3.PayloadCID)
+	require.NoError(t, err)
+
+	b1 := newRef(pubB, 1000)
+	u1 := newRef("", 1000)
+
+	require.Equal(t, []PublisherUsage{
+		{Publisher: pubA, Refs: 2, Size: 2000},
+		{Publisher: pubB, Refs: 1, Size: 1000},
+	}, idx.Publishers())
+
+	// Lowering the share doesn't evict anything until we need room
+	idx, err = NewIndex(ds, ms, WithBounds(10000, 9001), WithPublisherShare(0.1))
+	require.NoError(t, err)
+	require.Equal(t, 4, idx.Len())
+
+	// Content from publishers over their share is evicted first
+	newRef("", 6001)
+	require.Equal(t, 4, idx.Len())
+	_, err = idx.PeekRef(b1.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.PeekRef(u1.PayloadCID)
+	require.NoError(t, err)
+	usage := idx.Publishers()
+	require.Len(t, usage, 2)
+	require.Equal(t, uint64(1000), usage[0].Size)
+	require.Equal(t, 1, usage[0].Refs)
+}
+
+func TestIndexInterestPersistence(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithInterestPolicy(3, time.Hour))
+	require.NoError(t, err)
+
+	var refs []*DataRef
+	idx.imu.Lock()
+	for i := 0; i < 4; i++ {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 1000,
+			Freq:        int64(i + 1),
+		}
+		refs = append(refs, ref)
+		idx.addInterest(ref.PayloadCID.String(), ref)
+	}
+	idx.imu.Unlock()
+
+	// The least popular ref is dropped once the list is full
+	require.Equal(t, 3, idx.InterestLen())
+	require.NoError(t, idx.FlushInterest())
+
+	// The interest list is loaded after a restart
+	idx, err = NewIndex(ds, ms, WithInterestPolicy(3, time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 3, idx.InterestLen())
+	idx.imu.Lock()
+	_, ok := idx.interest[refs[0].PayloadCID.String()]
+	require.False(t, ok)
+	require.Equal(t, int64(4), idx.interest[refs[3].PayloadCID.String()].Freq)
+	// Two half lives elapsed
+	idx.decayed = time.Now().Add(-2 * time.Hour)
+	idx.imu.Unlock()
+
+	require.NoError(t, idx.FlushInterest())
+	require.Equal(t, 1, idx.InterestLen())
+
+	idx, err = NewIndex(ds, ms, WithInterestPolicy(3, time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, idx.InterestLen())
+	idx.imu.Lock()
+	require.Equal(t, int64(1), idx.interest[refs[3].PayloadCID.String()].Freq)
+	idx.imu.Unlock()
+}
+
+func TestIndexRange(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	keys := make(map[cid.Cid]bool)
+	for i := 0; i < 5; i++ {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 100,
+		}
+		require.NoError(t, idx.SetRef(ref))
+		keys[ref.PayloadCID] = true
+	}
+	require.Len(t, idx.Keys(), 5)
+	for _, k := range idx.Keys() {
+		require.True(t, keys[k])
+	}
+
+	// Callbacks can access the index and modifying copies has no effect
+	var seen int
+	idx.Range(func(ref *DataRef) bool {
+		_, err := idx.GetRef(ref.PayloadCID)
+		require.NoError(t, err)
+		ref.PayloadSize = 0
+		seen++
+		return seen < 3
+	})
+	require.Equal(t, 3, seen)
+
+	snap := idx.Snapshot()
+	require.Len(t, snap, 5)
+	for _, ref := range snap {
+		require.Equal(t, int64(100), ref.PayloadSize)
+	}
+}
+
+func TestIndexEvictionVeto(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	contracted := blockGen.Next().Cid()
+	var pressure EvictionPressure
+	idx, err := NewIndex(ds, ms, WithBounds(1000, 800), WithEvictionVeto(func(ref DataRef, p EvictionPressure) bool {
+		pressure = p
+		return ref.PayloadCID != contracted
+	}))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  contracted,
+		PayloadSize: 400,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 400,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+	// ref2 is more popular but ref1 cannot be evicted
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+
+	ref3 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 400,
+	}
+	require.NoError(t, idx.SetRef(ref3))
+
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.Error(t, err)
+	require.Equal(t, uint64(400), pressure.Remaining)
+	require.Equal(t, uint64(1000), pressure.UpperBound)
+}
+
+func TestIndexCreated(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	root := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: 100,
+		Created:     1000,
+	}))
+
+	// Updating the ref keeps the time it was first indexed
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  root,
+		PayloadSize: 200,
+	}))
+	ref, err := idx.PeekRef(root)
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), ref.Created)
+
+	// New refs are timestamped
+	before := time.Now().Unix()
+	ref = &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+	}
+	require.NoError(t, idx.SetRef(ref))
+	require.GreaterOrEqual(t, ref.Created, before)
+
+	// The time is persisted
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	loaded, err := idx.PeekRef(ref.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, ref.Created, loaded.Created)
+}
+
+func TestIndexRemoteRead(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	updates := 0
+	idx, err := NewIndex(ds, ms,
+		WithBounds(512000, 500000),
+		WithRemoteReadWeight(3),
+		WithUpdateFunc(func() { updates++ }),
+	)
+	require.NoError(t, err)
+
+	local := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(local))
+	remote := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 200000,
+	}
+	require.NoError(t, idx.SetRef(remote))
+
+	_, err = idx.GetRef(local.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.GetRef(local.PayloadCID)
+	require.NoError(t, err)
+	require.NoError(t, idx.RemoteRead(remote.PayloadCID))
+
+	// A single remote read outweighs two local reads
+	ref, err := idx.PeekRef(remote.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), ref.Freq)
+	require.Equal(t, 2, updates)
+
+	// So the locally read ref is evicted first
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100000,
+	}))
+	_, err = idx.PeekRef(local.PayloadCID)
+	require.Error(t, err)
+	_, err = idx.PeekRef(remote.PayloadCID)
+	require.NoError(t, err)
+
+	require.True(t, errors.Is(idx.RemoteRead(local.PayloadCID), ErrRefNotFound))
+
+	// Remote reads can be ignored
+	idx, err = NewIndex(ds, ms, WithBounds(512000, 500000), WithRemoteReadWeight(0))
+	require.NoError(t, err)
+	require.NoError(t, idx.RemoteRead(remote.PayloadCID))
+	ref, err = idx.PeekRef(remote.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), ref.Freq)
+}
+
+func TestIndexOverwriteEviction(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithBounds(512000, 500000))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	_, err = idx.GetRef(ref1.PayloadCID)
+	require.NoError(t, err)
+
+	// A new version of the same content replaces the ref
+	updated := &DataRef{
+		PayloadCID:  ref1.PayloadCID,
+		PayloadSize: 200000,
+	}
+	require.NoError(t, idx.SetRef(updated))
+	require.Equal(t, 1, idx.Len())
+	require.Equal(t, uint64(200000), idx.size)
+	// It keeps the reads of the version it replaced
+	require.Equal(t, int64(2), updated.Freq)
+
+	// Going over the upper bound evicts the new version only once
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 356000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.Error(t, err)
+	require.Equal(t, 1, idx.Len())
+	require.Equal(t, uint64(356000), idx.size)
+	require.Equal(t, 1, idx.blist.Len())
+	for entry := range idx.blist.Front().Value.(*bucket).entries {
+		require.Equal(t, ref2, entry)
+	}
+}
+
+func TestIndexOverwriteLeastUsed(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithBounds(512000, 500000))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 300000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+
+	// The new version goes over the upper bound while sitting in the least used bucket
+	updated := &DataRef{
+		PayloadCID:  ref1.PayloadCID,
+		PayloadSize: 250000,
+	}
+	require.NoError(t, idx.SetRef(updated))
+
+	// The other content is evicted instead
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.Error(t, err)
+	ref, err := idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, updated, ref)
+	require.Equal(t, 1, idx.Len())
+	require.Equal(t, uint64(250000), idx.size)
+	require.Equal(t, int64(2), updated.Freq)
+}
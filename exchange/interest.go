@@ -0,0 +1,163 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/filecoin-project/go-hamt-ipld/v3"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/myelnet/pop/metrics"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// KInterest is the datastore key for persisting the root of the interest list
+const KInterest = "interest"
+
+// DefaultMaxInterest is the default maximum number of refs in the interest list
+const DefaultMaxInterest = 10000
+
+// DefaultInterestHalfLife is the default period after which the frequencies in the interest list are halved
+const DefaultInterestHalfLife = 24 * time.Hour
+
+// WithInterestPolicy bounds the interest list to a maximum number of refs, the least popular refs are
+// dropped when it is full. Frequencies are halved every half life so content which isn't popular anymore
+// eventually leaves the list.
+func WithInterestPolicy(max int, halfLife time.Duration) IndexOption {
+	return func(idx *Index) {
+		idx.maxInterest = max
+		idx.halfLife = halfLife
+	}
+}
+
+// loadInterestList loads the interest list persisted by a previous session
+func (idx *Index) loadInterestList() error {
+	idx.decayed = time.Now()
+	enc, err := idx.ds.Get(datastore.NewKey(KInterest))
+	if errors.Is(err, datastore.ErrNotFound) {
+		idx.iroot, err = hamt.NewNode(idx.store, hamt.UseTreeBitWidth(5), hashOption)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	r, err := cid.Cast(enc)
+	if err != nil {
+		return err
+	}
+	idx.iroot, err = idx.LoadRoot(r, idx.store)
+	if err != nil {
+		return err
+	}
+	err = idx.iroot.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
+		if _, ok := idx.lookup(k); ok {
+			// We may have retrieved it before the list was flushed
+			idx.idirty[k] = true
+			return nil
+		}
+		v := new(DataRef)
+		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			return err
+		}
+		idx.addInterest(k, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Only the refs we already have need to be removed from the persisted list
+	for k := range idx.idirty {
+		if _, ok := idx.interest[k]; ok {
+			delete(idx.idirty, k)
+		}
+	}
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+	return nil
+}
+
+// FlushInterest decays the interest frequencies if a half life elapsed and persists the changes
+// made to the interest list since the last flush
+func (idx *Index) FlushInterest() error {
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	idx.decayInterest(time.Now())
+	if len(idx.idirty) == 0 {
+		return nil
+	}
+	for k := range idx.idirty {
+		if ref, ok := idx.interest[k]; ok {
+			if err := idx.iroot.Set(context.TODO(), k, ref); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := idx.iroot.Delete(context.TODO(), k); err != nil {
+			return err
+		}
+	}
+	if err := idx.iroot.Flush(context.TODO()); err != nil {
+		return err
+	}
+	r, err := idx.store.Put(context.TODO(), idx.iroot)
+	if err != nil {
+		return err
+	}
+	if err := idx.ds.Put(datastore.NewKey(KInterest), r.Bytes()); err != nil {
+		return err
+	}
+	idx.idirty = make(map[string]bool)
+	return nil
+}
+
+// decayInterest halves the interest frequencies for every half life elapsed since the last decay and
+// drops the refs nobody is interested in anymore, callers must hold the interest lock
+func (idx *Index) decayInterest(now time.Time) {
+	if idx.halfLife <= 0 {
+		return
+	}
+	n := uint(now.Sub(idx.decayed) / idx.halfLife)
+	if n == 0 {
+		return
+	}
+	idx.decayed = idx.decayed.Add(time.Duration(n) * idx.halfLife)
+	refs := idx.interest
+	idx.interest = make(map[string]*DataRef)
+	idx.freqs.Init()
+	for k, ref := range refs {
+		idx.idirty[k] = true
+		if n < 63 {
+			ref.Freq >>= n
+		} else {
+			ref.Freq = 0
+		}
+		if ref.Freq > 0 {
+			ref.bucketNode = nil
+			idx.addInterest(k, ref)
+		}
+	}
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+}
+
+// capInterest drops the least popular refs once the interest list is full, callers must hold the
+// interest lock
+func (idx *Index) capInterest() {
+	if idx.maxInterest <= 0 {
+		return
+	}
+	for len(idx.interest) > idx.maxInterest {
+		front := idx.freqs.Front()
+		if front == nil {
+			return
+		}
+		for ref := range front.Value.(*listEntry).entries {
+			k := ref.PayloadCID.String()
+			delete(idx.interest, k)
+			idx.idirty[k] = true
+			idx.remFreqEntry(front, ref)
+			break
+		}
+	}
+}
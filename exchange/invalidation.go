@@ -0,0 +1,284 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+//go:generate cbor-gen-for Invalidation
+
+// InvalidationTopic is the gossip topic publishers broadcast cache invalidations on
+const InvalidationTopic = "/myel/pop/invalidate/1.0"
+
+// MaxInvalidationAge is the maximum age of an invalidation message after which it is ignored
+// to prevent older messages from being replayed
+const MaxInvalidationAge = 24 * time.Hour
+
+// ErrInvalidSignature is returned when an invalidation is not signed by its publisher
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// InvalidationMethod is the action caches should take when receiving an invalidation
+type InvalidationMethod uint64
+
+const (
+	// Purge asks caches to drop the content
+	Purge InvalidationMethod = iota
+	// Supersede asks caches to replace the content with a new root
+	Supersede
+	// Amend asks caches to replace the content with a new root by retrieving only the changed entries
+	Amend
+)
+
+// Invalidation is a signed message from a content publisher notifying caches that
+// a root they dispatched should no longer be served
+type Invalidation struct {
+	Method    InvalidationMethod
+	Root      cid.Cid
+	Successor *cid.Cid
+	// Changed is the keys of the entries which differ in the successor when amending
+	Changed   []string
+	Publisher peer.ID
+	// Time is the unix timestamp at which the message was signed
+	Time      int64
+	Signature []byte
+}
+
+// SigningBytes returns the encoded message without the signature
+func (inv Invalidation) SigningBytes() ([]byte, error) {
+	inv.Signature = nil
+	buf := new(bytes.Buffer)
+	if err := inv.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign the message with the publisher private key
+func (inv *Invalidation) Sign(key crypto.PrivKey) error {
+	data, err := inv.SigningBytes()
+	if err != nil {
+		return err
+	}
+	inv.Signature, err = key.Sign(data)
+	return err
+}
+
+// Verify checks the message was signed by the given public key
+func (inv *Invalidation) Verify(key crypto.PubKey) error {
+	data, err := inv.SigningBytes()
+	if err != nil {
+		return err
+	}
+	ok, err := key.Verify(data, inv.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Invalidator broadcasts invalidations for content we published and applies invalidations
+// received from the publishers of content we cache
+type Invalidator struct {
+	h   host.Host
+	ps  *pubsub.PubSub
+	ds  datastore.Batching
+	idx *Index
+	rtv RoutedRetriever
+	top *pubsub.Topic
+	// out holds our invalidations until they reach the network
+	out *Outbox
+}
+
+// NewInvalidator creates a new Invalidator service
+func NewInvalidator(h host.Host, ps *pubsub.PubSub, ds datastore.Batching, idx *Index, rtv RoutedRetriever) *Invalidator {
+	return &Invalidator{
+		h:   h,
+		ps:  ps,
+		ds:  ds,
+		idx: idx,
+		rtv: rtv,
+	}
+}
+
+// Start joins the invalidation topic and starts handling messages
+func (iv *Invalidator) Start(ctx context.Context) error {
+	top, err := iv.ps.Join(InvalidationTopic)
+	if err != nil {
+		return err
+	}
+	iv.top = top
+	iv.out = NewOutbox(iv.ds, top, MaxInvalidationAge)
+	if err := iv.out.Start(ctx); err != nil {
+		return err
+	}
+	sub, err := top.Subscribe()
+	if err != nil {
+		return err
+	}
+	go iv.pump(ctx, sub)
+	return nil
+}
+
+func (iv *Invalidator) pump(ctx context.Context, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		// We don't need to handle our own messages
+		if msg.ReceivedFrom == iv.h.ID() {
+			continue
+		}
+		var inv Invalidation
+		if err := inv.UnmarshalCBOR(bytes.NewReader(msg.Data)); err != nil {
+			continue
+		}
+		if err := iv.Handle(ctx, inv); err != nil && !errors.Is(err, ErrRefNotFound) {
+			indexLog.Warn().Err(err).Msg("failed to handle invalidation")
+		}
+	}
+}
+
+// Pending returns the invalidations which haven't been published yet because we were offline or
+// didn't know any peer on the topic
+func (iv *Invalidator) Pending() ([]OutboxMessage, error) {
+	if iv.out == nil {
+		return nil, errors.New("invalidator not started")
+	}
+	return iv.out.Pending()
+}
+
+// Purge broadcasts a message asking caches to drop the given root
+func (iv *Invalidator) Purge(ctx context.Context, root cid.Cid) error {
+	return iv.publish(ctx, Invalidation{
+		Method: Purge,
+		Root:   root,
+	})
+}
+
+// Supersede broadcasts a message asking caches to replace a root with its successor. If we hold both
+// versions caches are only asked to retrieve the entries which changed.
+func (iv *Invalidator) Supersede(ctx context.Context, root cid.Cid, successor cid.Cid) error {
+	inv := Invalidation{
+		Method:    Supersede,
+		Root:      root,
+		Successor: &successor,
+	}
+	if changed, err := iv.idx.entryDelta(ctx, root, successor); err == nil {
+		inv.Method = Amend
+		inv.Changed = changed
+	}
+	return iv.publish(ctx, inv)
+}
+
+func (iv *Invalidator) publish(ctx context.Context, inv Invalidation) error {
+	if iv.out == nil {
+		return errors.New("invalidator not started")
+	}
+	inv.Publisher = iv.h.ID()
+	inv.Time = time.Now().Unix()
+	if err := inv.Sign(iv.h.Peerstore().PrivKey(iv.h.ID())); err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := inv.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	return iv.out.Publish(ctx, buf.Bytes())
+}
+
+// Handle verifies an invalidation against the publisher of the cached content and applies it
+func (iv *Invalidator) Handle(ctx context.Context, inv Invalidation) error {
+	ref, err := iv.idx.PeekRef(inv.Root)
+	if err != nil {
+		return err
+	}
+	// Only the peer who dispatched the content to us can invalidate it
+	if ref.Publisher == "" || ref.Publisher != inv.Publisher {
+		return fmt.Errorf("%s is not the publisher of %s", inv.Publisher, inv.Root)
+	}
+	if time.Since(time.Unix(inv.Time, 0)) > MaxInvalidationAge {
+		return fmt.Errorf("invalidation for %s is too old", inv.Root)
+	}
+	key := iv.h.Peerstore().PubKey(inv.Publisher)
+	if key == nil {
+		key, err = inv.Publisher.ExtractPublicKey()
+		if err != nil {
+			return err
+		}
+	}
+	if err := inv.Verify(key); err != nil {
+		return err
+	}
+
+	switch inv.Method {
+	case Purge:
+		return iv.idx.DropRef(inv.Root)
+	case Supersede:
+		if inv.Successor == nil {
+			return fmt.Errorf("no successor for %s", inv.Root)
+		}
+		return iv.supersede(ctx, inv)
+	case Amend:
+		if inv.Successor == nil {
+			return fmt.Errorf("no successor for %s", inv.Root)
+		}
+		dr, ok := iv.rtv.(DeltaRetriever)
+		if !ok {
+			return iv.supersede(ctx, inv)
+		}
+		succ := *inv.Successor
+		go func() {
+			// We keep serving the previous version until the new one is complete
+			err := dr.FindAndRetrieveEntries(ctx, succ, inv.Changed, func(id multistore.StoreID) error {
+				return iv.idx.ApplyDelta(ctx, inv.Root, succ, id)
+			})
+			if err == nil {
+				return
+			}
+			indexLog.Warn().Err(err).Str("root", inv.Root.String()).Msg("failed to amend")
+			if err := iv.supersede(ctx, inv); err != nil && !errors.Is(err, ErrRefNotFound) {
+				indexLog.Error().Err(err).Str("root", inv.Root.String()).Msg("failed to supersede")
+			}
+		}()
+		return nil
+	default:
+		return fmt.Errorf("unknown invalidation method %d", inv.Method)
+	}
+}
+
+// supersede drops the root and retrieves the whole successor
+func (iv *Invalidator) supersede(ctx context.Context, inv Invalidation) error {
+	if err := iv.idx.DropRef(inv.Root); err != nil {
+		return err
+	}
+	succ := *inv.Successor
+	go func() {
+		if err := iv.rtv.FindAndRetrieve(ctx, succ); err != nil {
+			txLog.Warn().Err(err).Str("root", succ.String()).Msg("failed to retrieve successor")
+			return
+		}
+		// Keep track of the publisher so they can invalidate the new root too
+		err := iv.idx.UpdateRef(succ, func(r *DataRef) {
+			r.Publisher = inv.Publisher
+		})
+		if err != nil {
+			indexLog.Error().Err(err).Str("root", succ.String()).Msg("failed to update successor ref")
+		}
+	}()
+	return nil
+}
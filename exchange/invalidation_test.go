@@ -0,0 +1,110 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type testRetriever struct {
+	roots chan cid.Cid
+}
+
+func (tr testRetriever) FindAndRetrieve(ctx context.Context, root cid.Cid) error {
+	tr.roots <- root
+	return nil
+}
+
+func TestInvalidation(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	pub, err := mn.GenPeer()
+	require.NoError(t, err)
+	cache, err := mn.GenPeer()
+	require.NoError(t, err)
+	other, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	cache.Peerstore().AddPubKey(pub.ID(), pub.Peerstore().PubKey(pub.ID()))
+	cache.Peerstore().AddPubKey(other.ID(), other.Peerstore().PubKey(other.ID()))
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	rtv := testRetriever{roots: make(chan cid.Cid, 1)}
+	iv := NewInvalidator(cache, nil, ds, idx, rtv)
+
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+		Publisher:   pub.ID(),
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+		Publisher:   pub.ID(),
+	}
+	require.NoError(t, idx.SetRef(ref2))
+
+	// Publisher is persisted with the ref
+	buf := new(bytes.Buffer)
+	require.NoError(t, ref1.MarshalCBOR(buf))
+	var dec DataRef
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.Equal(t, pub.ID(), dec.Publisher)
+
+	// Signed by someone else than the publisher
+	inv := Invalidation{
+		Method:    Purge,
+		Root:      ref1.PayloadCID,
+		Publisher: other.ID(),
+		Time:      time.Now().Unix(),
+	}
+	require.NoError(t, inv.Sign(other.Peerstore().PrivKey(other.ID())))
+	require.Error(t, iv.Handle(ctx, inv))
+
+	// Pretending to be the publisher
+	inv.Publisher = pub.ID()
+	require.True(t, errors.Is(iv.Handle(ctx, inv), ErrInvalidSignature))
+
+	// Message goes over the wire
+	require.NoError(t, inv.Sign(pub.Peerstore().PrivKey(pub.ID())))
+	buf.Reset()
+	require.NoError(t, inv.MarshalCBOR(buf))
+	var rcvd Invalidation
+	require.NoError(t, rcvd.UnmarshalCBOR(buf))
+	require.NoError(t, iv.Handle(ctx, rcvd))
+
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.True(t, errors.Is(err, ErrRefNotFound))
+
+	// Superseding a root drops it and retrieves the successor
+	succ := blockGen.Next().Cid()
+	inv = Invalidation{
+		Method:    Supersede,
+		Root:      ref2.PayloadCID,
+		Successor: &succ,
+		Publisher: pub.ID(),
+		Time:      time.Now().Unix(),
+	}
+	require.NoError(t, inv.Sign(pub.Peerstore().PrivKey(pub.ID())))
+	require.NoError(t, iv.Handle(ctx, inv))
+
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.True(t, errors.Is(err, ErrRefNotFound))
+	require.Equal(t, succ, <-rtv.roots)
+}
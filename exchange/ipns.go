@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipns"
+	ipnspb "github.com/ipfs/go-ipns/pb"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+)
+
+// DefaultIPNSLifetime is how long a published IPNS record stays valid
+const DefaultIPNSLifetime = 24 * time.Hour
+
+// ErrNoValueStore is returned when publishing IPNS records without a value store to put them in
+var ErrNoValueStore = errors.New("no value store to publish IPNS records")
+
+// IPNS publishes and resolves the IPNS records naming our roots with the keys of our keystore. Records
+// are stored in a value store such as the DHT which validates their signature.
+type IPNS struct {
+	vs       routing.ValueStore
+	lifetime time.Duration
+}
+
+// NewIPNS creates an IPNS publisher and resolver on top of a value store
+func NewIPNS(vs routing.ValueStore) *IPNS {
+	return &IPNS{
+		vs:       vs,
+		lifetime: DefaultIPNSLifetime,
+	}
+}
+
+// Publish signs a record pointing the name of the key to a root and returns the name formatted
+// as /ipns/<peer id>. The sequence number follows the last record published under the name.
+func (n *IPNS) Publish(ctx context.Context, sk crypto.PrivKey, root cid.Cid) (string, error) {
+	if n == nil || n.vs == nil {
+		return "", ErrNoValueStore
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return "", err
+	}
+	key := ipns.RecordKey(pid)
+	var seq uint64
+	if prev, err := n.getEntry(ctx, key); err == nil {
+		seq = prev.GetSequence() + 1
+	}
+	entry, err := ipns.Create(sk, []byte("/ipfs/"+root.String()), seq, time.Now().Add(n.lifetime))
+	if err != nil {
+		return "", err
+	}
+	// The public key of short Ed25519 peer IDs is already embedded in the ID
+	if err := ipns.EmbedPublicKey(sk.GetPublic(), entry); err != nil {
+		return "", err
+	}
+	data, err := entry.Marshal()
+	if err != nil {
+		return "", err
+	}
+	if err := n.vs.PutValue(ctx, key, data); err != nil {
+		return "", err
+	}
+	return "/ipns/" + pid.Pretty(), nil
+}
+
+// Resolve returns the root an /ipns/<peer id> name points to. Names which aren't peer IDs such as
+// DNSLink domains are not supported.
+func (n *IPNS) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	pid, err := peer.Decode(strings.TrimSuffix(strings.TrimPrefix(name, "/ipns/"), "/"))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrUnsupportedName, name)
+	}
+	if n == nil || n.vs == nil {
+		return cid.Undef, ErrNoValueStore
+	}
+	entry, err := n.getEntry(ctx, ipns.RecordKey(pid))
+	if err != nil {
+		return cid.Undef, err
+	}
+	p, err := ParsePath(string(entry.GetValue()))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p.Root, nil
+}
+
+func (n *IPNS) getEntry(ctx context.Context, key string) (*ipnspb.IpnsEntry, error) {
+	data, err := n.vs.GetValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	entry := new(ipnspb.IpnsEntry)
+	if err := entry.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ChainResolver resolves names with the first resolver supporting them
+type ChainResolver []NameResolver
+
+// Resolve tries each resolver until one supports the name
+func (c ChainResolver) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	for _, r := range c {
+		root, err := r.Resolve(ctx, name)
+		if errors.Is(err, ErrUnsupportedName) {
+			continue
+		}
+		return root, err
+	}
+	return cid.Undef, fmt.Errorf("%w: %s", ErrUnsupportedName, name)
+}
+
+// resolveNamedPath replaces the /ipns/<name> prefix of a path with the root the name points to
+func resolveNamedPath(ctx context.Context, res NameResolver, p string) (string, error) {
+	if !strings.HasPrefix(p, "/ipns/") {
+		return p, nil
+	}
+	segs := strings.SplitN(strings.TrimPrefix(p, "/ipns/"), "/", 2)
+	root, err := res.Resolve(ctx, "/ipns/"+segs[0])
+	if err != nil {
+		return "", err
+	}
+	if len(segs) == 1 {
+		return "/" + root.String(), nil
+	}
+	return "/" + root.String() + "/" + segs[1], nil
+}
@@ -0,0 +1,135 @@
+package exchange
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipns"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// mapValueStore is a value store validating IPNS records like the DHT does
+type mapValueStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (vs *mapValueStore) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	v := ipns.Validator{}
+	if err := v.Validate(key, val); err != nil {
+		return err
+	}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.values[key] = val
+	return nil
+}
+
+func (vs *mapValueStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	val, ok := vs.values[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return val, nil
+}
+
+func (vs *mapValueStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	val, err := vs.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []byte, 1)
+	ch <- val
+	close(ch)
+	return ch, nil
+}
+
+func TestIPNS(t *testing.T) {
+	ctx := context.Background()
+	vs := &mapValueStore{values: make(map[string][]byte)}
+	n := NewIPNS(vs)
+
+	sk, _, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+
+	first := blockGen.Next().Cid()
+	name, err := n.Publish(ctx, sk, first)
+	require.NoError(t, err)
+	require.Equal(t, "/ipns/"+pid.Pretty(), name)
+
+	root, err := n.Resolve(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, first, root)
+
+	// Publishing again points the name to the new root with a higher sequence number
+	second := blockGen.Next().Cid()
+	_, err = n.Publish(ctx, sk, second)
+	require.NoError(t, err)
+	entry, err := n.getEntry(ctx, ipns.RecordKey(pid))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), entry.GetSequence())
+
+	root, err = n.Resolve(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, second, root)
+
+	// Small keys are inlined in the peer ID
+	edsk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	name, err = n.Publish(ctx, edsk, first)
+	require.NoError(t, err)
+	root, err = n.Resolve(ctx, name)
+	require.NoError(t, err)
+	require.Equal(t, first, root)
+
+	_, err = n.Resolve(ctx, "/ipns/myel.network")
+	require.True(t, errors.Is(err, ErrUnsupportedName))
+
+	_, err = (*IPNS)(nil).Publish(ctx, sk, first)
+	require.True(t, errors.Is(err, ErrNoValueStore))
+}
+
+func TestResolveNamedPath(t *testing.T) {
+	ctx := context.Background()
+	vs := &mapValueStore{values: make(map[string][]byte)}
+	n := NewIPNS(vs)
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	root := blockGen.Next().Cid()
+	name, err := n.Publish(ctx, sk, root)
+	require.NoError(t, err)
+
+	dnsRoot := blockGen.Next().Cid()
+	res := ChainResolver{n, &mockResolver{roots: map[string]cid.Cid{"/ipns/myel.network": dnsRoot}}}
+
+	p, err := resolveNamedPath(ctx, res, name+"/data.txt")
+	require.NoError(t, err)
+	require.Equal(t, "/"+root.String()+"/data.txt", p)
+
+	p, err = resolveNamedPath(ctx, res, "/ipns/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, "/"+dnsRoot.String(), p)
+
+	// Other paths are left for the tags and CIDs to be parsed
+	p, err = resolveNamedPath(ctx, res, "mytag/data.txt")
+	require.NoError(t, err)
+	require.Equal(t, "mytag/data.txt", p)
+
+	_, err = resolveNamedPath(ctx, res, "/ipns/unknown.network")
+	require.True(t, errors.Is(err, ErrUnsupportedName))
+
+	require.NoError(t, CheckPath(name+"/data.txt"))
+	require.Error(t, CheckPath("/ipns/"))
+	require.Error(t, CheckPath(name+"/../data.txt"))
+}
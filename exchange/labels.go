@@ -0,0 +1,66 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidLabel is returned when a label filter is not formatted as key=value
+var ErrInvalidLabel = errors.New("label must be formatted as key=value")
+
+// Label is a key value pair of metadata to filter refs with
+type Label struct {
+	Key   string
+	Value string
+}
+
+// String formats the label as key=value
+func (l Label) String() string {
+	return l.Key + "=" + l.Value
+}
+
+// ParseLabel parses a label formatted as key=value
+func ParseLabel(s string) (Label, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return Label{}, fmt.Errorf("%w: %q", ErrInvalidLabel, s)
+	}
+	return Label{Key: kv[0], Value: kv[1]}, nil
+}
+
+// HasLabels returns true if the ref was labeled with all the given labels
+func (r *DataRef) HasLabels(labels ...Label) bool {
+	for _, l := range labels {
+		v, ok := r.Labels[l.Key]
+		if !ok || v != l.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLabel adds metadata to save with the ref of the transaction when committing such as the name of
+// the application, the content type or the owner. Setting an empty value removes the label.
+func (tx *Tx) SetLabel(key, value string) {
+	if value == "" {
+		delete(tx.labels, key)
+		return
+	}
+	if tx.labels == nil {
+		tx.labels = make(map[string]string)
+	}
+	tx.labels[key] = value
+}
+
+// copyLabels returns a copy of the labels so the ref isn't modified if more labels are set later
+func (tx *Tx) copyLabels() map[string]string {
+	if len(tx.labels) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(tx.labels))
+	for k, v := range tx.labels {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,95 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabel(t *testing.T) {
+	l, err := ParseLabel("type=image/png")
+	require.NoError(t, err)
+	require.Equal(t, Label{Key: "type", Value: "image/png"}, l)
+	require.Equal(t, "type=image/png", l.String())
+
+	l, err = ParseLabel("owner=a=b")
+	require.NoError(t, err)
+	require.Equal(t, "a=b", l.Value)
+
+	_, err = ParseLabel("owner")
+	require.True(t, errors.Is(err, ErrInvalidLabel))
+	_, err = ParseLabel("=value")
+	require.True(t, errors.Is(err, ErrInvalidLabel))
+}
+
+func TestIndexLabels(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	app := Label{Key: "app", Value: "pop"}
+	owner := Label{Key: "owner", Value: "alice"}
+
+	labeled := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+		Labels:      map[string]string{"app": "pop", "owner": "alice"},
+	}
+	require.NoError(t, idx.SetRef(labeled))
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+		Labels:      map[string]string{"app": "pop"},
+	}))
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+	}))
+
+	refs, err := idx.ListRefs()
+	require.NoError(t, err)
+	require.Len(t, refs, 3)
+
+	refs, err = idx.ListRefs(app)
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+
+	refs, err = idx.ListRefs(app, owner)
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	require.Equal(t, labeled.PayloadCID, refs[0].PayloadCID)
+
+	refs, err = idx.ListRefs(Label{Key: "owner", Value: "bob"})
+	require.NoError(t, err)
+	require.Len(t, refs, 0)
+
+	require.NoError(t, idx.Flush())
+
+	// Labels are persisted with the refs
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	ref, err := idx.PeekRef(labeled.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, labeled.Labels, ref.Labels)
+}
+
+func TestTxSetLabel(t *testing.T) {
+	tx := &Tx{}
+	tx.SetLabel("app", "pop")
+	tx.SetLabel("owner", "alice")
+	tx.SetLabel("owner", "")
+
+	ref := tx.Ref()
+	require.Equal(t, map[string]string{"app": "pop"}, ref.Labels)
+
+	// Labels set later don't modify the ref
+	tx.SetLabel("type", "text/plain")
+	require.Len(t, ref.Labels, 1)
+}
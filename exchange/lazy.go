@@ -0,0 +1,126 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/filecoin-project/go-hamt-ipld/v3"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// WithFastStart loads refs lazily from the HAMT the first time they are accessed instead of loading the
+// whole index before serving. A background pass then loads the remaining refs and drops the ones whose
+// content is missing from the stores. Until the pass completes, listing refs and evicting content only
+// account for the refs loaded so far.
+func WithFastStart() IndexOption {
+	return func(idx *Index) {
+		idx.lazy = true
+	}
+}
+
+// Loaded returns a channel closed once every ref from the HAMT is loaded in memory
+func (idx *Index) Loaded() <-chan struct{} {
+	return idx.loadDone
+}
+
+// load adds a ref read from the HAMT to the in memory index, callers must hold the lock
+func (idx *Index) load(v *DataRef) {
+	idx.Refs[v.PayloadCID.String()] = v
+	idx.size += idx.refSize(v)
+	idx.addUsage(v)
+	idx.insertRef(v)
+}
+
+// lookup returns a ref from memory or reads it from the HAMT if the index isn't fully loaded yet,
+// callers must hold the lock
+func (idx *Index) lookup(k string) (*DataRef, bool) {
+	if ref, ok := idx.Refs[k]; ok {
+		return ref, true
+	}
+	if idx.loaded {
+		return nil, false
+	}
+	if _, ok := idx.removed[k]; ok {
+		return nil, false
+	}
+	v := new(DataRef)
+	found, err := idx.root.Find(context.TODO(), k, v)
+	if err != nil || !found {
+		return nil, false
+	}
+	// The background pass will drop it from the HAMT
+	if !idx.hasContent(v) {
+		return nil, false
+	}
+	idx.load(v)
+	idx.updateMetrics()
+	return v, true
+}
+
+// forget keeps track of refs removed before the index is fully loaded so the background pass doesn't
+// bring them back, callers must hold the lock
+func (idx *Index) forget(k string) {
+	if !idx.loaded {
+		idx.removed[k] = struct{}{}
+	}
+}
+
+// loadInBackground loads the refs from the HAMT as it was when the index started and validates their
+// content is still in the stores
+func (idx *Index) loadInBackground(root *hamt.Node) {
+	defer close(idx.loadDone)
+
+	var refs []*DataRef
+	err := root.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
+		v := new(DataRef)
+		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			return err
+		}
+		refs = append(refs, v)
+		return nil
+	})
+	if err != nil {
+		indexLog.Error().Err(err).Msg("failed to load index")
+		return
+	}
+
+	var invalid []*DataRef
+	for _, v := range refs {
+		k := v.PayloadCID.String()
+		idx.mu.Lock()
+		_, ok := idx.Refs[k]
+		_, gone := idx.removed[k]
+		if !ok && !gone {
+			if idx.hasContent(v) {
+				idx.load(v)
+			} else {
+				invalid = append(invalid, v)
+			}
+		}
+		idx.mu.Unlock()
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, v := range invalid {
+		if _, err := idx.root.Delete(context.TODO(), v.PayloadCID.String()); err != nil {
+			indexLog.Warn().Err(err).Str("root", v.PayloadCID.String()).Msg("failed to drop invalid ref")
+			continue
+		}
+		idx.recordChange(v.PayloadCID, nil)
+	}
+	idx.loaded = true
+	idx.removed = nil
+	if idx.diskAccounting {
+		if err := idx.reconcile(); err != nil {
+			indexLog.Error().Err(err).Msg("failed to reconcile index")
+		}
+	} else if idx.ub > 0 && idx.lb > 0 && idx.size > idx.ub {
+		idx.evict(idx.size - idx.lb)
+	}
+	idx.updateMetrics()
+	idx.invalidateSearch()
+	if err := idx.Flush(); err != nil {
+		indexLog.Error().Err(err).Msg("failed to flush index")
+	}
+}
@@ -0,0 +1,64 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexFastStart(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	var refs []*DataRef
+	for i := 0; i < 10; i++ {
+		storeID := ms.Next()
+		store, err := ms.Get(storeID)
+		require.NoError(t, err)
+		blk := blockGen.Next()
+		require.NoError(t, store.Bstore.Put(blk))
+		ref := &DataRef{
+			PayloadCID:  blk.Cid(),
+			PayloadSize: 100,
+			StoreID:     storeID,
+		}
+		require.NoError(t, idx.SetRef(ref))
+		refs = append(refs, ref)
+	}
+	// This ref has no content in our stores
+	missing := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 100,
+		StoreID:     ms.Next(),
+	}
+	require.NoError(t, idx.SetRef(missing))
+
+	idx, err = NewIndex(ds, ms, WithFastStart())
+	require.NoError(t, err)
+
+	// Refs are available before the index is fully loaded
+	ref, err := idx.PeekRef(refs[3].PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, refs[3].StoreID, ref.StoreID)
+	require.NoError(t, idx.DropRef(refs[4].PayloadCID))
+
+	<-idx.Loaded()
+	require.Equal(t, 9, idx.Len())
+	require.Equal(t, uint64(900), idx.size)
+	_, err = idx.PeekRef(refs[4].PayloadCID)
+	require.Error(t, err)
+
+	// Refs without content are dropped by the validation
+	_, err = idx.PeekRef(missing.PayloadCID)
+	require.Error(t, err)
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 9, idx.Len())
+}
@@ -0,0 +1,254 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin/paych"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/payments"
+	"github.com/myelnet/pop/wallet"
+)
+
+//go:generate cbor-gen-for LeaseRequest LeaseResponse
+
+// LeaseProtocolID is the protocol for purchasing storage leases from providers
+const LeaseProtocolID = protocol.ID("/myel/pop/lease/1.0")
+
+// MaxLeaseDuration is the longest lease a provider will sell at once. Publishers can extend
+// a lease by purchasing a new one before it expires.
+const MaxLeaseDuration = 30 * 24 * time.Hour
+
+// ErrLeasesDisabled is returned when a provider does not sell leases
+var ErrLeasesDisabled = errors.New("leases disabled")
+
+// LeaseRequest asks a provider for a lease on a root it stores. It is first sent without payment
+// to get a quote then sent again with a voucher paying the quoted price.
+type LeaseRequest struct {
+	PayloadCID cid.Cid
+	// Duration of the lease in seconds
+	Duration       uint64
+	PaymentChannel *address.Address
+	PaymentVoucher *paych.SignedVoucher
+}
+
+// LeaseResponse quotes the price of a lease or confirms when the lease expires once paid
+type LeaseResponse struct {
+	Price          abi.TokenAmount
+	PaymentAddress *address.Address
+	// Expiry is the unix time at which the lease expires, it is 0 until the lease is paid for
+	Expiry int64
+	// Message explains why the lease was rejected if not empty
+	Message string
+}
+
+// Leases sells storage leases to publishers who want their content to be exempt from eviction
+// for a fixed duration and buys leases from other providers
+type Leases struct {
+	h   host.Host
+	idx *Index
+	pay payments.Manager
+	w   wallet.Driver
+	// price is the price per byte per started hour of lease. Leases aren't sold if nil
+	price abi.TokenAmount
+}
+
+// NewLeases creates a new lease service. A nil price disables selling leases
+func NewLeases(h host.Host, idx *Index, pay payments.Manager, w wallet.Driver, price abi.TokenAmount) *Leases {
+	l := &Leases{
+		h:     h,
+		idx:   idx,
+		pay:   pay,
+		w:     w,
+		price: price,
+	}
+	h.SetStreamHandler(LeaseProtocolID, l.handleStream)
+	return l
+}
+
+// Price returns the price of a lease for content of the given size. Every started hour is due.
+func (l *Leases) Price(size int64, d time.Duration) abi.TokenAmount {
+	hours := int64((d + time.Hour - 1) / time.Hour)
+	return big.Mul(l.price, abi.NewTokenAmount(size*hours))
+}
+
+func (l *Leases) handleStream(s network.Stream) {
+	defer s.Close()
+	buf := bufio.NewReaderSize(s, 16)
+
+	var req LeaseRequest
+	if err := req.UnmarshalCBOR(buf); err != nil {
+		return
+	}
+	root := req.PayloadCID
+	d := time.Duration(req.Duration) * time.Second
+	price, err := l.quote(root, d)
+	if err != nil {
+		_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: big.Zero(), Message: err.Error()})
+		return
+	}
+	quote := &LeaseResponse{Price: price}
+	if !price.IsZero() {
+		addr := l.w.DefaultAddress()
+		quote.PaymentAddress = &addr
+	}
+	if err := cborutil.WriteCborRPC(s, quote); err != nil {
+		return
+	}
+
+	// Wait for the publisher to pay the quoted price
+	if err := req.UnmarshalCBOR(buf); err != nil {
+		return
+	}
+	if req.PayloadCID != root || time.Duration(req.Duration)*time.Second != d {
+		_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: price, Message: "lease request changed after quote"})
+		return
+	}
+	if !price.IsZero() {
+		if req.PaymentChannel == nil || req.PaymentVoucher == nil {
+			_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: price, Message: "missing payment"})
+			return
+		}
+		if _, err := l.pay.AddVoucherInbound(context.TODO(), *req.PaymentChannel, req.PaymentVoucher, nil, price); err != nil {
+			_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: price, Message: err.Error()})
+			return
+		}
+	}
+	expiry, err := l.idx.ExtendLease(root, d)
+	if err != nil {
+		_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: price, Message: err.Error()})
+		return
+	}
+	_ = cborutil.WriteCborRPC(s, &LeaseResponse{Price: price, Expiry: expiry.Unix()})
+}
+
+// quote returns the price for leasing a root we store for a given duration
+func (l *Leases) quote(root cid.Cid, d time.Duration) (abi.TokenAmount, error) {
+	if l.price.Nil() {
+		return big.Zero(), ErrLeasesDisabled
+	}
+	if d <= 0 || d > MaxLeaseDuration {
+		return big.Zero(), fmt.Errorf("lease duration must be between 1s and %s", MaxLeaseDuration)
+	}
+	ref, err := l.idx.PeekRef(root)
+	if err != nil {
+		return big.Zero(), err
+	}
+	return l.Price(ref.PayloadSize, d), nil
+}
+
+// Lease pays a provider to keep a root for a given duration and returns when the lease expires.
+// If the root is already leased, the lease is extended by the given duration.
+func (l *Leases) Lease(ctx context.Context, p peer.ID, root cid.Cid, d time.Duration) (time.Time, error) {
+	s, err := l.h.NewStream(ctx, p, LeaseProtocolID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	buf := bufio.NewReaderSize(s, 16)
+
+	req := LeaseRequest{
+		PayloadCID: root,
+		Duration:   uint64(d / time.Second),
+	}
+	if err := cborutil.WriteCborRPC(s, &req); err != nil {
+		return time.Time{}, err
+	}
+	var quote LeaseResponse
+	if err := quote.UnmarshalCBOR(buf); err != nil {
+		return time.Time{}, err
+	}
+	if quote.Message != "" {
+		return time.Time{}, fmt.Errorf("lease rejected: %s", quote.Message)
+	}
+
+	if !quote.Price.IsZero() {
+		if quote.PaymentAddress == nil {
+			return time.Time{}, fmt.Errorf("provider did not send a payment address")
+		}
+		ch, err := l.channel(ctx, *quote.PaymentAddress, quote.Price)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+		}
+		// Each lease is paid on its own lane so the voucher amount is the price of the lease
+		lane, err := l.pay.AllocateLane(ctx, ch)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+		}
+		vres, err := l.pay.CreateVoucher(ctx, ch, quote.Price, lane)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrPaymentFailed, err)
+		}
+		if vres.Voucher == nil {
+			return time.Time{}, fmt.Errorf("%w: not enough funds in payment channel: shortfall %s", ErrPaymentFailed, vres.Shortfall)
+		}
+		req.PaymentChannel = &ch
+		req.PaymentVoucher = vres.Voucher
+	}
+	if err := cborutil.WriteCborRPC(s, &req); err != nil {
+		return time.Time{}, err
+	}
+	var res LeaseResponse
+	if err := res.UnmarshalCBOR(buf); err != nil {
+		return time.Time{}, err
+	}
+	if res.Message != "" {
+		return time.Time{}, fmt.Errorf("lease rejected: %s", res.Message)
+	}
+	return time.Unix(res.Expiry, 0), nil
+}
+
+// channel returns a payment channel to the given address with enough funds to pay the given amount
+func (l *Leases) channel(ctx context.Context, to address.Address, amt abi.TokenAmount) (address.Address, error) {
+	res, err := l.pay.GetChannel(ctx, l.w.DefaultAddress(), to, amt)
+	if err != nil {
+		return address.Undef, err
+	}
+	if res.WaitSentinel.Defined() {
+		return l.pay.WaitForChannel(ctx, res.WaitSentinel)
+	}
+	return res.Channel, nil
+}
+
+// ExtendLease exempts a root from eviction for the given duration. If the root is already under lease
+// the duration is added to the current expiry.
+func (idx *Index) ExtendLease(k cid.Cid, d time.Duration) (time.Time, error) {
+	var expiry time.Time
+	err := idx.UpdateRef(k, func(ref *DataRef) {
+		start := time.Now()
+		if current := time.Unix(ref.LeaseExpiry, 0); current.After(start) {
+			start = current
+		}
+		expiry = start.Add(d)
+		ref.LeaseExpiry = expiry.Unix()
+	})
+	return expiry, err
+}
+
+// Leased returns the refs currently under lease
+func (idx *Index) Leased() []DataRef {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	now := time.Now().Unix()
+	var refs []DataRef
+	for _, ref := range idx.Refs {
+		if ref.LeaseExpiry > now {
+			refs = append(refs, *ref)
+		}
+	}
+	return refs
+}
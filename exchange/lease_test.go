@@ -0,0 +1,115 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	pub := testutil.NewTestNode(mn, t)
+	prov := testutil.NewTestNode(mn, t)
+	other := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	idx, err := NewIndex(prov.Ds, prov.Ms)
+	require.NoError(t, err)
+	ref := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1024,
+	}
+	require.NoError(t, idx.SetRef(ref))
+
+	// Leases are free on this provider
+	NewLeases(prov.Host, idx, nil, nil, big.Zero())
+	pidx, err := NewIndex(pub.Ds, pub.Ms)
+	require.NoError(t, err)
+	client := NewLeases(pub.Host, pidx, nil, nil, abi.TokenAmount{})
+
+	expiry, err := client.Lease(ctx, prov.Host.ID(), ref.PayloadCID, time.Hour)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiry, 2*time.Second)
+
+	// Leasing again extends the current lease
+	expiry, err = client.Lease(ctx, prov.Host.ID(), ref.PayloadCID, time.Hour)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(2*time.Hour), expiry, 2*time.Second)
+
+	leased := idx.Leased()
+	require.Equal(t, 1, len(leased))
+	require.Equal(t, expiry.Unix(), leased[0].LeaseExpiry)
+
+	// Content we don't have can't be leased
+	_, err = client.Lease(ctx, prov.Host.ID(), blockGen.Next().Cid(), time.Hour)
+	require.Error(t, err)
+
+	// Leases too long are rejected
+	_, err = client.Lease(ctx, prov.Host.ID(), ref.PayloadCID, MaxLeaseDuration+time.Hour)
+	require.Error(t, err)
+
+	// Providers without a price don't sell leases
+	oidx, err := NewIndex(other.Ds, other.Ms)
+	require.NoError(t, err)
+	require.NoError(t, oidx.SetRef(ref))
+	NewLeases(other.Host, oidx, nil, nil, abi.TokenAmount{})
+	_, err = client.Lease(ctx, other.Host.ID(), ref.PayloadCID, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrLeasesDisabled.Error())
+}
+
+func TestLeaseEviction(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithBounds(512000, 500000))
+	require.NoError(t, err)
+
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+	_, err = idx.ExtendLease(ref1.PayloadCID, time.Hour)
+	require.NoError(t, err)
+
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 110000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+
+	// Adding some reads
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+
+	ref3 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 356000,
+	}
+	require.NoError(t, idx.SetRef(ref3))
+
+	// The first ref is the least frequently used but it is under lease
+	_, err = idx.PeekRef(ref1.PayloadCID)
+	require.NoError(t, err)
+
+	// So the second one was evicted instead
+	_, err = idx.PeekRef(ref2.PayloadCID)
+	require.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+)
+
+//go:generate cbor-gen-for Manifest
+
+// ErrNotEnoughSignatures is returned when a manifest is not signed by enough of its publishers
+var ErrNotEnoughSignatures = errors.New("not enough signatures")
+
+// ErrManifestNotFound is returned when we have no verified manifest for a root
+var ErrManifestNotFound = errors.New("manifest not found")
+
+// KManifests is the datastore key prefix under which verified manifests are persisted
+const KManifests = "manifests"
+
+// Manifest gates the publication of a root behind the signatures of M-of-N publishers.
+// Caches only accept a dispatch with a manifest if at least Threshold publishers signed it.
+type Manifest struct {
+	Root       cid.Cid
+	Threshold  uint64
+	Publishers []peer.ID
+	// Signatures are ordered like the publishers and empty for publishers who haven't signed yet
+	Signatures [][]byte
+}
+
+// NewManifest creates an unsigned manifest requiring threshold signatures from the given publishers
+func NewManifest(root cid.Cid, threshold uint64, publishers []peer.ID) (*Manifest, error) {
+	if threshold == 0 || threshold > uint64(len(publishers)) {
+		return nil, fmt.Errorf("invalid threshold %d for %d publishers", threshold, len(publishers))
+	}
+	seen := make(map[peer.ID]bool, len(publishers))
+	for _, p := range publishers {
+		if seen[p] {
+			return nil, fmt.Errorf("duplicate publisher %s", p)
+		}
+		seen[p] = true
+	}
+	return &Manifest{
+		Root:       root,
+		Threshold:  threshold,
+		Publishers: publishers,
+		Signatures: make([][]byte, len(publishers)),
+	}, nil
+}
+
+// SigningBytes returns the encoded manifest without the signatures
+func (m Manifest) SigningBytes() ([]byte, error) {
+	m.Signatures = nil
+	buf := new(bytes.Buffer)
+	if err := m.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign adds the signature of one of the publishers to the manifest
+func (m *Manifest) Sign(key crypto.PrivKey) error {
+	pid, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	i := m.publisherIndex(pid)
+	if i < 0 {
+		return fmt.Errorf("%s is not a publisher of %s", pid, m.Root)
+	}
+	data, err := m.SigningBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return err
+	}
+	if len(m.Signatures) != len(m.Publishers) {
+		m.Signatures = make([][]byte, len(m.Publishers))
+	}
+	m.Signatures[i] = sig
+	return nil
+}
+
+// Verify checks that at least Threshold distinct publishers signed the manifest. Public keys are
+// read from the key book if we have them or extracted from the peer IDs.
+func (m *Manifest) Verify(kb peerstore.KeyBook) error {
+	if m.Threshold == 0 || m.Threshold > uint64(len(m.Publishers)) {
+		return fmt.Errorf("invalid threshold %d for %d publishers", m.Threshold, len(m.Publishers))
+	}
+	if len(m.Signatures) != len(m.Publishers) {
+		return fmt.Errorf("%d signatures for %d publishers", len(m.Signatures), len(m.Publishers))
+	}
+	data, err := m.SigningBytes()
+	if err != nil {
+		return err
+	}
+	seen := make(map[peer.ID]bool, len(m.Publishers))
+	var n uint64
+	for i, p := range m.Publishers {
+		if seen[p] || len(m.Signatures[i]) == 0 {
+			continue
+		}
+		seen[p] = true
+		key := kb.PubKey(p)
+		if key == nil {
+			key, err = p.ExtractPublicKey()
+			if err != nil {
+				continue
+			}
+		}
+		if ok, err := key.Verify(data, m.Signatures[i]); err == nil && ok {
+			n++
+		}
+	}
+	if n < m.Threshold {
+		return fmt.Errorf("%w: %d of %d required", ErrNotEnoughSignatures, n, m.Threshold)
+	}
+	return nil
+}
+
+func (m *Manifest) publisherIndex(p peer.ID) int {
+	for i, pub := range m.Publishers {
+		if pub == p {
+			return i
+		}
+	}
+	return -1
+}
+
+func manifestKey(k cid.Cid) datastore.Key {
+	return datastore.NewKey(KManifests).ChildString(k.String())
+}
+
+// PutManifest persists a verified manifest so the content can be served as verified
+func (idx *Index) PutManifest(m *Manifest) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	idx.invalidateSearch()
+	return idx.ds.Put(manifestKey(m.Root), buf.Bytes())
+}
+
+// GetManifest returns the verified manifest for a given root
+func (idx *Index) GetManifest(k cid.Cid) (*Manifest, error) {
+	data, err := idx.ds.Get(manifestKey(k))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, ErrManifestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err := m.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Verified returns whether the content at a given root was published with a verified manifest
+func (idx *Index) Verified(k cid.Cid) bool {
+	has, err := idx.ds.Has(manifestKey(k))
+	return err == nil && has
+}
@@ -0,0 +1,106 @@
+package exchange
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	var keys []crypto.PrivKey
+	var pubs []peer.ID
+	for i := 0; i < 3; i++ {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		keys = append(keys, priv)
+		pubs = append(pubs, pid)
+	}
+	kb := pstoremem.NewKeyBook()
+	root := blockGen.Next().Cid()
+
+	_, err := NewManifest(root, 4, pubs)
+	require.Error(t, err)
+	_, err = NewManifest(root, 1, []peer.ID{pubs[0], pubs[0]})
+	require.Error(t, err)
+
+	m, err := NewManifest(root, 2, pubs)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Sign(keys[0]))
+	require.True(t, errors.Is(m.Verify(kb), ErrNotEnoughSignatures))
+
+	// Signing twice with the same key doesn't count twice
+	require.NoError(t, m.Sign(keys[0]))
+	require.True(t, errors.Is(m.Verify(kb), ErrNotEnoughSignatures))
+
+	// Only publishers can sign
+	outsider, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	require.Error(t, m.Sign(outsider))
+
+	require.NoError(t, m.Sign(keys[2]))
+	require.NoError(t, m.Verify(kb))
+
+	// The manifest survives encoding
+	buf := new(bytes.Buffer)
+	require.NoError(t, m.MarshalCBOR(buf))
+	var dec Manifest
+	require.NoError(t, dec.UnmarshalCBOR(buf))
+	require.NoError(t, dec.Verify(kb))
+	require.Equal(t, 0, len(dec.Signatures[1]))
+
+	// Changing the root invalidates the signatures
+	dec.Root = blockGen.Next().Cid()
+	require.True(t, errors.Is(dec.Verify(kb), ErrNotEnoughSignatures))
+}
+
+func TestIndexManifest(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	ref := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1024,
+		StoreID:     ms.Next(),
+	}
+	_, err = ms.Get(ref.StoreID)
+	require.NoError(t, err)
+	require.NoError(t, idx.SetRef(ref))
+	require.False(t, idx.Verified(ref.PayloadCID))
+	_, err = idx.GetManifest(ref.PayloadCID)
+	require.True(t, errors.Is(err, ErrManifestNotFound))
+
+	m, err := NewManifest(ref.PayloadCID, 1, []peer.ID{pid})
+	require.NoError(t, err)
+	require.NoError(t, m.Sign(priv))
+	require.NoError(t, idx.PutManifest(m))
+
+	require.True(t, idx.Verified(ref.PayloadCID))
+	got, err := idx.GetManifest(ref.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, m.Publishers, got.Publishers)
+
+	// Dropping the content drops the manifest
+	require.NoError(t, idx.DropRef(ref.PayloadCID))
+	require.False(t, idx.Verified(ref.PayloadCID))
+}
@@ -0,0 +1,118 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// HostingSource is how content we may host was brought to our attention
+type HostingSource int
+
+const (
+	// Dispatched content is pushed to us by its publisher or a relay
+	Dispatched HostingSource = iota
+	// Announced content is listed by other providers and was requested by our clients
+	Announced
+)
+
+func (s HostingSource) String() string {
+	switch s {
+	case Dispatched:
+		return "dispatched"
+	case Announced:
+		return "announced"
+	}
+	return fmt.Sprintf("unknown source %d", int(s))
+}
+
+// HostingRequest describes content a node may volunteer to cache
+type HostingRequest struct {
+	Root   cid.Cid
+	Size   uint64
+	Source HostingSource
+	// Peer is the peer dispatching the content, it is empty for announced content
+	Peer peer.ID
+	// Publisher is the peer who first dispatched the content if known
+	Publisher peer.ID
+	// Freq is the number of times our clients requested announced content
+	Freq int64
+}
+
+// Bid is the decision of a market to host content
+type Bid struct {
+	Accept bool
+	// Revenue is the expected revenue of hosting the content if the market estimates it
+	Revenue abi.TokenAmount
+	// Reason explains why the content is declined
+	Reason string
+}
+
+// HostingMarket bids on hosting the content dispatched to us or announced by other providers. It lets
+// external marketplace logic decide which content is worth caching while the exchange handles the
+// transfers. Bids are asked every time the interest list is replicated so they should be cheap or cached.
+type HostingMarket interface {
+	Bid(ctx context.Context, req HostingRequest) (Bid, error)
+}
+
+// HostAll is the default market which accepts all the content we have room for
+type HostAll struct{}
+
+// Bid accepts any content
+func (HostAll) Bid(context.Context, HostingRequest) (Bid, error) {
+	return Bid{Accept: true}, nil
+}
+
+// HostNone declines all the content. It is used by exchanges which only publish content.
+type HostNone struct{}
+
+// Bid declines any content
+func (HostNone) Bid(context.Context, HostingRequest) (Bid, error) {
+	return Bid{Reason: "not hosting content"}, nil
+}
+
+// RevenueMarket hosts content if the revenue we expect from serving it is high enough. Dispatched content
+// is expected to be retrieved at least once.
+type RevenueMarket struct {
+	// PricePerByte is what we charge for retrievals
+	PricePerByte abi.TokenAmount
+	// MinRevenue is the revenue below which content isn't worth hosting
+	MinRevenue abi.TokenAmount
+}
+
+// Bid estimates the revenue as the price of retrieving the content as many times as it was requested
+func (m RevenueMarket) Bid(_ context.Context, req HostingRequest) (Bid, error) {
+	retrievals := req.Freq
+	if retrievals < 1 {
+		retrievals = 1
+	}
+	price := m.PricePerByte
+	if price.Nil() {
+		price = big.Zero()
+	}
+	revenue := big.Mul(price, big.NewInt(int64(req.Size)*retrievals))
+	if m.MinRevenue.Nil() || revenue.GreaterThanEqual(m.MinRevenue) {
+		return Bid{Accept: true, Revenue: revenue}, nil
+	}
+	return Bid{
+		Revenue: revenue,
+		Reason:  fmt.Sprintf("expected revenue %s is below %s", revenue, m.MinRevenue),
+	}, nil
+}
+
+// bid asks a market if content should be hosted. Content is declined if the market fails.
+func bid(ctx context.Context, m HostingMarket, req HostingRequest) bool {
+	if m == nil {
+		return true
+	}
+	b, err := m.Bid(ctx, req)
+	if err != nil {
+		txLog.Warn().Err(err).Str("source", req.Source.String()).Str("root", req.Root.String()).Msg("failed to bid")
+		return false
+	}
+	return b.Accept
+}
@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevenueMarket(t *testing.T) {
+	ctx := context.Background()
+	m := RevenueMarket{
+		PricePerByte: big.NewInt(2),
+		MinRevenue:   big.NewInt(10000),
+	}
+	root := blockGen.Next().Cid()
+
+	// Dispatched content is expected to be retrieved once
+	b, err := m.Bid(ctx, HostingRequest{Root: root, Size: 1000, Source: Dispatched})
+	require.NoError(t, err)
+	require.False(t, b.Accept)
+	require.Equal(t, big.NewInt(2000), b.Revenue)
+	require.NotEmpty(t, b.Reason)
+
+	b, err = m.Bid(ctx, HostingRequest{Root: root, Size: 1000, Source: Announced, Freq: 5})
+	require.NoError(t, err)
+	require.True(t, b.Accept)
+	require.Equal(t, big.NewInt(10000), b.Revenue)
+
+	b, err = HostAll{}.Bid(ctx, HostingRequest{Root: root})
+	require.NoError(t, err)
+	require.True(t, b.Accept)
+}
+
+// sizeMarket only hosts content smaller than a size
+type sizeMarket uint64
+
+func (m sizeMarket) Bid(_ context.Context, req HostingRequest) (Bid, error) {
+	return Bid{Accept: req.Size < uint64(m)}, nil
+}
+
+func TestAutoReplicationMarket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 8000))
+	require.NoError(t, err)
+
+	rtv := blockingRetriever{
+		idx:     idx,
+		sizes:   make(map[cid.Cid]int64),
+		started: make(chan cid.Cid, 2),
+		release: make(chan struct{}),
+	}
+	// The most popular ref isn't worth hosting for the market
+	var refs []*DataRef
+	for i, size := range []int64{1000, 5000} {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: size,
+			Freq:        int64(i + 1),
+		}
+		rtv.sizes[ref.PayloadCID] = size
+		refs = append(refs, ref)
+		idx.imu.Lock()
+		idx.addInterest(ref.PayloadCID.String(), ref)
+		idx.imu.Unlock()
+	}
+
+	ar := NewAutoReplicator(idx, rtv, 0, 2)
+	ar.market = sizeMarket(2000)
+	go ar.Run(ctx)
+	ar.Trigger()
+
+	require.Equal(t, refs[0].PayloadCID, <-rtv.started)
+	select {
+	case c := <-rtv.started:
+		t.Fatalf("retrieved %s declined by the market", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(rtv.release)
+}
@@ -0,0 +1,87 @@
+package exchange
+
+// MemoryFootprint describes the metadata the index keeps in memory
+type MemoryFootprint struct {
+	// Refs is the number of refs loaded in memory
+	Refs int
+	// Buckets is the length of the LFU bucket list
+	Buckets int
+	// Interest is the number of refs in the interest list
+	Interest int
+	// MaxRefs and MaxBuckets are the bounds after which refs are evicted, 0 is unbounded
+	MaxRefs    int
+	MaxBuckets int
+}
+
+// WithMemoryBounds evicts the least frequently used refs once the index holds more than maxRefs refs or
+// its LFU bucket list is longer than maxBuckets, regardless of the size of the content. It lets nodes with
+// little RAM but a large disk cap their in-memory metadata independently of the capacity.
+// 0 leaves a bound unset.
+func WithMemoryBounds(maxRefs, maxBuckets int) IndexOption {
+	return func(idx *Index) {
+		idx.maxRefs = maxRefs
+		idx.maxBuckets = maxBuckets
+	}
+}
+
+// MemoryFootprint returns the amount of metadata the index keeps in memory
+func (idx *Index) MemoryFootprint() MemoryFootprint {
+	idx.mu.Lock()
+	refs := len(idx.Refs)
+	buckets := idx.blist.Len()
+	idx.mu.Unlock()
+	return MemoryFootprint{
+		Refs:       refs,
+		Buckets:    buckets,
+		Interest:   idx.InterestLen(),
+		MaxRefs:    idx.maxRefs,
+		MaxBuckets: idx.maxBuckets,
+	}
+}
+
+// lowMark leaves a 20% margin under a memory bound so we don't evict after every write once we reach it
+func lowMark(max int) int {
+	return max - max/5
+}
+
+// overMemory returns how many refs are above the given bounds and whether the bucket list is longer than
+// allowed, callers must hold the lock
+func (idx *Index) overMemory(maxRefs, maxBuckets int) (int, bool) {
+	over := 0
+	if maxRefs > 0 && len(idx.Refs) > maxRefs {
+		over = len(idx.Refs) - maxRefs
+	}
+	return over, maxBuckets > 0 && idx.blist.Len() > maxBuckets
+}
+
+// enforceMemory evicts the least frequently used refs until the index is back under the low marks of its
+// memory bounds, callers must hold the lock
+func (idx *Index) enforceMemory() {
+	if refs, buckets := idx.overMemory(idx.maxRefs, idx.maxBuckets); refs == 0 && !buckets {
+		return
+	}
+	maxRefs, maxBuckets := lowMark(idx.maxRefs), lowMark(idx.maxBuckets)
+	pressure := func() EvictionPressure {
+		refs, _ := idx.overMemory(maxRefs, maxBuckets)
+		return EvictionPressure{
+			Size:       idx.size,
+			UpperBound: idx.ub,
+			Refs:       refs,
+		}
+	}
+	done := func(*DataRef) bool {
+		refs, buckets := idx.overMemory(maxRefs, maxBuckets)
+		return refs == 0 && !buckets
+	}
+	// Content replaced by a new version goes first
+	idx.evictLFU(func(ref *DataRef) bool {
+		_, ok := idx.superseded[ref.PayloadCID.String()]
+		return ok
+	}, pressure, done)
+	if done(nil) {
+		return
+	}
+	idx.evictLFU(func(*DataRef) bool {
+		return true
+	}, pressure, done)
+}
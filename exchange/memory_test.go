@@ -0,0 +1,109 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexMaxRefs(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	var pressure []int
+	idx, err := NewIndex(ds, ms,
+		// The byte bounds are never reached
+		WithBounds(512000, 500000),
+		WithMemoryBounds(5, 0),
+		WithEvictionVeto(func(ref DataRef, p EvictionPressure) bool {
+			pressure = append(pressure, p.Refs)
+			return true
+		}),
+	)
+	require.NoError(t, err)
+
+	refs := make([]*DataRef, 5)
+	for i := range refs {
+		refs[i] = &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 10,
+		}
+		require.NoError(t, idx.SetRef(refs[i]))
+	}
+	// Every ref but the first one gets more popular than the previous one
+	for i, ref := range refs {
+		for j := 0; j < i; j++ {
+			_, err := idx.GetRef(ref.PayloadCID)
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, 5, idx.Len())
+
+	ref6 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 10,
+	}
+	require.NoError(t, idx.SetRef(ref6))
+
+	// We evict down to the low mark of 4 refs
+	require.Equal(t, 4, idx.Len())
+	require.Equal(t, []int{2, 1}, pressure)
+	for _, ref := range refs[:2] {
+		_, err := idx.PeekRef(ref.PayloadCID)
+		require.Error(t, err)
+	}
+	for _, ref := range append(refs[2:], ref6) {
+		_, err := idx.PeekRef(ref.PayloadCID)
+		require.NoError(t, err)
+	}
+
+	// Evicted refs don't come back after a restart
+	idx, err = NewIndex(ds, ms, WithMemoryBounds(5, 0))
+	require.NoError(t, err)
+	require.Equal(t, 4, idx.Len())
+}
+
+func TestIndexMaxBuckets(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithMemoryBounds(0, 3))
+	require.NoError(t, err)
+
+	refs := make([]*DataRef, 4)
+	for i := range refs {
+		refs[i] = &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: 10,
+		}
+		require.NoError(t, idx.SetRef(refs[i]))
+	}
+	// Each ref ends up in its own bucket
+	for i, ref := range refs {
+		for j := 0; j < i; j++ {
+			_, err := idx.GetRef(ref.PayloadCID)
+			require.NoError(t, err)
+		}
+	}
+	fp := idx.MemoryFootprint()
+	require.Equal(t, 4, fp.Buckets)
+	require.Equal(t, 4, fp.Refs)
+	require.Equal(t, 3, fp.MaxBuckets)
+
+	// The bounds are enforced when content is added
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 10,
+	}))
+
+	fp = idx.MemoryFootprint()
+	require.Equal(t, 3, fp.Buckets)
+	require.Equal(t, 4, fp.Refs)
+	_, err = idx.PeekRef(refs[0].PayloadCID)
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultNameCacheTTL is how long a resolved name is reused before resolving it again
+const DefaultNameCacheTTL = time.Minute
+
+// maxCachedNames bounds the number of names kept by a name cache
+const maxCachedNames = 1024
+
+type cachedName struct {
+	root    cid.Cid
+	expires time.Time
+}
+
+// NameCache caches the roots resolved by a name resolver so paths starting with a domain don't cost a
+// DNS query for every retrieval. Failed resolutions are not cached.
+type NameCache struct {
+	res NameResolver
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	names map[string]cachedName
+}
+
+// NewNameCache wraps a resolver with a cache keeping its results for the given duration
+func NewNameCache(res NameResolver, ttl time.Duration) *NameCache {
+	return &NameCache{
+		res:   res,
+		ttl:   ttl,
+		now:   time.Now,
+		names: make(map[string]cachedName),
+	}
+}
+
+// Resolve returns the cached root of a name if it didn't expire or resolves it again
+func (c *NameCache) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	c.mu.Lock()
+	cn, ok := c.names[name]
+	c.mu.Unlock()
+	if ok && c.now().Before(cn.expires) {
+		return cn.root, nil
+	}
+	root, err := c.res.Resolve(ctx, name)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.names) >= maxCachedNames {
+		for n, cn := range c.names {
+			if !now.Before(cn.expires) {
+				delete(c.names, n)
+			}
+		}
+	}
+	if len(c.names) < maxCachedNames {
+		c.names[name] = cachedName{root: root, expires: now.Add(c.ttl)}
+	}
+	return root, nil
+}
+
+// Invalidate drops a name from the cache so it is resolved on the next lookup
+func (c *NameCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.names, name)
+}
+
+// isDomain returns whether the first segment of a path looks like a domain name rather than a CID
+func isDomain(s string) bool {
+	if !strings.Contains(s, ".") || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") {
+		return false
+	}
+	_, err := cid.Decode(s)
+	return err != nil
+}
+
+// resolveDomainPath replaces a leading domain in a path formatted as <domain>/<key> with the root its
+// DNSLink record points to
+func resolveDomainPath(ctx context.Context, res NameResolver, p string) (string, error) {
+	trimmed := strings.TrimPrefix(p, "/")
+	if !isDomain(strings.SplitN(trimmed, "/", 2)[0]) {
+		return p, nil
+	}
+	return resolveNamedPath(ctx, res, "/ipns/"+trimmed)
+}
@@ -0,0 +1,79 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameCache(t *testing.T) {
+	ctx := context.Background()
+	root := blockGen.Next().Cid()
+	lookups := 0
+	res := DNSLinkResolver{
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			lookups++
+			if name != "_dnslink.myel.network" {
+				return nil, fmt.Errorf("no such host")
+			}
+			return []string{fmt.Sprintf("dnslink=/ipfs/%s", root)}, nil
+		},
+	}
+	now := time.Now()
+	c := NewNameCache(res, time.Minute)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		resolved, err := c.Resolve(ctx, "/ipns/myel.network")
+		require.NoError(t, err)
+		require.Equal(t, root, resolved)
+	}
+	require.Equal(t, 1, lookups)
+
+	// Expired names are resolved again
+	now = now.Add(2 * time.Minute)
+	_, err := c.Resolve(ctx, "/ipns/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, 2, lookups)
+
+	c.Invalidate("/ipns/myel.network")
+	_, err = c.Resolve(ctx, "/ipns/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, 3, lookups)
+
+	// Failures aren't cached
+	_, err = c.Resolve(ctx, "/ipns/unknown.network")
+	require.Error(t, err)
+	_, err = c.Resolve(ctx, "/ipns/unknown.network")
+	require.Error(t, err)
+	require.Equal(t, 7, lookups)
+}
+
+func TestResolveDomainPath(t *testing.T) {
+	ctx := context.Background()
+	root := blockGen.Next().Cid()
+	res := &mockResolver{roots: map[string]cid.Cid{"/ipns/myel.network": root}}
+
+	p, err := resolveDomainPath(ctx, res, "myel.network/data.txt")
+	require.NoError(t, err)
+	require.Equal(t, "/"+root.String()+"/data.txt", p)
+
+	p, err = resolveDomainPath(ctx, res, "/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, "/"+root.String(), p)
+
+	// CIDs, tags and /ipfs/ paths are left untouched
+	for _, s := range []string{root.String() + "/data.txt", "mytag/data.txt", "/ipfs/" + root.String()} {
+		p, err = resolveDomainPath(ctx, res, s)
+		require.NoError(t, err)
+		require.Equal(t, s, p)
+	}
+
+	_, err = resolveDomainPath(ctx, res, "unknown.network/data.txt")
+	require.True(t, errors.Is(err, ErrUnsupportedName))
+}
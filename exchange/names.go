@@ -0,0 +1,356 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+//go:generate cbor-gen-for NameBinding
+
+// KNames is the datastore key prefix of the mutable names tracked by the revalidator
+const KNames = "names"
+
+// KSuperseded is the datastore key prefix of the roots replaced by a new version
+const KSuperseded = "superseded"
+
+// DefaultRevalidateInterval is how often tracked names are resolved again
+const DefaultRevalidateInterval = 10 * time.Minute
+
+// ErrUnsupportedName is returned when no resolver can resolve a name
+var ErrUnsupportedName = errors.New("unsupported name")
+
+// NameResolver resolves a mutable name to the root it currently points to
+type NameResolver interface {
+	Resolve(ctx context.Context, name string) (cid.Cid, error)
+}
+
+// DNSLinkResolver resolves /ipns/<domain> names from the DNSLink TXT record of the domain.
+// IPNS keys aren't supported.
+type DNSLinkResolver struct {
+	// LookupTXT defaults to the system resolver or a resolver querying Servers if set
+	LookupTXT func(ctx context.Context, name string) ([]string, error)
+	// Servers are the DNS servers formatted as host:port queried instead of the system ones
+	Servers []string
+}
+
+// Resolve looks up the dnslink record under _dnslink.<domain> then under the domain itself
+func (r DNSLinkResolver) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	domain := strings.TrimSuffix(strings.TrimPrefix(name, "/ipns/"), "/")
+	if !strings.Contains(domain, ".") {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrUnsupportedName, name)
+	}
+	lookup := r.LookupTXT
+	if lookup == nil {
+		lookup = dnsResolver(r.Servers).LookupTXT
+	}
+	var lastErr error
+	for _, host := range []string{"_dnslink." + domain, domain} {
+		txts, err := lookup(ctx, host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, txt := range txts {
+			if root, ok := parseDNSLink(txt); ok {
+				return root, nil
+			}
+		}
+	}
+	if lastErr != nil {
+		return cid.Undef, lastErr
+	}
+	return cid.Undef, fmt.Errorf("no dnslink record for %s", domain)
+}
+
+// dnsResolver returns a resolver querying the given servers in order or the system resolver if empty
+func dnsResolver(servers []string) *net.Resolver {
+	if len(servers) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			var err error
+			for _, s := range servers {
+				var conn net.Conn
+				conn, err = d.DialContext(ctx, network, s)
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+	}
+}
+
+// parseDNSLink reads the root of a dnslink=/ipfs/<cid>/<path> record
+func parseDNSLink(txt string) (cid.Cid, bool) {
+	if !strings.HasPrefix(txt, "dnslink=/ipfs/") {
+		return cid.Undef, false
+	}
+	segs := strings.SplitN(strings.TrimPrefix(txt, "dnslink=/ipfs/"), "/", 2)
+	root, err := cid.Decode(segs[0])
+	if err != nil {
+		return cid.Undef, false
+	}
+	return root, true
+}
+
+// NameBinding is a mutable name and the root it pointed to the last time it was resolved
+type NameBinding struct {
+	Name string
+	Root cid.Cid
+	// Checked is the unix time at which the name was last resolved
+	Checked int64
+}
+
+// Revalidator periodically resolves the mutable names content was published under. When a name points
+// to a new root, the previous root is marked as superseded so it is evicted first and the new root takes
+// its place in the interest list or is prefetched if we were caching the previous one.
+type Revalidator struct {
+	ds       datastore.Batching
+	idx      *Index
+	res      NameResolver
+	rtv      RoutedRetriever
+	interval time.Duration
+
+	mu    sync.Mutex
+	names map[string]*NameBinding
+}
+
+// NewRevalidator loads the names tracked before restarting
+func NewRevalidator(ds datastore.Batching, idx *Index, res NameResolver, rtv RoutedRetriever) (*Revalidator, error) {
+	rv := &Revalidator{
+		ds:       namespace.Wrap(ds, datastore.NewKey(KNames)),
+		idx:      idx,
+		res:      res,
+		rtv:      rtv,
+		interval: DefaultRevalidateInterval,
+		names:    make(map[string]*NameBinding),
+	}
+	results, err := rv.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		b := new(NameBinding)
+		if err := b.UnmarshalCBOR(bytes.NewReader(e.Value)); err != nil {
+			return nil, err
+		}
+		rv.names[b.Name] = b
+	}
+	return rv, nil
+}
+
+// Start revalidating the tracked names at every interval
+func (rv *Revalidator) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(rv.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rv.Revalidate(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Track resolves a name and keeps checking if it points to a new root
+func (rv *Revalidator) Track(ctx context.Context, name string) (cid.Cid, error) {
+	root, err := rv.res.Resolve(ctx, name)
+	if err != nil {
+		return cid.Undef, err
+	}
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	prev, ok := rv.names[name]
+	if ok && prev.Root != root {
+		rv.supersede(ctx, prev.Root, root)
+	}
+	return root, rv.put(&NameBinding{
+		Name:    name,
+		Root:    root,
+		Checked: time.Now().Unix(),
+	})
+}
+
+// Untrack stops checking a name
+func (rv *Revalidator) Untrack(name string) error {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	delete(rv.names, name)
+	return rv.ds.Delete(datastore.NewKey(name))
+}
+
+// Bindings returns the tracked names sorted by name
+func (rv *Revalidator) Bindings() []NameBinding {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	bindings := make([]NameBinding, 0, len(rv.names))
+	for _, b := range rv.names {
+		bindings = append(bindings, *b)
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Name < bindings[j].Name
+	})
+	return bindings
+}
+
+// Revalidate resolves every tracked name and applies the changes of roots
+func (rv *Revalidator) Revalidate(ctx context.Context) {
+	for _, b := range rv.Bindings() {
+		root, err := rv.res.Resolve(ctx, b.Name)
+		if err != nil {
+			indexLog.Warn().Err(err).Str("name", b.Name).Msg("failed to revalidate")
+			continue
+		}
+		rv.mu.Lock()
+		// The name may have been untracked or updated while resolving
+		if cur, ok := rv.names[b.Name]; ok && cur.Root == b.Root {
+			if root != b.Root {
+				rv.supersede(ctx, b.Root, root)
+			}
+			if err := rv.put(&NameBinding{
+				Name:    b.Name,
+				Root:    root,
+				Checked: time.Now().Unix(),
+			}); err != nil {
+				indexLog.Error().Err(err).Str("name", b.Name).Msg("failed to persist name")
+			}
+		}
+		rv.mu.Unlock()
+	}
+}
+
+// put persists a binding, callers must hold the lock
+func (rv *Revalidator) put(b *NameBinding) error {
+	buf := new(bytes.Buffer)
+	if err := b.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	if err := rv.ds.Put(datastore.NewKey(b.Name), buf.Bytes()); err != nil {
+		return err
+	}
+	rv.names[b.Name] = b
+	return nil
+}
+
+// supersede replaces the previous root of a name with the new one. If we cache the previous root
+// the new one is prefetched so we keep serving the name, else it takes its place in the interest list.
+func (rv *Revalidator) supersede(ctx context.Context, prev, root cid.Cid) {
+	ref, err := rv.idx.PeekRef(prev)
+	if err != nil {
+		rv.idx.replaceInterest(prev, root)
+		return
+	}
+	if err := rv.idx.MarkSuperseded(prev); err != nil {
+		indexLog.Error().Err(err).Str("root", prev.String()).Msg("failed to mark superseded root")
+	}
+	// The new root is retrieved when the index is refreshed if the prefetch fails
+	rv.idx.AddInterest([]DataRef{{
+		PayloadCID:  root,
+		PayloadSize: ref.PayloadSize,
+		Freq:        ref.Freq,
+	}})
+	if rv.rtv == nil {
+		return
+	}
+	go func() {
+		if err := rv.rtv.FindAndRetrieve(ctx, root); err != nil {
+			txLog.Warn().Err(err).Str("root", root.String()).Msg("failed to prefetch new root")
+			return
+		}
+		// We don't need to retrieve it again
+		_ = rv.idx.DropInterest(root)
+	}()
+}
+
+func supersededKey(k cid.Cid) datastore.Key {
+	return datastore.NewKey(KSuperseded).ChildString(k.String())
+}
+
+// MarkSuperseded marks a root replaced by a new version so it is evicted before any other content
+func (idx *Index) MarkSuperseded(k cid.Cid) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.lookup(k.String()); !ok {
+		return ErrRefNotFound
+	}
+	if err := idx.ds.Put(supersededKey(k), []byte{1}); err != nil {
+		return err
+	}
+	idx.superseded[k.String()] = struct{}{}
+	return nil
+}
+
+// Superseded returns whether a root was replaced by a new version
+func (idx *Index) Superseded(k cid.Cid) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	_, ok := idx.superseded[k.String()]
+	return ok
+}
+
+// loadSuperseded reads the superseded roots persisted in the datastore
+func (idx *Index) loadSuperseded() error {
+	res, err := idx.ds.Query(dsq.Query{Prefix: "/" + KSuperseded, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		idx.superseded[datastore.NewKey(e.Key).BaseNamespace()] = struct{}{}
+	}
+	return nil
+}
+
+// unmarkSuperseded forgets a root once it is removed from the index, callers must hold the lock
+func (idx *Index) unmarkSuperseded(k cid.Cid) {
+	if _, ok := idx.superseded[k.String()]; !ok {
+		return
+	}
+	delete(idx.superseded, k.String())
+	_ = idx.ds.Delete(supersededKey(k))
+}
+
+// replaceInterest moves the frequency of a root in the interest list to its new version
+func (idx *Index) replaceInterest(prev, root cid.Cid) bool {
+	idx.imu.Lock()
+	ref, ok := idx.interest[prev.String()]
+	idx.imu.Unlock()
+	if !ok {
+		return false
+	}
+	if err := idx.DropInterest(prev); err != nil {
+		return false
+	}
+	idx.AddInterest([]DataRef{{
+		PayloadCID:  root,
+		PayloadSize: ref.PayloadSize,
+		Freq:        ref.Freq,
+	}})
+	return true
+}
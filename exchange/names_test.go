@@ -0,0 +1,166 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+type mockResolver struct {
+	mu    sync.Mutex
+	roots map[string]cid.Cid
+}
+
+func (r *mockResolver) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	root, ok := r.roots[name]
+	if !ok {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrUnsupportedName, name)
+	}
+	return root, nil
+}
+
+func (r *mockResolver) set(name string, root cid.Cid) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roots[name] = root
+}
+
+func TestDNSLinkResolver(t *testing.T) {
+	ctx := context.Background()
+	root := blockGen.Next().Cid()
+	res := DNSLinkResolver{
+		LookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			if name != "_dnslink.myel.network" {
+				return nil, fmt.Errorf("no such host")
+			}
+			return []string{"v=spf1", fmt.Sprintf("dnslink=/ipfs/%s/index.html", root)}, nil
+		},
+	}
+	resolved, err := res.Resolve(ctx, "/ipns/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, root, resolved)
+
+	_, err = res.Resolve(ctx, "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8")
+	require.ErrorIs(t, err, ErrUnsupportedName)
+
+	_, ok := parseDNSLink("dnslink=/ipns/myel.network")
+	require.False(t, ok)
+}
+
+func TestRevalidator(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 9001))
+	require.NoError(t, err)
+
+	newRef := func(size int64) *DataRef {
+		ref := &DataRef{
+			PayloadCID:  blockGen.Next().Cid(),
+			PayloadSize: size,
+		}
+		require.NoError(t, idx.SetRef(ref))
+		return ref
+	}
+	site := newRef(3000)
+	other1 := newRef(3000)
+	other2 := newRef(3000)
+	// The site is the most used content
+	for i := 0; i < 3; i++ {
+		_, err = idx.GetRef(site.PayloadCID)
+		require.NoError(t, err)
+	}
+
+	res := &mockResolver{roots: map[string]cid.Cid{
+		"/ipns/myel.network": site.PayloadCID,
+	}}
+	rv, err := NewRevalidator(ds, idx, res, nil)
+	require.NoError(t, err)
+
+	root, err := rv.Track(ctx, "/ipns/myel.network")
+	require.NoError(t, err)
+	require.Equal(t, site.PayloadCID, root)
+
+	// Nothing changes until the name points to a new root
+	rv.Revalidate(ctx)
+	require.False(t, idx.Superseded(site.PayloadCID))
+
+	update := blockGen.Next().Cid()
+	res.set("/ipns/myel.network", update)
+	rv.Revalidate(ctx)
+	require.True(t, idx.Superseded(site.PayloadCID))
+	idx.imu.Lock()
+	_, ok := idx.interest[update.String()]
+	idx.imu.Unlock()
+	require.True(t, ok)
+
+	// Bindings are kept across restarts
+	rv, err = NewRevalidator(ds, idx, res, nil)
+	require.NoError(t, err)
+	bindings := rv.Bindings()
+	require.Len(t, bindings, 1)
+	require.Equal(t, update, bindings[0].Root)
+
+	// Superseded roots are kept across restarts
+	idx, err = NewIndex(ds, ms, WithBounds(10000, 9001))
+	require.NoError(t, err)
+	require.True(t, idx.Superseded(site.PayloadCID))
+
+	// The superseded root is evicted first even if it is used more
+	newRef(2000)
+	_, err = idx.PeekRef(site.PayloadCID)
+	require.Error(t, err)
+	require.False(t, idx.Superseded(site.PayloadCID))
+	_, err = idx.PeekRef(other1.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.PeekRef(other2.PayloadCID)
+	require.NoError(t, err)
+
+	require.NoError(t, rv.Untrack("/ipns/myel.network"))
+	rv, err = NewRevalidator(ds, idx, res, nil)
+	require.NoError(t, err)
+	require.Len(t, rv.Bindings(), 0)
+}
+
+func TestRevalidatorInterest(t *testing.T) {
+	ctx := context.Background()
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms, WithBounds(10000, 9001))
+	require.NoError(t, err)
+
+	// We only know about the previous root from the interest list
+	prev := blockGen.Next().Cid()
+	idx.AddInterest([]DataRef{{PayloadCID: prev, PayloadSize: 1000, Freq: 4}})
+
+	res := &mockResolver{roots: map[string]cid.Cid{"/ipns/myel.network": prev}}
+	rv, err := NewRevalidator(ds, idx, res, nil)
+	require.NoError(t, err)
+	_, err = rv.Track(ctx, "/ipns/myel.network")
+	require.NoError(t, err)
+
+	update := blockGen.Next().Cid()
+	res.set("/ipns/myel.network", update)
+	rv.Revalidate(ctx)
+
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	_, ok := idx.interest[prev.String()]
+	require.False(t, ok)
+	ref, ok := idx.interest[update.String()]
+	require.True(t, ok)
+	require.Equal(t, int64(4), ref.Freq)
+}
@@ -2,17 +2,20 @@ package exchange
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"time"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/filecoin-project/go-data-transfer/channelmonitor"
 	dtfimpl "github.com/filecoin-project/go-data-transfer/impl"
 	dtnet "github.com/filecoin-project/go-data-transfer/network"
 	gstransport "github.com/filecoin-project/go-data-transfer/transport/graphsync"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
 	"github.com/ipfs/go-graphsync"
@@ -22,8 +25,11 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/metrics"
 )
 
 // RequestTopic listens for peers looking for content blocks
@@ -40,8 +46,8 @@ type Options struct {
 	MultiStore *multistore.MultiStore
 	// PubSub allows passing a different pubsub instance with alternative routing algorithms. Default is Gossip.
 	PubSub *pubsub.PubSub
-	// GraphSync is used as Transport for DataTransfer, if you're providing a DataTransfer manager instance
-	//  you don't need to set it.
+	// GraphSync is used as Transport for DataTransfer. It is required with a DataTransfer manager instance
+	// as the content it serves is counted as remote reads in the index.
 	GraphSync graphsync.GraphExchange
 	// DataTransfer is a single manager instance used across every retrieval operation.
 	DataTransfer datatransfer.Manager
@@ -61,6 +67,23 @@ type Options struct {
 	GossipTracer *GossipTracer
 	// Regions is the geographic region this exchange should serve. Defaults to Global only.
 	Regions []Region
+	// TopicShards spreads the queries of each region over this number of gossip topics by the CID prefix of
+	// their root so providers only receive the queries for the shards of the content they store. Every
+	// node of a region must use the same number of shards. Default is 0, a single topic per region.
+	TopicShards int
+	// PrivateQueries publishes a salted hash of the root instead of the root in the gossip queries so passive
+	// listeners can't read what we retrieve. Providers running a version without private queries don't
+	// answer them. Every exchange answers private queries.
+	PrivateQueries bool
+	// SaltPeriod is how long private queries are salted with the same salt. Default is 1 hour.
+	SaltPeriod time.Duration
+	// Bootstrap are the pops we connect to when starting and learn other pop peers from. We bootstrap again
+	// whenever we know less than MinPeers pop peers.
+	Bootstrap []peer.AddrInfo
+	// MinPeers is the number of pop peers in our regions below which we bootstrap again. Default is 4.
+	MinPeers int
+	// BootstrapInterval is how often we check if we know enough pop peers. Default is 1 minute.
+	BootstrapInterval time.Duration
 	// Capacity is the maximum storage capacity in bytes this exchange can handle. Once we capacity is reached,
 	// least frequently used content is evicted to make more room for new content.
 	// Default is 10GB.
@@ -69,10 +92,184 @@ type Options struct {
 	// RepInterval is the replication interval after which a worker will try to retrieve fresh new content
 	// on the network
 	RepInterval time.Duration
+	// PublisherShare is the fraction of the capacity the content of a single publisher can use before its
+	// least used content is evicted. Default is 0 which doesn't cap publishers.
+	PublisherShare float64
+	// CategoryQuotas partitions the capacity between categories of content so each category evicts its own
+	// content once over its fraction e.g. video=0.6 and software=0.3. Content without a listed category shares
+	// the rest unless an "other" quota is set. Default doesn't partition the capacity.
+	CategoryQuotas map[string]float64
+	// CategoryLabel is the label of a ref holding its category. Default is "category".
+	CategoryLabel string
+	// ReplicationBandwidth is the average number of bytes per second the auto replication may retrieve
+	// from the network. Default is unlimited.
+	ReplicationBandwidth uint64
+	// MaxReplications is the maximum number of retrievals the auto replication runs at the same time.
+	// Default is 2.
+	MaxReplications int
+	// EvictionVeto is called before evicting content from the cache, returning false keeps the content.
+	EvictionVeto EvictionVeto
+	// IndexFlushInterval persists the index at most once per interval instead of after every read and write.
+	// Default is to persist after every change.
+	IndexFlushInterval time.Duration
+	// FastStart loads the index lazily so the exchange can serve content before every ref is loaded, the
+	// refs are validated in the background.
+	FastStart bool
+	// DiskAccounting measures the content from the blocks in the stores instead of trusting the payload size
+	// of refs when enforcing the capacity.
+	DiskAccounting bool
+	// MaxRefs is the number of refs after which least frequently used content is evicted regardless of the
+	// capacity so nodes with little memory can cap the index metadata. Default is unbounded.
+	MaxRefs int
+	// MaxIndexBuckets is the length of the LFU bucket list after which least frequently used content is
+	// evicted. Default is unbounded.
+	MaxIndexBuckets int
+	// MaxInterest is the maximum number of refs kept in the interest list. Default is 10000.
+	MaxInterest int
+	// InterestHalfLife is the period after which the popularity of content in the interest list is halved
+	// so it eventually forgets content nobody asks for anymore. Default is 24h.
+	InterestHalfLife time.Duration
+	// NameResolver resolves the mutable names tracked by the exchange. Default resolves DNSLink names.
+	NameResolver NameResolver
+	// DNSServers are the DNS servers formatted as host:port queried to resolve DNSLink names. Default uses
+	// the system resolver.
+	DNSServers []string
+	// NameCacheTTL is how long the names resolved to retrieve content are cached. Default is 1 minute.
+	NameCacheTTL time.Duration
+	// RevalidateInterval is how often tracked names are checked for a new root. Default is 10 minutes.
+	RevalidateInterval time.Duration
+	// ProbeCanaries are small roots the prober retrieves from random providers to measure the time to first
+	// byte and throughput of the network. Nothing is probed if empty.
+	ProbeCanaries []cid.Cid
+	// ProbeInterval is how often a canary is probed. Default is 5 minutes.
+	ProbeInterval time.Duration
+	// Guard is an optional resource guard to reject new transfers when the node is over budget
+	Guard *metrics.Guard
+	// Bandwidth is an optional meter accounting the bytes of the blocks sent and received per peer and
+	// per ref and delaying the transfers over its limits
+	Bandwidth *metrics.Bandwidth
+	// LeasePrice is the price per byte per hour publishers pay to keep their content from being evicted.
+	// Leases aren't sold if nil.
+	LeasePrice abi.TokenAmount
+	// Pricing configures the price we ask to serve content. Default is the price of the region.
+	Pricing PricingConfig
+	// PricingPolicy replaces the default pricing policy configured by Pricing if not nil
+	PricingPolicy PricingPolicy
+	// ACL sets the access rules applying to every ref. Default serves all refs to any peer.
+	ACL ACLConfig
+	// RedeemThreshold is the value of the vouchers received on a payment channel after which they are
+	// redeemed on chain without waiting for the channel to settle. Vouchers are only redeemed when
+	// settling if nil.
+	RedeemThreshold abi.TokenAmount
+	// Contracts are providers we have an agreement with. They are tried first when dispatching and
+	// retrieving content before falling back to the open market.
+	Contracts []Contract
+	// StallTimeout is how long a retrieval may go without any update before its data transfer is restarted.
+	// Default is DefaultStallTimeout, negative never considers a retrieval stalled.
+	StallTimeout time.Duration
+	// StallRestarts is the number of times the data transfer of a stalled retrieval is restarted before the
+	// deal is cancelled and the next offer tried. Default is DefaultStallRestarts, negative never restarts.
+	StallRestarts int
+	// TransferPolicy controls how data transfers are restarted and timed out when the exchange creates the
+	// DataTransfer manager. The library defaults apply if it is empty.
+	TransferPolicy TransferPolicy
+	// SlowBlockThreshold is the duration after which block reads are logged with their CID and store ID.
+	// Default is 500ms, a negative duration disables the logs.
+	SlowBlockThreshold time.Duration
+	// StandbyOf is the primary node this exchange keeps a warm copy of if not nil
+	StandbyOf *peer.AddrInfo
+	// Standbys are the peers allowed to replicate the state of this exchange
+	Standbys []peer.ID
+	// StandbyInterval is how often a standby replicates its primary. Default is 1 minute.
+	StandbyInterval time.Duration
+	// Delegators are the light clients allowed to make this exchange retrieve and pay for content on
+	// their behalf. Nobody may delegate retrievals if empty.
+	Delegators []peer.ID
+	// HostingMarket decides whether we cache the content dispatched to us or announced by other providers.
+	// Default hosts all the content we have room for.
+	HostingMarket HostingMarket
+	// ValueStore stores the IPNS records of the roots we publish such as the DHT. IPNS names are only
+	// published and resolved if it is set.
+	ValueStore routing.ValueStore
+	// EnableBitswapFallback retrieves content over Bitswap from the public IPFS network when no provider
+	// offers to serve it. The content is then served to other pop peers like any cached content.
+	EnableBitswapFallback bool
+	// BitswapFallbackDelay is how long a retrieval waits for offers before falling back to Bitswap.
+	// Default is 10 seconds.
+	BitswapFallbackDelay time.Duration
+	// FilecoinFallbackDelay is how long a retrieval with a Filecoin fallback waits for offers from pop
+	// providers before querying the miners. Default is 15 seconds.
+	FilecoinFallbackDelay time.Duration
+	// RemoteReadWeight is the number of LFU buckets a ref moves up when it is served to a remote peer.
+	// Default is 1, a negative weight ignores remote reads.
+	RemoteReadWeight int
+	// ContentRouting finds the IPFS peers providing the blocks retrieved over Bitswap such as the DHT.
+	// Only the peers we are connected to are asked if nil.
+	ContentRouting routing.ContentRouting
+	// EnableDHT advertises the roots we store as provider records with the ContentRouting and looks for
+	// the providers of a root there when the gossip network yields no offer.
+	EnableDHT bool
+	// DHTFallbackDelay is how long a retrieval waits for offers from the gossip network before looking for
+	// providers in the DHT. Default is 5 seconds.
+	DHTFallbackDelay time.Duration
+	// StorageMiners are the addresses of the Filecoin miners content is archived with. Default is the
+	// miners of our regions.
+	StorageMiners []string
+	// PublishOnly restricts the exchange to the publisher role: it dispatches and retrieves content but
+	// doesn't answer queries, host content for other peers or sell leases. A node can run it on a separate
+	// host so the reputation and payments of its publisher and provider roles don't mix.
+	PublishOnly bool
+}
+
+// DefaultMaxRestarts is the number of consecutive restarts of a transfer if a policy doesn't set it
+const DefaultMaxRestarts = 3
+
+// transferRestartDebounce waits for related errors to fire before restarting a transfer
+const transferRestartDebounce = 10 * time.Second
+
+// TransferPolicy is how stalled data transfers are restarted and when they are given up on. Small edge links
+// may need long timeouts and many restarts while datacenter links can fail fast.
+type TransferPolicy struct {
+	// MaxRestarts is the number of consecutive restarts before failing a transfer. Default is 3.
+	MaxRestarts uint32
+	// RestartBackoff is the minimum time between two restarts of a transfer
+	RestartBackoff time.Duration
+	// AcceptTimeout is how long to wait for the other side to accept a transfer before restarting it.
+	// No timeout if 0.
+	AcceptTimeout time.Duration
+	// CompleteTimeout is how long to wait for the other side to complete a transfer once all the data was
+	// sent. No timeout if 0.
+	CompleteTimeout time.Duration
+}
+
+// IsZero returns true if no field of the policy is set
+func (p TransferPolicy) IsZero() bool {
+	return p == TransferPolicy{}
+}
+
+// monitorConfig returns the channel monitor config for the policy or nil if the library defaults apply
+func (p TransferPolicy) monitorConfig() *channelmonitor.Config {
+	if p.IsZero() {
+		return nil
+	}
+	cfg := &channelmonitor.Config{
+		AcceptTimeout:          p.AcceptTimeout,
+		RestartDebounce:        transferRestartDebounce,
+		RestartBackoff:         p.RestartBackoff,
+		MaxConsecutiveRestarts: p.MaxRestarts,
+		CompleteTimeout:        p.CompleteTimeout,
+	}
+	if cfg.MaxConsecutiveRestarts == 0 {
+		cfg.MaxConsecutiveRestarts = DefaultMaxRestarts
+	}
+	return cfg
 }
 
 // Everything isn't thoroughly validated so we trust users who provide options know what they're doing
 func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.Batching) (Options, error) {
+	if opts.DataTransfer != nil && opts.GraphSync == nil {
+		return opts, errors.New("a DataTransfer manager requires the GraphSync instance it runs on")
+	}
 	var err error
 	if opts.Blockstore == nil {
 		opts.Blockstore = blockstore.NewBlockstore(ds)
@@ -98,7 +295,7 @@ func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.
 		)
 	}
 	if opts.DataTransfer == nil {
-		opts.DataTransfer, err = NewDataTransfer(ctx, h, opts.GraphSync, ds, "pop/retrieval", opts.RepoPath)
+		opts.DataTransfer, err = NewDataTransfer(ctx, h, opts.GraphSync, ds, "pop/retrieval", opts.RepoPath, opts.TransferPolicy)
 		if err != nil {
 			return opts, err
 		}
@@ -112,11 +309,23 @@ func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.
 	if opts.Regions == nil {
 		opts.Regions = []Region{global}
 	}
+	if opts.TopicShards < 0 {
+		opts.TopicShards = 0
+	}
+	if opts.SaltPeriod <= 0 {
+		opts.SaltPeriod = DefaultSaltPeriod
+	}
+	if opts.MinPeers == 0 {
+		opts.MinPeers = DefaultMinPeers
+	}
+	if opts.BootstrapInterval == 0 {
+		opts.BootstrapInterval = DefaultBootstrapInterval
+	}
 	if opts.FilecoinRPCEndpoint != "" && opts.FilecoinAPI == nil {
 		opts.FilecoinAPI, err = filecoin.NewLotusRPC(ctx, opts.FilecoinRPCEndpoint, opts.FilecoinRPCHeader)
 		if err != nil {
 			// We don't fail the initialization and continue without it
-			fmt.Println("failed to connect with lotus RPC", err)
+			txLog.Warn().Err(err).Msg("failed to connect with lotus RPC")
 			opts.FilecoinAPI = nil
 		}
 	}
@@ -127,11 +336,70 @@ func (opts Options) fillDefaults(ctx context.Context, h host.Host, ds datastore.
 	if opts.RepInterval == 0 {
 		opts.RepInterval = 60 * time.Second
 	}
+	if err := ValidateCategoryQuotas(opts.CategoryQuotas); err != nil {
+		return opts, err
+	}
+	if opts.CategoryLabel == "" {
+		opts.CategoryLabel = DefaultCategoryLabel
+	}
+	if opts.MaxReplications == 0 {
+		opts.MaxReplications = DefaultMaxReplications
+	}
+	if opts.MaxInterest == 0 {
+		opts.MaxInterest = DefaultMaxInterest
+	}
+	if opts.InterestHalfLife == 0 {
+		opts.InterestHalfLife = DefaultInterestHalfLife
+	}
+	if opts.NameResolver == nil && opts.ValueStore != nil {
+		opts.NameResolver = ChainResolver{NewIPNS(opts.ValueStore), DNSLinkResolver{Servers: opts.DNSServers}}
+	}
+	if opts.NameResolver == nil {
+		opts.NameResolver = DNSLinkResolver{Servers: opts.DNSServers}
+	}
+	if opts.RemoteReadWeight == 0 {
+		opts.RemoteReadWeight = DefaultRemoteReadWeight
+	}
+	if opts.FilecoinFallbackDelay == 0 {
+		opts.FilecoinFallbackDelay = DefaultFilecoinFallbackDelay
+	}
+	if opts.EnableDHT && opts.ContentRouting == nil {
+		return opts, errors.New("the DHT requires a content routing")
+	}
+	if opts.DHTFallbackDelay == 0 {
+		opts.DHTFallbackDelay = DefaultDHTFallbackDelay
+	}
+	if opts.StallTimeout == 0 {
+		opts.StallTimeout = DefaultStallTimeout
+	}
+	if opts.StallRestarts == 0 {
+		opts.StallRestarts = DefaultStallRestarts
+	}
+	if opts.BitswapFallbackDelay == 0 {
+		opts.BitswapFallbackDelay = DefaultBitswapFallbackDelay
+	}
+	if opts.NameCacheTTL == 0 {
+		opts.NameCacheTTL = DefaultNameCacheTTL
+	}
+	if opts.RevalidateInterval == 0 {
+		opts.RevalidateInterval = DefaultRevalidateInterval
+	}
+	if opts.ProbeInterval == 0 {
+		opts.ProbeInterval = DefaultProbeInterval
+	}
+	if opts.PublishOnly {
+		opts.HostingMarket = HostNone{}
+		opts.LeasePrice = abi.TokenAmount{}
+	}
+	if opts.HostingMarket == nil {
+		opts.HostingMarket = HostAll{}
+	}
 	return opts, nil
 }
 
-// NewDataTransfer packages together all the things needed for a new manager to work
-func NewDataTransfer(ctx context.Context, h host.Host, gs graphsync.GraphExchange, ds datastore.Batching, dsprefix string, dir string) (datatransfer.Manager, error) {
+// NewDataTransfer packages together all the things needed for a new manager to work.
+// The policy configures restarts and timeouts of the transfers.
+func NewDataTransfer(ctx context.Context, h host.Host, gs graphsync.GraphExchange, ds datastore.Batching, dsprefix string, dir string, policy TransferPolicy) (datatransfer.Manager, error) {
 	cidDir, err := mkCidListDir(dir)
 	if err != nil {
 		return nil, err
@@ -142,8 +410,12 @@ func NewDataTransfer(ctx context.Context, h host.Host, gs graphsync.GraphExchang
 	dtNet := dtnet.NewFromLibp2pHost(h)
 	// Setup graphsync transport
 	tp := gstransport.NewTransport(h.ID(), gs)
+	var dtOpts []dtfimpl.DataTransferOption
+	if cfg := policy.monitorConfig(); cfg != nil {
+		dtOpts = append(dtOpts, dtfimpl.ChannelRestartConfig(*cfg))
+	}
 	// Build the manager
-	dt, err := dtfimpl.NewDataTransfer(dtDs, cidDir, dtNet, tp)
+	dt, err := dtfimpl.NewDataTransfer(dtDs, cidDir, dtNet, tp, dtOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,38 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferPolicy(t *testing.T) {
+	// Library defaults apply when nothing is set
+	require.Nil(t, TransferPolicy{}.monitorConfig())
+
+	cfg := TransferPolicy{
+		AcceptTimeout:   30 * time.Second,
+		CompleteTimeout: time.Minute,
+	}.monitorConfig()
+	require.NotNil(t, cfg)
+	require.Equal(t, 30*time.Second, cfg.AcceptTimeout)
+	require.Equal(t, time.Minute, cfg.CompleteTimeout)
+	require.Equal(t, uint32(DefaultMaxRestarts), cfg.MaxConsecutiveRestarts)
+	require.Equal(t, transferRestartDebounce, cfg.RestartDebounce)
+
+	cfg = TransferPolicy{MaxRestarts: 15, RestartBackoff: 20 * time.Second}.monitorConfig()
+	require.Equal(t, uint32(15), cfg.MaxConsecutiveRestarts)
+	require.Equal(t, 20*time.Second, cfg.RestartBackoff)
+	require.Equal(t, time.Duration(0), cfg.AcceptTimeout)
+}
+
+func TestDataTransferRequiresGraphSync(t *testing.T) {
+	// Remote reads are only counted from the graphsync instance the manager runs on
+	opts := Options{DataTransfer: struct{ datatransfer.Manager }{}}
+	_, err := opts.fillDefaults(context.Background(), nil, datastore.NewMapDatastore())
+	require.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+//go:generate cbor-gen-for OutboxMessage
+
+// KOutbox is the datastore namespace under which announcements waiting to be published are persisted
+const KOutbox = "/outbox"
+
+// MinOutboxPeers is the number of peers we need to know on a topic before publishing. Gossip doesn't
+// report when a message reaches no one so we hold announcements until the topic has enough peers.
+var MinOutboxPeers = 1
+
+// OutboxRetryInterval is how often we retry publishing pending announcements
+var OutboxRetryInterval = 30 * time.Second
+
+// OutboxMessage is an announcement waiting to be published on a gossip topic
+type OutboxMessage struct {
+	Data []byte
+	// Time is the unix timestamp at which the message was queued
+	Time int64
+	// Attempts counts the failed publish attempts
+	Attempts uint64
+}
+
+// Outbox persists the announcements published on a gossip topic until they are sent to enough peers.
+// Announcements made while the node is offline or the mesh is too thin are published again once
+// peers join the topic, including after a restart.
+type Outbox struct {
+	ds  datastore.Batching
+	top *pubsub.Topic
+	// ttl is the age after which pending announcements are dropped
+	ttl time.Duration
+
+	mu   sync.Mutex
+	last int64
+	wake chan struct{}
+}
+
+// NewOutbox creates an outbox for a topic, pending announcements are loaded from the datastore
+func NewOutbox(ds datastore.Batching, top *pubsub.Topic, ttl time.Duration) *Outbox {
+	return &Outbox{
+		ds:   namespace.Wrap(ds, datastore.NewKey(KOutbox).Child(datastore.NewKey(top.String()))),
+		top:  top,
+		ttl:  ttl,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Start retries publishing pending announcements periodically and whenever a peer joins the topic
+func (ob *Outbox) Start(ctx context.Context) error {
+	evts, err := ob.top.EventHandler()
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer evts.Cancel()
+		for {
+			evt, err := evts.NextPeerEvent(ctx)
+			if err != nil {
+				return
+			}
+			if evt.Type == pubsub.PeerJoin {
+				ob.signal()
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(OutboxRetryInterval)
+		defer ticker.Stop()
+		// Announcements may have been left from a previous session
+		ob.signal()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ob.wake:
+			case <-ctx.Done():
+				return
+			}
+			if err := ob.Flush(ctx); err != nil {
+				txLog.Error().Err(err).Msg("failed to flush outbox")
+			}
+		}
+	}()
+	return nil
+}
+
+func (ob *Outbox) signal() {
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publish queues an announcement and tries to publish it right away. The announcement stays in the
+// outbox if the topic doesn't have enough peers or publishing fails.
+func (ob *Outbox) Publish(ctx context.Context, data []byte) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	now := time.Now()
+	// Keys are ordered by time so announcements are published in the order they were made
+	id := now.UnixNano()
+	if id <= ob.last {
+		id = ob.last + 1
+	}
+	ob.last = id
+	if err := ob.put(outboxKey(id), &OutboxMessage{
+		Data: data,
+		Time: now.Unix(),
+	}); err != nil {
+		return err
+	}
+	return ob.flush(ctx)
+}
+
+// Pending returns the announcements which haven't been published yet, oldest first
+func (ob *Outbox) Pending() ([]OutboxMessage, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	var msgs []OutboxMessage
+	err := ob.forEach(func(k datastore.Key, m *OutboxMessage) error {
+		msgs = append(msgs, *m)
+		return nil
+	})
+	return msgs, err
+}
+
+// Flush publishes the pending announcements if the topic has enough peers
+func (ob *Outbox) Flush(ctx context.Context) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.flush(ctx)
+}
+
+// flush publishes pending announcements, callers must hold the lock
+func (ob *Outbox) flush(ctx context.Context) error {
+	if len(ob.top.ListPeers()) < MinOutboxPeers {
+		return nil
+	}
+	return ob.forEach(func(k datastore.Key, m *OutboxMessage) error {
+		// Stale announcements would be ignored by the receivers anyway
+		if ob.ttl > 0 && time.Since(time.Unix(m.Time, 0)) > ob.ttl {
+			return ob.ds.Delete(k)
+		}
+		if err := ob.top.Publish(ctx, m.Data); err != nil {
+			m.Attempts++
+			return ob.put(k, m)
+		}
+		return ob.ds.Delete(k)
+	})
+}
+
+func (ob *Outbox) forEach(fn func(datastore.Key, *OutboxMessage) error) error {
+	res, err := ob.ds.Query(dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		m := new(OutboxMessage)
+		if err := m.UnmarshalCBOR(bytes.NewReader(e.Value)); err != nil {
+			return err
+		}
+		if err := fn(datastore.NewKey(e.Key), m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ob *Outbox) put(k datastore.Key, m *OutboxMessage) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	return ob.ds.Put(k, buf.Bytes())
+}
+
+// outboxKey pads the id so the keys sort in the order the announcements were made
+func outboxKey(id int64) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%020d", id))
+}
@@ -0,0 +1,75 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	pub, err := mn.GenPeer()
+	require.NoError(t, err)
+	cache, err := mn.GenPeer()
+	require.NoError(t, err)
+
+	ps1, err := pubsub.NewGossipSub(ctx, pub)
+	require.NoError(t, err)
+	ps2, err := pubsub.NewGossipSub(ctx, cache)
+	require.NoError(t, err)
+
+	top1, err := ps1.Join("test")
+	require.NoError(t, err)
+
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ob := NewOutbox(ds, top1, time.Hour)
+
+	// We don't know any peer so the announcements are kept
+	require.NoError(t, ob.Publish(ctx, []byte("first")))
+	require.NoError(t, ob.Publish(ctx, []byte("second")))
+	// Stale announcements are dropped instead of being published
+	require.NoError(t, ob.put(outboxKey(1), &OutboxMessage{
+		Data: []byte("stale"),
+		Time: time.Now().Add(-2 * time.Hour).Unix(),
+	}))
+
+	// Pending announcements survive a restart
+	ob = NewOutbox(ds, top1, time.Hour)
+	msgs, err := ob.Pending()
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	top2, err := ps2.Join("test")
+	require.NoError(t, err)
+	sub, err := top2.Subscribe()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(top1.ListPeers()) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, ob.Flush(ctx))
+
+	msg, err := sub.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), msg.Data)
+	msg, err = sub.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), msg.Data)
+
+	msgs, err = ob.Pending()
+	require.NoError(t, err)
+	require.Len(t, msgs, 0)
+}
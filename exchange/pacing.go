@@ -0,0 +1,93 @@
+package exchange
+
+import (
+	"sync"
+	"time"
+)
+
+// transferTime is how long it takes to send size bytes at the given number of bytes per second
+func transferTime(size uint64, bandwidth uint64) time.Duration {
+	return time.Duration(float64(size) / float64(bandwidth) * float64(time.Second))
+}
+
+// pacer spreads the bytes sent over time so they stay within a bandwidth budget
+type pacer struct {
+	mu        sync.Mutex
+	bandwidth uint64
+	// next is the earliest time the budget allows sending more bytes
+	next time.Time
+}
+
+func newPacer(bandwidth uint64) *pacer {
+	return &pacer{bandwidth: bandwidth}
+}
+
+// wait blocks until the budget allows sending n bytes
+func (pc *pacer) wait(n int) {
+	pc.mu.Lock()
+	now := time.Now()
+	if pc.next.Before(now) {
+		pc.next = now
+	}
+	at := pc.next
+	pc.next = pc.next.Add(transferTime(uint64(n), pc.bandwidth))
+	pc.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// dispatchPace schedules the providers of a dispatch according to the uplink budget of the publisher
+type dispatchPace struct {
+	// parallel is the number of providers pulling the content at once, 0 is unlimited
+	parallel int
+	// transfer is how long it takes to send a copy of the content using the whole budget
+	transfer time.Duration
+	deadline time.Time
+}
+
+// newDispatchPace plans a dispatch of rf copies of size bytes. If the budget allows sending all the copies
+// before the deadline the providers pull the content at the same time, sharing the budget. Otherwise they
+// pull one after the other so each copy completes as early as possible and as many copies as the budget
+// allows are done before the deadline. Without a deadline we don't know if the budget is enough so the
+// providers are scheduled one after the other.
+func newDispatchPace(size uint64, rf int, bandwidth uint64, deadline time.Time) dispatchPace {
+	if bandwidth == 0 {
+		return dispatchPace{}
+	}
+	dp := dispatchPace{
+		parallel: 1,
+		transfer: transferTime(size, bandwidth),
+		deadline: deadline,
+	}
+	if !deadline.IsZero() && !time.Now().Add(time.Duration(rf)*dp.transfer).After(deadline) {
+		dp.parallel = rf
+	}
+	return dp
+}
+
+// paced returns whether the dispatch is limited by a budget
+func (dp dispatchPace) paced() bool {
+	return dp.parallel > 0
+}
+
+// slots returns how many new providers can start pulling the content given the number of copies we still
+// need and the number of transfers in flight
+func (dp dispatchPace) slots(need int, inflight int) int {
+	if !dp.paced() {
+		return need
+	}
+	// A new transfer wouldn't complete before the deadline
+	if !dp.deadline.IsZero() && time.Now().Add(dp.transfer).After(dp.deadline) {
+		return 0
+	}
+	max := dp.parallel
+	if need < max {
+		max = need
+	}
+	if inflight >= max {
+		return 0
+	}
+	return max - inflight
+}
@@ -0,0 +1,56 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchPace(t *testing.T) {
+	// 1GB at 50MB/s takes 20s per copy
+	size := uint64(1 << 30)
+	bw := uint64(size / 20)
+
+	// No budget keeps sending to all the providers we need
+	dp := newDispatchPace(size, 6, 0, time.Time{})
+	require.False(t, dp.paced())
+	require.Equal(t, 6, dp.slots(6, 10))
+
+	// The budget is enough to send all the copies before the deadline
+	dp = newDispatchPace(size, 6, bw, time.Now().Add(time.Hour))
+	require.True(t, dp.paced())
+	require.Equal(t, 6, dp.parallel)
+	require.Equal(t, 6, dp.slots(6, 0))
+	require.Equal(t, 2, dp.slots(6, 4))
+	require.Equal(t, 1, dp.slots(1, 0))
+
+	// The copies are sent one after the other if the budget doesn't allow sending them all in time
+	dp = newDispatchPace(size, 6, bw, time.Now().Add(time.Minute))
+	require.Equal(t, 1, dp.parallel)
+	require.Equal(t, 1, dp.slots(6, 0))
+	require.Equal(t, 0, dp.slots(6, 1))
+
+	// No transfer starts if it can't complete before the deadline
+	dp = newDispatchPace(size, 6, bw, time.Now().Add(10*time.Second))
+	require.Equal(t, 0, dp.slots(6, 0))
+
+	// Without deadline the copies are sent one after the other
+	dp = newDispatchPace(size, 6, bw, time.Time{})
+	require.Equal(t, 1, dp.parallel)
+	require.Equal(t, 1, dp.slots(6, 0))
+}
+
+func TestPacer(t *testing.T) {
+	// 1000 bytes per second
+	pc := newPacer(1000)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		pc.wait(100)
+	}
+	// The first 100 bytes are sent right away and the next 300 take 300ms
+	elapsed := time.Since(start)
+	require.True(t, elapsed >= 300*time.Millisecond, elapsed)
+	require.True(t, elapsed < time.Second, elapsed)
+}
@@ -0,0 +1,232 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	dagpb "github.com/ipld/go-ipld-prime-proto"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/myelnet/pop/selectors"
+)
+
+// ErrInvalidPath is returned when a content path cannot be parsed
+var ErrInvalidPath = errors.New("invalid path")
+
+// ErrInvalidKey is returned when an entry name cannot be used as a key
+var ErrInvalidKey = errors.New("invalid key")
+
+// ErrNestedPath is returned when selecting content inside an entry without resolving the names of the
+// nested entries from the DAG
+var ErrNestedPath = errors.New("paths inside an entry must be resolved from the DAG")
+
+// Key is the name of an entry at the root of a transaction DAG
+type Key string
+
+// ParseKey validates the name of an entry
+func ParseKey(s string) (Key, error) {
+	k := Key(s)
+	return k, k.Validate()
+}
+
+// FileKey returns the key of a file or directory added from disk which is the last element of its path
+func FileKey(p string) Key {
+	return Key(filepath.Base(p))
+}
+
+// Validate checks the key can name an entry. It cannot be empty, a relative path element or contain
+// a slash.
+func (k Key) Validate() error {
+	switch {
+	case k == "":
+		return fmt.Errorf("%w: empty key", ErrInvalidKey)
+	case k == "." || k == "..":
+		return fmt.Errorf("%w: %s is a relative path element", ErrInvalidKey, string(k))
+	case strings.ContainsAny(string(k), `/\`):
+		return fmt.Errorf("%w: %s contains a path separator", ErrInvalidKey, string(k))
+	}
+	return nil
+}
+
+func (k Key) String() string {
+	return string(k)
+}
+
+// Path addresses content with a root CID followed by the keys of the entries to walk through
+type Path struct {
+	Root     cid.Cid
+	Segments []Key
+}
+
+// NewPath creates a path from a root and the segments under it
+func NewPath(root cid.Cid, segs ...Key) Path {
+	return Path{Root: root, Segments: segs}
+}
+
+// ParsePath parses a path formatted as /<root>/<key>/... The root may be prefixed with /ipfs/ and the
+// leading slash is optional. Repeated and trailing slashes are ignored.
+func ParsePath(s string) (Path, error) {
+	return parsePath(s, func(r string) (cid.Cid, error) {
+		root, err := cid.Decode(r)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("root is not a CID: %v", err)
+		}
+		return root, nil
+	})
+}
+
+// parsePath parses a path with a function returning the CID of its first segment
+func parsePath(s string, resolve func(string) (cid.Cid, error)) (Path, error) {
+	var parts []string
+	for _, part := range strings.Split(s, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) > 0 && (parts[0] == "ipfs" || parts[0] == "ipld") {
+		parts = parts[1:]
+	}
+	if len(parts) == 0 {
+		return Path{}, fmt.Errorf("%w: %q has no root", ErrInvalidPath, s)
+	}
+	root, err := resolve(parts[0])
+	if err != nil {
+		return Path{}, fmt.Errorf("%w: %q %v", ErrInvalidPath, s, err)
+	}
+	p := Path{Root: root}
+	for _, part := range parts[1:] {
+		k, err := ParseKey(part)
+		if err != nil {
+			return Path{}, fmt.Errorf("%w: %q: %v", ErrInvalidPath, s, err)
+		}
+		p.Segments = append(p.Segments, k)
+	}
+	return p, nil
+}
+
+// Key returns the entry at the root of the DAG the path points to or an empty key for the whole DAG
+func (p Path) Key() Key {
+	if len(p.Segments) == 0 {
+		return ""
+	}
+	return p.Segments[0]
+}
+
+// IsRoot returns true if the path points to the whole DAG
+func (p Path) IsRoot() bool {
+	return len(p.Segments) == 0
+}
+
+// Selector returns the selector retrieving the content the path points to
+func (p Path) Selector() (ipld.Node, error) {
+	switch len(p.Segments) {
+	case 0:
+		return selectors.All(), nil
+	case 1:
+		return selectors.Key(p.Segments[0].String()), nil
+	default:
+		return nil, fmt.Errorf("cannot select %s: %w", p, ErrNestedPath)
+	}
+}
+
+// ResolveSelector returns the selector retrieving the content the path points to. Selectors cannot match
+// the names of UnixFS directory links so the names of the entries inside the root entry are resolved
+// to the index of their link from the directories loaded with the given loader.
+func (p Path) ResolveSelector(ctx context.Context, loader ipld.Loader) (ipld.Node, error) {
+	if len(p.Segments) < 2 {
+		return p.Selector()
+	}
+	chooser := dagpb.AddDagPBSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+	load := func(lnk ipld.Link) (ipld.Node, error) {
+		proto, err := chooser(lnk, ipld.LinkContext{})
+		if err != nil {
+			return nil, err
+		}
+		nb := proto.NewBuilder()
+		if err := lnk.Load(ctx, ipld.LinkContext{}, nb, loader); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	}
+	root, err := load(cidlink.Link{Cid: p.Root})
+	if err != nil {
+		return nil, err
+	}
+	entry, err := root.LookupByString(p.Key().String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s has no entry %s", ErrInvalidPath, p, p.Key())
+	}
+	value, err := entry.LookupByString("Value")
+	if err != nil {
+		return nil, err
+	}
+	lnk, err := value.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	indexes := make([]int, 0, len(p.Segments)-1)
+	for _, name := range p.Segments[1:] {
+		dir, err := load(lnk)
+		if err != nil {
+			return nil, err
+		}
+		i, next, err := findLink(dir, name.String())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPath, p, err)
+		}
+		indexes = append(indexes, i)
+		lnk = next
+	}
+	return selectors.Entry(p.Key().String(), indexes...), nil
+}
+
+// findLink returns the index and the link of a named entry in a UnixFS directory node
+func findLink(dir ipld.Node, name string) (int, ipld.Link, error) {
+	links, err := dir.LookupByString("Links")
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s is not in a directory", name)
+	}
+	it := links.ListIterator()
+	for it != nil && !it.Done() {
+		i, l, err := it.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+		n, err := l.LookupByString("Name")
+		if err != nil {
+			continue
+		}
+		if s, err := n.AsString(); err != nil || s != name {
+			continue
+		}
+		h, err := l.LookupByString("Hash")
+		if err != nil {
+			return 0, nil, err
+		}
+		lnk, err := h.AsLink()
+		if err != nil {
+			return 0, nil, err
+		}
+		return i, lnk, nil
+	}
+	return 0, nil, fmt.Errorf("%s not found", name)
+}
+
+// String formats the path in its normalized /<root>/<key>/... form
+func (p Path) String() string {
+	var b strings.Builder
+	b.WriteString("/")
+	b.WriteString(p.Root.String())
+	for _, k := range p.Segments {
+		b.WriteString("/")
+		b.WriteString(string(k))
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	root := blocks.NewBlock([]byte("root")).Cid()
+
+	testCases := []struct {
+		name string
+		in   string
+		segs []Key
+		err  error
+	}{
+		{name: "root", in: "/" + root.String()},
+		{name: "no leading slash", in: root.String() + "/data.txt", segs: []Key{"data.txt"}},
+		{name: "ipfs prefix", in: "/ipfs/" + root.String() + "/data.txt", segs: []Key{"data.txt"}},
+		{name: "repeated slashes", in: "//" + root.String() + "//dir///file/", segs: []Key{"dir", "file"}},
+		{name: "empty", in: "/", err: ErrInvalidPath},
+		{name: "bad root", in: "/notacid/data.txt", err: ErrInvalidPath},
+		{name: "relative element", in: "/" + root.String() + "/../data.txt", err: ErrInvalidPath},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePath(tc.in)
+			if tc.err != nil {
+				require.True(t, errors.Is(err, tc.err), err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, root, p.Root)
+			require.Equal(t, tc.segs, p.Segments)
+
+			// The normalized form parses to the same path
+			again, err := ParsePath(p.String())
+			require.NoError(t, err)
+			require.Equal(t, p, again)
+		})
+	}
+
+	p := NewPath(root, "data.txt")
+	require.Equal(t, "/"+root.String()+"/data.txt", p.String())
+	require.Equal(t, Key("data.txt"), p.Key())
+	_, err := p.Selector()
+	require.NoError(t, err)
+
+	require.True(t, NewPath(root).IsRoot())
+	require.Equal(t, Key(""), NewPath(root).Key())
+
+	_, err = NewPath(root, "dir", "file").Selector()
+	require.True(t, errors.Is(err, ErrNestedPath))
+}
+
+func TestKeys(t *testing.T) {
+	require.Equal(t, Key("file.txt"), FileKey("/tmp/dir/file.txt"))
+	require.Equal(t, Key("dir"), FileKey("/tmp/dir/"))
+	require.NoError(t, FileKey("file.txt").Validate())
+
+	for _, k := range []string{"", ".", "..", "a/b", `a\b`} {
+		_, err := ParseKey(k)
+		require.True(t, errors.Is(err, ErrInvalidKey), k)
+	}
+	require.Error(t, FileKey("/").Validate())
+}
@@ -1,7 +1,10 @@
 package exchange
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,17 +15,69 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
+// DefaultPeerPruneInterval is how often the peer manager disconnects from dead peers
+const DefaultPeerPruneInterval = time.Minute
+
+// MaxPeerStrikes is the number of consecutive requests a peer may fail to answer before it is pruned
+const MaxPeerStrikes = 3
+
 // Peer contains information recorded while interacted with a peer
 type Peer struct {
-	Regions []RegionCode
+	// Regions are the regions we share with the peer
+	Regions []Region
 	Latency time.Duration
+	// Responses counts the greetings, queries and requests the peer answered and Failures those it didn't
+	Responses uint64
+	Failures  uint64
+	// Strikes counts the failures since the last response, the peer is pruned once it reaches MaxPeerStrikes
+	Strikes int
+	// LastSeen is the last time the peer answered us
+	LastSeen time.Time
+}
+
+// Score ranks a peer between 0 and 1 by the ratio of requests it answered. Peers we never sent a
+// request to start at 0.5 like providers we never retrieved from.
+func (p Peer) Score() float64 {
+	return float64(p.Responses+1) / float64(p.Responses+p.Failures+2)
+}
+
+// better returns whether a peer should be selected before another one, preferring the best score
+// then the lowest latency. Peers without a latency measurement come last.
+func (p Peer) better(o Peer) bool {
+	if p.Score() != o.Score() {
+		return p.Score() > o.Score()
+	}
+	if (p.Latency == 0) != (o.Latency == 0) {
+		return p.Latency != 0
+	}
+	return p.Latency < o.Latency
+}
+
+// PeerState is the state of a peer tracked by the peer manager
+type PeerState struct {
+	ID peer.ID
+	Peer
+	// Protocols are the pop protocols the peer supports
+	Protocols []string
+}
+
+// InRegion returns true if the peer is part of the given region
+func (p Peer) InRegion(r Region) bool {
+	for _, rg := range p.Regions {
+		if rg.Name == r.Name {
+			return true
+		}
+	}
+	return false
 }
 
 // PeerMgr is in charge of maintaining an optimal network of peers to coordinate with
 type PeerMgr struct {
 	h       host.Host
-	regions map[RegionCode]Region
+	regions []Region
 	emitter event.Emitter
+	// interval is how often dead peers are pruned
+	interval time.Duration
 
 	mu    sync.Mutex
 	peers map[peer.ID]Peer
@@ -36,15 +91,11 @@ type HeyEvt struct {
 
 // NewPeerMgr prepares a new PeerMgr instance
 func NewPeerMgr(h host.Host, regions []Region) *PeerMgr {
-	reg := make(map[RegionCode]Region, len(regions))
-	for _, r := range regions {
-		reg[r.Code] = r
-	}
-
 	pm := &PeerMgr{
-		h:       h,
-		regions: reg,
-		peers:   make(map[peer.ID]Peer),
+		h:        h,
+		regions:  regions,
+		interval: DefaultPeerPruneInterval,
+		peers:    make(map[peer.ID]Peer),
 	}
 	pm.emitter, _ = h.EventBus().Emitter(new(HeyEvt))
 	h.Network().Notify(&network.NotifyBundle{
@@ -61,25 +112,51 @@ func NewPeerMgr(h host.Host, regions []Region) *PeerMgr {
 
 // Receive a new greeting from peer
 func (pm *PeerMgr) Receive(p peer.ID, h Hey) {
-	for _, r := range h.Regions {
-		// We only save peers who are in the same region as us
-		if reg, ok := pm.regions[r]; ok {
-			pm.emitter.Emit(HeyEvt{
-				Peer:      p,
-				IndexRoot: h.IndexRoot,
-			})
-			// These peers should be trimmed last when the number of connections overflows
-			pm.h.ConnManager().TagPeer(p, reg.Name, 10)
-			pm.mu.Lock()
-			pm.peers[p] = Peer{
-				Regions: h.Regions,
+	shared := pm.sharedRegions(h)
+	// We only save peers who are in the same region as us
+	if len(shared) == 0 {
+		return
+	}
+	for _, reg := range shared {
+		// These peers should be trimmed last when the number of connections overflows
+		pm.h.ConnManager().TagPeer(p, reg.Name, 10)
+	}
+	pm.mu.Lock()
+	peer := pm.peers[p]
+	peer.Regions = shared
+	pm.peers[p] = peer
+	pm.mu.Unlock()
+	pm.emitter.Emit(HeyEvt{
+		Peer:      p,
+		IndexRoot: h.IndexRoot,
+	})
+}
+
+// sharedRegions returns the regions of a greeting we are also part of. Regions are matched by name
+// as all custom regions have the same code unless the peer didn't send any name.
+func (pm *PeerMgr) sharedRegions(h Hey) []Region {
+	var shared []Region
+	for _, r := range pm.regions {
+		if len(h.RegionNames) > 0 {
+			for _, name := range h.RegionNames {
+				if name == r.Name {
+					shared = append(shared, r)
+					break
+				}
+			}
+			continue
+		}
+		for _, code := range h.Regions {
+			if code == r.Code {
+				shared = append(shared, r)
+				break
 			}
-			pm.mu.Unlock()
 		}
 	}
+	return shared
 }
 
-// RecordLatency for a given peer
+// RecordLatency for a given peer. The round trip of a greeting counts as a response.
 func (pm *PeerMgr) RecordLatency(p peer.ID, t time.Duration) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -88,25 +165,105 @@ func (pm *PeerMgr) RecordLatency(p peer.ID, t time.Duration) error {
 		return errors.New("no peer given ID")
 	}
 	peer.Latency = t
-	pm.peers[p] = peer
+	pm.peers[p] = peer.responded()
 	return nil
 }
 
-// Peers returns n active peers for a given list of regions and peers to ignore
+// RecordResponse records a peer answered one of our queries or requests
+func (pm *PeerMgr) RecordResponse(p peer.ID) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if peer, ok := pm.peers[p]; ok {
+		pm.peers[p] = peer.responded()
+	}
+}
+
+// RecordFailure records a peer failed to answer one of our greetings or requests
+func (pm *PeerMgr) RecordFailure(p peer.ID) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if peer, ok := pm.peers[p]; ok {
+		peer.Failures++
+		peer.Strikes++
+		pm.peers[p] = peer
+	}
+}
+
+func (p Peer) responded() Peer {
+	p.Responses++
+	p.Strikes = 0
+	p.LastSeen = time.Now()
+	return p
+}
+
+// Start prunes the dead peers at every interval until the context is cancelled
+func (pm *PeerMgr) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pm.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pm.Prune()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Prune forgets and disconnects from the peers which failed to answer our last requests so we stop
+// dispatching to them. They are greeted again if they reconnect. It returns the pruned peers.
+func (pm *PeerMgr) Prune() []peer.ID {
+	pm.mu.Lock()
+	var dead []peer.ID
+	for p, v := range pm.peers {
+		if v.Strikes < MaxPeerStrikes {
+			continue
+		}
+		dead = append(dead, p)
+		delete(pm.peers, p)
+		for _, reg := range v.Regions {
+			pm.h.ConnManager().UntagPeer(p, reg.Name)
+		}
+	}
+	pm.mu.Unlock()
+	for _, p := range dead {
+		if err := pm.h.Network().ClosePeer(p); err != nil {
+			discoveryLog.Warn().Err(err).Str("peer", p.String()).Msg("failed to disconnect from dead peer")
+		}
+	}
+	return dead
+}
+
+// Count returns the number of peers we share a region with
+func (pm *PeerMgr) Count() int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return len(pm.peers)
+}
+
+// Peers returns n active peers for a given list of regions and peers to ignore. Peers in the regions
+// listed first are selected first and the best peers of each region are selected first.
 func (pm *PeerMgr) Peers(n int, rl []Region, ignore map[peer.ID]bool) []peer.ID {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	var peers []peer.ID
+	selected := make(map[peer.ID]bool)
 	for _, r := range rl {
+		var candidates []peer.ID
 		for p, v := range pm.peers {
-			if ignore[p] {
+			if ignore[p] || selected[p] || !v.InRegion(r) {
 				continue
 			}
-			for _, rc := range v.Regions {
-				if rc == r.Code {
-					peers = append(peers, p)
-				}
-			}
+			candidates = append(candidates, p)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return pm.peers[candidates[i]].better(pm.peers[candidates[j]])
+		})
+		for _, p := range candidates {
+			peers = append(peers, p)
+			selected[p] = true
 			// Check if we have enough peers and return
 			if len(peers) == n {
 				return peers
@@ -115,3 +272,48 @@ func (pm *PeerMgr) Peers(n int, rl []Region, ignore map[peer.ID]bool) []peer.ID
 	}
 	return peers
 }
+
+// List returns the state of all the peers we share a region with, best first
+func (pm *PeerMgr) List() []PeerState {
+	pm.mu.Lock()
+	states := make([]PeerState, 0, len(pm.peers))
+	for p, v := range pm.peers {
+		states = append(states, PeerState{ID: p, Peer: v})
+	}
+	pm.mu.Unlock()
+	for i, ps := range states {
+		protos, err := pm.h.Peerstore().GetProtocols(ps.ID)
+		if err != nil {
+			continue
+		}
+		for _, proto := range protos {
+			if strings.HasPrefix(proto, "/myel/") {
+				states[i].Protocols = append(states[i].Protocols, proto)
+			}
+		}
+		sort.Strings(states[i].Protocols)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].better(states[j].Peer) || states[j].better(states[i].Peer) {
+			return states[i].better(states[j].Peer)
+		}
+		return states[i].ID < states[j].ID
+	})
+	return states
+}
+
+// Region returns the first region of the list a peer is part of
+func (pm *PeerMgr) Region(p peer.ID, rl []Region) (Region, bool) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	v, ok := pm.peers[p]
+	if !ok {
+		return Region{}, false
+	}
+	for _, r := range rl {
+		if v.InRegion(r) {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
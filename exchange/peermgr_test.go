@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerMgrRegions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n := testutil.NewTestNode(mn, t)
+
+	euWest := ParseRegions([]string{"eu-west"})[0]
+	asia := ParseRegions([]string{"asia"})[0]
+	pm := NewPeerMgr(n.Host, []Region{euWest, asia, Regions["Global"]})
+
+	p1 := testutil.NewTestNode(mn, t).Host.ID()
+	p2 := testutil.NewTestNode(mn, t).Host.ID()
+	p3 := testutil.NewTestNode(mn, t).Host.ID()
+	p4 := testutil.NewTestNode(mn, t).Host.ID()
+
+	pm.Receive(p1, Hey{
+		Regions:     []RegionCode{CustomRegion},
+		RegionNames: []string{"asia"},
+	})
+	pm.Receive(p2, Hey{
+		Regions:     []RegionCode{GlobalRegion, CustomRegion},
+		RegionNames: []string{"Global", "eu-west"},
+	})
+	// Custom regions are told apart by name
+	pm.Receive(p3, Hey{
+		Regions:     []RegionCode{CustomRegion},
+		RegionNames: []string{"us-east"},
+	})
+	// Peers which don't send region names are matched by code
+	pm.Receive(p4, Hey{
+		Regions: []RegionCode{GlobalRegion},
+	})
+
+	require.Equal(t, 3, len(pm.Peers(10, []Region{euWest, asia, Regions["Global"]}, nil)))
+
+	// Peers in the first regions are selected first
+	peers := pm.Peers(2, []Region{asia, euWest}, nil)
+	require.Equal(t, p1, peers[0])
+	require.Equal(t, p2, peers[1])
+
+	rg, ok := pm.Region(p2, []Region{Regions["Global"], euWest})
+	require.True(t, ok)
+	require.Equal(t, "Global", rg.Name)
+	_, ok = pm.Region(p3, []Region{euWest})
+	require.False(t, ok)
+}
+
+func TestPeerMgrQuality(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n := testutil.NewTestNode(mn, t)
+	global := Regions["Global"]
+	pm := NewPeerMgr(n.Host, []Region{global})
+
+	var peers []peer.ID
+	for i := 0; i < 4; i++ {
+		p := testutil.NewTestNode(mn, t).Host.ID()
+		pm.Receive(p, Hey{
+			Regions:     []RegionCode{GlobalRegion},
+			RegionNames: []string{"Global"},
+		})
+		peers = append(peers, p)
+	}
+	good, slow, flaky, dead := peers[0], peers[1], peers[2], peers[3]
+
+	require.NoError(t, pm.RecordLatency(good, 10*time.Millisecond))
+	require.NoError(t, pm.RecordLatency(slow, 100*time.Millisecond))
+	require.NoError(t, pm.RecordLatency(flaky, 5*time.Millisecond))
+	require.NoError(t, pm.RecordLatency(dead, 5*time.Millisecond))
+	pm.RecordFailure(flaky)
+	pm.RecordFailure(flaky)
+	pm.RecordResponse(flaky)
+	for i := 0; i < MaxPeerStrikes; i++ {
+		pm.RecordFailure(dead)
+	}
+
+	// The most responsive peers are selected first then the fastest
+	require.Equal(t, []peer.ID{good, slow, flaky, dead}, pm.Peers(4, []Region{global}, nil))
+	require.Equal(t, []peer.ID{slow}, pm.Peers(1, []Region{global}, map[peer.ID]bool{good: true}))
+
+	states := pm.List()
+	require.Len(t, states, 4)
+	require.Equal(t, flaky, states[2].ID)
+	require.Equal(t, uint64(2), states[2].Responses)
+	require.Equal(t, uint64(2), states[2].Failures)
+	require.Equal(t, 0, states[2].Strikes)
+	require.False(t, states[2].LastSeen.IsZero())
+	require.Equal(t, MaxPeerStrikes, states[3].Strikes)
+
+	// Only the peers failing to answer several requests in a row are pruned
+	require.Equal(t, []peer.ID{dead}, pm.Prune())
+	require.Equal(t, []peer.ID{good, slow, flaky}, pm.Peers(4, []Region{global}, nil))
+	_, ok := pm.Region(dead, []Region{global})
+	require.False(t, ok)
+	require.Empty(t, pm.Prune())
+}
@@ -0,0 +1,308 @@
+package exchange
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	carutil "github.com/ipld/go-car/util"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PexProtocol lets pops share the pop peers they know so a new node finds the network from a single
+// bootstrap pop without a public DHT
+const PexProtocol = protocol.ID("/myel/pop/pex/1.0")
+
+// PexProtocols are the versions of the peer exchange protocol we speak
+var PexProtocols = Protocols{
+	{ID: PexProtocol},
+}
+
+// DefaultMinPeers is the number of pop peers below which we bootstrap again
+const DefaultMinPeers = 4
+
+// DefaultBootstrapInterval is how often we check if we know enough pop peers
+const DefaultBootstrapInterval = time.Minute
+
+// maxPexPeers is the maximum number of peers sent in a peer exchange response
+const maxPexPeers = 20
+
+// pexTimeout bounds the time spent exchanging peers with or connecting to a single peer
+const pexTimeout = 30 * time.Second
+
+// PexRequest asks a pop for the peers it knows in the given regions
+type PexRequest struct {
+	Regions []string `json:"regions"`
+	Max     int      `json:"max,omitempty"`
+}
+
+// PexPeer is a pop peer shared during a peer exchange
+type PexPeer struct {
+	ID        peer.ID  `json:"id"`
+	Addrs     []string `json:"addrs"`
+	Regions   []string `json:"regions"`
+	Protocols []string `json:"protocols,omitempty"`
+}
+
+// PexResponse lists the peers a pop knows, best first
+type PexResponse struct {
+	Peers []PexPeer `json:"peers"`
+}
+
+// PeerExchange connects to the bootstrap pops then learns more pop peers by asking the peers it knows
+// which peers they know. It bootstraps again whenever the number of peers we share a region with drops
+// below a threshold.
+type PeerExchange struct {
+	h        host.Host
+	pm       *PeerMgr
+	regions  []Region
+	interval time.Duration
+
+	mu        sync.Mutex
+	bootstrap []peer.AddrInfo
+	// minPeers is the number of peers below which we bootstrap again
+	minPeers int
+}
+
+// NewPeerExchange creates a new peer exchange service bootstrapping from the given pops
+func NewPeerExchange(h host.Host, pm *PeerMgr, regions []Region, bootstrap []peer.AddrInfo) *PeerExchange {
+	px := &PeerExchange{
+		h:         h,
+		pm:        pm,
+		regions:   regions,
+		bootstrap: bootstrap,
+		minPeers:  DefaultMinPeers,
+		interval:  DefaultBootstrapInterval,
+	}
+	SetStreamHandlers(h, PexProtocols, px.handleStream)
+	return px
+}
+
+// Start bootstraps right away then every interval while we know less peers than the threshold until
+// the context is cancelled
+func (px *PeerExchange) Start(ctx context.Context) {
+	go func() {
+		px.Bootstrap(ctx)
+		ticker := time.NewTicker(px.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				px.mu.Lock()
+				minPeers := px.minPeers
+				px.mu.Unlock()
+				if px.pm.Count() < minPeers {
+					px.Bootstrap(ctx)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Bootstrap connects to the bootstrap pops then to the peers they and the peers we already know share
+// with us. It returns the number of new peers we connected to.
+func (px *PeerExchange) Bootstrap(ctx context.Context) int {
+	px.mu.Lock()
+	bootstrap := px.bootstrap
+	px.mu.Unlock()
+	var sources []peer.ID
+	px.connectAll(ctx, bootstrap, func(p peer.ID) {
+		sources = append(sources, p)
+	})
+	for _, st := range px.pm.List() {
+		sources = append(sources, st.ID)
+	}
+	seen := make(map[peer.ID]bool)
+	var learned []peer.AddrInfo
+	for _, src := range sources {
+		if seen[src] {
+			continue
+		}
+		seen[src] = true
+		peers, err := px.Request(ctx, src)
+		if err != nil {
+			discoveryLog.Warn().Err(err).Str("peer", src.String()).Msg("failed to exchange peers")
+			continue
+		}
+		for _, pp := range peers {
+			info, ok := px.addrInfo(pp)
+			if !ok || seen[info.ID] {
+				continue
+			}
+			seen[info.ID] = true
+			learned = append(learned, info)
+		}
+	}
+	n := 0
+	px.connectAll(ctx, learned, func(peer.ID) {
+		n++
+	})
+	return n
+}
+
+// SetBootstrap replaces the bootstrap pops and the number of peers below which we bootstrap again. It
+// takes effect at the next bootstrap.
+func (px *PeerExchange) SetBootstrap(bootstrap []peer.AddrInfo, minPeers int) {
+	if minPeers == 0 {
+		minPeers = DefaultMinPeers
+	}
+	px.mu.Lock()
+	defer px.mu.Unlock()
+	px.bootstrap = bootstrap
+	px.minPeers = minPeers
+}
+
+// addrInfo returns the addresses of a shared peer if it is a new peer we can dial
+func (px *PeerExchange) addrInfo(pp PexPeer) (peer.AddrInfo, bool) {
+	if pp.ID == px.h.ID() || px.h.Network().Connectedness(pp.ID) == network.Connected {
+		return peer.AddrInfo{}, false
+	}
+	info := peer.AddrInfo{ID: pp.ID}
+	for _, s := range pp.Addrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		info.Addrs = append(info.Addrs, addr)
+	}
+	return info, len(info.Addrs) > 0
+}
+
+// connectAll connects to the given peers in parallel and calls fn with every peer we are connected to.
+// Calls to fn are serialized.
+func (px *PeerExchange) connectAll(ctx context.Context, peers []peer.AddrInfo, fn func(peer.ID)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, info := range peers {
+		if info.ID == px.h.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, pexTimeout)
+			defer cancel()
+			if err := px.h.Connect(cctx, info); err != nil {
+				discoveryLog.Debug().Err(err).Str("peer", info.ID.String()).Msg("failed to connect")
+				return
+			}
+			mu.Lock()
+			fn(info.ID)
+			mu.Unlock()
+		}(info)
+	}
+	wg.Wait()
+}
+
+// Request asks a peer for the pop peers it knows in our regions
+func (px *PeerExchange) Request(ctx context.Context, p peer.ID) ([]PexPeer, error) {
+	ctx, cancel := context.WithTimeout(ctx, pexTimeout)
+	defer cancel()
+	s, err := px.h.NewStream(ctx, p, PexProtocols.Active(time.Now())...)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	req := PexRequest{Max: maxPexPeers}
+	for _, r := range px.regions {
+		req.Regions = append(req.Regions, r.Name)
+	}
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := carutil.LdWrite(s, buf); err != nil {
+		return nil, err
+	}
+	frame, err := carutil.LdRead(bufio.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	var res PexResponse
+	if err := json.Unmarshal(frame, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Peers) > maxPexPeers {
+		res.Peers = res.Peers[:maxPexPeers]
+	}
+	return res.Peers, nil
+}
+
+func (px *PeerExchange) handleStream(s network.Stream) {
+	defer s.Close()
+	frame, err := carutil.LdRead(bufio.NewReader(s))
+	if err != nil {
+		s.Reset()
+		return
+	}
+	var req PexRequest
+	if err := json.Unmarshal(frame, &req); err != nil {
+		s.Reset()
+		return
+	}
+	buf, err := json.Marshal(px.share(s.Conn().RemotePeer(), req))
+	if err != nil {
+		s.Reset()
+		return
+	}
+	if err := carutil.LdWrite(s, buf); err != nil {
+		s.Reset()
+	}
+}
+
+// share returns the best peers we know in the requested regions with the addresses we have for them
+func (px *PeerExchange) share(from peer.ID, req PexRequest) PexResponse {
+	max := req.Max
+	if max <= 0 || max > maxPexPeers {
+		max = maxPexPeers
+	}
+	wanted := make(map[string]bool, len(req.Regions))
+	for _, name := range req.Regions {
+		wanted[name] = true
+	}
+	res := PexResponse{Peers: []PexPeer{}}
+	for _, st := range px.pm.List() {
+		if len(res.Peers) == max {
+			break
+		}
+		// Peers failing to answer us are likely gone
+		if st.ID == from || st.Strikes > 0 {
+			continue
+		}
+		pp := PexPeer{ID: st.ID, Protocols: st.Protocols}
+		for _, r := range st.Regions {
+			pp.Regions = append(pp.Regions, r.Name)
+		}
+		if !sharesRegion(pp.Regions, wanted) {
+			continue
+		}
+		for _, addr := range px.h.Peerstore().Addrs(st.ID) {
+			pp.Addrs = append(pp.Addrs, addr.String())
+		}
+		if len(pp.Addrs) == 0 {
+			continue
+		}
+		res.Peers = append(res.Peers, pp)
+	}
+	return res
+}
+
+func sharesRegion(regions []string, wanted map[string]bool) bool {
+	for _, name := range regions {
+		if wanted[name] {
+			return true
+		}
+	}
+	return false
+}
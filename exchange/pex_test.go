@@ -0,0 +1,64 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	newExchange := func(n *testutil.TestNode, bootstrap ...peer.AddrInfo) *Exchange {
+		exch, err := New(ctx, n.Host, n.Ds, Options{
+			RepoPath:  n.DTTmpDir,
+			Keystore:  keystore.NewMemKeystore(),
+			Bootstrap: bootstrap,
+		})
+		require.NoError(t, err)
+		return exch
+	}
+	bn := testutil.NewTestNode(mn, t)
+	an := testutil.NewTestNode(mn, t)
+	cn := testutil.NewTestNode(mn, t)
+	nn := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+
+	boot := newExchange(bn)
+	newExchange(an)
+	newExchange(cn)
+	_, err := mn.ConnectPeers(bn.Host.ID(), an.Host.ID())
+	require.NoError(t, err)
+	_, err = mn.ConnectPeers(bn.Host.ID(), cn.Host.ID())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(boot.Peers()) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// The bootstrap pop shares the peers of the requested regions except the requester
+	res := boot.pex.share(an.Host.ID(), PexRequest{Regions: []string{"Global"}})
+	require.Len(t, res.Peers, 1)
+	require.Equal(t, cn.Host.ID(), res.Peers[0].ID)
+	require.NotEmpty(t, res.Peers[0].Addrs)
+	require.Contains(t, res.Peers[0].Protocols, string(PexProtocol))
+	require.Empty(t, boot.pex.share(an.Host.ID(), PexRequest{Regions: []string{"Asia"}}).Peers)
+
+	// A new pop only connected to the bootstrap pop learns the other pops from it
+	newcomer := newExchange(nn, peer.AddrInfo{ID: bn.Host.ID(), Addrs: bn.Host.Addrs()})
+	require.Eventually(t, func() bool {
+		return nn.Host.Network().Connectedness(an.Host.ID()) == network.Connected &&
+			nn.Host.Network().Connectedness(cn.Host.ID()) == network.Connected
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(newcomer.Peers()) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,285 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/selectors"
+)
+
+// KPlacements is the datastore key prefix under which placement manifests are persisted
+const KPlacements = "placements"
+
+// ErrPlacementNotFound is returned when we have no placement manifest for a root
+var ErrPlacementNotFound = errors.New("placement manifest not found")
+
+// SubtreeTimeout is how long we wait to retrieve a subtree from the network before giving up
+var SubtreeTimeout = 5 * time.Minute
+
+// Subtree is an entry of a root dispatched on its own
+type Subtree struct {
+	Key  string
+	Root cid.Cid
+	Size int64
+	// Providers confirmed caching the subtree when it was dispatched
+	Providers []peer.ID
+}
+
+// PlacementManifest records which providers cache each subtree of a root too large to be cached by
+// a single provider. The root block is embedded so the DAG can be assembled from the subtrees only.
+type PlacementManifest struct {
+	Root      cid.Cid
+	RootBlock []byte
+	Subtrees  []Subtree
+}
+
+// Subtree returns the placement of the subtree under a given key
+func (m *PlacementManifest) Subtree(key string) (Subtree, bool) {
+	for _, st := range m.Subtrees {
+		if st.Key == key {
+			return st, true
+		}
+	}
+	return Subtree{}, false
+}
+
+func placementKey(k cid.Cid) datastore.Key {
+	return datastore.NewKey(KPlacements).ChildString(k.String())
+}
+
+// PutPlacement persists a placement manifest under its root. It is kept after the root is evicted
+// so the content can be assembled from the subtrees.
+func (idx *Index) PutPlacement(m *PlacementManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return idx.ds.Put(placementKey(m.Root), data)
+}
+
+// GetPlacement returns the placement manifest for a given root
+func (idx *Index) GetPlacement(k cid.Cid) (*PlacementManifest, error) {
+	data, err := idx.ds.Get(placementKey(k))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, ErrPlacementNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(PlacementManifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithSubtreePlacement makes Commit dispatch each entry of the root to different providers instead of
+// replicating the whole DAG so content larger than any cache can be hosted by the network. The assignment
+// is recorded in a placement manifest.
+func WithSubtreePlacement() TxOption {
+	return func(tx *Tx) {
+		tx.subtrees = true
+	}
+}
+
+// WithPlacement retrieves the content by assembling the subtrees from the providers listed in the
+// manifest when querying instead of retrieving the root DAG directly
+func WithPlacement(m *PlacementManifest) TxOption {
+	return func(tx *Tx) {
+		tx.placement = m
+	}
+}
+
+// Placement returns the placement manifest created when committing with subtree placement. The providers
+// of each subtree are only recorded once the dispatch is over.
+func (tx *Tx) Placement() *PlacementManifest {
+	return tx.placement
+}
+
+// commitSubtrees stores each entry in its own store and dispatches them to distinct providers, rf
+// providers per subtree. It returns the records of the providers confirming they received a subtree.
+func (tx *Tx) commitSubtrees(opts DispatchOptions, rf int) (chan PRecord, error) {
+	blk, err := tx.store.Bstore.Get(tx.root)
+	if err != nil {
+		return nil, err
+	}
+	m := &PlacementManifest{
+		Root:      tx.root,
+		RootBlock: blk.RawData(),
+	}
+	entries, err := tx.Status()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e := entries[k]
+		storeID := tx.ms.Next()
+		store, err := tx.ms.Get(storeID)
+		if err != nil {
+			return nil, err
+		}
+		tx.index.hold(storeID)
+		defer tx.index.release(storeID)
+		if err := copyDAG(tx.ctx, tx.store.Bstore, store.Bstore, e.Value); err != nil {
+			return nil, err
+		}
+		err = tx.index.SetRef(&DataRef{
+			PayloadCID:  e.Value,
+			StoreID:     storeID,
+			PayloadSize: e.Size,
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.Subtrees = append(m.Subtrees, Subtree{Key: k, Root: e.Value, Size: e.Size})
+	}
+	if err := tx.index.PutPlacement(m); err != nil {
+		return nil, err
+	}
+	tx.placement = m
+
+	records := make(chan PRecord, len(m.Subtrees))
+	go func() {
+		defer close(records)
+		assigned := make([][]peer.ID, len(m.Subtrees))
+		// Providers caching a subtree are excluded from the next ones so none of them needs room for
+		// the whole root
+		var exclude []peer.ID
+		for i, st := range m.Subtrees {
+			opts.RF = rf
+			opts.Exclude = exclude
+			for rec := range tx.repl.Dispatch(st.Root, uint64(st.Size), opts) {
+				exclude = append(exclude, rec.Provider)
+				assigned[i] = append(assigned[i], rec.Provider)
+				records <- rec
+			}
+		}
+		for i := range m.Subtrees {
+			m.Subtrees[i].Providers = assigned[i]
+		}
+		if err := tx.index.PutPlacement(m); err != nil {
+			txLog.Warn().Err(err).Msg("failed to record subtree placement")
+		}
+	}()
+	return records, nil
+}
+
+// placementManifest returns the manifest to assemble the content from if we don't store the root
+// and it was committed with subtree placement
+func (tx *Tx) placementManifest() *PlacementManifest {
+	if tx.placement != nil {
+		return tx.placement
+	}
+	if _, err := tx.index.PeekRef(tx.root); err == nil {
+		return nil
+	}
+	m, err := tx.index.GetPlacement(tx.root)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// assemble retrieves the subtrees of the given keys or all of them into the transaction store from the
+// providers they were placed with. Subtrees we store are used first.
+func (tx *Tx) assemble(m *PlacementManifest, keys []string) {
+	res := TxResult{Spent: big.Zero()}
+	blk, err := blocks.NewBlockWithCid(m.RootBlock, m.Root)
+	if err != nil {
+		res.Err = fmt.Errorf("invalid root block for %s: %w", m.Root, err)
+		tx.done <- res
+		return
+	}
+	if err := tx.store.Bstore.Put(blk); err != nil {
+		res.Err = err
+		tx.done <- res
+		return
+	}
+	subtrees := m.Subtrees
+	if len(keys) > 0 {
+		subtrees = nil
+		for _, k := range keys {
+			st, ok := m.Subtree(k)
+			if !ok {
+				res.Err = fmt.Errorf("no subtree under key %s in placement of %s", k, m.Root)
+				tx.done <- res
+				return
+			}
+			subtrees = append(subtrees, st)
+		}
+	}
+	for _, st := range subtrees {
+		if err := tx.loadSubtree(st.Root); err == nil {
+			continue
+		}
+		r, err := tx.retrieveSubtree(st)
+		if !r.Spent.Nil() {
+			res.Spent = big.Add(res.Spent, r.Spent)
+		}
+		res.Size += r.Size
+		if err != nil {
+			res.Err = fmt.Errorf("failed to retrieve subtree %s: %w", st.Key, err)
+			break
+		}
+	}
+	tx.done <- res
+}
+
+// loadSubtree copies a subtree we store into the transaction store
+func (tx *Tx) loadSubtree(k cid.Cid) error {
+	ref, err := tx.index.PeekRef(k)
+	if err != nil {
+		return err
+	}
+	store, err := tx.index.OpenStore(ref.StoreID)
+	if err != nil {
+		return err
+	}
+	return copyDAG(tx.ctx, store.Bstore, tx.store.Bstore, k)
+}
+
+// retrieveSubtree retrieves a subtree in a new transaction trying the providers it was placed with
+// before the rest of the network and copies it into the transaction store
+func (tx *Tx) retrieveSubtree(st Subtree) (TxResult, error) {
+	if tx.newTx == nil {
+		return TxResult{}, ErrNoStrategy
+	}
+	ctx, cancel := context.WithTimeout(tx.ctx, SubtreeTimeout)
+	defer cancel()
+	contracts := make([]Contract, len(st.Providers))
+	for i, p := range st.Providers {
+		contracts[i] = Contract{Peer: p}
+	}
+	sub := tx.newTx(ctx, WithRoot(st.Root), WithStrategy(SelectFirst), WithContracts(contracts...))
+	defer sub.Close()
+	// The subtree is only needed until it is copied
+	defer func() {
+		_ = tx.index.deleteStore(sub.StoreID())
+	}()
+	if err := sub.Query(selectors.All()); err != nil {
+		return TxResult{}, err
+	}
+	select {
+	case res := <-sub.Done():
+		if res.Err != nil {
+			return res, res.Err
+		}
+		return res, copyDAG(ctx, sub.Store().Bstore, tx.store.Bstore, st.Root)
+	case <-ctx.Done():
+		return TxResult{}, sub.noOfferErr(ctx.Err())
+	}
+}
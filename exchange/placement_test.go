@@ -0,0 +1,80 @@
+package exchange
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubtreePlacement(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	p1 := n.CreateRandomFile(t, 64000)
+	p2 := n.CreateRandomFile(t, 32000)
+	data1, err := ioutil.ReadFile(p1)
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx, WithSubtreePlacement())
+	require.NoError(t, tx.PutFile(p1))
+	require.NoError(t, tx.PutFile(p2))
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+
+	m, err := exch.Index().GetPlacement(root)
+	require.NoError(t, err)
+	require.Equal(t, root, m.Root)
+	require.Equal(t, 2, len(m.Subtrees))
+	st1, ok := m.Subtree(FileKey(p1).String())
+	require.True(t, ok)
+	st2, ok := m.Subtree(FileKey(p2).String())
+	require.True(t, ok)
+	// Each subtree is indexed on its own so it can be dispatched and served separately
+	_, err = exch.Index().PeekRef(st1.Root)
+	require.NoError(t, err)
+	tx.Close()
+
+	// The root and one of the subtrees are evicted
+	require.NoError(t, exch.Index().DropRef(root))
+	require.NoError(t, exch.Index().DropRef(st2.Root))
+
+	// The subtree we still store is enough to get its entry
+	tx = exch.Tx(ctx, WithRoot(root))
+	defer tx.Close()
+	tx.newTx = nil
+	require.NoError(t, tx.QueryPath("/"+root.String()+"/"+st1.Key))
+	select {
+	case res := <-tx.Done():
+		require.NoError(t, res.Err)
+	case <-ctx.Done():
+		t.Fatal("content was not assembled")
+	}
+	f, err := tx.GetFile(st1.Key)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, data1, got)
+
+	// The other subtree must be retrieved from the network
+	tx = exch.Tx(ctx, WithRoot(root))
+	defer tx.Close()
+	tx.newTx = nil
+	require.NoError(t, tx.Query(nil))
+	res := <-tx.Done()
+	require.Error(t, res.Err)
+}
@@ -0,0 +1,222 @@
+package exchange
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/myelnet/pop/metrics"
+)
+
+// DemandVersion is the version of the demand snapshot format
+const DemandVersion = 1
+
+// ErrInvalidDemand is returned when importing a file which isn't a demand snapshot
+var ErrInvalidDemand = errors.New("invalid demand snapshot")
+
+// DemandHeader is the first line of a demand snapshot
+type DemandHeader struct {
+	Version int `json:"v"`
+	// Time is the unix time at which the snapshot was taken
+	Time int64 `json:"t"`
+}
+
+// DemandRecord is the popularity of a root in a demand snapshot. Keys are short to keep the snapshot
+// of a large index compact.
+type DemandRecord struct {
+	Root cid.Cid `json:"r"`
+	Size int64   `json:"s,omitempty"`
+	// Freq is the read frequency of the root in the index or the interest list
+	Freq int64 `json:"f,omitempty"`
+	// Daily are the reads of the last days until the snapshot was taken, oldest first. Days without
+	// reads before the first read are omitted.
+	Daily []uint64 `json:"d,omitempty"`
+}
+
+// DemandImport reports how a demand snapshot was applied
+type DemandImport struct {
+	// Refs is the number of roots we store whose frequency increased
+	Refs int `json:"refs"`
+	// Interest is the number of roots added to the interest list or whose interest increased
+	Interest int `json:"interest"`
+}
+
+// ExportDemand writes the interest list and the popularity of the roots we store as gzipped JSON lines
+// so another node can inherit the demand we observed. Unlike Export it doesn't include the refs so it
+// stays small and can be imported in nodes which store different content.
+func (idx *Index) ExportDemand(w io.Writer) error {
+	var records []DemandRecord
+	idx.mu.Lock()
+	for _, ref := range idx.Refs {
+		records = append(records, DemandRecord{
+			Root: ref.PayloadCID,
+			Size: ref.PayloadSize,
+			Freq: ref.Freq,
+		})
+	}
+	idx.mu.Unlock()
+	idx.imu.Lock()
+	for _, ref := range idx.interest {
+		records = append(records, DemandRecord{
+			Root: ref.PayloadCID,
+			Size: ref.PayloadSize,
+			Freq: ref.Freq,
+		})
+	}
+	idx.imu.Unlock()
+
+	now := idx.access.now()
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(DemandHeader{Version: DemandVersion, Time: now.Unix()}); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		stats, err := idx.access.stats(rec.Root)
+		if err != nil {
+			return err
+		}
+		days := stats.Days(DailyBuckets, now)
+		for i, d := range days {
+			if d.Reads() > 0 {
+				for _, d := range days[i:] {
+					rec.Daily = append(rec.Daily, d.Reads())
+				}
+				break
+			}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+// ImportDemand applies a demand snapshot created with ExportDemand. The frequencies are multiplied by
+// weight so the demand observed by other nodes doesn't outweigh our own, 0 applies them as is. Roots
+// we store move up in the LFU by at most MaxDemandWeight and the others are added to the interest list
+// so the most popular content is retrieved the next time the index is refreshed. The daily reads seed
+// the access statistics of the roots we have no statistics for.
+func (idx *Index) ImportDemand(r io.Reader, weight float64) (DemandImport, error) {
+	var res DemandImport
+	if weight <= 0 {
+		weight = 1
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return res, fmt.Errorf("%w: %v", ErrInvalidDemand, err)
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	var h DemandHeader
+	if err := dec.Decode(&h); err != nil {
+		return res, fmt.Errorf("%w: %v", ErrInvalidDemand, err)
+	}
+	if h.Version != DemandVersion {
+		return res, fmt.Errorf("%w: unsupported version %d", ErrInvalidDemand, h.Version)
+	}
+	var records []DemandRecord
+	for {
+		var rec DemandRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("%w: %v", ErrInvalidDemand, err)
+		}
+		records = append(records, rec)
+	}
+
+	var pending []*DataRef
+	idx.mu.Lock()
+	for _, rec := range records {
+		freq := int64(math.Ceil(float64(rec.Freq) * weight))
+		if freq <= 0 {
+			continue
+		}
+		k := rec.Root.String()
+		ref, ok := idx.lookup(k)
+		if !ok {
+			pending = append(pending, &DataRef{
+				PayloadCID:  rec.Root,
+				PayloadSize: rec.Size,
+				Freq:        freq,
+			})
+			continue
+		}
+		if freq > MaxDemandWeight {
+			freq = MaxDemandWeight
+		}
+		for i := int64(0); i < freq; i++ {
+			idx.increment(ref)
+		}
+		if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+			idx.mu.Unlock()
+			return res, err
+		}
+		res.Refs++
+	}
+	if res.Refs > 0 {
+		err = idx.commit()
+	}
+	idx.mu.Unlock()
+	if err != nil {
+		return res, err
+	}
+
+	idx.imu.Lock()
+	for _, ref := range pending {
+		idx.addInterest(ref.PayloadCID.String(), ref)
+		res.Interest++
+	}
+	idx.capInterest()
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+	idx.imu.Unlock()
+
+	at := time.Unix(h.Time, 0)
+	for _, rec := range records {
+		if len(rec.Daily) == 0 {
+			continue
+		}
+		if err := idx.access.seed(rec.Root, rec.Daily, at); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// seed sets the daily reads of a root from a demand snapshot taken at the given time if we have no
+// statistics for it yet. The reads count as remote reads as they were served by other nodes.
+func (al *accessLog) seed(root cid.Cid, daily []uint64, at time.Time) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	has, err := al.ds.Has(datastore.NewKey(root.String()))
+	if err != nil || has {
+		return err
+	}
+	s, err := al.get(root)
+	if err != nil {
+		return err
+	}
+	ring := accessRing(s.Daily)
+	for i, n := range daily {
+		t := at.Add(-time.Duration(len(daily)-1-i) * 24 * time.Hour)
+		j, start := ring.slot(24*time.Hour, t)
+		ring[j] = AccessBucket{Start: start, Remote: n}
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return al.ds.Put(datastore.NewKey(root.String()), buf)
+}
@@ -0,0 +1,79 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemandExportImport(t *testing.T) {
+	newIndex := func() *Index {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		ms, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		idx, err := NewIndex(ds, ms)
+		require.NoError(t, err)
+		return idx
+	}
+
+	src := newIndex()
+	shared := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+	}
+	require.NoError(t, src.SetRef(shared))
+	only := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 2000,
+	}
+	require.NoError(t, src.SetRef(only))
+	for i := 0; i < 4; i++ {
+		_, err := src.GetRef(shared.PayloadCID)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, src.RemoteRead(only.PayloadCID))
+	}
+	wanted := blockGen.Next().Cid()
+	_, _, err := src.AddDemand([]DemandHint{{Root: wanted, Weight: 6}})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, src.ExportDemand(&buf))
+
+	// The destination only stores one of the roots
+	dst := newIndex()
+	require.NoError(t, dst.SetRef(&DataRef{
+		PayloadCID:  shared.PayloadCID,
+		PayloadSize: 1000,
+	}))
+
+	res, err := dst.ImportDemand(bytes.NewReader(buf.Bytes()), 0.5)
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Refs)
+	require.Equal(t, 2, res.Interest)
+
+	ref, err := dst.PeekRef(shared.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ref.Freq)
+
+	// The roots we don't store are in the interest list with their size
+	require.Equal(t, 2, dst.InterestLen())
+	require.Equal(t, int64(2000), dst.interest[only.PayloadCID.String()].PayloadSize)
+	require.Equal(t, int64(3), dst.interest[wanted.String()].Freq)
+
+	// The daily reads seed the access statistics
+	stats, err := dst.AccessStats(only.PayloadCID)
+	require.NoError(t, err)
+	days := stats.Days(1, dst.access.now())
+	require.Equal(t, uint64(2), days[0].Remote)
+
+	_, err = dst.ImportDemand(strings.NewReader("not a snapshot"), 1)
+	require.True(t, errors.Is(err, ErrInvalidDemand))
+}
@@ -0,0 +1,193 @@
+package exchange
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipldformat "github.com/ipfs/go-ipld-format"
+)
+
+// DefaultPrefetchWindow is the maximum number of blocks we load ahead of sequential reads
+const DefaultPrefetchWindow = 64
+
+// Prefetcher wraps a DAG service to load upcoming sibling blocks ahead of sequential reads so streaming
+// a file doesn't stall on every block boundary. The readahead window starts at one block, doubles every
+// time the reads are sequential and falls back to one block when the reader seeks.
+type Prefetcher struct {
+	ipldformat.DAGService
+	// ctx bounds the background fetches
+	ctx context.Context
+	// max is the maximum number of blocks loaded ahead of the reader
+	max int
+	// poll is the interval at which we check again for a missing block if the content is still being
+	// retrieved. If 0 missing blocks fail right away.
+	poll time.Duration
+
+	mu     sync.Mutex
+	window int
+	// positions remembers where each block we know about sits among its siblings
+	positions map[cid.Cid]position
+	// seen is the set of blocks the reader already requested
+	seen map[cid.Cid]bool
+	// pending are the blocks being fetched or fetched but not read yet
+	pending map[cid.Cid]*fetch
+}
+
+type position struct {
+	links []cid.Cid
+	i     int
+}
+
+type fetch struct {
+	done chan struct{}
+	nd   ipldformat.Node
+	err  error
+}
+
+// NewPrefetcher wraps a DAG service with a readahead window of at most max blocks. If poll is not 0,
+// blocks missing from the DAG are polled until they are retrieved or the context is cancelled.
+func NewPrefetcher(ctx context.Context, dag ipldformat.DAGService, max int, poll time.Duration) *Prefetcher {
+	return &Prefetcher{
+		DAGService: dag,
+		ctx:        ctx,
+		max:        max,
+		poll:       poll,
+		window:     1,
+		positions:  make(map[cid.Cid]position),
+		seen:       make(map[cid.Cid]bool),
+		pending:    make(map[cid.Cid]*fetch),
+	}
+}
+
+// Get returns a block and loads the following siblings in the background
+func (p *Prefetcher) Get(ctx context.Context, c cid.Cid) (ipldformat.Node, error) {
+	f := p.request([]cid.Cid{c})[0]
+	select {
+	case <-f.done:
+		return f.nd, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetMany returns the requested blocks as they are loaded and loads the following siblings in the background
+func (p *Prefetcher) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipldformat.NodeOption {
+	out := make(chan *ipldformat.NodeOption, len(cids))
+	fetches := p.request(cids)
+	go func() {
+		defer close(out)
+		for _, f := range fetches {
+			select {
+			case <-f.done:
+				out <- &ipldformat.NodeOption{Node: f.nd, Err: f.err}
+			case <-ctx.Done():
+				out <- &ipldformat.NodeOption{Err: ctx.Err()}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Window returns the current size of the readahead window
+func (p *Prefetcher) Window() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.window
+}
+
+// request returns a fetch for each block, adjusts the readahead window and starts loading
+// the blocks following the last one
+func (p *Prefetcher) request(cids []cid.Cid) []*fetch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(cids) > 0 {
+		if pos, ok := p.positions[cids[0]]; ok {
+			// Reads are sequential if the previous sibling was already read
+			if pos.i == 0 || p.seen[pos.links[pos.i-1]] {
+				p.window *= 2
+				if p.window > p.max {
+					p.window = p.max
+				}
+			} else {
+				p.window = 1
+			}
+		}
+	}
+
+	fetches := make([]*fetch, len(cids))
+	for i, c := range cids {
+		p.seen[c] = true
+		f, ok := p.pending[c]
+		if ok {
+			delete(p.pending, c)
+		} else {
+			f = p.start(c)
+		}
+		fetches[i] = f
+	}
+
+	if len(cids) == 0 {
+		return fetches
+	}
+	pos, ok := p.positions[cids[len(cids)-1]]
+	if !ok {
+		return fetches
+	}
+	for i := pos.i + 1; i < len(pos.links) && i <= pos.i+p.window; i++ {
+		c := pos.links[i]
+		if p.seen[c] || p.pending[c] != nil {
+			continue
+		}
+		p.pending[c] = p.start(c)
+	}
+	return fetches
+}
+
+// start loads a block in the background, callers must hold the lock
+func (p *Prefetcher) start(c cid.Cid) *fetch {
+	f := &fetch{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		f.nd, f.err = p.load(c)
+		if f.err == nil {
+			p.index(f.nd)
+		}
+	}()
+	return f
+}
+
+// load gets a block from the DAG service, polling until it is available if the content is being retrieved
+func (p *Prefetcher) load(c cid.Cid) (ipldformat.Node, error) {
+	for {
+		nd, err := p.DAGService.Get(p.ctx, c)
+		if err != ipldformat.ErrNotFound || p.poll == 0 {
+			return nd, err
+		}
+		select {
+		case <-time.After(p.poll):
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		}
+	}
+}
+
+// index records the position of the children of a node so we know which blocks come next
+func (p *Prefetcher) index(nd ipldformat.Node) {
+	links := nd.Links()
+	if len(links) == 0 {
+		return
+	}
+	cids := make([]cid.Cid, len(links))
+	for i, l := range links {
+		cids[i] = l.Cid
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, c := range cids {
+		p.positions[c] = position{links: cids, i: i}
+	}
+}
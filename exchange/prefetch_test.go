@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// slowDAG adds latency to every block read and counts them
+type slowDAG struct {
+	ipldformat.DAGService
+	mu   sync.Mutex
+	gets int
+}
+
+func (d *slowDAG) Get(ctx context.Context, c cid.Cid) (ipldformat.Node, error) {
+	time.Sleep(time.Millisecond)
+	d.mu.Lock()
+	d.gets++
+	d.mu.Unlock()
+	return d.DAGService.Get(ctx, c)
+}
+
+func readFile(ctx context.Context, t *testing.T, dag ipldformat.DAGService, root cid.Cid) []byte {
+	nd, err := dag.Get(ctx, root)
+	require.NoError(t, err)
+	f, err := unixfile.NewUnixfsFile(ctx, dag, nd)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	return data
+}
+
+func TestPrefetcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n := testutil.NewTestNode(mocknet.New(ctx), t)
+	fname := n.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := n.LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	store, err := n.Ms.Get(storeID)
+	require.NoError(t, err)
+
+	dag := &slowDAG{DAGService: store.DAG}
+	p := NewPrefetcher(ctx, dag, 32, 0)
+	require.Equal(t, origBytes, readFile(ctx, t, p, root))
+
+	// The window grew as we read sequentially
+	require.Equal(t, 32, p.Window())
+	// Every block was only loaded once
+	require.Equal(t, 251, dag.gets)
+}
+
+func TestPrefetcherMissingBlocks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n := testutil.NewTestNode(mocknet.New(ctx), t)
+	fname := n.CreateRandomFile(t, 64000)
+	link, storeID, origBytes := n.LoadFileToNewStore(ctx, t, fname)
+	root := link.(cidlink.Link).Cid
+	src, err := n.Ms.Get(storeID)
+	require.NoError(t, err)
+
+	// The content is still being transferred to this store
+	dst, err := n.Ms.Get(n.Ms.Next())
+	require.NoError(t, err)
+
+	rootNd, err := src.DAG.Get(ctx, root)
+	require.NoError(t, err)
+	require.NoError(t, dst.DAG.Add(ctx, rootNd))
+
+	p := NewPrefetcher(ctx, dst.DAG, 16, 5*time.Millisecond)
+	done := make(chan []byte)
+	go func() {
+		done <- readFile(ctx, t, p, root)
+	}()
+
+	// Blocks arrive in order while the reader is waiting
+	for _, l := range rootNd.Links() {
+		nd, err := src.DAG.Get(ctx, l.Cid)
+		require.NoError(t, err)
+		require.NoError(t, dst.DAG.Add(ctx, nd))
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case data := <-done:
+		require.Equal(t, origBytes, data)
+	case <-ctx.Done():
+		t.Fatal("read did not complete")
+	}
+}
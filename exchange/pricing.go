@@ -0,0 +1,120 @@
+package exchange
+
+import (
+	"math"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+)
+
+// PricingRequest describes the query a provider is pricing
+type PricingRequest struct {
+	Peer   peer.ID
+	Root   cid.Cid
+	Size   uint64
+	Region Region
+}
+
+// PricingPolicy decides the price per byte a provider asks to serve content
+type PricingPolicy interface {
+	Price(PricingRequest) abi.TokenAmount
+}
+
+// PricingConfig configures the default pricing policy. Zero values keep the price of the region.
+type PricingConfig struct {
+	// PricePerByte replaces the price of the region if not nil
+	PricePerByte abi.TokenAmount
+	// FreeBelow is the size in bytes under which content is served for free
+	FreeBelow uint64
+	// FreePeers retrieve any content for free
+	FreePeers []peer.ID
+	// MaxLoad is the number of retrievals served at the same time at which the price is multiplied by
+	// MaxLoadMultiplier. The price grows linearly with the load until then.
+	MaxLoad           int
+	MaxLoadMultiplier float64
+}
+
+// Pricing is the default pricing policy. It can be reconfigured while the exchange is running.
+type Pricing struct {
+	mu  sync.Mutex
+	cfg PricingConfig
+	// active are the deals we are currently serving
+	active map[deal.ProviderDealIdentifier]struct{}
+}
+
+// NewPricing creates a pricing policy from a config
+func NewPricing(cfg PricingConfig) *Pricing {
+	return &Pricing{
+		cfg:    cfg,
+		active: make(map[deal.ProviderDealIdentifier]struct{}),
+	}
+}
+
+// Config returns the current config
+func (pr *Pricing) Config() PricingConfig {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.cfg
+}
+
+// SetConfig replaces the config for the next queries
+func (pr *Pricing) SetConfig(cfg PricingConfig) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.cfg = cfg
+}
+
+// Load returns the number of retrievals we are serving
+func (pr *Pricing) Load() int {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return len(pr.active)
+}
+
+// Price returns the price per byte for a query
+func (pr *Pricing) Price(req PricingRequest) abi.TokenAmount {
+	pr.mu.Lock()
+	cfg := pr.cfg
+	load := len(pr.active)
+	pr.mu.Unlock()
+
+	if cfg.FreeBelow > 0 && req.Size < cfg.FreeBelow {
+		return big.Zero()
+	}
+	for _, p := range cfg.FreePeers {
+		if p == req.Peer {
+			return big.Zero()
+		}
+	}
+	price := req.Region.PPB
+	if !cfg.PricePerByte.Nil() {
+		price = cfg.PricePerByte
+	}
+	if price.Nil() || price.IsZero() || cfg.MaxLoad <= 0 || cfg.MaxLoadMultiplier <= 1 {
+		return price
+	}
+	ratio := math.Min(float64(load)/float64(cfg.MaxLoad), 1)
+	// The multiplier is applied in thousandths to keep an integer amount
+	factor := int64(math.Round(1000 * (1 + (cfg.MaxLoadMultiplier-1)*ratio)))
+	return big.Div(big.Mul(price, big.NewInt(factor)), big.NewInt(1000))
+}
+
+// recordProviderDeal tracks the deals we are serving to measure the load
+func (pr *Pricing) recordProviderDeal(event provider.Event, state deal.ProviderState) {
+	id := state.Identifier()
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	switch state.Status {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored, deal.StatusRejected, deal.StatusDealNotFound:
+		delete(pr.active, id)
+		return
+	}
+	if event == provider.EventDealAccepted {
+		pr.active[id] = struct{}{}
+	}
+}
@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPricing(t *testing.T) {
+	free := peer.ID("free")
+	region := Region{Name: "Europe", PPB: abi.NewTokenAmount(10)}
+	req := PricingRequest{
+		Peer:   peer.ID("paying"),
+		Root:   blockGen.Next().Cid(),
+		Size:   2 << 20,
+		Region: region,
+	}
+
+	// The price of the region applies by default
+	pr := NewPricing(PricingConfig{})
+	require.Equal(t, abi.NewTokenAmount(10), pr.Price(req))
+
+	pr.SetConfig(PricingConfig{
+		PricePerByte:      abi.NewTokenAmount(100),
+		FreeBelow:         1 << 20,
+		FreePeers:         []peer.ID{free},
+		MaxLoad:           4,
+		MaxLoadMultiplier: 3,
+	})
+	require.Equal(t, abi.NewTokenAmount(100), pr.Price(req))
+
+	small := req
+	small.Size = 1 << 10
+	require.True(t, pr.Price(small).IsZero())
+
+	allowed := req
+	allowed.Peer = free
+	require.True(t, pr.Price(allowed).IsZero())
+
+	// The price grows with the number of retrievals we serve
+	for i := 0; i < 2; i++ {
+		pr.recordProviderDeal(provider.EventDealAccepted, deal.ProviderState{
+			Proposal: deal.Proposal{ID: deal.ID(i)},
+			Status:   deal.StatusAccepted,
+		})
+	}
+	require.Equal(t, 2, pr.Load())
+	require.Equal(t, abi.NewTokenAmount(200), pr.Price(req))
+
+	for i := 2; i < 6; i++ {
+		pr.recordProviderDeal(provider.EventDealAccepted, deal.ProviderState{
+			Proposal: deal.Proposal{ID: deal.ID(i)},
+			Status:   deal.StatusAccepted,
+		})
+	}
+	// It is capped at max load
+	require.Equal(t, abi.NewTokenAmount(300), pr.Price(req))
+
+	for i := 0; i < 6; i++ {
+		pr.recordProviderDeal(provider.EventComplete, deal.ProviderState{
+			Proposal: deal.Proposal{ID: deal.ID(i)},
+			Status:   deal.StatusCompleted,
+		})
+	}
+	require.Equal(t, 0, pr.Load())
+	require.Equal(t, abi.NewTokenAmount(100), pr.Price(req))
+}
@@ -0,0 +1,140 @@
+package exchange
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// BlindedRootCodec is the private use multicodec of the CIDs standing for the root of a private query. The
+// identity multihash of such a CID holds the salt followed by the SHA-256 of the salt and the root CID.
+const BlindedRootCodec = 0x300001
+
+// DefaultSaltPeriod is how long clients salt their private queries with the same salt. Providers hash the
+// keys of their index once per salt so a longer period costs them less but queries for the same root
+// during a period can be linked together.
+const DefaultSaltPeriod = time.Hour
+
+// saltLen is the length of the salts of private queries
+const saltLen = 8
+
+// maxBlindSalts is the number of salts providers keep the hashed keys of their index for
+const maxBlindSalts = 4
+
+// ErrNotBlinded is returned when resolving a CID which doesn't stand for the root of a private query
+var ErrNotBlinded = errors.New("not a blinded root")
+
+// SaltAt returns the salt of the private queries made at a given time
+func SaltAt(t time.Time, period time.Duration) []byte {
+	if period <= 0 {
+		period = DefaultSaltPeriod
+	}
+	salt := make([]byte, saltLen)
+	binary.BigEndian.PutUint64(salt, uint64(t.UnixNano()/int64(period)))
+	return salt
+}
+
+// BlindRoot returns the CID sent in place of a root in private queries so passive listeners of the gossip
+// topics can't read which content is requested. Providers holding the root can still match it by hashing
+// the keys of their index with the same salt.
+func BlindRoot(root cid.Cid, salt []byte) cid.Cid {
+	payload := append(append([]byte{}, salt...), blindDigest(root, salt)...)
+	// Identity hashes cannot fail to encode
+	hash, _ := mh.Sum(payload, mh.IDENTITY, -1)
+	return cid.NewCidV1(BlindedRootCodec, hash)
+}
+
+// IsBlinded returns whether a CID stands for the root of a private query
+func IsBlinded(c cid.Cid) bool {
+	return c.Defined() && c.Prefix().Codec == BlindedRootCodec
+}
+
+func blindDigest(root cid.Cid, salt []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(root.Bytes())
+	return h.Sum(nil)
+}
+
+// unblind returns the salt and the digest of a blinded root
+func unblind(c cid.Cid) ([]byte, []byte, error) {
+	if !IsBlinded(c) {
+		return nil, nil, ErrNotBlinded
+	}
+	dh, err := mh.Decode(c.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	if dh.Code != mh.IDENTITY || len(dh.Digest) != saltLen+sha256.Size {
+		return nil, nil, ErrNotBlinded
+	}
+	return dh.Digest[:saltLen], dh.Digest[saltLen:], nil
+}
+
+// blindKeys are the keys of the index hashed with a salt
+type blindKeys struct {
+	// seq is the sequence of the index when the keys were hashed
+	seq   uint64
+	roots map[string]cid.Cid
+}
+
+// BlindIndex matches the blinded roots of private queries with the roots in our index. The keys hashed with
+// the last few salts are kept until the index changes.
+type BlindIndex struct {
+	idx *Index
+
+	mu    sync.Mutex
+	salts map[string]*blindKeys
+	// order lists the salts from the oldest to the newest to forget the oldest first
+	order []string
+}
+
+// NewBlindIndex creates a new BlindIndex for the roots of the given index
+func NewBlindIndex(idx *Index) *BlindIndex {
+	return &BlindIndex{
+		idx:   idx,
+		salts: make(map[string]*blindKeys),
+	}
+}
+
+// Resolve returns the root of our index a blinded root stands for or ErrRefNotFound if we don't have it
+func (b *BlindIndex) Resolve(c cid.Cid) (cid.Cid, error) {
+	salt, digest, err := unblind(c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	root, ok := b.keys(salt).roots[string(digest)]
+	if !ok {
+		return cid.Undef, ErrRefNotFound
+	}
+	return root, nil
+}
+
+// keys returns the keys of the index hashed with a salt, hashing them again if the index changed
+func (b *BlindIndex) keys(salt []byte) *blindKeys {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seq := b.idx.Seq()
+	k := string(salt)
+	if bk, ok := b.salts[k]; ok && bk.seq == seq {
+		return bk
+	}
+	bk := &blindKeys{seq: seq, roots: make(map[string]cid.Cid)}
+	for _, root := range b.idx.Keys() {
+		bk.roots[string(blindDigest(root, salt))] = root
+	}
+	if _, ok := b.salts[k]; !ok {
+		b.order = append(b.order, k)
+		if len(b.order) > maxBlindSalts {
+			delete(b.salts, b.order[0])
+			b.order = b.order[1:]
+		}
+	}
+	b.salts[k] = bk
+	return bk
+}
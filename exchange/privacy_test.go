@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/retrieval/deal"
+	sel "github.com/myelnet/pop/selectors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlindIndex(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	root := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{PayloadCID: root, PayloadSize: 1000}))
+	b := NewBlindIndex(idx)
+
+	now := time.Now()
+	salt := SaltAt(now, time.Hour)
+	require.Equal(t, salt, SaltAt(now.Truncate(time.Hour), time.Hour))
+	require.NotEqual(t, salt, SaltAt(now.Add(time.Hour), time.Hour))
+
+	// The blinded root doesn't reveal the root but resolves to it
+	blinded := BlindRoot(root, salt)
+	require.True(t, IsBlinded(blinded))
+	require.False(t, IsBlinded(root))
+	require.NotContains(t, string(blinded.Bytes()), string(root.Hash()))
+	got, err := b.Resolve(blinded)
+	require.NoError(t, err)
+	require.Equal(t, root, got)
+
+	// Other salts hide the root differently
+	other := BlindRoot(root, SaltAt(now.Add(time.Hour), time.Hour))
+	require.NotEqual(t, blinded, other)
+	got, err = b.Resolve(other)
+	require.NoError(t, err)
+	require.Equal(t, root, got)
+
+	// Roots added after the keys were hashed are found
+	added := blockGen.Next().Cid()
+	_, err = b.Resolve(BlindRoot(added, salt))
+	require.True(t, errors.Is(err, ErrRefNotFound))
+	require.NoError(t, idx.SetRef(&DataRef{PayloadCID: added, PayloadSize: 1000}))
+	got, err = b.Resolve(BlindRoot(added, salt))
+	require.NoError(t, err)
+	require.Equal(t, added, got)
+
+	_, err = b.Resolve(root)
+	require.True(t, errors.Is(err, ErrNotBlinded))
+
+	// Only the keys of the last few salts are kept
+	for i := 0; i < maxBlindSalts+2; i++ {
+		_, err = b.Resolve(BlindRoot(root, SaltAt(now.Add(time.Duration(i)*time.Hour), time.Hour)))
+		require.NoError(t, err)
+	}
+	require.Len(t, b.salts, maxBlindSalts)
+	require.Len(t, b.order, maxBlindSalts)
+}
+
+func TestPrivateQuery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	tx := exch.Tx(ctx)
+	defer tx.Close()
+	require.NoError(t, tx.PutFile(n.CreateRandomFile(t, 56000)))
+	require.NoError(t, tx.Commit())
+
+	params, err := deal.NewQueryParams(sel.All())
+	require.NoError(t, err)
+	plain, err := exch.handleQuery(ctx, peer.ID("client"), global, deal.Query{
+		PayloadCID:  tx.Root(),
+		QueryParams: params,
+	})
+	require.NoError(t, err)
+
+	// A private query for content we store gets the same offer
+	private, err := exch.handleQuery(ctx, peer.ID("client"), global, deal.Query{
+		PayloadCID:  BlindRoot(tx.Root(), SaltAt(time.Now(), DefaultSaltPeriod)),
+		QueryParams: params,
+	})
+	require.NoError(t, err)
+	require.Equal(t, plain.Size, private.Size)
+
+	_, err = exch.handleQuery(ctx, peer.ID("client"), global, deal.Query{
+		PayloadCID:  BlindRoot(blockGen.Next().Cid(), SaltAt(time.Now(), DefaultSaltPeriod)),
+		QueryParams: params,
+	})
+	require.True(t, errors.Is(err, ErrRefNotFound))
+}
@@ -0,0 +1,276 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/metrics"
+	"github.com/myelnet/pop/retrieval/deal"
+	sel "github.com/myelnet/pop/selectors"
+)
+
+// DefaultProbeInterval is how often the prober retrieves a canary when probing is enabled
+const DefaultProbeInterval = 5 * time.Minute
+
+// DefaultProbeTimeout bounds the time a single probe may take from the query to the end of the transfer
+const DefaultProbeTimeout = time.Minute
+
+// DefaultProbeWindow is the number of recent probes the SLIs are computed from
+const DefaultProbeWindow = 100
+
+// probeOfferWait is how long a probe collects offers before picking a random provider
+const probeOfferWait = 3 * time.Second
+
+// ErrNoCanaries is returned when probing without any canary to retrieve
+var ErrNoCanaries = errors.New("no canary to probe")
+
+// ProbeResult is the outcome of retrieving a canary from a provider
+type ProbeResult struct {
+	Root     cid.Cid
+	Provider peer.ID
+	// Time is when the probe started
+	Time time.Time
+	// Discovery is the time from the query to the start of the transfer
+	Discovery time.Duration
+	// TTFB is the time from the start of the transfer to the first bytes received
+	TTFB time.Duration
+	// Duration is the time from the start of the transfer to its completion
+	Duration time.Duration
+	Size     uint64
+	// Err is the reason the probe failed if not empty
+	Err string
+}
+
+// Succeeded returns whether the canary was retrieved
+func (r ProbeResult) Succeeded() bool {
+	return r.Err == ""
+}
+
+// Throughput returns the number of bytes per second received during the transfer
+func (r ProbeResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Size) / r.Duration.Seconds()
+}
+
+// SLI summarizes the recent probes into indicators of the health of the network
+type SLI struct {
+	Probes   int
+	Failures int
+	// TTFB50 and TTFB90 are the median and 90th percentile time to first byte of the successful probes
+	TTFB50 time.Duration
+	TTFB90 time.Duration
+	// Throughput is the median number of bytes per second received by the successful probes
+	Throughput float64
+	// Last is when the last probe started
+	Last time.Time
+}
+
+// SuccessRate returns the fraction of the probes which retrieved their canary or 0 if nothing was probed
+func (s SLI) SuccessRate() float64 {
+	if s.Probes == 0 {
+		return 0
+	}
+	return float64(s.Probes-s.Failures) / float64(s.Probes)
+}
+
+// Prober periodically retrieves small canary objects from random providers and measures how fast they
+// serve them. The results are published as metrics and summarized into SLIs so the operator and the
+// selection strategies get a recent view of the network health. The canaries are never added to the
+// index and retrieving them updates the reputation of the providers like any retrieval.
+type Prober struct {
+	e        *Exchange
+	canaries []cid.Cid
+	interval time.Duration
+	timeout  time.Duration
+	wait     time.Duration
+
+	mu sync.Mutex
+	// results are the last probes from the oldest to the newest
+	results []ProbeResult
+	window  int
+}
+
+// NewProber creates a prober retrieving the given canaries
+func NewProber(e *Exchange, canaries []cid.Cid) *Prober {
+	return &Prober{
+		e:        e,
+		canaries: canaries,
+		interval: DefaultProbeInterval,
+		timeout:  DefaultProbeTimeout,
+		wait:     probeOfferWait,
+		window:   DefaultProbeWindow,
+	}
+}
+
+// Start probes the network at every interval until the context is cancelled. It does nothing without
+// canaries.
+func (p *Prober) Start(ctx context.Context) {
+	if len(p.canaries) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := p.Probe(ctx); err != nil && ctx.Err() == nil {
+					discoveryLog.Warn().Err(err).Msg("probe failed")
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Probe retrieves a random canary from a random provider and records the result
+func (p *Prober) Probe(ctx context.Context) (ProbeResult, error) {
+	if len(p.canaries) == 0 {
+		return ProbeResult{}, ErrNoCanaries
+	}
+	root := p.canaries[rand.Intn(len(p.canaries))]
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	res := ProbeResult{Root: root, Time: time.Now()}
+	fail := func(err error) (ProbeResult, error) {
+		res.Err = err.Error()
+		p.record(res)
+		return res, err
+	}
+
+	tx := p.e.Tx(ctx, WithRoot(root), WithStrategy(SelectRandom(p.wait)))
+	// The canary isn't indexed so its store is garbage collected once released
+	defer tx.Close()
+	if err := tx.Query(sel.All()); err != nil {
+		return fail(err)
+	}
+	var started time.Time
+	for {
+		select {
+		case ref := <-tx.Ongoing():
+			// A new deal starts if the previous provider failed, only the last one is measured
+			started = time.Now()
+			res.Provider = ref.Offer.Provider.ID
+			res.Discovery = started.Sub(res.Time)
+			res.TTFB = 0
+		case pe := <-tx.Progress():
+			if res.TTFB == 0 && !started.IsZero() && pe.Provider == res.Provider && pe.Received > 0 {
+				res.TTFB = time.Since(started)
+			}
+		case r := <-tx.Done():
+			if r.Err != nil {
+				return fail(r.Err)
+			}
+			res.Duration = time.Since(started)
+			res.Size = r.Size
+			// The progress updates may have been dropped if the transfer was quick
+			if res.TTFB == 0 {
+				res.TTFB = res.Duration
+			}
+			p.record(res)
+			return res, nil
+		case <-ctx.Done():
+			return fail(tx.RetrievalErr())
+		}
+	}
+}
+
+// record adds a probe result to the window and publishes it as metrics
+func (p *Prober) record(res ProbeResult) {
+	if res.Succeeded() {
+		metrics.ProbeResults.WithLabelValues("success").Inc()
+		metrics.ProbeTTFB.Observe(res.TTFB.Seconds())
+		metrics.ProbeThroughput.Set(res.Throughput())
+	} else {
+		metrics.ProbeResults.WithLabelValues("failure").Inc()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = append(p.results, res)
+	if len(p.results) > p.window {
+		p.results = p.results[len(p.results)-p.window:]
+	}
+}
+
+// Results returns the recent probes, newest first
+func (p *Prober) Results() []ProbeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := make([]ProbeResult, len(p.results))
+	for i, r := range p.results {
+		results[len(results)-1-i] = r
+	}
+	return results
+}
+
+// SLIs summarizes the recent probes
+func (p *Prober) SLIs() SLI {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sli SLI
+	var ttfbs []time.Duration
+	var throughputs []float64
+	for _, r := range p.results {
+		sli.Probes++
+		sli.Last = r.Time
+		if !r.Succeeded() {
+			sli.Failures++
+			continue
+		}
+		ttfbs = append(ttfbs, r.TTFB)
+		throughputs = append(throughputs, r.Throughput())
+	}
+	if len(ttfbs) == 0 {
+		return sli
+	}
+	sort.Slice(ttfbs, func(i, j int) bool { return ttfbs[i] < ttfbs[j] })
+	sort.Float64s(throughputs)
+	sli.TTFB50 = ttfbs[percentileIndex(len(ttfbs), 0.5)]
+	sli.TTFB90 = ttfbs[percentileIndex(len(ttfbs), 0.9)]
+	sli.Throughput = throughputs[percentileIndex(len(throughputs), 0.5)]
+	return sli
+}
+
+// percentileIndex returns the index of the nearest rank percentile in a sorted list of n values
+func percentileIndex(n int, q float64) int {
+	i := int(q*float64(n)+0.5) - 1
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// SelectRandom collects offers during the given window then executes one from a random provider so
+// measurements aren't biased toward the cheapest or best known providers. If the transfer fails it will
+// select another random offer given the buffered offers.
+func SelectRandom(wait time.Duration) func(OfferExecutor) OfferWorker {
+	return func(oe OfferExecutor) OfferWorker {
+		return sessionWorker{
+			executor:      oe,
+			offersIn:      make(chan deal.Offer),
+			closing:       make(chan chan []deal.Offer, 1),
+			numThreshold:  -1,
+			timeThreshold: wait,
+			priceCeiling:  abi.NewTokenAmount(-1),
+			rank: func(offers []deal.Offer) {
+				rand.Shuffle(len(offers), func(i, j int) {
+					offers[i], offers[j] = offers[j], offers[i]
+				})
+			},
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	newExchange := func(n *testutil.TestNode) *Exchange {
+		exch, err := New(ctx, n.Host, n.Ds, Options{
+			RepoPath: n.DTTmpDir,
+			Keystore: keystore.NewMemKeystore(),
+		})
+		require.NoError(t, err)
+		return exch
+	}
+	cn := testutil.NewTestNode(mn, t)
+	pn := testutil.NewTestNode(mn, t)
+	client := newExchange(cn)
+	provider := newExchange(pn)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+	time.Sleep(time.Second)
+
+	tx := provider.Tx(ctx)
+	require.NoError(t, tx.PutFile(pn.CreateRandomFile(t, 4000)))
+	require.NoError(t, tx.Commit())
+	canary := tx.Root()
+	tx.Close()
+
+	prb := NewProber(client, nil)
+	_, err := prb.Probe(ctx)
+	require.True(t, errors.Is(err, ErrNoCanaries))
+
+	prb.canaries = append(prb.canaries, canary)
+	prb.wait = 500 * time.Millisecond
+	res, err := prb.Probe(ctx)
+	require.NoError(t, err)
+	require.True(t, res.Succeeded())
+	require.Equal(t, pn.Host.ID(), res.Provider)
+	require.NotZero(t, res.Size)
+	require.NotZero(t, res.TTFB)
+	require.LessOrEqual(t, int64(res.TTFB), int64(res.Duration))
+
+	// Canaries aren't cached
+	_, err = client.Index().PeekRef(canary)
+	require.True(t, errors.Is(err, ErrRefNotFound))
+
+	// A canary nobody provides fails the probe
+	prb.canaries[0] = blockGen.Next().Cid()
+	prb.timeout = 2 * time.Second
+	res, err = prb.Probe(ctx)
+	require.Error(t, err)
+	require.False(t, res.Succeeded())
+
+	sli := prb.SLIs()
+	require.Equal(t, 2, sli.Probes)
+	require.Equal(t, 1, sli.Failures)
+	require.Equal(t, 0.5, sli.SuccessRate())
+	require.NotZero(t, sli.TTFB50)
+	require.Len(t, prb.Results(), 2)
+	require.False(t, prb.Results()[0].Succeeded())
+}
+
+func TestProberSLIs(t *testing.T) {
+	prb := NewProber(nil, nil)
+	prb.window = 10
+	require.Equal(t, SLI{}, prb.SLIs())
+
+	now := time.Now()
+	for i := 1; i <= 12; i++ {
+		prb.record(ProbeResult{
+			Provider: peer.ID("provider"),
+			Time:     now.Add(time.Duration(i) * time.Minute),
+			TTFB:     time.Duration(i) * 10 * time.Millisecond,
+			Duration: time.Second,
+			Size:     uint64(i * 1000),
+		})
+	}
+	prb.record(ProbeResult{
+		Time: now.Add(time.Hour),
+		Err:  "no offer",
+	})
+
+	// Only the last probes of the window are summarized
+	sli := prb.SLIs()
+	require.Equal(t, 10, sli.Probes)
+	require.Equal(t, 1, sli.Failures)
+	require.Equal(t, 0.9, sli.SuccessRate())
+	require.Equal(t, now.Add(time.Hour), sli.Last)
+	// The successful probes measured 40ms to 120ms and 4000 to 12000 bytes per second
+	require.Equal(t, 80*time.Millisecond, sli.TTFB50)
+	require.Equal(t, 110*time.Millisecond, sli.TTFB90)
+	require.Equal(t, 8000.0, sli.Throughput)
+
+	results := prb.Results()
+	require.Len(t, results, 10)
+	require.False(t, results[0].Succeeded())
+	require.Equal(t, uint64(12000), results[1].Size)
+}
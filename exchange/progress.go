@@ -0,0 +1,128 @@
+package exchange
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// progressBuffer is the number of progress events buffered before new events are dropped
+const progressBuffer = 16
+
+// ProgressEvent reports the state of a retrieval executed by a transaction
+type ProgressEvent struct {
+	Deal     deal.ID
+	Provider peer.ID
+	// Received is the number of bytes received so far and Size the total size of the offer if known
+	Received uint64
+	Size     uint64
+	// Blocks is the number of blocks received and verified against the selector
+	Blocks int
+	// Vouchers is the number of payment vouchers sent to the provider and Spent the funds they cover
+	Vouchers int
+	Spent    abi.TokenAmount
+	// Status is the name of the client event which triggered the update
+	Status string
+}
+
+// Fraction returns the portion of the content received between 0 and 1 or 0 if the size is unknown
+func (p ProgressEvent) Fraction() float64 {
+	if p.Size == 0 {
+		return 0
+	}
+	f := float64(p.Received) / float64(p.Size)
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// txProgress counts the blocks and vouchers of the deals executed by a transaction
+// and streams the updates to the transaction consumer
+type txProgress struct {
+	mu       sync.Mutex
+	size     uint64
+	blocks   map[deal.ID]int
+	vouchers map[deal.ID]int
+	// last is the latest progress of each deal
+	last map[deal.ID]ProgressEvent
+	ch   chan ProgressEvent
+}
+
+func newTxProgress() *txProgress {
+	return &txProgress{
+		blocks:   make(map[deal.ID]int),
+		vouchers: make(map[deal.ID]int),
+		last:     make(map[deal.ID]ProgressEvent),
+		ch:       make(chan ProgressEvent, progressBuffer),
+	}
+}
+
+// setSize records the size of the offer being executed
+func (p *txProgress) setSize(size uint64) {
+	p.mu.Lock()
+	p.size = size
+	p.mu.Unlock()
+}
+
+// observe updates the counters with a client event and publishes the new progress. Events are
+// dropped if the consumer isn't keeping up as each of them reports the full state of the deal.
+func (p *txProgress) observe(event client.Event, state deal.ClientState) {
+	p.mu.Lock()
+	switch event {
+	case client.EventBlocksReceived:
+		p.blocks[state.ID]++
+	case client.EventPaymentSent:
+		p.vouchers[state.ID]++
+	}
+	spent := state.FundsSpent
+	if spent.Nil() {
+		spent = big.Zero()
+	}
+	pe := ProgressEvent{
+		Deal:     state.ID,
+		Provider: state.Sender,
+		Received: state.TotalReceived,
+		Size:     p.size,
+		Blocks:   p.blocks[state.ID],
+		Vouchers: p.vouchers[state.ID],
+		Spent:    spent,
+		Status:   client.Events[event],
+	}
+	p.last[state.ID] = pe
+	p.mu.Unlock()
+
+	p.publish(pe)
+}
+
+// report publishes the latest progress of a deal with a status which isn't a client event
+func (p *txProgress) report(id deal.ID, provider peer.ID, status string) {
+	p.mu.Lock()
+	pe, ok := p.last[id]
+	if !ok {
+		pe = ProgressEvent{Deal: id, Provider: provider, Size: p.size, Spent: big.Zero()}
+	}
+	pe.Status = status
+	p.mu.Unlock()
+
+	p.publish(pe)
+}
+
+func (p *txProgress) publish(pe ProgressEvent) {
+	select {
+	case p.ch <- pe:
+	default:
+	}
+}
+
+// Progress returns a channel receiving updates about the deals executed by the transaction.
+// Updates are dropped if the channel is not read fast enough, the latest event always carries
+// the full progress of the deal. The channel is never closed, use Done to know when the retrieval
+// is over.
+func (tx *Tx) Progress() <-chan ProgressEvent {
+	return tx.progress.ch
+}
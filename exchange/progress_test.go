@@ -0,0 +1,46 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxProgress(t *testing.T) {
+	p := newTxProgress()
+	p.setSize(1000)
+
+	state := deal.ClientState{
+		Proposal: deal.Proposal{ID: 1},
+		Sender:   peer.ID("provider"),
+	}
+	state.TotalReceived = 200
+	p.observe(client.EventBlocksReceived, state)
+	state.TotalReceived = 500
+	p.observe(client.EventBlocksReceived, state)
+	state.FundsSpent = abi.NewTokenAmount(50)
+	p.observe(client.EventPaymentSent, state)
+
+	var last ProgressEvent
+	for i := 0; i < 3; i++ {
+		last = <-p.ch
+	}
+	require.Equal(t, deal.ID(1), last.Deal)
+	require.Equal(t, peer.ID("provider"), last.Provider)
+	require.Equal(t, uint64(500), last.Received)
+	require.Equal(t, 2, last.Blocks)
+	require.Equal(t, 1, last.Vouchers)
+	require.Equal(t, abi.NewTokenAmount(50), last.Spent)
+	require.Equal(t, "ClientEventPaymentSent", last.Status)
+	require.Equal(t, 0.5, last.Fraction())
+
+	// A slow consumer doesn't block the events
+	for i := 0; i < progressBuffer+5; i++ {
+		p.observe(client.EventBlocksReceived, state)
+	}
+	require.Len(t, p.ch, progressBuffer)
+}
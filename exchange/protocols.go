@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/metrics"
+)
+
+// ProtocolVersion is a version of a protocol we speak. Deprecated versions have a sunset after which
+// we stop serving them.
+type ProtocolVersion struct {
+	ID protocol.ID
+	// Sunset is when we stop speaking a deprecated version. It is zero for the current version.
+	Sunset time.Time
+}
+
+// Protocols lists the versions of a protocol from the current one to the oldest one. When a message
+// format changes the previous version is kept until its sunset so peers which haven't upgraded yet
+// can still reach us and the cache mesh doesn't partition during network upgrades.
+type Protocols []ProtocolVersion
+
+// Current returns the latest version of the protocol
+func (ps Protocols) Current() protocol.ID {
+	return ps[0].ID
+}
+
+// Active returns the versions we still speak at the given time from the newest to the oldest so
+// streams are negotiated with the newest version both peers support
+func (ps Protocols) Active(now time.Time) []protocol.ID {
+	var ids []protocol.ID
+	for _, v := range ps {
+		if v.Sunset.IsZero() || now.Before(v.Sunset) {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
+
+// Deprecated returns whether a protocol ID is a previous version of the protocol
+func (ps Protocols) Deprecated(id protocol.ID) bool {
+	return id != ps.Current()
+}
+
+// SetStreamHandlers serves every active version of a protocol with the same handler. Handlers can tell
+// which version a stream was opened with from the stream protocol.
+func SetStreamHandlers(h host.Host, ps Protocols, handler network.StreamHandler) {
+	for _, id := range ps.Active(time.Now()) {
+		h.SetStreamHandler(id, func(s network.Stream) {
+			recordProtocol(ps, s)
+			handler(s)
+		})
+	}
+}
+
+// deprecatedPeers remembers the peers we exchanged with over a deprecated version of each protocol
+var deprecatedPeers = struct {
+	sync.Mutex
+	peers map[protocol.ID]map[peer.ID]bool
+}{peers: make(map[protocol.ID]map[peer.ID]bool)}
+
+// recordProtocol reports which version of a protocol a stream uses so operators can tell when
+// no peer relies on a deprecated version anymore
+func recordProtocol(ps Protocols, s network.Stream) {
+	id := s.Protocol()
+	metrics.ProtocolStreams.WithLabelValues(string(id)).Inc()
+	if !ps.Deprecated(id) {
+		return
+	}
+	deprecatedPeers.Lock()
+	defer deprecatedPeers.Unlock()
+	set, ok := deprecatedPeers.peers[id]
+	if !ok {
+		set = make(map[peer.ID]bool)
+		deprecatedPeers.peers[id] = set
+	}
+	set[s.Conn().RemotePeer()] = true
+	metrics.DeprecatedProtocolPeers.WithLabelValues(string(id)).Set(float64(len(set)))
+}
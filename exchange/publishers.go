@@ -0,0 +1,101 @@
+package exchange
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PublisherUsage reports how much of the cache is used by the content dispatched by a publisher
+type PublisherUsage struct {
+	Publisher peer.ID
+	Refs      int
+	Size      uint64
+}
+
+// WithPublisherShare caps the fraction of the upper bound the content of a single publisher can use so
+// a prolific publisher cannot monopolize a shared cache. Once a publisher goes over its share its least
+// frequently used content is evicted first. Content without a known publisher isn't capped.
+func WithPublisherShare(share float64) IndexOption {
+	return func(idx *Index) {
+		if share < 0 || share > 1 {
+			panic("publisher share must be between 0 and 1")
+		}
+		idx.pubShare = share
+	}
+}
+
+// Publishers returns the usage of every publisher whose content we cache, largest first
+func (idx *Index) Publishers() []PublisherUsage {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	usage := make([]PublisherUsage, 0, len(idx.pubs))
+	for _, u := range idx.pubs {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Size > usage[j].Size
+	})
+	return usage
+}
+
+// PublisherCap returns the maximum size the content of a single publisher can use or 0 if uncapped
+func (idx *Index) PublisherCap() uint64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.publisherCap()
+}
+
+func (idx *Index) publisherCap() uint64 {
+	return uint64(float64(idx.ub) * idx.pubShare)
+}
+
+// addUsage accounts for a new ref in the usage of its publisher and category, callers must hold the lock
+func (idx *Index) addUsage(ref *DataRef) {
+	idx.addCategoryUsage(ref)
+	if ref.Publisher == "" {
+		return
+	}
+	u, ok := idx.pubs[ref.Publisher]
+	if !ok {
+		u = &PublisherUsage{Publisher: ref.Publisher}
+		idx.pubs[ref.Publisher] = u
+	}
+	u.Refs++
+	u.Size += uint64(ref.PayloadSize)
+}
+
+// removeUsage removes a ref from the usage of its publisher and category, callers must hold the lock
+func (idx *Index) removeUsage(ref *DataRef) {
+	idx.removeCategoryUsage(ref)
+	u, ok := idx.pubs[ref.Publisher]
+	if !ok {
+		return
+	}
+	u.Refs--
+	u.Size -= uint64(ref.PayloadSize)
+	if u.Refs <= 0 {
+		delete(idx.pubs, ref.Publisher)
+	}
+}
+
+// overShare returns whether the content of a publisher uses more than its share, callers must hold the lock
+func (idx *Index) overShare(p peer.ID) bool {
+	limit := idx.publisherCap()
+	if limit == 0 || p == "" {
+		return false
+	}
+	u, ok := idx.pubs[p]
+	return ok && u.Size > limit
+}
+
+// enforceShare evicts the least frequently used content of a publisher until it is back under its
+// share, callers must hold the lock
+func (idx *Index) enforceShare(p peer.ID) {
+	if !idx.overShare(p) {
+		return
+	}
+	idx.evictWhere(idx.pubs[p].Size-idx.publisherCap(), func(ref *DataRef) bool {
+		return ref.Publisher == p
+	})
+}
@@ -0,0 +1,265 @@
+package exchange
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// KReceipts is the datastore namespace under which the receipts of the retrievals we paid for are persisted
+const KReceipts = "/receipts"
+
+// ErrInvalidReceipt is returned when the signature of a receipt doesn't match its content
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// RetrievalReceipt proves a retrieval we paid for. It is signed with the key of our peer ID so a consumer
+// can reconcile it against the invoices of the provider.
+type RetrievalReceipt struct {
+	Client   string `json:"client"`
+	Provider string `json:"provider"`
+	Root     string `json:"root"`
+	Deal     uint64 `json:"deal"`
+	// Bytes is the number of bytes received
+	Bytes uint64 `json:"bytes"`
+	// Paid is the total price in attoFIL
+	Paid string `json:"paid"`
+	// PayCh and Lane are where the vouchers were created, the last voucher of the lane redeems the total price
+	PayCh    string `json:"payCh"`
+	Lane     uint64 `json:"lane"`
+	Vouchers int    `json:"vouchers"`
+	// Time is when the retrieval completed in unix nanoseconds
+	Time      int64  `json:"time"`
+	Signature []byte `json:"signature"`
+}
+
+// payload is the JSON encoding of the receipt without its signature
+func (r RetrievalReceipt) payload() ([]byte, error) {
+	r.Signature = nil
+	return json.Marshal(r)
+}
+
+// Sign signs the receipt with the private key of the client
+func (r *RetrievalReceipt) Sign(sk crypto.PrivKey) error {
+	b, err := r.payload()
+	if err != nil {
+		return err
+	}
+	r.Signature, err = sk.Sign(b)
+	return err
+}
+
+// Verify checks the receipt was signed by its client
+func (r RetrievalReceipt) Verify() error {
+	pid, err := peer.Decode(r.Client)
+	if err != nil {
+		return err
+	}
+	pk, err := pid.ExtractPublicKey()
+	if err != nil {
+		return err
+	}
+	b, err := r.payload()
+	if err != nil {
+		return err
+	}
+	ok, err := pk.Verify(b, r.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: deal %d from %s", ErrInvalidReceipt, r.Deal, r.Provider)
+	}
+	return nil
+}
+
+// ReceiptQuery filters the receipts. Zero values match everything.
+type ReceiptQuery struct {
+	Provider peer.ID
+	Root     cid.Cid
+	Since    time.Time
+	Until    time.Time
+	// Limit is the maximum number of receipts returned, the most recent ones are kept
+	Limit int
+}
+
+func (q ReceiptQuery) match(r RetrievalReceipt) bool {
+	switch {
+	case q.Provider != "" && r.Provider != q.Provider.String():
+		return false
+	case q.Root.Defined() && r.Root != q.Root.String():
+		return false
+	case !q.Since.IsZero() && r.Time < q.Since.UnixNano():
+		return false
+	case !q.Until.IsZero() && r.Time > q.Until.UnixNano():
+		return false
+	}
+	return true
+}
+
+// Receipts keeps a signed receipt for every retrieval we paid for
+type Receipts struct {
+	ds datastore.Batching
+	id peer.ID
+	sk crypto.PrivKey
+
+	mu sync.Mutex
+	// vouchers counts the payments sent for the deals in progress
+	vouchers map[deal.ID]int
+}
+
+// NewReceipts creates a receipt store signing with the given identity
+func NewReceipts(ds datastore.Batching, id peer.ID, sk crypto.PrivKey) *Receipts {
+	return &Receipts{
+		ds:       namespace.Wrap(ds, datastore.NewKey(KReceipts)),
+		id:       id,
+		sk:       sk,
+		vouchers: make(map[deal.ID]int),
+	}
+}
+
+// receiptKey sorts the receipts by completion time
+func receiptKey(r RetrievalReceipt) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%020d-%d", r.Time, r.Deal))
+}
+
+// Put signs and persists a receipt
+func (rs *Receipts) Put(r RetrievalReceipt) (RetrievalReceipt, error) {
+	r.Client = rs.id.String()
+	if err := r.Sign(rs.sk); err != nil {
+		return r, err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	return r, rs.ds.Put(receiptKey(r), b)
+}
+
+// Query returns the receipts matching the query, oldest first
+func (rs *Receipts) Query(q ReceiptQuery) ([]RetrievalReceipt, error) {
+	var receipts []RetrievalReceipt
+	err := rs.each(func(r RetrievalReceipt) error {
+		if q.match(r) {
+			receipts = append(receipts, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(receipts) > q.Limit {
+		receipts = receipts[len(receipts)-q.Limit:]
+	}
+	return receipts, nil
+}
+
+// Export writes the receipts matching the query as JSON lines
+func (rs *Receipts) Export(w io.Writer, q ReceiptQuery) error {
+	receipts, err := rs.Query(q)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, r := range receipts {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rs *Receipts) each(fn func(RetrievalReceipt) error) error {
+	res, err := rs.ds.Query(dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		var r RetrievalReceipt
+		if err := json.Unmarshal(e.Value, &r); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyReceipts checks the signatures of exported receipts and returns how many there are
+func VerifyReceipts(rd io.Reader) (int, error) {
+	n := 0
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var r RetrievalReceipt
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return n, err
+		}
+		if err := r.Verify(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, sc.Err()
+}
+
+// recordClientDeal issues a receipt once a deal we paid for is completed
+func (rs *Receipts) recordClientDeal(event client.Event, state deal.ClientState) {
+	rs.mu.Lock()
+	n, ok := rs.vouchers[state.ID]
+	if event == client.EventOpen && !ok {
+		rs.vouchers[state.ID] = 0
+		ok = true
+	}
+	if event == client.EventPaymentSent && ok {
+		n++
+		rs.vouchers[state.ID] = n
+	}
+	final := false
+	switch state.Status {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored, deal.StatusRejected, deal.StatusDealNotFound:
+		final = ok
+		delete(rs.vouchers, state.ID)
+	}
+	rs.mu.Unlock()
+	// Free retrievals have nothing to prove
+	if !final || state.Status != deal.StatusCompleted || state.FundsSpent.Nil() || !state.FundsSpent.GreaterThan(big.Zero()) {
+		return
+	}
+	r := RetrievalReceipt{
+		Provider: state.Sender.String(),
+		Root:     state.PayloadCID.String(),
+		Deal:     uint64(state.ID),
+		Bytes:    state.TotalReceived,
+		Paid:     state.FundsSpent.String(),
+		Vouchers: n,
+		Time:     time.Now().UnixNano(),
+	}
+	if pi := state.PaymentInfo; pi != nil {
+		r.PayCh = pi.PayCh.String()
+		r.Lane = pi.Lane
+	}
+	if _, err := rs.Put(r); err != nil {
+		txLog.Warn().Err(err).Msg("failed to record retrieval receipt")
+	}
+}
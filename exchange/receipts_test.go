@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrievalReceipts(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(sk)
+	require.NoError(t, err)
+	rs := NewReceipts(dss.MutexWrap(datastore.NewMapDatastore()), id, sk)
+
+	provider := peer.ID("provider")
+	paid := deal.ClientState{
+		Proposal: deal.Proposal{ID: 1, PayloadCID: blockGen.Next().Cid()},
+		Sender:   provider,
+	}
+	rs.recordClientDeal(client.EventOpen, paid)
+	paid.Status = deal.StatusOngoing
+	rs.recordClientDeal(client.EventPaymentSent, paid)
+	rs.recordClientDeal(client.EventPaymentSent, paid)
+	paid.Status = deal.StatusCompleted
+	paid.TotalReceived = 1024
+	paid.FundsSpent = big.NewInt(2048)
+	rs.recordClientDeal(client.EventComplete, paid)
+
+	// Free retrievals don't get a receipt
+	free := deal.ClientState{
+		Proposal: deal.Proposal{ID: 2, PayloadCID: blockGen.Next().Cid()},
+		Sender:   provider,
+	}
+	rs.recordClientDeal(client.EventOpen, free)
+	free.Status = deal.StatusCompleted
+	free.FundsSpent = big.Zero()
+	rs.recordClientDeal(client.EventComplete, free)
+
+	receipts, err := rs.Query(ReceiptQuery{Provider: provider})
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	r := receipts[0]
+	require.Equal(t, id.String(), r.Client)
+	require.Equal(t, paid.PayloadCID.String(), r.Root)
+	require.Equal(t, "2048", r.Paid)
+	require.Equal(t, 2, r.Vouchers)
+	require.NoError(t, r.Verify())
+
+	// Receipts can't be edited without breaking the signature
+	r.Paid = "1"
+	require.True(t, errors.Is(r.Verify(), ErrInvalidReceipt))
+
+	var buf bytes.Buffer
+	require.NoError(t, rs.Export(&buf, ReceiptQuery{}))
+	n, err := VerifyReceipts(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
@@ -0,0 +1,309 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer"
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+)
+
+// ErrRecording is returned when starting a recording while another one is running
+var ErrRecording = errors.New("already recording")
+
+// DefaultRecordWindow is the duration of a recording if none is given
+const DefaultRecordWindow = 10 * time.Minute
+
+// Kinds of recorded events
+const (
+	// EventRecordStart opens a log with the identity of the node and the bounds of its index
+	EventRecordStart = "record.start"
+	// EventIndexSnapshot is the state of a ref when the recording started
+	EventIndexSnapshot = "index.snapshot"
+	// EventIndexSet is recorded when a ref is added or replaced
+	EventIndexSet = "index.set"
+	// EventIndexRead is recorded when a read moves a ref to a higher bucket
+	EventIndexRead = "index.read"
+	// EventIndexRemoteRead is recorded when serving a ref to a remote peer moves it to a higher bucket
+	EventIndexRemoteRead = "index.remoteread"
+	// EventIndexDrop is recorded when a ref is removed on purpose
+	EventIndexDrop = "index.drop"
+	// EventIndexEvict is recorded when the LFU evicts a ref to make room
+	EventIndexEvict = "index.evict"
+	// EventQuery is recorded when a peer queries our content, Name is the answer
+	EventQuery = "query"
+	// EventOffer is recorded when a provider answers one of our queries
+	EventOffer = "offer"
+	// EventHey is recorded when a peer greets us
+	EventHey = "hey"
+	// EventTransfer is a data transfer event, Name is the event name
+	EventTransfer = "transfer"
+	// EventClientDeal is a state transition of a retrieval deal we started
+	EventClientDeal = "deal.client"
+	// EventProviderDeal is a state transition of a retrieval deal we serve
+	EventProviderDeal = "deal.provider"
+	// EventStall is recorded when a stalled retrieval is restarted or cancelled, Name is the action
+	EventStall = "deal.stall"
+)
+
+// queryStatuses names the status of query responses
+var queryStatuses = map[deal.QueryResponseStatus]string{
+	deal.QueryResponseAvailable:   "available",
+	deal.QueryResponseUnavailable: "unavailable",
+	deal.QueryResponseError:       "error",
+}
+
+// Event is a protocol message or a state transition captured by a Recorder
+type Event struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Peer string    `json:"peer,omitempty"`
+	Root string    `json:"root,omitempty"`
+	Deal string    `json:"deal,omitempty"`
+	Size int64     `json:"size,omitempty"`
+	// Bucket is the LFU bucket of a ref after the event
+	Bucket int64 `json:"bucket,omitempty"`
+	// Name is the name of the event or of the state reached
+	Name   string `json:"name,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// Upper and Lower are the bounds of the index when the recording started
+	Upper uint64 `json:"upper,omitempty"`
+	Lower uint64 `json:"lower,omitempty"`
+}
+
+// Recorder writes the events of the exchange as JSON lines for a time window so they can be replayed
+// to understand what the node decided. It does nothing until started.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	enc   *json.Encoder
+	until time.Time
+	timer *time.Timer
+	seq   uint64
+}
+
+// Start records the events to the writer until the window elapses or Stop is called
+func (r *Recorder) Start(w io.WriteCloser, window time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w != nil {
+		return ErrRecording
+	}
+	r.w = w
+	r.enc = json.NewEncoder(w)
+	r.until = time.Now().Add(window)
+	r.seq = 0
+	r.timer = time.AfterFunc(window, func() {
+		r.Stop()
+	})
+	return nil
+}
+
+// Stop ends the recording and closes the writer
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stop()
+}
+
+func (r *Recorder) stop() error {
+	if r.w == nil {
+		return nil
+	}
+	r.timer.Stop()
+	err := r.w.Close()
+	r.w = nil
+	r.enc = nil
+	return err
+}
+
+// Recording returns the time until which events are recorded if a recording is running
+func (r *Recorder) Recording() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.until, r.w != nil
+}
+
+// record appends an event to the log if a recording is running
+func (r *Recorder) record(e Event) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil {
+		return
+	}
+	e.Time = time.Now()
+	if e.Time.After(r.until) {
+		r.stop()
+		return
+	}
+	r.seq++
+	e.Seq = r.seq
+	if err := r.enc.Encode(e); err != nil {
+		r.stop()
+	}
+}
+
+// recordTransfer captures data transfer events for dispatches and retrievals
+func (r *Recorder) recordTransfer(event datatransfer.Event, state datatransfer.ChannelState) {
+	r.record(Event{
+		Kind:   EventTransfer,
+		Peer:   state.OtherPeer().String(),
+		Root:   state.BaseCID().String(),
+		Deal:   state.ChannelID().String(),
+		Size:   int64(state.Received() + state.Sent()),
+		Name:   datatransfer.Events[event.Code],
+		Detail: event.Message,
+	})
+}
+
+// recordClientDeal captures the state transitions of our retrievals
+func (r *Recorder) recordClientDeal(event client.Event, state deal.ClientState) {
+	r.record(Event{
+		Kind:   EventClientDeal,
+		Peer:   state.Sender.String(),
+		Root:   state.PayloadCID.String(),
+		Deal:   state.ID.String(),
+		Size:   int64(state.TotalReceived),
+		Name:   client.Events[event],
+		Detail: deal.Statuses[state.Status],
+	})
+}
+
+// recordProviderDeal captures the state transitions of the retrievals we serve
+func (r *Recorder) recordProviderDeal(event provider.Event, state deal.ProviderState) {
+	r.record(Event{
+		Kind:   EventProviderDeal,
+		Peer:   state.Receiver.String(),
+		Root:   state.PayloadCID.String(),
+		Deal:   state.ID.String(),
+		Size:   int64(state.TotalSent),
+		Name:   provider.Events[event],
+		Detail: deal.Statuses[state.Status],
+	})
+}
+
+// recordHeys captures the greetings of the peers sharing our regions
+func (r *Recorder) recordHeys(ctx context.Context, bus event.Bus) error {
+	sub, err := bus.Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt := <-sub.Out():
+				hey := evt.(HeyEvt)
+				e := Event{Kind: EventHey, Peer: hey.Peer.String()}
+				if hey.IndexRoot != nil {
+					e.Root = hey.IndexRoot.String()
+				}
+				r.record(e)
+			}
+		}
+	}()
+	return nil
+}
+
+// recordQuery wraps a query handler to capture the queries we receive and how we answered them
+func (r *Recorder) recordQuery(fn ResponseFunc) ResponseFunc {
+	return func(ctx context.Context, p peer.ID, rg Region, q deal.Query) (deal.QueryResponse, error) {
+		res, err := fn(ctx, p, rg, q)
+		e := Event{
+			Kind:   EventQuery,
+			Peer:   p.String(),
+			Root:   q.PayloadCID.String(),
+			Size:   int64(res.Size),
+			Name:   "offered",
+			Detail: rg.Name,
+		}
+		if err != nil {
+			e.Name = "unavailable"
+		}
+		r.record(e)
+		return res, err
+	}
+}
+
+// recordOffer captures an offer received for one of our queries
+func (r *Recorder) recordOffer(p peer.AddrInfo, res deal.QueryResponse, root string) {
+	r.record(Event{
+		Kind:   EventOffer,
+		Peer:   p.ID.String(),
+		Root:   root,
+		Size:   int64(res.Size),
+		Name:   queryStatuses[res.Status],
+		Detail: res.MinPricePerByte.String(),
+	})
+}
+
+// startRecording starts a recording from the current state of the index so the log can be replayed.
+// Refs still loading in the background after a fast start are missing from the snapshot.
+func (idx *Index) startRecording(r *Recorder, p peer.ID, start func() error) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := start(); err != nil {
+		return err
+	}
+	r.record(Event{
+		Kind:  EventRecordStart,
+		Peer:  p.String(),
+		Upper: idx.ub,
+		Lower: idx.lb,
+	})
+	for e := idx.blist.Front(); e != nil; e = e.Next() {
+		for ref := range e.Value.(*bucket).entries {
+			r.recordRef(EventIndexSnapshot, ref, idx.refSize(ref))
+		}
+	}
+	return nil
+}
+
+// recordRef captures a change of a ref in the index
+func (r *Recorder) recordRef(kind string, ref *DataRef, size uint64) {
+	r.record(Event{
+		Kind:   kind,
+		Peer:   publisherString(ref.Publisher),
+		Root:   ref.PayloadCID.String(),
+		Size:   int64(size),
+		Bucket: ref.BucketID,
+	})
+}
+
+// publisherString formats an optional peer ID
+func publisherString(p peer.ID) string {
+	if p == "" {
+		return ""
+	}
+	return p.String()
+}
+
+// ReadEvents decodes a log written by a Recorder
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	dec := json.NewDecoder(r)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
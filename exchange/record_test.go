@@ -0,0 +1,115 @@
+package exchange
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	rec := &Recorder{}
+	idx, err := NewIndex(ds, ms, WithBounds(512000, 500000), WithRecorder(rec))
+	require.NoError(t, err)
+
+	// Changes before the recording are only in the snapshot
+	ref1 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 256000,
+	}
+	require.NoError(t, idx.SetRef(ref1))
+
+	buf := &closeBuffer{}
+	start := func() error { return rec.Start(buf, time.Minute) }
+	require.NoError(t, idx.startRecording(rec, peer.ID("node"), start))
+	require.Equal(t, ErrRecording, idx.startRecording(rec, peer.ID("node"), start))
+
+	ref2 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 110000,
+	}
+	require.NoError(t, idx.SetRef(ref2))
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.GetRef(ref2.PayloadCID)
+	require.NoError(t, err)
+
+	// ref1 is evicted to make room
+	ref3 := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 356000,
+	}
+	require.NoError(t, idx.SetRef(ref3))
+
+	require.NoError(t, rec.Stop())
+	require.True(t, buf.closed)
+	_, recording := rec.Recording()
+	require.False(t, recording)
+
+	events, err := ReadEvents(&buf.Buffer)
+	require.NoError(t, err)
+	require.Len(t, events, 7)
+	require.Equal(t, EventIndexSnapshot, events[1].Kind)
+	require.Equal(t, ref1.PayloadCID.String(), events[1].Root)
+
+	rep, err := Replay(events)
+	require.NoError(t, err)
+	require.Equal(t, uint64(512000), rep.Upper)
+	require.Equal(t, 2, rep.Refs)
+	require.Equal(t, uint64(466000), rep.Size)
+
+	var kinds []string
+	for _, d := range rep.Decisions {
+		kinds = append(kinds, d.Kind)
+		require.Empty(t, d.Note)
+	}
+	require.Equal(t, []string{EventRecordStart, EventIndexSet, EventIndexEvict, EventIndexSet}, kinds)
+	require.Contains(t, rep.Decisions[2].Summary, ref1.PayloadCID.String())
+
+	// Missing events are pointed out
+	rep, err = Replay(append(events[:5:5], events[6:]...))
+	require.NoError(t, err)
+	require.Len(t, rep.Decisions, 3)
+	require.Equal(t, "1 events missing before this one", rep.Decisions[2].Note)
+
+	// Replay needs the snapshot
+	_, err = Replay(events[2:])
+	require.Equal(t, ErrNoRecordStart, err)
+}
+
+func TestRecorderWindow(t *testing.T) {
+	rec := &Recorder{}
+	buf := &closeBuffer{}
+	require.NoError(t, rec.Start(buf, 10*time.Millisecond))
+	_, recording := rec.Recording()
+	require.True(t, recording)
+
+	require.Eventually(t, func() bool {
+		_, recording := rec.Recording()
+		return !recording
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, buf.closed)
+
+	// Events outside a recording are dropped
+	rec.record(Event{Kind: EventHey})
+	require.Zero(t, buf.Len())
+}
@@ -0,0 +1,146 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrNoRecordStart is returned when replaying a log which doesn't begin with the start of a recording
+var ErrNoRecordStart = errors.New("log does not start with a recording")
+
+// Decision is a choice of the node or a state transition reconstructed from a recording
+type Decision struct {
+	Seq     uint64
+	Time    time.Time
+	Kind    string
+	Summary string
+	// Note explains why a decision may be unexpected given the reconstructed state
+	Note string
+}
+
+// ReplayReport is the outcome of replaying a recording
+type ReplayReport struct {
+	Peer      string
+	Decisions []Decision
+	// Refs and Size describe the index at the end of the recording
+	Refs int
+	Size uint64
+	// Upper is the upper bound of the index
+	Upper uint64
+}
+
+// replayRef is the state of a ref reconstructed from a recording
+type replayRef struct {
+	size   uint64
+	bucket int64
+}
+
+// Replay rebuilds the index from the snapshot at the start of a recording and applies every event in
+// order to reconstruct the decisions of the node. Evictions are checked against the reconstructed LFU
+// buckets to point out content which was kept although it was less frequently used.
+func Replay(events []Event) (*ReplayReport, error) {
+	events = append([]Event(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+	if len(events) == 0 || events[0].Kind != EventRecordStart {
+		return nil, ErrNoRecordStart
+	}
+
+	rep := &ReplayReport{
+		Peer:  events[0].Peer,
+		Upper: events[0].Upper,
+	}
+	refs := make(map[string]*replayRef)
+	var size uint64
+	remove := func(root string) (*replayRef, bool) {
+		ref, ok := refs[root]
+		if ok {
+			size -= ref.size
+			delete(refs, root)
+		}
+		return ref, ok
+	}
+	decide := func(e Event, note string, format string, args ...interface{}) {
+		rep.Decisions = append(rep.Decisions, Decision{
+			Seq:     e.Seq,
+			Time:    e.Time,
+			Kind:    e.Kind,
+			Summary: fmt.Sprintf(format, args...),
+			Note:    note,
+		})
+	}
+
+	var last uint64
+	for _, e := range events {
+		note := ""
+		if last > 0 && e.Seq != last+1 {
+			note = fmt.Sprintf("%d events missing before this one", e.Seq-last-1)
+		}
+		last = e.Seq
+
+		switch e.Kind {
+		case EventRecordStart:
+			decide(e, note, "recording started on %s with index bounds %d-%d bytes", e.Peer, e.Lower, e.Upper)
+		case EventIndexSnapshot:
+			remove(e.Root)
+			refs[e.Root] = &replayRef{size: uint64(e.Size), bucket: e.Bucket}
+			size += uint64(e.Size)
+		case EventIndexSet:
+			remove(e.Root)
+			refs[e.Root] = &replayRef{size: uint64(e.Size), bucket: e.Bucket}
+			size += uint64(e.Size)
+			decide(e, note, "cached %s (%d bytes) in bucket %d, index at %d of %d bytes", e.Root, e.Size, e.Bucket, size, rep.Upper)
+		case EventIndexRead, EventIndexRemoteRead:
+			if ref, ok := refs[e.Root]; ok {
+				ref.bucket = e.Bucket
+			}
+		case EventIndexDrop:
+			remove(e.Root)
+			decide(e, note, "dropped %s, index at %d bytes", e.Root, size)
+		case EventIndexEvict:
+			before := size
+			ref, ok := remove(e.Root)
+			if !ok {
+				ref = &replayRef{size: uint64(e.Size), bucket: e.Bucket}
+				if note == "" {
+					note = "ref was not in the reconstructed index"
+				}
+			}
+			if note == "" {
+				if kept := lowerBuckets(refs, ref.bucket); kept > 0 {
+					note = fmt.Sprintf("%d refs in lower buckets were kept: leased, vetoed, superseded content first or within their publisher share", kept)
+				}
+			}
+			decide(e, note, "evicted %s (%d bytes) from bucket %d, index at %d of %d bytes", e.Root, e.Size, ref.bucket, before, rep.Upper)
+		case EventQuery:
+			decide(e, note, "answered query from %s for %s: %s", e.Peer, e.Root, e.Name)
+		case EventOffer:
+			decide(e, note, "received offer from %s for %s (%d bytes): %s", e.Peer, e.Root, e.Size, e.Name)
+		case EventHey:
+			decide(e, note, "greeted by %s", e.Peer)
+		case EventTransfer:
+			decide(e, note, "transfer %s with %s for %s: %s", e.Deal, e.Peer, e.Root, e.Name)
+		case EventClientDeal, EventProviderDeal:
+			decide(e, note, "deal %s with %s for %s: %s -> %s", e.Deal, e.Peer, e.Root, e.Name, e.Detail)
+		default:
+			decide(e, note, "unknown event %s", e.Kind)
+		}
+	}
+	rep.Refs = len(refs)
+	rep.Size = size
+	return rep, nil
+}
+
+// lowerBuckets counts the refs in a lower bucket than the given one
+func lowerBuckets(refs map[string]*replayRef, bucket int64) int {
+	n := 0
+	for _, ref := range refs {
+		if ref.bucket < bucket {
+			n++
+		}
+	}
+	return n
+}
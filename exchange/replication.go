@@ -3,6 +3,7 @@ package exchange
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -22,19 +23,45 @@ import (
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/metrics"
+	"github.com/myelnet/pop/retrieval"
 	sel "github.com/myelnet/pop/selectors"
 )
 
-//go:generate cbor-gen-for Request
+//go:generate cbor-gen-for Request requestV1
 
 // PopRequestProtocolID is the protocol for requesting caches to store new content
-const PopRequestProtocolID = protocol.ID("/myel/pop/request/1.0")
+const PopRequestProtocolID = protocol.ID("/myel/pop/request/1.1")
+
+// PopRequestProtocolV1 is the deprecated version of the request protocol which only supports dispatching
+// content without a manifest
+const PopRequestProtocolV1 = protocol.ID("/myel/pop/request/1.0")
+
+// PopRequestProtocols are the versions of the request protocol we speak
+var PopRequestProtocols = Protocols{
+	{ID: PopRequestProtocolID},
+	{ID: PopRequestProtocolV1, Sunset: time.Date(2027, time.February, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+// ErrDeprecatedProtocol is returned when a request cannot be sent to a peer which only speaks
+// a deprecated version of the protocol
+var ErrDeprecatedProtocol = errors.New("request not supported by deprecated protocol")
+
+// RelayTTL is how long a relay cache holds a dispatch for a peer which hasn't come back online
+const RelayTTL = 24 * time.Hour
 
 // Request describes the content to pull
 type Request struct {
 	Method     Method
 	PayloadCID cid.Cid
 	Size       uint64
+	// Target is the peer a relay should forward the content to or the provider
+	// who received it in a receipt
+	Target peer.ID
+	// Path lists the peers the content went through starting with the publisher
+	Path []peer.ID
+	// Manifest is required for content gated behind the signatures of multiple publishers
+	Manifest *Manifest
 }
 
 // Type defines Request as a datatransfer voucher for pulling the data from the request
@@ -42,6 +69,13 @@ func (Request) Type() datatransfer.TypeIdentifier {
 	return "ReplicationRequestVoucher"
 }
 
+// requestV1 is the request message exchanged over PopRequestProtocolV1
+type requestV1 struct {
+	Method     Method
+	PayloadCID cid.Cid
+	Size       uint64
+}
+
 // Method is the replication request method
 type Method uint64
 
@@ -50,6 +84,10 @@ const (
 	Dispatch Method = iota
 	// FetchIndex is a request from one content provider to another to retrieve their index
 	FetchIndex
+	// Relay asks a cache which already received the content to hold it until the target comes online
+	Relay
+	// Receipt notifies the publisher that a relayed dispatch was received by the target
+	Receipt
 )
 
 // IndexEvt is emitted when a new index is loaded in the replication service
@@ -62,10 +100,19 @@ type RequestStream struct {
 	p   peer.ID
 	rw  mux.MuxedStream
 	buf *bufio.Reader
+	// proto is the version of the protocol the stream was opened with
+	proto protocol.ID
 }
 
 // ReadRequest reads and decodes a CBOR encoded Request message from a stream buffer
 func (rs *RequestStream) ReadRequest() (Request, error) {
+	if rs.proto == PopRequestProtocolV1 {
+		var m requestV1
+		if err := m.UnmarshalCBOR(rs.buf); err != nil {
+			return Request{}, err
+		}
+		return Request{Method: m.Method, PayloadCID: m.PayloadCID, Size: m.Size}, nil
+	}
 	var m Request
 	if err := m.UnmarshalCBOR(rs.buf); err != nil {
 		return Request{}, err
@@ -75,6 +122,12 @@ func (rs *RequestStream) ReadRequest() (Request, error) {
 
 // WriteRequest encodes and writes a Request message to a stream
 func (rs *RequestStream) WriteRequest(m Request) error {
+	if rs.proto == PopRequestProtocolV1 {
+		if m.Method > FetchIndex || m.Manifest != nil {
+			return ErrDeprecatedProtocol
+		}
+		return cborutil.WriteCborRPC(rs.rw, &requestV1{Method: m.Method, PayloadCID: m.PayloadCID, Size: m.Size})
+	}
 	return cborutil.WriteCborRPC(rs.rw, &m)
 }
 
@@ -105,14 +158,36 @@ type Replication struct {
 	reqProtos []protocol.ID
 	emitter   event.Emitter
 	indexRcvd chan struct{}
+	// announced receives the index roots of peers whose diffs we couldn't apply
+	announced chan HeyEvt
 	interval  time.Duration
 	rtv       RoutedRetriever
+	guard     *metrics.Guard
+	// bw meters and limits the blocks replicated
+	bw *metrics.Bandwidth
+	// auto retrieves popular content from the interest list
+	auto *AutoReplicator
+	// fan shares the blocks of content dispatched to several providers at once
+	fan *fanout
+	// market decides whether we host the content dispatched to us
+	market HostingMarket
 
 	pmu   sync.Mutex
 	pulls map[cid.Cid]*peer.Set
 
 	smu    sync.Mutex
 	stores map[cid.Cid]*multistore.Store
+
+	rmu      sync.Mutex
+	receipts map[receiptKey]chan PRecord
+	relays   map[peer.ID]map[cid.Cid]*relay
+}
+
+// relay is a dispatch held on behalf of a publisher until the target can pull it
+type relay struct {
+	req     Request
+	sent    bool
+	created time.Time
 }
 
 // NewReplication starts the exchange replication management system
@@ -126,15 +201,22 @@ func NewReplication(h host.Host, idx *Index, dt datatransfer.Manager, rtv Routed
 		idx:       idx,
 		rtv:       rtv,
 		interval:  60 * time.Second,
-		reqProtos: []protocol.ID{PopRequestProtocolID},
+		auto:      NewAutoReplicator(idx, rtv, 0, DefaultMaxReplications),
+		fan:       newFanout(),
+		reqProtos: PopRequestProtocols.Active(time.Now()),
 		pulls:     make(map[cid.Cid]*peer.Set),
 		indexRcvd: make(chan struct{}),
+		announced: make(chan HeyEvt, 16),
 		stores:    make(map[cid.Cid]*multistore.Store),
+		receipts:  make(map[receiptKey]chan PRecord),
+		relays:    make(map[peer.ID]map[cid.Cid]*relay),
 	}
 	r.hs = NewHeyService(h, pm, r)
-	h.SetStreamHandler(PopRequestProtocolID, r.handleRequest)
+	SetStreamHandlers(h, PopRequestProtocols, r.handleRequest)
 	r.dt.RegisterVoucherType(&Request{}, r)
-	r.dt.RegisterTransportConfigurer(&Request{}, TransportConfigurer(r.idx, r, h.ID()))
+	// Channels ending cancel the transfers waiting for the bandwidth limits
+	tc := retrieval.NewTransferContexts(context.Background(), dt)
+	r.dt.RegisterTransportConfigurer(&Request{}, TransportConfigurer(r.idx, r, h.ID(), tc))
 	r.emitter, _ = h.EventBus().Emitter(new(IndexEvt))
 
 	// TODO: clean this up
@@ -143,6 +225,13 @@ func NewReplication(h host.Host, idx *Index, dt datatransfer.Manager, rtv Routed
 			// If transfers fail and we're the recipient we need to remove it from our index
 			r.idx.DropRef(channelState.BaseCID())
 		}
+		if channelState.Status() == datatransfer.Completed && channelState.Sender() == h.ID() {
+			r.completeRelay(channelState.Recipient(), channelState.BaseCID())
+		}
+		if channelState.Status() == datatransfer.Completed && channelState.Recipient() == h.ID() {
+			// Record the entry names so they can be searched by peers who load our index
+			r.recordKeys(channelState.BaseCID())
+		}
 	})
 
 	return r
@@ -157,6 +246,13 @@ func (r *Replication) Start(ctx context.Context) error {
 	// Any time we receive a new index, check if any refs should be added to our supply
 	go r.refreshIndex(ctx)
 	go r.pumpIndexes(ctx, sub)
+	rsub, err := r.h.EventBus().Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	if err != nil {
+		return err
+	}
+	// Any time a peer comes back online, check if we're holding content for them
+	go r.pumpRelays(ctx, rsub)
+	r.pm.Start(ctx)
 	if err := r.hs.Run(ctx); err != nil {
 		return err
 	}
@@ -169,6 +265,17 @@ func (r *Replication) Start(ctx context.Context) error {
 func (r *Replication) pumpIndexes(ctx context.Context, sub event.Subscription) {
 	var q []HeyEvt
 	var fetchDone chan fetchResult
+	enqueue := func(hevt HeyEvt) {
+		if fetchDone == nil {
+			fetchDone = make(chan fetchResult, 1)
+			go func() {
+				err := r.fetchIndex(ctx, hevt)
+				fetchDone <- fetchResult{*hevt.IndexRoot, err}
+			}()
+			return
+		}
+		q = append(q, hevt)
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -176,16 +283,10 @@ func (r *Replication) pumpIndexes(ctx context.Context, sub event.Subscription) {
 		case evt := <-sub.Out():
 			hevt := evt.(HeyEvt)
 			if hevt.IndexRoot != nil {
-				if fetchDone == nil {
-					fetchDone = make(chan fetchResult, 1)
-					go func() {
-						err := r.fetchIndex(ctx, hevt)
-						fetchDone <- fetchResult{*hevt.IndexRoot, err}
-					}()
-					continue
-				}
-				q = append(q, hevt)
+				enqueue(hevt)
 			}
+		case hevt := <-r.announced:
+			enqueue(hevt)
 			// We can probably ignore errors
 		case res := <-fetchDone:
 			if res.err == nil {
@@ -193,7 +294,7 @@ func (r *Replication) pumpIndexes(ctx context.Context, sub event.Subscription) {
 					store := r.GetStore(rt)
 					err := r.idx.LoadInterest(rt, cbor.NewCborStore(store.Bstore))
 					if err != nil {
-						fmt.Println("failed to load interest", err)
+						indexLog.Error().Err(err).Str("root", rt.String()).Msg("failed to load interest")
 						return
 					}
 				}(res.root)
@@ -210,37 +311,48 @@ func (r *Replication) pumpIndexes(ctx context.Context, sub event.Subscription) {
 	}
 }
 
-// refreshIndex is a long running process that regularly inspects received indexes
-// and if usage is high enough retrieves the content at market price
+// queueIndex schedules fetching the full index of a peer, it is dropped if too many are already waiting
+// as the peer will announce another snapshot later
+func (r *Replication) queueIndex(p peer.ID, root cid.Cid) {
+	select {
+	case r.announced <- HeyEvt{Peer: p, IndexRoot: &root}:
+	default:
+	}
+}
+
+// refreshIndex is a long running process that regularly checks if the interest list has content
+// worth retrieving at market price in case the index isn't read often
 func (r *Replication) refreshIndex(ctx context.Context) {
+	r.auto.retrieved = func(k cid.Cid) {
+		r.emitter.Emit(IndexEvt{
+			Root: k,
+		})
+	}
+	go r.auto.Run(ctx)
 	ticker := time.NewTicker(r.interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			refs, err := r.idx.Interesting()
-			if err != nil || len(refs) == 0 {
-				continue
-			}
-			fmt.Println("tick", r.h.ID(), len(refs))
-
-			for ref := range refs {
-				// let's get it
-				err := r.rtv.FindAndRetrieve(ctx, ref.PayloadCID)
-				if err != nil {
-					continue
-				}
-				err = r.idx.DropInterest(ref.PayloadCID)
-				r.emitter.Emit(IndexEvt{
-					Root: ref.PayloadCID,
-				})
+			r.auto.Trigger()
+			if err := r.idx.FlushInterest(); err != nil {
+				indexLog.Error().Err(err).Msg("failed to flush interest list")
 			}
 		case <-ctx.Done():
+			// Persist the latest changes before shutting down
+			if err := r.idx.FlushInterest(); err != nil {
+				indexLog.Error().Err(err).Msg("failed to flush interest list")
+			}
 			return
 		}
 	}
 }
 
+// Replicate asks the auto replication to check if any content from the interest list should be retrieved
+func (r *Replication) Replicate() {
+	r.auto.Trigger()
+}
+
 // fetchResult associates the root of the index fetched and a possible error
 type fetchResult struct {
 	root cid.Cid
@@ -270,10 +382,13 @@ func (r *Replication) fetchIndex(ctx context.Context, hvt HeyEvt) error {
 		PayloadCID: rcid,
 	}
 
-	store, err := r.idx.ms.Get(r.idx.ms.Next())
+	storeID := r.idx.ms.Next()
+	store, err := r.idx.ms.Get(storeID)
 	if err != nil {
 		return err
 	}
+	// The fetched index is read from this store when loading the interest list
+	r.idx.hold(storeID)
 	r.smu.Lock()
 	r.stores[rcid] = store
 	r.smu.Unlock()
@@ -303,13 +418,14 @@ func (r *Replication) GetStore(k cid.Cid) *multistore.Store {
 // GetHey formats a new Hey message
 func (r *Replication) GetHey() Hey {
 	regions := make([]RegionCode, len(r.rgs))
-	i := 0
-	for _, rg := range r.rgs {
+	names := make([]string, len(r.rgs))
+	for i, rg := range r.rgs {
 		regions[i] = rg.Code
-		i++
+		names[i] = rg.Name
 	}
 	h := Hey{
-		Regions: regions,
+		Regions:     regions,
+		RegionNames: names,
 	}
 	idxr := r.idx.Root()
 	if idxr != cid.Undef {
@@ -324,14 +440,15 @@ func (r *Replication) NewRequestStream(dest peer.ID) (*RequestStream, error) {
 	if err != nil {
 		return nil, err
 	}
+	recordProtocol(PopRequestProtocols, s)
 	buf := bufio.NewReaderSize(s, 16)
-	return &RequestStream{p: dest, rw: s, buf: buf}, nil
+	return &RequestStream{p: dest, rw: s, buf: buf, proto: s.Protocol()}, nil
 }
 
 func (r *Replication) handleRequest(s network.Stream) {
 	p := s.Conn().RemotePeer()
 	buffered := bufio.NewReaderSize(s, 16)
-	rs := &RequestStream{p, s, buffered}
+	rs := &RequestStream{p, s, buffered, s.Protocol()}
 	defer rs.Close()
 	req, err := rs.ReadRequest()
 	if err != nil {
@@ -341,6 +458,33 @@ func (r *Replication) handleRequest(s network.Stream) {
 	switch req.Method {
 	case Dispatch:
 		// TODO: validate request
+		// Don't accept new content if we're running out of resources
+		if err := r.guard.Check(); err != nil {
+			return
+		}
+		// Content published with a manifest is only accepted if enough publishers signed it
+		if req.Manifest != nil {
+			if req.Manifest.Root != req.PayloadCID {
+				return
+			}
+			if err := req.Manifest.Verify(r.h.Peerstore()); err != nil {
+				return
+			}
+		}
+		// If the content was relayed the publisher is the first peer in the path
+		publisher := p
+		if len(req.Path) > 0 {
+			publisher = req.Path[0]
+		}
+		if !bid(context.TODO(), r.market, HostingRequest{
+			Root:      req.PayloadCID,
+			Size:      req.Size,
+			Source:    Dispatched,
+			Peer:      p,
+			Publisher: publisher,
+		}) {
+			return
+		}
 		// Create a new store to receive our new blocks
 		// It will be automatically picked up in the TransportConfigurer
 		storeID := r.idx.ms.Next()
@@ -348,14 +492,193 @@ func (r *Replication) handleRequest(s network.Stream) {
 			PayloadCID:  req.PayloadCID,
 			PayloadSize: int64(req.Size),
 			StoreID:     storeID,
+			Publisher:   publisher,
 		})
 		if err != nil {
 			return
 		}
+		if req.Manifest != nil {
+			if err := r.idx.PutManifest(req.Manifest); err != nil {
+				return
+			}
+		}
 		_, err = r.dt.OpenPullDataChannel(context.TODO(), p, &req, req.PayloadCID, sel.All())
 		if err != nil {
 			return
 		}
+	case Relay:
+		if err := r.guard.Check(); err != nil {
+			return
+		}
+		r.holdRelay(p, req)
+	case Receipt:
+		r.receiveReceipt(p, req)
+	}
+}
+
+// holdRelay records a dispatch to forward to the target once it can be reached
+func (r *Replication) holdRelay(p peer.ID, req Request) {
+	ref, err := r.idx.PeekRef(req.PayloadCID)
+	if err != nil {
+		return
+	}
+	// Only the peer who dispatched the content to us can ask us to relay it
+	if ref.Publisher != p || req.Target == "" {
+		return
+	}
+	fwd := Request{
+		Method:     Dispatch,
+		PayloadCID: req.PayloadCID,
+		Size:       req.Size,
+		Path:       append(req.Path, r.h.ID()),
+		Manifest:   req.Manifest,
+	}
+	r.rmu.Lock()
+	if _, ok := r.relays[req.Target]; !ok {
+		r.relays[req.Target] = make(map[cid.Cid]*relay)
+	}
+	r.relays[req.Target][req.PayloadCID] = &relay{req: fwd, created: time.Now()}
+	r.rmu.Unlock()
+
+	// The target may be reachable from here already
+	go r.forwardRelays(req.Target)
+}
+
+// forwardRelays sends the dispatch requests we are holding for a given peer
+func (r *Replication) forwardRelays(p peer.ID) {
+	r.rmu.Lock()
+	var pending []*relay
+	for _, rl := range r.relays[p] {
+		if !rl.sent {
+			pending = append(pending, rl)
+		}
+	}
+	r.rmu.Unlock()
+
+	for _, rl := range pending {
+		r.AuthorizePull(rl.req.PayloadCID, p)
+		if err := r.sendRequest(p, rl.req); err != nil {
+			continue
+		}
+		r.rmu.Lock()
+		rl.sent = true
+		r.rmu.Unlock()
+	}
+}
+
+// completeRelay sends a receipt to the publisher once the target pulled the content we held for them
+func (r *Replication) completeRelay(p peer.ID, k cid.Cid) {
+	r.rmu.Lock()
+	rl, ok := r.relays[p][k]
+	if ok {
+		delete(r.relays[p], k)
+		if len(r.relays[p]) == 0 {
+			delete(r.relays, p)
+		}
+	}
+	r.rmu.Unlock()
+	if !ok {
+		return
+	}
+	go func() {
+		err := r.sendRequest(rl.req.Path[0], Request{
+			Method:     Receipt,
+			PayloadCID: k,
+			Size:       rl.req.Size,
+			Target:     p,
+			Path:       rl.req.Path,
+		})
+		if err != nil {
+			txLog.Warn().Err(err).Str("peer", p.String()).Msg("failed to send relay receipt")
+		}
+	}()
+}
+
+// recordKeys saves the names of the entries of a root we received in its ref
+func (r *Replication) recordKeys(k cid.Cid) {
+	keys, err := r.idx.LoadKeys(k)
+	if err != nil || len(keys) == 0 {
+		return
+	}
+	_ = r.idx.UpdateRef(k, func(ref *DataRef) {
+		ref.Keys = keys
+	})
+}
+
+// receiptKey identifies the receipt a Dispatch waits for once it relayed content to a peer
+type receiptKey struct {
+	root   cid.Cid
+	target peer.ID
+}
+
+// expectReceipt forwards the receipt of a relay to a target to the channel of a Dispatch
+func (r *Replication) expectReceipt(root cid.Cid, target peer.ID, ch chan PRecord) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	r.receipts[receiptKey{root: root, target: target}] = ch
+}
+
+// forgetReceipt stops waiting for the receipt of a relay unless another Dispatch relayed to the same target
+func (r *Replication) forgetReceipt(root cid.Cid, target peer.ID, ch chan PRecord) {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	key := receiptKey{root: root, target: target}
+	if r.receipts[key] == ch {
+		delete(r.receipts, key)
+	}
+}
+
+// receiveReceipt forwards a receipt from a relay to the Dispatch operation waiting for it
+func (r *Replication) receiveReceipt(p peer.ID, req Request) {
+	// The receipt must come from the last relay in the path and be addressed to us
+	if len(req.Path) < 2 || req.Path[0] != r.h.ID() || req.Path[len(req.Path)-1] != p {
+		return
+	}
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	key := receiptKey{root: req.PayloadCID, target: req.Target}
+	ch, ok := r.receipts[key]
+	if !ok {
+		return
+	}
+	delete(r.receipts, key)
+	rg, _ := r.pm.Region(req.Target, r.rgs)
+	select {
+	case ch <- PRecord{
+		Provider:   req.Target,
+		PayloadCID: req.PayloadCID,
+		Path:       req.Path[1:],
+		Region:     rg.Name,
+	}:
+	default:
+	}
+}
+
+// pumpRelays forwards the content we're holding whenever the target peer greets us
+// and regularly drops relays which have been waiting for too long
+func (r *Replication) pumpRelays(ctx context.Context, sub event.Subscription) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-sub.Out():
+			r.forwardRelays(evt.(HeyEvt).Peer)
+		case <-ticker.C:
+			r.rmu.Lock()
+			for p, rls := range r.relays {
+				for k, rl := range rls {
+					if time.Since(rl.created) > RelayTTL {
+						delete(rls, k)
+					}
+				}
+				if len(rls) == 0 {
+					delete(r.relays, p)
+				}
+			}
+			r.rmu.Unlock()
+		}
 	}
 }
 
@@ -363,6 +686,10 @@ func (r *Replication) handleRequest(s network.Stream) {
 type PRecord struct {
 	Provider   peer.ID
 	PayloadCID cid.Cid
+	// Path lists the relay caches the content went through before reaching the provider
+	Path []peer.ID
+	// Region is the name of the region the provider was selected in
+	Region string
 }
 
 // DispatchOptions exposes parameters to affect the duration of a Dispatch operation
@@ -370,6 +697,31 @@ type DispatchOptions struct {
 	BackoffMin     time.Duration
 	BackoffAttemps int
 	RF             int
+	// Relay asks caches which received the content to forward it to the peers we couldn't reach
+	Relay bool
+	// RelayTimeout is how long to wait for relay receipts once we're done backing off
+	RelayTimeout time.Duration
+	// Manifest is sent along with the request if the content requires signatures from multiple publishers
+	Manifest *Manifest
+	// Regions are selected first when looking for providers. We fall back to the other regions we joined
+	// if there aren't enough providers in them.
+	Regions []Region
+	// Exclude lists providers which must not be selected
+	Exclude []peer.ID
+	// Preferred lists providers selected before any other, we fall back to the providers in the regions
+	// for the ones we couldn't reach
+	Preferred []peer.ID
+	// Progress is called with the number of bytes sent to a provider every time its transfer progresses
+	Progress func(peer.ID, uint64)
+	// StallTimeout cancels the transfer to a provider if it makes no progress for this long so slow providers
+	// don't hold resources while the content is sent to the others. 0 never cancels transfers.
+	StallTimeout time.Duration
+	// Bandwidth is the number of bytes per second the transfers may use from our uplink, 0 is unlimited.
+	// Providers pull the content at the same time if the budget allows sending all the copies before the
+	// Deadline, one after the other otherwise.
+	Bandwidth uint64
+	// Deadline stops starting transfers which wouldn't complete before it with the Bandwidth budget
+	Deadline time.Time
 }
 
 // DefaultDispatchOptions provides useful defaults
@@ -378,6 +730,26 @@ var DefaultDispatchOptions = DispatchOptions{
 	BackoffMin:     2 * time.Second,
 	BackoffAttemps: 4,
 	RF:             6,
+	Relay:          true,
+	StallTimeout:   time.Minute,
+}
+
+// preferRegions lists the preferred regions first followed by the other regions we joined
+func preferRegions(preferred []Region, joined []Region) []Region {
+	rgs := append([]Region{}, preferred...)
+	for _, r := range joined {
+		found := false
+		for _, p := range preferred {
+			if p.Name == r.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rgs = append(rgs, r)
+		}
+	}
+	return rgs
 }
 
 // Dispatch to the network until we have propagated the content to enough peers
@@ -386,31 +758,83 @@ func (r *Replication) Dispatch(root cid.Cid, size uint64, opt DispatchOptions) c
 		Method:     Dispatch,
 		PayloadCID: root,
 		Size:       size,
+		Manifest:   opt.Manifest,
 	}
+	rgs := preferRegions(opt.Regions, r.rgs)
 	resChan := make(chan PRecord, opt.RF)
 	out := make(chan PRecord, opt.RF)
+	// Providers pulling the content at the same time share the blocks read from the store
+	r.fan.open(root)
+	tw := newTransferWatch()
+	pace := newDispatchPace(size, opt.RF, opt.Bandwidth, opt.Deadline)
+	if opt.Bandwidth > 0 {
+		r.fan.limit(root, opt.Bandwidth)
+	}
+	// freed receives the providers whose transfer failed so a paced dispatch can replace them right away
+	freed := make(chan peer.ID, opt.RF)
+	stop := make(chan struct{})
+	if opt.StallTimeout > 0 {
+		go r.cancelStalled(tw, opt.StallTimeout, stop)
+	}
 	// listen for datatransfer events to identify the peers who pulled the content
 	unsub := r.dt.SubscribeToEvents(func(event datatransfer.Event, chState datatransfer.ChannelState) {
-		if chState.Status() == datatransfer.Completed {
-			root := chState.BaseCID()
-			if root != req.PayloadCID {
+		if chState.BaseCID() != req.PayloadCID || !tw.owns(chState.Recipient()) {
+			return
+		}
+		switch event.Code {
+		case datatransfer.Open, datatransfer.Accept, datatransfer.DataSent:
+			tw.progress(chState.Recipient(), chState.ChannelID())
+			if event.Code == datatransfer.DataSent && opt.Progress != nil {
+				opt.Progress(chState.Recipient(), chState.Sent())
+			}
+		}
+		switch chState.Status() {
+		case datatransfer.Failed, datatransfer.Cancelled:
+			tw.done(chState.Recipient())
+			// Let the pacing schedule another provider
+			select {
+			case freed <- chState.Recipient():
+			default:
+			}
+		case datatransfer.Completed:
+			tw.done(chState.Recipient())
+			if !tw.complete(chState.ChannelID()) {
 				return
 			}
+			root := chState.BaseCID()
 			// The recipient is the provider who received our content
 			rec := chState.Recipient()
-			resChan <- PRecord{
+			rg, _ := r.pm.Region(rec, rgs)
+			// Don't hold the data transfer events once the dispatch is over
+			select {
+			case resChan <- PRecord{
 				Provider:   rec,
 				PayloadCID: root,
+				Region:     rg.Name,
+			}:
+			case <-stop:
 			}
 		}
 	})
 	go func() {
+		// The peers we're waiting a relay receipt for
+		relayed := make(map[peer.ID]bool)
 		defer func() {
 			unsub()
+			close(stop)
+			r.fan.close(root)
+			for p := range relayed {
+				r.forgetReceipt(root, p, resChan)
+			}
 			close(out)
 		}()
 		// The peers we already sent requests to
 		rcv := make(map[peer.ID]bool)
+		for _, p := range opt.Exclude {
+			rcv[p] = true
+		}
+		// The peers we couldn't reach and haven't found a relay for yet
+		var unreachable []peer.ID
 		// Set the parameters for backing off after each try
 		b := backoff.Backoff{
 			Min: opt.BackoffMin,
@@ -424,36 +848,121 @@ func (r *Replication) Dispatch(root cid.Cid, size uint64, opt DispatchOptions) c
 		for {
 			// Give up after 6 attemps. Maybe should make this customizable for servers that can afford it
 			if int(b.Attempt()) > opt.BackoffAttemps {
+				if len(relayed) == 0 || opt.RelayTimeout == 0 {
+					return
+				}
+				// Give the relays some time to reach the peers we couldn't
+				timer := time.NewTimer(opt.RelayTimeout)
+				defer timer.Stop()
+				for len(relayed) > 0 {
+					select {
+					case <-timer.C:
+						return
+					case rec := <-resChan:
+						out <- rec
+						n++
+						if n == opt.RF {
+							return
+						}
+						delete(relayed, rec.Provider)
+					}
+				}
 				return
 			}
-			// Select the providers we want to send to minus those we already confirmed
-			// received the requests
-			providers := r.pm.Peers(opt.RF-n, r.rgs, rcv)
-
-			// Authorize the transfer
-			for _, p := range providers {
-				r.AuthorizePull(req.PayloadCID, p)
-				rcv[p] = true
-			}
-			if len(providers) > 0 {
-				// sendAllRequests
-				r.sendAllRequests(req, providers)
+			// The providers we sent a request to during this round count against the pacing until they
+			// start pulling the content or the round is over
+			pending := make(map[peer.ID]bool)
+			send := func() {
+				count := pace.slots(opt.RF-n, tw.inflight(pending))
+				if count == 0 {
+					return
+				}
+				// Select the providers we want to send to minus those we already confirmed
+				// received the requests
+				var providers []peer.ID
+				for _, p := range opt.Preferred {
+					if len(providers) == count {
+						break
+					}
+					if !rcv[p] {
+						providers = append(providers, p)
+						rcv[p] = true
+					}
+				}
+				if len(providers) < count {
+					providers = append(providers, r.pm.Peers(count-len(providers), rgs, rcv)...)
+				}
+
+				// Authorize the transfer
+				for _, p := range providers {
+					r.AuthorizePull(req.PayloadCID, p)
+					rcv[p] = true
+					pending[p] = true
+					tw.request(p)
+				}
+				if len(providers) > 0 {
+					// sendAllRequests
+					failed := r.sendAllRequests(req, providers)
+					for _, p := range failed {
+						delete(pending, p)
+					}
+					if opt.Relay {
+						unreachable = append(unreachable, failed...)
+					}
+				}
 			}
+			send()
 
 			timer := time.NewTimer(b.Duration())
 			for {
 				select {
 				case <-timer.C:
-
+					// Transfers of a paced dispatch may take longer than the backoff so we only back off
+					// if none is making progress
+					if pace.paced() && tw.inflight(nil) > 0 {
+						b.Reset()
+					}
 					continue requests
-				case r := <-resChan:
+				case p := <-freed:
+					delete(pending, p)
+					if pace.paced() {
+						send()
+					}
+				case rec := <-resChan:
 					// forward the confirmations to the Response channel
-					out <- r
+					out <- rec
 					// increment our results count
 					n++
 					if n == opt.RF {
 						return
 					}
+					delete(relayed, rec.Provider)
+					delete(pending, rec.Provider)
+					// Any cache which received the content directly can hold it for the peers we couldn't reach
+					if len(unreachable) > 0 && len(rec.Path) == 0 {
+						for _, p := range unreachable {
+							// Relays send us a receipt once the peer we couldn't reach received the content
+							r.expectReceipt(root, p, resChan)
+							err := r.sendRequest(rec.Provider, Request{
+								Method:     Relay,
+								PayloadCID: req.PayloadCID,
+								Size:       req.Size,
+								Target:     p,
+								Path:       []peer.ID{r.h.ID()},
+								Manifest:   req.Manifest,
+							})
+							if err != nil {
+								r.forgetReceipt(root, p, resChan)
+								continue
+							}
+							relayed[p] = true
+						}
+						unreachable = nil
+					}
+					// The next providers of a paced dispatch start once the previous ones are done
+					if pace.paced() {
+						send()
+					}
 				}
 			}
 		}
@@ -461,18 +970,38 @@ func (r *Replication) Dispatch(root cid.Cid, size uint64, opt DispatchOptions) c
 	return out
 }
 
-func (r *Replication) sendAllRequests(req Request, peers []peer.ID) {
+// sendAllRequests sends a request to all the given peers concurrently so a peer slow to reach doesn't
+// delay the transfers to the others and returns the peers we couldn't reach
+func (r *Replication) sendAllRequests(req Request, peers []peer.ID) []peer.ID {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failed []peer.ID
 	for _, p := range peers {
-		stream, err := r.NewRequestStream(p)
-		if err != nil {
-			continue
-		}
-		err = stream.WriteRequest(req)
-		stream.Close()
-		if err != nil {
-			continue
-		}
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := r.sendRequest(p, req); err != nil {
+				r.pm.RecordFailure(p)
+				mu.Lock()
+				failed = append(failed, p)
+				mu.Unlock()
+				return
+			}
+			r.pm.RecordResponse(p)
+		}(p)
+	}
+	wg.Wait()
+	return failed
+}
+
+// sendRequest opens a new stream to send a single request to the given peer
+func (r *Replication) sendRequest(p peer.ID, req Request) error {
+	stream, err := r.NewRequestStream(p)
+	if err != nil {
+		return err
 	}
+	defer stream.Close()
+	return stream.WriteRequest(req)
 }
 
 // AuthorizePull adds a peer to a set giving authorization to pull content without payment
@@ -536,16 +1065,21 @@ type StoreConfigurableTransport interface {
 	UseStore(datatransfer.ChannelID, ipld.Loader, ipld.Storer) error
 }
 
+// Bandwidth returns the meter of the blocks replicated, nil if they aren't metered
+func (r *Replication) Bandwidth() *metrics.Bandwidth {
+	return r.bw
+}
+
 // IdxStoreGetter returns the store used for retrieving a given index root
 type IdxStoreGetter interface {
 	GetStore(cid.Cid) *multistore.Store
 }
 
 // TransportConfigurer configurers the graphsync transport to use a custom blockstore per content
-func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID) datatransfer.TransportConfigurer {
+func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID, tc *retrieval.TransferContexts) datatransfer.TransportConfigurer {
 	return func(channelID datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport) {
 		warn := func(err error) {
-			fmt.Println("attempting to configure data store:", err)
+			txLog.Warn().Err(err).Msg("failed to configure data store")
 		}
 		request, ok := voucher.(*Request)
 		if !ok {
@@ -555,18 +1089,34 @@ func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID) datatransf
 		if !ok {
 			return
 		}
+		// Replications are pulled so the initiator receives the blocks and the other side sends them
+		meter := func(loader ipld.Loader, storer ipld.Storer) (ipld.Loader, ipld.Storer) {
+			bm, ok := isg.(retrieval.BandwidthMeter)
+			if !ok {
+				return loader, storer
+			}
+			other := channelID.OtherParty(pid)
+			ctx := tc.Context(channelID)
+			if channelID.Initiator == pid {
+				return loader, retrieval.MeterStorer(ctx, bm.Bandwidth(), other, request.PayloadCID, storer)
+			}
+			return retrieval.MeterLoader(ctx, bm.Bandwidth(), other, request.PayloadCID, loader), storer
+		}
 		if request.Method == FetchIndex && channelID.Initiator == pid {
 			// When we're fetching a new index we store it in a new store
 			store := isg.GetStore(request.PayloadCID)
-			err := gsTransport.UseStore(channelID, store.Loader, store.Storer)
+			loader, storer := meter(store.Loader, store.Storer)
+			err := gsTransport.UseStore(channelID, loader, storer)
 			if err != nil {
 				warn(err)
 			}
 			return
 		}
 		if request.Method == FetchIndex {
-			loader := storeutil.LoaderForBlockstore(idx.Bstore())
-			storer := storeutil.StorerForBlockstore(idx.Bstore())
+			loader, storer := meter(
+				storeutil.LoaderForBlockstore(idx.Bstore()),
+				storeutil.StorerForBlockstore(idx.Bstore()),
+			)
 			err := gsTransport.UseStore(channelID, loader, storer)
 			if err != nil {
 				warn(err)
@@ -578,7 +1128,13 @@ func TransportConfigurer(idx *Index, isg IdxStoreGetter, pid peer.ID) datatransf
 			warn(err)
 			return
 		}
-		err = gsTransport.UseStore(channelID, store.Loader, store.Storer)
+		loader := store.Loader
+		// Content we dispatch to several providers is read from the store once for all of them
+		if ls, ok := isg.(LoaderSharer); ok && request.Method == Dispatch && channelID.Initiator != pid {
+			loader = ls.ShareLoader(request.PayloadCID, loader)
+		}
+		loader, storer := meter(loader, store.Storer)
+		err = gsTransport.UseStore(channelID, loader, storer)
 		if err != nil {
 			warn(err)
 		}
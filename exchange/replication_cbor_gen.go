@@ -8,6 +8,7 @@ import (
 	"sort"
 
 	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
 	xerrors "golang.org/x/xerrors"
 )
@@ -16,7 +17,7 @@ var _ = xerrors.Errorf
 var _ = cid.Undef
 var _ = sort.Sort
 
-var lengthBufRequest = []byte{131}
+var lengthBufRequest = []byte{134}
 
 func (t *Request) MarshalCBOR(w io.Writer) error {
 	if t == nil {
@@ -47,6 +48,43 @@ func (t *Request) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.Target (peer.ID) (string)
+	if len(t.Target) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Target was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Target))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Target)); err != nil {
+		return err
+	}
+
+	// t.Path ([]peer.ID) (slice)
+	if len(t.Path) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Path was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajArray, uint64(len(t.Path))); err != nil {
+		return err
+	}
+	for _, v := range t.Path {
+		if len(v) > cbg.MaxLength {
+			return xerrors.Errorf("Value in field v was too long")
+		}
+
+		if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(v))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, string(v)); err != nil {
+			return err
+		}
+	}
+
+	// t.Manifest (exchange.Manifest) (struct)
+	if err := t.Manifest.MarshalCBOR(w); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -64,6 +102,161 @@ func (t *Request) UnmarshalCBOR(r io.Reader) error {
 		return fmt.Errorf("cbor input should be of type array")
 	}
 
+	if extra != 6 {
+		return fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Method (exchange.Method) (uint64)
+
+	{
+
+		maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Method = Method(extra)
+
+	}
+	// t.PayloadCID (cid.Cid) (struct)
+
+	{
+
+		c, err := cbg.ReadCid(br)
+		if err != nil {
+			return xerrors.Errorf("failed to read cid field t.PayloadCID: %w", err)
+		}
+
+		t.PayloadCID = c
+
+	}
+	// t.Size (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Size = uint64(extra)
+
+	}
+	// t.Target (peer.ID) (string)
+
+	{
+		sval, err := cbg.ReadStringBuf(br, scratch)
+		if err != nil {
+			return err
+		}
+
+		t.Target = peer.ID(sval)
+	}
+	// t.Path ([]peer.ID) (slice)
+
+	maj, extra, err = cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("t.Path: array too large (%d)", extra)
+	}
+
+	if maj != cbg.MajArray {
+		return fmt.Errorf("expected cbor array")
+	}
+
+	if extra > 0 {
+		t.Path = make([]peer.ID, extra)
+	}
+
+	for i := 0; i < int(extra); i++ {
+
+		{
+			sval, err := cbg.ReadStringBuf(br, scratch)
+			if err != nil {
+				return err
+			}
+
+			t.Path[i] = peer.ID(sval)
+		}
+	}
+
+	// t.Manifest (exchange.Manifest) (struct)
+
+	{
+
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != cbg.CborNull[0] {
+			if err := br.UnreadByte(); err != nil {
+				return err
+			}
+			t.Manifest = new(Manifest)
+			if err := t.Manifest.UnmarshalCBOR(br); err != nil {
+				return xerrors.Errorf("unmarshaling t.Manifest pointer: %w", err)
+			}
+		}
+
+	}
+	return nil
+}
+
+var lengthBufrequestV1 = []byte{131}
+
+func (t *requestV1) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+	if _, err := w.Write(lengthBufrequestV1); err != nil {
+		return err
+	}
+
+	scratch := make([]byte, 9)
+
+	// t.Method (exchange.Method) (uint64)
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Method)); err != nil {
+		return err
+	}
+
+	// t.PayloadCID (cid.Cid) (struct)
+
+	if err := cbg.WriteCidBuf(scratch, w, t.PayloadCID); err != nil {
+		return xerrors.Errorf("failed to write cid field t.PayloadCID: %w", err)
+	}
+
+	// t.Size (uint64) (uint64)
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajUnsignedInt, uint64(t.Size)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *requestV1) UnmarshalCBOR(r io.Reader) error {
+	*t = requestV1{}
+
+	br := cbg.GetPeeker(r)
+	scratch := make([]byte, 8)
+
+	maj, extra, err := cbg.CborReadHeaderBuf(br, scratch)
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajArray {
+		return fmt.Errorf("cbor input should be of type array")
+	}
+
 	if extra != 3 {
 		return fmt.Errorf("cbor input had wrong number of fields")
 	}
@@ -558,6 +558,96 @@ func TestSendDispatchNoPeers(t *testing.T) {
 	}
 }
 
+// A relay cache should hold the content until the target connects and send a receipt to the publisher
+func TestRelayDispatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+
+	withSwarmT := func(tn *testutil.TestNode) {
+		netw := swarmt.GenSwarm(t, context.Background())
+		h := bhost.NewBlankHost(netw, bhost.WithConnectionManager(
+			connmgr.NewConnManager(10, 11, time.Second),
+		))
+		tn.Host = h
+	}
+	setupNode := func() (*testutil.TestNode, *Replication) {
+		n := testutil.NewTestNode(mn, t, withSwarmT)
+		n.SetupDataTransfer(ctx, t)
+		idx, err := NewIndex(n.Ds, n.Ms)
+		require.NoError(t, err)
+		repl := NewReplication(n.Host, idx, n.Dt, NewMockRetriever(n.Dt, idx), []Region{global})
+		require.NoError(t, repl.Start(ctx))
+		return n, repl
+	}
+
+	nA, rA := setupNode()
+	nB, rB := setupNode()
+	nC, rC := setupNode()
+
+	sub, err := nB.Host.EventBus().Subscribe(new(HeyEvt), eventbus.BufSize(16))
+	require.NoError(t, err)
+
+	require.NoError(t, testutil.Connect(nA, nB))
+	select {
+	case <-sub.Out():
+	case <-ctx.Done():
+		t.Fatal("A didn't get in B's peermgr")
+	}
+	time.Sleep(time.Second)
+
+	fname := nA.CreateRandomFile(t, 256000)
+	link, storeID, origBytes := nA.LoadFileToNewStore(ctx, t, fname)
+	rootCid := link.(cidlink.Link).Cid
+	require.NoError(t, rA.idx.SetRef(&DataRef{
+		PayloadCID: rootCid,
+		StoreID:    storeID,
+	}))
+
+	opts := DefaultDispatchOptions
+	opts.RF = 1
+	for rec := range rA.Dispatch(rootCid, uint64(len(origBytes)), opts) {
+		require.Equal(t, nB.Host.ID(), rec.Provider)
+	}
+
+	receipts := make(chan PRecord, 1)
+	rA.expectReceipt(rootCid, nC.Host.ID(), receipts)
+
+	// C is not connected to anyone yet so B holds the content for them
+	require.NoError(t, rA.sendRequest(nB.Host.ID(), Request{
+		Method:     Relay,
+		PayloadCID: rootCid,
+		Size:       uint64(len(origBytes)),
+		Target:     nC.Host.ID(),
+		Path:       []peer.ID{nA.Host.ID()},
+	}))
+	time.Sleep(time.Second)
+
+	require.NoError(t, testutil.Connect(nB, nC))
+
+	select {
+	case rec := <-receipts:
+		require.Equal(t, nC.Host.ID(), rec.Provider)
+		require.Equal(t, []peer.ID{nB.Host.ID()}, rec.Path)
+	case <-ctx.Done():
+		t.Fatal("did not receive relay receipt")
+	}
+
+	ref, err := rC.idx.PeekRef(rootCid)
+	require.NoError(t, err)
+	require.Equal(t, nA.Host.ID(), ref.Publisher)
+
+	store, err := rC.idx.GetStore(rootCid)
+	require.NoError(t, err)
+	nC.VerifyFileTransferred(ctx, t, store.DAG, rootCid, origBytes)
+
+	// B is no longer holding anything for C
+	rB.rmu.Lock()
+	require.Equal(t, 0, len(rB.relays))
+	rB.rmu.Unlock()
+}
+
 // The role of this test is to make sure we never dispatch content to unwanted regions
 func TestSendDispatchDiffRegions(t *testing.T) {
 	bgCtx := context.Background()
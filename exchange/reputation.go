@@ -0,0 +1,211 @@
+package exchange
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+//go:generate cbor-gen-for PeerScore
+
+// KReputation is the datastore namespace under which peer scores are persisted
+const KReputation = "/reputation"
+
+// PeerScore records the outcome of the retrievals we made from a given provider
+type PeerScore struct {
+	Provider  peer.ID
+	Successes uint64
+	Failures  uint64
+	// Disputes counts the times the provider requested more funds than owed for the bytes sent
+	Disputes uint64
+	// Received is the total number of bytes received during successful retrievals
+	Received uint64
+	// Duration is the total time spent in successful retrievals in nanoseconds
+	Duration uint64
+}
+
+// Score ranks a provider between 0 and 1. The success ratio is smoothed so providers we never
+// retrieved from start at 0.5 and each payment dispute halves the score.
+func (ps PeerScore) Score() float64 {
+	ratio := float64(ps.Successes+1) / float64(ps.Successes+ps.Failures+2)
+	return ratio / math.Pow(2, float64(ps.Disputes))
+}
+
+// Throughput returns the average number of bytes per second received from the provider
+func (ps PeerScore) Throughput() float64 {
+	if ps.Duration == 0 {
+		return 0
+	}
+	return float64(ps.Received) / time.Duration(ps.Duration).Seconds()
+}
+
+// Reputation keeps track of the retrieval performance of providers we transact with
+type Reputation struct {
+	ds datastore.Batching
+
+	mu     sync.Mutex
+	scores map[peer.ID]*PeerScore
+	// starts records when ongoing deals were accepted by the provider
+	starts map[deal.ID]time.Time
+}
+
+// NewReputation loads all the peer scores persisted in the datastore
+func NewReputation(ds datastore.Batching) (*Reputation, error) {
+	rep := &Reputation{
+		ds:     namespace.Wrap(ds, datastore.NewKey(KReputation)),
+		scores: make(map[peer.ID]*PeerScore),
+		starts: make(map[deal.ID]time.Time),
+	}
+	res, err := rep.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		ps := new(PeerScore)
+		if err := ps.UnmarshalCBOR(bytes.NewReader(r.Value)); err != nil {
+			return nil, err
+		}
+		rep.scores[ps.Provider] = ps
+	}
+	return rep, nil
+}
+
+// Score returns the score of a given provider
+func (rep *Reputation) Score(p peer.ID) PeerScore {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if ps, ok := rep.scores[p]; ok {
+		return *ps
+	}
+	return PeerScore{Provider: p}
+}
+
+// Scores returns the scores of all the providers we retrieved from, best first
+func (rep *Reputation) Scores() []PeerScore {
+	rep.mu.Lock()
+	scores := make([]PeerScore, 0, len(rep.scores))
+	for _, ps := range rep.scores {
+		scores = append(scores, *ps)
+	}
+	rep.mu.Unlock()
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Score() > scores[j].Score() ||
+			(scores[i].Score() == scores[j].Score() && scores[i].Throughput() > scores[j].Throughput())
+	})
+	return scores
+}
+
+// RecordSuccess updates the score of a provider after a completed retrieval
+func (rep *Reputation) RecordSuccess(p peer.ID, size uint64, elapsed time.Duration) error {
+	return rep.update(p, func(ps *PeerScore) {
+		ps.Successes++
+		ps.Received += size
+		ps.Duration += uint64(elapsed)
+	})
+}
+
+// RecordFailure updates the score of a provider after a failed retrieval
+func (rep *Reputation) RecordFailure(p peer.ID) error {
+	return rep.update(p, func(ps *PeerScore) {
+		ps.Failures++
+	})
+}
+
+// RecordDispute updates the score of a provider which requested a payment we didn't owe
+func (rep *Reputation) RecordDispute(p peer.ID) error {
+	return rep.update(p, func(ps *PeerScore) {
+		ps.Disputes++
+	})
+}
+
+func (rep *Reputation) update(p peer.ID, fn func(*PeerScore)) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	ps, ok := rep.scores[p]
+	if !ok {
+		ps = &PeerScore{Provider: p}
+		rep.scores[p] = ps
+	}
+	fn(ps)
+	buf := new(bytes.Buffer)
+	if err := ps.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	return rep.ds.Put(datastore.NewKey(p.String()), buf.Bytes())
+}
+
+// handleClientEvent records the outcome of the deals made by the retrieval client. Only deals accepted
+// by the provider are tracked so we don't penalize providers for content they don't have.
+func (rep *Reputation) handleClientEvent(event client.Event, state deal.ClientState) {
+	rep.mu.Lock()
+	start, ok := rep.starts[state.ID]
+	if !ok {
+		if event == client.EventDealAccepted {
+			rep.starts[state.ID] = time.Now()
+		}
+		rep.mu.Unlock()
+		return
+	}
+	var done bool
+	switch state.Status {
+	case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored:
+		delete(rep.starts, state.ID)
+		done = true
+	}
+	rep.mu.Unlock()
+
+	var err error
+	switch {
+	case event == client.EventBadPaymentRequested:
+		err = rep.RecordDispute(state.Sender)
+	case !done:
+	case state.Status == deal.StatusCompleted:
+		err = rep.RecordSuccess(state.Sender, state.TotalReceived, time.Since(start))
+	default:
+		err = rep.RecordFailure(state.Sender)
+	}
+	if err != nil {
+		txLog.Warn().Err(err).Msg("failed to record reputation")
+	}
+}
+
+// SelectByReputation collects offers during the given window then executes the one from the provider
+// with the best reputation. Providers with the same score are ranked by throughput then by price.
+// If the transfer fails it will select the next best provider given the buffered offers.
+func SelectByReputation(rep *Reputation, wait time.Duration) func(OfferExecutor) OfferWorker {
+	return func(oe OfferExecutor) OfferWorker {
+		return sessionWorker{
+			executor:      oe,
+			offersIn:      make(chan deal.Offer),
+			closing:       make(chan chan []deal.Offer, 1),
+			numThreshold:  -1,
+			timeThreshold: wait,
+			priceCeiling:  abi.NewTokenAmount(-1),
+			rank: func(offers []deal.Offer) {
+				sortByTotalPrice(offers)
+				sort.SliceStable(offers, func(i, j int) bool {
+					si := rep.Score(offers[i].Provider.ID)
+					sj := rep.Score(offers[j].Provider.ID)
+					if si.Score() != sj.Score() {
+						return si.Score() > sj.Score()
+					}
+					return si.Throughput() > sj.Throughput()
+				})
+			},
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReputation(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	rep, err := NewReputation(ds)
+	require.NoError(t, err)
+
+	good := peer.ID("good")
+	bad := peer.ID("bad")
+	greedy := peer.ID("greedy")
+
+	// Providers we know nothing about start in the middle
+	require.Equal(t, 0.5, rep.Score(good).Score())
+
+	require.NoError(t, rep.RecordSuccess(good, 2000, time.Second))
+	require.NoError(t, rep.RecordSuccess(good, 2000, time.Second))
+	require.NoError(t, rep.RecordFailure(bad))
+	require.NoError(t, rep.RecordSuccess(greedy, 1000, time.Second))
+	require.NoError(t, rep.RecordDispute(greedy))
+	require.NoError(t, rep.RecordDispute(greedy))
+
+	require.Equal(t, 2000.0, rep.Score(good).Throughput())
+	require.Equal(t, 0.75, rep.Score(good).Score())
+
+	scores := rep.Scores()
+	require.Equal(t, 3, len(scores))
+	require.Equal(t, good, scores[0].Provider)
+	require.Equal(t, bad, scores[1].Provider)
+	require.Equal(t, greedy, scores[2].Provider)
+
+	// Scores are reloaded from the datastore
+	rep, err = NewReputation(ds)
+	require.NoError(t, err)
+	require.Equal(t, scores, rep.Scores())
+}
+
+func TestReputationClientEvents(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	rep, err := NewReputation(ds)
+	require.NoError(t, err)
+
+	p := peer.ID("provider")
+	state := deal.ClientState{Sender: p}
+
+	// Deals which were never accepted are not recorded
+	state.ID = 1
+	state.Status = deal.StatusErrored
+	rep.handleClientEvent(client.EventDealRejected, state)
+	require.Equal(t, uint64(0), rep.Score(p).Failures)
+
+	state.ID = 2
+	state.Status = deal.StatusAccepted
+	rep.handleClientEvent(client.EventDealAccepted, state)
+	rep.handleClientEvent(client.EventBadPaymentRequested, state)
+	state.Status = deal.StatusErrored
+	rep.handleClientEvent(client.EventDataTransferError, state)
+	// Events after the deal ended are ignored
+	rep.handleClientEvent(client.EventDataTransferError, state)
+
+	state.ID = 3
+	state.Status = deal.StatusAccepted
+	rep.handleClientEvent(client.EventDealAccepted, state)
+	state.Status = deal.StatusCompleted
+	state.TotalReceived = 1024
+	rep.handleClientEvent(client.EventComplete, state)
+
+	ps := rep.Score(p)
+	require.Equal(t, uint64(1), ps.Successes)
+	require.Equal(t, uint64(1), ps.Failures)
+	require.Equal(t, uint64(1), ps.Disputes)
+	require.Equal(t, uint64(1024), ps.Received)
+}
+
+func TestSelectByReputation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rep, err := NewReputation(dss.MutexWrap(datastore.NewMapDatastore()))
+	require.NoError(t, err)
+	require.NoError(t, rep.RecordFailure(peer.ID("cheap")))
+	require.NoError(t, rep.RecordSuccess(peer.ID("reliable"), 1000, time.Second))
+
+	exec := testExecutor{
+		done: make(chan deal.Offer, 1),
+		err:  make(chan error, 1),
+	}
+	wq := SelectByReputation(rep, 200*time.Millisecond)(exec)
+	wq.Start()
+
+	offers := []struct {
+		id  string
+		ppb int64
+	}{
+		{"cheap", 1},
+		{"unknown", 2},
+		{"reliable", 5},
+	}
+	for _, o := range offers {
+		wq.ReceiveResponse(peer.AddrInfo{ID: peer.ID(o.id)}, deal.QueryResponse{
+			Size:            1000,
+			MinPricePerByte: abi.NewTokenAmount(o.ppb),
+		})
+	}
+	exec.SetError(nil)
+
+	select {
+	case of := <-exec.done:
+		require.Equal(t, peer.ID("reliable"), of.Provider.ID)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err())
+	}
+	require.Equal(t, 2, len(wq.Close()))
+}
@@ -61,7 +61,7 @@ func OpenStream(ctx context.Context, h host.Host, p peer.ID, protos []protocol.I
 		if err == nil {
 			return s, err
 		}
-		fmt.Println("trying again", err)
+		discoveryLog.Debug().Err(err).Str("peer", p.String()).Msg("failed to open stream, trying again")
 
 		nAttempts := b.Attempt()
 		if nAttempts == MaxStreamOpenAttempts {
@@ -142,22 +142,39 @@ type ResponseFunc func(context.Context, peer.ID, Region, deal.Query) (deal.Query
 type GossipRouting struct {
 	h              host.Host
 	ps             *pubsub.PubSub
-	tops           []*pubsub.Topic
 	queryProtocols []protocol.ID
 	meta           MessageTracker
 	regions        []Region
-	rmu            sync.Mutex
-	receiveResp    ReceiveResponse
+	// shards is the number of topics the queries of each region are spread over by root, 0 is a single topic
+	shards int
+	// private queries carry a salted hash of the root instead of the root itself
+	private bool
+	// saltPeriod is how long private queries are salted with the same salt
+	saltPeriod  time.Duration
+	rmu         sync.Mutex
+	receiveResp ReceiveResponse
+	// responded is notified of the providers answering our queries if not nil
+	responded func(peer.ID)
+
+	smu     sync.Mutex
+	tops    map[string]*pubsub.Topic
+	subs    map[string]*pubsub.Subscription
+	ctx     context.Context
+	respond ResponseFunc
 }
 
-// NewGossipRouting creates a new GossipRouting service
-func NewGossipRouting(h host.Host, ps *pubsub.PubSub, meta MessageTracker, rgs []Region) *GossipRouting {
+// NewGossipRouting creates a new GossipRouting service. With shards above 0 the queries of each region are
+// published on the topic of the shard of their root and providers only subscribe to the shards of the content
+// they store. All the nodes of a region must use the same number of shards.
+func NewGossipRouting(h host.Host, ps *pubsub.PubSub, meta MessageTracker, rgs []Region, shards int) *GossipRouting {
 	routing := &GossipRouting{
 		h:       h,
 		ps:      ps,
 		meta:    meta,
 		regions: rgs,
-		tops:    make([]*pubsub.Topic, len(rgs)),
+		shards:  shards,
+		tops:    make(map[string]*pubsub.Topic),
+		subs:    make(map[string]*pubsub.Subscription),
 		queryProtocols: []protocol.ID{
 			FilQueryProtocolID,
 			PopQueryProtocolID,
@@ -166,29 +183,29 @@ func NewGossipRouting(h host.Host, ps *pubsub.PubSub, meta MessageTracker, rgs [
 	return routing
 }
 
-// StartProviding opens up our gossip subscription and sets our stream handler
+// StartProviding opens up our gossip subscription and sets our stream handler. Sharded topics are only
+// subscribed to once Provide is called with the roots we store.
 func (gr *GossipRouting) StartProviding(ctx context.Context, fn ResponseFunc) error {
 	// We only need to handle the Pop query protocol since Fil is for querying storage miners
 	gr.h.SetStreamHandler(PopQueryProtocolID, gr.handleQueryResponse)
 
-	for i, r := range gr.regions {
-		top, err := gr.ps.Join(fmt.Sprintf("%s/%s", PopQueryProtocolID, r.Name))
-		if err != nil {
-			return err
-		}
-		gr.tops[i] = top
-		sub, err := top.Subscribe()
-		if err != nil {
+	gr.smu.Lock()
+	defer gr.smu.Unlock()
+	gr.ctx = ctx
+	gr.respond = fn
+	if gr.shards > 0 {
+		return nil
+	}
+	for _, r := range gr.regions {
+		if err := gr.subscribe(queryTopic(r, -1), r); err != nil {
 			return err
 		}
-		go gr.pump(ctx, sub, fn)
 	}
 
 	return nil
 }
 
-func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, fn ResponseFunc) {
-	r := RegionFromTopic(sub.Topic())
+func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, r Region, fn ResponseFunc) {
 	for {
 		msg, err := sub.Next(ctx)
 		if err != nil {
@@ -208,7 +225,7 @@ func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, fn
 
 		qs, err := gr.NewQueryStream(msg.ReceivedFrom)
 		if err != nil {
-			fmt.Println("failed to create response query stream", err)
+			discoveryLog.Warn().Err(err).Str("peer", msg.ReceivedFrom.String()).Msg("failed to create response query stream")
 			continue
 		}
 		resp.Message, err = gr.ResponseMsg(msg.Message)
@@ -216,7 +233,7 @@ func (gr *GossipRouting) pump(ctx context.Context, sub *pubsub.Subscription, fn
 			continue
 		}
 		if err := qs.WriteQueryResponse(resp); err != nil {
-			fmt.Printf("retrieval query: WriteCborRPC: %s\n", err)
+			discoveryLog.Warn().Err(err).Msg("failed to write query response")
 			continue
 		}
 
@@ -271,15 +288,31 @@ func (gr *GossipRouting) Query(ctx context.Context, root cid.Cid, sel ipld.Node)
 		PayloadCID:  root,
 		QueryParams: params,
 	}
+	// The topic shard still tells which fraction of the roots the query is for
+	if gr.private {
+		m.PayloadCID = BlindRoot(root, SaltAt(time.Now(), gr.saltPeriod))
+	}
 
 	buf := new(bytes.Buffer)
 	if err := m.MarshalCBOR(buf); err != nil {
 		return err
 	}
 
-	bytes := buf.Bytes()
 	// publish to all regions this exchange joined
-	for _, topic := range gr.tops {
+	var tops []*pubsub.Topic
+	gr.smu.Lock()
+	for _, name := range gr.queryTopics(root) {
+		top, err := gr.join(name)
+		if err != nil {
+			gr.smu.Unlock()
+			return err
+		}
+		tops = append(tops, top)
+	}
+	gr.smu.Unlock()
+
+	bytes := buf.Bytes()
+	for _, topic := range tops {
 		if err := topic.Publish(ctx, bytes); err != nil {
 			return err
 		}
@@ -318,7 +351,7 @@ func (gr *GossipRouting) handleQueryResponse(s network.Stream) {
 	buf := new(bytes.Buffer)
 	msg, err := PeekResponseMsg(buffered, buf)
 	if err != nil {
-		fmt.Println("failed to peek message", err)
+		discoveryLog.Debug().Err(err).Msg("failed to peek message")
 		return
 	}
 	// Here we handle messages from Filecoin miners
@@ -326,12 +359,12 @@ func (gr *GossipRouting) handleQueryResponse(s network.Stream) {
 		gr.rmu.Lock()
 		defer gr.rmu.Unlock()
 		if gr.receiveResp == nil {
-			fmt.Println("received resp")
+			discoveryLog.Debug().Msg("received query response without a receiver")
 			return
 		}
 		var resp deal.QueryResponse
 		if err := resp.UnmarshalCBOR(buf); err != nil && !errors.Is(err, io.EOF) {
-			fmt.Println("failed to read query response", err)
+			discoveryLog.Debug().Err(err).Msg("failed to read query response")
 			return
 		}
 		gr.receiveResp(gr.h.Peerstore().PeerInfo(s.Conn().RemotePeer()), resp)
@@ -340,7 +373,7 @@ func (gr *GossipRouting) handleQueryResponse(s network.Stream) {
 	// Get the index where to split
 	is, err := strconv.ParseInt(msg[:2], 10, 64)
 	if err != nil {
-		fmt.Println("failed to parse index", err)
+		discoveryLog.Debug().Err(err).Msg("failed to parse index")
 		return
 	}
 	msgID := msg[2 : is+2]
@@ -349,16 +382,16 @@ func (gr *GossipRouting) handleQueryResponse(s network.Stream) {
 	if !gr.meta.Published(msgID) {
 		to, err := gr.meta.Sender(msgID)
 		if err != nil {
-			fmt.Println("failed to find message recipient", err)
+			discoveryLog.Debug().Err(err).Msg("failed to find message recipient")
 			return
 		}
 		w, err := OpenStream(context.Background(), gr.h, to, gr.queryProtocols)
 		if err != nil {
-			fmt.Println("failed to open stream", err)
+			discoveryLog.Debug().Err(err).Str("peer", to.String()).Msg("failed to open stream")
 			return
 		}
 		if _, err := io.Copy(w, buf); err != nil {
-			fmt.Println("failed to forward buffer", err)
+			discoveryLog.Debug().Err(err).Msg("failed to forward buffer")
 		}
 		return
 	}
@@ -371,15 +404,18 @@ func (gr *GossipRouting) handleQueryResponse(s network.Stream) {
 
 	rec, err := utils.AddrBytesToAddrInfo([]byte(msg[is+2:]))
 	if err != nil {
-		fmt.Println("failed to parse addr bytes", err)
+		discoveryLog.Debug().Err(err).Msg("failed to parse addr bytes")
 		return
 	}
 
 	var resp deal.QueryResponse
 	if err := resp.UnmarshalCBOR(buf); err != nil && !errors.Is(err, io.EOF) {
-		fmt.Println("failed to read query response", err)
+		discoveryLog.Debug().Err(err).Msg("failed to read query response")
 		return
 	}
+	if gr.responded != nil {
+		gr.responded(rec.ID)
+	}
 
 	gr.receiveResp(*rec, resp)
 }
@@ -136,7 +136,7 @@ func TestGossipRouting(t *testing.T) {
 				tracer := NewGossipTracer()
 				ps, err := pubsub.NewGossipSub(ctx, n.Host, pubsub.WithEventTracer(tracer))
 				require.NoError(t, err)
-				routing := NewGossipRouting(n.Host, ps, tracer, []Region{global})
+				routing := NewGossipRouting(n.Host, ps, tracer, []Region{global}, 0)
 
 				require.NoError(t, routing.StartProviding(ctx, calcResponse))
 
@@ -209,7 +209,7 @@ func TestMessageForwarding(t *testing.T) {
 	ps, err := pubsub.NewGossipSub(ctx, cnode.Host)
 	require.NoError(t, err)
 	// We don't need store getters or address getters as we're manually sending responses in
-	cnet := NewGossipRouting(cnode.Host, ps, mtracker{true, ""}, []Region{global})
+	cnet := NewGossipRouting(cnode.Host, ps, mtracker{true, ""}, []Region{global}, 0)
 	responses := make(chan deal.QueryResponse)
 	cnet.receiveResp = func(i peer.AddrInfo, r deal.QueryResponse) {
 		responses <- r
@@ -227,7 +227,7 @@ func TestMessageForwarding(t *testing.T) {
 		}
 		ps, err := pubsub.NewGossipSub(ctx, pnode.Host)
 		require.NoError(t, err)
-		pnet := NewGossipRouting(pnode.Host, ps, mtracker{false, pp}, []Region{global})
+		pnet := NewGossipRouting(pnode.Host, ps, mtracker{false, pp}, []Region{global}, 0)
 		require.NoError(t, pnet.StartProviding(ctx, calcResponse))
 		pnodes = append(pnodes, pnode)
 		pnets = append(pnets, pnet)
@@ -288,7 +288,7 @@ func BenchmarkNetworkForwarding(b *testing.B) {
 	cnode := testutil.NewTestNode(mn, b)
 	ps, err := pubsub.NewGossipSub(ctx, cnode.Host)
 	require.NoError(b, err)
-	cnet := NewGossipRouting(cnode.Host, ps, mtracker{true, ""}, []Region{global})
+	cnet := NewGossipRouting(cnode.Host, ps, mtracker{true, ""}, []Region{global}, 0)
 	responses := make(chan deal.QueryResponse)
 	cnet.receiveResp = func(i peer.AddrInfo, r deal.QueryResponse) {
 		responses <- r
@@ -308,7 +308,7 @@ func BenchmarkNetworkForwarding(b *testing.B) {
 		ps, err := pubsub.NewGossipSub(ctx, pnode.Host)
 		require.NoError(b, err)
 
-		pnet := NewGossipRouting(pnode.Host, ps, mtracker{false, pp}, []Region{global})
+		pnet := NewGossipRouting(pnode.Host, ps, mtracker{false, pp}, []Region{global}, 0)
 		require.NoError(b, pnet.StartProviding(ctx, calcResponse))
 		pnodes = append(pnodes, pnode)
 		pnets = append(pnets, pnet)
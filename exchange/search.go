@@ -0,0 +1,234 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrEmptyQuery is returned when a search query doesn't contain any word
+var ErrEmptyQuery = errors.New("empty query")
+
+// SearchResult is a root with entry names or metadata matching a search query
+type SearchResult struct {
+	Root cid.Cid
+	Size int64
+	// Keys are the names of the entries matching at least one word of the query. It is empty if only
+	// the metadata matched.
+	Keys []string
+	// Publishers are the peers who published the content
+	Publishers []peer.ID
+	// Cached is true if we store the content or false if we only know about it from the interest list
+	Cached bool
+}
+
+// searchDoc is everything we can search about a root
+type searchDoc struct {
+	ref        DataRef
+	keys       []string
+	publishers []peer.ID
+	cached     bool
+}
+
+// searchIndex maps the words of entry names and publisher IDs to the roots they were found in
+type searchIndex struct {
+	docs  map[string]*searchDoc
+	terms map[string]map[string]bool
+}
+
+func (si *searchIndex) add(doc *searchDoc) {
+	k := doc.ref.PayloadCID.String()
+	si.docs[k] = doc
+	var words []string
+	for _, key := range doc.keys {
+		words = append(words, tokenize(key)...)
+	}
+	for _, p := range doc.publishers {
+		words = append(words, tokenize(p.String())...)
+	}
+	for _, w := range words {
+		if si.terms[w] == nil {
+			si.terms[w] = make(map[string]bool)
+		}
+		si.terms[w][k] = true
+	}
+}
+
+// tokenize splits a string into lower case words
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchAny returns true if any query word is the prefix of one of the words
+func matchAny(query []string, words []string) bool {
+	for _, q := range query {
+		for _, w := range words {
+			if strings.HasPrefix(w, q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// invalidateSearch marks the search index as stale so it is rebuilt before the next search
+func (idx *Index) invalidateSearch() {
+	atomic.StoreInt32(&idx.searchStale, 1)
+}
+
+// buildSearch indexes the cached and interest listed refs. Names of cached entries which weren't
+// recorded in the ref are read from the root.
+func (idx *Index) buildSearch() *searchIndex {
+	si := &searchIndex{
+		docs:  make(map[string]*searchDoc),
+		terms: make(map[string]map[string]bool),
+	}
+
+	idx.mu.Lock()
+	cached := make([]DataRef, 0, len(idx.Refs))
+	for _, ref := range idx.Refs {
+		cached = append(cached, *ref)
+	}
+	idx.mu.Unlock()
+	for _, ref := range cached {
+		doc := &searchDoc{ref: ref, keys: ref.Keys, cached: true}
+		if len(doc.keys) == 0 {
+			doc.keys, _ = idx.loadKeys(ref)
+		}
+		if ref.Publisher != "" {
+			doc.publishers = append(doc.publishers, ref.Publisher)
+		}
+		if m, err := idx.GetManifest(ref.PayloadCID); err == nil {
+			for _, p := range m.Publishers {
+				if p != ref.Publisher {
+					doc.publishers = append(doc.publishers, p)
+				}
+			}
+		}
+		si.add(doc)
+	}
+
+	idx.imu.Lock()
+	interest := make([]DataRef, 0, len(idx.interest))
+	for _, ref := range idx.interest {
+		interest = append(interest, *ref)
+	}
+	idx.imu.Unlock()
+	for _, ref := range interest {
+		if _, ok := si.docs[ref.PayloadCID.String()]; ok {
+			continue
+		}
+		doc := &searchDoc{ref: ref, keys: ref.Keys}
+		if ref.Publisher != "" {
+			doc.publishers = append(doc.publishers, ref.Publisher)
+		}
+		si.add(doc)
+	}
+	return si
+}
+
+// Search returns the cached and interest listed roots with entry names or publishers matching every
+// word of the query. Query words match any word starting with them so "vid" finds "video.mp4".
+func (idx *Index) Search(query string) ([]SearchResult, error) {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	idx.smu.Lock()
+	defer idx.smu.Unlock()
+	if atomic.SwapInt32(&idx.searchStale, 0) == 1 || idx.search == nil {
+		idx.search = idx.buildSearch()
+	}
+
+	// Roots must match every word of the query
+	var roots map[string]bool
+	for _, w := range words {
+		matches := make(map[string]bool)
+		for term, docs := range idx.search.terms {
+			if !strings.HasPrefix(term, w) {
+				continue
+			}
+			for k := range docs {
+				if roots == nil || roots[k] {
+					matches[k] = true
+				}
+			}
+		}
+		roots = matches
+	}
+
+	results := make([]SearchResult, 0, len(roots))
+	for k := range roots {
+		doc := idx.search.docs[k]
+		res := SearchResult{
+			Root:       doc.ref.PayloadCID,
+			Size:       doc.ref.PayloadSize,
+			Publishers: doc.publishers,
+			Cached:     doc.cached,
+		}
+		for _, key := range doc.keys {
+			if matchAny(words, tokenize(key)) {
+				res.Keys = append(res.Keys, key)
+			}
+		}
+		results = append(results, res)
+	}
+	// Roots with the most matching entries come first then the ones we have locally
+	sort.Slice(results, func(i, j int) bool {
+		if len(results[i].Keys) != len(results[j].Keys) {
+			return len(results[i].Keys) > len(results[j].Keys)
+		}
+		if results[i].Cached != results[j].Cached {
+			return results[i].Cached
+		}
+		return results[i].Root.String() < results[j].Root.String()
+	})
+	return results, nil
+}
+
+// LoadKeys reads the names of the entries under a root we store
+func (idx *Index) LoadKeys(k cid.Cid) ([]string, error) {
+	ref, err := idx.PeekRef(k)
+	if err != nil {
+		return nil, err
+	}
+	return idx.loadKeys(*ref)
+}
+
+func (idx *Index) loadKeys(ref DataRef) ([]string, error) {
+	store, err := idx.OpenStore(ref.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	lk := cidlink.Link{Cid: ref.PayloadCID}
+	nb := basicnode.Prototype.Map.NewBuilder()
+	if err := lk.Load(context.TODO(), ipld.LinkContext{}, nb, store.Loader); err != nil {
+		return nil, err
+	}
+	var keys []string
+	it := nb.Build().MapIterator()
+	for !it.Done() {
+		kn, _, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, err := kn.AsString()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
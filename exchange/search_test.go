@@ -0,0 +1,99 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	put := func(names ...string) *Tx {
+		dir := t.TempDir()
+		tx := exch.Tx(ctx)
+		for _, name := range names {
+			p := filepath.Join(dir, name)
+			require.NoError(t, ioutil.WriteFile(p, []byte(name), 0644))
+			require.NoError(t, tx.PutFile(p))
+		}
+		require.NoError(t, tx.Commit())
+		return tx
+	}
+
+	holiday := put("holiday-video.mp4", "notes.txt")
+	report := put("Annual_Report.pdf")
+	// Entry names are read from the root if they weren't recorded
+	require.NoError(t, exch.Index().UpdateRef(report.Root(), func(ref *DataRef) {
+		ref.Keys = nil
+	}))
+
+	// A peer advertises content we don't have yet
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+	remote, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+	remoteRef := &DataRef{
+		PayloadCID:  blocksutil.NewBlockGenerator().Next().Cid(),
+		PayloadSize: 1000,
+		Publisher:   n.Host.ID(),
+		Keys:        []string{"video.mkv"},
+	}
+	require.NoError(t, remote.SetRef(remoteRef))
+	require.NoError(t, exch.Index().LoadInterest(remote.Root(), remote.store))
+
+	results, err := exch.Search("vid")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(results))
+	require.Equal(t, holiday.Root(), results[0].Root)
+	require.Equal(t, []string{"holiday-video.mp4"}, results[0].Keys)
+	require.True(t, results[0].Cached)
+	require.Equal(t, remoteRef.PayloadCID, results[1].Root)
+	require.False(t, results[1].Cached)
+
+	// Every word must match
+	results, err = exch.Search("holiday video")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+
+	results, err = exch.Search("annual report")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, report.Root(), results[0].Root)
+
+	// Content can be found by publisher
+	results, err = exch.Search(n.Host.ID().String())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	require.Equal(t, remoteRef.PayloadCID, results[0].Root)
+	require.Equal(t, 0, len(results[0].Keys))
+
+	// Dropped content isn't found anymore
+	require.NoError(t, exch.Index().DropRef(holiday.Root()))
+	results, err = exch.Search("holiday")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(results))
+
+	_, err = exch.Search("  ")
+	require.True(t, errors.Is(err, ErrEmptyQuery))
+}
@@ -0,0 +1,155 @@
+package exchange
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// shardSyncInterval is how often we check if the index changed to update the query topic subscriptions
+const shardSyncInterval = time.Second
+
+// ShardOf returns the query topic shard of a root among n shards using the prefix of its multihash digest
+func ShardOf(root cid.Cid, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	dh, err := mh.Decode(root.Hash())
+	if err != nil || len(dh.Digest) < 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(dh.Digest[:4]) % uint32(n))
+}
+
+// queryTopic returns the name of the query topic of a region, a negative shard is the unsharded topic
+func queryTopic(r Region, shard int) string {
+	if shard < 0 {
+		return fmt.Sprintf("%s/%s", PopQueryProtocolID, r.Name)
+	}
+	return fmt.Sprintf("%s/%s/%d", PopQueryProtocolID, r.Name, shard)
+}
+
+// queryTopics returns the names of the topics a query for a root is published on
+func (gr *GossipRouting) queryTopics(root cid.Cid) []string {
+	shard := -1
+	if gr.shards > 0 {
+		shard = ShardOf(root, gr.shards)
+	}
+	names := make([]string, len(gr.regions))
+	for i, r := range gr.regions {
+		names[i] = queryTopic(r, shard)
+	}
+	return names
+}
+
+// join returns a topic joining it the first time, callers must hold the lock
+func (gr *GossipRouting) join(name string) (*pubsub.Topic, error) {
+	if top, ok := gr.tops[name]; ok {
+		return top, nil
+	}
+	top, err := gr.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	gr.tops[name] = top
+	return top, nil
+}
+
+// subscribe starts answering the queries published on a topic, callers must hold the lock
+func (gr *GossipRouting) subscribe(name string, r Region) error {
+	if _, ok := gr.subs[name]; ok {
+		return nil
+	}
+	top, err := gr.join(name)
+	if err != nil {
+		return err
+	}
+	sub, err := top.Subscribe()
+	if err != nil {
+		return err
+	}
+	gr.subs[name] = sub
+	go gr.pump(gr.ctx, sub, r, gr.respond)
+	return nil
+}
+
+// Provide subscribes to the query topic shards of the given roots in each of our regions and unsubscribes
+// from the shards none of them belong to anymore so we only receive the queries for content we may have.
+// It does nothing if the topics aren't sharded.
+func (gr *GossipRouting) Provide(roots []cid.Cid) error {
+	if gr.shards == 0 {
+		return nil
+	}
+	gr.smu.Lock()
+	defer gr.smu.Unlock()
+	if gr.respond == nil {
+		return errors.New("not providing")
+	}
+	shards := make(map[int]bool)
+	for _, root := range roots {
+		shards[ShardOf(root, gr.shards)] = true
+	}
+	wanted := make(map[string]bool)
+	for _, r := range gr.regions {
+		for s := range shards {
+			name := queryTopic(r, s)
+			wanted[name] = true
+			if err := gr.subscribe(name, r); err != nil {
+				return err
+			}
+		}
+	}
+	for name, sub := range gr.subs {
+		if !wanted[name] {
+			sub.Cancel()
+			delete(gr.subs, name)
+		}
+	}
+	return nil
+}
+
+// Subscriptions returns the names of the query topics we answer queries on
+func (gr *GossipRouting) Subscriptions() []string {
+	gr.smu.Lock()
+	defer gr.smu.Unlock()
+	names := make([]string, 0, len(gr.subs))
+	for name := range gr.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// provideShards keeps the query topic subscriptions in line with the content of the index
+func (e *Exchange) provideShards(ctx context.Context) {
+	select {
+	case <-e.idx.Loaded():
+	case <-ctx.Done():
+		return
+	}
+	ticker := time.NewTicker(shardSyncInterval)
+	defer ticker.Stop()
+	synced := false
+	var seq uint64
+	for {
+		if s := e.idx.Seq(); !synced || s != seq {
+			if err := e.rou.Provide(e.idx.Keys()); err != nil {
+				discoveryLog.Warn().Err(err).Msg("failed to update query topic subscriptions")
+			} else {
+				synced, seq = true, s
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardOf(t *testing.T) {
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		root := blockGen.Next().Cid()
+		s := ShardOf(root, 4)
+		require.True(t, s >= 0 && s < 4)
+		require.Equal(t, s, ShardOf(root, 4))
+		require.Equal(t, 0, ShardOf(root, 0))
+		seen[s] = true
+	}
+	// The roots are spread over every shard
+	require.Len(t, seen, 4)
+}
+
+func TestGossipShards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n := testutil.NewTestNode(mn, t)
+	ps, err := pubsub.NewGossipSub(ctx, n.Host)
+	require.NoError(t, err)
+
+	gr := NewGossipRouting(n.Host, ps, NewGossipTracer(), []Region{global, europe}, 4)
+	root := blockGen.Next().Cid()
+	require.Error(t, gr.Provide([]cid.Cid{root}))
+
+	require.NoError(t, gr.StartProviding(ctx, calcResponse))
+	// We don't receive any query until we store something
+	require.Len(t, gr.Subscriptions(), 0)
+
+	shard := ShardOf(root, 4)
+	require.NoError(t, gr.Provide([]cid.Cid{root}))
+	require.ElementsMatch(t, []string{queryTopic(global, shard), queryTopic(europe, shard)}, gr.Subscriptions())
+	require.ElementsMatch(t, gr.Subscriptions(), gr.queryTopics(root))
+
+	require.NoError(t, gr.Provide(nil))
+	require.Len(t, gr.Subscriptions(), 0)
+
+	// Unsharded routing subscribes to a single topic per region
+	n = testutil.NewTestNode(mn, t)
+	ps, err = pubsub.NewGossipSub(ctx, n.Host)
+	require.NoError(t, err)
+	gr = NewGossipRouting(n.Host, ps, NewGossipTracer(), []Region{global}, 0)
+	require.NoError(t, gr.StartProviding(ctx, calcResponse))
+	require.Equal(t, []string{queryTopic(global, -1)}, gr.Subscriptions())
+	require.NoError(t, gr.Provide([]cid.Cid{root}))
+	require.Equal(t, []string{"/myel/pop/query/1.0/Global"}, gr.Subscriptions())
+}
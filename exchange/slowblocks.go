@@ -0,0 +1,65 @@
+package exchange
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/metrics"
+)
+
+// DefaultSlowBlockThreshold is the duration after which a block read is logged as slow
+const DefaultSlowBlockThreshold = 500 * time.Millisecond
+
+// SlowBlock is a block read which took longer than the slow block threshold
+type SlowBlock struct {
+	Cid      cid.Cid
+	StoreID  multistore.StoreID
+	Duration time.Duration
+	Err      error
+}
+
+func (sb SlowBlock) String() string {
+	s := fmt.Sprintf("slow block read: %s in store %d took %s", sb.Cid, sb.StoreID, sb.Duration)
+	if sb.Err != nil {
+		s += fmt.Sprintf(" (%s)", sb.Err)
+	}
+	return s
+}
+
+// WithSlowBlockThreshold sets the duration after which block reads are reported as slow.
+// A negative duration disables the reports.
+func WithSlowBlockThreshold(d time.Duration) IndexOption {
+	return func(idx *Index) {
+		idx.slowBlock = d
+	}
+}
+
+// WithSlowBlockFunc calls the given function with every slow block read instead of printing it
+func WithSlowBlockFunc(fn func(SlowBlock)) IndexOption {
+	return func(idx *Index) {
+		idx.slowBlockFn = fn
+	}
+}
+
+// timeRead observes the latency of a block read from a store and reports it if it is too slow.
+// Slow reads usually point to a failing disk or a datastore busy compacting.
+func (idx *Index) timeRead(c cid.Cid, id multistore.StoreID, start time.Time, err error) {
+	d := time.Since(start)
+	metrics.BlockReads.Observe(d.Seconds())
+	if idx.slowBlock < 0 || d < idx.slowBlock {
+		return
+	}
+	metrics.SlowBlockReads.Inc()
+	sb := SlowBlock{Cid: c, StoreID: id, Duration: d, Err: err}
+	if idx.slowBlockFn != nil {
+		idx.slowBlockFn(sb)
+		return
+	}
+	ev := indexLog.Warn().Str("block", sb.Cid.String()).Uint64("store", uint64(sb.StoreID)).Dur("duration", sb.Duration)
+	if sb.Err != nil {
+		ev = ev.Err(sb.Err)
+	}
+	ev.Msg("slow block read")
+}
@@ -0,0 +1,55 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/myelnet/pop/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowBlocks(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	var slow []SlowBlock
+	idx, err := NewIndex(ds, ms, WithSlowBlockFunc(func(sb SlowBlock) {
+		slow = append(slow, sb)
+	}))
+	require.NoError(t, err)
+
+	id := ms.Next()
+	store, err := idx.OpenStore(id)
+	require.NoError(t, err)
+	blk := blocks.NewBlock([]byte("hello"))
+	require.NoError(t, store.Bstore.Put(blk))
+
+	before := testutil.ToFloat64(metrics.SlowBlockReads)
+
+	// Fast reads are not reported
+	_, err = store.Bstore.Get(blk.Cid())
+	require.NoError(t, err)
+	require.Len(t, slow, 0)
+
+	// Every read is slow with a tiny threshold
+	WithSlowBlockThreshold(time.Nanosecond)(idx)
+	_, err = store.Bstore.Get(blk.Cid())
+	require.NoError(t, err)
+	require.Len(t, slow, 1)
+	require.Equal(t, blk.Cid(), slow[0].Cid)
+	require.Equal(t, id, slow[0].StoreID)
+	require.NoError(t, slow[0].Err)
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.SlowBlockReads))
+
+	// Disabled with a negative threshold
+	WithSlowBlockThreshold(-1)(idx)
+	_, err = store.Bstore.Get(blk.Cid())
+	require.NoError(t, err)
+	require.Len(t, slow, 1)
+}
@@ -0,0 +1,153 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Export writes a snapshot of the index as a CAR archive rooted at the HAMT root. The snapshot holds
+// every ref with its read frequency but not the content itself.
+func (idx *Index) Export(w io.Writer) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := idx.Flush(); err != nil {
+		return err
+	}
+	if err := car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{idx.rootCID},
+		Version: 1,
+	}, w); err != nil {
+		return err
+	}
+	seen := cid.NewSet()
+	queue := []cid.Cid{idx.rootCID}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if !seen.Visit(c) {
+			continue
+		}
+		blk, err := idx.bstore.Get(c)
+		// Refs link to content roots which are not part of the HAMT
+		if errors.Is(err, blockstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+		nd, err := cbor.DecodeBlock(blk)
+		if err != nil {
+			return err
+		}
+		for _, l := range nd.Links() {
+			queue = append(queue, l.Cid)
+		}
+	}
+	return nil
+}
+
+// Import loads the refs from a snapshot created with Export. Refs for content found in our stores are
+// added to the index with their read frequency while the others are added to the interest list so
+// the most popular content is retrieved the next time the index is refreshed. Refs we already have
+// are not modified.
+func (idx *Index) Import(r io.Reader) error {
+	h, err := car.LoadCar(idx.bstore, r)
+	if err != nil {
+		return err
+	}
+	if len(h.Roots) != 1 {
+		return fmt.Errorf("invalid index snapshot with %d roots", len(h.Roots))
+	}
+	root, err := idx.LoadRoot(h.Roots[0], idx.store)
+	if err != nil {
+		return err
+	}
+
+	var refs []*DataRef
+	err = root.ForEach(context.TODO(), func(k string, val *cbg.Deferred) error {
+		v := new(DataRef)
+		if err := v.UnmarshalCBOR(bytes.NewReader(val.Raw)); err != nil {
+			return err
+		}
+		refs = append(refs, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var missing []*DataRef
+	idx.mu.Lock()
+	for _, ref := range refs {
+		k := ref.PayloadCID.String()
+		if _, ok := idx.lookup(k); ok {
+			continue
+		}
+		if !idx.hasContent(ref) {
+			missing = append(missing, ref)
+			continue
+		}
+		idx.Refs[k] = ref
+		idx.measure(ref)
+		idx.size += idx.refSize(ref)
+		idx.addUsage(ref)
+		idx.insertRef(ref)
+		idx.recordChange(ref.PayloadCID, ref)
+		if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+			idx.mu.Unlock()
+			return err
+		}
+	}
+	if idx.ub > 0 && idx.lb > 0 && idx.size > idx.ub {
+		idx.evict(idx.size - idx.lb)
+	}
+	idx.updateMetrics()
+	err = idx.Flush()
+	idx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	for _, ref := range missing {
+		// The content will be stored in a new store once retrieved
+		ref.StoreID = 0
+		idx.addInterest(ref.PayloadCID.String(), ref)
+	}
+	idx.invalidateSearch()
+	return nil
+}
+
+// hasContent checks if the store referenced by a ref holds its root block, callers must hold the lock
+func (idx *Index) hasContent(ref *DataRef) bool {
+	found := false
+	for _, id := range idx.ms.List() {
+		if id == ref.StoreID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	store, err := idx.OpenStore(ref.StoreID)
+	if err != nil {
+		return false
+	}
+	has, err := store.Bstore.Has(ref.PayloadCID)
+	return err == nil && has
+}
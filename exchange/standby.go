@@ -0,0 +1,298 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/metrics"
+)
+
+// StandbyProtocol streams the state of a primary node to its standby
+const StandbyProtocol = protocol.ID("/myel/pop/standby/1.0")
+
+// StandbyProtocols are the versions of the standby protocol we speak
+var StandbyProtocols = Protocols{
+	{ID: StandbyProtocol},
+}
+
+// DefaultStandbyInterval is how often a standby replicates the state of its primary
+const DefaultStandbyInterval = time.Minute
+
+// StandbyNamespaces are the datastore namespaces copied to a standby on top of the refs of the index and
+// interest list. They hold the provider scores, the tracked names, the messages waiting to be dispatched,
+// the log of the retrievals served and the tags. They mirror the primary on the standby so it shouldn't
+// serve content itself and they are loaded when the standby restarts as a primary.
+var StandbyNamespaces = []string{KReputation, "/" + KNames, KOutbox, KAudit, KReceipts, KTags}
+
+// ErrNoPrimary is returned when syncing a node which isn't the standby of a primary
+var ErrNoPrimary = errors.New("no primary to replicate")
+
+// Kinds of standby snapshot frames
+const (
+	standbyRef byte = iota
+	standbyState
+)
+
+// Standby keeps a warm copy of a primary node so operators can fail over a cache node without
+// fetching all its content again. A primary serves snapshots to the standbys it trusts while
+// a standby regularly applies the snapshots of its primary. Stores are not shared so the refs of
+// the primary which the standby doesn't have yet land in its interest list with the primary's
+// frequencies and the most popular content is retrieved as the replication runs.
+type Standby struct {
+	h   host.Host
+	ds  datastore.Batching
+	idx *Index
+	// standbys are the peers allowed to replicate our state
+	standbys map[peer.ID]bool
+	// primary is the node we replicate if we are a standby
+	primary  *peer.AddrInfo
+	interval time.Duration
+
+	mu       sync.Mutex
+	lastSync time.Time
+}
+
+// NewStandby creates a standby service serving the given standbys and replicating the primary if not nil
+func NewStandby(h host.Host, ds datastore.Batching, idx *Index, primary *peer.AddrInfo, standbys []peer.ID) *Standby {
+	sb := &Standby{
+		h:        h,
+		ds:       ds,
+		idx:      idx,
+		standbys: make(map[peer.ID]bool),
+		primary:  primary,
+		interval: DefaultStandbyInterval,
+	}
+	for _, p := range standbys {
+		sb.standbys[p] = true
+	}
+	return sb
+}
+
+// Start serves our state to our standbys and replicates our primary until the context is cancelled
+func (sb *Standby) Start(ctx context.Context) {
+	if len(sb.standbys) > 0 {
+		SetStreamHandlers(sb.h, StandbyProtocols, sb.handleStream)
+	}
+	if sb.primary == nil {
+		return
+	}
+	sb.h.Peerstore().AddAddrs(sb.primary.ID, sb.primary.Addrs, time.Hour)
+	go func() {
+		ticker := time.NewTicker(sb.interval)
+		defer ticker.Stop()
+		for {
+			if err := sb.Sync(ctx); err != nil {
+				indexLog.Warn().Err(err).Msg("failed to replicate primary")
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// LastSync returns when the state of the primary was last applied
+func (sb *Standby) LastSync() time.Time {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.lastSync
+}
+
+func (sb *Standby) handleStream(s network.Stream) {
+	defer s.Close()
+	if !sb.standbys[s.Conn().RemotePeer()] {
+		s.Reset()
+		return
+	}
+	w := bufio.NewWriter(s)
+	if err := sb.writeSnapshot(w); err != nil {
+		indexLog.Warn().Err(err).Msg("failed to send standby snapshot")
+		s.Reset()
+		return
+	}
+	if err := w.Flush(); err != nil {
+		s.Reset()
+	}
+}
+
+// Sync applies a snapshot of the state of our primary
+func (sb *Standby) Sync(ctx context.Context) error {
+	if sb.primary == nil {
+		return ErrNoPrimary
+	}
+	s, err := sb.h.NewStream(ctx, sb.primary.ID, StandbyProtocols.Active(time.Now())...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := sb.applySnapshot(s); err != nil {
+		s.Reset()
+		return err
+	}
+	sb.mu.Lock()
+	sb.lastSync = time.Now()
+	sb.mu.Unlock()
+	return nil
+}
+
+// writeSnapshot writes the refs of the index and interest list and the state namespaces as length
+// prefixed frames
+func (sb *Standby) writeSnapshot(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	for _, ref := range append(sb.idx.Snapshot(), sb.idx.interestRefs()...) {
+		buf.Reset()
+		if err := ref.MarshalCBOR(buf); err != nil {
+			return err
+		}
+		if err := carutil.LdWrite(w, []byte{standbyRef}, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	for _, ns := range StandbyNamespaces {
+		res, err := sb.ds.Query(dsq.Query{Prefix: ns})
+		if err != nil {
+			return err
+		}
+		for e := range res.Next() {
+			if e.Error != nil {
+				res.Close()
+				return e.Error
+			}
+			klen := make([]byte, binary.MaxVarintLen64)
+			n := binary.PutUvarint(klen, uint64(len(e.Key)))
+			if err := carutil.LdWrite(w, []byte{standbyState}, klen[:n], []byte(e.Key), e.Value); err != nil {
+				res.Close()
+				return err
+			}
+		}
+		res.Close()
+	}
+	return nil
+}
+
+// applySnapshot reads the frames written by writeSnapshot. Applying the same snapshot twice leaves
+// the state unchanged.
+func (sb *Standby) applySnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var refs []DataRef
+	// keys is the state the primary still has
+	keys := make(map[datastore.Key]bool)
+	for {
+		frame, err := carutil.LdRead(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(frame) == 0 {
+			return errors.New("empty standby frame")
+		}
+		kind, data := frame[0], frame[1:]
+		switch kind {
+		case standbyRef:
+			var ref DataRef
+			if err := ref.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		case standbyState:
+			klen, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < klen {
+				return errors.New("invalid standby state frame")
+			}
+			k := datastore.NewKey(string(data[n : n+int(klen)]))
+			if err := sb.ds.Put(k, data[n+int(klen):]); err != nil {
+				return err
+			}
+			keys[k] = true
+		default:
+			return fmt.Errorf("unknown standby frame %d", kind)
+		}
+	}
+	// Remove the state the primary deleted since the last sync such as dispatched messages
+	for _, ns := range StandbyNamespaces {
+		res, err := sb.ds.Query(dsq.Query{Prefix: ns, KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if k := datastore.NewKey(e.Key); !keys[k] {
+				if err := sb.ds.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	sb.idx.mirrorInterest(refs)
+	return sb.idx.FlushInterest()
+}
+
+// interestRefs returns a copy of the refs in the interest list
+func (idx *Index) interestRefs() []DataRef {
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	refs := make([]DataRef, 0, len(idx.interest))
+	for _, ref := range idx.interest {
+		cp := *ref
+		cp.bucketNode = nil
+		refs = append(refs, cp)
+	}
+	return refs
+}
+
+// mirrorInterest adds the refs of another node we don't have to the interest list. Unlike AddInterest
+// the frequency of refs already listed is raised to the one of the other node instead of being added
+// so mirroring the same refs again changes nothing.
+func (idx *Index) mirrorInterest(refs []DataRef) {
+	idx.mu.Lock()
+	var missing []*DataRef
+	for i := range refs {
+		if _, ok := idx.lookup(refs[i].PayloadCID.String()); ok {
+			continue
+		}
+		missing = append(missing, &refs[i])
+	}
+	idx.mu.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	idx.imu.Lock()
+	defer idx.imu.Unlock()
+	for _, ref := range missing {
+		k := ref.PayloadCID.String()
+		if cur, ok := idx.interest[k]; ok {
+			if ref.Freq <= cur.Freq {
+				continue
+			}
+			ref.Freq -= cur.Freq
+		}
+		// The content will be stored in a new store once retrieved
+		ref.StoreID = 0
+		ref.BucketID = 0
+		idx.addInterest(k, ref)
+	}
+	idx.invalidateSearch()
+	metrics.InterestRefs.Set(float64(len(idx.interest)))
+}
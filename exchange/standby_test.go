@@ -0,0 +1,80 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandby(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	n1 := testutil.NewTestNode(mn, t)
+	n2 := testutil.NewTestNode(mn, t)
+	n3 := testutil.NewTestNode(mn, t)
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	newIndex := func() (datastore.Batching, *Index) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+		ms, err := multistore.NewMultiDstore(ds)
+		require.NoError(t, err)
+		idx, err := NewIndex(ds, ms)
+		require.NoError(t, err)
+		return ds, idx
+	}
+
+	pds, pidx := newIndex()
+	ref := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+	}
+	require.NoError(t, pidx.SetRef(ref))
+	_, err := pidx.GetRef(ref.PayloadCID)
+	require.NoError(t, err)
+	interesting := blockGen.Next().Cid()
+	pidx.AddInterest([]DataRef{{PayloadCID: interesting, PayloadSize: 2000, Freq: 3}})
+	require.NoError(t, pds.Put(datastore.NewKey(KReputation).ChildString("provider"), []byte("score")))
+	require.NoError(t, pds.Put(datastore.NewKey(KOutbox).ChildString("1"), []byte("msg")))
+
+	primary := NewStandby(n1.Host, pds, pidx, nil, []peer.ID{n2.Host.ID()})
+	primary.Start(ctx)
+
+	sds, sidx := newIndex()
+	standby := NewStandby(n2.Host, sds, sidx, &peer.AddrInfo{ID: n1.Host.ID()}, nil)
+	require.NoError(t, standby.Sync(ctx))
+	require.False(t, standby.LastSync().IsZero())
+
+	// The content of the primary is waiting to be retrieved
+	require.Equal(t, 2, sidx.InterestLen())
+	v, err := sds.Get(datastore.NewKey(KReputation).ChildString("provider"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("score"), v)
+
+	// Syncing again doesn't inflate the frequencies and removes the dispatched messages
+	require.NoError(t, pds.Delete(datastore.NewKey(KOutbox).ChildString("1")))
+	require.NoError(t, standby.Sync(ctx))
+	require.Equal(t, 2, sidx.InterestLen())
+	freqs := make(map[string]int64)
+	for _, r := range sidx.interestRefs() {
+		freqs[r.PayloadCID.String()] = r.Freq
+	}
+	require.Equal(t, int64(3), freqs[interesting.String()])
+	require.Equal(t, pidx.Snapshot()[0].Freq, freqs[ref.PayloadCID.String()])
+	_, err = sds.Get(datastore.NewKey(KOutbox).ChildString("1"))
+	require.Equal(t, datastore.ErrNotFound, err)
+
+	// Other peers cannot replicate the primary
+	other := NewStandby(n3.Host, dss.MutexWrap(datastore.NewMapDatastore()), sidx, &peer.AddrInfo{ID: n1.Host.ID()}, nil)
+	require.Error(t, other.Sync(ctx))
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/filecoin-project/go-multistore"
 	cid "github.com/ipfs/go-cid"
@@ -15,6 +16,7 @@ import (
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/ipld/go-ipld-prime/traversal"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/myelnet/pop/selectors"
 )
 
 // DAGStat describes a DAG
@@ -76,3 +78,30 @@ func Stat(ctx context.Context, store *multistore.Store, root cid.Cid, sel ipld.N
 	})
 	return res, nil
 }
+
+// EntryStat describes the DAG of an entry under a transaction root
+type EntryStat struct {
+	Key   string
+	Value cid.Cid
+	DAGStat
+}
+
+// StatEntries returns stats about the DAG of each entry under a transaction root sorted by key
+func StatEntries(ctx context.Context, store *multistore.Store, root cid.Cid) ([]EntryStat, error) {
+	entries, err := loadEntries(ctx, store, root)
+	if err != nil {
+		return nil, err
+	}
+	var stats []EntryStat
+	for key, c := range entries {
+		st, err := Stat(ctx, store, c, selectors.All())
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, EntryStat{Key: key, Value: c, DAGStat: st})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+	return stats, nil
+}
@@ -14,9 +14,12 @@ import (
 	dss "github.com/ipfs/go-datastore/sync"
 	chunk "github.com/ipfs/go-ipfs-chunker"
 	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
 	ipldformat "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-unixfs/importer/balanced"
 	"github.com/ipfs/go-unixfs/importer/helpers"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/internal/testutil"
 	sel "github.com/myelnet/pop/selectors"
 	"github.com/stretchr/testify/require"
 )
@@ -107,3 +110,42 @@ func TestStat(t *testing.T) {
 		})
 	}
 }
+
+func TestStatEntries(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+
+	tx := exch.Tx(ctx)
+	for _, p := range filepaths {
+		require.NoError(t, tx.PutFile(p))
+	}
+	status, err := tx.Status()
+	require.NoError(t, err)
+
+	entries, err := StatEntries(ctx, tx.Store(), tx.Root())
+	require.NoError(t, err)
+	require.Len(t, entries, len(filepaths))
+
+	total, err := Stat(ctx, tx.Store(), tx.Root(), sel.All())
+	require.NoError(t, err)
+	blocks := 1
+	for i, e := range entries {
+		if i > 0 {
+			require.Less(t, entries[i-1].Key, e.Key)
+		}
+		require.Equal(t, status[e.Key].Value, e.Value)
+		require.Greater(t, e.NumBlocks, 0)
+		blocks += e.NumBlocks
+	}
+	// The root block links all the entries
+	require.Equal(t, total.NumBlocks, blocks)
+}
@@ -0,0 +1,210 @@
+package exchange
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// KStats is the datastore key prefix of the access statistics of each root
+const KStats = "/stats"
+
+// HourlyBuckets is the number of hours of reads kept for each root
+const HourlyBuckets = 48
+
+// DailyBuckets is the number of days of reads kept for each root. Roots which weren't read for that long
+// lose their statistics.
+const DailyBuckets = 30
+
+// AccessBucket counts the reads of a root during a period
+type AccessBucket struct {
+	// Start is the unix time at which the period starts
+	Start int64 `json:"start"`
+	// Local are the reads of this node and Remote the retrievals served to other peers
+	Local  uint64 `json:"local"`
+	Remote uint64 `json:"remote"`
+}
+
+// Reads returns the total number of reads during the period
+func (b AccessBucket) Reads() uint64 {
+	return b.Local + b.Remote
+}
+
+// accessRing is a ring of buckets covering consecutive periods. The slot of a period is reused once the
+// ring wraps around.
+type accessRing []AccessBucket
+
+// slot returns the index and the start of the bucket covering a time
+func (r accessRing) slot(period time.Duration, t time.Time) (int, int64) {
+	n := t.Unix() / int64(period/time.Second)
+	return int(n % int64(len(r))), n * int64(period/time.Second)
+}
+
+// add counts a read in the bucket of the given time
+func (r accessRing) add(period time.Duration, t time.Time, remote bool) {
+	i, start := r.slot(period, t)
+	if r[i].Start != start {
+		r[i] = AccessBucket{Start: start}
+	}
+	if remote {
+		r[i].Remote++
+	} else {
+		r[i].Local++
+	}
+}
+
+// last returns the buckets of the last n periods until the given time, oldest first. Periods without
+// reads have empty buckets.
+func (r accessRing) last(period time.Duration, n int, t time.Time) []AccessBucket {
+	if n > len(r) {
+		n = len(r)
+	}
+	buckets := make([]AccessBucket, n)
+	for j := 0; j < n; j++ {
+		pt := t.Add(-time.Duration(n-1-j) * period)
+		i, start := r.slot(period, pt)
+		buckets[j] = AccessBucket{Start: start}
+		if len(r) > 0 && r[i].Start == start {
+			buckets[j] = r[i]
+		}
+	}
+	return buckets
+}
+
+// latest returns the start of the most recent bucket with reads
+func (r accessRing) latest() int64 {
+	var start int64
+	for _, b := range r {
+		if b.Start > start {
+			start = b.Start
+		}
+	}
+	return start
+}
+
+// AccessStats are the reads of a root bucketed by hour and by day
+type AccessStats struct {
+	Root   cid.Cid        `json:"root"`
+	Hourly []AccessBucket `json:"hourly"`
+	Daily  []AccessBucket `json:"daily"`
+}
+
+// Hours returns the reads of the last n hours until the given time, oldest first
+func (s AccessStats) Hours(n int, t time.Time) []AccessBucket {
+	return accessRing(s.Hourly).last(time.Hour, n, t)
+}
+
+// Days returns the reads of the last n days until the given time, oldest first
+func (s AccessStats) Days(n int, t time.Time) []AccessBucket {
+	return accessRing(s.Daily).last(24*time.Hour, n, t)
+}
+
+// accessLog persists the access statistics of each root
+type accessLog struct {
+	ds  datastore.Batching
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func newAccessLog(ds datastore.Batching) *accessLog {
+	return &accessLog{
+		ds:  namespace.Wrap(ds, datastore.NewKey(KStats)),
+		now: time.Now,
+	}
+}
+
+func (al *accessLog) get(root cid.Cid) (AccessStats, error) {
+	s := AccessStats{
+		Root:   root,
+		Hourly: make([]AccessBucket, HourlyBuckets),
+		Daily:  make([]AccessBucket, DailyBuckets),
+	}
+	buf, err := al.ds.Get(datastore.NewKey(root.String()))
+	if err == datastore.ErrNotFound {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return s, err
+	}
+	// The rings keep their length if the number of buckets changed
+	if len(s.Hourly) != HourlyBuckets {
+		s.Hourly = make([]AccessBucket, HourlyBuckets)
+	}
+	if len(s.Daily) != DailyBuckets {
+		s.Daily = make([]AccessBucket, DailyBuckets)
+	}
+	return s, nil
+}
+
+// record counts a read of a root in its hourly and daily buckets
+func (al *accessLog) record(root cid.Cid, remote bool) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	s, err := al.get(root)
+	if err != nil {
+		return err
+	}
+	now := al.now()
+	accessRing(s.Hourly).add(time.Hour, now, remote)
+	accessRing(s.Daily).add(24*time.Hour, now, remote)
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return al.ds.Put(datastore.NewKey(root.String()), buf)
+}
+
+// stats returns the access statistics of a root, roots which were never read have empty buckets
+func (al *accessLog) stats(root cid.Cid) (AccessStats, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.get(root)
+}
+
+// prune deletes the statistics of the roots which weren't read during the days we keep
+func (al *accessLog) prune() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	res, err := al.ds.Query(dsq.Query{})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	oldest := al.now().Add(-DailyBuckets * 24 * time.Hour).Unix()
+	for _, e := range entries {
+		var s AccessStats
+		if err := json.Unmarshal(e.Value, &s); err != nil {
+			return err
+		}
+		if accessRing(s.Daily).latest() < oldest {
+			if err := al.ds.Delete(datastore.NewKey(e.Key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordAccess counts a read in the access statistics, failing to do so doesn't fail the read
+func (idx *Index) recordAccess(root cid.Cid, remote bool) {
+	if err := idx.access.record(root, remote); err != nil {
+		indexLog.Warn().Err(err).Str("root", root.String()).Msg("failed to record access")
+	}
+}
+
+// AccessStats returns the reads of a root bucketed by hour and by day. The statistics are kept for a
+// while after the content is evicted so they can inform prefetching it again.
+func (idx *Index) AccessStats(root cid.Cid) (AccessStats, error) {
+	return idx.access.stats(root)
+}
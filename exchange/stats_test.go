@@ -0,0 +1,90 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessStats(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+	// Half past noon today so the reads of the next hour are on the same day
+	now := time.Now().Truncate(24 * time.Hour).Add(12*time.Hour + 30*time.Minute)
+	idx.access.now = func() time.Time { return now }
+
+	ref := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+	}
+	require.NoError(t, idx.SetRef(ref))
+
+	_, err = idx.GetRef(ref.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.GetRef(ref.PayloadCID)
+	require.NoError(t, err)
+	require.NoError(t, idx.RemoteRead(ref.PayloadCID))
+
+	// An hour later
+	now = now.Add(time.Hour)
+	_, err = idx.GetRef(ref.PayloadCID)
+	require.NoError(t, err)
+
+	// The next day
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, idx.RemoteRead(ref.PayloadCID))
+
+	stats, err := idx.AccessStats(ref.PayloadCID)
+	require.NoError(t, err)
+
+	hours := stats.Hours(HourlyBuckets, now)
+	require.Len(t, hours, HourlyBuckets)
+	last := hours[len(hours)-1]
+	require.Equal(t, now.Truncate(time.Hour).Unix(), last.Start)
+	require.Equal(t, uint64(1), last.Remote)
+	// 24 hours before
+	require.Equal(t, uint64(1), hours[len(hours)-25].Local)
+	require.Equal(t, uint64(2), hours[len(hours)-26].Local)
+	require.Equal(t, uint64(1), hours[len(hours)-26].Remote)
+
+	days := stats.Days(3, now)
+	require.Len(t, days, 3)
+	require.Equal(t, uint64(0), days[0].Reads())
+	require.Equal(t, uint64(4), days[1].Reads())
+	require.Equal(t, uint64(1), days[2].Reads())
+
+	// The statistics are persisted
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	stats, err = idx.AccessStats(ref.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.Days(1, now)[0].Remote)
+
+	// Old buckets are reused once the ring wraps around
+	idx.access.now = func() time.Time { return now }
+	now = now.Add(HourlyBuckets * time.Hour)
+	_, err = idx.GetRef(ref.PayloadCID)
+	require.NoError(t, err)
+	stats, err = idx.AccessStats(ref.PayloadCID)
+	require.NoError(t, err)
+	hours = stats.Hours(HourlyBuckets, now)
+	require.Equal(t, uint64(1), hours[len(hours)-1].Local)
+	require.Equal(t, uint64(0), hours[len(hours)-1].Remote)
+
+	// Roots nobody read for longer than the days we keep lose their statistics
+	now = now.Add((DailyBuckets + 1) * 24 * time.Hour)
+	require.NoError(t, idx.access.prune())
+	stats, err = idx.AccessStats(ref.PayloadCID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), stats.Days(1, now)[0].Reads())
+	_, err = idx.access.ds.Get(datastore.NewKey(ref.PayloadCID.String()))
+	require.Equal(t, datastore.ErrNotFound, err)
+}
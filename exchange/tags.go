@@ -0,0 +1,194 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// KTags is the datastore key prefix of the tags naming roots
+const KTags = "/tags"
+
+// ErrTagNotFound is returned when no root is tagged with a name
+var ErrTagNotFound = errors.New("tag not found")
+
+// ErrTagMoved is returned when re-pointing a tag which doesn't point to the expected root anymore
+var ErrTagMoved = errors.New("tag was moved")
+
+// ErrInvalidTag is returned when a name cannot be used as a tag
+var ErrInvalidTag = errors.New("invalid tag")
+
+// Tag is a human readable name pointing to a root
+type Tag struct {
+	Name string    `json:"name"`
+	Root cid.Cid   `json:"root"`
+	Time time.Time `json:"time"`
+}
+
+// ValidateTag checks a name can be used as a tag. Tags are used in place of the root of a path so
+// they follow the rules of keys and cannot be parsed as a CID.
+func ValidateTag(name string) error {
+	if err := Key(name).Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTag, err)
+	}
+	if _, err := cid.Decode(name); err == nil {
+		return fmt.Errorf("%w: %s is a CID", ErrInvalidTag, name)
+	}
+	return nil
+}
+
+// Tags persists the names given to roots so they can be used anywhere a root CID is expected. Moving
+// a tag to a new root is atomic so a dataset can be updated without readers seeing a missing name.
+type Tags struct {
+	ds datastore.Batching
+	// mu serializes the updates so a tag is only moved from the root it was read at
+	mu sync.Mutex
+}
+
+// NewTags creates a tag store in the given datastore
+func NewTags(ds datastore.Batching) *Tags {
+	return &Tags{
+		ds: namespace.Wrap(ds, datastore.NewKey(KTags)),
+	}
+}
+
+// Set points a tag to a root, replacing the root it pointed to if any
+func (t *Tags) Set(name string, root cid.Cid) error {
+	if err := ValidateTag(name); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.put(Tag{Name: name, Root: root, Time: time.Now()})
+}
+
+// Move points a tag to a new root only if it still points to the expected root. Concurrent updates
+// of a dataset fail with ErrTagMoved instead of overwriting each other.
+func (t *Tags) Move(name string, from, to cid.Cid) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, err := t.get(name)
+	if err != nil {
+		return err
+	}
+	if !cur.Root.Equals(from) {
+		return fmt.Errorf("%w: %s points to %s", ErrTagMoved, name, cur.Root)
+	}
+	return t.put(Tag{Name: name, Root: to, Time: time.Now()})
+}
+
+// Get returns the tag with the given name
+func (t *Tags) Get(name string) (Tag, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.get(name)
+}
+
+// Delete removes a tag. The root it pointed to is left in the index.
+func (t *Tags) Delete(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.get(name); err != nil {
+		return err
+	}
+	return t.ds.Delete(datastore.NewKey(name))
+}
+
+// List returns all the tags sorted by name
+func (t *Tags) List() ([]Tag, error) {
+	res, err := t.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]Tag, 0, len(entries))
+	for _, e := range entries {
+		var tag Tag
+		if err := json.Unmarshal(e.Value, &tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// Resolve returns the root of a CID or a tag
+func (t *Tags) Resolve(s string) (cid.Cid, error) {
+	if root, err := cid.Decode(s); err == nil {
+		return root, nil
+	}
+	tag, err := t.Get(s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("root is neither a CID nor a tag: %w", err)
+	}
+	return tag.Root, nil
+}
+
+// ResolvePath parses a path like ParsePath but the root can also be a tag
+func (t *Tags) ResolvePath(s string) (Path, error) {
+	return parsePath(s, t.Resolve)
+}
+
+// CheckPath validates a path whose root may be a CID, a tag or an /ipns/ name without resolving it
+func CheckPath(s string) error {
+	if name := strings.TrimPrefix(s, "/ipns/"); name != s {
+		segs := strings.SplitN(name, "/", 2)
+		if segs[0] == "" {
+			return fmt.Errorf("%w: empty IPNS name", ErrInvalidPath)
+		}
+		// Only the keys remain to be checked so the name is replaced with a valid tag
+		segs[0] = "ipns"
+		s = strings.Join(segs, "/")
+	}
+	_, err := parsePath(s, func(r string) (cid.Cid, error) {
+		if root, err := cid.Decode(r); err == nil {
+			return root, nil
+		}
+		if err := ValidateTag(r); err != nil {
+			return cid.Undef, fmt.Errorf("root is neither a CID nor a tag: %w", err)
+		}
+		return cid.Undef, nil
+	})
+	return err
+}
+
+func (t *Tags) get(name string) (Tag, error) {
+	if err := ValidateTag(name); err != nil {
+		return Tag{}, err
+	}
+	v, err := t.ds.Get(datastore.NewKey(name))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return Tag{}, fmt.Errorf("%w: %s", ErrTagNotFound, name)
+	}
+	if err != nil {
+		return Tag{}, err
+	}
+	var tag Tag
+	if err := json.Unmarshal(v, &tag); err != nil {
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+func (t *Tags) put(tag Tag) error {
+	v, err := json.Marshal(tag)
+	if err != nil {
+		return err
+	}
+	return t.ds.Put(datastore.NewKey(tag.Name), v)
+}
@@ -0,0 +1,59 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	tags := NewTags(ds)
+
+	v1 := blockGen.Next().Cid()
+	v2 := blockGen.Next().Cid()
+	v3 := blockGen.Next().Cid()
+
+	require.NoError(t, tags.Set("dataset", v1))
+	require.True(t, errors.Is(tags.Set(v1.String(), v2), ErrInvalidTag))
+	require.True(t, errors.Is(tags.Set("a/b", v2), ErrInvalidTag))
+
+	root, err := tags.Resolve("dataset")
+	require.NoError(t, err)
+	require.Equal(t, v1, root)
+	root, err = tags.Resolve(v2.String())
+	require.NoError(t, err)
+	require.Equal(t, v2, root)
+	_, err = tags.Resolve("missing")
+	require.True(t, errors.Is(err, ErrTagNotFound))
+
+	p, err := tags.ResolvePath("/dataset/file.txt")
+	require.NoError(t, err)
+	require.Equal(t, NewPath(v1, "file.txt"), p)
+	require.NoError(t, CheckPath("dataset/file.txt"))
+	require.Error(t, CheckPath("../file.txt"))
+
+	// Moving from a stale root fails
+	require.NoError(t, tags.Move("dataset", v1, v2))
+	require.True(t, errors.Is(tags.Move("dataset", v1, v3), ErrTagMoved))
+	tag, err := tags.Get("dataset")
+	require.NoError(t, err)
+	require.Equal(t, v2, tag.Root)
+
+	// Tags are loaded from the datastore
+	require.NoError(t, tags.Set("archive", v3))
+	list, err := NewTags(ds).List()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	require.Equal(t, "archive", list[0].Name)
+	require.Equal(t, v2, list[1].Root)
+
+	require.NoError(t, tags.Delete("archive"))
+	require.True(t, errors.Is(tags.Delete("archive"), ErrTagNotFound))
+	list, err = tags.List()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+}
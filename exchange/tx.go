@@ -5,23 +5,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"text/tabwriter"
 	"time"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	cid "github.com/ipfs/go-cid"
-	chunk "github.com/ipfs/go-ipfs-chunker"
 	files "github.com/ipfs/go-ipfs-files"
+	keystore "github.com/ipfs/go-ipfs-keystore"
 	ipldformat "github.com/ipfs/go-ipld-format"
-	"github.com/ipfs/go-merkledag"
 	unixfile "github.com/ipfs/go-unixfs/file"
-	"github.com/ipfs/go-unixfs/importer/balanced"
-	"github.com/ipfs/go-unixfs/importer/helpers"
+	uio "github.com/ipfs/go-unixfs/io"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
@@ -30,15 +32,26 @@ import (
 	"github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/retrieval"
 	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/selectors"
 )
 
 // DefaultHashFunction used for generating CIDs of imported data
 // although less convenient than SHA2, BLAKE2B seems to be more peformant in most cases
 const DefaultHashFunction = uint64(mh.BLAKE2B_MIN + 31)
 
+// DefaultStallTimeout is how long a retrieval may go without any update before the next offer is tried
+const DefaultStallTimeout = 5 * time.Minute
+
 // ErrNoStrategy is returned when we try querying content without a read strategy
 var ErrNoStrategy = errors.New("no strategy")
 
+// ErrNotEnoughConfirmations is returned when committing if fewer providers than required by
+// the dispatch policy confirmed they received the content
+var ErrNotEnoughConfirmations = errors.New("not enough confirmations")
+
+// ErrEntryNotStaged is returned when selecting an entry which isn't part of the transaction
+var ErrEntryNotStaged = errors.New("entry not staged")
+
 // Entry represents a link to an item in the DAG map
 type Entry struct {
 	// Key is string name of the entry
@@ -67,7 +80,9 @@ type Tx struct {
 	storeID multistore.StoreID
 	// store is the isolated blockstore and DAG instances for this session
 	store *multistore.Store
-	// entries is the cached reference to values used during the session
+	// entries is the cached reference to values used during the session. emu guards them with the root and
+	// size built from them as PutDir adds its entry in the background.
+	emu     sync.Mutex
 	entries map[string]Entry
 	// disco is the discovery mechanism for finding content offers
 	rou *GossipRouting
@@ -85,8 +100,48 @@ type Tx struct {
 	size int64
 	// chunk size is the chunk size to use when adding files
 	chunkSize int64
+	// chunker configures how files are split and linked when adding them
+	chunker ChunkerConfig
+	// cidBuilder builds the CIDs of the DAG nodes instead of the chunker settings if set
+	cidBuilder cid.Builder
+	// contracts are the providers tried first to retrieve and dispatch the content
+	contracts []Contract
+	// rec captures the offers we receive while a recording is running
+	rec *Recorder
+	// labels are saved with the ref when committing
+	labels map[string]string
 	// cacheRF is the cache replication factor used when committing to storage
 	cacheRF int
+	// prefetch is the maximum number of blocks loaded ahead of sequential file reads, 0 disables prefetching
+	prefetch int
+	// manifest gates the publication of the root behind the signatures of multiple publishers
+	manifest *Manifest
+	// regions are preferred when dispatching and retrieving content
+	regions []Region
+	// shardK and shardN enable erasure coding when committing, any shardK of the shardN shards
+	// are enough to reconstruct the content. Erasure coding is disabled if shardN is 0
+	shardK int
+	shardN int
+	// shards is the manifest of the shards the content is reconstructed from
+	shards *ShardManifest
+	// subtrees dispatches each entry to different providers when committing
+	subtrees bool
+	// placement is the manifest of the providers the subtrees are assembled from
+	placement *PlacementManifest
+	// pathKeys are the entries selected by the queried path if any
+	pathKeys []string
+	// bitswap retrieves the content if no provider offered to serve it
+	bitswap *BitswapFallback
+	// dht advertises the committed root and finds providers if the gossip network yields no offer
+	dht *DHTRouting
+	// filFallback queries filMiners or the miners archiving the root if no provider offered to serve
+	// the content after filDelay
+	filFallback bool
+	filMiners   []address.Address
+	filAPI      filecoin.API
+	filDelay    time.Duration
+	// newTx starts the transactions retrieving individual shards
+	newTx func(context.Context, ...TxOption) *Tx
 	// sel is the selector used to select specific nodes only to retrieve. if not provided we select
 	// all the nodes by default
 	sel ipld.Node
@@ -94,7 +149,22 @@ type Tx struct {
 	// is not nil we've run out of options and nothing we can do at this time will get us the content.
 	done chan TxResult
 	// errs receives any kind of error status from execution so we can try to fix it.
-	errs chan deal.Status
+	errs chan error
+	// failures records the cause of failing deals and when we last heard from them
+	failures *dealFailures
+	// progress streams the state of the deals executed by the transaction
+	progress *txProgress
+	// deals are cancelled if they are still running when the transaction is closed
+	deals *txDeals
+	// stallTimeout is how long a retrieval may go without any event before it is considered stalled
+	stallTimeout time.Duration
+	// stallRestarts is the number of times the transfer of a stalled retrieval is restarted before giving up
+	stallRestarts int
+	// offers is the number of offers received and expensive the number declined for their price
+	offers    int32
+	expensive int32
+	// execErr is the error of the last offer which failed to execute
+	execErr error
 	// unsubscribes is used to clear any subscriptions to our retrieval events when we have received
 	// all the content
 	unsub retrieval.Unsubscribe
@@ -107,8 +177,33 @@ type Tx struct {
 	triage chan DealSelection
 	// dispatching is a stream of peer confirmations when dispatching updates
 	dispatching chan PRecord
+	// minConfirmations is the number of providers which must confirm storing the content
+	// before Commit returns. If 0 Commit returns as soon as the dispatch starts
+	minConfirmations int
+	// confirmTimeout is how long Commit waits for confirmations. If 0 it waits until the dispatch is over
+	confirmTimeout time.Duration
+	// dispatchProgress is called with the bytes sent to each provider while dispatching
+	dispatchProgress func(peer.ID, uint64)
+	// uplink is the number of bytes per second the dispatch may use, 0 is unlimited
+	uplink uint64
+	// dispatchDeadline is how long after Commit all the copies should be sent
+	dispatchDeadline time.Duration
+	// ipns publishes the root under the IPNS name of ipnsKey when committing if the key is set
+	ipns     *IPNS
+	ks       keystore.Keystore
+	ipnsKey  string
+	ipnsName string
+	// names resolves the /ipns/ and domain paths queried
+	names NameResolver
+	// confirmed is the list of providers which confirmed storing the content
+	cmu       sync.Mutex
+	confirmed []peer.ID
 	// Err exposes any error reported by the session during use
 	Err error
+
+	// selected is the last offer we started executing
+	omu      sync.Mutex
+	selected *deal.Offer
 }
 
 // TxOption sets optional fields on a Tx struct
@@ -118,8 +213,12 @@ type TxOption func(*Tx)
 func WithStrategy(strategy SelectionStrategy) TxOption {
 	return func(tx *Tx) {
 		tx.worker = strategy(tx)
+		if sw, ok := tx.worker.(sessionWorker); ok {
+			sw.rank = tx.preferRegions(sw.rank)
+			tx.worker = sw
+		}
 		tx.worker.Start()
-		tx.rou.SetReceiver(tx.worker.ReceiveResponse)
+		tx.rou.SetReceiver(tx.receiveOffer)
 	}
 }
 
@@ -131,6 +230,58 @@ func WithRoot(r cid.Cid) TxOption {
 	}
 }
 
+// WithSelector sets the selector used to query and retrieve only part of the DAG
+func WithSelector(sel ipld.Node) TxOption {
+	return func(tx *Tx) {
+		tx.sel = sel
+	}
+}
+
+// WithPrefetch loads up to max blocks ahead of sequential file reads. If the transaction is retrieving
+// the content, reads wait for missing blocks to be received so a file can be streamed while it is transferred.
+func WithPrefetch(max int) TxOption {
+	return func(tx *Tx) {
+		tx.prefetch = max
+	}
+}
+
+// WithDispatchPolicy makes Commit block until at least minConfirmations providers confirmed they
+// received the content. Commit fails if not enough providers confirmed before the timeout.
+func WithDispatchPolicy(minConfirmations int, timeout time.Duration) TxOption {
+	return func(tx *Tx) {
+		tx.minConfirmations = minConfirmations
+		tx.confirmTimeout = timeout
+	}
+}
+
+// WithDispatchProgress calls fn with the number of bytes sent to each provider while the content is
+// dispatched. Providers receive the content concurrently so fn may be called from different goroutines.
+func WithDispatchProgress(fn func(peer.ID, uint64)) TxOption {
+	return func(tx *Tx) {
+		tx.dispatchProgress = fn
+	}
+}
+
+// WithDispatchPacing paces the transfers of the dispatch to use at most bandwidth bytes per second of our
+// uplink. Providers pull the content at the same time if the budget allows sending all the copies within
+// the deadline, one after the other otherwise. Transfers which wouldn't complete before the deadline
+// aren't started. A deadline of 0 sends the copies one after the other without time limit.
+func WithDispatchPacing(bandwidth uint64, deadline time.Duration) TxOption {
+	return func(tx *Tx) {
+		tx.uplink = bandwidth
+		tx.dispatchDeadline = deadline
+	}
+}
+
+// WithRegions prefers providers in the given regions when dispatching and retrieving content.
+// Strategies comparing offers rank the offers from these regions first and the dispatch only
+// selects providers in other regions if there aren't enough in these ones.
+func WithRegions(rgs []Region) TxOption {
+	return func(tx *Tx) {
+		tx.regions = rgs
+	}
+}
+
 // WithTriage allows a transaction to manually prompt for external confirmation before executing an offer
 func WithTriage() TxOption {
 	return func(tx *Tx) {
@@ -144,22 +295,176 @@ func (tx *Tx) SetChunkSize(size int64) {
 	tx.chunkSize = size
 }
 
+// WithChunker sets how files are split into blocks and linked when adding them to the transaction
+func WithChunker(c ChunkerConfig) TxOption {
+	return func(tx *Tx) {
+		tx.chunker = c
+	}
+}
+
+// SetChunker changes how files are split and linked between put operations
+func (tx *Tx) SetChunker(c ChunkerConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	tx.chunker = c
+	return nil
+}
+
+// WithCidBuilder sets the CID version and hash function of the DAG nodes created in the transaction.
+// It overrides the CID settings of the chunker and the root is hashed with the same function.
+func WithCidBuilder(b cid.Builder) TxOption {
+	return func(tx *Tx) {
+		tx.cidBuilder = b
+	}
+}
+
+// SetCidBuilder changes the CID builder between put operations, the root uses the builder set
+// when it is built
+func (tx *Tx) SetCidBuilder(b cid.Builder) {
+	tx.cidBuilder = b
+}
+
+// chunkerConfig returns the chunker with the CID builder of the transaction if any
+func (tx *Tx) chunkerConfig() ChunkerConfig {
+	c := tx.chunker
+	if tx.cidBuilder != nil {
+		c.CidBuilder = tx.cidBuilder
+	}
+	return c
+}
+
+// WithStallTimeout sets how long a retrieval may go without any progress before the next offer is tried.
+// Default is the StallTimeout of the exchange options, 0 never gives up on a retrieval.
+func WithStallTimeout(d time.Duration) TxOption {
+	return func(tx *Tx) {
+		tx.stallTimeout = d
+	}
+}
+
+// WithStallRestarts sets how many times the data transfer of a stalled retrieval is restarted before the
+// deal is cancelled and the next offer tried. Default is DefaultStallRestarts.
+func WithStallRestarts(n int) TxOption {
+	return func(tx *Tx) {
+		tx.stallRestarts = n
+	}
+}
+
+// WithIPNS publishes the root under the IPNS name of a key from the keystore when committing
+func WithIPNS(key string) TxOption {
+	return func(tx *Tx) {
+		tx.ipnsKey = key
+	}
+}
+
+// SetIPNS sets the keystore key the root is published under when committing
+func (tx *Tx) SetIPNS(key string) {
+	tx.ipnsKey = key
+}
+
+// IPNSName returns the /ipns/ name the root was published under once committed
+func (tx *Tx) IPNSName() string {
+	return tx.ipnsName
+}
+
+// publishIPNS signs and publishes an IPNS record pointing to the root with the key of the transaction
+func (tx *Tx) publishIPNS() error {
+	if tx.ipns == nil {
+		return ErrNoValueStore
+	}
+	sk, err := tx.ks.Get(tx.ipnsKey)
+	if err != nil {
+		return fmt.Errorf("failed to get IPNS key %s: %w", tx.ipnsKey, err)
+	}
+	tx.ipnsName, err = tx.ipns.Publish(tx.ctx, sk, tx.root)
+	return err
+}
+
 // SetCacheRF sets the cache replication factor before committing
 // we don't set it as an option as the value may only be known when committing
 func (tx *Tx) SetCacheRF(rf int) {
 	tx.cacheRF = rf
 }
 
+// SetDispatchPacing sets the uplink budget and deadline of the dispatch, see WithDispatchPacing
+func (tx *Tx) SetDispatchPacing(bandwidth uint64, deadline time.Duration) {
+	tx.uplink = bandwidth
+	tx.dispatchDeadline = deadline
+}
+
+// SetManifest sets a manifest signed by multiple publishers to send along the content when committing.
+// The manifest must be signed by enough publishers before committing.
+func (tx *Tx) SetManifest(m *Manifest) {
+	tx.manifest = m
+}
+
 // PutFile adds or replaces a file into the transaction
 // it is _not_ thread safe
 func (tx *Tx) PutFile(path string) error {
 	if tx.Err != nil {
 		return tx.Err
 	}
-	err := tx.add(path)
+	return tx.add(path)
+}
+
+// PutReader adds or replaces an entry under the given key with the content read until EOF
+func (tx *Tx) PutReader(key string, r io.Reader) error {
+	if tx.Err != nil {
+		return tx.Err
+	}
+	if err := Key(key).Validate(); err != nil {
+		return err
+	}
+	cr := &countReader{r: r}
+	n, err := tx.importFile(files.NewReaderFile(cr))
 	if err != nil {
 		return err
 	}
+	return tx.setEntry(Entry{
+		Key:   key,
+		Value: n.Cid(),
+		Size:  cr.n,
+	})
+}
+
+// Stage adds the entry of another transaction under the same key. The blocks of the entry are copied
+// from the store of the other transaction so it can be closed once the entries are moved.
+func (tx *Tx) Stage(from *Tx, key string) error {
+	if tx.Err != nil {
+		return tx.Err
+	}
+	from.emu.Lock()
+	e, ok := from.entries[key]
+	from.emu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: %w", key, ErrEntryNotStaged)
+	}
+	if err := copyDAG(tx.ctx, from.store.Bstore, tx.store.Bstore, e.Value); err != nil {
+		return err
+	}
+	return tx.setEntry(e)
+}
+
+// Unstage removes the entry under the given key from the transaction. The blocks of the entry are
+// not deleted from the store as other entries may share them.
+func (tx *Tx) Unstage(key string) error {
+	if tx.Err != nil {
+		return tx.Err
+	}
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
+	if _, ok := tx.entries[key]; !ok {
+		return fmt.Errorf("%s: %w", key, ErrEntryNotStaged)
+	}
+	delete(tx.entries, key)
+	return tx.buildRoot()
+}
+
+// setEntry adds or replaces an entry and rebuilds the root
+func (tx *Tx) setEntry(e Entry) error {
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
+	tx.entries[e.Key] = e
 	return tx.buildRoot()
 }
 
@@ -172,63 +477,230 @@ func (tx *Tx) add(path string) error {
 	if err != nil {
 		return err
 	}
-	key := KeyFromPath(path)
+	key := FileKey(path)
+	if err := key.Validate(); err != nil {
+		return err
+	}
 
 	switch f := file.(type) {
 	case files.Directory:
-		return tx.addDir(key, f)
+		return fmt.Errorf("%s is a directory, use PutDir instead", path)
 	case files.File:
-		return tx.addFile(key, f)
+		return tx.addFile(key.String(), f)
 	default:
 		return fmt.Errorf("unknown file type")
 	}
 }
 
 func (tx *Tx) addFile(key string, f files.File) error {
-	bufferedDS := ipldformat.NewBufferedDAG(tx.ctx, tx.store.DAG)
+	n, err := tx.importFile(f)
+	if err != nil {
+		return err
+	}
 
-	prefix, err := merkledag.PrefixForCidVersion(1)
+	e := Entry{}
+	e.Key = key
+	e.Value = n.Cid()
+	e.Size, err = f.Size()
 	if err != nil {
 		return err
 	}
-	prefix.MhType = DefaultHashFunction
+	return tx.setEntry(e)
+}
+
+// importFile chunks a file and writes the resulting UnixFS DAG into the transaction store
+func (tx *Tx) importFile(f files.File) (ipldformat.Node, error) {
+	return importFile(tx.ctx, tx.store.DAG, f, tx.chunkerConfig(), tx.chunkSize)
+}
+
+// PutProgress reports the result of importing a single file during a PutDir operation
+type PutProgress struct {
+	// Path is the location of the file on disk
+	Path string
+	// Key is the path of the file relative to the transaction entry
+	Key string
+	// Cid is the root of the file DAG
+	Cid cid.Cid
+	// Size is the original file size
+	Size int64
+	// Err is set if the file or the directory could not be imported
+	Err error
+}
+
+// PutOption customizes how content is added to a transaction
+type PutOption func(*putOptions)
+
+type putOptions struct {
+	include []string
+	exclude []string
+}
+
+// WithInclude only imports files with a name or relative path matching one of the given glob patterns
+func WithInclude(patterns ...string) PutOption {
+	return func(o *putOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
 
-	params := helpers.DagBuilderParams{
-		Maxlinks:   1024,
-		RawLeaves:  true,
-		CidBuilder: prefix,
-		Dagserv:    bufferedDS,
+// WithExclude skips any file or directory with a name or relative path matching one of the given glob patterns
+func WithExclude(patterns ...string) PutOption {
+	return func(o *putOptions) {
+		o.exclude = append(o.exclude, patterns...)
 	}
+}
 
-	db, err := params.New(chunk.NewSizeSplitter(f, tx.chunkSize))
-	if err != nil {
-		return err
+// matchAny returns true if the name or the relative path matches any of the patterns
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PutDir walks a directory tree and adds it to the transaction as a single entry,
+// preserving the hierarchy as nested UnixFS directories. The returned channel receives
+// a progress record for every imported file and is closed once the root was rebuilt.
+// The transaction should not be used until the channel is closed.
+func (tx *Tx) PutDir(path string, opts ...PutOption) <-chan PutProgress {
+	o := putOptions{}
+	for _, opt := range opts {
+		opt(&o)
 	}
+	progress := make(chan PutProgress, 16)
+	go func() {
+		defer close(progress)
+		if err := tx.putDir(path, o, progress); err != nil {
+			// Nobody may be reading anymore once the transaction is closed
+			_ = tx.sendProgress(progress, PutProgress{Path: path, Err: err})
+		}
+	}()
+	return progress
+}
 
-	n, err := balanced.Layout(db)
+func (tx *Tx) putDir(path string, o putOptions, progress chan<- PutProgress) error {
+	if tx.Err != nil {
+		return tx.Err
+	}
+	st, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-
-	err = bufferedDS.Commit()
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	file, err := files.NewSerialFile(path, false, st)
 	if err != nil {
 		return err
 	}
-
-	e := Entry{}
-	e.Key = key
-	e.Value = n.Cid()
-	e.Size, err = f.Size()
+	dir, ok := file.(files.Directory)
+	if !ok {
+		return fmt.Errorf("unknown file type")
+	}
+	key := FileKey(path)
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	nd, size, err := tx.importDir(path, "", dir, o, progress)
 	if err != nil {
 		return err
 	}
-	tx.entries[key] = e
+	return tx.setEntry(Entry{
+		Key:   key.String(),
+		Value: nd.Cid(),
+		Size:  size,
+	})
+}
 
-	return nil
+// sendProgress reports the progress of PutDir unless the transaction is closed
+func (tx *Tx) sendProgress(progress chan<- PutProgress, p PutProgress) error {
+	select {
+	case progress <- p:
+		return nil
+	case <-tx.ctx.Done():
+		return tx.ctx.Err()
+	}
 }
 
-func (tx *Tx) addDir(key string, dir files.Directory) error {
-	return fmt.Errorf("TODO")
+// importDir recursively imports all the children of a directory and returns the UnixFS directory node
+// and the total size of the files it contains
+func (tx *Tx) importDir(
+	path, rel string,
+	dir files.Directory,
+	o putOptions,
+	progress chan<- PutProgress,
+) (ipldformat.Node, int64, error) {
+	prefix, err := tx.chunkerConfig().builder()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	udir := uio.NewDirectory(tx.store.DAG)
+	udir.SetCidBuilder(prefix)
+
+	var size int64
+	it := dir.Entries()
+	for it.Next() {
+		name := it.Name()
+		crel := filepath.Join(rel, name)
+		cpath := filepath.Join(path, name)
+		if matchAny(o.exclude, crel) {
+			continue
+		}
+
+		var child ipldformat.Node
+		switch f := it.Node().(type) {
+		case files.Directory:
+			var csize int64
+			child, csize, err = tx.importDir(cpath, crel, f, o, progress)
+			if err != nil {
+				return nil, 0, err
+			}
+			size += csize
+		case files.File:
+			if len(o.include) > 0 && !matchAny(o.include, crel) {
+				continue
+			}
+			child, err = tx.importFile(f)
+			if err != nil {
+				return nil, 0, err
+			}
+			fsize, err := f.Size()
+			if err != nil {
+				return nil, 0, err
+			}
+			size += fsize
+			err = tx.sendProgress(progress, PutProgress{
+				Path: cpath,
+				Key:  crel,
+				Cid:  child.Cid(),
+				Size: fsize,
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+		default:
+			continue
+		}
+		if err := udir.AddChild(tx.ctx, name, child); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, err
+	}
+	nd, err := udir.GetNode()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := tx.store.DAG.Add(tx.ctx, nd); err != nil {
+		return nil, 0, err
+	}
+	return nd, size, nil
 }
 
 // Status represents our staged values
@@ -263,7 +735,13 @@ func (tx *Tx) Status() (Status, error) {
 	if tx.Err != nil {
 		return Status{}, tx.Err
 	}
-	return Status(tx.entries), nil
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
+	s := make(Status, len(tx.entries))
+	for k, e := range tx.entries {
+		s[k] = e
+	}
+	return s, nil
 }
 
 // assemble all the entries into a single dag Node
@@ -314,15 +792,11 @@ func (tx *Tx) assembleEntries() (ipld.Node, error) {
 	return nb.Build(), nil
 }
 
-// updateDAG stores the current contents of the index in an array to yield a single root CID
+// updateDAG stores the current contents of the index in an array to yield a single root CID,
+// callers must hold emu
 func (tx *Tx) buildRoot() error {
 	lb := cidlink.LinkBuilder{
-		Prefix: cid.Prefix{
-			Version:  1,
-			Codec:    0x71, // dag-cbor as per multicodec
-			MhType:   DefaultHashFunction,
-			MhLength: -1,
-		},
+		Prefix: rootPrefix(tx.cidBuilder),
 	}
 
 	var size int64
@@ -355,6 +829,7 @@ func (tx *Tx) Ref() *DataRef {
 		PayloadCID:  tx.root,
 		StoreID:     tx.storeID,
 		PayloadSize: tx.size,
+		Labels:      tx.copyLabels(),
 	}
 }
 
@@ -363,23 +838,152 @@ func (tx *Tx) Commit() error {
 	if tx.Err != nil {
 		return tx.Err
 	}
+	if tx.manifest != nil {
+		if tx.manifest.Root != tx.root {
+			return fmt.Errorf("manifest root %s does not match transaction root %s", tx.manifest.Root, tx.root)
+		}
+		// Caches would reject the content anyway
+		if err := tx.manifest.Verify(tx.repl.h.Peerstore()); err != nil {
+			return err
+		}
+	}
+	tx.emu.Lock()
+	keys := make([]string, 0, len(tx.entries))
+	for k := range tx.entries {
+		keys = append(keys, k)
+	}
+	tx.emu.Unlock()
+	sort.Strings(keys)
 	err := tx.index.SetRef(&DataRef{
 		PayloadCID:  tx.root,
 		StoreID:     tx.storeID,
 		PayloadSize: tx.size,
+		Keys:        keys,
+		Labels:      tx.copyLabels(),
 	})
 	if err != nil {
 		return err
 	}
+	if tx.manifest != nil {
+		if err := tx.index.PutManifest(tx.manifest); err != nil {
+			return err
+		}
+	}
+	if tx.ipnsKey != "" {
+		if err := tx.publishIPNS(); err != nil {
+			return err
+		}
+	}
+	if tx.dht != nil && tx.dht.provide {
+		go func() {
+			if err := tx.dht.Provide(tx.ctx, tx.root); err != nil {
+				discoveryLog.Warn().Err(err).Str("root", tx.root.String()).Msg("failed to provide")
+			}
+		}()
+	}
 	opts := DefaultDispatchOptions
-	if tx.cacheRF > 0 {
-		opts.RF = tx.cacheRF
-		tx.dispatching = tx.repl.Dispatch(tx.root, uint64(tx.size), opts)
+	opts.Manifest = tx.manifest
+	opts.Regions = tx.regions
+	opts.Progress = tx.dispatchProgress
+	opts.Bandwidth = tx.uplink
+	if tx.dispatchDeadline > 0 {
+		// Subtrees and shards are dispatched one after the other so they share the same deadline
+		opts.Deadline = time.Now().Add(tx.dispatchDeadline)
 	}
-	return nil
+	opts.Preferred = contractPeers(tx.contracts)
+	var records chan PRecord
+	var rf int
+	if tx.shardN > 0 {
+		records, err = tx.commitShards(opts)
+		if err != nil {
+			return err
+		}
+		rf = tx.shardN
+	} else if tx.subtrees {
+		// Every subtree is dispatched even if we don't require confirmations so the placement is recorded
+		srf := tx.cacheRF
+		if srf < 1 {
+			srf = 1
+		}
+		records, err = tx.commitSubtrees(opts, srf)
+		if err != nil {
+			return err
+		}
+		rf = srf * len(tx.placement.Subtrees)
+	} else {
+		// We need to dispatch to at least as many peers as we require confirmations from
+		rf = tx.cacheRF
+		if rf < tx.minConfirmations {
+			rf = tx.minConfirmations
+		}
+		if rf == 0 {
+			return nil
+		}
+		opts.RF = rf
+		records = tx.repl.Dispatch(tx.root, uint64(tx.size), opts)
+	}
+	tx.dispatching = make(chan PRecord, rf)
+	// confirmed is closed once we have received enough confirmations
+	confirmed := make(chan struct{})
+	// over is closed once the dispatch is over
+	over := make(chan struct{})
+	go func() {
+		defer close(over)
+		defer close(tx.dispatching)
+		for rec := range records {
+			tx.cmu.Lock()
+			tx.confirmed = append(tx.confirmed, rec.Provider)
+			n := len(tx.confirmed)
+			tx.cmu.Unlock()
+			if n == tx.minConfirmations {
+				close(confirmed)
+			}
+			tx.dispatching <- rec
+		}
+	}()
+	if tx.minConfirmations <= 0 {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if tx.confirmTimeout > 0 {
+		timer := time.NewTimer(tx.confirmTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-confirmed:
+		return nil
+	case <-over:
+	case <-timeout:
+	case <-tx.ctx.Done():
+		return tx.ctx.Err()
+	}
+	// The last confirmation may have come in at the same time
+	select {
+	case <-confirmed:
+		return nil
+	default:
+	}
+	return fmt.Errorf("%w: %d of %d providers", ErrNotEnoughConfirmations, len(tx.Confirmed()), tx.minConfirmations)
+}
+
+// Confirmed returns the providers which confirmed storing the content dispatched when committing
+func (tx *Tx) Confirmed() []peer.ID {
+	tx.cmu.Lock()
+	defer tx.cmu.Unlock()
+	confirmed := make([]peer.ID, len(tx.confirmed))
+	copy(confirmed, tx.confirmed)
+	return confirmed
 }
 
 func (tx *Tx) getUnixDAG(k cid.Cid, DAG ipldformat.DAGService) (files.Node, error) {
+	if tx.prefetch > 0 {
+		var poll time.Duration
+		if tx.worker != nil {
+			poll = 50 * time.Millisecond
+		}
+		DAG = NewPrefetcher(tx.ctx, DAG, tx.prefetch, poll)
+	}
 	dn, err := DAG.Get(tx.ctx, k)
 	if err != nil {
 		return nil, err
@@ -390,14 +994,20 @@ func (tx *Tx) getUnixDAG(k cid.Cid, DAG ipldformat.DAGService) (files.Node, erro
 
 // GetFile retrieves a file associated with the given key from the cache
 func (tx *Tx) GetFile(k string) (files.Node, error) {
+	if err := Key(k).Validate(); err != nil {
+		return nil, err
+	}
 	// If the key is in our cached entries we can use the current DAG
-	if e, ok := tx.entries[k]; ok {
+	tx.emu.Lock()
+	e, ok := tx.entries[k]
+	tx.emu.Unlock()
+	if ok {
 		return tx.getUnixDAG(e.Value, tx.store.DAG)
 	}
 	// Check the index if we may already have it from a different transaction
 	if ref, err := tx.index.GetRef(tx.root); err == nil {
 		// In this case we need to access a different store
-		store, err := tx.ms.Get(ref.StoreID)
+		store, err := tx.index.OpenStore(ref.StoreID)
 		if err != nil {
 			return nil, err
 		}
@@ -441,11 +1051,15 @@ func (tx *Tx) WatchDispatch(fn func(r PRecord)) {
 
 // Root returns the current root CID of the transaction
 func (tx *Tx) Root() cid.Cid {
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
 	return tx.root
 }
 
 // Size returns the current size of content cached by the transaction
 func (tx *Tx) Size() int64 {
+	tx.emu.Lock()
+	defer tx.emu.Unlock()
 	return tx.size
 }
 
@@ -481,25 +1095,154 @@ func (ds DealSelection) Decline() {
 	ds.confirm <- false
 }
 
-// Query the discovery service for offers
+// Query the discovery service for offers. Providers price the offers based on the size of the DAG
+// reached by the selector. If the selector is nil we use the one set when creating the transaction.
 func (tx *Tx) Query(sel ipld.Node) error {
-	tx.sel = sel
+	if sel != nil {
+		tx.sel = sel
+	}
+	if m := tx.shardManifest(); m != nil {
+		// Completed deals for the shards must not be reported as the transaction result
+		tx.done = make(chan TxResult, 1)
+		go tx.reconstruct(m)
+		return nil
+	}
+	if m := tx.placementManifest(); m != nil {
+		// Completed deals for the subtrees must not be reported as the transaction result
+		tx.done = make(chan TxResult, 1)
+		go tx.assemble(m, tx.pathKeys)
+		return nil
+	}
 	if tx.worker != nil {
-		return tx.rou.Query(tx.ctx, tx.root, sel)
+		if tx.bitswap != nil {
+			go tx.fallbackBitswap()
+		}
+		if tx.filFallback {
+			go tx.fallbackFilecoin()
+		}
+		if tx.dht != nil {
+			go tx.fallbackDHT()
+		}
+		if len(tx.contracts) > 0 {
+			go tx.retrieveContracted()
+			return nil
+		}
+		return tx.rou.Query(tx.ctx, tx.root, tx.sel)
 	}
 	return ErrNoStrategy
 }
 
+// shardManifest returns the manifest to reconstruct the content from if we don't store the root
+// and it was committed with erasure coding
+func (tx *Tx) shardManifest() *ShardManifest {
+	if tx.shards != nil {
+		return tx.shards
+	}
+	if _, err := tx.index.PeekRef(tx.root); err == nil {
+		return nil
+	}
+	m, err := tx.index.GetShards(tx.root)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// QueryPath queries offers for the content at a given path formatted as /<root>/<key>/...
+// so only the blocks of a single entry or of a file or directory inside it are retrieved. Paths starting with /ipns/<name> or a domain
+// with a DNSLink record are resolved to the root the name points to first.
+func (tx *Tx) QueryPath(p string) error {
+	if tx.names != nil {
+		var err error
+		p, err = resolveNamedPath(tx.ctx, tx.names, p)
+		if err != nil {
+			return err
+		}
+		p, err = resolveDomainPath(tx.ctx, tx.names, p)
+		if err != nil {
+			return err
+		}
+	}
+	pth, err := ParsePath(p)
+	if err != nil {
+		return err
+	}
+	if tx.root != cid.Undef && tx.root != pth.Root {
+		return fmt.Errorf("path root %s does not match transaction root %s", pth.Root, tx.root)
+	}
+	sel, err := tx.pathSelector(pth)
+	if err != nil {
+		return err
+	}
+	tx.root = pth.Root
+	if len(pth.Segments) > 0 {
+		tx.pathKeys = []string{pth.Key().String()}
+	}
+	return tx.Query(sel)
+}
+
+// pathSelector returns the selector of a queried path. The names of the entries inside the root entry
+// are resolved from the DAG if we have its directories so only the blocks on the path are retrieved,
+// the whole root entry is retrieved otherwise.
+func (tx *Tx) pathSelector(p Path) (ipld.Node, error) {
+	sel, err := p.Selector()
+	if !errors.Is(err, ErrNestedPath) {
+		return sel, err
+	}
+	for _, store := range []*multistore.Store{tx.store, tx.refStore(p.Root)} {
+		if store == nil {
+			continue
+		}
+		sel, err := p.ResolveSelector(tx.ctx, store.Loader)
+		if err == nil || errors.Is(err, ErrInvalidPath) {
+			return sel, err
+		}
+	}
+	return selectors.Key(p.Key().String()), nil
+}
+
+// refStore returns the store of a root in the index or nil if we don't have it
+func (tx *Tx) refStore(root cid.Cid) *multistore.Store {
+	ref, err := tx.index.PeekRef(root)
+	if err != nil {
+		return nil
+	}
+	store, err := tx.index.OpenStore(ref.StoreID)
+	if err != nil {
+		return nil
+	}
+	return store
+}
+
 // QueryFrom allows querying directly from a given peer
 func (tx *Tx) QueryFrom(info peer.AddrInfo, key string) error {
 	if tx.worker != nil {
-		return tx.rou.QueryPeer(info, tx.root, tx.worker.ReceiveResponse)
+		return tx.rou.QueryPeer(info, tx.root, tx.receiveOffer)
 	}
 	return ErrNoStrategy
 }
 
+// EstimateCost returns the total cost we should expect to pay if we accept the given offer
+func (tx *Tx) EstimateCost(of deal.Offer) (deal.CostEstimate, error) {
+	return tx.retriever.EstimateCost(tx.ctx, tx.clientAddr, of.Response)
+}
+
 // Execute starts a retrieval operation for a given offer and returns the deal ID for that operation
 func (tx *Tx) Execute(of deal.Offer) error {
+	err := tx.execute(of)
+	if err != nil {
+		tx.omu.Lock()
+		tx.execErr = err
+		tx.omu.Unlock()
+	}
+	return err
+}
+
+func (tx *Tx) execute(of deal.Offer) error {
+	tx.omu.Lock()
+	tx.selected = &of
+	tx.omu.Unlock()
+	tx.progress.setSize(of.Response.Size)
 	// Make sure our provider is in our peerstore
 	tx.rou.AddAddrs(of.Provider.ID, of.Provider.Addrs)
 	params, err := deal.NewParams(
@@ -527,18 +1270,92 @@ func (tx *Tx) Execute(of deal.Offer) error {
 	if err != nil {
 		return err
 	}
+	tx.deals.start(id)
+	txLog.Debug().
+		Str("root", tx.root.String()).
+		Str("provider", of.Provider.ID.String()).
+		Str("deal", id.String()).
+		Msg("started retrieval")
 	tx.ongoing <- DealRef{
 		ID:    id,
 		Offer: of,
 	}
-	select {
-	case status := <-tx.errs:
-		// For now we just return the error and assume the transfer is failed
-		// we do have access to the status in order to try and restart the deal or something else
-		return errors.New(deal.Statuses[status])
-	case <-tx.ctx.Done():
-		return tx.ctx.Err()
+	tx.failures.reset()
+	// A zero stall timeout disables the watchdog
+	var tick <-chan time.Time
+	if tx.stallTimeout > 0 {
+		ticker := time.NewTicker(tx.stallTimeout / 4)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	watch := &stallWatch{timeout: tx.stallTimeout, restarts: tx.stallRestarts}
+	for {
+		select {
+		case err := <-tx.errs:
+			// For now we just return the error and assume the transfer is failed
+			// we do have access to the cause in order to try and restart the deal or something else
+			return err
+		case <-tick:
+			// The strategy moves on to the next offer once the deal can't be restarted
+			if err := tx.watchStall(watch, id, of); err != nil {
+				return err
+			}
+		case <-tx.ctx.Done():
+			return tx.ctx.Err()
+		}
+	}
+}
+
+// receiveOffer counts the offers received before passing them to the worker
+func (tx *Tx) receiveOffer(p peer.AddrInfo, res deal.QueryResponse) {
+	atomic.AddInt32(&tx.offers, 1)
+	txLog.Debug().
+		Str("root", tx.root.String()).
+		Str("provider", p.ID.String()).
+		Uint64("size", res.Size).
+		Str("region", res.Region).
+		Msg("received offer")
+	tx.rec.recordOffer(p, res, tx.root.String())
+	tx.worker.ReceiveResponse(p, res)
+}
+
+// declineOffer records an offer the worker ignored for its price
+func (tx *Tx) declineOffer(of deal.Offer) {
+	atomic.AddInt32(&tx.expensive, 1)
+}
+
+// noOfferErr explains why no offer was executed before the context ended
+func (tx *Tx) noOfferErr(err error) error {
+	tx.omu.Lock()
+	execErr := tx.execErr
+	tx.omu.Unlock()
+	if execErr != nil {
+		return execErr
 	}
+	if n := atomic.LoadInt32(&tx.expensive); n > 0 {
+		return fmt.Errorf("%w: %d offers above the price ceiling", ErrOfferTooExpensive, n)
+	}
+	if atomic.LoadInt32(&tx.offers) == 0 {
+		if err == nil {
+			return ErrNoOffers
+		}
+		return fmt.Errorf("%w: %v", ErrNoOffers, err)
+	}
+	return err
+}
+
+// RetrievalErr returns why the transaction couldn't retrieve the content once its context is done.
+// It wraps ErrNoOffers, ErrOfferTooExpensive or the error of the last offer executed if any.
+func (tx *Tx) RetrievalErr() error {
+	return tx.noOfferErr(tx.ctx.Err())
+}
+
+// SelectedOffer returns the last offer selected for execution by the strategy or nil if none
+// was selected yet. It can be used to audit which provider and price the transaction used.
+func (tx *Tx) SelectedOffer() *deal.Offer {
+	tx.omu.Lock()
+	defer tx.omu.Unlock()
+	return tx.selected
 }
 
 // Confirm takes an offer and blocks to wait for user confirmation before returning true or false
@@ -565,7 +1382,7 @@ func (tx *Tx) Triage() (DealSelection, error) {
 	case dc := <-tx.triage:
 		return dc, nil
 	case <-tx.ctx.Done():
-		return DealSelection{}, tx.ctx.Err()
+		return DealSelection{}, tx.noOfferErr(tx.ctx.Err())
 	}
 }
 
@@ -579,10 +1396,17 @@ func (tx *Tx) Ongoing() <-chan DealRef {
 	return tx.ongoing
 }
 
-// Close removes any listeners and stream handlers related to a session
+// Close removes any listeners and stream handlers related to a session and cancels the deals
+// still running so they don't keep transferring and paying in the background
 func (tx *Tx) Close() {
+	for _, id := range tx.deals.running() {
+		if err := tx.retriever.CancelDeal(id); err != nil {
+			txLog.Warn().Err(err).Str("deal", id.String()).Msg("failed to cancel deal")
+		}
+	}
 	tx.unsub()
 	tx.cancelCtx()
+	tx.index.release(tx.storeID)
 }
 
 // SetAddress to use for funding the retriebal
@@ -600,6 +1424,11 @@ type OfferWorker interface {
 	Close() []deal.Offer
 }
 
+// offerDecliner is implemented by executors which want to know about the offers a worker ignored for their price
+type offerDecliner interface {
+	declineOffer(deal.Offer)
+}
+
 // OfferExecutor exposes the methods required to execute offers
 type OfferExecutor interface {
 	Execute(deal.Offer) error
@@ -641,6 +1470,23 @@ func SelectCheapest(after int, t time.Duration) func(OfferExecutor) OfferWorker
 	}
 }
 
+// SelectCheapestWithin collects offers during the given window then executes the one with the lowest total
+// price (price per byte * size + unseal price). Offers with the same price are ranked by how fast the provider
+// responded. If the transfer fails it will select the next cheapest given the buffered offers
+func SelectCheapestWithin(wait time.Duration) func(OfferExecutor) OfferWorker {
+	return func(oe OfferExecutor) OfferWorker {
+		return sessionWorker{
+			executor:      oe,
+			offersIn:      make(chan deal.Offer),
+			closing:       make(chan chan []deal.Offer, 1),
+			numThreshold:  -1,
+			timeThreshold: wait,
+			priceCeiling:  abi.NewTokenAmount(-1),
+			rank:          sortByTotalPrice,
+		}
+	}
+}
+
 // SelectFirstLowerThan returns the first offer which price is lower than given amount
 // it keeps collecting offers below price threshold to fallback on before completing execution
 func SelectFirstLowerThan(amount abi.TokenAmount) func(oe OfferExecutor) OfferWorker {
@@ -668,6 +1514,8 @@ type sessionWorker struct {
 	timeThreshold time.Duration
 	// priceCeiling is the price over which we are ignoring an offer for this session
 	priceCeiling abi.TokenAmount
+	// rank orders the queued offers before selecting the next one, sortOffers if nil
+	rank func([]deal.Offer)
 }
 
 func (s sessionWorker) exec(offer deal.Offer, result chan error) {
@@ -691,11 +1539,19 @@ func (s sessionWorker) Start() {
 	}
 	// Use the price ceiling if the value is not -1
 	useCeiling := !s.priceCeiling.Equals(abi.NewTokenAmount(-1))
+	// Strategies waiting for a threshold always pick the best ranked offer in the queue
+	cheapest := s.numThreshold >= 0 || s.timeThreshold >= 0
+	rank := s.rank
+	if rank == nil {
+		rank = sortOffers
+	}
 	// Start a routine to collect a set of offers
 	go func() {
 		// Offers are queued in this slice
 		var q []deal.Offer
 		var execDone chan error
+		// expired is true when the delay is over before we received any offer
+		expired := false
 		for {
 			var updates chan error
 			if len(q) > 0 {
@@ -709,9 +1565,12 @@ func (s sessionWorker) Start() {
 				return
 			case of := <-s.offersIn:
 				if useCeiling && of.Response.MinPricePerByte.LessThan(s.priceCeiling) {
+					if d, ok := s.executor.(offerDecliner); ok {
+						d.declineOffer(of)
+					}
 					continue
 				}
-				if s.numThreshold < 0 && s.timeThreshold < 0 && execDone == nil {
+				if (!cheapest || expired) && execDone == nil {
 					execDone = make(chan error, 1)
 					go s.exec(of, execDone)
 					continue
@@ -725,7 +1584,7 @@ func (s sessionWorker) Start() {
 				// If after this one we've reached the threshold let's execute the cheapest offer
 				if len(q) == s.numThreshold {
 					execDone = make(chan error, 1)
-					sortOffers(q)
+					rank(q)
 					go s.exec(q[0], execDone)
 					q = q[1:]
 				}
@@ -734,14 +1593,22 @@ func (s sessionWorker) Start() {
 				if execDone != nil {
 					continue
 				}
+				// Execute the first offer we get if we haven't received any yet
+				if len(q) == 0 {
+					expired = true
+					continue
+				}
 				execDone = make(chan error, 1)
-				sortOffers(q)
+				rank(q)
 				go s.exec(q[0], execDone)
 				q = q[1:]
 			case err := <-updates:
 				// If the execution returns an error we assume it is not fixable
 				// and automatically try the next offer
 				if err != nil && len(q) > 0 {
+					if cheapest {
+						rank(q)
+					}
 					execDone = make(chan error, 1)
 					go s.exec(q[0], execDone)
 					q = q[1:]
@@ -767,14 +1634,54 @@ func (s sessionWorker) ReceiveResponse(p peer.AddrInfo, res deal.QueryResponse)
 	}
 }
 
+// preferRegions ranks the offers from providers in the transaction regions first while keeping
+// the order of the given ranking within each group
+func (tx *Tx) preferRegions(rank func([]deal.Offer)) func([]deal.Offer) {
+	if rank == nil {
+		rank = sortOffers
+	}
+	return func(offers []deal.Offer) {
+		rank(offers)
+		if len(tx.regions) == 0 {
+			return
+		}
+		preferred := func(of deal.Offer) bool {
+			for _, r := range tx.regions {
+				if r.Name == of.Response.Region {
+					return true
+				}
+			}
+			return false
+		}
+		sort.SliceStable(offers, func(i, j int) bool {
+			return preferred(offers[i]) && !preferred(offers[j])
+		})
+	}
+}
+
 func sortOffers(offers []deal.Offer) {
 	sort.Slice(offers, func(i, j int) bool {
 		return offers[i].Response.MinPricePerByte.LessThan(offers[j].Response.MinPricePerByte)
 	})
 }
 
-// KeyFromPath returns a key name from a file path
-func KeyFromPath(p string) string {
-	_, name := filepath.Split(p)
-	return name
+// sortByTotalPrice ranks offers by total price. The sort is stable so offers with the same price
+// keep the order in which they were received i.e. providers with lower latency first.
+func sortByTotalPrice(offers []deal.Offer) {
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offerPrice(offers[i].Response).LessThan(offerPrice(offers[j].Response))
+	})
+}
+
+// offerPrice returns the total price of an offer treating missing prices as zero
+func offerPrice(res deal.QueryResponse) abi.TokenAmount {
+	ppb := res.MinPricePerByte
+	if ppb.Nil() {
+		ppb = big.Zero()
+	}
+	unseal := res.UnsealPrice
+	if unseal.Nil() {
+		unseal = big.Zero()
+	}
+	return big.Add(big.Mul(ppb, abi.NewTokenAmount(int64(res.Size))), unseal)
 }
@@ -1,10 +1,13 @@
 package exchange
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
@@ -58,7 +61,7 @@ func TestTx(t *testing.T) {
 			tx := providers[0].Tx(ctx)
 			require.NoError(t, tx.PutFile(fname))
 
-			file, err := tx.GetFile(KeyFromPath(fname))
+			file, err := tx.GetFile(FileKey(fname).String())
 			require.NoError(t, err)
 			size, err := file.Size()
 			require.NoError(t, err)
@@ -82,13 +85,13 @@ func TestTx(t *testing.T) {
 			require.NoError(t, mn.ConnectAllButSelf())
 
 			tx = client.Tx(ctx, WithRoot(root), WithStrategy(SelectFirst))
-			require.NoError(t, tx.Query(sel.Key(KeyFromPath(fname))))
+			require.NoError(t, tx.Query(sel.Key(FileKey(fname).String())))
 			select {
 			case <-ctx.Done():
 				t.Fatal("tx timeout")
 			case <-tx.Done():
 			}
-			file, err = tx.GetFile(KeyFromPath(fname))
+			file, err = tx.GetFile(FileKey(fname).String())
 			require.NoError(t, err)
 			size, err = file.Size()
 			require.NoError(t, err)
@@ -97,6 +100,48 @@ func TestTx(t *testing.T) {
 	}
 
 }
+
+func TestTxDispatchPolicy(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(bgCtx)
+
+	var providers []*Exchange
+	var pnodes []*testutil.TestNode
+	for i := 0; i < 4; i++ {
+		n := testutil.NewTestNode(mn, t)
+		exch, err := New(ctx, n.Host, n.Ds, Options{
+			RepoPath: n.DTTmpDir,
+			Keystore: keystore.NewMemKeystore(),
+		})
+		require.NoError(t, err)
+		providers = append(providers, exch)
+		pnodes = append(pnodes, n)
+	}
+	require.NoError(t, mn.LinkAll())
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	fname := pnodes[0].CreateRandomFile(t, 56000)
+
+	// Commit blocks until enough providers received the content
+	tx := providers[0].Tx(ctx, WithDispatchPolicy(2, 5*time.Second))
+	require.NoError(t, tx.PutFile(fname))
+	require.NoError(t, tx.Commit())
+	require.GreaterOrEqual(t, len(tx.Confirmed()), 2)
+	tx.Close()
+
+	// Only 3 providers can receive the content
+	fname = pnodes[1].CreateRandomFile(t, 56000)
+	tx = providers[1].Tx(ctx, WithDispatchPolicy(4, time.Second))
+	require.NoError(t, tx.PutFile(fname))
+	err := tx.Commit()
+	require.True(t, errors.Is(err, ErrNotEnoughConfirmations))
+	require.LessOrEqual(t, len(tx.Confirmed()), 3)
+	tx.Close()
+}
 func genTestFiles(t *testing.T) (map[string]string, []string) {
 	dir := t.TempDir()
 
@@ -181,6 +226,122 @@ func TestTxPutGet(t *testing.T) {
 	require.Equal(t, segs, []string{"line1.txt"})
 }
 
+func TestTxStageUnstage(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	opts := Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	}
+	exch, err := New(ctx, n.Host, n.Ds, opts)
+	require.NoError(t, err)
+
+	filevals, filepaths := genTestFiles(t)
+
+	tx := exch.Tx(ctx)
+	for _, p := range filepaths {
+		require.NoError(t, tx.PutFile(p))
+	}
+	full := tx.Root()
+
+	// Move all the entries but one to another transaction
+	kept := FileKey(filepaths[0]).String()
+	rest := exch.Tx(ctx)
+	for _, p := range filepaths[1:] {
+		k := FileKey(p).String()
+		require.NoError(t, rest.Stage(tx, k))
+		require.NoError(t, tx.Unstage(k))
+	}
+	require.True(t, errors.Is(tx.Unstage(FileKey(filepaths[1]).String()), ErrEntryNotStaged))
+	require.True(t, errors.Is(rest.Stage(tx, "missing"), ErrEntryNotStaged))
+
+	status, err := tx.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(status))
+	require.NotEqual(t, full, tx.Root())
+	require.NoError(t, tx.Commit())
+	tx.Close()
+
+	// The moved entries can be read from the new store once the first transaction is closed
+	status, err = rest.Status()
+	require.NoError(t, err)
+	require.Equal(t, len(filepaths)-1, len(status))
+	for k, v := range filevals {
+		if k == kept {
+			continue
+		}
+		nd, err := rest.GetFile(k)
+		require.NoError(t, err)
+		bytes, err := io.ReadAll(nd.(files.File))
+		require.NoError(t, err)
+		require.Equal(t, []byte(v), bytes)
+	}
+
+}
+
+func TestTxPutDir(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	opts := Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	}
+	exch, err := New(ctx, n.Host, n.Ds, opts)
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "poem")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "stanza"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "title.txt"), []byte("The Road Not Taken\n"), 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stanza", "line1.txt"), []byte("Two roads diverged in a yellow wood,\n"), 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stanza", "draft.log"), []byte("scratch"), 0666))
+
+	tx := exch.Tx(ctx)
+	var keys []string
+	for p := range tx.PutDir(dir, WithExclude("*.log")) {
+		require.NoError(t, p.Err)
+		keys = append(keys, p.Key)
+	}
+	require.ElementsMatch(t, []string{"title.txt", filepath.Join("stanza", "line1.txt")}, keys)
+
+	status, err := tx.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(status))
+	require.Equal(t, int64(56), status["poem"].Size)
+
+	nd, err := tx.GetFile("poem")
+	require.NoError(t, err)
+	d, ok := nd.(files.Directory)
+	require.True(t, ok)
+
+	var names []string
+	it := d.Entries()
+	for it.Next() {
+		names = append(names, it.Name())
+	}
+	require.NoError(t, it.Err())
+	require.ElementsMatch(t, []string{"stanza", "title.txt"}, names)
+
+	// Closing the transaction stops the import even if nobody reads the progress
+	big := filepath.Join(t.TempDir(), "big")
+	require.NoError(t, os.MkdirAll(big, 0755))
+	for i := 0; i < 40; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(big, fmt.Sprintf("%d.txt", i)), []byte("data"), 0666))
+	}
+	progress := tx.PutDir(big)
+	tx.Close()
+	// Give the import time to fill the progress buffer and give up
+	time.Sleep(100 * time.Millisecond)
+	var received int
+	for range progress {
+		received++
+	}
+	require.Less(t, received, 40)
+}
+
 func BenchmarkAdd(b *testing.B) {
 
 	ctx := context.Background()
@@ -277,9 +438,8 @@ func TestMapFieldSelector(t *testing.T) {
 	require.Equal(t, 2, stat.NumBlocks)
 	require.Equal(t, 627, stat.Size)
 
-	gtx := cn.Tx(ctx, WithRoot(tx.Root()), WithStrategy(SelectFirst))
-	key := KeyFromPath(filepaths[0])
-	gtx.sel = sel.Key(key)
+	key := FileKey(filepaths[0]).String()
+	gtx := cn.Tx(ctx, WithRoot(tx.Root()), WithStrategy(SelectFirst), WithSelector(sel.Key(key)))
 
 	// We skip discovery and send an offer directly
 	qs, err := pn.rou.NewQueryStream(n2.Host.ID())
@@ -308,10 +468,104 @@ func TestMapFieldSelector(t *testing.T) {
 	require.Equal(t, bytes, []byte(filevals[key]))
 
 	// Getting any other key should fail
-	_, err = gtx.GetFile(KeyFromPath(filepaths[1]))
+	_, err = gtx.GetFile(FileKey(filepaths[1]).String())
 	require.Error(t, err)
 }
 
+func TestTxQueryPath(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	_, filepaths := genTestFiles(t)
+
+	tx := exch.Tx(ctx)
+	for _, p := range filepaths {
+		require.NoError(t, tx.PutFile(p))
+	}
+	root := tx.Root()
+
+	// Selecting a single entry only reaches a fraction of the DAG
+	all, err := Stat(ctx, tx.Store(), root, sel.All())
+	require.NoError(t, err)
+	key, err := Stat(ctx, tx.Store(), root, sel.Key(FileKey(filepaths[0]).String()))
+	require.NoError(t, err)
+	require.Less(t, key.Size, all.Size)
+
+	qtx := exch.Tx(ctx, WithStrategy(SelectFirst))
+	defer qtx.Close()
+	require.NoError(t, qtx.QueryPath(fmt.Sprintf("/%s/%s", root, FileKey(filepaths[0]).String())))
+	require.Equal(t, root, qtx.Root())
+
+	// Paths must match the transaction root
+	require.Error(t, qtx.QueryPath(fmt.Sprintf("/%s", blockGen.Next().Cid())))
+}
+
+func TestTxQueryNestedPath(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	n := testutil.NewTestNode(mn, t)
+	exch, err := New(ctx, n.Host, n.Ds, Options{
+		RepoPath: n.DTTmpDir,
+		Keystore: keystore.NewMemKeystore(),
+	})
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "dir")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file.bin"), bytes.Repeat([]byte("a"), 1024), 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.bin"), bytes.Repeat([]byte("b"), 4096), 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "deep.txt"), []byte("deep"), 0666))
+
+	tx := exch.Tx(ctx)
+	for p := range tx.PutDir(dir) {
+		require.NoError(t, p.Err)
+	}
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+	store, err := exch.Index().GetStore(root)
+	require.NoError(t, err)
+
+	// A path inside the entry only reaches the blocks on the way to the file
+	sl, err := NewPath(root, "dir", "file.bin").ResolveSelector(ctx, store.Loader)
+	require.NoError(t, err)
+	file, err := Stat(ctx, store, root, sl)
+	require.NoError(t, err)
+	entry, err := Stat(ctx, store, root, sel.Key("dir"))
+	require.NoError(t, err)
+	require.Less(t, file.Size, entry.Size)
+	// The root, the directory and the file
+	require.Equal(t, 3, file.NumBlocks)
+
+	sl, err = NewPath(root, "dir", "sub", "deep.txt").ResolveSelector(ctx, store.Loader)
+	require.NoError(t, err)
+	deep, err := Stat(ctx, store, root, sl)
+	require.NoError(t, err)
+	require.Equal(t, 4, deep.NumBlocks)
+
+	_, err = NewPath(root, "dir", "missing.bin").ResolveSelector(ctx, store.Loader)
+	require.True(t, errors.Is(err, ErrInvalidPath))
+
+	// Transactions resolve the path from the DAG we have
+	qtx := exch.Tx(ctx, WithStrategy(SelectFirst))
+	defer qtx.Close()
+	require.NoError(t, qtx.QueryPath(fmt.Sprintf("/%s/dir/file.bin", root)))
+	require.Equal(t, root, qtx.Root())
+	qs, err := Stat(ctx, store, root, qtx.sel)
+	require.NoError(t, err)
+	require.Equal(t, file, qs)
+	require.Error(t, qtx.QueryPath(fmt.Sprintf("/%s/dir/missing.bin", root)))
+}
+
 func TestMultiTx(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
@@ -353,7 +607,7 @@ func TestMultiTx(t *testing.T) {
 	require.NoError(t, pn.Index().SetRef(tx.Ref()))
 
 	gtx1 := cn1.Tx(ctx, WithRoot(tx.Root()), WithStrategy(SelectFirst))
-	key1 := KeyFromPath(filepaths[0])
+	key1 := FileKey(filepaths[0]).String()
 	require.NoError(t, gtx1.Query(sel.Key(key1)))
 
 	select {
@@ -368,7 +622,7 @@ func TestMultiTx(t *testing.T) {
 	require.NoError(t, err)
 
 	gtx2 := cn1.Tx(ctx, WithRoot(tx.Root()), WithStrategy(SelectFirst))
-	key2 := KeyFromPath(filepaths[1])
+	key2 := FileKey(filepaths[1]).String()
 	require.NoError(t, gtx2.Query(sel.Key(key2)))
 
 	select {
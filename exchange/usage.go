@@ -0,0 +1,98 @@
+package exchange
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-multistore"
+)
+
+// WithDiskAccounting measures the size of content from the blocks in its store instead of trusting the
+// payload size of refs which doesn't account for duplicate blocks. Sizes are reconciled when the index
+// is loaded so the bounds reflect the actual disk usage.
+func WithDiskAccounting() IndexOption {
+	return func(idx *Index) {
+		idx.diskAccounting = true
+	}
+}
+
+// DiskUsage returns the number of bytes used by the blocks of every store in the multistore
+func (idx *Index) DiskUsage() (uint64, error) {
+	var total uint64
+	for _, id := range idx.ms.List() {
+		size, err := idx.storeUsage(id)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// storeUsage adds up the size of all the blocks in a store
+func (idx *Index) storeUsage(id multistore.StoreID) (uint64, error) {
+	found := false
+	// Getting a store which isn't listed would create it
+	for _, sid := range idx.ms.List() {
+		if sid == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+	store, err := idx.ms.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	keys, err := store.Bstore.AllKeysChan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for k := range keys {
+		size, err := store.Bstore.GetSize(k)
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(size)
+	}
+	return total, nil
+}
+
+// measure records the disk usage of the store holding a ref if the accounting is enabled
+func (idx *Index) measure(ref *DataRef) {
+	if !idx.diskAccounting {
+		return
+	}
+	size, err := idx.storeUsage(ref.StoreID)
+	if err != nil {
+		return
+	}
+	ref.diskSize = size
+}
+
+// refSize returns the size a ref counts for in the index, the payload size is used until the
+// content is measured
+func (idx *Index) refSize(ref *DataRef) uint64 {
+	if idx.diskAccounting && ref.diskSize > 0 {
+		return ref.diskSize
+	}
+	return uint64(ref.PayloadSize)
+}
+
+// reconcile measures the content of every ref and corrects the size of the index, callers must hold the lock
+func (idx *Index) reconcile() error {
+	idx.size = 0
+	for _, ref := range idx.Refs {
+		idx.measure(ref)
+		idx.size += idx.refSize(ref)
+	}
+	if idx.ub > 0 && idx.lb > 0 && idx.size > idx.ub {
+		idx.evict(idx.size - idx.lb)
+		return idx.Flush()
+	}
+	return nil
+}
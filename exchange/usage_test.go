@@ -0,0 +1,72 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexDiskUsage(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	// Add some blocks to a store and return their total size
+	fill := func(store *multistore.Store, n int) uint64 {
+		var size uint64
+		for i := 0; i < n; i++ {
+			blk := blockGen.Next()
+			require.NoError(t, store.Bstore.Put(blk))
+			size += uint64(len(blk.RawData()))
+		}
+		return size
+	}
+
+	storeID := ms.Next()
+	store, err := ms.Get(storeID)
+	require.NoError(t, err)
+	stored := fill(store, 3)
+
+	// Content which isn't indexed still uses disk space
+	orphan, err := ms.Get(ms.Next())
+	require.NoError(t, err)
+	orphaned := fill(orphan, 2)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+	// The payload size doesn't match what is in the store
+	ref := &DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+		StoreID:     storeID,
+	}
+	require.NoError(t, idx.SetRef(ref))
+	require.Equal(t, uint64(1000), idx.size)
+
+	usage, err := idx.DiskUsage()
+	require.NoError(t, err)
+	require.Equal(t, stored+orphaned, usage)
+
+	// The index is reconciled with the stores when loaded
+	idx, err = NewIndex(ds, ms, WithDiskAccounting())
+	require.NoError(t, err)
+	require.Equal(t, stored, idx.size)
+
+	// New refs are measured when added
+	storeID = ms.Next()
+	store, err = ms.Get(storeID)
+	require.NoError(t, err)
+	added := fill(store, 1)
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blockGen.Next().Cid(),
+		PayloadSize: 1000,
+		StoreID:     storeID,
+	}))
+	require.Equal(t, stored+added, idx.size)
+
+	require.NoError(t, idx.DropRef(ref.PayloadCID))
+	require.Equal(t, added, idx.size)
+}
@@ -0,0 +1,155 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// KWal is the datastore key prefix of the ref operations not yet persisted in the HAMT root
+const KWal = "wal"
+
+const (
+	walSet byte = iota
+	walDrop
+)
+
+func walKey(k string) datastore.Key {
+	return datastore.NewKey(KWal).ChildString(k)
+}
+
+// logRef records a ref operation before it is applied so it can be replayed if the process stops before
+// the HAMT root is flushed, callers must hold the lock
+func (idx *Index) logRef(op byte, ref *DataRef) error {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(op)
+	if err := ref.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	k := ref.PayloadCID.String()
+	if err := idx.ds.Put(walKey(k), buf.Bytes()); err != nil {
+		return err
+	}
+	idx.pending[k] = struct{}{}
+	return nil
+}
+
+// truncateLog removes the operations persisted by the last flush, callers must hold the lock
+func (idx *Index) truncateLog() error {
+	for k := range idx.pending {
+		if err := idx.ds.Delete(walKey(k)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return err
+		}
+		delete(idx.pending, k)
+	}
+	return nil
+}
+
+// replayLog applies the operations which were not persisted in the HAMT root before the index stopped.
+// Refs are added back if their content is in the stores and dropped refs have their store deleted.
+func (idx *Index) replayLog() error {
+	res, err := idx.ds.Query(dsq.Query{Prefix: "/" + KWal})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		if len(e.Value) == 0 {
+			continue
+		}
+		ref := new(DataRef)
+		if err := ref.UnmarshalCBOR(bytes.NewReader(e.Value[1:])); err != nil {
+			return err
+		}
+		k := ref.PayloadCID.String()
+		switch e.Value[0] {
+		case walSet:
+			if !idx.hasContent(ref) {
+				continue
+			}
+			if err := idx.root.Set(context.TODO(), k, ref); err != nil {
+				return err
+			}
+		case walDrop:
+			if _, err := idx.root.Delete(context.TODO(), k); err != nil {
+				return err
+			}
+			if err := idx.deleteStore(ref.StoreID); err != nil {
+				return err
+			}
+			if err := idx.ds.Delete(manifestKey(ref.PayloadCID)); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+				return err
+			}
+		}
+		idx.pending[k] = struct{}{}
+	}
+	return idx.Flush()
+}
+
+// deleteStore deletes a store if it is in the multistore
+func (idx *Index) deleteStore(id multistore.StoreID) error {
+	for _, sid := range idx.ms.List() {
+		if sid == id {
+			// Blocks deduplicated by other stores must be kept
+			if err := idx.unshare(id); err != nil {
+				return err
+			}
+			return idx.ms.Delete(id)
+		}
+	}
+	return nil
+}
+
+// VerifyReport lists the inconsistencies between the index and the stores repaired by Verify
+type VerifyReport struct {
+	// OrphanedStores are the stores no ref points to
+	OrphanedStores []multistore.StoreID
+	// MissingContent are the refs whose content isn't in their store
+	MissingContent []cid.Cid
+}
+
+// Verify deletes the stores which no ref points to and drops the refs whose content is missing from the
+// stores. Stores being filled by transactions aren't indexed yet so Verify must run before any transaction
+// is started, for example right after creating the index.
+func (idx *Index) Verify() (VerifyReport, error) {
+	var report VerifyReport
+	<-idx.Loaded()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var missing []*DataRef
+	for _, ref := range idx.Refs {
+		if !idx.hasContent(ref) {
+			missing = append(missing, ref)
+		}
+	}
+	for _, ref := range missing {
+		if err := idx.dropRef(ref.PayloadCID); err != nil {
+			return report, fmt.Errorf("failed to drop %s: %w", ref.PayloadCID, err)
+		}
+		report.MissingContent = append(report.MissingContent, ref.PayloadCID)
+	}
+	for _, id := range idx.orphans() {
+		if err := idx.deleteStore(id); err != nil {
+			return report, err
+		}
+		report.OrphanedStores = append(report.OrphanedStores, id)
+	}
+	if len(missing) == 0 {
+		return report, nil
+	}
+	return report, idx.commit()
+}
@@ -0,0 +1,99 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexWAL(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	newRef := func() *DataRef {
+		storeID := ms.Next()
+		store, err := ms.Get(storeID)
+		require.NoError(t, err)
+		blk := blockGen.Next()
+		require.NoError(t, store.Bstore.Put(blk))
+		return &DataRef{
+			PayloadCID:  blk.Cid(),
+			PayloadSize: 100,
+			StoreID:     storeID,
+		}
+	}
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+	dropped := newRef()
+	require.NoError(t, idx.SetRef(dropped))
+
+	// The HAMT root isn't flushed before the process stops
+	idx, err = NewIndex(ds, ms, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+	added := newRef()
+	require.NoError(t, idx.SetRef(added))
+	require.NoError(t, idx.DropRef(dropped.PayloadCID))
+
+	idx, err = NewIndex(ds, ms)
+	require.NoError(t, err)
+	require.Equal(t, 1, idx.Len())
+	_, err = idx.PeekRef(added.PayloadCID)
+	require.NoError(t, err)
+	_, err = idx.PeekRef(dropped.PayloadCID)
+	require.Error(t, err)
+	require.NotContains(t, ms.List(), dropped.StoreID)
+}
+
+func TestIndexVerify(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	ms, err := multistore.NewMultiDstore(ds)
+	require.NoError(t, err)
+
+	idx, err := NewIndex(ds, ms)
+	require.NoError(t, err)
+
+	storeID := ms.Next()
+	store, err := ms.Get(storeID)
+	require.NoError(t, err)
+	blk := blockGen.Next()
+	require.NoError(t, store.Bstore.Put(blk))
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  blk.Cid(),
+		PayloadSize: 100,
+		StoreID:     storeID,
+	}))
+
+	// A store left behind by a ref which was never persisted
+	orphan := ms.Next()
+	store, err = ms.Get(orphan)
+	require.NoError(t, err)
+	require.NoError(t, store.Bstore.Put(blockGen.Next()))
+
+	// A ref whose content was deleted
+	missing := blockGen.Next().Cid()
+	require.NoError(t, idx.SetRef(&DataRef{
+		PayloadCID:  missing,
+		PayloadSize: 100,
+		StoreID:     ms.Next(),
+	}))
+
+	report, err := idx.Verify()
+	require.NoError(t, err)
+	require.Equal(t, []multistore.StoreID{orphan}, report.OrphanedStores)
+	require.Len(t, report.MissingContent, 1)
+	require.Equal(t, missing, report.MissingContent[0])
+	require.Equal(t, 1, idx.Len())
+	require.Equal(t, []multistore.StoreID{storeID}, ms.List())
+
+	// Nothing left to repair
+	report, err = idx.Verify()
+	require.NoError(t, err)
+	require.Len(t, report.OrphanedStores, 0)
+	require.Len(t, report.MissingContent, 0)
+}
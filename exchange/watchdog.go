@@ -0,0 +1,89 @@
+package exchange
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/myelnet/pop/retrieval/deal"
+)
+
+// DefaultStallRestarts is the number of times the data transfer of a stalled retrieval is restarted before
+// the deal is cancelled and the next offer tried
+const DefaultStallRestarts = 2
+
+// Statuses of the progress events reporting what the watchdog did about a stalled retrieval
+const (
+	// StatusStallRestarted is reported when the data transfer of a stalled deal is restarted
+	StatusStallRestarted = "StallRestarted"
+	// StatusStallCancelled is reported when a stalled deal is cancelled to try the next offer
+	StatusStallCancelled = "StallCancelled"
+)
+
+type stallAction int
+
+const (
+	stallNone stallAction = iota
+	stallRestart
+	stallCancel
+)
+
+// stallWatch decides what to do about a deal which stopped making progress. The data transfer is
+// restarted a few times then the deal is given up on.
+type stallWatch struct {
+	timeout  time.Duration
+	restarts int
+	attempts int
+}
+
+// check returns the action to take about a deal we haven't heard from for the given time
+func (w *stallWatch) check(idle time.Duration) stallAction {
+	if w.timeout <= 0 || idle <= w.timeout {
+		return stallNone
+	}
+	if w.attempts < w.restarts {
+		w.attempts++
+		return stallRestart
+	}
+	return stallCancel
+}
+
+// watchStall restarts the data transfer of a stalled deal or cancels the deal once it was restarted too
+// many times. It returns ErrTransferStalled when the strategy should move on to the next offer.
+func (tx *Tx) watchStall(w *stallWatch, id deal.ID, of deal.Offer) error {
+	idle := tx.failures.idle()
+	action := w.check(idle)
+	if action == stallNone {
+		return nil
+	}
+	detail := fmt.Sprintf("no update for %s", idle.Round(time.Second))
+	if action == stallRestart {
+		err := tx.retriever.RestartDeal(tx.ctx, id)
+		if err == nil {
+			tx.failures.reset()
+			tx.reportStall(id, of, StatusStallRestarted, fmt.Sprintf("%s, attempt %d", detail, w.attempts))
+			return nil
+		}
+		// A transfer which can't be restarted is given up on right away
+		detail = fmt.Sprintf("%s, restart failed: %v", detail, err)
+	}
+	// The deal won't fail the next offer when it ends
+	tx.failures.drop(id)
+	if err := tx.retriever.CancelDeal(id); err != nil {
+		txLog.Warn().Err(err).Str("deal", id.String()).Msg("failed to cancel stalled deal")
+	}
+	tx.reportStall(id, of, StatusStallCancelled, detail)
+	return fmt.Errorf("%w: no update from %s for %s", ErrTransferStalled, of.Provider.ID, idle.Round(time.Second))
+}
+
+// reportStall records an action of the watchdog and publishes it with the progress of the deal
+func (tx *Tx) reportStall(id deal.ID, of deal.Offer, status string, detail string) {
+	tx.rec.record(Event{
+		Kind:   EventStall,
+		Peer:   of.Provider.ID.String(),
+		Root:   tx.root.String(),
+		Deal:   id.String(),
+		Name:   status,
+		Detail: detail,
+	})
+	tx.progress.report(id, of.Provider.ID, status)
+}
@@ -0,0 +1,48 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/myelnet/pop/retrieval/client"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStallWatch(t *testing.T) {
+	w := &stallWatch{timeout: time.Minute, restarts: 2}
+	require.Equal(t, stallNone, w.check(30*time.Second))
+	require.Equal(t, stallRestart, w.check(2*time.Minute))
+	require.Equal(t, stallRestart, w.check(2*time.Minute))
+	require.Equal(t, stallCancel, w.check(2*time.Minute))
+
+	// Negative restarts give up on the first stall
+	w = &stallWatch{timeout: time.Minute, restarts: -1}
+	require.Equal(t, stallCancel, w.check(2*time.Minute))
+
+	// No timeout disables the watchdog
+	w = &stallWatch{restarts: 2}
+	require.Equal(t, stallNone, w.check(time.Hour))
+}
+
+func TestStallReport(t *testing.T) {
+	f := newDealFailures()
+	p := newTxProgress()
+	p.setSize(1000)
+
+	state := deal.ClientState{ID: deal.ID(1), TotalReceived: 400}
+	p.observe(client.EventBlocksReceived, state)
+	<-p.ch
+
+	// The watchdog actions carry the latest progress of the deal
+	p.report(deal.ID(1), "", StatusStallRestarted)
+	pe := <-p.ch
+	require.Equal(t, StatusStallRestarted, pe.Status)
+	require.Equal(t, uint64(400), pe.Received)
+	require.Equal(t, 1, pe.Blocks)
+
+	// A cancelled deal doesn't fail the transaction once it ends
+	f.drop(deal.ID(1))
+	state.Status = deal.StatusCancelled
+	require.NoError(t, f.observe(client.EventCancelComplete, state))
+}
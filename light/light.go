@@ -0,0 +1,254 @@
+// Package light is a retrieval only client fetching content from pop gateways. Gateways send the blocks
+// of the requested DAG in a CAR archive and the client verifies every block against its CID and checks
+// the DAG is complete before exposing the content so it doesn't have to trust the gateway. It doesn't
+// depend on libp2p or Filecoin so it can be compiled to WebAssembly for browser apps.
+package light
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-ipld-prime"
+	dagpb "github.com/ipld/go-ipld-prime-proto"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/myelnet/pop/selectors"
+)
+
+// CarContentType is the media type gateways use to send CAR archives. Clients request archives
+// with this type in the Accept header or with the format=car query parameter.
+const CarContentType = "application/vnd.ipld.car"
+
+// ErrInvalidBlock is returned when a block doesn't match its CID
+var ErrInvalidBlock = errors.New("block does not match its cid")
+
+// ErrUnexpectedRoot is returned when the archive isn't rooted at the requested CID
+var ErrUnexpectedRoot = errors.New("archive does not have the requested root")
+
+// ErrIncomplete is returned when blocks of the requested DAG are missing from the archive
+var ErrIncomplete = errors.New("archive is missing blocks")
+
+// Client fetches content from a pop gateway
+type Client struct {
+	gateway string
+	hc      *http.Client
+}
+
+// NewClient creates a client for the gateway at the given url. The default http client is used if nil.
+func NewClient(gateway string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		gateway: strings.TrimSuffix(gateway, "/"),
+		hc:      hc,
+	}
+}
+
+// Fetch retrieves the DAG of a root or of a single entry of the root if the key isn't empty
+// and verifies it
+func (c *Client) Fetch(ctx context.Context, root cid.Cid, key string) (*Content, error) {
+	u := c.gateway + "/ipfs/" + root.String()
+	if key != "" {
+		u += "/" + url.PathEscape(key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+"?format=car", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", CarContentType)
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("gateway returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return Load(ctx, res.Body, root, key)
+}
+
+// Content is a verified DAG received from a gateway
+type Content struct {
+	Root cid.Cid
+	bs   blockstore.Blockstore
+	dag  ipldformat.DAGService
+}
+
+// Load reads a CAR archive and verifies it holds the complete DAG of a root or of a single entry
+// of the root if the key isn't empty
+func Load(ctx context.Context, r io.Reader, root cid.Cid, key string) (*Content, error) {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Header.Roots) != 1 || !cr.Header.Roots[0].Equals(root) {
+		return nil, ErrUnexpectedRoot
+	}
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyBlock(blk); err != nil {
+			return nil, err
+		}
+		if err := bs.Put(blk); err != nil {
+			return nil, err
+		}
+	}
+	sel := selectors.All()
+	if key != "" {
+		sel = selectors.Key(key)
+	}
+	if err := walk(ctx, bs, root, sel); err != nil {
+		return nil, err
+	}
+	return &Content{
+		Root: root,
+		bs:   bs,
+		dag:  merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+	}, nil
+}
+
+// verifyBlock hashes the data of a block with the function of its CID
+func verifyBlock(blk blocks.Block) error {
+	c, err := blk.Cid().Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !c.Equals(blk.Cid()) {
+		return fmt.Errorf("%w: %s", ErrInvalidBlock, blk.Cid())
+	}
+	return nil
+}
+
+// loaderForBlockstore loads the blocks of a verified DAG
+func loaderForBlockstore(bs blockstore.Blockstore) ipld.Loader {
+	return func(lnk ipld.Link, _ ipld.LinkContext) (io.Reader, error) {
+		c, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("incorrect Link Type")
+		}
+		blk, err := bs.Get(c.Cid)
+		if errors.Is(err, blockstore.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrIncomplete, c.Cid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+}
+
+// walk traverses the selected DAG and fails if any block is missing
+func walk(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, sel ipld.Node) error {
+	// The traversal doesn't wrap loader errors so we keep track of missing blocks
+	var missing error
+	load := loaderForBlockstore(bs)
+	loader := func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		r, err := load(lnk, lnkCtx)
+		if errors.Is(err, ErrIncomplete) {
+			missing = err
+		}
+		return r, err
+	}
+	chooser := dagpb.AddDagPBSupportToChooser(func(ipld.Link, ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+	link := cidlink.Link{Cid: root}
+	proto, err := chooser(link, ipld.LinkContext{})
+	if err != nil {
+		return err
+	}
+	nb := proto.NewBuilder()
+	if err := link.Load(ctx, ipld.LinkContext{}, nb, loader); err != nil {
+		return err
+	}
+	s, err := selector.ParseSelector(sel)
+	if err != nil {
+		return err
+	}
+	err = traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                            ctx,
+			LinkLoader:                     loader,
+			LinkTargetNodePrototypeChooser: chooser,
+		},
+	}.WalkAdv(nb.Build(), s, func(traversal.Progress, ipld.Node, traversal.VisitReason) error {
+		return nil
+	})
+	if missing != nil {
+		return missing
+	}
+	return err
+}
+
+// Blocks returns the verified blocks of the DAG
+func (ct *Content) Blocks(ctx context.Context) ([]blocks.Block, error) {
+	keys, err := ct.bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var blks []blocks.Block
+	for k := range keys {
+		blk, err := ct.bs.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		blks = append(blks, blk)
+	}
+	return blks, nil
+}
+
+// File returns the file or directory stored under an entry of the root
+func (ct *Content) File(ctx context.Context, key string) (files.Node, error) {
+	nb := basicnode.Prototype.Map.NewBuilder()
+	lk := cidlink.Link{Cid: ct.Root}
+	if err := lk.Load(ctx, ipld.LinkContext{}, nb, loaderForBlockstore(ct.bs)); err != nil {
+		return nil, err
+	}
+	entry, err := nb.Build().LookupByString(key)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := entry.LookupByString("Value")
+	if err != nil {
+		return nil, err
+	}
+	l, err := ln.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	dn, err := ct.dag.Get(ctx, l.(cidlink.Link).Cid)
+	if err != nil {
+		return nil, err
+	}
+	return unixfile.NewUnixfsFile(ctx, ct.dag, dn)
+}
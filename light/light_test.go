@@ -0,0 +1,70 @@
+package light
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	leaf := merkledag.NodeWithData([]byte("leaf"))
+	root := merkledag.NodeWithData([]byte("root"))
+	require.NoError(t, root.AddNodeLink("leaf", leaf))
+	require.NoError(t, dag.AddMany(ctx, []ipldformat.Node{leaf, root}))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, car.WriteCar(ctx, dag, []cid.Cid{root.Cid()}, buf))
+	archive := buf.Bytes()
+
+	content, err := Load(ctx, bytes.NewReader(archive), root.Cid(), "")
+	require.NoError(t, err)
+	blks, err := content.Blocks(ctx)
+	require.NoError(t, err)
+	require.Len(t, blks, 2)
+
+	// The archive must be rooted at the requested CID
+	_, err = Load(ctx, bytes.NewReader(archive), leaf.Cid(), "")
+	require.True(t, errors.Is(err, ErrUnexpectedRoot))
+
+	writeCar := func(data map[cid.Cid][]byte) []byte {
+		buf := new(bytes.Buffer)
+		require.NoError(t, car.WriteHeader(&car.CarHeader{
+			Roots:   []cid.Cid{root.Cid()},
+			Version: 1,
+		}, buf))
+		for c, d := range data {
+			require.NoError(t, carutil.LdWrite(buf, c.Bytes(), d))
+		}
+		return buf.Bytes()
+	}
+
+	// Blocks must match their CID
+	_, err = Load(ctx, bytes.NewReader(writeCar(map[cid.Cid][]byte{
+		root.Cid(): root.RawData(),
+		leaf.Cid(): []byte("tampered"),
+	})), root.Cid(), "")
+	require.True(t, errors.Is(err, ErrInvalidBlock))
+
+	// The DAG must be complete
+	_, err = Load(ctx, bytes.NewReader(writeCar(map[cid.Cid][]byte{
+		root.Cid(): root.RawData(),
+	})), root.Cid(), "")
+	require.True(t, errors.Is(err, ErrIncomplete))
+}
@@ -0,0 +1,138 @@
+// Package lightclient retrieves content from pop caches for browser and mobile apps. It queries the HTTP
+// gateways of several pops for offers, selects the cheapest and fastest one, pays it with a payment channel
+// voucher if the content isn't free and verifies every block it receives before exposing the content.
+// It doesn't depend on libp2p, the index or the file system so it compiles under js/wasm.
+package lightclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/light"
+)
+
+// ErrNoOffer is returned when no gateway offers the requested content
+var ErrNoOffer = errors.New("no offer")
+
+// ErrNoChannel is returned when an offer isn't free and we have no payment channel to its payment address
+var ErrNoChannel = errors.New("no payment channel")
+
+// Client fetches verified content from the cheapest pop gateway
+type Client struct {
+	gateways []string
+	hc       *http.Client
+	signer   Signer
+
+	mu sync.Mutex
+	// channels are our payment channels by payment address
+	channels map[address.Address]*Channel
+}
+
+// Option configures a light client
+type Option func(*Client)
+
+// WithHTTPClient sets the http client used to reach the gateways instead of the default one
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithPayments lets the client pay for content through the given channels. Without payments only free
+// offers are accepted.
+func WithPayments(s Signer, channels ...*Channel) Option {
+	return func(c *Client) {
+		c.signer = s
+		for _, ch := range channels {
+			c.channels[ch.To] = ch
+		}
+	}
+}
+
+// New creates a light client retrieving from the gateways at the given urls
+func New(gateways []string, opts ...Option) *Client {
+	c := &Client{
+		hc:       http.DefaultClient,
+		channels: make(map[address.Address]*Channel),
+	}
+	for _, gw := range gateways {
+		c.gateways = append(c.gateways, strings.TrimSuffix(gw, "/"))
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// AddChannel adds a payment channel to pay the gateways with the channel payment address
+func (c *Client) AddChannel(ch *Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels[ch.To] = ch
+}
+
+// Fetch retrieves the DAG of a root or of a single entry of the root if the key isn't empty. It tries
+// the offers from the best to the worst until one gateway sends the verified content.
+func (c *Client) Fetch(ctx context.Context, root cid.Cid, key string) (*light.Content, error) {
+	offers, err := c.Query(ctx, root, key)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, offer := range offers {
+		content, err := c.Retrieve(ctx, offer)
+		if err == nil {
+			return content, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", offer.Gateway, err))
+	}
+	return nil, fmt.Errorf("all offers failed: %s", strings.Join(errs, ", "))
+}
+
+// Retrieve executes an offer, paying the gateway first if the offer isn't free
+func (c *Client) Retrieve(ctx context.Context, offer Offer) (*light.Content, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, offer.Gateway+"/ipfs/"+contentPath(offer.Root, offer.Key)+"?format=car", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", light.CarContentType)
+	if !offer.Free() {
+		c.mu.Lock()
+		ch, ok := c.channels[offer.PaymentAddress]
+		c.mu.Unlock()
+		if !ok || c.signer == nil {
+			return nil, fmt.Errorf("%w to %s", ErrNoChannel, offer.PaymentAddress)
+		}
+		// The voucher is spent even if the transfer fails afterwards
+		sv, err := ch.Pay(ctx, c.signer, offer.Price())
+		if err != nil {
+			return nil, err
+		}
+		v, err := EncodeVoucher(sv)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(VoucherHeader, v)
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return nil, fmt.Errorf("gateway returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return light.Load(ctx, res.Body, offer.Root, offer.Key)
+}
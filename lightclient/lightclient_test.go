@@ -0,0 +1,152 @@
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin/paych"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipldformat "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSigner struct{}
+
+func (mockSigner) Sign(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error) {
+	return &crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte("signature")}, nil
+}
+
+// testGateway serves an offer and the CAR of a DAG, recording the vouchers it receives
+type testGateway struct {
+	offer    Offer
+	archive  []byte
+	vouchers []*paych.SignedVoucher
+	fail     bool
+}
+
+func (tg *testGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/offer/") {
+		json.NewEncoder(w).Encode(tg.offer)
+		return
+	}
+	if !tg.offer.Free() {
+		sv, err := DecodeVoucher(r.Header.Get(VoucherHeader))
+		if err != nil {
+			http.Error(w, "payment required", http.StatusPaymentRequired)
+			return
+		}
+		tg.vouchers = append(tg.vouchers, sv)
+	}
+	if tg.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write(tg.archive)
+}
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	leaf := merkledag.NodeWithData([]byte("leaf"))
+	root := merkledag.NodeWithData([]byte("root"))
+	require.NoError(t, root.AddNodeLink("leaf", leaf))
+	require.NoError(t, dag.AddMany(ctx, []ipldformat.Node{leaf, root}))
+	buf := new(bytes.Buffer)
+	require.NoError(t, car.WriteCar(ctx, dag, []cid.Cid{root.Cid()}, buf))
+
+	payAddr, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	paid := &testGateway{
+		offer: Offer{
+			Provider:       "paid",
+			Root:           root.Cid(),
+			Size:           100,
+			PricePerByte:   abi.NewTokenAmount(2),
+			PaymentAddress: payAddr,
+		},
+		archive: buf.Bytes(),
+	}
+	free := &testGateway{
+		offer: Offer{
+			Provider:     "free",
+			Root:         root.Cid(),
+			Size:         100,
+			PricePerByte: big.Zero(),
+		},
+		archive: buf.Bytes(),
+		fail:    true,
+	}
+	paidSrv := httptest.NewServer(paid)
+	defer paidSrv.Close()
+	freeSrv := httptest.NewServer(free)
+	defer freeSrv.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+
+	// Free offers rank first and gateways without the content are skipped
+	c := New([]string{paidSrv.URL, freeSrv.URL, down.URL})
+	offers, err := c.Query(ctx, root.Cid(), "")
+	require.NoError(t, err)
+	require.Len(t, offers, 2)
+	require.Equal(t, "free", offers[0].Provider)
+	require.Equal(t, freeSrv.URL, offers[0].Gateway)
+	require.Equal(t, abi.NewTokenAmount(200), offers[1].Price())
+
+	// Paid offers need a payment channel
+	_, err = c.Fetch(ctx, root.Cid(), "")
+	require.Error(t, err)
+	_, err = c.Retrieve(ctx, offers[1])
+	require.True(t, errors.Is(err, ErrNoChannel))
+
+	// The failing free gateway is skipped and the paid one receives cumulative vouchers
+	chAddr, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+	ch := NewChannel(chAddr, payAddr, payAddr, 0, 0, big.Zero())
+	c = New([]string{paidSrv.URL, freeSrv.URL}, WithPayments(mockSigner{}, ch))
+	for i := 1; i <= 2; i++ {
+		content, err := c.Fetch(ctx, root.Cid(), "")
+		require.NoError(t, err)
+		blks, err := content.Blocks(ctx)
+		require.NoError(t, err)
+		require.Len(t, blks, 2)
+	}
+	require.Len(t, paid.vouchers, 2)
+	require.Equal(t, chAddr, paid.vouchers[1].ChannelAddr)
+	require.Equal(t, uint64(2), paid.vouchers[1].Nonce)
+	require.Equal(t, abi.NewTokenAmount(400), paid.vouchers[1].Amount)
+	require.Equal(t, abi.NewTokenAmount(400), ch.Paid())
+}
+
+func TestSortOffers(t *testing.T) {
+	offers := []Offer{
+		{Provider: "expensive", Size: 10, PricePerByte: abi.NewTokenAmount(3)},
+		{Provider: "slow", Size: 10, PricePerByte: abi.NewTokenAmount(1), Latency: time.Second},
+		{Provider: "fast", Size: 10, PricePerByte: abi.NewTokenAmount(1), Latency: time.Millisecond},
+		{Provider: "free", Size: 10},
+	}
+	SortOffers(offers)
+	var order []string
+	for _, o := range offers {
+		order = append(order, o.Provider)
+	}
+	require.Equal(t, []string{"free", "fast", "slow", "expensive"}, order)
+}
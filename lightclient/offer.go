@@ -0,0 +1,126 @@
+package lightclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/ipfs/go-cid"
+)
+
+// Offer is the price a gateway asks to serve the DAG of a root or of a single entry of the root
+type Offer struct {
+	// Gateway is the url of the gateway which made the offer
+	Gateway string `json:"-"`
+	// Provider is the peer ID of the pop behind the gateway
+	Provider string  `json:"provider"`
+	Root     cid.Cid `json:"root"`
+	Key      string  `json:"key,omitempty"`
+	// Size is the number of bytes of the selected DAG
+	Size         uint64          `json:"size"`
+	PricePerByte abi.TokenAmount `json:"pricePerByte"`
+	// PaymentAddress is the address receiving the payments, the client must have a channel to it
+	// unless the offer is free
+	PaymentAddress address.Address `json:"paymentAddress"`
+	// Latency is the time the gateway took to answer the query
+	Latency time.Duration `json:"-"`
+}
+
+// Price returns the total price of the offer
+func (o Offer) Price() abi.TokenAmount {
+	if o.PricePerByte.Nil() {
+		return big.Zero()
+	}
+	return big.Mul(o.PricePerByte, abi.NewTokenAmount(int64(o.Size)))
+}
+
+// Free returns whether the content is served without payment
+func (o Offer) Free() bool {
+	return o.Price().IsZero()
+}
+
+// SortOffers ranks the cheapest offers first then the fastest gateways among offers of the same price
+func SortOffers(offers []Offer) {
+	sort.SliceStable(offers, func(i, j int) bool {
+		if cmp := big.Cmp(offers[i].Price(), offers[j].Price()); cmp != 0 {
+			return cmp < 0
+		}
+		return offers[i].Latency < offers[j].Latency
+	})
+}
+
+// Query asks every gateway for an offer in parallel and returns the offers ranked with SortOffers. Gateways
+// which don't have the content or fail to answer are skipped.
+func (c *Client) Query(ctx context.Context, root cid.Cid, key string) ([]Offer, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var offers []Offer
+	var errs []string
+	for _, gw := range c.gateways {
+		wg.Add(1)
+		go func(gw string) {
+			defer wg.Done()
+			offer, err := c.query(ctx, gw, root, key)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", gw, err))
+				return
+			}
+			offers = append(offers, offer)
+		}(gw)
+	}
+	wg.Wait()
+	if len(offers) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoOffer, strings.Join(errs, ", "))
+	}
+	SortOffers(offers)
+	return offers, nil
+}
+
+// query asks a single gateway for an offer
+func (c *Client) query(ctx context.Context, gw string, root cid.Cid, key string) (Offer, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gw+"/offer/"+contentPath(root, key), nil)
+	if err != nil {
+		return Offer{}, err
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return Offer{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return Offer{}, fmt.Errorf("gateway returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	var offer Offer
+	if err := json.NewDecoder(res.Body).Decode(&offer); err != nil {
+		return Offer{}, err
+	}
+	if !offer.Root.Equals(root) {
+		return Offer{}, fmt.Errorf("offer for %s instead of %s", offer.Root, root)
+	}
+	offer.Gateway = gw
+	offer.Latency = time.Since(start)
+	return offer, nil
+}
+
+// contentPath returns the path of a root or of an entry of the root
+func contentPath(root cid.Cid, key string) string {
+	if key == "" {
+		return root.String()
+	}
+	return root.String() + "/" + url.PathEscape(key)
+}
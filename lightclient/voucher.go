@@ -0,0 +1,108 @@
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin/paych"
+)
+
+// VoucherHeader is the HTTP header carrying the payment channel voucher paying for a retrieval
+const VoucherHeader = "X-Pop-Voucher"
+
+// Signer signs vouchers with the key controlling a payment channel. Browser apps may implement it with
+// their own wallet so the key never enters the light client.
+type Signer interface {
+	Sign(ctx context.Context, addr address.Address, msg []byte) (*crypto.Signature, error)
+}
+
+// Channel is a payment channel created on chain by the client to a gateway's payment address. The light
+// client doesn't talk to the chain, it only issues vouchers of increasing amounts on one lane of the channel.
+type Channel struct {
+	Addr address.Address
+	// Control is the address signing the vouchers
+	Control address.Address
+	// To is the payment address of the gateway
+	To   address.Address
+	Lane uint64
+
+	mu sync.Mutex
+	// nonce is the nonce of the last voucher issued on the lane
+	nonce uint64
+	// paid is the amount of the last voucher issued on the lane, vouchers are cumulative
+	paid abi.TokenAmount
+}
+
+// NewChannel tracks a payment channel starting from the last voucher issued on the lane if any
+func NewChannel(addr, control, to address.Address, lane uint64, nonce uint64, paid abi.TokenAmount) *Channel {
+	if paid.Nil() {
+		paid = big.Zero()
+	}
+	return &Channel{
+		Addr:    addr,
+		Control: control,
+		To:      to,
+		Lane:    lane,
+		nonce:   nonce,
+		paid:    paid,
+	}
+}
+
+// Paid returns the total amount paid through the channel
+func (ch *Channel) Paid() abi.TokenAmount {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.paid
+}
+
+// Pay issues a voucher adding the given amount to what the channel already paid
+func (ch *Channel) Pay(ctx context.Context, s Signer, amt abi.TokenAmount) (*paych.SignedVoucher, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	sv := &paych.SignedVoucher{
+		ChannelAddr: ch.Addr,
+		Lane:        ch.Lane,
+		Nonce:       ch.nonce + 1,
+		Amount:      big.Add(ch.paid, amt),
+	}
+	vb, err := sv.SigningBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get voucher signing bytes: %w", err)
+	}
+	sv.Signature, err = s.Sign(ctx, ch.Control, vb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign voucher: %w", err)
+	}
+	ch.nonce = sv.Nonce
+	ch.paid = sv.Amount
+	return sv, nil
+}
+
+// EncodeVoucher encodes a voucher for the VoucherHeader
+func EncodeVoucher(sv *paych.SignedVoucher) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := sv.MarshalCBOR(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeVoucher decodes a voucher from the VoucherHeader
+func DecodeVoucher(s string) (*paych.SignedVoucher, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	sv := new(paych.SignedVoucher)
+	if err := sv.UnmarshalCBOR(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return sv, nil
+}
@@ -0,0 +1,206 @@
+// Package logging provides the loggers of the pop subsystems. The level of each subsystem can change
+// while pop is running so operators can debug a live node without restarting it.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Names of the subsystems logging separately
+const (
+	// Index logs the content index and its evictions
+	Index = "index"
+	// Tx logs the transactions dispatching and retrieving content
+	Tx = "tx"
+	// Retrieval logs the data transfers and retrieval deals
+	Retrieval = "retrieval"
+	// Payments logs the payment channels and vouchers
+	Payments = "payments"
+	// Discovery logs the peer exchange, the announcements and the DHT
+	Discovery = "discovery"
+)
+
+// All selects every subsystem when setting a level
+const All = "all"
+
+// DefaultLevel is the level of the subsystems until it is set
+const DefaultLevel = zerolog.InfoLevel
+
+var (
+	mu sync.RWMutex
+	// base is the logger every subsystem writes to
+	base    = zerolog.New(os.Stderr).With().Timestamp().Logger()
+	levels  = make(map[string]zerolog.Level)
+	loggers = make(map[string]zerolog.Logger)
+)
+
+func init() {
+	for _, name := range []string{Index, Tx, Retrieval, Payments, Discovery} {
+		register(name)
+	}
+}
+
+// register adds a subsystem at the default level if it doesn't exist yet, callers must hold the lock
+func register(name string) {
+	if _, ok := levels[name]; ok {
+		return
+	}
+	levels[name] = DefaultLevel
+	rebuild(name)
+}
+
+// rebuild updates the logger of a subsystem after its level or the output changed, callers must hold
+// the lock
+func rebuild(name string) {
+	loggers[name] = base.With().Str("module", name).Logger().Level(levels[name])
+}
+
+// Logger logs the messages of a subsystem at the level currently set for it
+type Logger struct {
+	name string
+}
+
+// Subsystem returns the logger of the named subsystem, the subsystem is created if it doesn't exist
+func Subsystem(name string) *Logger {
+	mu.Lock()
+	register(name)
+	mu.Unlock()
+	return &Logger{name: name}
+}
+
+func (l *Logger) logger() *zerolog.Logger {
+	mu.RLock()
+	zl := loggers[l.name]
+	mu.RUnlock()
+	return &zl
+}
+
+// Debug starts a message only useful when debugging the subsystem
+func (l *Logger) Debug() *zerolog.Event {
+	return l.logger().Debug()
+}
+
+// Info starts a message about the normal operation of the subsystem
+func (l *Logger) Info() *zerolog.Event {
+	return l.logger().Info()
+}
+
+// Warn starts a message about a failure the subsystem recovers from
+func (l *Logger) Warn() *zerolog.Event {
+	return l.logger().Warn()
+}
+
+// Error starts a message about a failure of the subsystem
+func (l *Logger) Error() *zerolog.Event {
+	return l.logger().Error()
+}
+
+// SetOutput sets the writer of the logs. They are written as JSON lines if json is true else formatted
+// for a console. The global zerolog logger used by the node writes to the same output.
+func SetOutput(w io.Writer, json bool) {
+	if !json {
+		w = zerolog.ConsoleWriter{Out: w}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	base = zerolog.New(w).With().Timestamp().Logger()
+	log.Logger = base
+	for name := range levels {
+		rebuild(name)
+	}
+}
+
+// ParseLevel parses a level name, off disables the logs
+func ParseLevel(s string) (zerolog.Level, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "off":
+		return zerolog.Disabled, nil
+	case "trace", "debug", "info", "warn", "error":
+		return zerolog.ParseLevel(s)
+	}
+	return zerolog.NoLevel, fmt.Errorf("unknown log level %q, expected trace, debug, info, warn, error or off", s)
+}
+
+// SetLevel sets the level of a subsystem or of every subsystem if the name is all
+func SetLevel(name, level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if name == All {
+		for n := range levels {
+			levels[n] = lvl
+			rebuild(n)
+		}
+		return nil
+	}
+	if _, ok := levels[name]; !ok {
+		return fmt.Errorf("unknown log subsystem %q, expected %s or %s", name, strings.Join(subsystems(), ", "), All)
+	}
+	levels[name] = lvl
+	rebuild(name)
+	return nil
+}
+
+// SetLevels sets the levels formatted as a level applied to every subsystem and levels of single
+// subsystems separated by commas e.g. info,tx=debug,discovery=warn
+func SetLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, level := All, part
+		if i := strings.Index(part, "="); i >= 0 {
+			name, level = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+		if err := SetLevel(name, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels returns the level of every subsystem
+func Levels() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+	lvls := make(map[string]string, len(levels))
+	for name, lvl := range levels {
+		lvls[name] = levelName(lvl)
+	}
+	return lvls
+}
+
+// Subsystems returns the sorted names of the subsystems
+func Subsystems() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return subsystems()
+}
+
+func subsystems() []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func levelName(lvl zerolog.Level) string {
+	if lvl == zerolog.Disabled {
+		return "off"
+	}
+	return lvl.String()
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLevels(t *testing.T) {
+	defer SetLevel(All, "info")
+
+	require.NoError(t, SetLevels("warn, tx=debug,discovery=off"))
+	lvls := Levels()
+	require.Equal(t, "debug", lvls[Tx])
+	require.Equal(t, "off", lvls[Discovery])
+	require.Equal(t, "warn", lvls[Index])
+	require.Equal(t, "warn", lvls[Payments])
+
+	require.Error(t, SetLevels("tx=verbose"))
+	require.Error(t, SetLevel("nope", "debug"))
+	require.NoError(t, SetLevel(Retrieval, "ERROR"))
+	require.Equal(t, "error", Levels()[Retrieval])
+
+	// New subsystems start at the default level
+	Subsystem("test")
+	require.Equal(t, "info", Levels()["test"])
+	require.Contains(t, Subsystems(), "test")
+}
+
+func TestSubsystemOutput(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf, true)
+	defer SetOutput(os.Stderr, false)
+	defer SetLevel(All, "info")
+
+	l := Subsystem(Index)
+	l.Debug().Msg("hidden")
+	l.Info().Msg("shown")
+	require.NoError(t, SetLevel(Index, "debug"))
+	// The level applies to the loggers created before it changed
+	l.Debug().Msg("debugging")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &msg))
+	require.Equal(t, "debugging", msg["message"])
+	require.Equal(t, Index, msg["module"])
+	require.Equal(t, "debug", msg["level"])
+}
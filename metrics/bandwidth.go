@@ -0,0 +1,355 @@
+package metrics
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// BandwidthSent counts the bytes of the blocks sent to peers
+	BandwidthSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bandwidth",
+		Name:      "sent_bytes_total",
+		Help:      "Bytes of the blocks sent to peers",
+	})
+	// BandwidthReceived counts the bytes of the blocks received from peers
+	BandwidthReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bandwidth",
+		Name:      "received_bytes_total",
+		Help:      "Bytes of the blocks received from peers",
+	})
+	// BandwidthThrottled counts the time transfers were delayed by the bandwidth limits
+	BandwidthThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bandwidth",
+		Name:      "throttled_seconds_total",
+		Help:      "Time transfers were delayed to stay within the bandwidth limits",
+	}, []string{"direction"})
+)
+
+func init() {
+	Registry.MustRegister(
+		BandwidthSent,
+		BandwidthReceived,
+		BandwidthThrottled,
+	)
+}
+
+// MaxBandwidthPeers and MaxBandwidthRefs are the number of peers and refs whose bytes are accounted
+// separately, the ones which transferred the least are forgotten first
+var (
+	MaxBandwidthPeers = 1000
+	MaxBandwidthRefs  = 10000
+)
+
+// BandwidthLimits are the maximum number of bytes per second sent and received. Zero values are unlimited.
+type BandwidthLimits struct {
+	// Upload and Download are shared by all the peers
+	Upload   uint64
+	Download uint64
+	// PeerUpload and PeerDownload apply to each peer
+	PeerUpload   uint64
+	PeerDownload uint64
+}
+
+// BandwidthTotals are the bytes sent and received
+type BandwidthTotals struct {
+	Sent     uint64
+	Received uint64
+}
+
+func (t BandwidthTotals) total() uint64 {
+	return t.Sent + t.Received
+}
+
+// BandwidthStat are the bytes sent and received with a peer or for a ref
+type BandwidthStat struct {
+	ID string
+	BandwidthTotals
+}
+
+// BandwidthStats are the bytes transferred since the node started
+type BandwidthStats struct {
+	BandwidthTotals
+	Limits BandwidthLimits
+	// Peers and Refs are sorted by the number of bytes transferred, most first
+	Peers []BandwidthStat
+	Refs  []BandwidthStat
+}
+
+// rateLimit spreads the bytes transferred over time so they stay under a rate
+type rateLimit struct {
+	// next is the earliest time the rate allows transferring more bytes
+	next time.Time
+}
+
+// reserve schedules n bytes at the given bytes per second and returns how long to wait before
+// transferring them
+func (rl *rateLimit) reserve(now time.Time, n int, rate uint64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	at := rl.next
+	rl.next = rl.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	return at.Sub(now)
+}
+
+// bandwidthEntry is the bytes transferred with a peer or for a ref
+type bandwidthEntry struct {
+	BandwidthTotals
+	// key is the peer ID or the ref CID
+	key interface{}
+	// index is the position of the entry in its heap
+	index int
+}
+
+// bandwidthHeap orders the entries by the number of bytes transferred so the least active one is forgotten
+// without scanning them all
+type bandwidthHeap []*bandwidthEntry
+
+func (h bandwidthHeap) Len() int { return len(h) }
+
+func (h bandwidthHeap) Less(i, j int) bool { return h[i].total() < h[j].total() }
+
+func (h bandwidthHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *bandwidthHeap) Push(x interface{}) {
+	e := x.(*bandwidthEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *bandwidthHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type peerBandwidth struct {
+	bandwidthEntry
+	up   rateLimit
+	down rateLimit
+}
+
+// Bandwidth meters the bytes sent and received per peer and per ref and delays the transfers exceeding
+// the limits so operators on metered links can bound their traffic
+type Bandwidth struct {
+	mu       sync.Mutex
+	limits   BandwidthLimits
+	totals   BandwidthTotals
+	up       rateLimit
+	down     rateLimit
+	peers    map[peer.ID]*peerBandwidth
+	refs     map[cid.Cid]*bandwidthEntry
+	peerHeap bandwidthHeap
+	refHeap  bandwidthHeap
+}
+
+// NewBandwidth creates a new Bandwidth meter with the given limits
+func NewBandwidth(l BandwidthLimits) *Bandwidth {
+	return &Bandwidth{
+		limits: l,
+		peers:  make(map[peer.ID]*peerBandwidth),
+		refs:   make(map[cid.Cid]*bandwidthEntry),
+	}
+}
+
+// SetLimits replaces the limits, they apply to the next bytes transferred
+func (b *Bandwidth) SetLimits(l BandwidthLimits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = l
+}
+
+// Limits returns the current limits
+func (b *Bandwidth) Limits() BandwidthLimits {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limits
+}
+
+// Send accounts n bytes sent to a peer for a ref and blocks until the upload limits allow them or the
+// context is done. A nil Bandwidth neither accounts nor limits anything.
+func (b *Bandwidth) Send(ctx context.Context, p peer.ID, root cid.Cid, n int) error {
+	return b.transfer(ctx, p, root, n, true)
+}
+
+// Receive accounts n bytes received from a peer for a ref and blocks until the download limits allow them
+// or the context is done
+func (b *Bandwidth) Receive(ctx context.Context, p peer.ID, root cid.Cid, n int) error {
+	return b.transfer(ctx, p, root, n, false)
+}
+
+func (b *Bandwidth) transfer(ctx context.Context, p peer.ID, root cid.Cid, n int, upload bool) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	pb := b.peer(p)
+	rb := b.ref(root)
+	var wait time.Duration
+	if upload {
+		b.totals.Sent += uint64(n)
+		pb.Sent += uint64(n)
+		rb.Sent += uint64(n)
+		wait = maxDuration(b.up.reserve(now, n, b.limits.Upload), pb.up.reserve(now, n, b.limits.PeerUpload))
+	} else {
+		b.totals.Received += uint64(n)
+		pb.Received += uint64(n)
+		rb.Received += uint64(n)
+		wait = maxDuration(b.down.reserve(now, n, b.limits.Download), pb.down.reserve(now, n, b.limits.PeerDownload))
+	}
+	heap.Fix(&b.peerHeap, pb.index)
+	heap.Fix(&b.refHeap, rb.index)
+	b.mu.Unlock()
+
+	direction := "download"
+	if upload {
+		direction = "upload"
+		BandwidthSent.Add(float64(n))
+	} else {
+		BandwidthReceived.Add(float64(n))
+	}
+	if wait <= 0 {
+		return nil
+	}
+	BandwidthThrottled.WithLabelValues(direction).Add(wait.Seconds())
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// peer returns the bandwidth of a peer, callers must hold the lock
+func (b *Bandwidth) peer(p peer.ID) *peerBandwidth {
+	pb, ok := b.peers[p]
+	if ok {
+		return pb
+	}
+	if len(b.peers) >= MaxBandwidthPeers {
+		least := heap.Pop(&b.peerHeap).(*bandwidthEntry)
+		delete(b.peers, least.key.(peer.ID))
+	}
+	pb = &peerBandwidth{bandwidthEntry: bandwidthEntry{key: p}}
+	b.peers[p] = pb
+	heap.Push(&b.peerHeap, &pb.bandwidthEntry)
+	return pb
+}
+
+// ref returns the bandwidth of a ref, callers must hold the lock
+func (b *Bandwidth) ref(root cid.Cid) *bandwidthEntry {
+	rb, ok := b.refs[root]
+	if ok {
+		return rb
+	}
+	if len(b.refs) >= MaxBandwidthRefs {
+		least := heap.Pop(&b.refHeap).(*bandwidthEntry)
+		delete(b.refs, least.key.(cid.Cid))
+	}
+	rb = &bandwidthEntry{key: root}
+	b.refs[root] = rb
+	heap.Push(&b.refHeap, rb)
+	return rb
+}
+
+// Stats returns the bytes transferred with the top peers and for the top refs, all of them if top is 0
+func (b *Bandwidth) Stats(top int) BandwidthStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BandwidthStats{
+		BandwidthTotals: b.totals,
+		Limits:          b.limits,
+	}
+	for p, pb := range b.peers {
+		stats.Peers = append(stats.Peers, BandwidthStat{ID: p.String(), BandwidthTotals: pb.BandwidthTotals})
+	}
+	for k, rb := range b.refs {
+		stats.Refs = append(stats.Refs, BandwidthStat{ID: k.String(), BandwidthTotals: rb.BandwidthTotals})
+	}
+	stats.Peers = topBandwidth(stats.Peers, top)
+	stats.Refs = topBandwidth(stats.Refs, top)
+	return stats
+}
+
+func topBandwidth(stats []BandwidthStat, top int) []BandwidthStat {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].total() == stats[j].total() {
+			return stats[i].ID < stats[j].ID
+		}
+		return stats[i].total() > stats[j].total()
+	})
+	if top > 0 && len(stats) > top {
+		stats = stats[:top]
+	}
+	return stats
+}
+
+// Reader accounts the bytes read from r as sent to a peer for a ref. Reads waiting for the upload limits
+// fail with the context error once it is done.
+func (b *Bandwidth) Reader(ctx context.Context, p peer.ID, root cid.Cid, r io.Reader) io.Reader {
+	return &meteredReader{r: r, fn: func(n int) error { return b.Send(ctx, p, root, n) }}
+}
+
+// Writer accounts the bytes written to w as received from a peer for a ref. Writes waiting for the
+// download limits fail with the context error once it is done.
+func (b *Bandwidth) Writer(ctx context.Context, p peer.ID, root cid.Cid, w io.Writer) io.Writer {
+	return &meteredWriter{w: w, fn: func(n int) error { return b.Receive(ctx, p, root, n) }}
+}
+
+type meteredReader struct {
+	r  io.Reader
+	fn func(int) error
+}
+
+func (mr *meteredReader) Read(buf []byte) (int, error) {
+	n, err := mr.r.Read(buf)
+	if merr := mr.fn(n); merr != nil && err == nil {
+		err = merr
+	}
+	return n, err
+}
+
+type meteredWriter struct {
+	w  io.Writer
+	fn func(int) error
+}
+
+func (mw *meteredWriter) Write(buf []byte) (int, error) {
+	n, err := mw.w.Write(buf)
+	if merr := mw.fn(n); merr != nil && err == nil {
+		err = merr
+	}
+	return n, err
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
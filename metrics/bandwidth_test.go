@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthAccounting(t *testing.T) {
+	ctx := context.Background()
+	bw := NewBandwidth(BandwidthLimits{})
+	p1, p2 := peer.ID("peer1"), peer.ID("peer2")
+	r1, r2 := blocks.NewBlock([]byte("ref1")).Cid(), blocks.NewBlock([]byte("ref2")).Cid()
+
+	bw.Send(ctx, p1, r1, 100)
+	bw.Send(ctx, p2, r1, 50)
+	bw.Receive(ctx, p2, r2, 300)
+
+	data, err := ioutil.ReadAll(bw.Reader(ctx, p1, r2, bytes.NewReader(make([]byte, 1000))))
+	require.NoError(t, err)
+	require.Len(t, data, 1000)
+	var buf bytes.Buffer
+	_, err = bw.Writer(ctx, p1, r2, &buf).Write(make([]byte, 10))
+	require.NoError(t, err)
+
+	stats := bw.Stats(0)
+	require.Equal(t, uint64(1150), stats.Sent)
+	require.Equal(t, uint64(310), stats.Received)
+	require.Len(t, stats.Peers, 2)
+	require.Equal(t, p1.String(), stats.Peers[0].ID)
+	require.Equal(t, BandwidthTotals{Sent: 1100, Received: 10}, stats.Peers[0].BandwidthTotals)
+	require.Equal(t, r2.String(), stats.Refs[0].ID)
+	require.Equal(t, BandwidthTotals{Sent: 150}, stats.Refs[1].BandwidthTotals)
+
+	require.Len(t, bw.Stats(1).Refs, 1)
+
+	// A nil meter doesn't account anything
+	var nbw *Bandwidth
+	require.NoError(t, nbw.Send(ctx, p1, r1, 100))
+}
+
+func TestBandwidthForgetsLeastActive(t *testing.T) {
+	ctx := context.Background()
+	maxRefs := MaxBandwidthRefs
+	MaxBandwidthRefs = 2
+	defer func() { MaxBandwidthRefs = maxRefs }()
+
+	bw := NewBandwidth(BandwidthLimits{})
+	p := peer.ID("peer")
+	r1, r2, r3 := blocks.NewBlock([]byte("ref1")).Cid(), blocks.NewBlock([]byte("ref2")).Cid(), blocks.NewBlock([]byte("ref3")).Cid()
+	bw.Send(ctx, p, r1, 100)
+	bw.Send(ctx, p, r2, 10)
+	bw.Send(ctx, p, r3, 50)
+
+	stats := bw.Stats(0)
+	require.Len(t, stats.Refs, 2)
+	require.Equal(t, r1.String(), stats.Refs[0].ID)
+	require.Equal(t, r3.String(), stats.Refs[1].ID)
+	// The totals still count the forgotten refs
+	require.Equal(t, uint64(160), stats.Sent)
+}
+
+func TestBandwidthLimits(t *testing.T) {
+	ctx := context.Background()
+	bw := NewBandwidth(BandwidthLimits{PeerUpload: 10000})
+	p1, p2 := peer.ID("peer1"), peer.ID("peer2")
+	r := blocks.NewBlock([]byte("ref")).Cid()
+
+	// The first bytes go right away and the next ones wait for the rate to allow them
+	start := time.Now()
+	bw.Send(ctx, p1, r, 1000)
+	bw.Send(ctx, p1, r, 1000)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(90*time.Millisecond))
+
+	// Each peer has its own budget
+	start = time.Now()
+	bw.Send(ctx, p2, r, 1000)
+	require.Less(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	// The global limit is shared by all the peers
+	bw.SetLimits(BandwidthLimits{Upload: 10000})
+	require.Equal(t, uint64(10000), bw.Limits().Upload)
+	start = time.Now()
+	bw.Send(ctx, p1, r, 1000)
+	bw.Send(ctx, p2, r, 1000)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(90*time.Millisecond))
+
+	// Downloads aren't limited by the upload limits
+	start = time.Now()
+	bw.Receive(ctx, p1, r, 1000)
+	bw.Receive(ctx, p1, r, 1000)
+	require.Less(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	// Throttled transfers give up once their context is done
+	bw.SetLimits(BandwidthLimits{Upload: 1000})
+	bw.Send(ctx, p1, r, 1000)
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err := bw.Send(cctx, p1, r, 1000)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	_, err = bw.Reader(cctx, p1, r, bytes.NewReader(make([]byte, 10))).Read(make([]byte, 10))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrOverBudget is returned when the daemon uses more resources than its budget allows
+var ErrOverBudget = errors.New("resource budget exceeded")
+
+var (
+	// RSS is the resident memory used by the daemon
+	RSS = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "rss_bytes",
+		Help:      "Resident memory used by the daemon",
+	})
+	// Goroutines is the number of goroutines currently running
+	Goroutines = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "goroutines",
+		Help:      "Number of goroutines currently running",
+	})
+	// OverBudget is set to 1 while the daemon exceeds its budget and sheds load
+	OverBudget = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "over_budget",
+		Help:      "Whether the daemon exceeds its resource budget and rejects new transfers",
+	})
+	// Shed counts the transfers rejected because we were over budget
+	Shed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "guard",
+		Name:      "shed_total",
+		Help:      "Number of transfers rejected because the daemon was over budget",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		RSS,
+		Goroutines,
+		OverBudget,
+		Shed,
+	)
+}
+
+// Budget is the maximum amount of resources the daemon should use. Zero values are unlimited.
+type Budget struct {
+	// MaxRSS is the maximum resident memory in bytes
+	MaxRSS uint64
+	// MaxGoroutines is the maximum number of running goroutines
+	MaxGoroutines int
+}
+
+// Usage is a sample of the resources used by the daemon
+type Usage struct {
+	RSS        uint64 `json:"rss"`
+	Goroutines int    `json:"goroutines"`
+}
+
+// Guard regularly samples the resources used by the daemon and tells services to reject
+// new transfers when they exceed the budget, preventing OOM kills on small devices
+type Guard struct {
+	budget Budget
+	sample func() Usage
+
+	mu    sync.Mutex
+	usage Usage
+	err   error
+}
+
+// NewGuard creates a new Guard for the given budget
+func NewGuard(b Budget) *Guard {
+	return &Guard{
+		budget: b,
+		sample: sampleUsage,
+	}
+}
+
+// Run samples resource usage at the given interval until the context is cancelled
+func (g *Guard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	g.Update()
+	for {
+		select {
+		case <-ticker.C:
+			g.Update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Update samples the current resource usage and checks it against the budget
+func (g *Guard) Update() {
+	u := g.sample()
+	RSS.Set(float64(u.RSS))
+	Goroutines.Set(float64(u.Goroutines))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var err error
+	switch {
+	case g.budget.MaxRSS > 0 && u.RSS > g.budget.MaxRSS:
+		err = fmt.Errorf("%w: rss %d > %d", ErrOverBudget, u.RSS, g.budget.MaxRSS)
+	case g.budget.MaxGoroutines > 0 && u.Goroutines > g.budget.MaxGoroutines:
+		err = fmt.Errorf("%w: goroutines %d > %d", ErrOverBudget, u.Goroutines, g.budget.MaxGoroutines)
+	}
+
+	// Only report when the condition changes so we don't flood the logs
+	if err != nil && g.err == nil {
+		log.Warn().Err(err).Msg("shedding load")
+		OverBudget.Set(1)
+	}
+	if err == nil && g.err != nil {
+		log.Info().Msg("back within resource budget")
+		OverBudget.Set(0)
+	}
+	g.usage = u
+	g.err = err
+}
+
+// SetBudget replaces the budget, it is checked against the next sample
+func (g *Guard) SetBudget(b Budget) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.budget = b
+}
+
+// Check returns an error if we should reject new transfers. A nil guard never sheds load.
+func (g *Guard) Check() error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		Shed.Inc()
+	}
+	return g.err
+}
+
+// Usage returns the last resource usage sample
+func (g *Guard) Usage() Usage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.usage
+}
+
+// HealthHandler reports the guard status as JSON and responds with 503 when over budget
+func HealthHandler(g *Guard) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			OK     bool   `json:"ok"`
+			Reason string `json:"reason,omitempty"`
+			Usage  *Usage `json:"usage,omitempty"`
+		}{OK: true}
+		if g != nil {
+			u := g.Usage()
+			status.Usage = &u
+			g.mu.Lock()
+			if g.err != nil {
+				status.OK = false
+				status.Reason = g.err.Error()
+			}
+			g.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !status.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+func sampleUsage() Usage {
+	return Usage{
+		RSS:        readRSS(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+}
+
+// readRSS reads the resident set size from procfs and falls back to the memory
+// obtained from the OS by the Go runtime on other platforms
+func readRSS() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) > 1 {
+			pages, err := strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.Sys
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard(t *testing.T) {
+	usage := Usage{RSS: 100, Goroutines: 10}
+	g := NewGuard(Budget{MaxRSS: 200, MaxGoroutines: 20})
+	g.sample = func() Usage {
+		return usage
+	}
+
+	g.Update()
+	require.NoError(t, g.Check())
+
+	usage.RSS = 300
+	g.Update()
+	require.True(t, errors.Is(g.Check(), ErrOverBudget))
+
+	rec := httptest.NewRecorder()
+	HealthHandler(g).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Contains(t, rec.Body.String(), "rss 300 > 200")
+
+	usage.RSS = 100
+	usage.Goroutines = 30
+	g.Update()
+	require.True(t, errors.Is(g.Check(), ErrOverBudget))
+
+	usage.Goroutines = 10
+	g.Update()
+	require.NoError(t, g.Check())
+
+	rec = httptest.NewRecorder()
+	HealthHandler(g).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// A nil guard never sheds load
+	var ng *Guard
+	require.NoError(t, ng.Check())
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "pop"
+
+var (
+	// IndexSize is the total size of the content committed to the index
+	IndexSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "size_bytes",
+		Help:      "Total size of the content stored in the index",
+	})
+	// IndexRefs is the number of refs stored in the index
+	IndexRefs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "refs",
+		Help:      "Number of refs stored in the index",
+	})
+	// IndexBuckets is the length of the LFU bucket list of the index
+	IndexBuckets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "buckets",
+		Help:      "Number of LFU frequency buckets in the index",
+	})
+	// IndexEvictions counts the refs evicted from the index to make room for new content
+	IndexEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "evictions_total",
+		Help:      "Number of refs evicted from the index",
+	})
+	// IndexHits counts reads for content we have in the index
+	IndexHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "hits_total",
+		Help:      "Number of index reads for content we have",
+	})
+	// IndexMisses counts reads for content we don't have in the index
+	IndexMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "misses_total",
+		Help:      "Number of index reads for content we don't have",
+	})
+	// InterestRefs is the number of refs in the interest list
+	InterestRefs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "index",
+		Name:      "interest_refs",
+		Help:      "Number of refs in the interest list",
+	})
+	// ActiveRetrievals is the number of retrieval deals we are currently serving
+	ActiveRetrievals = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "active",
+		Help:      "Number of retrieval deals currently being served",
+	})
+	// BytesServed counts the bytes sent to retrieval clients
+	BytesServed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "bytes_served_total",
+		Help:      "Number of bytes sent to retrieval clients",
+	})
+	// PaymentsReceived counts the funds received from retrieval clients
+	PaymentsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "payments_received_fil_total",
+		Help:      "Amount of FIL received from retrieval clients",
+	})
+	// BlockReads observes the latency of blockstore reads when serving content
+	BlockReads = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "blockstore",
+		Name:      "read_seconds",
+		Help:      "Latency of blockstore reads when serving content",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+	// SlowBlockReads counts the blockstore reads slower than the slow block threshold
+	SlowBlockReads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "blockstore",
+		Name:      "slow_reads_total",
+		Help:      "Number of blockstore reads slower than the slow block threshold",
+	})
+	// ProtocolStreams counts the streams opened with each version of our protocols
+	ProtocolStreams = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "protocol",
+		Name:      "streams_total",
+		Help:      "Number of streams opened with each protocol version",
+	}, []string{"protocol"})
+	// DeprecatedProtocolPeers is the number of distinct peers which still use a deprecated protocol version
+	DeprecatedProtocolPeers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "protocol",
+		Name:      "deprecated_peers",
+		Help:      "Number of distinct peers which used a deprecated protocol version",
+	}, []string{"protocol"})
+	// ProbeTTFB observes the time to first byte of the canaries retrieved by the prober
+	ProbeTTFB = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "ttfb_seconds",
+		Help:      "Time to first byte of the canaries retrieved by the prober",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	// ProbeThroughput is the throughput of the last canary retrieved by the prober
+	ProbeThroughput = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "throughput_bytes_per_second",
+		Help:      "Throughput of the last canary retrieved by the prober",
+	})
+	// ProbeResults counts the probes by result
+	ProbeResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "results_total",
+		Help:      "Number of probes by result",
+	}, []string{"result"})
+	// Reachability is 1 for the reachability AutoNAT detected for our host and 0 for the others
+	Reachability = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "nat",
+		Name:      "reachability",
+		Help:      "Whether other peers can dial this pop as detected by AutoNAT",
+	}, []string{"status"})
+	// RelayAddrs is the number of addresses we advertise through a circuit relay
+	RelayAddrs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "nat",
+		Name:      "relay_addrs",
+		Help:      "Number of addresses advertised through a circuit relay",
+	})
+)
+
+// Registry holds all the pop metrics
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		IndexSize,
+		IndexRefs,
+		IndexBuckets,
+		IndexEvictions,
+		IndexHits,
+		IndexMisses,
+		InterestRefs,
+		ActiveRetrievals,
+		BytesServed,
+		PaymentsReceived,
+		BlockReads,
+		SlowBlockReads,
+		ProtocolStreams,
+		DeprecatedProtocolPeers,
+		ProbeTTFB,
+		ProbeThroughput,
+		ProbeResults,
+		Reachability,
+		RelayAddrs,
+	)
+}
+
+// Handler returns an http handler exposing the metrics in the prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes the metrics at /metrics and the guard status at /health on the given address
+// until the context is cancelled
+func Serve(ctx context.Context, addr string, g *Guard) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	mux.Handle("/health", HealthHandler(g))
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
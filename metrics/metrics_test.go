@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	IndexHits.Inc()
+	IndexSize.Set(1024)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	require.Contains(t, body, "pop_index_hits_total 1")
+	require.Contains(t, body, "pop_index_size_bytes 1024")
+	require.Contains(t, body, "pop_retrieval_bytes_served_total 0")
+}
@@ -0,0 +1,250 @@
+// Package mobile embeds a pop light node in iOS and Android apps. Generate the bindings with:
+//
+//	gomobile bind -target=android ./mobile
+//	gomobile bind -target=ios ./mobile
+//
+// gomobile only supports basic types in exported signatures so lists are separated by commas, results
+// are JSON encoded and the progress of long running operations is reported to a callback interface
+// implemented by the app.
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/myelnet/pop/node"
+)
+
+// DefaultCapacity is the storage space a mobile node dedicates to the exchange
+const DefaultCapacity = "1GB"
+
+// DefaultRegions are the regions a mobile node joins
+const DefaultRegions = "Global"
+
+// Config configures the node started by Start
+type Config struct {
+	// RepoPath is the directory the node persists its data in, usually in the app files directory
+	RepoPath string
+	// Regions are the regions to join separated by commas
+	Regions string
+	// BootstrapPeers are the p2p addresses of the pops to connect to separated by commas
+	BootstrapPeers string
+	// Capacity is the storage space dedicated to the exchange such as 500MB
+	Capacity string
+	// FilEndpoint is the websocket url of a Filecoin RPC, the node pays with vouchers only if empty
+	FilEndpoint string
+	// FilToken is the authorization token of the Filecoin RPC
+	FilToken string
+	// PrivKey is a hex encoded private key imported as the default address
+	PrivKey string
+	// EnableAutoRelay lets peers reach the node through a relay when it is behind a NAT
+	EnableAutoRelay bool
+}
+
+// NewConfig returns the default config of a node persisting its data in the given directory
+func NewConfig(repoPath string) *Config {
+	return &Config{
+		RepoPath: repoPath,
+		Regions:  DefaultRegions,
+		Capacity: DefaultCapacity,
+	}
+}
+
+// options converts the config into the options of the node
+func (c *Config) options() (node.Options, error) {
+	if c.RepoPath == "" {
+		return node.Options{}, fmt.Errorf("no repo path")
+	}
+	capacity, err := units.FromHumanSize(c.Capacity)
+	if err != nil {
+		return node.Options{}, fmt.Errorf("invalid capacity: %w", err)
+	}
+	return node.Options{
+		RepoPath:        c.RepoPath,
+		Regions:         splitList(c.Regions),
+		BootstrapPeers:  splitList(c.BootstrapPeers),
+		Capacity:        uint64(capacity),
+		PublisherShare:  node.DefaultPublisherShare,
+		FilEndpoint:     c.FilEndpoint,
+		FilToken:        c.FilToken,
+		PrivKey:         c.PrivKey,
+		EnableAutoRelay: c.EnableAutoRelay,
+	}, nil
+}
+
+// splitList splits a list separated by commas ignoring empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ProgressHandler receives the JSON encoded results of a long running operation as it progresses
+type ProgressHandler interface {
+	OnProgress(result string)
+}
+
+// progress passes a result to the handler if the app gave one
+func progress(h ProgressHandler, v interface{}) error {
+	if h == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h.OnProgress(string(b))
+	return nil
+}
+
+// Node is a pop node running in the app
+type Node struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	e      *node.Embedded
+	repo   string
+}
+
+// Start starts a node with the given config. The app must call Stop before starting it again.
+func Start(cfg *Config) (*Node, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.RepoPath, 0755); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e, err := node.Embed(ctx, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Node{
+		ctx:    ctx,
+		cancel: cancel,
+		e:      e,
+		repo:   cfg.RepoPath,
+	}, nil
+}
+
+// ID returns the peer ID of the node
+func (n *Node) ID() string {
+	return n.e.ID()
+}
+
+// Stop aborts the ongoing operations and stops the node
+func (n *Node) Stop() error {
+	n.cancel()
+	return n.e.Close()
+}
+
+// Status returns the JSON encoded entries of the current transaction
+func (n *Node) Status() (string, error) {
+	res, err := n.e.Status(n.ctx, &node.StatusArgs{})
+	if err != nil {
+		return "", err
+	}
+	return encode(res)
+}
+
+// Put adds the file at the given path to the transaction and returns the root of the transaction
+func (n *Node) Put(path string, h ProgressHandler) (string, error) {
+	var root string
+	err := n.e.Put(n.ctx, &node.PutArgs{Path: path, ChunkSize: 1024}, func(pr *node.PutResult) error {
+		root = pr.Root
+		return progress(h, pr)
+	})
+	return root, err
+}
+
+// PutBytes adds data to the transaction under the given key and returns the root of the transaction.
+// It is convenient for content held in memory by the app such as a photo.
+func (n *Node) PutBytes(key string, data []byte, h ProgressHandler) (string, error) {
+	if key == "" || key != filepath.Base(key) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	tmp := filepath.Join(n.repo, "tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return "", err
+	}
+	// The entry is keyed by the name of the file so it is written in its own directory
+	dir, err := ioutil.TempDir(tmp, "put")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, key)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return n.Put(path, h)
+}
+
+// Commit dispatches the transaction to the given number of cache providers. The JSON encoded results
+// of the commit are passed to the handler.
+func (n *Node) Commit(cacheRF int, h ProgressHandler) error {
+	return n.e.Commit(n.ctx, &node.CommArgs{CacheOnly: true, CacheRF: cacheRF}, func(cr *node.CommResult) error {
+		return progress(h, cr)
+	})
+}
+
+// Query retrieves the entry of a root and writes it to out if not empty. The whole DAG is retrieved if
+// key is empty. Offers costing more than maxPrice FIL are declined, an empty maxPrice only accepts free
+// offers. The JSON encoded progress of the retrieval is passed to the handler.
+func (n *Node) Query(root, key, out, maxPrice string, h ProgressHandler) error {
+	if maxPrice == "" {
+		maxPrice = "0"
+	}
+	args := &node.GetArgs{
+		Cid:      root,
+		Key:      key,
+		Out:      out,
+		MaxPrice: maxPrice,
+	}
+	return n.e.Query(n.ctx, args, func(gr *node.GetResult) error {
+		return progress(h, gr)
+	})
+}
+
+// WalletList returns the JSON encoded addresses of the wallet
+func (n *Node) WalletList() (string, error) {
+	res, err := n.e.WalletList(n.ctx)
+	if err != nil {
+		return "", err
+	}
+	return encode(res)
+}
+
+// WalletNew creates a new address and returns it
+func (n *Node) WalletNew() (string, error) {
+	return n.e.WalletNew(n.ctx)
+}
+
+// WalletSetDefault sets the address paying for retrievals
+func (n *Node) WalletSetDefault(addr string) error {
+	return n.e.WalletSetDefault(addr)
+}
+
+// WalletTransfer sends an amount in FIL between two addresses, it requires a Filecoin RPC
+func (n *Node) WalletTransfer(from, to, amount string) error {
+	return n.e.WalletTransfer(n.ctx, from, to, amount)
+}
+
+func encode(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,50 @@
+package mobile
+
+import (
+	"testing"
+
+	"github.com/myelnet/pop/node"
+	"github.com/stretchr/testify/require"
+)
+
+type progressRecorder struct {
+	results []string
+}
+
+func (r *progressRecorder) OnProgress(result string) {
+	r.results = append(r.results, result)
+}
+
+func TestConfigOptions(t *testing.T) {
+	cfg := NewConfig(t.TempDir())
+	opts, err := cfg.options()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Global"}, opts.Regions)
+	require.Equal(t, uint64(1000000000), opts.Capacity)
+	require.Empty(t, opts.BootstrapPeers)
+
+	cfg.Regions = "Europe, NorthAmerica,"
+	cfg.BootstrapPeers = "/ip4/127.0.0.1/tcp/41504/p2p/12D3KooWQtnktGLsDc3fgHW4vrsCVR15oC1Vn6Wy6Moi65pL6q2a"
+	cfg.Capacity = "500MB"
+	opts, err = cfg.options()
+	require.NoError(t, err)
+	require.Equal(t, []string{"Europe", "NorthAmerica"}, opts.Regions)
+	require.Len(t, opts.BootstrapPeers, 1)
+	require.Equal(t, uint64(500000000), opts.Capacity)
+
+	cfg.Capacity = "lots"
+	_, err = cfg.options()
+	require.Error(t, err)
+
+	_, err = (&Config{Capacity: DefaultCapacity}).options()
+	require.Error(t, err)
+}
+
+func TestProgress(t *testing.T) {
+	require.NoError(t, progress(nil, &node.PutResult{Root: "root"}))
+
+	rec := &progressRecorder{}
+	require.NoError(t, progress(rec, &node.PutResult{Root: "root"}))
+	require.Len(t, rec.results, 1)
+	require.Contains(t, rec.results[0], `"Root":"root"`)
+}
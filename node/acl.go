@@ -0,0 +1,59 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+)
+
+// ParsePeers parses a list of peer IDs separated by commas
+func ParsePeers(list string) ([]peer.ID, error) {
+	var peers []peer.ID
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %s: %w", s, err)
+		}
+		peers = append(peers, pid)
+	}
+	return peers, nil
+}
+
+// ParseACLConfig parses the global access rules given as a default visibility and lists of peer IDs
+// separated by commas
+func ParseACLConfig(visibility, allow, deny, operators string) (exchange.ACLConfig, error) {
+	var cfg exchange.ACLConfig
+	var err error
+	cfg.Default, err = exchange.ParseVisibility(visibility)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Allow, err = ParsePeers(allow); err != nil {
+		return cfg, err
+	}
+	if cfg.Deny, err = ParsePeers(deny); err != nil {
+		return cfg, err
+	}
+	if cfg.Operators, err = ParsePeers(operators); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// peerStrings formats a list of peer IDs
+func peerStrings(peers []peer.ID) []string {
+	if len(peers) == 0 {
+		return nil
+	}
+	list := make([]string, len(peers))
+	for i, p := range peers {
+		list[i] = p.String()
+	}
+	return list
+}
@@ -0,0 +1,34 @@
+package node
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseACLConfig(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+
+	cfg, err := ParseACLConfig("private", "", pid.String(), " "+pid.String()+",")
+	require.NoError(t, err)
+	require.Equal(t, exchange.Private, cfg.Default)
+	require.Empty(t, cfg.Allow)
+	require.Equal(t, []peer.ID{pid}, cfg.Deny)
+	require.Equal(t, []peer.ID{pid}, cfg.Operators)
+
+	cfg, err = ParseACLConfig("", "", "", "")
+	require.NoError(t, err)
+	require.Equal(t, exchange.Public, cfg.Default)
+
+	_, err = ParseACLConfig("secret", "", "", "")
+	require.Error(t, err)
+	_, err = ParseACLConfig("public", "notapeer", "", "")
+	require.Error(t, err)
+}
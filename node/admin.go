@@ -0,0 +1,364 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/myelnet/pop/exchange"
+	"github.com/rs/zerolog/log"
+)
+
+// KAdminToken is the keystore key of the secret the admin API token is derived from
+const KAdminToken = "admin-token"
+
+// AdminToken returns the bearer token guarding the admin API. The secret it is derived from is
+// generated and saved in the keystore the first time.
+func AdminToken(ks keystore.Keystore) (string, error) {
+	k, err := ks.Get(KAdminToken)
+	if errors.Is(err, keystore.ErrNoSuchKey) {
+		k, _, err = ci.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		err = ks.Put(KAdminToken, k)
+	}
+	if err != nil {
+		return "", err
+	}
+	raw, err := k.Raw()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AdminTxResult is returned when creating or committing a transaction with the admin API
+type AdminTxResult struct {
+	ID   string `json:"id"`
+	Root string `json:"root,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// AdminRef describes a ref listed by the admin API
+type AdminRef struct {
+	Root     string            `json:"root"`
+	Size     int64             `json:"size"`
+	Freq     int64             `json:"freq"`
+	BucketID int64             `json:"bucketId"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Created  int64             `json:"created"`
+}
+
+// adminCommit is the optional body of a commit request
+type adminCommit struct {
+	CacheRF int               `json:"cacheRF"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// admin is a REST API to manage the node remotely when the local socket isn't reachable. Every
+// request must carry the admin token as a bearer token.
+//
+// POST /tx creates a transaction, PUT /tx/{id}/file?name={key} adds the request body to it and
+// POST /tx/{id}/commit commits and dispatches it. GET /refs lists the refs in the index and
+// DELETE /refs/{cid} drops one. GET /demand exports a demand snapshot and POST /demand?weight={w} imports
+// the snapshot in the request body so a fleet can share the demand its nodes observed.
+type admin struct {
+	ctx   context.Context
+	node  *node
+	token string
+
+	mu  sync.Mutex
+	txs map[string]*adminTx
+}
+
+// adminTx is a transaction created with the admin API. Requests using the same transaction are serialized.
+type adminTx struct {
+	mu   sync.Mutex
+	tx   *exchange.Tx
+	done bool
+}
+
+// newAdmin creates an admin API handler. Transactions live until committed or the context is cancelled.
+func newAdmin(ctx context.Context, nd *node, token string) *admin {
+	return &admin{
+		ctx:   ctx,
+		node:  nd,
+		token: token,
+		txs:   make(map[string]*adminTx),
+	}
+}
+
+// serveAdmin starts an HTTP server for the admin API on the admin address until the context is cancelled
+func serveAdmin(ctx context.Context, opts Options, nd *node) error {
+	ks, err := keystore.NewFSKeystore(filepath.Join(opts.RepoPath, "keystore"))
+	if err != nil {
+		return err
+	}
+	token, err := AdminToken(ks)
+	if err != nil {
+		return err
+	}
+	adm := newAdmin(ctx, nd, token)
+	srv := &http.Server{
+		Addr:    opts.AdminAddr,
+		Handler: adm,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+		adm.close()
+	}()
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// validBearer returns whether an Authorization header carries the given bearer token
+func validBearer(auth, token string) bool {
+	return strings.HasPrefix(auth, "Bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+func (adm *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !validBearer(r.Header.Get("Authorization"), adm.token) {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return
+	}
+
+	segs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segs) == 1 && segs[0] == "tx" && r.Method == http.MethodPost:
+		adm.createTx(w, r)
+	case len(segs) == 3 && segs[0] == "tx" && segs[2] == "file" && r.Method == http.MethodPut:
+		adm.putFile(w, r, segs[1])
+	case len(segs) == 3 && segs[0] == "tx" && segs[2] == "commit" && r.Method == http.MethodPost:
+		adm.commit(w, r, segs[1])
+	case len(segs) == 1 && segs[0] == "refs" && r.Method == http.MethodGet:
+		adm.listRefs(w, r)
+	case len(segs) == 2 && segs[0] == "refs" && r.Method == http.MethodDelete:
+		adm.dropRef(w, r, segs[1])
+	case len(segs) == 1 && segs[0] == "demand" && r.Method == http.MethodGet:
+		adm.exportDemand(w, r)
+	case len(segs) == 1 && segs[0] == "demand" && r.Method == http.MethodPost:
+		adm.importDemand(w, r)
+	default:
+		http.Error(w, "Method "+r.Method+" not allowed for "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+// writeJSON encodes a response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("admin response")
+	}
+}
+
+func (adm *admin) createTx(w http.ResponseWriter, r *http.Request) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := AdminTxResult{ID: hex.EncodeToString(id)}
+
+	adm.mu.Lock()
+	adm.txs[res.ID] = &adminTx{tx: adm.node.exch.Tx(adm.ctx)}
+	adm.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// lookupTx returns a transaction by ID locked for the duration of a request
+func (adm *admin) lookupTx(w http.ResponseWriter, id string) (*adminTx, bool) {
+	adm.mu.Lock()
+	atx, ok := adm.txs[id]
+	adm.mu.Unlock()
+	if ok {
+		atx.mu.Lock()
+		// The transaction may have been committed while we were waiting
+		if atx.done {
+			atx.mu.Unlock()
+			ok = false
+		}
+	}
+	if !ok {
+		http.Error(w, "transaction not found", http.StatusNotFound)
+	}
+	return atx, ok
+}
+
+func (adm *admin) putFile(w http.ResponseWriter, r *http.Request, id string) {
+	key, err := exchange.ParseKey(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	atx, ok := adm.lookupTx(w, id)
+	if !ok {
+		return
+	}
+	defer atx.mu.Unlock()
+
+	tx := atx.tx
+	if err := tx.PutReader(key.String(), r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminTxResult{
+		ID:   id,
+		Root: tx.Root().String(),
+		Size: tx.Size(),
+	})
+}
+
+func (adm *admin) commit(w http.ResponseWriter, r *http.Request, id string) {
+	var args adminCommit
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			http.Error(w, "invalid commit body", http.StatusBadRequest)
+			return
+		}
+	}
+	atx, ok := adm.lookupTx(w, id)
+	if !ok {
+		return
+	}
+	defer atx.mu.Unlock()
+
+	tx := atx.tx
+	tx.SetCacheRF(args.CacheRF)
+	for k, v := range args.Labels {
+		tx.SetLabel(k, v)
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	atx.done = true
+	adm.mu.Lock()
+	delete(adm.txs, id)
+	adm.mu.Unlock()
+
+	// Wait for the dispatch in the background so the transaction is released once done
+	go func() {
+		tx.WatchDispatch(func(rec exchange.PRecord) {
+			log.Info().Str("root", tx.Root().String()).Str("provider", rec.Provider.String()).Msg("admin dispatch")
+		})
+		tx.Close()
+	}()
+	writeJSON(w, http.StatusOK, AdminTxResult{
+		ID:   id,
+		Root: tx.Root().String(),
+		Size: tx.Size(),
+	})
+}
+
+func (adm *admin) listRefs(w http.ResponseWriter, r *http.Request) {
+	var labels []exchange.Label
+	for _, l := range r.URL.Query()["label"] {
+		label, err := exchange.ParseLabel(l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		labels = append(labels, label)
+	}
+	refs := []AdminRef{}
+	for _, ref := range adm.node.exch.Index().Snapshot() {
+		if !ref.HasLabels(labels...) {
+			continue
+		}
+		refs = append(refs, AdminRef{
+			Root:     ref.PayloadCID.String(),
+			Size:     ref.PayloadSize,
+			Freq:     ref.Freq,
+			BucketID: ref.BucketID,
+			Labels:   ref.Labels,
+			Created:  ref.Created,
+		})
+	}
+	writeJSON(w, http.StatusOK, refs)
+}
+
+func (adm *admin) dropRef(w http.ResponseWriter, r *http.Request, cstr string) {
+	root, err := cid.Decode(cstr)
+	if err != nil {
+		http.Error(w, "invalid cid", http.StatusBadRequest)
+		return
+	}
+	err = adm.node.exch.Index().DropRef(root)
+	if errors.Is(err, exchange.ErrRefNotFound) {
+		http.Error(w, "ref not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (adm *admin) exportDemand(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := adm.node.exch.Index().ExportDemand(w); err != nil {
+		// The status was already sent with the first bytes
+		log.Error().Err(err).Msg("admin demand export")
+	}
+}
+
+func (adm *admin) importDemand(w http.ResponseWriter, r *http.Request) {
+	var weight float64
+	if ws := r.URL.Query().Get("weight"); ws != "" {
+		var err error
+		weight, err = strconv.ParseFloat(ws, 64)
+		if err != nil {
+			http.Error(w, "invalid weight", http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := adm.node.exch.Index().ImportDemand(r.Body, weight)
+	if errors.Is(err, exchange.ErrInvalidDemand) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// close releases the transactions which were never committed
+func (adm *admin) close() {
+	adm.mu.Lock()
+	txs := adm.txs
+	adm.txs = make(map[string]*adminTx)
+	adm.mu.Unlock()
+	for _, atx := range txs {
+		atx.mu.Lock()
+		if !atx.done {
+			atx.done = true
+			atx.tx.Close()
+		}
+		atx.mu.Unlock()
+	}
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminToken(t *testing.T) {
+	ks := keystore.NewMemKeystore()
+	tok, err := AdminToken(ks)
+	require.NoError(t, err)
+	require.NotEmpty(t, tok)
+
+	// The same token is derived from the saved secret
+	again, err := AdminToken(ks)
+	require.NoError(t, err)
+	require.Equal(t, tok, again)
+
+	other, err := AdminToken(keystore.NewMemKeystore())
+	require.NoError(t, err)
+	require.NotEqual(t, tok, other)
+}
+
+func TestAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	adm := newAdmin(ctx, nd, "secret")
+	defer adm.close()
+	srv := httptest.NewServer(adm)
+	defer srv.Close()
+
+	do := func(method, path, token string, body []byte, v interface{}) int {
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		if v != nil && res.StatusCode < 300 {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+		}
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/refs", "", nil, nil))
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/refs", "wrong", nil, nil))
+
+	var tx AdminTxResult
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/tx", "secret", nil, &tx))
+	require.NotEmpty(t, tx.ID)
+
+	data := make([]byte, 256000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+
+	var put AdminTxResult
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/tx/"+tx.ID+"/file", "secret", data, nil))
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/tx/"+tx.ID+"/file?name=data", "secret", data, &put))
+	require.NotEmpty(t, put.Root)
+	require.Equal(t, http.StatusNotFound, do(http.MethodPut, "/tx/nope/file?name=data", "secret", data, nil))
+
+	var comm AdminTxResult
+	body := []byte(`{"labels":{"env":"prod"}}`)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/tx/"+tx.ID+"/commit", "secret", body, &comm))
+	require.Equal(t, put.Root, comm.Root)
+	// The transaction is gone once committed
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/tx/"+tx.ID+"/commit", "secret", nil, nil))
+
+	var refs []AdminRef
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/refs?label=env=prod", "secret", nil, &refs))
+	require.Len(t, refs, 1)
+	require.Equal(t, comm.Root, refs[0].Root)
+	require.Equal(t, "prod", refs[0].Labels["env"])
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/refs?label=env=dev", "secret", nil, &refs))
+	require.Len(t, refs, 0)
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/refs/"+comm.Root, "secret", nil, nil))
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/refs/"+comm.Root, "secret", nil, nil))
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/refs/notacid", "secret", nil, nil))
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/refs", "secret", nil, &refs))
+	require.Len(t, refs, 0)
+}
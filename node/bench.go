@@ -0,0 +1,246 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/myelnet/pop/exchange"
+)
+
+// CapabilityFile is the name of the file in the repo holding the last capability report
+const CapabilityFile = "capability.json"
+
+// DefaultBenchSize is the amount of data in bytes used by each self benchmark
+const DefaultBenchSize = 64 << 20
+
+// benchProtocol streams data between two local hosts to measure the loopback transfer rate
+const benchProtocol = protocol.ID("/myel/pop/bench/1.0")
+
+// benchTransferRate is the throughput in bytes per second we expect a single transfer to need
+const benchTransferRate = 8 << 20
+
+// capacityFillTime is the duration in which the node should be able to write its whole capacity
+const capacityFillTime = 6 * time.Hour
+
+// Capability is a report of the throughput a node can sustain measured by a self benchmark.
+// Rates are in bytes per second.
+type Capability struct {
+	Time      time.Time `json:"time"`
+	CPUs      int       `json:"cpus"`
+	Size      int64     `json:"size"`
+	DiskWrite uint64    `json:"diskWrite"`
+	// DiskRead may be served from the page cache and overestimate cold reads
+	DiskRead uint64 `json:"diskRead"`
+	Chunking uint64 `json:"chunking"`
+	Loopback uint64 `json:"loopback"`
+}
+
+// Throughput is the rate at which the node can ingest content, limited by the slowest of disk writes,
+// chunking and transfers
+func (c Capability) Throughput() uint64 {
+	t := c.DiskWrite
+	if c.Chunking < t {
+		t = c.Chunking
+	}
+	if c.Loopback < t {
+		t = c.Loopback
+	}
+	return t
+}
+
+// MaxReplications is the number of retrievals the node can run at once while giving each of them
+// enough throughput. It is at least 1 and at most twice the number of CPUs.
+func (c Capability) MaxReplications() int {
+	n := int(c.Throughput() / benchTransferRate)
+	if limit := 2 * c.CPUs; limit > 0 && n > limit {
+		n = limit
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// ReplicationBandwidth is the rate the auto replication may use, half the throughput is kept for
+// serving content
+func (c Capability) ReplicationBandwidth() uint64 {
+	return c.Throughput() / 2
+}
+
+// Capacity returns the storage capacity the node should advertise given the requested capacity.
+// It is reduced if the disk is too slow to write it all within a few hours. 0 is returned as is
+// so the exchange default applies.
+func (c Capability) Capacity(requested uint64) uint64 {
+	if c.DiskWrite == 0 {
+		return requested
+	}
+	limit := c.DiskWrite * uint64(capacityFillTime/time.Second)
+	if requested > limit {
+		return limit
+	}
+	return requested
+}
+
+// SaveCapability writes the capability report in the repo so it is applied when the node starts
+func SaveCapability(repoPath string, c Capability) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(repoPath, CapabilityFile), data, 0666)
+}
+
+// LoadCapability reads the last capability report saved in the repo
+func LoadCapability(repoPath string) (*Capability, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, CapabilityFile))
+	if err != nil {
+		return nil, err
+	}
+	var c Capability
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// RunSelfBench measures the disk throughput in the given directory, the chunking speed and the loopback
+// transfer rate with size bytes of random data
+func RunSelfBench(ctx context.Context, dir string, size int64) (*Capability, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	c := &Capability{
+		Time: time.Now(),
+		CPUs: runtime.NumCPU(),
+		Size: size,
+	}
+	var err error
+	c.DiskWrite, c.DiskRead, err = benchDisk(dir, data)
+	if err != nil {
+		return nil, err
+	}
+	c.Chunking, err = benchChunking(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	c.Loopback, err = benchLoopback(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// rate converts a number of bytes processed in a duration to bytes per second
+func rate(n int64, d time.Duration) uint64 {
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return uint64(float64(n) / d.Seconds())
+}
+
+// benchDisk writes the data to a temporary file synced to disk then reads it back
+func benchDisk(dir string, data []byte) (uint64, uint64, error) {
+	f, err := ioutil.TempFile(dir, "bench")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	start := time.Now()
+	if _, err := f.Write(data); err != nil {
+		return 0, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, err
+	}
+	write := rate(int64(len(data)), time.Since(start))
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	start = time.Now()
+	n, err := io.Copy(ioutil.Discard, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return write, rate(n, time.Since(start)), nil
+}
+
+// benchChunking imports the data in an in memory DAG with the default chunker
+func benchChunking(ctx context.Context, data []byte) (uint64, error) {
+	bs := blockstore.NewBlockstore(dss.MutexWrap(datastore.NewMapDatastore()))
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	start := time.Now()
+	if _, err := exchange.DefaultChunkerConfig.Import(ctx, dag, bytes.NewReader(data)); err != nil {
+		return 0, err
+	}
+	return rate(int64(len(data)), time.Since(start)), nil
+}
+
+// benchLoopback streams the data between two libp2p hosts listening on the loopback interface
+func benchLoopback(ctx context.Context, data []byte) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	src, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	dst, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	dst.SetStreamHandler(benchProtocol, func(s network.Stream) {
+		defer s.Close()
+		if _, err := io.Copy(ioutil.Discard, s); err != nil {
+			s.Reset()
+			return
+		}
+		// Acknowledge once everything was received
+		s.Write([]byte{1})
+	})
+	if err := src.Connect(ctx, peer.AddrInfo{ID: dst.ID(), Addrs: dst.Addrs()}); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	s, err := src.NewStream(ctx, dst.ID(), benchProtocol)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	if _, err := s.Write(data); err != nil {
+		return 0, err
+	}
+	if err := s.CloseWrite(); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(s, make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return rate(int64(len(data)), time.Since(start)), nil
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSelfBench(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	c, err := RunSelfBench(ctx, dir, 1<<20)
+	require.NoError(t, err)
+	require.Greater(t, c.DiskWrite, uint64(0))
+	require.Greater(t, c.DiskRead, uint64(0))
+	require.Greater(t, c.Chunking, uint64(0))
+	require.Greater(t, c.Loopback, uint64(0))
+
+	require.NoError(t, SaveCapability(dir, *c))
+	loaded, err := LoadCapability(dir)
+	require.NoError(t, err)
+	require.Equal(t, c.Loopback, loaded.Loopback)
+	require.True(t, c.Time.Equal(loaded.Time))
+}
+
+func TestCapabilityDefaults(t *testing.T) {
+	c := Capability{
+		CPUs:      2,
+		DiskWrite: 100 << 20,
+		Chunking:  200 << 20,
+		Loopback:  32 << 20,
+	}
+	require.Equal(t, uint64(32<<20), c.Throughput())
+	require.Equal(t, 4, c.MaxReplications())
+	require.Equal(t, uint64(16<<20), c.ReplicationBandwidth())
+
+	// Limited by the number of CPUs
+	c.Loopback = 1 << 30
+	require.Equal(t, 4, c.MaxReplications())
+
+	// A slow disk can't fill a large capacity
+	c.DiskWrite = 1 << 20
+	require.Equal(t, uint64(1<<30), c.Capacity(1<<30))
+	require.Equal(t, uint64(6*3600)<<20, c.Capacity(1<<40))
+	require.Equal(t, uint64(0), c.Capacity(0))
+
+	// Always allow a replication
+	c.DiskWrite = 1 << 10
+	require.Equal(t, 1, c.MaxReplications())
+}
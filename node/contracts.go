@@ -0,0 +1,63 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// contractConfig is the JSON representation of a contracted provider in a contracts file
+type contractConfig struct {
+	Peer  string   `json:"peer"`
+	Addrs []string `json:"addrs"`
+	// Price is the agreed retrieval price per GiB e.g. 0.0001FIL, any price is accepted if empty
+	Price string `json:"price"`
+}
+
+// LoadContracts reads the contracted providers from a JSON file holding a list of objects with a peer,
+// addrs and price fields. Providers are tried in the order of the list.
+func LoadContracts(path string) ([]exchange.Contract, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []contractConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	contracts := make([]exchange.Contract, len(configs))
+	peers := make(map[peer.ID]bool)
+	for i, c := range configs {
+		pid, err := peer.Decode(c.Peer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer for contract %d: %w", i, err)
+		}
+		if peers[pid] {
+			return nil, fmt.Errorf("duplicate contract with %s", pid)
+		}
+		peers[pid] = true
+		ct := exchange.Contract{Peer: pid}
+		for _, a := range c.Addrs {
+			addr, err := ma.NewMultiaddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address for %s: %w", pid, err)
+			}
+			ct.Addrs = append(ct.Addrs, addr)
+		}
+		if c.Price != "" {
+			price, err := filecoin.ParseFIL(c.Price)
+			if err != nil {
+				return nil, fmt.Errorf("invalid price for %s: %w", pid, err)
+			}
+			// Convert to a price per byte
+			ct.PricePerByte = filecoin.BigDiv(filecoin.BigInt(price), filecoin.NewInt(1<<30))
+		}
+		contracts[i] = ct
+	}
+	return contracts, nil
+}
@@ -0,0 +1,43 @@
+package node
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadContracts(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+
+	p := filepath.Join(t.TempDir(), "contracts.json")
+	require.NoError(t, os.WriteFile(p, []byte(`[
+		{"peer": "`+pid.String()+`", "addrs": ["/ip4/127.0.0.1/tcp/41504"], "price": "1073741824attoFIL"}
+	]`), 0666))
+
+	contracts, err := LoadContracts(p)
+	require.NoError(t, err)
+	require.Len(t, contracts, 1)
+	require.Equal(t, pid, contracts[0].Peer)
+	require.Len(t, contracts[0].Addrs, 1)
+	require.Equal(t, abi.NewTokenAmount(1), contracts[0].PricePerByte)
+
+	require.NoError(t, os.WriteFile(p, []byte(`[
+		{"peer": "`+pid.String()+`"},
+		{"peer": "`+pid.String()+`"}
+	]`), 0666))
+	_, err = LoadContracts(p)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(p, []byte(`[{"peer": "notapeer"}]`), 0666))
+	_, err = LoadContracts(p)
+	require.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/exchange"
+)
+
+// ParseDelegators parses a list of peer IDs separated by commas allowed to delegate their retrievals to us
+func ParseDelegators(list string) ([]peer.ID, error) {
+	return parsePeerList(list, "delegator")
+}
+
+// ParseDelegate parses the p2p address of the pop retrieving content on our behalf e.g.
+// /ip4/1.2.3.4/tcp/41504/p2p/<id>. A bare peer ID is accepted if we know its addresses.
+func ParseDelegate(addr string) (peer.AddrInfo, error) {
+	if pid, err := peer.Decode(addr); err == nil {
+		return peer.AddrInfo{ID: pid}, nil
+	}
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("invalid delegate address: %w", err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("invalid delegate address: %w", err)
+	}
+	return *info, nil
+}
+
+// delegate asks a trusted pop to retrieve the content and send it back to us so we don't pay providers
+func (nd *node) delegate(ctx context.Context, c cid.Cid, args *GetArgs) error {
+	info, err := ParseDelegate(args.Delegate)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := nd.exch.Delegate(ctx, info, c); err != nil {
+		return err
+	}
+	if args.Out != "" {
+		f, err := nd.exch.Tx(ctx, exchange.WithRoot(c)).GetFile(args.Key)
+		if err != nil {
+			return err
+		}
+		if err := writeOutput(f, args.Out, outputName(c, args.Key)); err != nil {
+			return err
+		}
+	}
+	nd.sendTo(ctx, Notify{
+		GetResult: &GetResult{
+			TransLatSeconds: time.Since(start).Seconds(),
+		},
+	})
+	return nil
+}
@@ -0,0 +1,131 @@
+package node
+
+import (
+	"context"
+	"errors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/myelnet/pop/wallet"
+)
+
+// Embedded is a node running in the process of an application such as a mobile app instead of a daemon.
+// Operations are executed as commands like the gRPC API does and their results are returned directly.
+type Embedded struct {
+	s      *grpcServer
+	cancel context.CancelFunc
+}
+
+// Embed starts a node in the current process. It runs until Close is called or the context is cancelled.
+func Embed(ctx context.Context, opts Options) (*Embedded, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	nd, err := New(ctx, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return newEmbedded(ctx, cancel, nd), nil
+}
+
+func newEmbedded(ctx context.Context, cancel context.CancelFunc, nd *node) *Embedded {
+	// Notifications nobody is waiting for are dropped
+	nd.notify = func(Notify) {}
+	return &Embedded{
+		s: &grpcServer{
+			ctx: ctx,
+			nd:  nd,
+			cs:  NewCommandServer(nd, nil),
+		},
+		cancel: cancel,
+	}
+}
+
+// ID returns the peer ID of the node
+func (e *Embedded) ID() string {
+	return e.s.nd.host.ID().String()
+}
+
+// Close stops the node and releases the repo so it can be opened again
+func (e *Embedded) Close() error {
+	e.cancel()
+	nd := e.s.nd
+	if nd.pubHost != nd.host {
+		nd.pubHost.Close()
+	}
+	if err := nd.host.Close(); err != nil {
+		return err
+	}
+	return nd.ds.Close()
+}
+
+// Status returns the entries of the current transaction
+func (e *Embedded) Status(ctx context.Context, args *StatusArgs) (*StatusResult, error) {
+	var res *StatusResult
+	err := e.s.run(ctx, &Command{Status: args}, func(n Notify) (bool, error) {
+		if sr := n.StatusResult; sr != nil {
+			if sr.Err != "" {
+				return true, errors.New(sr.Err)
+			}
+			res = sr
+			return true, nil
+		}
+		return false, nil
+	})
+	return res, err
+}
+
+// Put adds a file or directory to the transaction and passes the progress to fn until the root is added
+func (e *Embedded) Put(ctx context.Context, args *PutArgs, fn func(*PutResult) error) error {
+	return e.s.put(ctx, args, fn)
+}
+
+// Commit commits the transaction and passes the results to fn until the commit is completed
+func (e *Embedded) Commit(ctx context.Context, args *CommArgs, fn func(*CommResult) error) error {
+	return e.s.commit(ctx, args, fn)
+}
+
+// Query retrieves content and passes the progress to fn until the retrieval is completed. Retrievals
+// waiting for a confirmation are confirmed with Confirm.
+func (e *Embedded) Query(ctx context.Context, args *GetArgs, fn func(*GetResult) error) error {
+	return e.s.query(ctx, args, fn)
+}
+
+// Confirm accepts or declines a retrieval waiting for a confirmation
+func (e *Embedded) Confirm(ctx context.Context, args *ConfirmArgs) {
+	e.s.nd.Confirm(ctx, args)
+}
+
+// WalletList lists the addresses of the wallet
+func (e *Embedded) WalletList(ctx context.Context) (*WalletResult, error) {
+	return e.s.walletResult(ctx)
+}
+
+// WalletNew creates a new address and returns it
+func (e *Embedded) WalletNew(ctx context.Context) (string, error) {
+	addr, err := e.s.nd.exch.Wallet().NewKey(ctx, wallet.KTSecp256k1)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// WalletSetDefault sets the address used to pay for retrievals and storage
+func (e *Embedded) WalletSetDefault(addr string) error {
+	a, err := address.NewFromString(addr)
+	if err != nil {
+		return err
+	}
+	return e.s.nd.exch.Wallet().SetDefaultAddress(a)
+}
+
+// WalletTransfer sends an amount in FIL between two addresses, it requires a Filecoin RPC
+func (e *Embedded) WalletTransfer(ctx context.Context, from, to, amount string) error {
+	f, err := address.NewFromString(from)
+	if err != nil {
+		return err
+	}
+	t, err := address.NewFromString(to)
+	if err != nil {
+		return err
+	}
+	return e.s.nd.exch.Wallet().Transfer(ctx, f, t, amount)
+}
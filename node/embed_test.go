@@ -0,0 +1,60 @@
+package node
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	e := newEmbedded(ctx, cancel, nd)
+	require.Equal(t, nd.host.ID().String(), e.ID())
+
+	// No transaction yet
+	_, err := e.Status(ctx, &StatusArgs{})
+	require.Error(t, err)
+
+	data := make([]byte, 256000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	file, err := ioutil.TempFile(t.TempDir(), "data")
+	require.NoError(t, err)
+	_, err = file.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var results []*PutResult
+	require.NoError(t, e.Put(ctx, &PutArgs{Path: file.Name(), ChunkSize: 1024}, func(pr *PutResult) error {
+		results = append(results, pr)
+		return nil
+	}))
+	require.Len(t, results, 1)
+	require.NotEmpty(t, results[0].Root)
+
+	sr, err := e.Status(ctx, &StatusArgs{})
+	require.NoError(t, err)
+	require.Equal(t, results[0].Root, sr.RootCid)
+
+	// Missing files fail the put
+	require.Error(t, e.Put(ctx, &PutArgs{Path: "nope"}, func(*PutResult) error { return nil }))
+
+	addr, err := e.WalletNew(ctx)
+	require.NoError(t, err)
+	require.NoError(t, e.WalletSetDefault(addr))
+	wr, err := e.WalletList(ctx)
+	require.NoError(t, err)
+	require.Len(t, wr.Addresses, 2)
+	for _, wa := range wr.Addresses {
+		require.Equal(t, wa.Address == addr, wa.Default)
+	}
+	require.Error(t, e.WalletSetDefault("nope"))
+}
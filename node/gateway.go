@@ -0,0 +1,469 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	gopath "path"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/light"
+	"github.com/myelnet/pop/lightclient"
+	"github.com/myelnet/pop/selectors"
+	"github.com/rs/zerolog/log"
+)
+
+// gateway serves content cached in the exchange index over HTTP so a pop can act as
+// a CDN edge for browsers. Unlike the localhost handler it never triggers a retrieval
+// and only serves what is already in our stores. Responses follow the cache policy of
+// the node like the localhost handler.
+type gateway struct {
+	node *node
+	// transforms convert content at serve time for the requests they match
+	transforms []Transform
+	// variants caches transformed content if not nil
+	variants datastore.Batching
+	// tenants restricts access to the holders of an API token if not nil
+	tenants *tenants
+	// paid requires a payment channel voucher for the content which isn't free in our region
+	paid   bool
+	region exchange.Region
+}
+
+// serveGateway starts an HTTP server on the gateway address until the context is cancelled
+func serveGateway(ctx context.Context, opts Options, nd *node) error {
+	gw := &gateway{
+		node:       nd,
+		transforms: opts.GatewayTransforms,
+		tenants:    nd.tenants,
+		paid:       opts.GatewayPayments,
+	}
+	// Content is priced like the queries from our first region
+	if regions := exchange.ParseRegions(opts.Regions); len(regions) > 0 {
+		gw.region = regions[0]
+	}
+	if opts.CacheVariants {
+		gw.variants = namespace.Wrap(nd.ds, datastore.NewKey("/gateway/variants"))
+	}
+	if gw.tenants != nil {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					if err := gw.tenants.flush(); err != nil {
+						log.Error().Err(err).Msg("persisting tenant usage")
+					}
+					return
+				}
+				if err := gw.tenants.flush(); err != nil {
+					log.Error().Err(err).Msg("persisting tenant usage")
+				}
+			}
+		}()
+	}
+	srv := &http.Server{
+		Addr:    opts.GatewayAddr,
+		Handler: gw,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (gw *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		gw.addHeaders(w)
+		return
+	default:
+		http.Error(w, "Method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if gw.tenants != nil {
+		ts, ok := gw.tenants.lookup(requestToken(r))
+		if !ok {
+			http.Error(w, "invalid API token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/usage" {
+			gw.addHeaders(w)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(gw.tenants.usage(ts))
+			return
+		}
+		if status, msg := gw.tenants.admit(ts, time.Now()); status != 0 {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			http.Error(w, msg, status)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		defer func() {
+			gw.tenants.record(ts, cw.n)
+		}()
+		w = cw
+	}
+	// Shed load if we're running out of resources
+	if err := gw.node.guard.Check(); err != nil {
+		http.Error(w, "node is over capacity", http.StatusServiceUnavailable)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/offer/") {
+		gw.serveOffer(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/ipfs/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	p, err := exchange.ParsePath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := gw.node.exch.Index().PeekRef(p.Root); err != nil {
+		http.Error(w, "content not found", http.StatusNotFound)
+		return
+	}
+	if status, msg := gw.authorize(p); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if status, msg := gw.charge(r, p); status != 0 {
+		gw.addHeaders(w)
+		http.Error(w, msg, status)
+		return
+	}
+	// Content is checked again once its max age passed so we never serve content we couldn't validate.
+	// Unlike the localhost handler the gateway doesn't fetch it again if it went missing.
+	state, age := gw.node.lc.state(p.Root, time.Now())
+	var release func()
+	switch state {
+	case cacheExpired:
+		if err := gw.node.revalidate(r.Context(), p.Root); err != nil {
+			http.Error(w, "content not found", http.StatusNotFound)
+			return
+		}
+	case cacheNearExpiry, cacheStale:
+		// The content isn't dropped until we served it
+		release = gw.node.lc.read(p.Root)
+		go func() {
+			if err := gw.node.revalidate(context.Background(), p.Root); err != nil {
+				log.Error().Err(err).Str("root", p.Root.String()).Msg("background revalidation")
+			}
+		}()
+	}
+	if release == nil {
+		release = gw.node.lc.read(p.Root)
+	}
+	defer release()
+
+	if isCarRequest(r) {
+		gw.serveCar(w, r, p, state, age)
+		return
+	}
+	if p.IsRoot() {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	tx := gw.node.exch.Tx(r.Context(), exchange.WithRoot(p.Root), exchange.WithPrefetch(exchange.DefaultPrefetchWindow))
+	defer tx.Close()
+	fnd, err := tx.GetFile(p.Key().String())
+	if err != nil {
+		http.Error(w, "content not found", http.StatusNotFound)
+		return
+	}
+	// Walk any directory imported with PutDir
+	for _, name := range p.Segments[1:] {
+		dir, ok := fnd.(files.Directory)
+		if !ok {
+			http.Error(w, "content not found", http.StatusNotFound)
+			return
+		}
+		fnd, err = findEntry(dir, name.String())
+		if err != nil {
+			http.Error(w, "content not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	gw.addHeaders(w)
+	// Content under a root never changes so the path is enough to identify its version
+	etag := fmt.Sprintf(`"%s"`, strings.TrimPrefix(p.String(), "/"))
+	w.Header().Set("Etag", etag)
+	gw.node.lc.setHeaders(w, state, age)
+	// Let clients know enough publishers signed off on this content
+	if gw.node.exch.Index().Verified(p.Root) {
+		w.Header().Set("X-Pop-Verified", "true")
+	}
+
+	switch f := fnd.(type) {
+	case files.File:
+		gw.serveFile(w, r, f)
+	case files.Directory:
+		gw.serveDirectory(w, r, f)
+	default:
+		http.Error(w, "unsupported file type", http.StatusInternalServerError)
+	}
+}
+
+func (gw *gateway) addHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Range, If-None-Match, Accept, Authorization, "+lightclient.VoucherHeader)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Content-Length, Etag, X-Pop-Verified")
+}
+
+// serveOffer tells light clients the size and price of the content a path points to
+func (gw *gateway) serveOffer(w http.ResponseWriter, r *http.Request) {
+	p, err := exchange.ParsePath(strings.TrimPrefix(r.URL.Path, "/offer"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if status, msg := gw.authorize(p); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	offer, err := gw.offer(r.Context(), p)
+	if err != nil {
+		http.Error(w, "content not found", http.StatusNotFound)
+		return
+	}
+	gw.addHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	// The price may change with our load
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(offer)
+}
+
+// authorize checks the ACL lets anonymous clients retrieve the content. HTTP clients have no peer ID so
+// only the public refs are served. It returns the status and message of the response if access is denied.
+func (gw *gateway) authorize(p exchange.Path) (int, string) {
+	err := gw.node.exch.ACL().Check("", p.Root)
+	if err == nil {
+		return 0, ""
+	}
+	if errors.Is(err, exchange.ErrAccessDenied) {
+		return http.StatusForbidden, "access denied"
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+// offer prices the content a path points to like a query from the gossip network. Content is free unless
+// the gateway requires payments.
+func (gw *gateway) offer(ctx context.Context, p exchange.Path) (lightclient.Offer, error) {
+	sel, err := p.Selector()
+	if err != nil {
+		return lightclient.Offer{}, err
+	}
+	store, err := gw.node.exch.Index().GetStore(p.Root)
+	if err != nil {
+		return lightclient.Offer{}, err
+	}
+	stats, err := exchange.Stat(ctx, store, p.Root, sel)
+	if err != nil {
+		return lightclient.Offer{}, err
+	}
+	offer := lightclient.Offer{
+		Provider:       gw.node.host.ID().String(),
+		Root:           p.Root,
+		Key:            p.Key().String(),
+		Size:           uint64(stats.Size),
+		PricePerByte:   big.Zero(),
+		PaymentAddress: gw.node.exch.Wallet().DefaultAddress(),
+	}
+	if gw.paid {
+		offer.PricePerByte = gw.node.exch.Pricing().Price(exchange.PricingRequest{
+			Root:   p.Root,
+			Size:   offer.Size,
+			Region: gw.region,
+		})
+	}
+	return offer, nil
+}
+
+// charge checks the voucher sent with a request pays for the content when the gateway requires payments.
+// It returns the status and message of the response if the request isn't paid for.
+func (gw *gateway) charge(r *http.Request, p exchange.Path) (int, string) {
+	if !gw.paid || r.Method == http.MethodHead {
+		return 0, ""
+	}
+	offer, err := gw.offer(r.Context(), p)
+	if err != nil {
+		return http.StatusNotFound, "content not found"
+	}
+	if offer.Free() {
+		return 0, ""
+	}
+	v := r.Header.Get(lightclient.VoucherHeader)
+	if v == "" {
+		return http.StatusPaymentRequired, fmt.Sprintf("payment required, get the price at /offer%s", p)
+	}
+	sv, err := lightclient.DecodeVoucher(v)
+	if err != nil {
+		return http.StatusBadRequest, "invalid voucher"
+	}
+	// The voucher must add at least the price of the content to what the channel already paid us
+	if _, err := gw.node.exch.Payments().AddVoucherInbound(r.Context(), sv.ChannelAddr, sv, nil, offer.Price()); err != nil {
+		return http.StatusPaymentRequired, fmt.Sprintf("invalid payment: %s", err)
+	}
+	return 0, ""
+}
+
+// isCarRequest checks if the client asks for the blocks in a CAR archive instead of the file content
+func isCarRequest(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "car" || strings.Contains(r.Header.Get("Accept"), light.CarContentType)
+}
+
+// serveCar writes the blocks of the whole DAG or of a single entry of the root in a CAR archive
+// so light clients can verify the content themselves
+func (gw *gateway) serveCar(w http.ResponseWriter, r *http.Request, p exchange.Path, state cacheState, age time.Duration) {
+	sel, err := p.Selector()
+	if err != nil {
+		http.Error(w, "archives can only select an entry of the root", http.StatusBadRequest)
+		return
+	}
+	gw.addHeaders(w)
+	etag := fmt.Sprintf(`"%s.car"`, strings.TrimPrefix(p.String(), "/"))
+	w.Header().Set("Etag", etag)
+	gw.node.lc.setHeaders(w, state, age)
+	w.Header().Set("Content-Type", light.CarContentType)
+	if gw.node.exch.Index().Verified(p.Root) {
+		w.Header().Set("X-Pop-Verified", "true")
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	tx := gw.node.exch.Tx(r.Context(), exchange.WithRoot(p.Root))
+	defer tx.Close()
+	// Headers are already sent once we start streaming so clients detect failures by verifying the archive
+	if err := tx.ExportCar(p.Root, w, sel); err != nil {
+		log.Error().Err(err).Msg("exporting car")
+	}
+}
+
+// serveFile relies on http.ServeContent to handle range requests and If-None-Match
+// conditions against the Etag header
+func (gw *gateway) serveFile(w http.ResponseWriter, r *http.Request, f files.File) {
+	size, err := f.Size()
+	if err != nil {
+		http.Error(w, "cannot serve files with unknown sizes", http.StatusBadGateway)
+		return
+	}
+	content := &lazySeeker{
+		size:   size,
+		reader: f,
+	}
+	mimeType, err := mimetype.DetectReader(content)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot detect content-type: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "seeker can't seek", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeType.String())
+	for _, tf := range gw.transforms {
+		if tf.Match(r, mimeType.String()) {
+			gw.serveVariant(w, r, tf, content)
+			return
+		}
+	}
+	http.ServeContent(w, r, gopath.Base(r.URL.Path), time.Time{}, content)
+}
+
+// serveVariant serves the content converted by a transform. Variants are cached under a key
+// derived from the transform name and the content path if caching is enabled.
+func (gw *gateway) serveVariant(w http.ResponseWriter, r *http.Request, tf Transform, content io.Reader) {
+	key := datastore.NewKey(tf.Name()).Child(datastore.NewKey(gopath.Clean(r.URL.Path)))
+	var data []byte
+	if gw.variants != nil {
+		data, _ = gw.variants.Get(key)
+	}
+	if data == nil {
+		buf := new(bytes.Buffer)
+		if err := tf.Apply(buf, content); err != nil {
+			http.Error(w, fmt.Sprintf("cannot transform content: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+		if gw.variants != nil {
+			if err := gw.variants.Put(key, data); err != nil {
+				log.Error().Err(err).Msg("caching variant")
+			}
+		}
+	}
+	tf.Header(w.Header())
+	// Each variant is a different representation of the content
+	etag := strings.TrimSuffix(w.Header().Get("Etag"), `"`)
+	w.Header().Set("Etag", fmt.Sprintf(`%s+%s"`, etag, tf.Name()))
+	http.ServeContent(w, r, gopath.Base(r.URL.Path), time.Time{}, bytes.NewReader(data))
+}
+
+// serveDirectory writes a minimal listing of the directory entries
+func (gw *gateway) serveDirectory(w http.ResponseWriter, r *http.Request, dir files.Directory) {
+	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == w.Header().Get("Etag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	base := strings.TrimSuffix(r.URL.Path, "/")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, "<html><body><ul>")
+	it := dir.Entries()
+	for it.Next() {
+		name := html.EscapeString(it.Name())
+		fmt.Fprintf(w, `<li><a href="%s/%s">%s</a></li>`, html.EscapeString(base), name, name)
+	}
+	io.WriteString(w, "</ul></body></html>")
+	if it.Err() != nil {
+		log.Error().Err(it.Err()).Msg("listing directory")
+	}
+}
+
+func findEntry(dir files.Directory, name string) (files.Node, error) {
+	it := dir.Entries()
+	for it.Next() {
+		if it.Name() == name {
+			return it.Node(), nil
+		}
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
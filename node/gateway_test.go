@@ -0,0 +1,307 @@
+package node
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/light"
+	"github.com/myelnet/pop/lightclient"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "hello.txt")
+	require.NoError(t, os.WriteFile(p, []byte("hello pop gateway"), 0666))
+
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(p))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	gw := &gateway{node: nd}
+	path := fmt.Sprintf("/ipfs/%s/%s", root, exchange.FileKey(p).String())
+
+	// Full content
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello pop gateway", string(body))
+	etag := rec.Header().Get("Etag")
+	require.NotEqual(t, "", etag)
+
+	// Range request
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Range", "bytes=6-8")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "pop", rec.Body.String())
+
+	// Conditional request
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	// Content we don't have is never retrieved
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/ipfs/%s/missing", blocksutil.NewBlockGenerator().Next().Cid()), nil)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGatewayCar(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	dir := t.TempDir()
+	p1 := filepath.Join(dir, "hello.txt")
+	require.NoError(t, os.WriteFile(p1, []byte("hello pop gateway"), 0666))
+	p2 := filepath.Join(dir, "other.txt")
+	require.NoError(t, os.WriteFile(p2, []byte("some other content"), 0666))
+
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(p1))
+	require.NoError(t, tx.PutFile(p2))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	srv := httptest.NewServer(&gateway{node: nd})
+	defer srv.Close()
+	client := light.NewClient(srv.URL, nil)
+
+	// Fetch the whole root and read a file from the verified blocks
+	content, err := client.Fetch(ctx, root, "")
+	require.NoError(t, err)
+	f, err := content.File(ctx, exchange.FileKey(p2).String())
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, "some other content", string(body))
+
+	// Fetch a single entry
+	key := exchange.FileKey(p1).String()
+	content, err = client.Fetch(ctx, root, key)
+	require.NoError(t, err)
+	f, err = content.File(ctx, key)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, "hello pop gateway", string(body))
+	// Blocks of the other entry are not sent
+	_, err = content.File(ctx, exchange.FileKey(p2).String())
+	require.Error(t, err)
+
+	// Content we don't have is never retrieved
+	_, err = client.Fetch(ctx, blocksutil.NewBlockGenerator().Next().Cid(), "")
+	require.Error(t, err)
+}
+
+func TestGatewayACL(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "hello.txt")
+	require.NoError(t, os.WriteFile(p, []byte("hello pop gateway"), 0666))
+
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(p))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	require.NoError(t, nd.exch.ACL().Set(exchange.RefACL{
+		Root:       root,
+		Visibility: exchange.Private,
+		Allow:      []peer.ID{nd.host.ID()},
+	}))
+
+	gw := &gateway{node: nd}
+	key := exchange.FileKey(p).String()
+	// Private content is denied to HTTP clients whatever they ask for
+	for _, path := range []string{
+		fmt.Sprintf("/ipfs/%s/%s", root, key),
+		fmt.Sprintf("/ipfs/%s?format=car", root),
+		fmt.Sprintf("/offer/%s/%s", root, key),
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, path)
+		require.NotContains(t, rec.Body.String(), "hello pop gateway")
+	}
+
+	// It is served once public
+	require.NoError(t, nd.exch.ACL().Delete(root))
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/ipfs/%s/%s", root, key), nil)
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello pop gateway", rec.Body.String())
+}
+
+func TestGatewayTransforms(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	dir := t.TempDir()
+	txt := filepath.Join(dir, "hello.txt")
+	content := strings.Repeat("hello pop gateway ", 100)
+	require.NoError(t, os.WriteFile(txt, []byte(content), 0666))
+
+	img := image.NewRGBA(image.Rect(0, 0, 512, 256))
+	buf := new(bytes.Buffer)
+	require.NoError(t, png.Encode(buf, img))
+	pic := filepath.Join(dir, "pic.png")
+	require.NoError(t, os.WriteFile(pic, buf.Bytes(), 0666))
+
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(txt))
+	require.NoError(t, tx.PutFile(pic))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	gw := &gateway{
+		node:       nd,
+		transforms: []Transform{GzipTransform{}, PreviewTransform{MaxWidth: 128}},
+		variants:   namespace.Wrap(nd.ds, datastore.NewKey("/gateway/variants")),
+	}
+	txtPath := fmt.Sprintf("/ipfs/%s/%s", root, exchange.FileKey(txt).String())
+
+	// Clients that don't accept gzip get the original content
+	req := httptest.NewRequest(http.MethodGet, txtPath, nil)
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "", rec.Header().Get("Content-Encoding"))
+	require.Equal(t, content, rec.Body.String())
+	etag := rec.Header().Get("Etag")
+
+	req = httptest.NewRequest(http.MethodGet, txtPath, nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	require.NotEqual(t, etag, rec.Header().Get("Etag"))
+	gz, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, content, string(body))
+
+	// The variant is cached under a derived key
+	has, err := gw.variants.Has(datastore.NewKey("gzip").Child(datastore.NewKey(txtPath)))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/ipfs/%s/%s?preview", root, exchange.FileKey(pic).String()), nil)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	preview, err := png.Decode(rec.Body)
+	require.NoError(t, err)
+	require.Equal(t, 128, preview.Bounds().Dx())
+	require.Equal(t, 64, preview.Bounds().Dy())
+}
+
+func TestGatewayOffers(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	p := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(p, []byte("hello pop gateway"), 0666))
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(p))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+	key := exchange.FileKey(p).String()
+
+	// Content is free unless the gateway requires payments
+	srv := httptest.NewServer(&gateway{node: nd, region: exchange.Regions["Europe"]})
+	defer srv.Close()
+	client := lightclient.New([]string{srv.URL})
+	offers, err := client.Query(ctx, root, key)
+	require.NoError(t, err)
+	require.Len(t, offers, 1)
+	require.True(t, offers[0].Free())
+	require.NotZero(t, offers[0].Size)
+	require.Equal(t, nd.host.ID().String(), offers[0].Provider)
+	content, err := client.Fetch(ctx, root, key)
+	require.NoError(t, err)
+	f, err := content.File(ctx, key)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(f.(files.File))
+	require.NoError(t, err)
+	require.Equal(t, "hello pop gateway", string(body))
+
+	paid := httptest.NewServer(&gateway{node: nd, paid: true, region: exchange.Regions["Europe"]})
+	defer paid.Close()
+	client = lightclient.New([]string{paid.URL})
+	offers, err = client.Query(ctx, root, key)
+	require.NoError(t, err)
+	require.False(t, offers[0].Free())
+	require.Equal(t, nd.exch.Wallet().DefaultAddress(), offers[0].PaymentAddress)
+	_, err = client.Retrieve(ctx, offers[0])
+	require.True(t, errors.Is(err, lightclient.ErrNoChannel))
+
+	// Requests without a valid voucher aren't served
+	res, err := http.Get(paid.URL + "/ipfs/" + root.String() + "/" + key + "?format=car")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusPaymentRequired, res.StatusCode)
+	req, err := http.NewRequest(http.MethodGet, paid.URL+"/ipfs/"+root.String()+"/"+key, nil)
+	require.NoError(t, err)
+	req.Header.Set(lightclient.VoucherHeader, "notavoucher")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
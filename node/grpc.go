@@ -0,0 +1,493 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/myelnet/pop/wallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCService is the full name of the gRPC service exposed by the node. Methods are called at
+// /pop.Node/<Method> e.g. /pop.Node/Status.
+const GRPCService = "pop.Node"
+
+// GRPCCodec is the content subtype of the gRPC API. Messages are the JSON encoded Args and Result
+// types of the command protocol so clients must send requests as application/grpc+json.
+const GRPCCodec = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes gRPC messages as JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return GRPCCodec
+}
+
+// WalletArgs provides params for the wallet methods of the gRPC API
+type WalletArgs struct {
+	// Address is the address to set as default
+	Address string
+}
+
+// WalletAddress describes an address of the wallet
+type WalletAddress struct {
+	Address string
+	Default bool
+	// Balance is only set when the node is connected to a Filecoin RPC
+	Balance string
+}
+
+// WalletResult lists the addresses of the wallet
+type WalletResult struct {
+	Addresses []WalletAddress
+}
+
+// ConfirmResult acknowledges a confirmation
+type ConfirmResult struct{}
+
+// grpcServer drives the node from gRPC calls. Calls are executed as commands and the notifications they
+// trigger are sent back to the caller.
+type grpcServer struct {
+	// ctx is the context of the daemon as operations such as transactions outlive the calls
+	ctx context.Context
+	nd  *node
+	cs  *CommandServer
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCService,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Status",
+			Handler:    grpcStatusHandler,
+		},
+		{
+			MethodName: "Confirm",
+			Handler:    grpcConfirmHandler,
+		},
+		{
+			MethodName: "WalletList",
+			Handler:    grpcWalletListHandler,
+		},
+		{
+			MethodName: "WalletNew",
+			Handler:    grpcWalletNewHandler,
+		},
+		{
+			MethodName: "WalletSetDefault",
+			Handler:    grpcWalletSetDefaultHandler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    grpcStatsHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Put",
+			Handler:       grpcPutHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Commit",
+			Handler:       grpcCommitHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Query",
+			Handler:       grpcQueryHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListRefs",
+			Handler:       grpcListRefsHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// newGRPCServer creates a gRPC server executing calls with the given node. Calls must carry the admin
+// token as a bearer token in their authorization metadata.
+func newGRPCServer(ctx context.Context, nd *node, token string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authorize(ss.Context(), token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	srv.RegisterService(&grpcServiceDesc, &grpcServer{
+		ctx: ctx,
+		nd:  nd,
+		cs:  NewCommandServer(nd, nil),
+	})
+	return srv
+}
+
+// authorize checks the metadata of a call carries the bearer token
+func authorize(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if validBearer(auth, token) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
+
+// serveGRPC serves the gRPC API on the gRPC address until the context is cancelled
+func serveGRPC(ctx context.Context, opts Options, nd *node) error {
+	ks, err := keystore.NewFSKeystore(filepath.Join(opts.RepoPath, "keystore"))
+	if err != nil {
+		return err
+	}
+	token, err := AdminToken(ks)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", opts.GRPCAddr)
+	if err != nil {
+		return err
+	}
+	srv := newGRPCServer(ctx, nd, token)
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+	return srv.Serve(l)
+}
+
+// subscribe passes every notification to fn until the returned function is called
+func (nd *node) subscribe(fn func(Notify)) func() {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	if nd.subs == nil {
+		nd.subs = make(map[int]func(Notify))
+	}
+	id := nd.nextSub
+	nd.nextSub++
+	nd.subs[id] = fn
+	return func() {
+		nd.mu.Lock()
+		defer nd.mu.Unlock()
+		delete(nd.subs, id)
+	}
+}
+
+// run executes a command and passes the notifications it sends until fn returns true or an error
+func (s *grpcServer) run(ctx context.Context, cmd *Command, fn func(Notify) (bool, error)) error {
+	// Ids are random like session tokens so calls to other servers of the node never collide
+	id := newSessionToken()
+
+	// Notifications are queued so a slow caller never blocks the node sending them
+	var (
+		mu     sync.Mutex
+		queue  []Notify
+		queued = make(chan struct{}, 1)
+	)
+	unsubscribe := s.nd.subscribe(func(n Notify) {
+		if n.Request != id {
+			return
+		}
+		mu.Lock()
+		queue = append(queue, n)
+		mu.Unlock()
+		select {
+		case queued <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	errs := make(chan error, 1)
+	go func() {
+		if err := s.cs.GotMsg(withRequest(s.ctx, id), cmd); err != nil {
+			errs <- status.Error(codes.InvalidArgument, err.Error())
+		}
+	}()
+	for {
+		select {
+		case <-queued:
+			mu.Lock()
+			notifs := queue
+			queue = nil
+			mu.Unlock()
+			for _, n := range notifs {
+				done, err := fn(n)
+				if err != nil || done {
+					return err
+				}
+			}
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runSession executes a commit or a get and passes the notifications of its session to fn until the
+// operation is completed. The operation is cancelled if the call is.
+func (s *grpcServer) runSession(ctx context.Context, cmd *Command, op string, fn func(Notify) error) error {
+	var token string
+	err := s.run(ctx, cmd, func(n Notify) (bool, error) {
+		if token == "" {
+			if sr := n.SessionResult; sr != nil && sr.Op == op && !sr.Done {
+				token = sr.Token
+			}
+			return false, nil
+		}
+		if n.Session != token {
+			return false, nil
+		}
+		if sr := n.SessionResult; sr != nil {
+			return sr.Done, nil
+		}
+		return false, fn(n)
+	})
+	// A client going away aborts the operation instead of leaving it running in the background
+	if ctx.Err() != nil && token != "" {
+		s.nd.Cancel(s.ctx, &CancelArgs{Token: token})
+	}
+	return err
+}
+
+// unary decodes the arguments of a unary call and executes it with the interceptor if any
+func unary(ctx context.Context, srv interface{}, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor,
+	method string, args interface{}, fn func(*grpcServer, context.Context, interface{}) (interface{}, error)) (interface{}, error) {
+	if err := dec(args); err != nil {
+		return nil, err
+	}
+	s := srv.(*grpcServer)
+	if interceptor == nil {
+		return fn(s, ctx, args)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + GRPCService + "/" + method,
+	}
+	return interceptor(ctx, args, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return fn(s, ctx, req)
+	})
+}
+
+func grpcStatusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "Status", new(StatusArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		var res *StatusResult
+		err := s.run(ctx, &Command{Status: args.(*StatusArgs)}, func(n Notify) (bool, error) {
+			if sr := n.StatusResult; sr != nil {
+				if sr.Err != "" {
+					return true, status.Error(codes.FailedPrecondition, sr.Err)
+				}
+				res = sr
+				return true, nil
+			}
+			return false, nil
+		})
+		return res, err
+	})
+}
+
+func grpcStatsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "Stats", new(StatsArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		var res *StatsResult
+		err := s.run(ctx, &Command{Stats: args.(*StatsArgs)}, func(n Notify) (bool, error) {
+			if sr := n.StatsResult; sr != nil {
+				if sr.Err != "" {
+					return true, status.Error(codes.NotFound, sr.Err)
+				}
+				res = sr
+				return true, nil
+			}
+			return false, nil
+		})
+		return res, err
+	})
+}
+
+func grpcConfirmHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "Confirm", new(ConfirmArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		s.nd.Confirm(ctx, args.(*ConfirmArgs))
+		return &ConfirmResult{}, nil
+	})
+}
+
+func grpcWalletListHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "WalletList", new(WalletArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		return s.walletResult(ctx)
+	})
+}
+
+func grpcWalletNewHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "WalletNew", new(WalletArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		if _, err := s.nd.exch.Wallet().NewKey(ctx, wallet.KTSecp256k1); err != nil {
+			return nil, err
+		}
+		return s.walletResult(ctx)
+	})
+}
+
+func grpcWalletSetDefaultHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return unary(ctx, srv, dec, interceptor, "WalletSetDefault", new(WalletArgs), func(s *grpcServer, ctx context.Context, args interface{}) (interface{}, error) {
+		addr, err := address.NewFromString(args.(*WalletArgs).Address)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := s.nd.exch.Wallet().SetDefaultAddress(addr); err != nil {
+			return nil, err
+		}
+		return s.walletResult(ctx)
+	})
+}
+
+// walletResult lists the addresses of the wallet with their balance if we are connected to Filecoin
+func (s *grpcServer) walletResult(ctx context.Context) (*WalletResult, error) {
+	w := s.nd.exch.Wallet()
+	addrs, err := w.List()
+	if err != nil {
+		return nil, err
+	}
+	res := &WalletResult{}
+	for _, addr := range addrs {
+		wa := WalletAddress{
+			Address: addr.String(),
+			Default: addr == w.DefaultAddress(),
+		}
+		if s.nd.exch.IsFilecoinOnline() {
+			bal, err := w.Balance(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			wa.Balance = bal.String()
+		}
+		res.Addresses = append(res.Addresses, wa)
+	}
+	return res, nil
+}
+
+func grpcPutHandler(srv interface{}, stream grpc.ServerStream) error {
+	args := new(PutArgs)
+	if err := stream.RecvMsg(args); err != nil {
+		return err
+	}
+	return srv.(*grpcServer).put(stream.Context(), args, func(pr *PutResult) error {
+		return stream.SendMsg(pr)
+	})
+}
+
+// put adds a file or directory to the transaction and passes every result to fn until the root is added
+func (s *grpcServer) put(ctx context.Context, args *PutArgs, fn func(*PutResult) error) error {
+	return s.run(ctx, &Command{Put: args}, func(n Notify) (bool, error) {
+		pr := n.PutResult
+		if pr == nil {
+			return false, nil
+		}
+		if pr.Err != "" {
+			return true, errors.New(pr.Err)
+		}
+		if err := fn(pr); err != nil {
+			return true, err
+		}
+		// Files of a directory are reported before the root
+		return pr.Root != "", nil
+	})
+}
+
+func grpcCommitHandler(srv interface{}, stream grpc.ServerStream) error {
+	args := new(CommArgs)
+	if err := stream.RecvMsg(args); err != nil {
+		return err
+	}
+	return srv.(*grpcServer).commit(stream.Context(), args, func(cr *CommResult) error {
+		return stream.SendMsg(cr)
+	})
+}
+
+// commit commits the transaction and passes the results of the commit to fn until it is completed
+func (s *grpcServer) commit(ctx context.Context, args *CommArgs, fn func(*CommResult) error) error {
+	return s.runSession(ctx, &Command{Commit: args}, "commit", func(n Notify) error {
+		cr := n.CommResult
+		if cr == nil {
+			return nil
+		}
+		if cr.Err != "" {
+			return errors.New(cr.Err)
+		}
+		return fn(cr)
+	})
+}
+
+func grpcQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	args := new(GetArgs)
+	if err := stream.RecvMsg(args); err != nil {
+		return err
+	}
+	return srv.(*grpcServer).query(stream.Context(), args, func(gr *GetResult) error {
+		return stream.SendMsg(gr)
+	})
+}
+
+// query retrieves content and passes the results of the retrieval to fn until it is completed.
+// Retrievals waiting for a confirmation are confirmed with the Confirm method.
+func (s *grpcServer) query(ctx context.Context, args *GetArgs, fn func(*GetResult) error) error {
+	return s.runSession(ctx, &Command{Get: args}, "get", func(n Notify) error {
+		gr := n.GetResult
+		if gr == nil {
+			return nil
+		}
+		if gr.Err != "" {
+			return errors.New(gr.Err)
+		}
+		return fn(gr)
+	})
+}
+
+func grpcListRefsHandler(srv interface{}, stream grpc.ServerStream) error {
+	args := new(ListArgs)
+	if err := stream.RecvMsg(args); err != nil {
+		return err
+	}
+	return srv.(*grpcServer).run(stream.Context(), &Command{List: args}, func(n Notify) (bool, error) {
+		lr := n.ListResult
+		if lr == nil {
+			return false, nil
+		}
+		if lr.Err != "" {
+			return true, status.Error(codes.NotFound, lr.Err)
+		}
+		if err := stream.SendMsg(lr); err != nil {
+			return true, err
+		}
+		return lr.Last, nil
+	})
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	nd.exch.FilecoinAPI().(*filecoin.MockLotusAPI).SetActorState(&filecoin.ActorState{
+		Balance: filecoin.NewInt(1000),
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := newGRPCServer(ctx, nd, "secret")
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn, err := grpc.DialContext(ctx, l.Addr().String(),
+		grpc.WithInsecure(),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(GRPCCodec)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Calls without the token are rejected
+	err = conn.Invoke(ctx, "/pop.Node/Status", &StatusArgs{}, &StatusResult{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	stream, err := conn.NewStream(ctx, &grpcServiceDesc.Streams[3], "/pop.Node/ListRefs")
+	require.NoError(t, err)
+	// The stream may already be closed in which case the status is returned by RecvMsg
+	_ = stream.SendMsg(&ListArgs{})
+	require.Equal(t, codes.Unauthenticated, status.Code(stream.RecvMsg(&ListResult{})))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	// No transaction yet
+	err = conn.Invoke(ctx, "/pop.Node/Status", &StatusArgs{}, &StatusResult{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	data := make([]byte, 256000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	file, err := ioutil.TempFile(t.TempDir(), "data")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	require.NoError(t, err)
+
+	// Progress is streamed until the file is added
+	stream, err = conn.NewStream(ctx, &grpcServiceDesc.Streams[0], "/pop.Node/Put")
+	require.NoError(t, err)
+	require.NoError(t, stream.SendMsg(&PutArgs{Path: file.Name(), ChunkSize: 1024}))
+	require.NoError(t, stream.CloseSend())
+	var pr PutResult
+	require.NoError(t, stream.RecvMsg(&pr))
+	require.NotEmpty(t, pr.Root)
+	require.Equal(t, io.EOF, stream.RecvMsg(&pr))
+
+	var sr StatusResult
+	require.NoError(t, conn.Invoke(ctx, "/pop.Node/Status", &StatusArgs{}, &sr))
+	require.Equal(t, pr.Root, sr.RootCid)
+
+	var wr WalletResult
+	require.NoError(t, conn.Invoke(ctx, "/pop.Node/WalletList", &WalletArgs{}, &wr))
+	require.Len(t, wr.Addresses, 1)
+	require.True(t, wr.Addresses[0].Default)
+	require.Equal(t, "1000", wr.Addresses[0].Balance)
+
+	require.NoError(t, conn.Invoke(ctx, "/pop.Node/WalletNew", &WalletArgs{}, &wr))
+	require.Len(t, wr.Addresses, 2)
+
+	err = conn.Invoke(ctx, "/pop.Node/WalletSetDefault", &WalletArgs{Address: "nope"}, &wr)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	nd.notify = func(Notify) {}
+	s := &grpcServer{
+		ctx: ctx,
+		nd:  nd,
+		cs:  NewCommandServer(nd, nil),
+	}
+
+	// Results sent to other clients don't answer the call
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				nd.send(Notify{StatusResult: &StatusResult{RootCid: "other"}})
+			}
+		}
+	}()
+	var res *StatusResult
+	err := s.run(ctx, &Command{Status: &StatusArgs{}}, func(n Notify) (bool, error) {
+		if sr := n.StatusResult; sr != nil {
+			res = sr
+			return true, nil
+		}
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, res.RootCid)
+	require.Equal(t, "no pending transaction", res.Err)
+}
@@ -0,0 +1,217 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// CachePolicy defines how long content served over HTTP is considered fresh before
+// we check again that we can still provide it. Content under a root never changes so
+// stale or expired never means outdated, only that we haven't checked recently that all
+// the blocks of the root are still in our stores. Stale content is served while the check
+// runs in the background, expired content is only served once the check passed and a root
+// failing it is dropped from the index.
+type CachePolicy struct {
+	// MaxAge is the duration after validation during which content is fresh
+	MaxAge time.Duration
+	// StaleWhileRevalidate is the window after MaxAge during which stale content is still served
+	// immediately while we revalidate it in the background
+	StaleWhileRevalidate time.Duration
+}
+
+// DefaultCachePolicy is used when no policy is provided in the node options
+var DefaultCachePolicy = CachePolicy{
+	MaxAge:               time.Hour,
+	StaleWhileRevalidate: 24 * time.Hour,
+}
+
+// nearExpiry is the fraction of MaxAge after which fresh content is revalidated in the background
+const nearExpiry = 0.9
+
+// revalidateTimeout bounds the time spent revalidating a root or fetching it again
+const revalidateTimeout = 2 * time.Minute
+
+// cacheState describes the lifecycle state of a root in the local cache
+type cacheState int
+
+const (
+	// cacheMiss means we don't have the content locally
+	cacheMiss cacheState = iota
+	// cacheFresh content can be served without any validation
+	cacheFresh
+	// cacheNearExpiry content is still fresh but should be revalidated in the background
+	cacheNearExpiry
+	// cacheStale content can be served while we revalidate in the background
+	cacheStale
+	// cacheExpired content must be revalidated before being served
+	cacheExpired
+)
+
+var cacheStates = map[cacheState]string{
+	cacheMiss:       "MISS",
+	cacheFresh:      "HIT",
+	cacheNearExpiry: "HIT",
+	cacheStale:      "STALE",
+	cacheExpired:    "EXPIRED",
+}
+
+// flight is a revalidation shared by all the requests for the same root
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// lifecycle keeps track of when cached roots were last validated and of the roots being read so their
+// content isn't dropped while it is served
+type lifecycle struct {
+	policy CachePolicy
+
+	mu        sync.Mutex
+	validated map[cid.Cid]time.Time
+	flights   map[string]*flight
+	reads     map[cid.Cid]int
+	idle      map[cid.Cid]chan struct{}
+}
+
+func newLifecycle(policy CachePolicy) *lifecycle {
+	if policy.MaxAge == 0 {
+		policy = DefaultCachePolicy
+	}
+	return &lifecycle{
+		policy:    policy,
+		validated: make(map[cid.Cid]time.Time),
+		flights:   make(map[string]*flight),
+		reads:     make(map[cid.Cid]int),
+		idle:      make(map[cid.Cid]chan struct{}),
+	}
+}
+
+// state returns the lifecycle state of a cached root and the time since it was last validated.
+// Content we never validated since starting the node is served as near expiry so it is checked in
+// the background without being reported stale.
+func (lc *lifecycle) state(k cid.Cid, now time.Time) (cacheState, time.Duration) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	at, ok := lc.validated[k]
+	if !ok {
+		return cacheNearExpiry, time.Duration(float64(lc.policy.MaxAge) * nearExpiry)
+	}
+	age := now.Sub(at)
+	switch {
+	case age < time.Duration(float64(lc.policy.MaxAge)*nearExpiry):
+		return cacheFresh, age
+	case age < lc.policy.MaxAge:
+		return cacheNearExpiry, age
+	case age < lc.policy.MaxAge+lc.policy.StaleWhileRevalidate:
+		return cacheStale, age
+	default:
+		return cacheExpired, age
+	}
+}
+
+// validate records the given root as validated at the given time
+func (lc *lifecycle) validate(k cid.Cid, at time.Time) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.validated[k] = at
+}
+
+// do runs fn once for all the concurrent calls with the same key and returns its error. fn gets a context
+// bounded by the revalidation timeout which isn't cancelled with the context of the callers so a request
+// going away doesn't fail the others waiting for the same result.
+func (lc *lifecycle) do(ctx context.Context, key string, fn func(context.Context) error) error {
+	lc.mu.Lock()
+	f, ok := lc.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		lc.flights[key] = f
+		go func() {
+			fctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+			defer cancel()
+			f.err = fn(fctx)
+			lc.mu.Lock()
+			delete(lc.flights, key)
+			lc.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	lc.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read registers a response serving the content of a root and returns a function to call once
+// it is done
+func (lc *lifecycle) read(k cid.Cid) func() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.reads[k]++
+	return func() {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		lc.reads[k]--
+		if lc.reads[k] > 0 {
+			return
+		}
+		delete(lc.reads, k)
+		if idle, ok := lc.idle[k]; ok {
+			close(idle)
+			delete(lc.idle, k)
+		}
+	}
+}
+
+// dropWhenIdle waits until no response reads a root anymore then drops it. No read can start while
+// the root is dropped.
+func (lc *lifecycle) dropWhenIdle(ctx context.Context, k cid.Cid, drop func() error) error {
+	for {
+		lc.mu.Lock()
+		if lc.reads[k] == 0 {
+			defer lc.mu.Unlock()
+			delete(lc.validated, k)
+			return drop()
+		}
+		idle, ok := lc.idle[k]
+		if !ok {
+			idle = make(chan struct{})
+			lc.idle[k] = idle
+		}
+		lc.mu.Unlock()
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setHeaders sets the cache headers reflecting the lifecycle state of the content
+func (lc *lifecycle) setHeaders(w http.ResponseWriter, state cacheState, age time.Duration) {
+	swr := int(lc.policy.StaleWhileRevalidate.Seconds())
+	switch state {
+	case cacheStale:
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=0, stale-while-revalidate=%d", swr))
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	default:
+		remaining := lc.policy.MaxAge - age
+		if state == cacheMiss || state == cacheExpired || remaining < 0 {
+			// content was just validated
+			remaining = lc.policy.MaxAge
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf(
+			"public, max-age=%d, stale-while-revalidate=%d",
+			int(remaining.Seconds()),
+			swr,
+		))
+	}
+	w.Header().Set("X-Cache", cacheStates[state])
+}
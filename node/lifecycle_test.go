@@ -0,0 +1,105 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycleState(t *testing.T) {
+	bgen := blocksutil.NewBlockGenerator()
+	lc := newLifecycle(CachePolicy{
+		MaxAge:               10 * time.Minute,
+		StaleWhileRevalidate: time.Hour,
+	})
+	root := bgen.Next().Cid()
+	now := time.Now()
+
+	// We never validated this root since starting so it is checked without being reported stale
+	state, _ := lc.state(root, now)
+	require.Equal(t, cacheNearExpiry, state)
+
+	lc.validate(root, now)
+	state, age := lc.state(root, now.Add(time.Minute))
+	require.Equal(t, cacheFresh, state)
+	require.Equal(t, time.Minute, age)
+
+	state, _ = lc.state(root, now.Add(9*time.Minute+30*time.Second))
+	require.Equal(t, cacheNearExpiry, state)
+
+	state, _ = lc.state(root, now.Add(30*time.Minute))
+	require.Equal(t, cacheStale, state)
+
+	state, _ = lc.state(root, now.Add(2*time.Hour))
+	require.Equal(t, cacheExpired, state)
+
+	w := httptest.NewRecorder()
+	lc.setHeaders(w, cacheStale, 30*time.Minute)
+	require.Equal(t, "public, max-age=0, stale-while-revalidate=3600", w.Header().Get("Cache-Control"))
+	require.Equal(t, "STALE", w.Header().Get("X-Cache"))
+
+	w = httptest.NewRecorder()
+	lc.setHeaders(w, cacheFresh, time.Minute)
+	require.Equal(t, "public, max-age=540, stale-while-revalidate=3600", w.Header().Get("Cache-Control"))
+}
+
+func TestLifecycleRevalidation(t *testing.T) {
+	ctx := context.Background()
+	bgen := blocksutil.NewBlockGenerator()
+	lc := newLifecycle(DefaultCachePolicy)
+	root := bgen.Next().Cid()
+
+	// Concurrent revalidations of a root share a single run
+	var runs int32
+	unblock := make(chan struct{})
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- lc.do(ctx, root.String(), func(ctx context.Context) error {
+				atomic.AddInt32(&runs, 1)
+				<-unblock
+				return errors.New("missing blocks")
+			})
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	for i := 0; i < 3; i++ {
+		require.Error(t, <-errs)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	// Content isn't dropped while it is read
+	release := lc.read(root)
+	dropped := make(chan struct{})
+	go func() {
+		require.NoError(t, lc.dropWhenIdle(ctx, root, func() error {
+			close(dropped)
+			return nil
+		}))
+	}()
+	select {
+	case <-dropped:
+		t.Fatal("dropped content being read")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-dropped:
+	case <-time.After(time.Second):
+		t.Fatal("content not dropped after the read")
+	}
+
+	// Waiting for the reads is bounded by the context
+	release = lc.read(root)
+	defer release()
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.Error(t, lc.dropWhenIdle(cctx, root, func() error { return nil }))
+}
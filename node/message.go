@@ -23,6 +23,19 @@ type PingArgs struct {
 type PutArgs struct {
 	Path      string
 	ChunkSize int
+	Recursive bool
+	Include   []string
+	Exclude   []string
+	// Chunker is a go-ipfs chunker string such as size-262144, rabin or buzhash or ipfs to use the same
+	// chunker as ipfs add. Files are split in chunks of ChunkSize if empty.
+	Chunker string
+	// Layout is balanced or trickle
+	Layout string
+	// RawLeaves and CidVersion keep the chunker defaults if nil
+	RawLeaves  *bool
+	CidVersion *int
+	// Hash is the name of the multihash function of CIDv1 blocks such as sha2-256
+	Hash string
 }
 
 // StatusArgs get passed to the Status command
@@ -30,6 +43,12 @@ type StatusArgs struct {
 	Verbose bool
 }
 
+// UnstageArgs get passed to the Unstage command
+type UnstageArgs struct {
+	// Keys are the names of the entries to remove from the ongoing transaction
+	Keys []string
+}
+
 // QuoteArgs are passed to the quote command
 type QuoteArgs struct {
 	Ref       string
@@ -46,34 +65,297 @@ type CommArgs struct {
 	StorageRF int // StorageRF if the replication factor for storage
 	Duration  time.Duration
 	Miners    map[string]bool
+	// Labels are saved with the ref to filter the list of content
+	Labels map[string]string
+	// Keys only commits the given entries of the transaction if not empty. The other entries stay
+	// staged for a later commit.
+	Keys []string
+	// Timeout aborts the commit if it isn't completed in time, 0 means no timeout
+	Timeout time.Duration
+	// IPNS is the keystore key the root is published under if not empty, self is the node identity
+	IPNS string
+	// Uplink is the number of bytes per second the dispatch may use, 0 is unlimited
+	Uplink uint64
+	// Deadline is how long the providers have to receive the content with the Uplink budget
+	Deadline time.Duration
 }
 
 // GetArgs get passed to the Get command
 type GetArgs struct {
-	Cid      string
-	Key      string
-	Sel      string
-	Out      string
+	Cid string
+	Key string
+	Sel string
+	Out string
+	// Timeout is the number of minutes after which the retrieval is aborted, 0 means no timeout
 	Timeout  int
 	Verbose  bool
 	Miner    string
 	Strategy string
+	// ConfirmAbove is an amount in FIL above which the estimated cost of the retrieval must be
+	// confirmed by the client before accepting the offer
+	ConfirmAbove string
+	// MaxPrice is an amount in FIL above which the estimated cost of an offer is declined
+	MaxPrice string
+	// Delegate is the p2p address of a trusted pop retrieving and paying for the content on our behalf
+	Delegate string
+}
+
+// ConfirmArgs answers a request for confirming the cost of a retrieval
+type ConfirmArgs struct {
+	Cid    string
+	Accept bool
 }
 
 // ListArgs provides params for the List command
 type ListArgs struct {
 	Page int // potential pagination as the amount may be very large
+	// Labels only lists the refs with all the given labels formatted as key=value
+	Labels []string
+}
+
+// PeersArgs provides params for the Peers command
+type PeersArgs struct{}
+
+// PublishersArgs provides params for the Publishers command
+type PublishersArgs struct{}
+
+// CategoriesArgs provides params for the Categories command
+type CategoriesArgs struct{}
+
+// LeaseArgs provides params for purchasing a storage lease from a provider
+type LeaseArgs struct {
+	Cid      string
+	Peer     string
+	Duration string
+}
+
+// LeasesArgs provides params for listing the content leased on this node
+type LeasesArgs struct{}
+
+// SearchArgs provides params for searching content by entry name or publisher
+type SearchArgs struct {
+	Query string
+}
+
+// DemandHint is the weight of the external demand for a root
+type DemandHint struct {
+	Cid    string
+	Weight int64
+}
+
+// WarmArgs provides a batch of demand hints from an external system to steer the cache
+type WarmArgs struct {
+	Hints []DemandHint
+}
+
+// ImportArgs provides params for importing a CAR archive into the current transaction
+type ImportArgs struct {
+	Path string
+}
+
+// ExportArgs provides params for writing a CAR archive of content we store
+type ExportArgs struct {
+	Cid string
+	// Key selects a single entry of the root if not empty
+	Key  string
+	Path string
+}
+
+// IndexExportArgs provides params for writing a snapshot of the index to a file
+type IndexExportArgs struct {
+	Path string
+	// Demand only writes the interest list and the popularity of the roots
+	Demand bool
+}
+
+// IndexImportArgs provides params for loading the refs from an index snapshot file
+type IndexImportArgs struct {
+	Path string
+	// Demand loads a demand snapshot instead of an index snapshot
+	Demand bool
+	// Weight multiplies the frequencies of a demand snapshot, 0 applies them as is
+	Weight float64
+}
+
+// GCArgs provides params for deleting the stores no ref points to
+type GCArgs struct {
+	// DryRun reports the stores without deleting them
+	DryRun bool
+}
+
+// UsageArgs asks for the gateway usage of each tenant
+type UsageArgs struct{}
+
+// TrackArgs provides params for following the roots a mutable name points to
+type TrackArgs struct {
+	// Name is the IPNS or DNSLink name to track, all tracked names are listed if empty
+	Name string
+	// Untrack stops following the name
+	Untrack bool
+}
+
+// AttachArgs provides params for reattaching to an operation after a client disconnected
+type AttachArgs struct {
+	// Token is the session token sent when the operation started
+	Token string
+}
+
+// CancelArgs provides params for aborting an operation when its client is interrupted
+type CancelArgs struct {
+	// Token is the session token sent when the operation started
+	Token string
+}
+
+// InspectArgs provides params for the Inspect command
+type InspectArgs struct {
+	Cid string
+}
+
+// RecordArgs provides params for recording the events of the node to a log file
+type RecordArgs struct {
+	Path string
+	// Window is how long events are recorded for
+	Window time.Duration
+	// Stop ends the running recording
+	Stop bool
+}
+
+// AuditArgs provides params for querying, exporting or verifying the log of the retrievals we served
+type AuditArgs struct {
+	Peer string
+	Root string
+	// Since only selects the retrievals which ended within this duration
+	Since time.Duration
+	Limit int
+	// Export writes the whole log as JSON lines to the path instead of returning entries
+	Export string
+	// Verify checks the hash chain of the log
+	Verify bool
+}
+
+// ReceiptsArgs provides params for querying or exporting the receipts of the retrievals we paid for
+type ReceiptsArgs struct {
+	Provider string
+	Root     string
+	// Since only selects the retrievals which completed within this duration
+	Since time.Duration
+	Limit int
+	// Export writes the matching receipts as JSON lines to the path instead of returning them
+	Export string
+}
+
+// TagArgs provides params for naming a root, listing or deleting tags
+type TagArgs struct {
+	Name string
+	// Root is the CID or tag the name points to
+	Root string
+	// Expect only moves the tag if it still points to this root
+	Expect string
+	Delete bool
+	List   bool
+}
+
+// ACLArgs provides params for showing, setting, deleting or listing the access rules of refs
+type ACLArgs struct {
+	// Root is the CID or tag of the ref
+	Root string
+	// Visibility is public or private, the rules are only shown if it is empty and no peers are given
+	Visibility string
+	// Allow replaces the peers allowed to retrieve the ref if it is private when not empty
+	Allow []string
+	// Deny replaces the peers denied from retrieving the ref when not empty
+	Deny   []string
+	Delete bool
+	List   bool
+}
+
+// StatsArgs provides params for getting the access statistics of a root
+type StatsArgs struct {
+	// Root is the CID or tag of the content
+	Root string
+	// Hours and Days are the number of hourly and daily buckets to return, 0 returns the default
+	Hours int
+	Days  int
+}
+
+// BandwidthArgs provides params for getting the bytes sent and received by the node
+type BandwidthArgs struct {
+	// Top is the number of peers and refs listed, the ones which transferred the most first. 0 lists the
+	// default number.
+	Top int
+}
+
+// ProbesArgs provides params for getting the health of the network measured by the prober
+type ProbesArgs struct {
+	// Run probes a canary right away before reporting
+	Run bool
+}
+
+// SnapshotsArgs provides params for listing the collections we publish snapshots of
+type SnapshotsArgs struct {
+	// Run exports and publishes a snapshot of the named collection right away
+	Run string
+}
+
+// ConfigArgs provides params for changing a setting of the running node
+type ConfigArgs struct {
+	Key   string
+	Value string
+	// Reload applies the changes of the config file instead of a single key
+	Reload bool
+}
+
+// LogArgs provides params for changing the log level of a subsystem of the running node
+type LogArgs struct {
+	// Module is the name of the subsystem or all, the levels are only listed if empty
+	Module string
+	Level  string
 }
 
+// ChannelsArgs provides params for listing our payment channels
+type ChannelsArgs struct{}
+
 // Command is a message sent from a client to the daemon
 type Command struct {
-	Ping   *PingArgs
-	Put    *PutArgs
-	Status *StatusArgs
-	Quote  *QuoteArgs
-	Commit *CommArgs
-	Get    *GetArgs
-	List   *ListArgs
+	Ping    *PingArgs
+	Put     *PutArgs
+	Status  *StatusArgs
+	Quote   *QuoteArgs
+	Commit  *CommArgs
+	Get     *GetArgs
+	Confirm *ConfirmArgs
+	List    *ListArgs
+	Peers   *PeersArgs
+	Lease   *LeaseArgs
+	Leases  *LeasesArgs
+	Search  *SearchArgs
+	Warm    *WarmArgs
+	Import  *ImportArgs
+	Export  *ExportArgs
+	GC      *GCArgs
+	Usage   *UsageArgs
+	Track   *TrackArgs
+	Attach  *AttachArgs
+	Inspect *InspectArgs
+
+	Publishers  *PublishersArgs
+	Categories  *CategoriesArgs
+	Record      *RecordArgs
+	IndexExport *IndexExportArgs
+	IndexImport *IndexImportArgs
+	Audit       *AuditArgs
+	Receipts    *ReceiptsArgs
+	Unstage     *UnstageArgs
+	Tag         *TagArgs
+	Cancel      *CancelArgs
+	Channels    *ChannelsArgs
+	Config      *ConfigArgs
+	ACL         *ACLArgs
+	Stats       *StatsArgs
+	Probes      *ProbesArgs
+	Snapshots   *SnapshotsArgs
+	Log         *LogArgs
+	Bandwidth   *BandwidthArgs
 }
 
 // PingResult is sent in the notify message to give us the info we requested
@@ -83,11 +365,16 @@ type PingResult struct {
 	Peers          []string // Peers currently connected to the node (local daemon only)
 	LatencySeconds float64
 	Version        string // The Version the node is running
-	Err            string
+	// PublisherID and PublisherAddress are the peer ID and Filecoin address publishing our content
+	// when they are separate from the host (local daemon only)
+	PublisherID      string
+	PublisherAddress string
+	Err              string
 }
 
 // PutResult gives us feedback on the result of the Put request
 type PutResult struct {
+	Key       string // Key is set when reporting progress for a single file of a directory
 	Cid       string
 	Size      string
 	NumBlocks int
@@ -99,7 +386,13 @@ type PutResult struct {
 type StatusResult struct {
 	RootCid string
 	Entries string
-	Err     string
+	// Deduplicated is the size of the staged content which was already stored
+	Deduplicated string
+	// Reachability is whether other peers can dial us as detected by AutoNAT: Public, Private or Unknown
+	Reachability string
+	// RelayAddrs are the addresses we advertise through a circuit relay if we aren't publicly reachable
+	RelayAddrs []string
+	Err        string
 }
 
 // QuoteResult returns the output of the Quote request
@@ -114,7 +407,11 @@ type CommResult struct {
 	Miners []string
 	Deals  []string
 	Caches []string
-	Err    string
+	// IPNS is the name the root was published under
+	IPNS string
+	Err  string
+	// Code identifies the exchange error if any
+	Code string
 }
 
 // GetResult gives us feedback on the result of the Get request
@@ -125,10 +422,27 @@ type GetResult struct {
 	PieceSize       string
 	PricePerByte    string
 	UnsealPrice     string
+	GasPrice        string // maximum fee for creating or funding the payment channel
+	EstimatedTotal  string // total cost we expect including gas
+	NeedsConfirm    bool   // set when the estimated total requires confirmation
 	DiscLatSeconds  float64
 	TransLatSeconds float64
 	Local           bool
 	Err             string
+	// Code identifies the exchange error if any
+	Code string
+	// Progress is set while the blocks are being transferred
+	Progress *GetProgress
+}
+
+// GetProgress reports the state of an ongoing retrieval
+type GetProgress struct {
+	Received uint64
+	Size     uint64
+	Blocks   int
+	Vouchers int
+	Provider string
+	Spent    string
 }
 
 // ListResult contains the result for a single item of the list
@@ -136,12 +450,377 @@ type ListResult struct {
 	Root string
 	Freq int64
 	Size int64
+	// Verified is true if the content was published with a manifest signed by enough publishers
+	Verified bool
+	Labels   map[string]string
+	BucketID int64
+	StoreID  uint64
+	// Created is the unix time at which the content was first indexed
+	Created int64
+	Last    bool
+	Err     string
+}
+
+// PeersResult contains the state and reputation of a single peer
+type PeersResult struct {
+	ID         string
+	Score      float64
+	Successes  uint64
+	Failures   uint64
+	Disputes   uint64
+	Throughput string
+	// Tracked is true if the peer manager tracks the peer, the fields below are only set for tracked peers
+	Tracked        bool
+	Regions        []string
+	Protocols      []string
+	LatencySeconds float64
+	// Responsiveness is the ratio of greetings, queries and requests the peer answered
+	Responsiveness float64
+	Responses      uint64
+	Unanswered     uint64
+	// Strikes is the number of requests the peer failed to answer since its last response
+	Strikes  int
+	LastSeen time.Time
+	Last     bool
+	Err      string
+}
+
+// PublishersResult contains the cache usage of a single publisher
+type PublishersResult struct {
+	ID   string
+	Refs int
+	Size string
+	// Share is the fraction of the publisher cap used by its content
+	Share float64
+	Last  bool
+	Err   string
+}
+
+// CategoriesResult contains the cache usage of a single category of content
+type CategoriesResult struct {
+	Category string
+	Refs     int
+	Size     string
+	// Quota is the fraction of the capacity the category can use
+	Quota float64
+	// Share is the fraction of the quota used by the content of the category
+	Share float64
+	Last  bool
+	Err   string
+}
+
+// LeaseResult gives us the expiry of a lease we purchased or of a single lease held by this node
+type LeaseResult struct {
+	Root   string
+	Peer   string
+	Size   int64
+	Expiry string
+	Last   bool
+	Err    string
+	// Code identifies the exchange error if any
+	Code string
+}
+
+// SearchResult contains a single root matching a search query
+type SearchResult struct {
+	Root string
+	Size int64
+	// Keys are the entries with a name matching the query
+	Keys []string
+	// Cached is true if the content is stored on this node
+	Cached bool
+	Last   bool
+	Err    string
+}
+
+// WarmResult reports how many demand hints were applied to stored content and to the interest list
+type WarmResult struct {
+	Cached   int
+	Interest int
+	Err      string
+	// Code identifies the exchange error if any
+	Code string
+}
+
+// ImportResult gives us the roots of an imported CAR archive
+type ImportResult struct {
+	// Roots are the roots of the archive added as entries of the transaction
+	Roots []string
+	Size  string
+	// Root is the new root of the transaction
+	Root string
+	Err  string
+}
+
+// ExportResult gives us the location of an exported CAR archive
+type ExportResult struct {
+	Path string
+	Size string
+	Err  string
+}
+
+// IndexResult reports the number of refs in the index after exporting or importing a snapshot
+type IndexResult struct {
+	Path string
+	// Refs is the number of roots stored in the index
+	Refs int
+	// Interest is the number of roots in the interest list
+	Interest int
+	Err      string
+}
+
+// GCResult reports the stores deleted by the garbage collection
+type GCResult struct {
+	Stores    int
+	Reclaimed string
+	DryRun    bool
+	Err       string
+}
+
+// UsageResult reports the gateway usage of each tenant during the current quota period
+type UsageResult struct {
+	Tenants []TenantUsage
+	Err     string
+}
+
+// NameBinding is a tracked name and the root it pointed to when it was last checked
+type NameBinding struct {
+	Name    string
+	Root    string
+	Checked time.Time
+}
+
+// TrackResult lists the tracked names and their current root
+type TrackResult struct {
+	Names []NameBinding
+	Err   string
+}
+
+// SessionResult is sent when a commit or a get starts, when a client reattaches and when the operation is done
+type SessionResult struct {
+	// Token identifies the session to reattach to it
+	Token string
+	// Op is the operation running in the session: commit or get
+	Op string
+	// Ref is the root or the reference the operation is about
+	Ref  string
+	Done bool
+	Err  string
+}
+
+// InspectEntry describes the DAG of an entry under the inspected root
+type InspectEntry struct {
+	Key    string
+	Cid    string
+	Size   int
+	Blocks int
+}
+
+// InspectResult describes a ref and the structure of its DAG
+type InspectResult struct {
+	Root      string
+	Size      int64
+	Freq      int64
+	BucketID  int64
+	StoreID   uint64
+	Created   int64
+	Publisher string
+	Labels    map[string]string
+	// DAGSize and Blocks are measured from the blocks in the store
+	DAGSize int
+	Blocks  int
+	Entries []InspectEntry
+	Err     string
+}
+
+// RecordResult describes the running recording
+type RecordResult struct {
+	Path string
+	// Until is when the recording ends, zero if no recording is running
+	Until time.Time
+	Err   string
+}
+
+// ReceiptsResult is a receipt of a retrieval we paid for or the summary of an export
+type ReceiptsResult struct {
+	Time     time.Time
+	Provider string
+	Root     string
+	Deal     uint64
+	Size     string
+	Paid     string
+	Vouchers int
+	// Len is the number of receipts exported to Path
+	Len  int
+	Path string
 	Last bool
 	Err  string
 }
 
+// AuditResult is an entry of the audit log or the summary of an export or verification
+type AuditResult struct {
+	Seq      uint64
+	Time     time.Time
+	Peer     string
+	Root     string
+	Deal     uint64
+	Size     string
+	Paid     string
+	Vouchers int
+	Status   string
+	Hash     string
+	// Len and Head are the number of entries and the hash of the last one when summarizing the log
+	Len  uint64
+	Head string
+	Path string
+	Last bool
+	Err  string
+}
+
+// TagResult is a tag or the result of updating it
+type TagResult struct {
+	Name string
+	Root string
+	Time time.Time
+	// Prev is the root the tag pointed to before being updated or deleted
+	Prev string
+	Last bool
+	Err  string
+}
+
+// ACLResult are the access rules of a ref
+type ACLResult struct {
+	Root       string
+	Visibility string
+	Allow      []string
+	Deny       []string
+	Last       bool
+	Err        string
+}
+
+// StatsBucket counts the reads of a root during an hour or a day
+type StatsBucket struct {
+	Start  time.Time
+	Local  uint64
+	Remote uint64
+}
+
+// StatsResult are the reads of a root, oldest buckets first
+type StatsResult struct {
+	Root string
+	// Stored is true if we store the content and Freq its read frequency in the index
+	Stored bool
+	Freq   int64
+	Hourly []StatsBucket
+	Daily  []StatsBucket
+	Err    string
+}
+
+// BandwidthEntry is the number of bytes sent to and received from a peer or for a ref
+type BandwidthEntry struct {
+	ID       string
+	Sent     uint64
+	Received uint64
+}
+
+// BandwidthResult is the number of bytes sent and received since the node started with the limits in
+// bytes per second, 0 is unlimited
+type BandwidthResult struct {
+	Sent              uint64
+	Received          uint64
+	UploadLimit       uint64
+	DownloadLimit     uint64
+	PeerUploadLimit   uint64
+	PeerDownloadLimit uint64
+	Peers             []BandwidthEntry
+	Refs              []BandwidthEntry
+	Err               string
+}
+
+// ProbeSample is the outcome of retrieving a canary
+type ProbeSample struct {
+	Root     string
+	Provider string
+	Time     time.Time
+	// TTFBSeconds is the time from the start of the transfer to the first bytes received
+	TTFBSeconds float64
+	// Throughput is the number of bytes per second received during the transfer
+	Throughput float64
+	Size       uint64
+	Err        string
+}
+
+// ProbesResult summarizes the recent probes into indicators of the network health
+type ProbesResult struct {
+	Probes      int
+	Failures    int
+	SuccessRate float64
+	// TTFB50Seconds and TTFB90Seconds are the median and 90th percentile time to first byte
+	TTFB50Seconds float64
+	TTFB90Seconds float64
+	// Throughput is the median number of bytes per second received by the probes
+	Throughput float64
+	// Recent are the last probes, newest first
+	Recent []ProbeSample
+	Err    string
+}
+
+// SnapshotsResult is the state of a collection we publish snapshots of
+type SnapshotsResult struct {
+	Collection string
+	// Root is the latest snapshot and Time when it was published
+	Root string
+	Time time.Time
+	// Snapshots is the number of snapshots we still store
+	Snapshots int
+	// LastError is the reason the last export failed if not empty
+	LastError string
+	Last      bool
+	Err       string
+}
+
+// ConfigResult confirms a setting was changed
+type ConfigResult struct {
+	Key   string
+	Value string
+	// Applied are the keys applied to the running pop
+	Applied []string
+	// Restart are the keys which only apply the next time pop starts
+	Restart []string
+	Err     string
+}
+
+// LogResult lists the log level of every subsystem
+type LogResult struct {
+	Levels map[string]string
+	Err    string
+}
+
+// ChannelsResult is the balance of a payment channel
+type ChannelsResult struct {
+	Channel string
+	// Direction is inbound for the channels paying us and outbound for the channels we pay with
+	Direction string
+	// Peer is the Filecoin address on the other end of the channel
+	Peer      string
+	Amount    string
+	Vouchered string
+	// Pending is the value of the vouchers not redeemed on chain yet
+	Pending         string
+	PendingVouchers int
+	Lanes           uint64
+	Settling        bool
+	Last            bool
+	Err             string
+}
+
 // Notify is a message sent from the daemon to the client
 type Notify struct {
+	// Session is the token of the operation the notification belongs to if any
+	Session string
+	// Request is the id of the call the notification answers if the caller set one
+	Request string
+
 	PingResult   *PingResult
 	PutResult    *PutResult
 	StatusResult *StatusResult
@@ -149,6 +828,33 @@ type Notify struct {
 	CommResult   *CommResult
 	GetResult    *GetResult
 	ListResult   *ListResult
+	PeersResult  *PeersResult
+	LeaseResult  *LeaseResult
+	SearchResult *SearchResult
+	WarmResult   *WarmResult
+	ImportResult *ImportResult
+	ExportResult *ExportResult
+	IndexResult  *IndexResult
+	GCResult     *GCResult
+	UsageResult  *UsageResult
+	TrackResult  *TrackResult
+
+	PublishersResult *PublishersResult
+	CategoriesResult *CategoriesResult
+	SessionResult    *SessionResult
+	InspectResult    *InspectResult
+	RecordResult     *RecordResult
+	AuditResult      *AuditResult
+	ReceiptsResult   *ReceiptsResult
+	TagResult        *TagResult
+	ChannelsResult   *ChannelsResult
+	ConfigResult     *ConfigResult
+	ACLResult        *ACLResult
+	StatsResult      *StatsResult
+	ProbesResult     *ProbesResult
+	SnapshotsResult  *SnapshotsResult
+	LogResult        *LogResult
+	BandwidthResult  *BandwidthResult
 }
 
 // CommandServer receives commands on the daemon side and executes them
@@ -195,18 +901,147 @@ func (cs *CommandServer) GotMsg(ctx context.Context, cmd *Command) error {
 	if c := cmd.Commit; c != nil {
 		// push requests are usually quite long so we don't block the thread so users
 		// can start a new transaction while their previous commit is uploading for example
-		go cs.n.Commit(ctx, c)
+		cs.n.runSession(ctx, "commit", c.Ref, func(ctx context.Context) {
+			cs.n.Commit(ctx, c)
+		})
+		return nil
+	}
+	if c := cmd.Confirm; c != nil {
+		cs.n.Confirm(ctx, c)
 		return nil
 	}
 	if c := cmd.Get; c != nil {
 		// Get requests can be quite long and we don't want to block other commands
-		go cs.n.Get(ctx, c)
+		cs.n.runSession(ctx, "get", c.Cid, func(ctx context.Context) {
+			cs.n.Get(ctx, c)
+		})
 		return nil
 	}
 	if c := cmd.List; c != nil {
 		go cs.n.List(ctx, c)
 		return nil
 	}
+	if c := cmd.Peers; c != nil {
+		cs.n.Peers(ctx, c)
+		return nil
+	}
+	if c := cmd.Lease; c != nil {
+		// Paying for a lease may require waiting for a payment channel
+		go cs.n.Lease(ctx, c)
+		return nil
+	}
+	if c := cmd.Leases; c != nil {
+		cs.n.Leases(ctx, c)
+		return nil
+	}
+	if c := cmd.Search; c != nil {
+		cs.n.Search(ctx, c)
+		return nil
+	}
+	if c := cmd.Warm; c != nil {
+		cs.n.Warm(ctx, c)
+		return nil
+	}
+	if c := cmd.Import; c != nil {
+		cs.n.Import(ctx, c)
+		return nil
+	}
+	if c := cmd.Export; c != nil {
+		cs.n.Export(ctx, c)
+		return nil
+	}
+	if c := cmd.Publishers; c != nil {
+		cs.n.Publishers(ctx, c)
+		return nil
+	}
+	if c := cmd.Categories; c != nil {
+		cs.n.Categories(ctx, c)
+		return nil
+	}
+	if c := cmd.IndexExport; c != nil {
+		cs.n.IndexExport(ctx, c)
+		return nil
+	}
+	if c := cmd.IndexImport; c != nil {
+		cs.n.IndexImport(ctx, c)
+		return nil
+	}
+	if c := cmd.GC; c != nil {
+		cs.n.GC(ctx, c)
+		return nil
+	}
+	if c := cmd.Usage; c != nil {
+		cs.n.Usage(ctx, c)
+		return nil
+	}
+	if c := cmd.Track; c != nil {
+		cs.n.Track(ctx, c)
+		return nil
+	}
+	if c := cmd.Attach; c != nil {
+		cs.n.Attach(ctx, c)
+		return nil
+	}
+	if c := cmd.Inspect; c != nil {
+		cs.n.Inspect(ctx, c)
+		return nil
+	}
+	if c := cmd.Record; c != nil {
+		cs.n.Record(ctx, c)
+		return nil
+	}
+	if c := cmd.Audit; c != nil {
+		cs.n.Audit(ctx, c)
+		return nil
+	}
+	if c := cmd.Receipts; c != nil {
+		cs.n.Receipts(ctx, c)
+		return nil
+	}
+	if c := cmd.Channels; c != nil {
+		cs.n.Channels(ctx, c)
+		return nil
+	}
+	if c := cmd.Config; c != nil {
+		cs.n.Config(ctx, c)
+		return nil
+	}
+	if c := cmd.Unstage; c != nil {
+		cs.n.Unstage(ctx, c)
+		return nil
+	}
+	if c := cmd.Tag; c != nil {
+		cs.n.Tag(ctx, c)
+		return nil
+	}
+	if c := cmd.Cancel; c != nil {
+		cs.n.Cancel(ctx, c)
+		return nil
+	}
+	if c := cmd.ACL; c != nil {
+		cs.n.ACL(ctx, c)
+		return nil
+	}
+	if c := cmd.Stats; c != nil {
+		cs.n.Stats(ctx, c)
+		return nil
+	}
+	if c := cmd.Probes; c != nil {
+		cs.n.Probes(ctx, c)
+		return nil
+	}
+	if c := cmd.Snapshots; c != nil {
+		cs.n.Snapshots(ctx, c)
+		return nil
+	}
+	if c := cmd.Log; c != nil {
+		cs.n.Log(ctx, c)
+		return nil
+	}
+	if c := cmd.Bandwidth; c != nil {
+		cs.n.Bandwidth(ctx, c)
+		return nil
+	}
 	return fmt.Errorf("CommandServer: no command specified")
 }
 
@@ -285,10 +1120,134 @@ func (cc *CommandClient) Get(args *GetArgs) {
 	cc.send(Command{Get: args})
 }
 
+func (cc *CommandClient) Confirm(args *ConfirmArgs) {
+	cc.send(Command{Confirm: args})
+}
+
 func (cc *CommandClient) List(args *ListArgs) {
 	cc.send(Command{List: args})
 }
 
+func (cc *CommandClient) Peers(args *PeersArgs) {
+	cc.send(Command{Peers: args})
+}
+
+func (cc *CommandClient) Lease(args *LeaseArgs) {
+	cc.send(Command{Lease: args})
+}
+
+func (cc *CommandClient) Leases(args *LeasesArgs) {
+	cc.send(Command{Leases: args})
+}
+
+func (cc *CommandClient) Search(args *SearchArgs) {
+	cc.send(Command{Search: args})
+}
+
+func (cc *CommandClient) Warm(args *WarmArgs) {
+	cc.send(Command{Warm: args})
+}
+
+func (cc *CommandClient) Import(args *ImportArgs) {
+	cc.send(Command{Import: args})
+}
+
+func (cc *CommandClient) Export(args *ExportArgs) {
+	cc.send(Command{Export: args})
+}
+
+func (cc *CommandClient) Publishers(args *PublishersArgs) {
+	cc.send(Command{Publishers: args})
+}
+
+func (cc *CommandClient) Categories(args *CategoriesArgs) {
+	cc.send(Command{Categories: args})
+}
+
+func (cc *CommandClient) IndexExport(args *IndexExportArgs) {
+	cc.send(Command{IndexExport: args})
+}
+
+func (cc *CommandClient) IndexImport(args *IndexImportArgs) {
+	cc.send(Command{IndexImport: args})
+}
+
+func (cc *CommandClient) GC(args *GCArgs) {
+	cc.send(Command{GC: args})
+}
+
+func (cc *CommandClient) Usage(args *UsageArgs) {
+	cc.send(Command{Usage: args})
+}
+
+func (cc *CommandClient) Track(args *TrackArgs) {
+	cc.send(Command{Track: args})
+}
+
+func (cc *CommandClient) Attach(args *AttachArgs) {
+	cc.send(Command{Attach: args})
+}
+
+func (cc *CommandClient) Inspect(args *InspectArgs) {
+	cc.send(Command{Inspect: args})
+}
+
+func (cc *CommandClient) Record(args *RecordArgs) {
+	cc.send(Command{Record: args})
+}
+
+func (cc *CommandClient) Audit(args *AuditArgs) {
+	cc.send(Command{Audit: args})
+}
+
+func (cc *CommandClient) Receipts(args *ReceiptsArgs) {
+	cc.send(Command{Receipts: args})
+}
+
+func (cc *CommandClient) Channels(args *ChannelsArgs) {
+	cc.send(Command{Channels: args})
+}
+
+func (cc *CommandClient) Config(args *ConfigArgs) {
+	cc.send(Command{Config: args})
+}
+
+func (cc *CommandClient) Unstage(args *UnstageArgs) {
+	cc.send(Command{Unstage: args})
+}
+
+func (cc *CommandClient) Tag(args *TagArgs) {
+	cc.send(Command{Tag: args})
+}
+
+func (cc *CommandClient) Cancel(args *CancelArgs) {
+	cc.send(Command{Cancel: args})
+}
+
+func (cc *CommandClient) ACL(args *ACLArgs) {
+	cc.send(Command{ACL: args})
+}
+
+func (cc *CommandClient) Stats(args *StatsArgs) {
+	cc.send(Command{Stats: args})
+}
+
+func (cc *CommandClient) Probes(args *ProbesArgs) {
+	cc.send(Command{Probes: args})
+}
+
+func (cc *CommandClient) Snapshots(args *SnapshotsArgs) {
+	cc.send(Command{Snapshots: args})
+}
+
+func (cc *CommandClient) Log(args *LogArgs) {
+	cc.send(Command{Log: args})
+}
+
+func (cc *CommandClient) Bandwidth(args *BandwidthArgs) {
+	cc.send(Command{Bandwidth: args})
+}
+
 func (cc *CommandClient) SetNotifyCallback(fn func(Notify)) {
 	cc.notify = fn
 }
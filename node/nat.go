@@ -0,0 +1,96 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-eventbus"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/myelnet/pop/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// reachabilities are all the states AutoNAT reports, exported as metric labels
+var reachabilities = []network.Reachability{
+	network.ReachabilityUnknown,
+	network.ReachabilityPublic,
+	network.ReachabilityPrivate,
+}
+
+// reachability tracks whether other peers can dial our host as detected by AutoNAT
+type reachability struct {
+	mu     sync.Mutex
+	status network.Reachability
+	since  time.Time
+}
+
+// watch updates the reachability every time AutoNAT detects a change and the number of relay addresses
+// every time our addresses change until the context is cancelled
+func (r *reachability) watch(ctx context.Context, h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+	}, eventbus.BufSize(16))
+	if err != nil {
+		return err
+	}
+	r.set(network.ReachabilityUnknown)
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch evt := evt.(type) {
+				case event.EvtLocalReachabilityChanged:
+					log.Info().Str("reachability", evt.Reachability.String()).Msg("reachability changed")
+					r.set(evt.Reachability)
+				case event.EvtLocalAddressesUpdated:
+					metrics.RelayAddrs.Set(float64(len(relayAddrs(h))))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *reachability) set(status network.Reachability) {
+	r.mu.Lock()
+	r.status = status
+	r.since = time.Now()
+	r.mu.Unlock()
+	for _, s := range reachabilities {
+		v := 0.0
+		if s == status {
+			v = 1
+		}
+		metrics.Reachability.WithLabelValues(s.String()).Set(v)
+	}
+}
+
+// get returns the current reachability and since when
+func (r *reachability) get() (network.Reachability, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status, r.since
+}
+
+// relayAddrs returns the addresses we advertise through a circuit relay. AutoRelay adds them when AutoNAT
+// detects we are behind a NAT.
+func relayAddrs(h host.Host) []string {
+	var addrs []string
+	for _, addr := range h.Addrs() {
+		if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs
+}
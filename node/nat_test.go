@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachability(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	nd := newTestNode(ctx, mn, t)
+	require.NoError(t, nd.reach.watch(ctx, nd.host))
+
+	status, _ := nd.reach.get()
+	require.Equal(t, network.ReachabilityUnknown, status)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.Reachability.WithLabelValues("Unknown")))
+
+	emitter, err := nd.host.EventBus().Emitter(new(event.EvtLocalReachabilityChanged))
+	require.NoError(t, err)
+	defer emitter.Close()
+	require.NoError(t, emitter.Emit(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate}))
+	require.Eventually(t, func() bool {
+		status, _ := nd.reach.get()
+		return status == network.ReachabilityPrivate
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.Reachability.WithLabelValues("Private")))
+	require.Equal(t, 0.0, testutil.ToFloat64(metrics.Reachability.WithLabelValues("Unknown")))
+
+	// The status command reports the reachability even without a pending transaction
+	res := make(chan *StatusResult, 1)
+	nd.notify = func(n Notify) {
+		res <- n.StatusResult
+	}
+	nd.Status(ctx, &StatusArgs{})
+	sr := <-res
+	require.Equal(t, "Private", sr.Reachability)
+	require.Empty(t, sr.RelayAddrs)
+	require.NotEmpty(t, sr.Err)
+}
@@ -14,7 +14,9 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-fil-markets/storagemarket"
+	blocks "github.com/ipfs/go-block-format"
 	blocksutil "github.com/ipfs/go-ipfs-blocksutil"
+	files "github.com/ipfs/go-ipfs-files"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -23,6 +25,8 @@ import (
 	"github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/filecoin/storage"
 	"github.com/myelnet/pop/internal/testutil"
+	"github.com/myelnet/pop/logging"
+	"github.com/myelnet/pop/metrics"
 	"github.com/stretchr/testify/require"
 )
 
@@ -53,7 +57,9 @@ func newTestNode(ctx context.Context, mn mocknet.Mocknet, t *testing.T) *node {
 
 	tn := testutil.NewTestNode(mn, t)
 
-	nd := &node{}
+	nd := &node{
+		lc: newLifecycle(DefaultCachePolicy),
+	}
 	nd.ds = tn.Ds
 	nd.bs = tn.Bs
 	nd.ms = tn.Ms
@@ -68,6 +74,7 @@ func newTestNode(ctx context.Context, mn mocknet.Mocknet, t *testing.T) *node {
 	}
 	nd.exch, err = exchange.New(ctx, nd.host, nd.ds, opts)
 	require.NoError(t, err)
+	nd.pub, nd.pubHost = nd.exch, nd.host
 
 	return nd
 }
@@ -84,6 +91,53 @@ func TestPing(t *testing.T) {
 	nd.Ping(ctx, "")
 }
 
+func TestLog(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	defer logging.SetLevel(logging.All, "info")
+
+	var results []*LogResult
+	nd.notify = func(n Notify) {
+		results = append(results, n.LogResult)
+	}
+	nd.Log(ctx, &LogArgs{Module: logging.Tx, Level: "debug"})
+	nd.Log(ctx, &LogArgs{Module: "nope", Level: "debug"})
+	nd.Log(ctx, &LogArgs{})
+	require.Len(t, results, 3)
+	require.Equal(t, "debug", results[0].Levels[logging.Tx])
+	require.Equal(t, "info", results[0].Levels[logging.Index])
+	require.NotEmpty(t, results[1].Err)
+	require.Equal(t, results[0].Levels, results[2].Levels)
+}
+
+func TestBandwidth(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	nd.bw = metrics.NewBandwidth(metrics.BandwidthLimits{Upload: 1 << 20})
+	for i := 0; i < 3; i++ {
+		root := blocks.NewBlock([]byte(fmt.Sprintf("ref%d", i))).Cid()
+		nd.bw.Send(ctx, nd.host.ID(), root, 100*(i+1))
+	}
+
+	var results []*BandwidthResult
+	nd.notify = func(n Notify) {
+		results = append(results, n.BandwidthResult)
+	}
+	nd.Bandwidth(ctx, &BandwidthArgs{Top: 2})
+	require.Len(t, results, 1)
+	res := results[0]
+	require.Empty(t, res.Err)
+	require.Equal(t, uint64(600), res.Sent)
+	require.Equal(t, uint64(1<<20), res.UploadLimit)
+	require.Len(t, res.Peers, 1)
+	require.Len(t, res.Refs, 2)
+	require.Equal(t, uint64(300), res.Refs[0].Sent)
+}
+
 func TestPut(t *testing.T) {
 	ctx := context.Background()
 	mn := mocknet.New(ctx)
@@ -562,3 +616,70 @@ func TestMultipleGet(t *testing.T) {
 	res = <-got3
 	require.Greater(t, res.TransLatSeconds, 0.0)
 }
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	cn := newTestNode(ctx, mn, t)
+
+	dir := t.TempDir()
+	data := make([]byte, 256000)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	p := filepath.Join(dir, "data1")
+	require.NoError(t, os.WriteFile(p, data, 0666))
+
+	added := make(chan string, 1)
+	cn.notify = func(n Notify) {
+		require.Equal(t, n.PutResult.Err, "")
+		added <- n.PutResult.Cid
+	}
+	cn.Put(ctx, &PutArgs{
+		Path:      p,
+		ChunkSize: 1024,
+	})
+	<-added
+
+	ref, err := cn.getRef("")
+	require.NoError(t, err)
+	require.NoError(t, cn.exch.Index().SetRef(ref))
+
+	out := make(chan *InspectResult, 1)
+	cn.notify = func(n Notify) {
+		out <- n.InspectResult
+	}
+	cn.Inspect(ctx, &InspectArgs{Cid: ref.PayloadCID.String()})
+	res := <-out
+	require.Equal(t, "", res.Err)
+	require.Equal(t, ref.PayloadCID.String(), res.Root)
+	require.Greater(t, res.Created, int64(0))
+	require.Len(t, res.Entries, 1)
+	require.Equal(t, "data1", res.Entries[0].Key)
+	// The root block links the entry
+	require.Equal(t, res.Blocks, res.Entries[0].Blocks+1)
+
+	cn.Inspect(ctx, &InspectArgs{Cid: blocksutil.NewBlockGenerator().Next().Cid().String()})
+	res = <-out
+	require.NotEqual(t, "", res.Err)
+}
+
+func TestWriteOutput(t *testing.T) {
+	dir := t.TempDir()
+	root := blocksutil.NewBlockGenerator().Next().Cid()
+	content := files.NewMapDirectory(map[string]files.Node{
+		"data.txt": files.NewBytesFile([]byte("hello")),
+	})
+
+	// Content is named after the root inside an existing directory
+	require.NoError(t, writeOutput(content, dir, outputName(root, "")))
+	b, err := ioutil.ReadFile(filepath.Join(dir, root.String(), "data.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	// Or written at the output path if it doesn't exist
+	out := filepath.Join(dir, "out.txt")
+	require.NoError(t, writeOutput(files.NewBytesFile([]byte("hello")), out, outputName(root, "data.txt")))
+	b, err = ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	badgerds "github.com/ipfs/go-ds-badger"
+	"github.com/myelnet/pop/exchange"
+)
+
+// openDatastore opens the datastore of a repo, it fails if another process holds it
+func openDatastore(repo string) (*badgerds.Datastore, error) {
+	dsopts := badgerds.DefaultOptions
+	dsopts.SyncWrites = false
+	dsopts.Truncate = true
+
+	return badgerds.NewDatastore(filepath.Join(repo, "datastore"), &dsopts)
+}
+
+// CheckIndex checks the index of a repo while the daemon isn't running so a node whose index fails to load
+// can be debugged. The index of the separate publisher is checked if publisher is true. If repair is true
+// the HAMT is rebuilt without the refs which are corrupt or whose content is missing.
+func CheckIndex(repo string, publisher, repair bool) (exchange.IndexCheck, error) {
+	var check exchange.IndexCheck
+	bds, err := openDatastore(repo)
+	if err != nil {
+		return check, fmt.Errorf("failed to open %s, is the daemon running? %w", repo, err)
+	}
+	defer bds.Close()
+
+	var ds datastore.Batching = bds
+	if publisher {
+		ds = namespace.Wrap(bds, datastore.NewKey("/publisher"))
+	}
+	ms, err := multistore.NewMultiDstore(ds)
+	if err != nil {
+		return check, err
+	}
+	if repair {
+		return exchange.RepairIndex(ds, ms)
+	}
+	return exchange.CheckIndex(ds, ms)
+}
@@ -0,0 +1,24 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIndex(t *testing.T) {
+	repo := t.TempDir()
+
+	check, err := CheckIndex(repo, false, false)
+	require.NoError(t, err)
+	require.True(t, check.OK())
+	require.False(t, check.Root.Defined())
+	require.Len(t, check.Refs, 0)
+
+	// The repo can't be opened while another process holds it
+	ds, err := openDatastore(repo)
+	require.NoError(t, err)
+	defer ds.Close()
+	_, err = CheckIndex(repo, false, false)
+	require.Error(t, err)
+}
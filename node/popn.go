@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -17,33 +18,38 @@ import (
 	"github.com/filecoin-project/go-commp-utils/writer"
 	"github.com/filecoin-project/go-multistore"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/namespace"
-	badgerds "github.com/ipfs/go-ds-badger"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	files "github.com/ipfs/go-ipfs-files"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	ipldformat "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
-	"github.com/ipfs/go-path"
 	"github.com/ipld/go-car"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/libp2p/go-libp2p"
 	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	quic "github.com/libp2p/go-libp2p-quic-transport"
 	"github.com/libp2p/go-libp2p/p2p/net/conngater"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/myelnet/pop/build"
 	"github.com/myelnet/pop/exchange"
 	"github.com/myelnet/pop/filecoin"
 	"github.com/myelnet/pop/filecoin/storage"
 	"github.com/myelnet/pop/internal/utils"
+	"github.com/myelnet/pop/logging"
+	"github.com/myelnet/pop/metrics"
+	"github.com/myelnet/pop/payments"
 	"github.com/myelnet/pop/retrieval/client"
 	"github.com/myelnet/pop/retrieval/deal"
 	sel "github.com/myelnet/pop/selectors"
@@ -56,6 +62,9 @@ const unixfsLinksPerLevel = 1024
 // KLibp2pHost is the keystore key used for storing the host private key
 const KLibp2pHost = "libp2p-host"
 
+// progressInterval is the minimum delay between two progress notifications of a retrieval
+const progressInterval = 250 * time.Millisecond
+
 // ErrFilecoinRPCOffline is returned when the node is running without a provided filecoin api endpoint + token
 var ErrFilecoinRPCOffline = errors.New("filecoin RPC is offline")
 
@@ -83,8 +92,25 @@ type Options struct {
 	RepoPath string
 	// SocketPath is the unix socket path to listen on
 	SocketPath string
-	// BootstrapPeers is a peer address to connect to for discovering other peers
+	// BootstrapPeers are the p2p addresses of the pops to connect to for discovering other peers
 	BootstrapPeers []string
+	// MinPeers is the number of pop peers in our regions below which we bootstrap again
+	MinPeers int
+	// EnableAutoRelay lets peers behind a NAT reach us through a circuit relay when AutoNAT detects we
+	// aren't publicly reachable. Relays are discovered in the DHT unless static relays are given.
+	// Our libp2p version only speaks circuit relay v1 so the connections stay relayed, there is no hole
+	// punching to upgrade them to direct ones.
+	EnableAutoRelay bool
+	// Relays are the p2p addresses of the static relays used by AutoRelay
+	Relays []string
+	// ListenAddrs are the multiaddresses the libp2p host listens on, libp2p picks them if empty. Browsers
+	// connect to a WebSocket address such as /ip4/0.0.0.0/tcp/41505/ws.
+	ListenAddrs []string
+	// AnnounceAddrs replace the addresses advertised to other peers if not empty e.g. the public address of
+	// a port forwarded by the router
+	AnnounceAddrs []string
+	// Identity is the libp2p host key, it is read from the keystore or generated if nil
+	Identity ci.PrivKey
 	// FilEndpoint is the websocket url for accessing a remote filecoin api
 	FilEndpoint string
 	// FilToken is the authorization token to access the filecoin api
@@ -94,8 +120,101 @@ type Options struct {
 	// Regions is a list of regions a provider chooses to support.
 	// Nothing prevents providers from participating in regions outside of their geographic location however they may get less deals since the latency is likely to be higher
 	Regions []string
+	// TopicShards spreads the queries of each region over this number of gossip topics, 0 is a single topic
+	TopicShards int
+	// PrivateQueries publishes a salted hash of the roots we query instead of the roots
+	PrivateQueries bool
 	// Capacity is the maxium storage capacity dedicated to the exchange
 	Capacity uint64
+	// PublisherShare is the fraction of the capacity the content of a single publisher can use, 0 is uncapped
+	PublisherShare float64
+	// CategoryQuotas are the fractions of the capacity each category of content can use
+	CategoryQuotas map[string]float64
+	// CategoryLabel is the label holding the category of content
+	CategoryLabel string
+	// MaxRefs is the number of refs above which the least used content is evicted regardless of the capacity
+	MaxRefs int
+	// MaxIndexBuckets is the length of the LFU bucket list above which the least used content is evicted
+	MaxIndexBuckets int
+	// FastStart loads the index lazily to start serving sooner on nodes with a large cache
+	FastStart bool
+	// CachePolicy sets how long content served over HTTP is fresh before revalidation
+	CachePolicy CachePolicy
+	// GatewayAddr is an optional address to serve cached content over HTTP at /ipfs/<root>/<path>
+	GatewayAddr string
+	// GatewayTransforms convert content served by the gateway for the requests they match
+	GatewayTransforms []Transform
+	// GatewayTenants restrict the gateway to the holders of their API token with enforced limits.
+	// The gateway is open to anyone if empty.
+	GatewayTenants []Tenant
+	// GatewayQuotaPeriod is the period after which the bandwidth used by tenants is reset
+	GatewayQuotaPeriod time.Duration
+	// GatewayPayments requires light clients to pay for the content served by the gateway with payment
+	// channel vouchers at the price of our pricing policy
+	GatewayPayments bool
+	// CacheVariants stores the content converted by gateway transforms so it is only computed once
+	CacheVariants bool
+	// MetricsAddr is an optional address to expose prometheus metrics at /metrics
+	MetricsAddr string
+	// GRPCAddr is an optional address to serve the gRPC API at, calls need the admin token
+	GRPCAddr string
+	// AdminAddr is an optional address to serve the REST admin API at
+	AdminAddr string
+	// MaxMemory is the resident memory in bytes above which we reject new transfers. 0 is unlimited.
+	MaxMemory uint64
+	// MaxGoroutines is the number of goroutines above which we reject new transfers. 0 is unlimited.
+	MaxGoroutines int
+	// Bandwidth caps the bytes per second of the blocks sent and received globally and with each peer
+	Bandwidth metrics.BandwidthLimits
+	// LeasePrice is the price in attoFIL per byte per hour for publishers to keep their content
+	// from being evicted. Leases aren't sold if nil.
+	LeasePrice abi.TokenAmount
+	// Pricing configures the price we ask to serve content
+	Pricing exchange.PricingConfig
+	// ACL sets the access rules applying to every ref we serve
+	ACL exchange.ACLConfig
+	// RedeemThreshold is the value of the vouchers received on a payment channel after which they are
+	// redeemed without waiting for the channel to settle. Vouchers are redeemed when settling if nil.
+	RedeemThreshold abi.TokenAmount
+	// Contracts are the providers tried first for dispatching and retrieving content
+	Contracts []exchange.Contract
+	// Capability is the report of a self benchmark used to adjust the capacity and the number of
+	// replications to what the node can sustain
+	Capability *Capability
+	// TransferPolicy controls restarts and timeouts of data transfers
+	TransferPolicy exchange.TransferPolicy
+	// StallTimeout is how long a retrieval may go without any update before its transfer is restarted
+	StallTimeout time.Duration
+	// StallRestarts is the number of restarts of a stalled retrieval before trying another provider
+	StallRestarts int
+	// SlowBlockThreshold is the duration after which block reads are logged, negative disables the logs
+	SlowBlockThreshold time.Duration
+	// StandbyOf is the primary this node keeps a warm copy of if not nil
+	StandbyOf *peer.AddrInfo
+	// Standbys are the peers allowed to replicate the state of this node
+	Standbys []peer.ID
+	// Delegators are the light clients allowed to make this node retrieve and pay for content on their behalf
+	Delegators []peer.ID
+	// ProbeCanaries are small roots retrieved from random providers to measure the network health
+	ProbeCanaries []cid.Cid
+	// ProbeInterval is how often a canary is probed
+	ProbeInterval time.Duration
+	// DNSServers are the DNS servers formatted as host:port used to resolve DNSLink names
+	DNSServers []string
+	// BitswapFallback retrieves content from the public IPFS network when no provider offers it
+	BitswapFallback bool
+	// EnableDHT advertises our roots in the DHT and looks for providers there when gossip yields no offer
+	EnableDHT bool
+	// StorageMiners are the Filecoin miners content is archived with, default is the miners of our regions
+	StorageMiners []string
+	// SeparatePublisher publishes content from a second libp2p host with its own identity and Filecoin
+	// address so the reputation and payments of the publisher role don't mix with the cache provider's
+	SeparatePublisher bool
+	// Collections are the datasets exported on a schedule and published as new snapshots
+	Collections []Collection
+	// ConfigPath is the JSON config file whose live keys are applied again on SIGHUP or pop config reload,
+	// the config can't be reloaded if empty
+	ConfigPath string
 }
 
 // RemoteStorer is the interface used to store content on decentralized storage networks (Filecoin)
@@ -115,28 +234,65 @@ type node struct {
 	exch *exchange.Exchange
 	rs   RemoteStorer
 
+	// pub publishes our content, it is the exchange of a separate host if the roles are separated
+	pub     *exchange.Exchange
+	pubHost host.Host
+
 	mu     sync.Mutex
 	notify func(Notify)
+	// subs receive every notification in addition to the notify callback
+	subs    map[int]func(Notify)
+	nextSub int
 
-	// cache the last storage quote
+	// cache the last storage quote and the piece it was made for
 	qmu    sync.Mutex
 	sQuote *storage.Quote
+	sPiece *PieceRef
 
 	// keep track of an ongoing transaction
 	txmu sync.Mutex
 	tx   *exchange.Tx
+
+	// lc tracks the freshness of content served over HTTP
+	lc *lifecycle
+
+	// keep track of retrievals waiting for a cost confirmation
+	cmu      sync.Mutex
+	confirms map[cid.Cid]chan bool
+
+	// guard sheds load when we exceed our resource budget
+	guard *metrics.Guard
+	// bw meters the bytes sent and received per peer and per ref
+	bw *metrics.Bandwidth
+	// capability adjusts the configured capacity to what the node can sustain if benchmarked
+	capability *Capability
+
+	// keep track of the settings changed while running
+	confmu sync.Mutex
+	// confPath is the config file reloaded on SIGHUP or pop config reload if any
+	confPath string
+	// conf are the flags of the config file when it was last loaded
+	conf map[string]string
+	live LiveConfig
+
+	// tenants enforces the limits of the gateway tenants if any
+	tenants *tenants
+	// sessions lets clients reattach to a commit or a get after a disconnection
+	sessions sessions
+	// snaps publishes the snapshots of the collections if any
+	snaps *snapshots
+	// reach is whether other peers can dial our host
+	reach reachability
 }
 
 // New puts together all the components of the ipfs node
 func New(ctx context.Context, opts Options) (*node, error) {
 	var err error
-	nd := &node{}
-
-	dsopts := badgerds.DefaultOptions
-	dsopts.SyncWrites = false
-	dsopts.Truncate = true
+	nd := &node{
+		lc: newLifecycle(opts.CachePolicy),
+	}
 
-	nd.ds, err = badgerds.NewDatastore(filepath.Join(opts.RepoPath, "datastore"), &dsopts)
+	nd.ds, err = openDatastore(opts.RepoPath)
 	if err != nil {
 		return nil, err
 	}
@@ -150,13 +306,23 @@ func New(ctx context.Context, opts Options) (*node, error) {
 
 	nd.dag = merkledag.NewDAGService(blockservice.New(nd.bs, offline.Exchange(nd.bs)))
 
+	if len(opts.GatewayTenants) > 0 {
+		nd.tenants, err = newTenants(nd.ds, opts.GatewayTenants, opts.GatewayQuotaPeriod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	ks, err := keystore.NewFSKeystore(filepath.Join(opts.RepoPath, "keystore"))
 	if err != nil {
 		return nil, err
 	}
-	priv, err := utils.Libp2pKey(ks)
-	if err != nil {
-		return nil, err
+	priv := opts.Identity
+	if priv == nil {
+		priv, err = utils.Libp2pKey(ks)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	gater, err := conngater.NewBasicConnectionGater(nd.ds)
@@ -164,8 +330,9 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		return nil, err
 	}
 
-	nd.host, err = libp2p.New(
-		ctx,
+	// The DHT also stores the IPNS records of the roots we publish
+	var kad *dht.IpfsDHT
+	lopts := []libp2p.Option{
 		libp2p.Identity(priv),
 		libp2p.ConnectionManager(connmgr.NewConnManager(
 			20,             // Lowwater
@@ -173,23 +340,69 @@ func New(ctx context.Context, opts Options) (*node, error) {
 			20*time.Second, // GracePeriod
 		)),
 		libp2p.ConnectionGater(gater),
+		// QUIC isn't one of the default transports of our libp2p version
+		libp2p.DefaultTransports,
+		libp2p.Transport(quic.NewTransport),
 		libp2p.DisableRelay(),
 		// Attempt to open ports using uPNP for NATed hosts.
 		libp2p.NATPortMap(),
 		libp2p.EnableNATService(),
 		// Let this host use the DHT to find other hosts
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			return dht.New(ctx, h)
+			d, err := dht.New(ctx, h)
+			kad = d
+			return d, err
 		}),
 		// user-agent is sent along the identify protocol
-		libp2p.UserAgent("pop-"+build.Version),
-	)
+		libp2p.UserAgent("pop-" + build.Version),
+	}
+	if opts.EnableAutoRelay {
+		relays, err := parseAddrInfos(opts.Relays)
+		if err != nil {
+			return nil, err
+		}
+		// Overrides DisableRelay so we can listen on and dial circuit addresses
+		lopts = append(lopts, libp2p.EnableRelay(), libp2p.EnableAutoRelay())
+		if len(relays) > 0 {
+			lopts = append(lopts, libp2p.StaticRelays(relays))
+		}
+	}
+	if len(opts.ListenAddrs) > 0 {
+		if err := ValidateListenAddrs(opts.ListenAddrs); err != nil {
+			return nil, err
+		}
+		lopts = append(lopts, libp2p.ListenAddrStrings(opts.ListenAddrs...))
+	}
+	if len(opts.AnnounceAddrs) > 0 {
+		announce, err := parseAnnounceAddrs(opts.AnnounceAddrs)
+		if err != nil {
+			return nil, err
+		}
+		lopts = append(lopts, libp2p.AddrsFactory(announceFactory(announce)))
+	}
+	nd.host, err = libp2p.New(ctx, lopts...)
 	if err != nil {
 		return nil, err
 	}
+	if err := nd.reach.watch(ctx, nd.host); err != nil {
+		return nil, err
+	}
 
 	// Convert region names to region structs
 	regions := exchange.ParseRegions(opts.Regions)
+	bootstrap, err := parseAddrInfos(opts.BootstrapPeers)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MaxMemory > 0 || opts.MaxGoroutines > 0 {
+		nd.guard = metrics.NewGuard(metrics.Budget{
+			MaxRSS:        opts.MaxMemory,
+			MaxGoroutines: opts.MaxGoroutines,
+		})
+		go nd.guard.Run(ctx, 5*time.Second)
+	}
+	nd.bw = metrics.NewBandwidth(opts.Bandwidth)
 
 	eopts := exchange.Options{
 		Blockstore:          nd.bs,
@@ -200,27 +413,106 @@ func New(ctx context.Context, opts Options) (*node, error) {
 		FilecoinRPCHeader: http.Header{
 			"Authorization": []string{opts.FilToken},
 		},
-		Regions:  regions,
-		Capacity: opts.Capacity,
+		Regions:            regions,
+		TopicShards:        opts.TopicShards,
+		PrivateQueries:     opts.PrivateQueries,
+		Bootstrap:          bootstrap,
+		MinPeers:           opts.MinPeers,
+		Capacity:           opts.Capacity,
+		PublisherShare:     opts.PublisherShare,
+		CategoryQuotas:     opts.CategoryQuotas,
+		CategoryLabel:      opts.CategoryLabel,
+		MaxRefs:            opts.MaxRefs,
+		MaxIndexBuckets:    opts.MaxIndexBuckets,
+		FastStart:          opts.FastStart,
+		Guard:              nd.guard,
+		Bandwidth:          nd.bw,
+		LeasePrice:         opts.LeasePrice,
+		RedeemThreshold:    opts.RedeemThreshold,
+		Pricing:            opts.Pricing,
+		ACL:                opts.ACL,
+		Contracts:          opts.Contracts,
+		TransferPolicy:     opts.TransferPolicy,
+		StallTimeout:       opts.StallTimeout,
+		StallRestarts:      opts.StallRestarts,
+		SlowBlockThreshold: opts.SlowBlockThreshold,
+		StandbyOf:          opts.StandbyOf,
+		Standbys:           opts.Standbys,
+		Delegators:         opts.Delegators,
+		ProbeCanaries:      opts.ProbeCanaries,
+		ProbeInterval:      opts.ProbeInterval,
+		ValueStore:         kad,
+		DNSServers:         opts.DNSServers,
+		// The DHT also finds the IPFS peers providing content
+		EnableBitswapFallback: opts.BitswapFallback,
+		EnableDHT:             opts.EnableDHT,
+		ContentRouting:        kad,
+		StorageMiners:         opts.StorageMiners,
+	}
+	if c := opts.Capability; c != nil {
+		eopts.Capacity = c.Capacity(opts.Capacity)
+		eopts.MaxReplications = c.MaxReplications()
+		eopts.ReplicationBandwidth = c.ReplicationBandwidth()
 	}
 
 	nd.exch, err = exchange.New(ctx, nd.host, nd.ds, eopts)
 	if err != nil {
 		return nil, err
 	}
+	nd.capability = opts.Capability
+	nd.live = LiveConfig{
+		ACL:            opts.ACL,
+		Capacity:       opts.Capacity,
+		PublisherShare: opts.PublisherShare,
+		Bootstrap:      bootstrap,
+		MinPeers:       opts.MinPeers,
+		Budget: metrics.Budget{
+			MaxRSS:        opts.MaxMemory,
+			MaxGoroutines: opts.MaxGoroutines,
+		},
+		Bandwidth: opts.Bandwidth,
+	}
+	if nd.live.MinPeers == 0 {
+		nd.live.MinPeers = exchange.DefaultMinPeers
+	}
+	// Changes to the config file are applied when it is reloaded
+	if opts.ConfigPath != "" {
+		nd.confPath = opts.ConfigPath
+		nd.conf, err = readConfigFile(opts.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if opts.PrivKey != "" {
 		nd.importAddress(opts.PrivKey)
 	}
 
+	nd.pub, nd.pubHost = nd.exch, nd.host
+	pbs, pms, pds := nd.bs, nd.ms, nd.ds
+	if opts.SeparatePublisher {
+		pds = namespace.Wrap(nd.ds, datastore.NewKey("/publisher"))
+		pbs = blockstore.NewBlockstore(pds)
+		pms, err = multistore.NewMultiDstore(pds)
+		if err != nil {
+			return nil, err
+		}
+		nd.pub, nd.pubHost, err = newPublisher(ctx, opts, eopts, pds, pbs, pms)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("peerID", nd.pubHost.ID().String()).Msg("publishing from a separate host")
+	}
+
+	// Storage deals are paid by the publisher
 	nd.rs, err = storage.New(
-		nd.host,
-		nd.bs,
-		nd.ms,
-		namespace.Wrap(nd.ds, datastore.NewKey("/storage/client")),
-		nd.exch.DataTransfer(),
-		nd.exch.Wallet(),
-		nd.exch.FilecoinAPI(),
-		nd.exch,
+		nd.pubHost,
+		pbs,
+		pms,
+		namespace.Wrap(pds, datastore.NewKey("/storage/client")),
+		nd.pub.DataTransfer(),
+		nd.pub.Wallet(),
+		nd.pub.FilecoinAPI(),
+		nd.pub,
 	)
 	if err != nil {
 		return nil, err
@@ -229,22 +521,103 @@ func New(ctx context.Context, opts Options) (*node, error) {
 	if err != nil {
 		return nil, err
 	}
-	// start connecting with peers
-	go utils.Bootstrap(ctx, nd.host, opts.BootstrapPeers)
+
+	if len(opts.Collections) > 0 {
+		nd.snaps = newSnapshots(nd, filepath.Join(opts.RepoPath, "snapshots"), opts.Collections)
+		nd.snaps.start(ctx)
+	}
 
 	return nd, nil
 
 }
 
+// newPublisher creates a publish only exchange on a host with its own identity. Its keys and Filecoin
+// addresses are kept in a separate keystore so none are shared with the provider.
+func newPublisher(ctx context.Context, opts Options, eopts exchange.Options, ds datastore.Batching, bs blockstore.Blockstore, ms *multistore.MultiStore) (*exchange.Exchange, host.Host, error) {
+	repoPath := filepath.Join(opts.RepoPath, "publisher")
+	ks, err := keystore.NewFSKeystore(filepath.Join(repoPath, "keystore"))
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := utils.Libp2pKey(ks)
+	if err != nil {
+		return nil, nil, err
+	}
+	var kad *dht.IpfsDHT
+	h, err := libp2p.New(ctx,
+		libp2p.Identity(priv),
+		libp2p.ConnectionManager(connmgr.NewConnManager(
+			20,             // Lowwater
+			60,             // HighWater,
+			20*time.Second, // GracePeriod
+		)),
+		libp2p.DisableRelay(),
+		libp2p.NATPortMap(),
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			d, err := dht.New(ctx, h)
+			kad = d
+			return d, err
+		}),
+		libp2p.UserAgent("pop-"+build.Version),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	eopts.Blockstore = bs
+	eopts.MultiStore = ms
+	eopts.Keystore = ks
+	eopts.RepoPath = repoPath
+	eopts.ValueStore = kad
+	eopts.ContentRouting = kad
+	eopts.StandbyOf = nil
+	eopts.Standbys = nil
+	eopts.Delegators = nil
+	eopts.ProbeCanaries = nil
+	eopts.PublishOnly = true
+	exch, err := exchange.New(ctx, h, ds, eopts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return exch, h, nil
+}
+
+// parseAddrInfos parses p2p addresses such as the ones of the bootstrap pops, merging the addresses of the same peer
+func parseAddrInfos(addrs []string) ([]peer.AddrInfo, error) {
+	var maddrs []ma.Multiaddr
+	for _, s := range addrs {
+		maddr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %s: %w", s, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return peer.AddrInfosFromP2pAddrs(maddrs...)
+}
+
+// exchangeOf returns the publisher exchange if it stores the given root or the provider exchange
+func (nd *node) exchangeOf(root cid.Cid) *exchange.Exchange {
+	if _, err := nd.pub.Index().PeekRef(root); err == nil {
+		return nd.pub
+	}
+	return nd.exch
+}
+
 // send hits out notify callback if we attached one
 func (nd *node) send(n Notify) {
 	nd.mu.Lock()
 	notify := nd.notify
+	subs := make([]func(Notify), 0, len(nd.subs))
+	for _, fn := range nd.subs {
+		subs = append(subs, fn)
+	}
 	nd.mu.Unlock()
 
+	for _, fn := range subs {
+		fn(n)
+	}
 	if notify != nil {
 		notify(n)
-	} else {
+	} else if len(subs) == 0 {
 		log.Info().Interface("notif", n).Msg("nil notify callback; dropping")
 	}
 }
@@ -267,12 +640,17 @@ func (nd *node) Ping(ctx context.Context, who string) {
 		for _, a := range nd.host.Addrs() {
 			addrs = append(addrs, a.String())
 		}
-		nd.send(Notify{PingResult: &PingResult{
+		res := &PingResult{
 			ID:      nd.host.ID().String(),
 			Addrs:   addrs,
 			Peers:   pstr,
 			Version: build.Version,
-		}})
+		}
+		if nd.pubHost != nd.host {
+			res.PublisherID = nd.pubHost.ID().String()
+			res.PublisherAddress = nd.pub.Wallet().DefaultAddress().String()
+		}
+		nd.send(Notify{PingResult: res})
 		return
 	}
 
@@ -337,7 +715,7 @@ func (nd *node) ping(ctx context.Context, pi peer.AddrInfo) error {
 // Put a file into a new or pending transaction
 func (nd *node) Put(ctx context.Context, args *PutArgs) {
 	sendErr := func(err error) {
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			PutResult: &PutResult{
 				Err: err.Error(),
 			},
@@ -347,10 +725,23 @@ func (nd *node) Put(ctx context.Context, args *PutArgs) {
 	nd.txmu.Lock()
 	defer nd.txmu.Unlock()
 	if nd.tx == nil {
-		nd.tx = nd.exch.Tx(ctx)
+		nd.tx = nd.pub.Tx(ctx)
 	}
 	nd.tx.SetChunkSize(int64(args.ChunkSize))
-	err := nd.tx.PutFile(args.Path)
+	chunker, err := putChunker(args)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if err := nd.tx.SetChunker(chunker); err != nil {
+		sendErr(err)
+		return
+	}
+	if args.Recursive {
+		nd.putDir(ctx, args)
+		return
+	}
+	err = nd.tx.PutFile(args.Path)
 	if err != nil {
 		sendErr(err)
 		return
@@ -360,14 +751,14 @@ func (nd *node) Put(ctx context.Context, args *PutArgs) {
 		sendErr(err)
 		return
 	}
-	froot := status[exchange.KeyFromPath(args.Path)].Value
+	froot := status[exchange.FileKey(args.Path).String()].Value
 	// We could get the size from the index entry but DAGStat gives more feedback into
 	// how the file actually got chunked
 	stats, err := exchange.Stat(ctx, nd.tx.Store(), froot, sel.All())
 	if err != nil {
 		log.Error().Err(err).Msg("record not found")
 	}
-	nd.send(Notify{
+	nd.sendTo(ctx, Notify{
 		PutResult: &PutResult{
 			Cid:       froot.String(),
 			Size:      filecoin.SizeStr(filecoin.NewInt(uint64(stats.Size))),
@@ -376,34 +767,218 @@ func (nd *node) Put(ctx context.Context, args *PutArgs) {
 		}})
 }
 
-// Status prints the current transaction status. It shows which files have been added but not yet committed
-// to the network
-func (nd *node) Status(ctx context.Context, args *StatusArgs) {
+// putChunker returns the chunker config requested by the put args
+func putChunker(args *PutArgs) (exchange.ChunkerConfig, error) {
+	c := exchange.DefaultChunkerConfig
+	switch args.Chunker {
+	case "":
+	case "ipfs":
+		c = exchange.IPFSChunkerConfig
+	default:
+		c.Splitter = args.Chunker
+	}
+	if args.Layout != "" {
+		c.Layout = args.Layout
+	}
+	if args.RawLeaves != nil {
+		c.RawLeaves = *args.RawLeaves
+	}
+	if args.CidVersion != nil {
+		c.CidVersion = *args.CidVersion
+	}
+	if args.Hash != "" {
+		code, err := exchange.HashCode(args.Hash)
+		if err != nil {
+			return c, err
+		}
+		c.HashFunction = code
+	}
+	return c, nil
+}
+
+// Import adds the DAGs of a CAR archive to the current transaction
+func (nd *node) Import(ctx context.Context, args *ImportArgs) {
 	sendErr := func(err error) {
 		nd.send(Notify{
-			StatusResult: &StatusResult{
+			ImportResult: &ImportResult{
 				Err: err.Error(),
 			},
 		})
 	}
+
+	f, err := os.Open(args.Path)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer f.Close()
+
 	nd.txmu.Lock()
 	defer nd.txmu.Unlock()
-	if nd.tx != nil {
-		s, err := nd.tx.Status()
+	if nd.tx == nil {
+		nd.tx = nd.pub.Tx(ctx)
+	}
+	roots, err := nd.tx.PutCar(f)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	status, err := nd.tx.Status()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &ImportResult{
+		Root: nd.tx.Root().String(),
+	}
+	var size int64
+	for _, r := range roots {
+		res.Roots = append(res.Roots, r.String())
+		size += status[r.String()].Size
+	}
+	res.Size = filecoin.SizeStr(filecoin.NewInt(uint64(size)))
+	nd.send(Notify{ImportResult: res})
+}
+
+// Export writes a CAR archive of content we store to a file
+func (nd *node) Export(ctx context.Context, args *ExportArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			ExportResult: &ExportResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	root, err := nd.exch.Tags().Resolve(args.Cid)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	p := exchange.NewPath(root)
+	if args.Key != "" {
+		key, err := exchange.ParseKey(args.Key)
 		if err != nil {
 			sendErr(err)
 			return
 		}
+		p.Segments = append(p.Segments, key)
+	}
+	sl, err := p.Selector()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	f, err := os.Create(args.Path)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	defer f.Close()
 
-		nd.send(Notify{
-			StatusResult: &StatusResult{
-				RootCid: nd.tx.Root().String(),
-				Entries: s.String(),
+	tx := nd.exchangeOf(root).Tx(ctx, exchange.WithRoot(root))
+	defer tx.Close()
+	if err := tx.ExportCar(root, f, sl); err != nil {
+		sendErr(err)
+		return
+	}
+	st, err := f.Stat()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		ExportResult: &ExportResult{
+			Path: args.Path,
+			Size: filecoin.SizeStr(filecoin.NewInt(uint64(st.Size()))),
+		},
+	})
+}
+
+// putDir adds a directory to the current transaction and sends feedback for every file imported.
+// Callers must hold the transaction lock.
+func (nd *node) putDir(ctx context.Context, args *PutArgs) {
+	var opts []exchange.PutOption
+	if len(args.Include) > 0 {
+		opts = append(opts, exchange.WithInclude(args.Include...))
+	}
+	if len(args.Exclude) > 0 {
+		opts = append(opts, exchange.WithExclude(args.Exclude...))
+	}
+	for p := range nd.tx.PutDir(args.Path, opts...) {
+		if p.Err != nil {
+			nd.sendTo(ctx, Notify{
+				PutResult: &PutResult{
+					Err: p.Err.Error(),
+				},
+			})
+			return
+		}
+		nd.sendTo(ctx, Notify{
+			PutResult: &PutResult{
+				Key:  p.Key,
+				Cid:  p.Cid.String(),
+				Size: filecoin.SizeStr(filecoin.NewInt(uint64(p.Size))),
+			},
+		})
+	}
+	status, err := nd.tx.Status()
+	if err != nil {
+		nd.sendTo(ctx, Notify{
+			PutResult: &PutResult{
+				Err: err.Error(),
 			},
 		})
 		return
 	}
-	sendErr(errors.New("no pending transaction"))
+	droot := status[exchange.FileKey(args.Path).String()].Value
+	stats, err := exchange.Stat(ctx, nd.tx.Store(), droot, sel.All())
+	if err != nil {
+		log.Error().Err(err).Msg("record not found")
+	}
+	nd.sendTo(ctx, Notify{
+		PutResult: &PutResult{
+			Cid:       droot.String(),
+			Size:      filecoin.SizeStr(filecoin.NewInt(uint64(stats.Size))),
+			NumBlocks: stats.NumBlocks,
+			Root:      nd.tx.Root().String(),
+		}})
+}
+
+// Status prints the current transaction status. It shows which files have been added but not yet committed
+// to the network and whether other peers can reach us
+func (nd *node) Status(ctx context.Context, args *StatusArgs) {
+	sendErr := func(err error) {
+		nd.sendTo(ctx, Notify{
+			StatusResult: &StatusResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	reach, _ := nd.reach.get()
+	sr := &StatusResult{
+		Reachability: reach.String(),
+		RelayAddrs:   relayAddrs(nd.host),
+	}
+	nd.txmu.Lock()
+	defer nd.txmu.Unlock()
+	if nd.tx != nil {
+		s, err := nd.tx.Status()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		sr.RootCid = nd.tx.Root().String()
+		sr.Entries = s.String()
+		if dedup := nd.tx.Deduplicated(); dedup > 0 {
+			sr.Deduplicated = filecoin.SizeStr(filecoin.NewInt(dedup))
+		}
+	} else {
+		// The network status is still reported without a pending transaction
+		sr.Err = "no pending transaction"
+	}
+	nd.sendTo(ctx, Notify{
+		StatusResult: sr,
+	})
 }
 
 // getRef is an internal function to find a ref with a given string cid
@@ -412,11 +987,11 @@ func (nd *node) getRef(cstr string) (*exchange.DataRef, error) {
 	// Select the commit with the matching CID
 	// TODO: should prob error out if we don't find it
 	if cstr != "" {
-		ccid, err := cid.Parse(cstr)
+		ccid, err := nd.exch.Tags().Resolve(cstr)
 		if err != nil {
 			return nil, err
 		}
-		ref, err := nd.exch.Index().PeekRef(ccid)
+		ref, err := nd.exchangeOf(ccid).Index().PeekRef(ccid)
 		if err != nil {
 			return nil, err
 		}
@@ -441,7 +1016,7 @@ func (nd *node) Quote(ctx context.Context, args *QuoteArgs) {
 			},
 		})
 	}
-	if !nd.exch.IsFilecoinOnline() {
+	if !nd.pub.IsFilecoinOnline() {
 		sendErr(ErrFilecoinRPCOffline)
 		return
 	}
@@ -450,7 +1025,12 @@ func (nd *node) Quote(ctx context.Context, args *QuoteArgs) {
 		sendErr(err)
 		return
 	}
-	store, err := nd.ms.Get(com.StoreID)
+	// The pending transaction is in the publisher stores
+	exch := nd.pub
+	if args.Ref != "" {
+		exch = nd.exchangeOf(com.PayloadCID)
+	}
+	store, err := exch.Index().OpenStore(com.StoreID)
 	if err != nil {
 		sendErr(err)
 		return
@@ -469,6 +1049,7 @@ func (nd *node) Quote(ctx context.Context, args *QuoteArgs) {
 	})
 	nd.qmu.Lock()
 	nd.sQuote = quote
+	nd.sPiece = piece
 	nd.qmu.Unlock()
 
 	if err != nil {
@@ -492,35 +1073,106 @@ func (nd *node) Quote(ctx context.Context, args *QuoteArgs) {
 // Commit a content transaction for storage
 func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 	sendErr := func(err error) {
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			CommResult: &CommResult{
-				Err: err.Error(),
+				Err:  err.Error(),
+				Code: exchange.ErrorCode(err),
 			},
 		})
 	}
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
 	nd.txmu.Lock()
+	if nd.tx == nil {
+		nd.txmu.Unlock()
+		sendErr(errors.New("no pending transaction"))
+		return
+	}
+	// The entries we don't commit stay staged in a new transaction
+	var rest *exchange.Tx
+	if len(args.Keys) > 0 {
+		var err error
+		rest, err = nd.splitTx(ctx, args.Keys)
+		if err != nil {
+			nd.txmu.Unlock()
+			sendErr(err)
+			return
+		}
+	}
 	nd.tx.SetCacheRF(args.CacheRF)
+	nd.tx.SetDispatchPacing(args.Uplink, args.Deadline)
+	for k, v := range args.Labels {
+		nd.tx.SetLabel(k, v)
+	}
+	if args.IPNS != "" {
+		key := args.IPNS
+		// self publishes under the peer ID of the node
+		if key == "self" {
+			key = KLibp2pHost
+		}
+		nd.tx.SetIPNS(key)
+	}
 	err := nd.tx.Commit()
 	if err != nil {
+		if rest != nil {
+			// Stage the other entries again so the transaction can be committed after fixing the error
+			status, _ := rest.Status()
+			for k := range status {
+				if err := nd.tx.Stage(rest, k); err != nil {
+					log.Error().Err(err).Str("key", k).Msg("failed to stage entry again")
+				}
+			}
+			rest.Close()
+		}
+		nd.txmu.Unlock()
 		sendErr(err)
 		return
 	}
 	ref := nd.tx.Ref()
-	nd.tx.WatchDispatch(func(r exchange.PRecord) {
-		nd.send(Notify{
+	tx := nd.tx
+	if name := tx.IPNSName(); name != "" {
+		nd.sendTo(ctx, Notify{
 			CommResult: &CommResult{
-				Caches: []string{
-					r.Provider.String(),
-				},
+				IPNS: name,
 			},
 		})
-	})
+	}
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		tx.WatchDispatch(func(r exchange.PRecord) {
+			cache := r.Provider.String()
+			// Show which caches relayed the content if the provider couldn't be reached directly
+			for _, p := range r.Path {
+				cache = fmt.Sprintf("%s (via %s)", cache, p)
+			}
+			nd.sendTo(ctx, Notify{
+				CommResult: &CommResult{
+					Caches: []string{
+						cache,
+					},
+				},
+			})
+		})
+	}()
+	// The caches reached before a cancellation keep the content but no storage deals are started
+	select {
+	case <-watched:
+	case <-ctx.Done():
+	}
 	nd.tx.Close()
-	nd.tx = nil
+	nd.tx = rest
 	nd.txmu.Unlock()
+	if err := ctx.Err(); err != nil {
+		sendErr(err)
+		return
+	}
 
 	if !args.CacheOnly && args.StorageRF > 0 {
-		if !nd.exch.IsFilecoinOnline() {
+		if !nd.pub.IsFilecoinOnline() {
 			sendErr(ErrFilecoinRPCOffline)
 			return
 		}
@@ -532,6 +1184,7 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 			return
 		}
 		quote := nd.sQuote
+		piece := nd.sPiece
 		nd.qmu.Unlock()
 
 		var miners []storage.Miner
@@ -545,7 +1198,7 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 		rcpt, err := nd.rs.Store(ctx, storage.NewParams(
 			ref.PayloadCID,
 			args.Duration,
-			nd.exch.Wallet().DefaultAddress(),
+			nd.pub.Wallet().DefaultAddress(),
 			miners,
 		))
 		if err != nil {
@@ -556,6 +1209,10 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 			sendErr(ErrAllDealsFailed)
 			return
 		}
+		// The deals are recorded so the content can be restored from the miners once evicted
+		if err := nd.pub.Index().PutArchive(newArchive(ref, piece, rcpt, args.Duration)); err != nil {
+			log.Error().Err(err).Str("root", ref.PayloadCID.String()).Msg("failed to record archive")
+		}
 		var cr CommResult
 		for _, m := range rcpt.Miners {
 			cr.Miners = append(cr.Miners, m.String())
@@ -563,44 +1220,125 @@ func (nd *node) Commit(ctx context.Context, args *CommArgs) {
 		for _, d := range rcpt.DealRefs {
 			cr.Deals = append(cr.Deals, d.String())
 		}
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			CommResult: &cr,
 		})
 	}
 }
 
-// Get sends a request for content with the given arguments. It also sends feedback to any open cli
-// connections
-func (nd *node) Get(ctx context.Context, args *GetArgs) {
+// splitTx moves the entries of the ongoing transaction which are not in keys to a new transaction
+// returned so they can be committed later. It returns nil if all the entries are kept.
+func (nd *node) splitTx(ctx context.Context, keys []string) (*exchange.Tx, error) {
+	status, err := nd.tx.Status()
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if _, ok := status[k]; !ok {
+			return nil, fmt.Errorf("%s: %w", k, exchange.ErrEntryNotStaged)
+		}
+		selected[k] = true
+	}
+	var others []string
+	for k := range status {
+		if !selected[k] {
+			others = append(others, k)
+		}
+	}
+	if len(others) == 0 {
+		return nil, nil
+	}
+	rest := nd.pub.Tx(ctx)
+	for _, k := range others {
+		if err := rest.Stage(nd.tx, k); err != nil {
+			rest.Close()
+			return nil, err
+		}
+	}
+	for _, k := range others {
+		if err := nd.tx.Unstage(k); err != nil {
+			rest.Close()
+			return nil, err
+		}
+	}
+	return rest, nil
+}
+
+// Unstage removes entries from the ongoing transaction and sends the status of the remaining ones
+func (nd *node) Unstage(ctx context.Context, args *UnstageArgs) {
 	sendErr := func(err error) {
 		nd.send(Notify{
-			GetResult: &GetResult{
+			StatusResult: &StatusResult{
 				Err: err.Error(),
+			},
+		})
+	}
+	nd.txmu.Lock()
+	defer nd.txmu.Unlock()
+	if nd.tx == nil {
+		sendErr(errors.New("no pending transaction"))
+		return
+	}
+	for _, k := range args.Keys {
+		if err := nd.tx.Unstage(k); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	s, err := nd.tx.Status()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if len(s) == 0 {
+		// Nothing left to commit
+		nd.tx.Close()
+		nd.tx = nil
+		nd.send(Notify{StatusResult: &StatusResult{}})
+		return
+	}
+	nd.send(Notify{
+		StatusResult: &StatusResult{
+			RootCid: nd.tx.Root().String(),
+			Entries: s.String(),
+		},
+	})
+}
+
+// Get sends a request for content with the given arguments. It also sends feedback to any open cli
+// connections
+func (nd *node) Get(ctx context.Context, args *GetArgs) {
+	sendErr := func(err error) {
+		nd.sendTo(ctx, Notify{
+			GetResult: &GetResult{
+				Err:  err.Error(),
+				Code: exchange.ErrorCode(err),
 			}})
 	}
-	p := path.FromString(args.Cid)
 	// /<cid>/path/file.ext => cid, ["path", file.ext"]
-	root, segs, err := path.SplitAbsPath(p)
+	p, err := nd.exch.ResolvePath(ctx, args.Cid)
 	if err != nil {
 		sendErr(err)
 		return
 	}
+	root := p.Root
 	// Check if we're trying to get from an ongoing transaction
 	nd.txmu.Lock()
 	if nd.tx != nil && nd.tx.Root() == root {
-		f, err := nd.tx.GetFile(segs[0])
+		f, err := nd.tx.GetFile(p.Key().String())
 		if err != nil {
 			sendErr(err)
 			return
 		}
 		if args.Out != "" {
-			err = files.WriteTo(f, args.Out)
+			err = writeOutput(f, args.Out, outputName(root, p.Key().String()))
 			if err != nil {
 				sendErr(err)
 				return
 			}
 		}
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			GetResult: &GetResult{
 				Local: true,
 			},
@@ -610,7 +1348,11 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 	nd.txmu.Unlock()
 
 	// Only support a single segment for now
-	args.Key = segs[0]
+	if len(p.Segments) > 1 {
+		sendErr(exchange.ErrNestedPath)
+		return
+	}
+	args.Key = p.Key().String()
 	// Log progress
 	if args.Verbose {
 		unsub := nd.exch.Retrieval().Client().SubscribeToEvents(
@@ -624,14 +1366,60 @@ func (nd *node) Get(ctx context.Context, args *GetArgs) {
 		)
 		defer unsub()
 	}
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(args.Timeout)*time.Minute)
-	defer cancel()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(args.Timeout)*time.Minute)
+		defer cancel()
+	}
 	err = nd.get(ctx, root, args)
 	if err != nil {
 		sendErr(err)
 	}
 }
 
+// Confirm accepts or declines the cost of a retrieval waiting for confirmation
+func (nd *node) Confirm(ctx context.Context, args *ConfirmArgs) {
+	p, err := nd.exch.ResolvePath(ctx, args.Cid)
+	if err != nil {
+		log.Error().Err(err).Msg("Confirm")
+		return
+	}
+	root := p.Root
+	nd.cmu.Lock()
+	ch, ok := nd.confirms[root]
+	nd.cmu.Unlock()
+	if !ok {
+		log.Info().Str("root", root.String()).Msg("no retrieval waiting for confirmation")
+		return
+	}
+	select {
+	case ch <- args.Accept:
+	default:
+	}
+}
+
+// waitConfirm blocks until the client confirms or declines the retrieval of the given root
+func (nd *node) waitConfirm(ctx context.Context, root cid.Cid) bool {
+	ch := make(chan bool, 1)
+	nd.cmu.Lock()
+	if nd.confirms == nil {
+		nd.confirms = make(map[cid.Cid]chan bool)
+	}
+	nd.confirms[root] = ch
+	nd.cmu.Unlock()
+	defer func() {
+		nd.cmu.Lock()
+		delete(nd.confirms, root)
+		nd.cmu.Unlock()
+	}()
+	select {
+	case ok := <-ch:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // get is a synchronous content retrieval operation which can be called by a CLI request or HTTP
 func (nd *node) get(ctx context.Context, c cid.Cid, args *GetArgs) error {
 	// Check our supply if we may already have it
@@ -640,18 +1428,21 @@ func (nd *node) get(ctx context.Context, c cid.Cid, args *GetArgs) error {
 		if err != nil {
 			return err
 		}
-		err = files.WriteTo(f, args.Out)
+		err = writeOutput(f, args.Out, outputName(c, args.Key))
 		if err != nil {
 			return err
 		}
 	}
 	if err == nil {
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			GetResult: &GetResult{
 				Local: true,
 			}})
 		return nil
 	}
+	if args.Delegate != "" {
+		return nd.delegate(ctx, c, args)
+	}
 
 	var strategy exchange.SelectionStrategy
 	switch args.Strategy {
@@ -661,13 +1452,35 @@ func (nd *node) get(ctx context.Context, c cid.Cid, args *GetArgs) error {
 		strategy = exchange.SelectCheapest(5, 4*time.Second)
 	case "SelectFirstLowerThan":
 		strategy = exchange.SelectFirstLowerThan(abi.NewTokenAmount(5))
+	case "SelectByReputation":
+		strategy = exchange.SelectByReputation(nd.exch.Reputation(), 4*time.Second)
 	default:
 		return errors.New("unknown strategy")
 	}
 
+	var confirmAbove filecoin.FIL
+	if args.ConfirmAbove != "" {
+		confirmAbove, err = filecoin.ParseFIL(args.ConfirmAbove)
+		if err != nil {
+			return err
+		}
+	}
+	var maxPrice filecoin.FIL
+	if args.MaxPrice != "" {
+		maxPrice, err = filecoin.ParseFIL(args.MaxPrice)
+		if err != nil {
+			return err
+		}
+	}
+
 	start := time.Now()
 
-	tx := nd.exch.Tx(ctx, exchange.WithRoot(c), exchange.WithStrategy(strategy), exchange.WithTriage())
+	txOpts := []exchange.TxOption{exchange.WithRoot(c), exchange.WithStrategy(strategy), exchange.WithTriage()}
+	// Content we archived is retrieved from the miners storing it if no cache has it anymore
+	if a, err := nd.pub.Index().GetArchive(c); err == nil && args.Miner == "" {
+		txOpts = append(txOpts, exchange.WithFilecoinFallback(a.Miners()...))
+	}
+	tx := nd.exch.Tx(ctx, txOpts...)
 	defer tx.Close()
 	var sl ipld.Node
 	if args.Key != "" {
@@ -696,145 +1509,1298 @@ func (nd *node) get(ctx context.Context, c cid.Cid, args *GetArgs) error {
 		}
 	}
 
-	// Triage waits until we select the first offer it might not mean the first
-	// offer that we receive depending on the strategy used
-	selection, err := tx.Triage()
-	if err != nil {
-		return err
+	var selection exchange.DealSelection
+	var est deal.CostEstimate
+	declined := 0
+	for {
+		// Triage waits until we select the first offer it might not mean the first
+		// offer that we receive depending on the strategy used
+		selection, err = tx.Triage()
+		if err != nil {
+			if declined > 0 {
+				return fmt.Errorf("%w: declined %d offers above %s FIL", exchange.ErrOfferTooExpensive, declined, args.MaxPrice)
+			}
+			return err
+		}
+		est, err = tx.EstimateCost(selection.Offer)
+		if err != nil {
+			selection.Decline()
+			return err
+		}
+		if args.MaxPrice == "" || !est.Total().GreaterThan(filecoin.BigInt(maxPrice)) {
+			break
+		}
+		// The strategy moves on to the next offer if any
+		selection.Decline()
+		declined++
 	}
 	now := time.Now()
 	discDuration := now.Sub(start)
 	resp := selection.Offer.Response
 
-	// TODO: accept all by default but we should be able to pass flag to provide
-	// confirmation before retrieving
+	// Expensive retrievals must be explicitly accepted by the client
+	if args.ConfirmAbove != "" && est.Total().GreaterThan(filecoin.BigInt(confirmAbove)) {
+		nd.sendTo(ctx, Notify{
+			GetResult: &GetResult{
+				TotalPrice:     filecoin.FIL(est.Transfer).Short(),
+				PricePerByte:   filecoin.FIL(resp.MinPricePerByte).Short(),
+				UnsealPrice:    filecoin.FIL(resp.UnsealPrice).Short(),
+				PieceSize:      filecoin.SizeStr(filecoin.NewInt(resp.Size)),
+				GasPrice:       filecoin.FIL(est.Gas).Short(),
+				EstimatedTotal: filecoin.FIL(est.Total()).Short(),
+				NeedsConfirm:   true,
+			},
+		})
+		if !nd.waitConfirm(ctx, c) {
+			selection.Decline()
+			return fmt.Errorf("%w: %v above %s FIL", exchange.ErrOfferTooExpensive, exchange.ErrUserDeniedOffer, args.ConfirmAbove)
+		}
+	}
 	selection.Incline()
 
 	var dref exchange.DealRef
 	select {
 	case dref = <-tx.Ongoing():
 	case <-ctx.Done():
-		return ctx.Err()
+		return tx.RetrievalErr()
 	}
 
-	nd.send(Notify{
+	nd.sendTo(ctx, Notify{
 		GetResult: &GetResult{
-			DealID:       dref.ID.String(),
-			TotalPrice:   filecoin.FIL(resp.PieceRetrievalPrice()).Short(),
-			PricePerByte: filecoin.FIL(resp.MinPricePerByte).Short(),
-			UnsealPrice:  filecoin.FIL(resp.UnsealPrice).Short(),
-			PieceSize:    filecoin.SizeStr(filecoin.NewInt(resp.Size)),
+			DealID:         dref.ID.String(),
+			TotalPrice:     filecoin.FIL(resp.PieceRetrievalPrice()).Short(),
+			PricePerByte:   filecoin.FIL(resp.MinPricePerByte).Short(),
+			UnsealPrice:    filecoin.FIL(resp.UnsealPrice).Short(),
+			PieceSize:      filecoin.SizeStr(filecoin.NewInt(resp.Size)),
+			GasPrice:       filecoin.FIL(est.Gas).Short(),
+			EstimatedTotal: filecoin.FIL(est.Total()).Short(),
 		},
 	})
 
-	select {
-	case res := <-tx.Done():
-		if res.Err != nil {
-			return res.Err
-		}
-		tx.Close()
-		end := time.Now()
-		transDuration := end.Sub(start) - discDuration
-		if args.Out != "" {
-			f, err := tx.GetFile(args.Key)
-			if err != nil {
-				return err
+	// Report the transfer progress at most a few times per second
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var prog *GetProgress
+	for {
+		select {
+		case pe := <-tx.Progress():
+			prog = &GetProgress{
+				Received: pe.Received,
+				Size:     pe.Size,
+				Blocks:   pe.Blocks,
+				Vouchers: pe.Vouchers,
+				Provider: pe.Provider.String(),
+				Spent:    filecoin.FIL(pe.Spent).Short(),
+			}
+		case <-ticker.C:
+			if prog != nil {
+				nd.sendTo(ctx, Notify{GetResult: &GetResult{Progress: prog}})
+				prog = nil
+			}
+		case res := <-tx.Done():
+			if res.Err != nil {
+				return res.Err
 			}
-			err = files.WriteTo(f, args.Out)
+			tx.Close()
+			end := time.Now()
+			transDuration := end.Sub(start) - discDuration
+			if args.Out != "" {
+				f, err := tx.GetFile(args.Key)
+				if err != nil {
+					return err
+				}
+				err = writeOutput(f, args.Out, outputName(c, args.Key))
+				if err != nil {
+					return err
+				}
+			}
+			// Register new blocks in our supply by default
+			err = nd.exch.Index().SetRef(&exchange.DataRef{
+				PayloadCID:  c,
+				StoreID:     tx.StoreID(),
+				PayloadSize: int64(res.Size),
+			})
 			if err != nil {
 				return err
 			}
+			nd.sendTo(ctx, Notify{
+				GetResult: &GetResult{
+					DiscLatSeconds:  discDuration.Seconds(),
+					TransLatSeconds: transDuration.Seconds(),
+				},
+			})
+			return nil
+		case <-ctx.Done():
+			return tx.RetrievalErr()
+		}
+	}
+}
+
+// outputName is the name of the content written inside an output directory
+func outputName(root cid.Cid, key string) string {
+	if key != "" {
+		return key
+	}
+	return root.String()
+}
+
+// writeOutput writes retrieved files or directories to the local filesystem. If the output is an existing
+// directory the content is written inside it under the given name.
+func writeOutput(f files.Node, out string, name string) error {
+	if fi, err := os.Stat(out); err == nil && fi.IsDir() {
+		out = filepath.Join(out, name)
+	}
+	return files.WriteTo(f, out)
+}
+
+// revalidate checks we can still provide a cached root. The ref is dropped once no response reads it
+// anymore if its blocks went missing. Concurrent calls for the same root share a single check.
+func (nd *node) revalidate(ctx context.Context, root cid.Cid) error {
+	return nd.lc.do(ctx, "check/"+root.String(), func(ctx context.Context) error {
+		store, err := nd.exch.Index().GetStore(root)
+		if err == nil {
+			_, err = exchange.Stat(ctx, store, root, sel.All())
 		}
-		// Register new blocks in our supply by default
-		err = nd.exch.Index().SetRef(&exchange.DataRef{
-			PayloadCID:  c,
-			StoreID:     tx.StoreID(),
-			PayloadSize: int64(res.Size),
+		if err == nil {
+			nd.lc.validate(root, time.Now())
+			return nil
+		}
+		log.Info().Str("root", root.String()).Err(err).Msg("revalidation failed")
+		derr := nd.lc.dropWhenIdle(ctx, root, func() error {
+			return nd.exch.Index().DropRef(root)
 		})
-		if err != nil {
+		if derr != nil && !errors.Is(derr, exchange.ErrRefNotFound) {
+			log.Error().Err(derr).Msg("dropping invalid ref")
+		}
+		return err
+	})
+}
+
+// refetch retrieves a root again from the network after its revalidation failed. Concurrent calls for
+// the same root share a single retrieval.
+func (nd *node) refetch(ctx context.Context, root cid.Cid, key string) error {
+	return nd.lc.do(ctx, "fetch/"+root.String()+"/"+key, func(ctx context.Context) error {
+		if err := nd.get(ctx, root, &GetArgs{Key: key, Strategy: "SelectFirst"}); err != nil {
 			return err
 		}
-		nd.send(Notify{
-			GetResult: &GetResult{
-				DiscLatSeconds:  discDuration.Seconds(),
-				TransLatSeconds: transDuration.Seconds(),
-			},
-		})
+		nd.lc.validate(root, time.Now())
 		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	})
 }
 
 // List returns all the roots for the content stored by this node
 func (nd *node) List(ctx context.Context, args *ListArgs) {
-	list, err := nd.exch.Index().ListRefs()
-	if err != nil {
-		nd.send(Notify{
-			ListResult: &ListResult{
-				Err: err.Error(),
-			},
-		})
-		return
+	labels := make([]exchange.Label, len(args.Labels))
+	for i, l := range args.Labels {
+		label, err := exchange.ParseLabel(l)
+		if err != nil {
+			nd.sendTo(ctx, Notify{
+				ListResult: &ListResult{
+					Err: err.Error(),
+				},
+			})
+			return
+		}
+		labels[i] = label
+	}
+	var list []exchange.DataRef
+	for _, ref := range nd.exch.Index().Snapshot() {
+		if ref.HasLabels(labels...) {
+			list = append(list, ref)
+		}
 	}
 	if len(list) == 0 {
-		nd.send(Notify{
+		msg := "no refs stored"
+		if len(labels) > 0 {
+			msg = "no refs with the given labels"
+		}
+		nd.sendTo(ctx, Notify{
 			ListResult: &ListResult{
-				Err: "no refs stored",
+				Err: msg,
 			},
 		})
 		return
 	}
 	for i, ref := range list {
-		nd.send(Notify{
+		nd.sendTo(ctx, Notify{
 			ListResult: &ListResult{
-				Root: ref.PayloadCID.String(),
-				Size: ref.PayloadSize,
-				Freq: ref.Freq,
-				Last: i == len(list)-1,
+				Root:     ref.PayloadCID.String(),
+				Size:     ref.PayloadSize,
+				Freq:     ref.Freq,
+				Verified: nd.exch.Index().Verified(ref.PayloadCID),
+				Labels:   ref.Labels,
+				BucketID: ref.BucketID,
+				StoreID:  uint64(ref.StoreID),
+				Created:  ref.Created,
+				Last:     i == len(list)-1,
 			},
 		})
 	}
 }
 
-// connPeers returns a list of connected peer IDs
-func (nd *node) connPeers() []peer.ID {
-	conns := nd.host.Network().Conns()
-	var out []peer.ID
-	for _, c := range conns {
-		pid := c.RemotePeer()
-		out = append(out, pid)
+// Inspect describes a ref and the structure of its DAG
+func (nd *node) Inspect(ctx context.Context, args *InspectArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			InspectResult: &InspectResult{
+				Err: err.Error(),
+			},
+		})
 	}
-	return out
+	root, err := nd.exch.Tags().Resolve(args.Cid)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	ref, err := nd.exch.Index().PeekRef(root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	store, err := nd.exch.Index().OpenStore(ref.StoreID)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	stats, err := exchange.Stat(ctx, store, root, sel.All())
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &InspectResult{
+		Root:     root.String(),
+		Size:     ref.PayloadSize,
+		Freq:     ref.Freq,
+		BucketID: ref.BucketID,
+		StoreID:  uint64(ref.StoreID),
+		Created:  ref.Created,
+		Labels:   ref.Labels,
+		DAGSize:  stats.Size,
+		Blocks:   stats.NumBlocks,
+	}
+	if ref.Publisher != "" {
+		res.Publisher = ref.Publisher.String()
+	}
+	entries, err := exchange.StatEntries(ctx, store, root)
+	if err != nil {
+		// Content imported from a CAR may not have entries
+		log.Info().Err(err).Str("root", root.String()).Msg("failed to load entries")
+	}
+	for _, e := range entries {
+		res.Entries = append(res.Entries, InspectEntry{
+			Key:    e.Key,
+			Cid:    e.Value.String(),
+			Size:   e.Size,
+			Blocks: e.NumBlocks,
+		})
+	}
+	nd.send(Notify{InspectResult: res})
 }
 
-// importAddress from a hex encoded private key to use as default on the exchange instead of
-// the auto generated one. This is mostly for development and will be reworked into a nicer command
-// eventually
-func (nd *node) importAddress(pk string) {
-	var iki wallet.KeyInfo
-	data, err := hex.DecodeString(pk)
+// Record captures the protocol messages and state transitions of the exchange in a log file which can
+// be replayed to understand the decisions of the node
+func (nd *node) Record(ctx context.Context, args *RecordArgs) {
+	err := func() error {
+		if args.Stop {
+			return nd.exch.StopRecording()
+		}
+		window := args.Window
+		if window <= 0 {
+			window = exchange.DefaultRecordWindow
+		}
+		f, err := os.Create(args.Path)
+		if err != nil {
+			return err
+		}
+		if err := nd.exch.Record(f, window); err != nil {
+			f.Close()
+			return err
+		}
+		return nil
+	}()
 	if err != nil {
-		log.Error().Err(err).Msg("hex.DecodeString(opts.PrivKey)")
+		nd.send(Notify{
+			RecordResult: &RecordResult{
+				Err: err.Error(),
+			},
+		})
+		return
 	}
-	if err := json.Unmarshal(data, &iki); err != nil {
-		log.Error().Err(err).Msg("json.Unmarshal(PrivKey)")
+	res := &RecordResult{Path: args.Path}
+	if until, ok := nd.exch.Recording(); ok {
+		res.Until = until
 	}
+	nd.send(Notify{RecordResult: res})
+}
 
-	addr, err := nd.exch.Wallet().ImportKey(context.TODO(), &iki)
+// Audit returns the entries of the audit log matching the query or summarizes the log after exporting
+// or verifying it
+func (nd *node) Audit(ctx context.Context, args *AuditArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			AuditResult: &AuditResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	al := nd.exch.Audit()
+	if args.Verify || args.Export != "" {
+		err := func() error {
+			if args.Verify {
+				_, err := al.Verify()
+				return err
+			}
+			f, err := os.Create(args.Export)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return al.Export(f)
+		}()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		seq, head := al.Head()
+		nd.send(Notify{
+			AuditResult: &AuditResult{
+				Len:  seq,
+				Head: head,
+				Path: args.Export,
+				Last: true,
+			},
+		})
+		return
+	}
+
+	q := exchange.AuditQuery{Limit: args.Limit}
+	if args.Peer != "" {
+		p, err := peer.Decode(args.Peer)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		q.Peer = p
+	}
+	if args.Root != "" {
+		root, err := nd.exch.Tags().Resolve(args.Root)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		q.Root = root
+	}
+	if args.Since > 0 {
+		q.Since = time.Now().Add(-args.Since)
+	}
+	entries, err := al.Query(q)
 	if err != nil {
-		log.Error().Err(err).Msg("Wallet.ImportKey")
-	} else {
-		fmt.Printf("==> Imported private key for %s.\n", addr.String())
-		err := nd.exch.Wallet().SetDefaultAddress(addr)
+		sendErr(err)
+		return
+	}
+	if len(entries) == 0 {
+		sendErr(errors.New("no matching retrievals"))
+		return
+	}
+	for i, e := range entries {
+		paid, err := big.FromString(e.Paid)
 		if err != nil {
-			log.Error().Err(err).Msg("Wallet.SetDefaultAddress")
+			paid = big.Zero()
+		}
+		nd.send(Notify{
+			AuditResult: &AuditResult{
+				Seq:      e.Seq,
+				Time:     time.Unix(0, e.Time),
+				Peer:     e.Peer,
+				Root:     e.Root,
+				Deal:     e.Deal,
+				Size:     filecoin.SizeStr(filecoin.NewInt(e.Bytes)),
+				Paid:     filecoin.FIL(paid).Short(),
+				Vouchers: e.Vouchers,
+				Status:   e.Status,
+				Hash:     e.Hash,
+				Last:     i == len(entries)-1,
+			},
+		})
+	}
+}
+
+// Receipts sends the signed receipts of the retrievals we paid for or exports them to a file
+func (nd *node) Receipts(ctx context.Context, args *ReceiptsArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			ReceiptsResult: &ReceiptsResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	q := exchange.ReceiptQuery{Limit: args.Limit}
+	if args.Provider != "" {
+		p, err := peer.Decode(args.Provider)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		q.Provider = p
+	}
+	if args.Root != "" {
+		root, err := nd.exch.Tags().Resolve(args.Root)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		q.Root = root
+	}
+	if args.Since > 0 {
+		q.Since = time.Now().Add(-args.Since)
+	}
+	rs := nd.exch.Receipts()
+	if args.Export != "" {
+		err := func() error {
+			f, err := os.Create(args.Export)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return rs.Export(f, q)
+		}()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		receipts, err := rs.Query(q)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		nd.send(Notify{
+			ReceiptsResult: &ReceiptsResult{
+				Len:  len(receipts),
+				Path: args.Export,
+				Last: true,
+			},
+		})
+		return
+	}
+	receipts, err := rs.Query(q)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if len(receipts) == 0 {
+		sendErr(errors.New("no matching receipts"))
+		return
+	}
+	for i, r := range receipts {
+		paid, err := big.FromString(r.Paid)
+		if err != nil {
+			paid = big.Zero()
+		}
+		nd.send(Notify{
+			ReceiptsResult: &ReceiptsResult{
+				Time:     time.Unix(0, r.Time),
+				Provider: r.Provider,
+				Root:     r.Root,
+				Deal:     r.Deal,
+				Size:     filecoin.SizeStr(filecoin.NewInt(r.Bytes)),
+				Paid:     filecoin.FIL(paid).Short(),
+				Vouchers: r.Vouchers,
+				Last:     i == len(receipts)-1,
+			},
+		})
+	}
+}
+
+// Channels sends the balance of the payment channels of the exchange and of the publisher if separate
+func (nd *node) Channels(ctx context.Context, args *ChannelsArgs) {
+	balances, err := nd.exch.Payments().Balances()
+	if err == nil && nd.pub != nd.exch {
+		var pb []payments.ChannelBalance
+		pb, err = nd.pub.Payments().Balances()
+		balances = append(balances, pb...)
+	}
+	if err == nil && len(balances) == 0 {
+		err = errors.New("no payment channels")
+	}
+	if err != nil {
+		nd.send(Notify{
+			ChannelsResult: &ChannelsResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	for i, b := range balances {
+		dir := "outbound"
+		if b.Direction == payments.DirInbound {
+			dir = "inbound"
+		}
+		nd.send(Notify{
+			ChannelsResult: &ChannelsResult{
+				Channel:         b.Channel.String(),
+				Direction:       dir,
+				Peer:            b.Target.String(),
+				Amount:          filecoin.FIL(b.Amount).Short(),
+				Vouchered:       filecoin.FIL(b.Vouchered).Short(),
+				Pending:         filecoin.FIL(b.Pending).Short(),
+				PendingVouchers: b.PendingVouchers,
+				Lanes:           b.Lanes,
+				Settling:        b.Settling,
+				Last:            i == len(balances)-1,
+			},
+		})
+	}
+}
+
+// Config updates a setting of the running node or reloads the config file. Settings which only apply when
+// pop starts are reported in the result.
+func (nd *node) Config(ctx context.Context, args *ConfigArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			ConfigResult: &ConfigResult{
+				Key: args.Key,
+				Err: err.Error(),
+			},
+		})
+	}
+	if args.Reload {
+		applied, restart, err := nd.reloadConfig()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		nd.send(Notify{
+			ConfigResult: &ConfigResult{
+				Applied: applied,
+				Restart: restart,
+			},
+		})
+		return
+	}
+	nd.confmu.Lock()
+	live, err := nd.setConfigKey(args.Key, args.Value)
+	nd.confmu.Unlock()
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	res := &ConfigResult{
+		Key:   args.Key,
+		Value: args.Value,
+	}
+	if live {
+		res.Applied = []string{args.Key}
+	} else {
+		res.Restart = []string{args.Key}
+	}
+	nd.send(Notify{ConfigResult: res})
+}
+
+// DefaultBandwidthTop is the number of peers and refs returned by the Bandwidth command by default
+const DefaultBandwidthTop = 10
+
+// Bandwidth sends the bytes sent and received with the peers and for the refs which transferred the most
+func (nd *node) Bandwidth(ctx context.Context, args *BandwidthArgs) {
+	if nd.bw == nil {
+		nd.send(Notify{BandwidthResult: &BandwidthResult{Err: "bandwidth isn't metered"}})
+		return
+	}
+	top := args.Top
+	if top <= 0 {
+		top = DefaultBandwidthTop
+	}
+	stats := nd.bw.Stats(top)
+	entries := func(bs []metrics.BandwidthStat) []BandwidthEntry {
+		res := make([]BandwidthEntry, len(bs))
+		for i, b := range bs {
+			res[i] = BandwidthEntry{
+				ID:       b.ID,
+				Sent:     b.Sent,
+				Received: b.Received,
+			}
+		}
+		return res
+	}
+	nd.send(Notify{
+		BandwidthResult: &BandwidthResult{
+			Sent:              stats.Sent,
+			Received:          stats.Received,
+			UploadLimit:       stats.Limits.Upload,
+			DownloadLimit:     stats.Limits.Download,
+			PeerUploadLimit:   stats.Limits.PeerUpload,
+			PeerDownloadLimit: stats.Limits.PeerDownload,
+			Peers:             entries(stats.Peers),
+			Refs:              entries(stats.Refs),
+		},
+	})
+}
+
+// Log sets the log level of a subsystem and sends the level of every subsystem
+func (nd *node) Log(ctx context.Context, args *LogArgs) {
+	if args.Module != "" {
+		if err := logging.SetLevel(args.Module, args.Level); err != nil {
+			nd.send(Notify{LogResult: &LogResult{Err: err.Error()}})
+			return
+		}
+	}
+	nd.send(Notify{LogResult: &LogResult{Levels: logging.Levels()}})
+}
+
+// DefaultStatsHours and DefaultStatsDays are the number of buckets returned by the Stats command by default
+const (
+	DefaultStatsHours = 24
+	DefaultStatsDays  = 7
+)
+
+// Stats returns the reads of a root bucketed by hour and by day
+func (nd *node) Stats(ctx context.Context, args *StatsArgs) {
+	sendErr := func(err error) {
+		nd.sendTo(ctx, Notify{
+			StatsResult: &StatsResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	root, err := nd.exch.Tags().Resolve(args.Root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	idx := nd.exch.Index()
+	stats, err := idx.AccessStats(root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	hours, days := args.Hours, args.Days
+	if hours <= 0 {
+		hours = DefaultStatsHours
+	}
+	if days <= 0 {
+		days = DefaultStatsDays
+	}
+	buckets := func(bs []exchange.AccessBucket) []StatsBucket {
+		res := make([]StatsBucket, len(bs))
+		for i, b := range bs {
+			res[i] = StatsBucket{
+				Start:  time.Unix(b.Start, 0),
+				Local:  b.Local,
+				Remote: b.Remote,
+			}
+		}
+		return res
+	}
+	now := time.Now()
+	res := &StatsResult{
+		Root:   root.String(),
+		Hourly: buckets(stats.Hours(hours, now)),
+		Daily:  buckets(stats.Days(days, now)),
+	}
+	// Peeking doesn't count as a read
+	if ref, err := idx.PeekRef(root); err == nil {
+		res.Stored = true
+		res.Freq = ref.Freq
+	}
+	nd.sendTo(ctx, Notify{StatsResult: res})
+}
+
+// ACL shows, sets, deletes or lists the access rules deciding which peers may retrieve our refs
+func (nd *node) ACL(ctx context.Context, args *ACLArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			ACLResult: &ACLResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	sendACL := func(r exchange.RefACL, last bool) {
+		nd.send(Notify{
+			ACLResult: &ACLResult{
+				Root:       r.Root.String(),
+				Visibility: string(r.Visibility),
+				Allow:      peerStrings(r.Allow),
+				Deny:       peerStrings(r.Deny),
+				Last:       last,
+			},
+		})
+	}
+	acl := nd.exch.ACL()
+	if args.List {
+		list, err := acl.List()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if len(list) == 0 {
+			nd.send(Notify{ACLResult: &ACLResult{Last: true}})
+			return
+		}
+		for i, r := range list {
+			sendACL(r, i == len(list)-1)
+		}
+		return
+	}
+
+	root, err := nd.exch.Tags().Resolve(args.Root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if args.Delete {
+		if err := acl.Delete(root); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	r, err := acl.Get(root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if args.Delete || (args.Visibility == "" && len(args.Allow) == 0 && len(args.Deny) == 0) {
+		sendACL(r, true)
+		return
+	}
+	if args.Visibility != "" {
+		if r.Visibility, err = exchange.ParseVisibility(args.Visibility); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	if len(args.Allow) > 0 {
+		if r.Allow, err = ParsePeers(strings.Join(args.Allow, ",")); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	if len(args.Deny) > 0 {
+		if r.Deny, err = ParsePeers(strings.Join(args.Deny, ",")); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	if err := acl.Set(r); err != nil {
+		sendErr(err)
+		return
+	}
+	sendACL(r, true)
+}
+
+// Tag points a name to a root, atomically moves it if an expected root is given, deletes it or lists
+// all the tags
+func (nd *node) Tag(ctx context.Context, args *TagArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			TagResult: &TagResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	tags := nd.exch.Tags()
+	if args.List {
+		list, err := tags.List()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		if len(list) == 0 {
+			nd.send(Notify{TagResult: &TagResult{Last: true}})
+			return
+		}
+		for i, t := range list {
+			nd.send(Notify{
+				TagResult: &TagResult{
+					Name: t.Name,
+					Root: t.Root.String(),
+					Time: t.Time,
+					Last: i == len(list)-1,
+				},
+			})
+		}
+		return
+	}
+
+	var prev string
+	if t, err := tags.Get(args.Name); err == nil {
+		prev = t.Root.String()
+	}
+	if args.Delete {
+		if err := tags.Delete(args.Name); err != nil {
+			sendErr(err)
+			return
+		}
+		nd.send(Notify{TagResult: &TagResult{Name: args.Name, Prev: prev, Last: true}})
+		return
+	}
+	root, err := tags.Resolve(args.Root)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	if args.Expect != "" {
+		from, err := cid.Decode(args.Expect)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		err = tags.Move(args.Name, from, root)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		prev = from.String()
+	} else if err := tags.Set(args.Name, root); err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		TagResult: &TagResult{
+			Name: args.Name,
+			Root: root.String(),
+			Time: time.Now(),
+			Prev: prev,
+			Last: true,
+		},
+	})
+}
+
+// Peers returns the state of the peers we share a region with, best first, followed by the reputation of
+// the other providers we retrieved from
+func (nd *node) Peers(ctx context.Context, args *PeersArgs) {
+	states := nd.exch.Peers()
+	scores := nd.exch.PeerScores()
+	if len(states) == 0 && len(scores) == 0 {
+		nd.send(Notify{
+			PeersResult: &PeersResult{
+				Err: "no known peers yet",
+			},
+		})
+		return
+	}
+	rep := nd.exch.Reputation()
+	results := make([]*PeersResult, 0, len(states)+len(scores))
+	tracked := make(map[peer.ID]bool, len(states))
+	for _, st := range states {
+		tracked[st.ID] = true
+		res := peersResult(rep.Score(st.ID))
+		res.Tracked = true
+		for _, r := range st.Regions {
+			res.Regions = append(res.Regions, r.Name)
+		}
+		res.Protocols = st.Protocols
+		res.LatencySeconds = st.Latency.Seconds()
+		res.Responsiveness = st.Score()
+		res.Responses = st.Responses
+		res.Unanswered = st.Failures
+		res.Strikes = st.Strikes
+		res.LastSeen = st.LastSeen
+		results = append(results, res)
+	}
+	// Providers we retrieved from outside of our regions are listed after the peers we track
+	for _, ps := range scores {
+		if !tracked[ps.Provider] {
+			results = append(results, peersResult(ps))
+		}
+	}
+	for i, res := range results {
+		res.Last = i == len(results)-1
+		nd.send(Notify{PeersResult: res})
+	}
+}
+
+// peersResult returns the reputation of a provider as a peers result
+func peersResult(ps exchange.PeerScore) *PeersResult {
+	return &PeersResult{
+		ID:         ps.Provider.String(),
+		Score:      ps.Score(),
+		Successes:  ps.Successes,
+		Failures:   ps.Failures,
+		Disputes:   ps.Disputes,
+		Throughput: filecoin.SizeStr(filecoin.NewInt(uint64(ps.Throughput()))) + "/s",
+	}
+}
+
+// Publishers returns how much of the cache is used by the content of each publisher, largest first
+func (nd *node) Publishers(ctx context.Context, args *PublishersArgs) {
+	usage := nd.exch.Publishers()
+	if len(usage) == 0 {
+		nd.send(Notify{
+			PublishersResult: &PublishersResult{
+				Err: "no content from publishers",
+			},
+		})
+		return
+	}
+	limit := nd.exch.Index().PublisherCap()
+	for i, u := range usage {
+		share := 0.0
+		if limit > 0 {
+			share = float64(u.Size) / float64(limit)
+		}
+		nd.send(Notify{
+			PublishersResult: &PublishersResult{
+				ID:    u.Publisher.String(),
+				Refs:  u.Refs,
+				Size:  filecoin.SizeStr(filecoin.NewInt(u.Size)),
+				Share: share,
+				Last:  i == len(usage)-1,
+			},
+		})
+	}
+}
+
+// Categories returns how much of its quota each category of content uses, largest quota first
+func (nd *node) Categories(ctx context.Context, args *CategoriesArgs) {
+	usage := nd.exch.Categories()
+	if len(usage) == 0 {
+		nd.send(Notify{
+			CategoriesResult: &CategoriesResult{
+				Err: "capacity isn't partitioned between categories",
+			},
+		})
+		return
+	}
+	for i, u := range usage {
+		share := 0.0
+		if limit := nd.exch.Index().CategoryCap(u.Category); limit > 0 {
+			share = float64(u.Size) / float64(limit)
+		}
+		nd.send(Notify{
+			CategoriesResult: &CategoriesResult{
+				Category: u.Category,
+				Refs:     u.Refs,
+				Size:     filecoin.SizeStr(filecoin.NewInt(u.Size)),
+				Quota:    u.Quota,
+				Share:    share,
+				Last:     i == len(usage)-1,
+			},
+		})
+	}
+}
+
+// Lease purchases a lease from a provider so it keeps the content until the lease expires
+func (nd *node) Lease(ctx context.Context, args *LeaseArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			LeaseResult: &LeaseResult{
+				Err:  err.Error(),
+				Code: exchange.ErrorCode(err),
+			},
+		})
+	}
+	root, err := nd.exch.Tags().Resolve(args.Cid)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	p, err := peer.Decode(args.Peer)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	d, err := time.ParseDuration(args.Duration)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	expiry, err := nd.pub.Lease(ctx, p, root, d)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		LeaseResult: &LeaseResult{
+			Root:   root.String(),
+			Peer:   p.String(),
+			Expiry: expiry.Format(time.RFC3339),
+			Last:   true,
+		},
+	})
+}
+
+// Leases returns the content this node stores under lease
+func (nd *node) Leases(ctx context.Context, args *LeasesArgs) {
+	refs := nd.exch.Index().Leased()
+	if len(refs) == 0 {
+		nd.send(Notify{
+			LeaseResult: &LeaseResult{
+				Err: "no leases",
+			},
+		})
+		return
+	}
+	for i, ref := range refs {
+		nd.send(Notify{
+			LeaseResult: &LeaseResult{
+				Root:   ref.PayloadCID.String(),
+				Size:   ref.PayloadSize,
+				Expiry: time.Unix(ref.LeaseExpiry, 0).Format(time.RFC3339),
+				Last:   i == len(refs)-1,
+			},
+		})
+	}
+}
+
+// Search returns the content we store or know about with entry names or publishers matching the query
+func (nd *node) Search(ctx context.Context, args *SearchArgs) {
+	results, err := nd.exch.Search(args.Query)
+	if err != nil {
+		nd.send(Notify{
+			SearchResult: &SearchResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	if len(results) == 0 {
+		nd.send(Notify{
+			SearchResult: &SearchResult{
+				Err: "no content found",
+			},
+		})
+		return
+	}
+	for i, res := range results {
+		nd.send(Notify{
+			SearchResult: &SearchResult{
+				Root:   res.Root.String(),
+				Size:   res.Size,
+				Keys:   res.Keys,
+				Cached: res.Cached,
+				Last:   i == len(results)-1,
+			},
+		})
+	}
+}
+
+// Warm feeds demand hints from an external system into the index so popular content is kept longer
+// and content we don't have yet is retrieved the next time the index is refreshed
+func (nd *node) Warm(ctx context.Context, args *WarmArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			WarmResult: &WarmResult{
+				Err:  err.Error(),
+				Code: exchange.ErrorCode(err),
+			},
+		})
+	}
+	hints := make([]exchange.DemandHint, len(args.Hints))
+	for i, h := range args.Hints {
+		root, err := cid.Parse(h.Cid)
+		if err != nil {
+			sendErr(fmt.Errorf("invalid cid %s: %w", h.Cid, err))
+			return
+		}
+		hints[i] = exchange.DemandHint{Root: root, Weight: h.Weight}
+	}
+	cached, interest, err := nd.exch.Index().AddDemand(hints)
+	if err != nil {
+		sendErr(err)
+		return
+	}
+	nd.send(Notify{
+		WarmResult: &WarmResult{
+			Cached:   cached,
+			Interest: interest,
+		},
+	})
+}
+
+// IndexExport writes a snapshot of the index or of the demand for our content to a file so it can be
+// imported on a different node
+func (nd *node) IndexExport(ctx context.Context, args *IndexExportArgs) {
+	err := func() error {
+		f, err := os.Create(args.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if args.Demand {
+			return nd.exch.Index().ExportDemand(f)
+		}
+		return nd.exch.Index().Export(f)
+	}()
+	nd.sendIndexResult(args.Path, err)
+}
+
+// IndexImport loads the refs from an index snapshot file or the interest from a demand snapshot
+func (nd *node) IndexImport(ctx context.Context, args *IndexImportArgs) {
+	err := func() error {
+		f, err := os.Open(args.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if args.Demand {
+			_, err := nd.exch.Index().ImportDemand(f, args.Weight)
+			return err
+		}
+		return nd.exch.Index().Import(f)
+	}()
+	nd.sendIndexResult(args.Path, err)
+}
+
+func (nd *node) sendIndexResult(path string, err error) {
+	if err != nil {
+		nd.send(Notify{
+			IndexResult: &IndexResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	idx := nd.exch.Index()
+	nd.send(Notify{
+		IndexResult: &IndexResult{
+			Path:     path,
+			Refs:     idx.Len(),
+			Interest: idx.InterestLen(),
+		},
+	})
+}
+
+// GC deletes the stores no ref points to or only reports them if it is a dry run
+func (nd *node) GC(ctx context.Context, args *GCArgs) {
+	idx := nd.exch.Index()
+	gc := idx.GC
+	if args.DryRun {
+		gc = idx.Orphans
+	}
+	report, err := gc(ctx)
+	if err != nil {
+		nd.send(Notify{
+			GCResult: &GCResult{
+				Err: err.Error(),
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		GCResult: &GCResult{
+			Stores:    len(report.Stores),
+			Reclaimed: filecoin.SizeStr(filecoin.NewInt(report.Reclaimed)),
+			DryRun:    args.DryRun,
+		},
+	})
+}
+
+// Usage reports the gateway usage of each tenant so operators can bill them
+func (nd *node) Usage(ctx context.Context, args *UsageArgs) {
+	if nd.tenants == nil {
+		nd.send(Notify{
+			UsageResult: &UsageResult{
+				Err: "gateway has no tenants",
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		UsageResult: &UsageResult{
+			Tenants: nd.tenants.all(),
+		},
+	})
+}
+
+// Track follows the roots a mutable name points to so the content we cache under it is kept up to date
+func (nd *node) Track(ctx context.Context, args *TrackArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			TrackResult: &TrackResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	if args.Name != "" {
+		if args.Untrack {
+			if err := nd.exch.Untrack(args.Name); err != nil {
+				sendErr(err)
+				return
+			}
+		} else if _, err := nd.exch.Track(ctx, args.Name); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	var names []NameBinding
+	for _, b := range nd.exch.Names() {
+		names = append(names, NameBinding{
+			Name:    b.Name,
+			Root:    b.Root.String(),
+			Checked: time.Unix(b.Checked, 0),
+		})
+	}
+	nd.send(Notify{
+		TrackResult: &TrackResult{
+			Names: names,
+		},
+	})
+}
+
+// connPeers returns a list of connected peer IDs
+func (nd *node) connPeers() []peer.ID {
+	conns := nd.host.Network().Conns()
+	var out []peer.ID
+	for _, c := range conns {
+		pid := c.RemotePeer()
+		out = append(out, pid)
+	}
+	return out
+}
+
+// importAddress from a hex encoded private key to use as default on the exchange instead of
+// the auto generated one. This is mostly for development and will be reworked into a nicer command
+// eventually
+func (nd *node) importAddress(pk string) {
+	var iki wallet.KeyInfo
+	data, err := hex.DecodeString(pk)
+	if err != nil {
+		log.Error().Err(err).Msg("hex.DecodeString(opts.PrivKey)")
+	}
+	if err := json.Unmarshal(data, &iki); err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(PrivKey)")
+	}
+
+	addr, err := nd.exch.Wallet().ImportKey(context.TODO(), &iki)
+	if err != nil {
+		log.Error().Err(err).Msg("Wallet.ImportKey")
+	} else {
+		log.Info().Str("address", addr.String()).Msg("imported private key")
+		err := nd.exch.Wallet().SetDefaultAddress(addr)
+		if err != nil {
+			log.Error().Err(err).Msg("Wallet.SetDefaultAddress")
+		}
+	}
+}
+
+// newArchive records the storage deals of a receipt. The piece is only set if it was computed for the
+// same root.
+func newArchive(ref *exchange.DataRef, piece *PieceRef, rcpt *storage.Receipt, d time.Duration) *exchange.Archive {
+	a := &exchange.Archive{
+		Root:        ref.PayloadCID,
+		PayloadSize: ref.PayloadSize,
+		Created:     time.Now(),
+		Duration:    d,
+	}
+	if piece != nil && piece.Root == ref.PayloadCID {
+		a.PieceCID = piece.CID
+		a.PieceSize = piece.PieceSize
+	}
+	// Receipts list the deal proposals in the same order as the miners
+	for i, p := range rcpt.DealRefs {
+		if i >= len(rcpt.Miners) {
+			break
 		}
+		a.Deals = append(a.Deals, exchange.ArchiveDeal{Miner: rcpt.Miners[i], Proposal: p})
 	}
+	return a
 }
 
 // PieceRef contains Filecoin metadata about a storage piece
 type PieceRef struct {
+	// Root is the root of the DAG the piece was made from
+	Root        cid.Cid
 	CID         cid.Cid
 	PayloadSize int64
 	PieceSize   abi.PaddedPieceSize
@@ -860,6 +2826,7 @@ func (nd *node) archive(ctx context.Context, DAG ipldformat.DAGService, root cid
 	}
 
 	return &PieceRef{
+		Root:        root,
 		CID:         dataCIDSize.PieceCID,
 		PayloadSize: dataCIDSize.PayloadSize,
 		PieceSize:   dataCIDSize.PieceSize,
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// PricingKeys are the config keys setting the retrieval pricing policy
+var PricingKeys = []string{
+	"pricing.price",
+	"pricing.free-below",
+	"pricing.free-peers",
+	"pricing.max-load",
+	"pricing.max-load-multiplier",
+}
+
+// SetPricingKey parses the value of a pricing config key into the config. An empty value resets the key
+// to its default.
+func SetPricingKey(cfg *exchange.PricingConfig, key, value string) error {
+	switch key {
+	case "pricing.price":
+		// Prices are given per GiB like leases and contracts
+		if value == "" {
+			cfg.PricePerByte = filecoin.BigInt{}
+			return nil
+		}
+		price, err := filecoin.ParseFIL(value)
+		if err != nil {
+			return err
+		}
+		cfg.PricePerByte = filecoin.BigDiv(filecoin.BigInt(price), filecoin.NewInt(1<<30))
+	case "pricing.free-below":
+		if value == "" {
+			cfg.FreeBelow = 0
+			return nil
+		}
+		size, err := units.FromHumanSize(value)
+		if err != nil {
+			return err
+		}
+		cfg.FreeBelow = uint64(size)
+	case "pricing.free-peers":
+		cfg.FreePeers = nil
+		for _, s := range strings.Split(value, ",") {
+			if s == "" {
+				continue
+			}
+			p, err := peer.Decode(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			cfg.FreePeers = append(cfg.FreePeers, p)
+		}
+	case "pricing.max-load":
+		if value == "" {
+			cfg.MaxLoad = 0
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		cfg.MaxLoad = n
+	case "pricing.max-load-multiplier":
+		if value == "" {
+			cfg.MaxLoadMultiplier = 0
+			return nil
+		}
+		m, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		cfg.MaxLoadMultiplier = m
+	default:
+		return fmt.Errorf("unknown pricing key %q, expected one of %s", key, strings.Join(PricingKeys, ", "))
+	}
+	return nil
+}
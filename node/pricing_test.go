@@ -0,0 +1,41 @@
+package node
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPricingKey(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+
+	var cfg exchange.PricingConfig
+	require.NoError(t, SetPricingKey(&cfg, "pricing.price", "1073741824attoFIL"))
+	require.Equal(t, abi.NewTokenAmount(1), cfg.PricePerByte)
+	require.NoError(t, SetPricingKey(&cfg, "pricing.free-below", "1MB"))
+	require.Equal(t, uint64(1000000), cfg.FreeBelow)
+	require.NoError(t, SetPricingKey(&cfg, "pricing.free-peers", pid.String()))
+	require.Equal(t, []peer.ID{pid}, cfg.FreePeers)
+	require.NoError(t, SetPricingKey(&cfg, "pricing.max-load", "8"))
+	require.Equal(t, 8, cfg.MaxLoad)
+	require.NoError(t, SetPricingKey(&cfg, "pricing.max-load-multiplier", "2.5"))
+	require.Equal(t, 2.5, cfg.MaxLoadMultiplier)
+
+	// Empty values reset the defaults
+	require.NoError(t, SetPricingKey(&cfg, "pricing.price", ""))
+	require.True(t, cfg.PricePerByte.Nil())
+	require.NoError(t, SetPricingKey(&cfg, "pricing.free-peers", ""))
+	require.Len(t, cfg.FreePeers, 0)
+
+	require.Error(t, SetPricingKey(&cfg, "pricing.free-peers", "notapeer"))
+	require.Error(t, SetPricingKey(&cfg, "pricing.max-load", "many"))
+	require.Error(t, SetPricingKey(&cfg, "pricing.unknown", "1"))
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/exchange"
+)
+
+// ParseCanaries parses a list of CIDs separated by commas the prober retrieves to measure the network health
+func ParseCanaries(list string) ([]cid.Cid, error) {
+	var roots []cid.Cid
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		root, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary %s: %w", s, err)
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// Probes reports the health of the network measured by retrieving canaries from random providers
+func (nd *node) Probes(ctx context.Context, args *ProbesArgs) {
+	prb := nd.exch.Prober()
+	if args.Run {
+		if _, err := prb.Probe(ctx); errors.Is(err, exchange.ErrNoCanaries) {
+			nd.send(Notify{
+				ProbesResult: &ProbesResult{
+					Err: "no canary to probe, set them with the -probe-canaries flag of pop start",
+				},
+			})
+			return
+		}
+	}
+	sli := prb.SLIs()
+	res := &ProbesResult{
+		Probes:        sli.Probes,
+		Failures:      sli.Failures,
+		SuccessRate:   sli.SuccessRate(),
+		TTFB50Seconds: sli.TTFB50.Seconds(),
+		TTFB90Seconds: sli.TTFB90.Seconds(),
+		Throughput:    sli.Throughput,
+	}
+	for _, r := range prb.Results() {
+		res.Recent = append(res.Recent, ProbeSample{
+			Root:        r.Root.String(),
+			Provider:    r.Provider.String(),
+			Time:        r.Time,
+			TTFBSeconds: r.TTFB.Seconds(),
+			Throughput:  r.Throughput(),
+			Size:        r.Size,
+			Err:         r.Err,
+		})
+	}
+	nd.send(Notify{ProbesResult: res})
+}
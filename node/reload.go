@@ -0,0 +1,322 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/go-units"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// ConfigFile is the name of the JSON file in the repo holding the flags of pop start
+const ConfigFile = "PopConfig.json"
+
+// DefaultCapacity is the storage space allocated for the node if not configured
+const DefaultCapacity = "10GB"
+
+// DefaultPublisherShare is the fraction of the capacity the content of a single publisher can use if not
+// configured
+const DefaultPublisherShare = 0.2
+
+// LiveKeys are the config keys applied to a running pop when they are set or the config file is reloaded.
+// The other keys such as the regions or the listen addresses only apply when pop starts.
+var LiveKeys = append([]string{
+	"capacity",
+	"publisher-share",
+	"bootstrap",
+	"min-peers",
+	"max-memory",
+	"max-goroutines",
+	"upload-limit",
+	"download-limit",
+	"peer-upload-limit",
+	"peer-download-limit",
+	"acl-default",
+	"acl-allow",
+	"acl-deny",
+	"acl-operators",
+}, PricingKeys...)
+
+// LiveConfig holds the settings which can change while pop is running
+type LiveConfig struct {
+	Pricing exchange.PricingConfig
+	ACL     exchange.ACLConfig
+	// Capacity is the requested capacity, it is adjusted to the capability of the node if benchmarked
+	Capacity       uint64
+	PublisherShare float64
+	Bootstrap      []peer.AddrInfo
+	MinPeers       int
+	Budget         metrics.Budget
+	Bandwidth      metrics.BandwidthLimits
+}
+
+// IsLiveKey returns whether a config key applies to a running pop
+func IsLiveKey(key string) bool {
+	for _, k := range LiveKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLiveKey parses the value of a live config key into the config. An empty value resets the key to
+// its default.
+func SetLiveKey(cfg *LiveConfig, key, value string) error {
+	if strings.HasPrefix(key, "pricing.") {
+		return SetPricingKey(&cfg.Pricing, key, value)
+	}
+	var err error
+	switch key {
+	case "capacity":
+		if value == "" {
+			value = DefaultCapacity
+		}
+		size, err := units.FromHumanSize(value)
+		if err != nil {
+			return err
+		}
+		cfg.Capacity = uint64(size)
+	case "publisher-share":
+		if value == "" {
+			cfg.PublisherShare = DefaultPublisherShare
+			return nil
+		}
+		share, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		if share < 0 || share > 1 {
+			return fmt.Errorf("publisher share must be between 0 and 1")
+		}
+		cfg.PublisherShare = share
+	case "bootstrap":
+		// The interactive setup separates the bootstrap peers with new lines
+		var addrs []string
+		for _, addr := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		cfg.Bootstrap, err = parseAddrInfos(addrs)
+	case "min-peers":
+		cfg.MinPeers = exchange.DefaultMinPeers
+		if value != "" {
+			cfg.MinPeers, err = strconv.Atoi(value)
+		}
+	case "max-memory":
+		cfg.Budget.MaxRSS = 0
+		if value != "" {
+			size, err := units.FromHumanSize(value)
+			if err != nil {
+				return err
+			}
+			cfg.Budget.MaxRSS = uint64(size)
+		}
+	case "max-goroutines":
+		cfg.Budget.MaxGoroutines = 0
+		if value != "" {
+			cfg.Budget.MaxGoroutines, err = strconv.Atoi(value)
+		}
+	case "upload-limit":
+		cfg.Bandwidth.Upload, err = parseRate(value)
+	case "download-limit":
+		cfg.Bandwidth.Download, err = parseRate(value)
+	case "peer-upload-limit":
+		cfg.Bandwidth.PeerUpload, err = parseRate(value)
+	case "peer-download-limit":
+		cfg.Bandwidth.PeerDownload, err = parseRate(value)
+	case "acl-default":
+		cfg.ACL.Default, err = exchange.ParseVisibility(value)
+	case "acl-allow":
+		cfg.ACL.Allow, err = ParsePeers(value)
+	case "acl-deny":
+		cfg.ACL.Deny, err = ParsePeers(value)
+	case "acl-operators":
+		cfg.ACL.Operators, err = ParsePeers(value)
+	default:
+		return fmt.Errorf("unknown config key %q, expected one of %s", key, strings.Join(LiveKeys, ", "))
+	}
+	return err
+}
+
+// parseRate parses a number of bytes per second such as 10MB, an empty value is unlimited
+func parseRate(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := units.FromHumanSize(value)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(size), nil
+}
+
+// readConfigFile reads the flags saved in a JSON config file formatted as they are given on the
+// command line. A missing file has no flags.
+func readConfigFile(path string) (map[string]string, error) {
+	conf := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for k, v := range values {
+		switch v := v.(type) {
+		case string:
+			conf[k] = v
+		case float64:
+			conf[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			conf[k] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s: invalid value for %s", path, k)
+		}
+	}
+	return conf, nil
+}
+
+// liveConfig returns the live settings currently applied, callers must hold the config lock
+func (nd *node) liveConfig() LiveConfig {
+	cfg := nd.live
+	// The pricing policy fills the defaults of our regions
+	cfg.Pricing = nd.exch.Pricing().Config()
+	return cfg
+}
+
+// setLiveConfig applies the live settings to the components of the node, callers must hold the config lock
+func (nd *node) setLiveConfig(cfg LiveConfig) error {
+	capacity := cfg.Capacity
+	if c := nd.capability; c != nil {
+		capacity = c.Capacity(capacity)
+	}
+	if err := nd.exch.Index().SetCapacity(capacity, cfg.PublisherShare); err != nil {
+		return err
+	}
+	nd.exch.Pricing().SetConfig(cfg.Pricing)
+	nd.exch.ACL().SetConfig(cfg.ACL)
+	nd.exch.PeerExchange().SetBootstrap(cfg.Bootstrap, cfg.MinPeers)
+	if nd.guard != nil {
+		nd.guard.SetBudget(cfg.Budget)
+	}
+	if nd.bw != nil {
+		nd.bw.SetLimits(cfg.Bandwidth)
+	}
+	nd.live = cfg
+	return nil
+}
+
+// setConfigKey applies a config key to the running node. It returns false if the key only applies when
+// pop starts, callers must hold the config lock.
+func (nd *node) setConfigKey(key, value string) (bool, error) {
+	if !IsLiveKey(key) {
+		return false, nil
+	}
+	// The resource guard only runs if pop started with a budget
+	if nd.guard == nil && (key == "max-memory" || key == "max-goroutines") {
+		return false, nil
+	}
+	cfg := nd.liveConfig()
+	if err := SetLiveKey(&cfg, key, value); err != nil {
+		return false, err
+	}
+	if err := nd.setLiveConfig(cfg); err != nil {
+		return false, err
+	}
+	if nd.conf == nil {
+		nd.conf = make(map[string]string)
+	}
+	nd.conf[key] = value
+	return true, nil
+}
+
+// reloadConfig applies the live keys of the config file which changed since it was last loaded. It
+// returns the keys applied and the changed keys which only apply when pop starts again. Keys removed from
+// the file keep their current value until pop restarts.
+func (nd *node) reloadConfig() (applied, restart []string, err error) {
+	if nd.confPath == "" {
+		return nil, nil, fmt.Errorf("no config file")
+	}
+	conf, err := readConfigFile(nd.confPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	nd.confmu.Lock()
+	defer nd.confmu.Unlock()
+	keys := make([]string, 0, len(conf))
+	for k, v := range conf {
+		if prev, ok := nd.conf[k]; !ok || prev != v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		live, err := nd.setConfigKey(k, conf[k])
+		if err != nil {
+			return applied, restart, fmt.Errorf("%s: %w", k, err)
+		}
+		if live {
+			applied = append(applied, k)
+		} else {
+			restart = append(restart, k)
+		}
+	}
+	// Restart keys are reported again until pop restarts
+	for _, k := range restart {
+		delete(conf, k)
+		if v, ok := nd.conf[k]; ok {
+			conf[k] = v
+		}
+	}
+	nd.conf = conf
+	return applied, restart, nil
+}
+
+// reloadOnHangup reloads the config file every time the process receives a SIGHUP until the context is
+// cancelled
+func (nd *node) reloadOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			applied, restart, err := nd.reloadConfig()
+			if err != nil {
+				log.Error().Err(err).Msg("reloadConfig")
+				continue
+			}
+			log.Info().Str("config", ConfigFile).Str("applied", keyList(applied)).Msg("reloaded config")
+			if len(restart) > 0 {
+				log.Warn().Str("keys", keyList(restart)).Msg("restart pop to apply the config")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// keyList formats a list of config keys
+func keyList(keys []string) string {
+	if len(keys) == 0 {
+		return "no change"
+	}
+	return strings.Join(keys, ", ")
+}
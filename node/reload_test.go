@@ -0,0 +1,120 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/exchange"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLiveKey(t *testing.T) {
+	var cfg LiveConfig
+	require.NoError(t, SetLiveKey(&cfg, "capacity", "2GB"))
+	require.Equal(t, uint64(2000000000), cfg.Capacity)
+	require.NoError(t, SetLiveKey(&cfg, "capacity", ""))
+	require.Equal(t, uint64(10000000000), cfg.Capacity)
+
+	require.NoError(t, SetLiveKey(&cfg, "publisher-share", "0.5"))
+	require.Equal(t, 0.5, cfg.PublisherShare)
+	require.Error(t, SetLiveKey(&cfg, "publisher-share", "2"))
+
+	require.NoError(t, SetLiveKey(&cfg, "min-peers", ""))
+	require.Equal(t, exchange.DefaultMinPeers, cfg.MinPeers)
+	require.NoError(t, SetLiveKey(&cfg, "max-memory", "512MB"))
+	require.Equal(t, uint64(512000000), cfg.Budget.MaxRSS)
+	require.NoError(t, SetLiveKey(&cfg, "peer-upload-limit", "1MB"))
+	require.Equal(t, uint64(1000000), cfg.Bandwidth.PeerUpload)
+	require.NoError(t, SetLiveKey(&cfg, "peer-upload-limit", ""))
+	require.Zero(t, cfg.Bandwidth.PeerUpload)
+	require.Error(t, SetLiveKey(&cfg, "upload-limit", "fast"))
+
+	require.NoError(t, SetLiveKey(&cfg, "acl-default", "private"))
+	require.Equal(t, exchange.Private, cfg.ACL.Default)
+	require.Error(t, SetLiveKey(&cfg, "acl-deny", "nope"))
+
+	require.NoError(t, SetLiveKey(&cfg, "pricing.free-below", "1MB"))
+	require.Equal(t, uint64(1000000), cfg.Pricing.FreeBelow)
+
+	// Keys only applying when pop starts can't be set live
+	require.Error(t, SetLiveKey(&cfg, "regions", "Europe"))
+	require.False(t, IsLiveKey("regions"))
+	require.True(t, IsLiveKey("pricing.price"))
+}
+
+func TestReloadConfig(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+	nd.live = LiveConfig{
+		Capacity:       1 << 30,
+		PublisherShare: DefaultPublisherShare,
+		MinPeers:       exchange.DefaultMinPeers,
+	}
+	nd.confPath = filepath.Join(t.TempDir(), ConfigFile)
+	writeConf := func(conf map[string]interface{}) {
+		data, err := json.Marshal(conf)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(nd.confPath, data, 0644))
+	}
+
+	// A missing file has nothing to apply
+	applied, restart, err := nd.reloadConfig()
+	require.NoError(t, err)
+	require.Empty(t, applied)
+	require.Empty(t, restart)
+
+	writeConf(map[string]interface{}{
+		"capacity":        "1GB",
+		"publisher-share": 0.5,
+		"acl-default":     "private",
+		"regions":         "Europe",
+		"max-memory":      "512MB",
+	})
+	applied, restart, err = nd.reloadConfig()
+	require.NoError(t, err)
+	require.Equal(t, []string{"acl-default", "capacity", "publisher-share"}, applied)
+	// The resource guard isn't running without a budget
+	require.Equal(t, []string{"max-memory", "regions"}, restart)
+	require.Equal(t, uint64(500000000), nd.exch.Index().PublisherCap())
+	require.Equal(t, exchange.Private, nd.live.ACL.Default)
+
+	// Only the changed keys are applied again
+	writeConf(map[string]interface{}{
+		"capacity":        "2GB",
+		"publisher-share": 0.5,
+		"acl-default":     "private",
+		"regions":         "Europe",
+		"max-memory":      "512MB",
+	})
+	applied, restart, err = nd.reloadConfig()
+	require.NoError(t, err)
+	require.Equal(t, []string{"capacity"}, applied)
+	require.Equal(t, []string{"max-memory", "regions"}, restart)
+	require.Equal(t, uint64(1000000000), nd.exch.Index().PublisherCap())
+
+	writeConf(map[string]interface{}{
+		"capacity": "lots",
+	})
+	_, _, err = nd.reloadConfig()
+	require.Error(t, err)
+
+	// Setting a key applies it right away if it can change while running
+	var results []*ConfigResult
+	nd.notify = func(n Notify) {
+		results = append(results, n.ConfigResult)
+	}
+	nd.Config(ctx, &ConfigArgs{Key: "pricing.free-below", Value: "1MB"})
+	nd.Config(ctx, &ConfigArgs{Key: "listen", Value: "/ip4/0.0.0.0/tcp/41505/ws"})
+	nd.Config(ctx, &ConfigArgs{Key: "min-peers", Value: "many"})
+	require.Len(t, results, 3)
+	require.Equal(t, []string{"pricing.free-below"}, results[0].Applied)
+	require.Equal(t, uint64(1000000), nd.exch.Pricing().Config().FreeBelow)
+	require.Equal(t, []string{"listen"}, results[1].Restart)
+	require.NotEmpty(t, results[2].Err)
+}
@@ -14,8 +14,8 @@ import (
 
 	"github.com/gabriel-vasile/mimetype"
 	files "github.com/ipfs/go-ipfs-files"
-	ipath "github.com/ipfs/go-path"
 	"github.com/myelnet/pop/exchange"
+	"github.com/myelnet/pop/metrics"
 	"github.com/rs/zerolog/log"
 )
 
@@ -138,29 +138,66 @@ func (s *server) addUserHeaders(w http.ResponseWriter) {
 func (s *server) getHandler(w http.ResponseWriter, r *http.Request) {
 	urlPath := r.URL.Path
 
-	parsedPath := ipath.FromString(urlPath)
-
 	// Extract the CID and file path segments
-	root, segs, err := ipath.SplitAbsPath(parsedPath)
+	p, err := exchange.ParsePath(urlPath)
 	if err != nil {
-		http.Error(w, "invalid path", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// try to retrieve the blocks
-	err = s.node.get(r.Context(), root, &GetArgs{Key: segs[0], Strategy: "SelectFirst"})
-	if err != nil {
-		fmt.Printf("ERR %s\n", err)
-		// TODO: give better feedback into what went wrong
-		http.Error(w, "Failed to retrieve content", http.StatusInternalServerError)
+	if len(p.Segments) != 1 {
+		http.Error(w, "path must point to an entry of the root", http.StatusBadRequest)
 		return
 	}
-	fnd, err := s.node.exch.Tx(r.Context(), exchange.WithRoot(root)).GetFile(segs[0])
+	root, key := p.Root, p.Key().String()
+	state := cacheMiss
+	var age time.Duration
+	if _, err := s.node.exch.Index().PeekRef(root); err == nil {
+		state, age = s.node.lc.state(root, time.Now())
+	}
+	var release func()
+	switch state {
+	case cacheExpired:
+		// content is too old to be served before we make sure it is still valid
+		if err := s.node.revalidate(r.Context(), root); err == nil {
+			break
+		}
+		state = cacheMiss
+		fallthrough
+	case cacheMiss:
+		// try to retrieve the blocks
+		err = s.node.refetch(r.Context(), root, key)
+		if err != nil {
+			log.Error().Err(err).Str("root", root.String()).Msg("retrieving content")
+			// TODO: give better feedback into what went wrong
+			http.Error(w, "Failed to retrieve content", http.StatusInternalServerError)
+			return
+		}
+	case cacheNearExpiry, cacheStale:
+		// serve the content we have right away and revalidate in the background, the content
+		// isn't dropped until we served it
+		release = s.node.lc.read(root)
+		go func(key string) {
+			ctx := context.Background()
+			if err := s.node.revalidate(ctx, root); err == nil {
+				return
+			}
+			if err := s.node.refetch(ctx, root, key); err != nil {
+				log.Error().Err(err).Str("root", root.String()).Msg("background revalidation")
+			}
+		}(key)
+	}
+	if release == nil {
+		release = s.node.lc.read(root)
+	}
+	defer release()
+	fnd, err := s.node.exch.Tx(r.Context(), exchange.WithRoot(root), exchange.WithPrefetch(exchange.DefaultPrefetchWindow)).GetFile(key)
 	if err != nil {
 		http.Error(w, "Failed to read file from store", http.StatusInternalServerError)
 		return
 	}
 
 	s.addUserHeaders(w)
+	s.node.lc.setHeaders(w, state, age)
 
 	modtime := time.Now()
 	if f, ok := fnd.(files.File); ok {
@@ -218,12 +255,71 @@ func Run(ctx context.Context, opts Options) error {
 		return fmt.Errorf("node.New: %v", err)
 	}
 
-	fmt.Printf("==> Started pop node\n")
-	fmt.Printf("==> Joined %s regions\n", opts.Regions)
+	log.Info().Strs("regions", opts.Regions).Msg("started pop node")
 	if nd.exch.IsFilecoinOnline() {
-		fmt.Printf("==> Connected to Filecoin RPC at %s\n", opts.FilEndpoint)
+		log.Info().Str("endpoint", opts.FilEndpoint).Msg("connected to Filecoin RPC")
+	}
+
+	public, local := dialableAddrs(nd.host)
+	for _, addr := range public {
+		log.Info().Str("addr", addr).Msg("dialable")
+	}
+	for _, addr := range local {
+		log.Info().Str("addr", addr).Msg("dialable on the local network")
+	}
+	if len(public) == 0 {
+		log.Warn().Msg("no public address yet, set -announce if the port is forwarded or -auto-relay behind a NAT")
+	}
+
+	if opts.StandbyOf != nil {
+		log.Info().Str("primary", opts.StandbyOf.ID.String()).Msg("standby")
 	}
 
+	if opts.ConfigPath != "" {
+		go nd.reloadOnHangup(ctx)
+	}
+
+	if opts.GatewayAddr != "" {
+		go func() {
+			if err := serveGateway(ctx, opts, nd); err != nil {
+				log.Error().Err(err).Msg("serveGateway")
+			}
+		}()
+		log.Info().Str("addr", opts.GatewayAddr).Msg("serving HTTP gateway")
+	}
+
+	if opts.MetricsAddr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, opts.MetricsAddr, nd.guard); err != nil {
+				log.Error().Err(err).Msg("metrics.Serve")
+			}
+		}()
+		log.Info().Str("addr", opts.MetricsAddr+"/metrics").Msg("serving metrics")
+	}
+
+	if opts.GRPCAddr != "" {
+		go func() {
+			if err := serveGRPC(ctx, opts, nd); err != nil {
+				log.Error().Err(err).Msg("serveGRPC")
+			}
+		}()
+		log.Info().Str("addr", opts.GRPCAddr).Msg("serving gRPC API")
+	}
+
+	if opts.AdminAddr != "" {
+		go func() {
+			if err := serveAdmin(ctx, opts, nd); err != nil {
+				log.Error().Err(err).Msg("serveAdmin")
+			}
+		}()
+		log.Info().Str("addr", opts.AdminAddr).Msg("serving admin API, run pop token for the bearer token")
+	}
+
+	return serve(ctx, listen, nd)
+}
+
+// serve executes the commands received on the listener with the given node until the context is cancelled
+func serve(ctx context.Context, listen net.Listener, nd *node) error {
 	server := &server{
 		node: nd,
 	}
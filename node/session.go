@@ -0,0 +1,207 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxSessionEvents is the number of notifications a session keeps to replay to a client reattaching
+const maxSessionEvents = 256
+
+// sessionRetention is how long a finished session can still be reattached to
+const sessionRetention = 10 * time.Minute
+
+// session records the notifications of a long running operation such as a commit or a get so a client
+// which disconnected can reattach with the session token and receive what it missed
+type session struct {
+	token string
+	op    string
+	ref   string
+	// cancel aborts the operation
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	events []Notify
+	done   bool
+	ended  time.Time
+}
+
+// record tags a notification with the session token and keeps it for replay
+func (s *session) record(n Notify) Notify {
+	n.Session = s.token
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, n)
+	if len(s.events) > maxSessionEvents {
+		s.events = s.events[len(s.events)-maxSessionEvents:]
+	}
+	return n
+}
+
+// finish marks the operation as completed
+func (s *session) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.ended = time.Now()
+}
+
+// replay returns the notifications sent so far
+func (s *session) replay() []Notify {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]Notify, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+// result describes the session in a notification
+func (s *session) result(done bool) *SessionResult {
+	return &SessionResult{
+		Token: s.token,
+		Op:    s.op,
+		Ref:   s.ref,
+		Done:  done,
+	}
+}
+
+// sessions keeps track of the operations clients can reattach to
+type sessions struct {
+	mu sync.Mutex
+	m  map[string]*session
+}
+
+// start registers a new session for an operation and forgets the sessions finished a while ago
+func (ss *sessions) start(op, ref string) *session {
+	s := &session{
+		token: newSessionToken(),
+		op:    op,
+		ref:   ref,
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.m == nil {
+		ss.m = make(map[string]*session)
+	}
+	now := time.Now()
+	for k, old := range ss.m {
+		old.mu.Lock()
+		expired := old.done && now.Sub(old.ended) > sessionRetention
+		old.mu.Unlock()
+		if expired {
+			delete(ss.m, k)
+		}
+	}
+	ss.m[s.token] = s
+	return s
+}
+
+// get returns the session for a token
+func (ss *sessions) get(token string) (*session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.m[token]
+	return s, ok
+}
+
+func newSessionToken() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails if the system has no source of randomness
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type sessionKey struct{}
+
+// withSession attaches a session to the context of an operation
+func withSession(ctx context.Context, s *session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// sessionFrom returns the session of an operation if any
+func sessionFrom(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+type requestKey struct{}
+
+// withRequest tags the notifications of a call with the given id so the caller can tell them apart
+// from the ones of other calls
+func withRequest(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey{}, id)
+}
+
+// sendTo sends a notification tagged with the request of the call and records it in the session of
+// the operation if any
+func (nd *node) sendTo(ctx context.Context, n Notify) {
+	if id, ok := ctx.Value(requestKey{}).(string); ok {
+		n.Request = id
+	}
+	if s := sessionFrom(ctx); s != nil {
+		n = s.record(n)
+	}
+	nd.send(n)
+}
+
+// runSession executes an operation in a new session. Clients learn the session token from the first
+// notification and a last notification tells them the operation is completed.
+func (nd *node) runSession(ctx context.Context, op, ref string, fn func(ctx context.Context)) {
+	s := nd.sessions.start(op, ref)
+	ctx, s.cancel = context.WithCancel(ctx)
+	nd.sendTo(ctx, Notify{
+		Session:       s.token,
+		SessionResult: s.result(false),
+	})
+	go func() {
+		defer s.cancel()
+		ctx := withSession(ctx, s)
+		fn(ctx)
+		nd.sendTo(ctx, Notify{SessionResult: s.result(true)})
+		s.finish()
+	}()
+}
+
+// Attach replays the notifications of a session to a client reattaching after a disconnection.
+// The next notifications of the operation are sent to every client as usual.
+func (nd *node) Attach(ctx context.Context, args *AttachArgs) {
+	s, ok := nd.sessions.get(args.Token)
+	if !ok {
+		nd.send(Notify{
+			SessionResult: &SessionResult{
+				Token: args.Token,
+				Err:   "session not found",
+			},
+		})
+		return
+	}
+	nd.send(Notify{
+		Session:       s.token,
+		SessionResult: s.result(false),
+	})
+	// The last event is the completion of the operation if it is done
+	for _, n := range s.replay() {
+		nd.send(n)
+	}
+}
+
+// Cancel aborts the operation of a session such as a retrieval the client was interrupted during.
+// The operation reports the cancellation and the end of the session as usual.
+func (nd *node) Cancel(ctx context.Context, args *CancelArgs) {
+	s, ok := nd.sessions.get(args.Token)
+	if !ok {
+		nd.send(Notify{
+			SessionResult: &SessionResult{
+				Token: args.Token,
+				Err:   "session not found",
+			},
+		})
+		return
+	}
+	s.cancel()
+}
@@ -0,0 +1,115 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionReattach(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var received []Notify
+	nd := &node{}
+	nd.notify = func(n Notify) {
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+	}
+	drain := func() []Notify {
+		mu.Lock()
+		defer mu.Unlock()
+		r := received
+		received = nil
+		return r
+	}
+
+	release := make(chan struct{})
+	nd.runSession(ctx, "get", "bafy", func(ctx context.Context) {
+		nd.sendTo(ctx, Notify{GetResult: &GetResult{DealID: "1"}})
+		<-release
+		nd.sendTo(ctx, Notify{GetResult: &GetResult{DealID: "1", TotalPrice: "0"}})
+	})
+
+	// The first notification gives the session token
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+	events := drain()
+	require.NotNil(t, events[0].SessionResult)
+	token := events[0].SessionResult.Token
+	require.Equal(t, "get", events[0].SessionResult.Op)
+	require.Equal(t, token, events[1].Session)
+
+	// A client reattaching receives the notifications it missed
+	close(release)
+	require.Eventually(t, func() bool {
+		s, _ := nd.sessions.get(token)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.done
+	}, time.Second, 10*time.Millisecond)
+	drain()
+
+	nd.Attach(ctx, &AttachArgs{Token: token})
+	events = drain()
+	require.Len(t, events, 4)
+	require.Equal(t, token, events[0].SessionResult.Token)
+	require.False(t, events[0].SessionResult.Done)
+	require.Equal(t, "1", events[1].GetResult.DealID)
+	require.Equal(t, "0", events[2].GetResult.TotalPrice)
+	require.True(t, events[3].SessionResult.Done)
+
+	nd.Attach(ctx, &AttachArgs{Token: "unknown"})
+	events = drain()
+	require.Len(t, events, 1)
+	require.NotEmpty(t, events[0].SessionResult.Err)
+}
+
+func TestSessionPrune(t *testing.T) {
+	var ss sessions
+	s := ss.start("commit", "")
+	for i := 0; i < maxSessionEvents+10; i++ {
+		s.record(Notify{CommResult: &CommResult{}})
+	}
+	require.Len(t, s.replay(), maxSessionEvents)
+
+	s.finish()
+	s.ended = time.Now().Add(-2 * sessionRetention)
+	ss.start("get", "")
+	_, ok := ss.get(s.token)
+	require.False(t, ok)
+}
+
+func TestSessionCancel(t *testing.T) {
+	ctx := context.Background()
+
+	tokens := make(chan string, 1)
+	nd := &node{}
+	nd.notify = func(n Notify) {
+		if sr := n.SessionResult; sr != nil && !sr.Done && sr.Token != "" && sr.Err == "" {
+			tokens <- sr.Token
+		}
+	}
+
+	aborted := make(chan error, 1)
+	nd.runSession(ctx, "get", "bafy", func(ctx context.Context) {
+		<-ctx.Done()
+		aborted <- ctx.Err()
+	})
+
+	token := <-tokens
+	nd.Cancel(ctx, &CancelArgs{Token: token})
+	select {
+	case err := <-aborted:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("operation was not cancelled")
+	}
+}
@@ -0,0 +1,401 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/exchange"
+	"github.com/rs/zerolog/log"
+)
+
+// CollectionLabel is the label holding the collection a snapshot was published under
+const CollectionLabel = "collection"
+
+// DefaultSnapshotKeep is the number of snapshots of a collection kept unless configured otherwise
+const DefaultSnapshotKeep = 3
+
+// DefaultSnapshotInterval is how often a collection is exported unless configured otherwise
+const DefaultSnapshotInterval = 24 * time.Hour
+
+// ErrCollectionNotFound is returned when asking for a collection which isn't configured
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Exporter writes a snapshot of a dataset so it can be published as a new root of a collection
+type Exporter interface {
+	// Name is the file name of the snapshot in the published DAG
+	Name() string
+	// Export writes the snapshot to w
+	Export(ctx context.Context, w io.Writer) error
+}
+
+// FileExporter snapshots a file such as a SQLite database. Databases being written to should be exported
+// with a CommandExporter running sqlite3 with the .dump command so the snapshot is consistent.
+type FileExporter struct {
+	Path string
+}
+
+// Name of the file in the snapshot
+func (e FileExporter) Name() string {
+	return filepath.Base(e.Path)
+}
+
+// Export copies the file
+func (e FileExporter) Export(ctx context.Context, w io.Writer) error {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// CommandExporter snapshots the standard output of a command such as pg_dump
+type CommandExporter struct {
+	FileName string
+	Command  string
+	Args     []string
+}
+
+// Name of the file in the snapshot
+func (e CommandExporter) Name() string {
+	return e.FileName
+}
+
+// Export runs the command and fails if it exits with an error
+func (e CommandExporter) Export(ctx context.Context, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", e.Command, err, msg)
+		}
+		return fmt.Errorf("%s: %w", e.Command, err)
+	}
+	return nil
+}
+
+// Collection is a dataset exported on a schedule and published as a new root every time. The tag named
+// after the collection always points to the latest snapshot and older snapshots expire by policy.
+type Collection struct {
+	Name     string
+	Exporter Exporter
+	Interval time.Duration
+	// Keep is the number of snapshots kept, older ones are dropped and purged from the caches
+	Keep int
+	// MaxAge expires the snapshots older than this if not zero, the latest snapshot never expires
+	MaxAge time.Duration
+	// CacheRF is the number of caches each snapshot is dispatched to
+	CacheRF int
+}
+
+// ParseCollections parses collections separated by semicolons, each formatted as key=value pairs separated
+// by commas e.g. name=users,file=/var/lib/app.db,every=1h,keep=5;name=orders,cmd=pg_dump orders,max-age=168h.
+// A collection exports either a file or the output of a command, the cmd collections can set the file name of
+// their snapshot with out=orders.sql.
+func ParseCollections(s string) ([]Collection, error) {
+	var cols []Collection
+	names := make(map[string]bool)
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		col := Collection{
+			Interval: DefaultSnapshotInterval,
+			Keep:     DefaultSnapshotKeep,
+		}
+		var file, command, out string
+		for _, kv := range strings.Split(spec, ",") {
+			l, err := exchange.ParseLabel(strings.TrimSpace(kv))
+			if err != nil {
+				return nil, err
+			}
+			v := strings.TrimSpace(l.Value)
+			switch strings.TrimSpace(l.Key) {
+			case "name":
+				col.Name = v
+			case "file":
+				file = v
+			case "cmd":
+				command = v
+			case "out":
+				out = v
+			case "every":
+				col.Interval, err = time.ParseDuration(v)
+			case "keep":
+				col.Keep, err = strconv.Atoi(v)
+			case "max-age":
+				col.MaxAge, err = time.ParseDuration(v)
+			case "rf":
+				col.CacheRF, err = strconv.Atoi(v)
+			default:
+				err = fmt.Errorf("unknown key %s", l.Key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid collection %q: %w", spec, err)
+			}
+		}
+		if err := exchange.ValidateTag(col.Name); err != nil {
+			return nil, fmt.Errorf("invalid collection name %q: %w", col.Name, err)
+		}
+		if names[col.Name] {
+			return nil, fmt.Errorf("collection %s is listed twice", col.Name)
+		}
+		names[col.Name] = true
+		switch {
+		case file != "" && command == "":
+			col.Exporter = FileExporter{Path: file}
+		case command != "" && file == "":
+			args := strings.Fields(command)
+			if out == "" {
+				out = col.Name + ".dump"
+			}
+			col.Exporter = CommandExporter{FileName: out, Command: args[0], Args: args[1:]}
+		default:
+			return nil, fmt.Errorf("collection %s must export either a file or a command", col.Name)
+		}
+		if col.Interval <= 0 || col.Keep < 1 || col.MaxAge < 0 || col.CacheRF < 0 {
+			return nil, fmt.Errorf("invalid schedule or policy for collection %s", col.Name)
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// SnapshotStatus is the state of the snapshots of a collection
+type SnapshotStatus struct {
+	Collection string
+	// Root is the latest snapshot and Time when it was published
+	Root cid.Cid
+	Time time.Time
+	// Snapshots is the number of snapshots we still store
+	Snapshots int
+	// Err is the reason the last export failed if not empty
+	Err string
+}
+
+// snapshots publishes the collections on their schedule
+type snapshots struct {
+	nd   *node
+	cols map[string]Collection
+	// dir is where exports are written before being committed
+	dir string
+
+	mu     sync.Mutex
+	status map[string]*SnapshotStatus
+	// running serializes the exports of each collection
+	running map[string]*sync.Mutex
+}
+
+func newSnapshots(nd *node, dir string, cols []Collection) *snapshots {
+	s := &snapshots{
+		nd:      nd,
+		cols:    make(map[string]Collection, len(cols)),
+		dir:     dir,
+		status:  make(map[string]*SnapshotStatus, len(cols)),
+		running: make(map[string]*sync.Mutex, len(cols)),
+	}
+	for _, col := range cols {
+		s.cols[col.Name] = col
+		s.status[col.Name] = &SnapshotStatus{Collection: col.Name}
+		s.running[col.Name] = new(sync.Mutex)
+	}
+	return s
+}
+
+// start exports every collection right away then at every interval until the context is cancelled
+func (s *snapshots) start(ctx context.Context) {
+	for _, col := range s.cols {
+		go func(col Collection) {
+			ticker := time.NewTicker(col.Interval)
+			defer ticker.Stop()
+			for {
+				if _, err := s.run(ctx, col.Name); err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Str("collection", col.Name).Msg("failed to publish snapshot")
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(col)
+	}
+}
+
+// run exports a collection, publishes the snapshot and expires the old ones
+func (s *snapshots) run(ctx context.Context, name string) (cid.Cid, error) {
+	col, ok := s.cols[name]
+	if !ok {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+	s.running[name].Lock()
+	defer s.running[name].Unlock()
+
+	root, err := s.publish(ctx, col)
+	s.mu.Lock()
+	st := s.status[name]
+	st.Err = ""
+	if err != nil {
+		st.Err = err.Error()
+	} else {
+		st.Root = root
+		st.Time = time.Now()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return cid.Undef, err
+	}
+	n, err := s.expire(ctx, col)
+	s.mu.Lock()
+	st.Snapshots = n
+	s.mu.Unlock()
+	return root, err
+}
+
+// publish commits the export of a collection and points the collection tag to it
+func (s *snapshots) publish(ctx context.Context, col Collection) (cid.Cid, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return cid.Undef, err
+	}
+	dir, err := os.MkdirTemp(s.dir, col.Name)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer os.RemoveAll(dir)
+	// The file name is the key of the snapshot in the DAG
+	path := filepath.Join(dir, col.Exporter.Name())
+	f, err := os.Create(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	err = col.Exporter.Export(ctx, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	tx := s.nd.pub.Tx(ctx)
+	defer tx.Close()
+	if err := tx.PutFile(path); err != nil {
+		return cid.Undef, err
+	}
+	tx.SetLabel(CollectionLabel, col.Name)
+	tx.SetCacheRF(col.CacheRF)
+	if err := tx.Commit(); err != nil {
+		return cid.Undef, err
+	}
+	root := tx.Root()
+	if col.CacheRF > 0 {
+		tx.WatchDispatch(func(r exchange.PRecord) {
+			log.Info().Str("collection", col.Name).Str("cache", r.Provider.String()).Msg("snapshot dispatched")
+		})
+	}
+	return root, s.nd.exch.Tags().Set(col.Name, root)
+}
+
+// expire drops the snapshots of a collection beyond the policy, newest first, and asks the caches to drop
+// them too. The latest snapshot is always kept. It returns the number of snapshots left.
+func (s *snapshots) expire(ctx context.Context, col Collection) (int, error) {
+	idx := s.nd.pub.Index()
+	refs, err := idx.ListRefs(exchange.Label{Key: CollectionLabel, Value: col.Name})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Created > refs[j].Created
+	})
+	// The tag tells which snapshot is the latest if several were created within the same second
+	if latest, err := s.nd.exch.Tags().Get(col.Name); err == nil {
+		for i, ref := range refs {
+			if ref.PayloadCID == latest.Root {
+				refs[0], refs[i] = refs[i], refs[0]
+				break
+			}
+		}
+	}
+	left := 0
+	for i, ref := range refs {
+		age := time.Since(time.Unix(ref.Created, 0))
+		if i == 0 || (i < col.Keep && (col.MaxAge == 0 || age <= col.MaxAge)) {
+			left++
+			continue
+		}
+		if err := s.nd.pub.Purge(ctx, ref.PayloadCID); err != nil {
+			log.Error().Err(err).Str("root", ref.PayloadCID.String()).Msg("failed to purge expired snapshot from caches")
+		}
+		if err := idx.DropRef(ref.PayloadCID); err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+// list returns the state of every collection sorted by name
+func (s *snapshots) list() []SnapshotStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]SnapshotStatus, 0, len(s.status))
+	for _, st := range s.status {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Collection < list[j].Collection
+	})
+	return list
+}
+
+// Snapshots lists the collections we publish or publishes a snapshot of a collection right away
+func (nd *node) Snapshots(ctx context.Context, args *SnapshotsArgs) {
+	sendErr := func(err error) {
+		nd.send(Notify{
+			SnapshotsResult: &SnapshotsResult{
+				Err: err.Error(),
+			},
+		})
+	}
+	if nd.snaps == nil || len(nd.snaps.cols) == 0 {
+		sendErr(errors.New("no collection configured, set them with the -snapshots flag of pop start"))
+		return
+	}
+	if args.Run != "" {
+		if _, err := nd.snaps.run(ctx, args.Run); err != nil {
+			sendErr(err)
+			return
+		}
+	}
+	list := nd.snaps.list()
+	for i, st := range list {
+		if args.Run != "" && st.Collection != args.Run {
+			continue
+		}
+		res := &SnapshotsResult{
+			Collection: st.Collection,
+			Snapshots:  st.Snapshots,
+			Time:       st.Time,
+			LastError:  st.Err,
+			Last:       args.Run != "" || i == len(list)-1,
+		}
+		if st.Root.Defined() {
+			res.Root = st.Root.String()
+		}
+		nd.send(Notify{SnapshotsResult: res})
+	}
+}
@@ -0,0 +1,109 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/exchange"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCollections(t *testing.T) {
+	cols, err := ParseCollections("name=users,file=/var/lib/users.db,every=1h,keep=5; name=orders,cmd=pg_dump -t orders shop,out=orders.sql,max-age=168h,rf=2")
+	require.NoError(t, err)
+	require.Len(t, cols, 2)
+
+	require.Equal(t, "users", cols[0].Name)
+	require.Equal(t, FileExporter{Path: "/var/lib/users.db"}, cols[0].Exporter)
+	require.Equal(t, "users.db", cols[0].Exporter.Name())
+	require.Equal(t, time.Hour, cols[0].Interval)
+	require.Equal(t, 5, cols[0].Keep)
+
+	require.Equal(t, CommandExporter{FileName: "orders.sql", Command: "pg_dump", Args: []string{"-t", "orders", "shop"}}, cols[1].Exporter)
+	require.Equal(t, DefaultSnapshotInterval, cols[1].Interval)
+	require.Equal(t, DefaultSnapshotKeep, cols[1].Keep)
+	require.Equal(t, 168*time.Hour, cols[1].MaxAge)
+	require.Equal(t, 2, cols[1].CacheRF)
+
+	cols, err = ParseCollections("")
+	require.NoError(t, err)
+	require.Empty(t, cols)
+
+	for _, spec := range []string{
+		"file=/var/lib/users.db",
+		"name=users",
+		"name=users,file=a.db,cmd=sqlite3",
+		"name=users,file=a.db,keep=0",
+		"name=users,file=a.db,every=often",
+		"name=users,file=a.db,color=blue",
+		"name=users,file=a.db;name=users,file=b.db",
+		"name=bafy,file=a.db",
+	} {
+		_, err := ParseCollections(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	nd := newTestNode(ctx, mn, t)
+
+	path := filepath.Join(t.TempDir(), "users.db")
+	require.NoError(t, os.WriteFile(path, []byte("version 1"), 0644))
+	snaps := newSnapshots(nd, t.TempDir(), []Collection{{
+		Name:     "users",
+		Exporter: FileExporter{Path: path},
+		Interval: time.Hour,
+		Keep:     1,
+	}})
+
+	_, err := snaps.run(ctx, "orders")
+	require.True(t, errors.Is(err, ErrCollectionNotFound))
+
+	first, err := snaps.run(ctx, "users")
+	require.NoError(t, err)
+	ref, err := nd.pub.Index().PeekRef(first)
+	require.NoError(t, err)
+	require.Equal(t, "users", ref.Labels[CollectionLabel])
+
+	tag, err := nd.exch.Tags().Get("users")
+	require.NoError(t, err)
+	require.Equal(t, first, tag.Root)
+
+	// A new version of the dataset replaces the previous snapshot
+	require.NoError(t, os.WriteFile(path, []byte("version 2"), 0644))
+	second, err := snaps.run(ctx, "users")
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	tag, err = nd.exch.Tags().Get("users")
+	require.NoError(t, err)
+	require.Equal(t, second, tag.Root)
+
+	refs, err := nd.pub.Index().ListRefs(exchange.Label{Key: CollectionLabel, Value: "users"})
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	require.Equal(t, second, refs[0].PayloadCID)
+
+	list := snaps.list()
+	require.Len(t, list, 1)
+	require.Equal(t, second, list[0].Root)
+	require.Equal(t, 1, list[0].Snapshots)
+	require.Empty(t, list[0].Err)
+
+	// A failed export is reported without touching the published snapshot
+	require.NoError(t, os.Remove(path))
+	_, err = snaps.run(ctx, "users")
+	require.Error(t, err)
+	list = snaps.list()
+	require.Equal(t, second, list[0].Root)
+	require.NotEmpty(t, list[0].Err)
+}
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ParseStandbyOf parses the p2p address of the primary a standby replicates e.g. /ip4/1.2.3.4/tcp/41504/p2p/<id>
+func ParseStandbyOf(addr string) (*peer.AddrInfo, error) {
+	maddr, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary address: %w", err)
+	}
+	return peer.AddrInfoFromP2pAddr(maddr)
+}
+
+// ParseStandbys parses a list of peer IDs separated by commas allowed to replicate our state
+func ParseStandbys(list string) ([]peer.ID, error) {
+	return parsePeerList(list, "standby")
+}
+
+// parsePeerList parses a list of peer IDs separated by commas, kind names the peers in errors
+func parsePeerList(list string, kind string) ([]peer.ID, error) {
+	var peers []peer.ID
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s: %w", kind, s, err)
+		}
+		peers = append(peers, pid)
+	}
+	return peers, nil
+}
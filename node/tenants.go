@@ -0,0 +1,266 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+)
+
+// DefaultQuotaPeriod is the period after which the bandwidth used by gateway tenants is reset
+const DefaultQuotaPeriod = 30 * 24 * time.Hour
+
+// Tenant is a customer given access to the gateway with an API token
+type Tenant struct {
+	Name  string
+	Token string
+	// RateLimit is the number of requests per second allowed on average, 0 is unlimited
+	RateLimit float64
+	// Burst is the number of requests allowed at once above the rate limit
+	Burst int
+	// BandwidthQuota is the number of bytes which can be served per quota period, 0 is unlimited
+	BandwidthQuota uint64
+}
+
+// tenantConfig is the JSON representation of a tenant in a tenants file
+type tenantConfig struct {
+	Name  string  `json:"name"`
+	Token string  `json:"token"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// Quota is a human readable size e.g. 100GB
+	Quota string `json:"quota"`
+}
+
+// LoadTenants reads the gateway tenants from a JSON file holding a list of objects with a name, token,
+// rate, burst and quota fields
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []tenantConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	tenants := make([]Tenant, len(configs))
+	names := make(map[string]bool)
+	for i, c := range configs {
+		if c.Name == "" || c.Token == "" {
+			return nil, fmt.Errorf("tenant %d is missing a name or token", i)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate tenant %s", c.Name)
+		}
+		names[c.Name] = true
+		t := Tenant{
+			Name:      c.Name,
+			Token:     c.Token,
+			RateLimit: c.Rate,
+			Burst:     c.Burst,
+		}
+		if c.Quota != "" {
+			size, err := units.FromHumanSize(c.Quota)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quota for %s: %w", c.Name, err)
+			}
+			t.BandwidthQuota = uint64(size)
+		}
+		tenants[i] = t
+	}
+	return tenants, nil
+}
+
+// TenantUsage reports how much a tenant used the gateway during the current quota period
+type TenantUsage struct {
+	Name string
+	// Requests is the number of requests served
+	Requests uint64
+	// Bytes is the number of bytes served
+	Bytes uint64
+	// Rejected is the number of requests rejected for exceeding the rate limit or the quota
+	Rejected uint64
+	// Quota is the bandwidth quota of the tenant, 0 is unlimited
+	Quota uint64
+	// PeriodStart is when the current quota period started
+	PeriodStart time.Time
+}
+
+type tenantState struct {
+	Tenant
+	// tokens are the requests left in the bucket
+	tokens float64
+	last   time.Time
+	usage  TenantUsage
+}
+
+// tenants enforces the rate limits and bandwidth quotas of the gateway tenants and persists their usage
+type tenants struct {
+	period time.Duration
+	ds     datastore.Batching
+
+	mu      sync.Mutex
+	byToken map[string]*tenantState
+}
+
+func newTenants(ds datastore.Batching, list []Tenant, period time.Duration) (*tenants, error) {
+	if period == 0 {
+		period = DefaultQuotaPeriod
+	}
+	tn := &tenants{
+		period:  period,
+		ds:      namespace.Wrap(ds, datastore.NewKey("/gateway/usage")),
+		byToken: make(map[string]*tenantState),
+	}
+	now := time.Now()
+	for _, t := range list {
+		ts := &tenantState{
+			Tenant: t,
+			tokens: float64(t.Burst),
+			last:   now,
+			usage: TenantUsage{
+				Name:        t.Name,
+				Quota:       t.BandwidthQuota,
+				PeriodStart: now,
+			},
+		}
+		// Usage is kept across restarts so quotas can't be reset by restarting the node
+		data, err := tn.ds.Get(datastore.NewKey(t.Name))
+		if err == nil {
+			if err := json.Unmarshal(data, &ts.usage); err != nil {
+				return nil, err
+			}
+			ts.usage.Quota = t.BandwidthQuota
+		} else if !errors.Is(err, datastore.ErrNotFound) {
+			return nil, err
+		}
+		tn.byToken[t.Token] = ts
+	}
+	return tn, nil
+}
+
+// requestToken reads the API token from the Authorization header or the token query parameter
+// for clients which can't set headers such as img tags
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// lookup returns the tenant of a token if any
+func (tn *tenants) lookup(token string) (*tenantState, bool) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	ts, ok := tn.byToken[token]
+	return ts, ok
+}
+
+// admit checks a tenant is within its rate limit and quota. It returns the status code to reject the
+// request with or 0 if the request can be served.
+func (tn *tenants) admit(ts *tenantState, now time.Time) (int, string) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	tn.rollover(ts, now)
+	if ts.BandwidthQuota > 0 && ts.usage.Bytes >= ts.BandwidthQuota {
+		ts.usage.Rejected++
+		return http.StatusForbidden, "bandwidth quota exceeded"
+	}
+	if ts.RateLimit > 0 {
+		// Refill the bucket with the requests allowed since the last one
+		ts.tokens += now.Sub(ts.last).Seconds() * ts.RateLimit
+		burst := float64(ts.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		if ts.tokens > burst {
+			ts.tokens = burst
+		}
+		ts.last = now
+		if ts.tokens < 1 {
+			ts.usage.Rejected++
+			return http.StatusTooManyRequests, "rate limit exceeded"
+		}
+		ts.tokens--
+	}
+	ts.usage.Requests++
+	return 0, ""
+}
+
+// record adds the bytes served to a tenant's usage
+func (tn *tenants) record(ts *tenantState, n uint64) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	ts.usage.Bytes += n
+}
+
+// rollover starts a new quota period if the current one is over, callers must hold the lock
+func (tn *tenants) rollover(ts *tenantState, now time.Time) {
+	if now.Sub(ts.usage.PeriodStart) < tn.period {
+		return
+	}
+	ts.usage = TenantUsage{
+		Name:        ts.Name,
+		Quota:       ts.BandwidthQuota,
+		PeriodStart: now,
+	}
+}
+
+// usage returns the usage of a single tenant
+func (tn *tenants) usage(ts *tenantState) TenantUsage {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	tn.rollover(ts, time.Now())
+	return ts.usage
+}
+
+// all returns the usage of every tenant sorted by name
+func (tn *tenants) all() []TenantUsage {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	now := time.Now()
+	usage := make([]TenantUsage, 0, len(tn.byToken))
+	for _, ts := range tn.byToken {
+		tn.rollover(ts, now)
+		usage = append(usage, ts.usage)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Name < usage[j].Name
+	})
+	return usage
+}
+
+// flush persists the usage of every tenant
+func (tn *tenants) flush() error {
+	for _, u := range tn.all() {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := tn.ds.Put(datastore.NewKey(u.Name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written in a response
+type countingWriter struct {
+	http.ResponseWriter
+	n uint64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,129 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dss "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/myelnet/pop/exchange"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTenants(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "tenants.json")
+	require.NoError(t, os.WriteFile(p, []byte(`[
+		{"name": "acme", "token": "secret", "rate": 10, "burst": 20, "quota": "1GB"},
+		{"name": "free", "token": "public"}
+	]`), 0666))
+
+	tenants, err := LoadTenants(p)
+	require.NoError(t, err)
+	require.Equal(t, []Tenant{
+		{Name: "acme", Token: "secret", RateLimit: 10, Burst: 20, BandwidthQuota: 1000000000},
+		{Name: "free", Token: "public"},
+	}, tenants)
+
+	require.NoError(t, os.WriteFile(p, []byte(`[{"name": "acme"}]`), 0666))
+	_, err = LoadTenants(p)
+	require.Error(t, err)
+}
+
+func TestTenantLimits(t *testing.T) {
+	ds := dss.MutexWrap(datastore.NewMapDatastore())
+	tn, err := newTenants(ds, []Tenant{
+		{Name: "acme", Token: "secret", RateLimit: 1, Burst: 2, BandwidthQuota: 100},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	ts, ok := tn.lookup("secret")
+	require.True(t, ok)
+	_, ok = tn.lookup("guess")
+	require.False(t, ok)
+
+	// The burst is allowed at once then requests are limited to the rate
+	now := time.Now()
+	status, _ := tn.admit(ts, now)
+	require.Equal(t, 0, status)
+	status, _ = tn.admit(ts, now)
+	require.Equal(t, 0, status)
+	status, _ = tn.admit(ts, now)
+	require.Equal(t, http.StatusTooManyRequests, status)
+	status, _ = tn.admit(ts, now.Add(time.Second))
+	require.Equal(t, 0, status)
+
+	// Requests are rejected once the quota is used
+	tn.record(ts, 100)
+	status, _ = tn.admit(ts, now.Add(time.Minute))
+	require.Equal(t, http.StatusForbidden, status)
+	u := tn.usage(ts)
+	require.Equal(t, uint64(3), u.Requests)
+	require.Equal(t, uint64(100), u.Bytes)
+	require.Equal(t, uint64(2), u.Rejected)
+
+	// Usage is kept across restarts
+	require.NoError(t, tn.flush())
+	tn, err = newTenants(ds, []Tenant{
+		{Name: "acme", Token: "secret", RateLimit: 1, Burst: 2, BandwidthQuota: 100},
+	}, time.Hour)
+	require.NoError(t, err)
+	ts, _ = tn.lookup("secret")
+	require.Equal(t, uint64(100), tn.usage(ts).Bytes)
+
+	// The quota is reset once the period is over
+	status, _ = tn.admit(ts, time.Now().Add(2*time.Hour))
+	require.Equal(t, 0, status)
+	require.Equal(t, uint64(0), tn.usage(ts).Bytes)
+}
+
+func TestGatewayTenants(t *testing.T) {
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+
+	nd := newTestNode(ctx, mn, t)
+
+	p := filepath.Join(t.TempDir(), "hello.txt")
+	require.NoError(t, os.WriteFile(p, []byte("hello tenant"), 0666))
+
+	tx := nd.exch.Tx(ctx)
+	require.NoError(t, tx.PutFile(p))
+	tx.SetCacheRF(0)
+	require.NoError(t, tx.Commit())
+	root := tx.Root()
+	tx.Close()
+
+	tn, err := newTenants(nd.ds, []Tenant{{Name: "acme", Token: "secret"}}, 0)
+	require.NoError(t, err)
+	gw := &gateway{node: nd, tenants: tn}
+	path := fmt.Sprintf("/ipfs/%s/%s", root, exchange.FileKey(p).String())
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello tenant", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/usage?token=secret", nil)
+	rec = httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var u TenantUsage
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&u))
+	require.Equal(t, "acme", u.Name)
+	require.Equal(t, uint64(1), u.Requests)
+	require.Equal(t, uint64(len("hello tenant")), u.Bytes)
+}
@@ -0,0 +1,196 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/myelnet/pop/exchange"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultTestnetPort is the libp2p port of the first testnet node, the next nodes use the following ports
+const DefaultTestnetPort = 41500
+
+// TestnetOptions configures a local network of nodes running in a single process
+type TestnetOptions struct {
+	// Nodes is the number of nodes in the network
+	Nodes int
+	// RepoPath is the directory in which each node keeps its repo
+	RepoPath string
+	// SeedPath is an optional directory of files added to the first node and dispatched to the others
+	SeedPath string
+	// Port is the libp2p port of the first node, node i listens on Port+i
+	Port int
+	// GatewayPort is the HTTP gateway port of the first node, node i serves on GatewayPort+i.
+	// Gateways are disabled if 0.
+	GatewayPort int
+	// Regions are the regions joined by every node
+	Regions []string
+	// Capacity is the storage capacity of each node
+	Capacity uint64
+	// Ready is called with the peer address of each node and the roots of the seeded content
+	// once the network is running
+	Ready func(addrs []string, roots map[string]string)
+}
+
+// testnetIdentity derives the host key of a testnet node from its index so the peer IDs and
+// addresses are the same every time the network starts
+func testnetIdentity(i int) (crypto.PrivKey, error) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.New(rand.NewSource(int64(i) + 1)))
+	return priv, err
+}
+
+// testnetOptions returns the options of the node at the given index in the network
+func testnetOptions(i int, opts TestnetOptions) (Options, error) {
+	priv, err := testnetIdentity(i)
+	if err != nil {
+		return Options{}, err
+	}
+	nopts := Options{
+		RepoPath:    filepath.Join(opts.RepoPath, fmt.Sprintf("node%d", i)),
+		ListenAddrs: []string{fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", opts.Port+i)},
+		Identity:    priv,
+		Regions:     opts.Regions,
+		Capacity:    opts.Capacity,
+	}
+	if opts.GatewayPort > 0 {
+		nopts.GatewayAddr = fmt.Sprintf("127.0.0.1:%d", opts.GatewayPort+i)
+	}
+	// Every node bootstraps with the first one
+	if i > 0 {
+		boot, err := testnetOptions(0, opts)
+		if err != nil {
+			return Options{}, err
+		}
+		pid, err := peer.IDFromPrivateKey(boot.Identity)
+		if err != nil {
+			return Options{}, err
+		}
+		nopts.BootstrapPeers = []string{fmt.Sprintf("%s/p2p/%s", boot.ListenAddrs[0], pid)}
+	}
+	return nopts, nil
+}
+
+// RunTestnet runs a network of nodes in a single process with fixed ports and identities so
+// applications can run integration tests against it. The first node executes the commands
+// received from the CLI.
+func RunTestnet(ctx context.Context, opts TestnetOptions) error {
+	if opts.Nodes < 1 {
+		return fmt.Errorf("testnet needs at least 1 node")
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultTestnetPort
+	}
+
+	listen, err := SocketListen("")
+	if err != nil {
+		return fmt.Errorf("SocketListen: %v", err)
+	}
+
+	var nodes []*node
+	var addrs []string
+	for i := 0; i < opts.Nodes; i++ {
+		nopts, err := testnetOptions(i, opts)
+		if err != nil {
+			listen.Close()
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(nopts.RepoPath, "datastore"), 0755); err != nil {
+			listen.Close()
+			return err
+		}
+		nd, err := New(ctx, nopts)
+		if err != nil {
+			listen.Close()
+			return fmt.Errorf("node.New: %v", err)
+		}
+		if nopts.GatewayAddr != "" {
+			go func(nopts Options) {
+				if err := serveGateway(ctx, nopts, nd); err != nil {
+					log.Error().Err(err).Msg("serveGateway")
+				}
+			}(nopts)
+		}
+		nodes = append(nodes, nd)
+		addrs = append(addrs, fmt.Sprintf("%s/p2p/%s", nopts.ListenAddrs[0], nd.host.ID()))
+		log.Info().Int("node", i).Str("addr", addrs[i]).Msg("started testnet node")
+	}
+
+	waitTestnetPeers(ctx, nodes, 30*time.Second)
+
+	roots := make(map[string]string)
+	if opts.SeedPath != "" {
+		roots, err = seedTestnet(ctx, nodes[0], opts.SeedPath, opts.Nodes-1)
+		if err != nil {
+			listen.Close()
+			return err
+		}
+	}
+	log.Info().Int("nodes", opts.Nodes).Msg("testnet ready")
+	if opts.Ready != nil {
+		opts.Ready(addrs, roots)
+	}
+
+	return serve(ctx, listen, nodes[0])
+}
+
+// waitTestnetPeers waits until every node is connected with the first one or the timeout expires
+func waitTestnetPeers(ctx context.Context, nodes []*node, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for len(nodes[0].connPeers()) < len(nodes)-1 {
+		select {
+		case <-ctx.Done():
+			log.Warn().Msg("not all testnet nodes are connected")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// seedTestnet adds the files in the seed directory to a node and dispatches them to rf providers.
+// It returns the root of each file keyed by file name.
+func seedTestnet(ctx context.Context, nd *node, path string, rf int) (map[string]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	tx := nd.pub.Tx(ctx, exchange.WithDispatchPolicy(rf, time.Minute))
+	defer tx.Close()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if err := tx.PutFile(filepath.Join(path, e.Name())); err != nil {
+			return nil, err
+		}
+	}
+	status, err := tx.Status()
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]string)
+	for k, e := range status {
+		roots[k] = e.Value.String()
+		log.Info().Str("key", k).Str("root", e.Value.String()).Msg("seeded")
+	}
+	if len(roots) == 0 {
+		return roots, nil
+	}
+	tx.SetCacheRF(rf)
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, p := range tx.Confirmed() {
+		log.Info().Str("peer", p.String()).Msg("dispatched seed content")
+	}
+	return roots, nil
+}
@@ -0,0 +1,35 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestnetOptions(t *testing.T) {
+	opts := TestnetOptions{
+		Nodes:    3,
+		RepoPath: t.TempDir(),
+		Port:     DefaultTestnetPort,
+	}
+
+	first, err := testnetOptions(0, opts)
+	require.NoError(t, err)
+	require.Len(t, first.BootstrapPeers, 0)
+	require.Equal(t, []string{"/ip4/127.0.0.1/tcp/41500"}, first.ListenAddrs)
+	pid, err := peer.IDFromPrivateKey(first.Identity)
+	require.NoError(t, err)
+
+	// Identities are the same on every run
+	again, err := testnetOptions(0, opts)
+	require.NoError(t, err)
+	require.True(t, first.Identity.Equals(again.Identity))
+
+	second, err := testnetOptions(1, opts)
+	require.NoError(t, err)
+	require.False(t, first.Identity.Equals(second.Identity))
+	require.Equal(t, []string{"/ip4/127.0.0.1/tcp/41501"}, second.ListenAddrs)
+	require.Equal(t, []string{"/ip4/127.0.0.1/tcp/41500/p2p/" + pid.String()}, second.BootstrapPeers)
+	require.NotEqual(t, first.RepoPath, second.RepoPath)
+}
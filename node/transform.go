@@ -0,0 +1,138 @@
+package node
+
+import (
+	"compress/gzip"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Transform converts content at serve time for the requests it applies to. Each transform
+// produces a single variant of the content so its output can be cached under a key derived from its name.
+type Transform interface {
+	// Name identifies the variant produced by the transform
+	Name() string
+	// Match returns whether the transform applies to a request for content of the given mime type
+	Match(r *http.Request, mimeType string) bool
+	// Header sets any response header describing the transformed representation
+	Header(h http.Header)
+	// Apply writes the transformed content read from src into dst
+	Apply(dst io.Writer, src io.Reader) error
+}
+
+// ParseTransforms returns the built in transforms for a list of names separated by commas
+func ParseTransforms(names string) ([]Transform, error) {
+	var ts []Transform
+	for _, n := range strings.Split(names, ",") {
+		switch strings.TrimSpace(n) {
+		case "":
+		case "gzip":
+			ts = append(ts, GzipTransform{})
+		case "preview":
+			ts = append(ts, PreviewTransform{MaxWidth: 256})
+		default:
+			return nil, fmt.Errorf("unknown transform %s", n)
+		}
+	}
+	return ts, nil
+}
+
+// GzipTransform compresses text based content for clients accepting gzip encoding
+type GzipTransform struct{}
+
+// Name of the variant
+func (GzipTransform) Name() string {
+	return "gzip"
+}
+
+// Match requests accepting gzip for content which compresses well. We don't compress
+// range requests as the ranges would apply to the compressed representation.
+func (GzipTransform) Match(r *http.Request, mimeType string) bool {
+	if r.Header.Get("Range") != "" || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		return false
+	}
+	mt := strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/javascript", mt == "application/xml", mt == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// Header sets the content encoding
+func (GzipTransform) Header(h http.Header) {
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// Apply compresses the content
+func (GzipTransform) Apply(dst io.Writer, src io.Reader) error {
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// PreviewTransform scales down jpeg and png images when the request has a preview query parameter
+type PreviewTransform struct {
+	MaxWidth int
+}
+
+// Name of the variant includes the width so different sizes are cached separately
+func (pt PreviewTransform) Name() string {
+	return fmt.Sprintf("preview-%d", pt.MaxWidth)
+}
+
+// Match image requests asking for a preview
+func (pt PreviewTransform) Match(r *http.Request, mimeType string) bool {
+	if _, ok := r.URL.Query()["preview"]; !ok {
+		return false
+	}
+	return mimeType == "image/jpeg" || mimeType == "image/png"
+}
+
+// Header is a noop as the preview keeps the same format
+func (pt PreviewTransform) Header(h http.Header) {}
+
+// Apply decodes the image and encodes it back scaled down to the max width
+func (pt PreviewTransform) Apply(dst io.Writer, src io.Reader) error {
+	img, format, err := image.Decode(src)
+	if err != nil {
+		return err
+	}
+	img = scaleDown(img, pt.MaxWidth)
+	switch format {
+	case "png":
+		return png.Encode(dst, img)
+	default:
+		return jpeg.Encode(dst, img, nil)
+	}
+}
+
+// scaleDown resizes an image wider than the given width using nearest neighbor sampling
+func scaleDown(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() <= width {
+		return img
+	}
+	height := b.Dy() * width / b.Dx()
+	if height == 0 {
+		height = 1
+	}
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := b.Min.X + x*b.Dx()/width
+			out.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ErrUnsupportedTransport is returned when listening on a transport our libp2p version doesn't ship
+var ErrUnsupportedTransport = errors.New("unsupported transport")
+
+// unsupportedTransports are the transports browsers may use which need a newer libp2p.
+// WebTransport only ships with go-libp2p v0.23 and above so we reject it at startup rather than
+// failing to bind. Browsers can connect over WebSocket in the meantime.
+var unsupportedTransports = []string{"/webtransport"}
+
+// ValidateListenAddrs checks the libp2p host can listen on the given multiaddresses
+func ValidateListenAddrs(addrs []string) error {
+	for _, s := range addrs {
+		for _, t := range unsupportedTransports {
+			if strings.Contains(s, t) {
+				return fmt.Errorf("%w: %s, listen on /ip4/0.0.0.0/tcp/<port>/ws for browsers", ErrUnsupportedTransport, s)
+			}
+		}
+		if _, err := ma.NewMultiaddr(s); err != nil {
+			return fmt.Errorf("invalid listen address %s: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// ValidateAnnounceAddrs checks the given multiaddresses can be announced to other peers
+func ValidateAnnounceAddrs(addrs []string) error {
+	_, err := parseAnnounceAddrs(addrs)
+	return err
+}
+
+func parseAnnounceAddrs(addrs []string) ([]ma.Multiaddr, error) {
+	var maddrs []ma.Multiaddr
+	for _, s := range addrs {
+		maddr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announce address %s: %w", s, err)
+		}
+		// The host appends its peer ID when sharing its addresses
+		if _, err := maddr.ValueForProtocol(ma.P_P2P); err == nil {
+			return nil, fmt.Errorf("invalid announce address %s: remove the /p2p part", s)
+		}
+		if manet.IsIPUnspecified(maddr) {
+			return nil, fmt.Errorf("invalid announce address %s: unspecified IP", s)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return maddrs, nil
+}
+
+// announceFactory replaces the addresses the host advertises with the announced ones, for instance the
+// public address of a port forwarded by the router. Relayed addresses are still advertised.
+func announceFactory(announce []ma.Multiaddr) func([]ma.Multiaddr) []ma.Multiaddr {
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		out := append([]ma.Multiaddr{}, announce...)
+		for _, addr := range addrs {
+			if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+				out = append(out, addr)
+			}
+		}
+		return out
+	}
+}
+
+// dialableAddrs returns the full p2p addresses of the host, the public ones first then the ones only
+// peers on the same network can dial
+func dialableAddrs(h host.Host) (public []string, local []string) {
+	p2p, err := ma.NewComponent("p2p", h.ID().Pretty())
+	if err != nil {
+		return nil, nil
+	}
+	for _, addr := range h.Addrs() {
+		full := addr.Encapsulate(p2p).String()
+		if manet.IsPublicAddr(addr) || strings.HasPrefix(addr.String(), "/dns") {
+			public = append(public, full)
+		} else {
+			local = append(local, full)
+		}
+	}
+	return public, local
+}
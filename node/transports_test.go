@@ -0,0 +1,48 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHostAddrs(t *testing.T) {
+	require.NoError(t, ValidateListenAddrs([]string{"/ip4/0.0.0.0/tcp/41504", "/ip4/0.0.0.0/tcp/41505/ws", "/ip4/0.0.0.0/udp/41504/quic"}))
+	err := ValidateListenAddrs([]string{"/ip4/0.0.0.0/udp/41504/quic/webtransport"})
+	require.True(t, errors.Is(err, ErrUnsupportedTransport))
+	require.Error(t, ValidateListenAddrs([]string{"0.0.0.0:41504"}))
+
+	require.NoError(t, ValidateAnnounceAddrs([]string{"/ip4/1.2.3.4/tcp/41504", "/dns4/pop.example.com/tcp/443/wss"}))
+	require.Error(t, ValidateAnnounceAddrs([]string{"/ip4/0.0.0.0/tcp/41504"}))
+	require.Error(t, ValidateAnnounceAddrs([]string{"/ip4/1.2.3.4/tcp/41504/p2p/12D3KooWBRx1wadU9sqXJSLfLBJdbq4jLy4Zkb3eMtyzkYdPaE8v"}))
+}
+
+func TestAnnounceFactory(t *testing.T) {
+	announce, err := parseAnnounceAddrs([]string{"/ip4/1.2.3.4/tcp/41504"})
+	require.NoError(t, err)
+	local := ma.StringCast("/ip4/192.168.1.2/tcp/41504")
+	relayed := ma.StringCast("/ip4/5.6.7.8/tcp/4001/p2p/12D3KooWBRx1wadU9sqXJSLfLBJdbq4jLy4Zkb3eMtyzkYdPaE8v/p2p-circuit")
+
+	// The listen addresses are replaced but the relayed ones are kept
+	addrs := announceFactory(announce)([]ma.Multiaddr{local, relayed})
+	require.Equal(t, []ma.Multiaddr{announce[0], relayed}, addrs)
+}
+
+func TestDialableAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	nd := newTestNode(ctx, mn, t)
+
+	public, local := dialableAddrs(nd.host)
+	require.Len(t, append(public, local...), len(nd.host.Addrs()))
+	for _, addr := range append(public, local...) {
+		require.True(t, strings.HasSuffix(addr, "/p2p/"+nd.host.ID().Pretty()))
+	}
+}
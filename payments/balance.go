@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// ChannelBalance summarizes the funds of a payment channel from the state we track locally
+type ChannelBalance struct {
+	Channel   address.Address
+	Direction uint64
+	// Control is our address and Target the address of the peer on the other end
+	Control address.Address
+	Target  address.Address
+	// Amount is the amount added to the channel and PendingAmount the amount waiting for confirmation
+	Amount        filecoin.BigInt
+	PendingAmount filecoin.BigInt
+	// Vouchered is the total value of the vouchers sent or received on the channel
+	Vouchered filecoin.BigInt
+	// Pending is the value of the vouchers not submitted on chain yet and PendingVouchers how many
+	// vouchers it takes to redeem it
+	Pending         filecoin.BigInt
+	PendingVouchers int
+	Lanes           uint64
+	Settling        bool
+	SettlingAt      abi.ChainEpoch
+}
+
+// Balances returns the balance of every channel we have created or received vouchers on
+func (p *Payments) Balances() ([]ChannelBalance, error) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	cis, err := p.store.findChans(func(ci *ChannelInfo) bool {
+		return ci.Channel != nil
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]ChannelBalance, len(cis))
+	for i, ci := range cis {
+		best := make(map[uint64]filecoin.BigInt)
+		for _, vi := range ci.Vouchers {
+			if b, ok := best[vi.Voucher.Lane]; !ok || vi.Voucher.Amount.GreaterThan(b) {
+				best[vi.Voucher.Lane] = vi.Voucher.Amount
+			}
+		}
+		vouchered := filecoin.NewInt(0)
+		for _, amt := range best {
+			vouchered = filecoin.BigAdd(vouchered, amt)
+		}
+		pending, _ := ci.pendingByLane()
+		balances[i] = ChannelBalance{
+			Channel:         *ci.Channel,
+			Direction:       ci.Direction,
+			Control:         ci.Control,
+			Target:          ci.Target,
+			Amount:          orZero(ci.Amount),
+			PendingAmount:   orZero(ci.PendingAmount),
+			Vouchered:       vouchered,
+			Pending:         ci.pendingAmount(),
+			PendingVouchers: len(pending),
+			Lanes:           ci.NextLane,
+			Settling:        ci.Settling,
+			SettlingAt:      ci.SettlingAt,
+		}
+	}
+	return balances, nil
+}
+
+// orZero returns zero for amounts never set such as the funds of inbound channels
+func orZero(amt filecoin.BigInt) filecoin.BigInt {
+	if amt.Nil() {
+		return filecoin.NewInt(0)
+	}
+	return amt
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/wallet"
 )
 
@@ -359,7 +360,7 @@ func (ch *channel) mpoolPush(ctx context.Context, msg *filecoin.Message) (*filec
 		if strings.Contains(err.Error(), "already in mpool, increase GasPremium") {
 			// incGas picks up the suggested gas premium from the error message and tries to push
 			// a new message to the pool with that amount
-			fmt.Println("message already in pool, increasing gas")
+			log.Debug().Msg("message already in pool, increasing gas")
 			return ch.increaseGas(ctx, msg, err.Error())
 		}
 		return nil, fmt.Errorf("MpoolPush failed with error: %v", err)
@@ -410,7 +411,7 @@ func (ch *channel) mutateChannelInfo(channelID string, mutate func(*ChannelInfo)
 	// we record to the store that we're going to send a message, send
 	// the message, and then record that the message was sent.
 	if err != nil {
-		fmt.Printf("Error reading channel info from store: %s\n", err)
+		log.Error().Err(err).Msg("failed to read channel info from store")
 		return
 	}
 
@@ -418,7 +419,7 @@ func (ch *channel) mutateChannelInfo(channelID string, mutate func(*ChannelInfo)
 
 	err = ch.store.putChannelInfo(channelInfo)
 	if err != nil {
-		fmt.Printf("Error writing channel info to store: %s\n", err)
+		log.Error().Err(err).Msg("failed to write channel info to store")
 	}
 }
 
@@ -444,12 +445,12 @@ func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 		// Channel creation failed, so remove the channel from the datastore
 		dserr := ch.store.RemoveChannel(channelID)
 		if dserr != nil {
-			fmt.Printf("failed to remove channel %s: %s", channelID, dserr)
+			log.Error().Err(dserr).Str("channel", channelID).Msg("failed to remove channel")
 		}
 
 		// Exit code 7 means out of gas
 		err := fmt.Errorf("payment channel creation failed (exit code %d)", mwait.Receipt.ExitCode)
-		fmt.Printf("Error: %v", err)
+		log.Error().Err(err).Str("channel", channelID).Msg("failed to create channel")
 		return err
 	}
 
@@ -459,7 +460,7 @@ func (ch *channel) waitPaychCreateMsg(channelID string, mcid cid.Cid) error {
 	var decodedReturn init2.ExecReturn
 	err = decodedReturn.UnmarshalCBOR(bytes.NewReader(mwait.Receipt.Return))
 	if err != nil {
-		fmt.Printf("Error decoding Receipt: %v", err)
+		log.Error().Err(err).Msg("failed to decode receipt")
 		return err
 	}
 
@@ -486,7 +487,7 @@ func (ch *channel) msgWaitComplete(mcid cid.Cid, err error) {
 	// Save the message result to the store
 	dserr := ch.store.SaveMessageResult(mcid, err)
 	if dserr != nil {
-		fmt.Printf("saving message result: %s", dserr)
+		log.Error().Err(dserr).Str("message", mcid.String()).Msg("failed to save message result")
 	}
 
 	// Inform listeners that the message has completed
@@ -524,7 +525,7 @@ func (ch *channel) addFunds(ctx context.Context, channelInfo *ChannelInfo, amt f
 	// look up the channel from the message CID
 	err = ch.store.SaveNewMessage(channelInfo.ChannelID, mcid)
 	if err != nil {
-		fmt.Printf("saving add funds message CID %s: %s", mcid, err)
+		log.Error().Err(err).Str("message", mcid.String()).Msg("failed to save add funds message")
 	}
 
 	go ch.waitForAddFundsMsg(channelInfo.ChannelID, mcid)
@@ -541,13 +542,13 @@ func (ch *channel) waitForAddFundsMsg(channelID string, mcid cid.Cid) {
 func (ch *channel) waitAddFundsMsg(channelID string, mcid cid.Cid) error {
 	mwait, err := ch.api.StateWaitMsg(ch.ctx, mcid, uint64(5))
 	if err != nil {
-		fmt.Printf("Error waiting for chain message: %v", err)
+		log.Error().Err(err).Str("message", mcid.String()).Msg("failed to wait for chain message")
 		return err
 	}
 
 	if mwait.Receipt.ExitCode != 0 {
 		err := fmt.Errorf("voucher channel creation failed: adding funds (exit code %d)", mwait.Receipt.ExitCode)
-		fmt.Printf("Error: %v", err)
+		log.Error().Err(err).Str("channel", channelID).Msg("failed to add funds")
 
 		ch.lk.Lock()
 		defer ch.lk.Unlock()
@@ -681,7 +682,7 @@ func (ch *channel) addVoucherUnlocked(ctx context.Context, chAddr address.Addres
 		}
 		if eq {
 			// Ignore the duplicate voucher.
-			fmt.Println("AddVoucher: voucher re-added")
+			log.Debug().Msg("ignored voucher added again")
 			return filecoin.NewInt(0), nil
 		}
 
@@ -1132,7 +1133,7 @@ func (ch *channel) settle(ctx context.Context, chAddr address.Address) (cid.Cid,
 	ci.Settling = true
 	err = ch.store.putChannelInfo(ci)
 	if err != nil {
-		fmt.Printf("Error marking channel as settled: %s", err)
+		log.Error().Err(err).Msg("failed to mark channel as settled")
 	}
 
 	return smgs.Cid(), err
@@ -1520,7 +1521,7 @@ func (ml *msgListeners) fireMsgComplete(mcid cid.Cid, err error) {
 	e := ml.ps.Publish(msgCompleteEvt{mcid: mcid, err: err})
 	if e != nil {
 		// In theory we shouldn't ever get an error here
-		fmt.Printf("unexpected error publishing message complete: %s", e)
+		log.Error().Err(e).Str("message", mcid.String()).Msg("failed to publish message complete")
 	}
 }
 
@@ -15,9 +15,12 @@ import (
 	"github.com/ipfs/go-datastore"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/wallet"
 )
 
+var log = logging.Subsystem(logging.Payments)
+
 // Manager is the interface required to handle payments for the pop exchange
 type Manager interface {
 	GetChannel(ctx context.Context, from, to address.Address, amt filecoin.BigInt) (*ChannelResponse, error)
@@ -28,6 +31,7 @@ type Manager interface {
 	AllocateLane(context.Context, address.Address) (uint64, error)
 	AddVoucherInbound(context.Context, address.Address, *paych.SignedVoucher, []byte, filecoin.BigInt) (filecoin.BigInt, error)
 	ChannelAvailableFunds(address.Address) (*AvailableFunds, error)
+	EstimateFundingGas(context.Context, address.Address, address.Address, filecoin.BigInt) (filecoin.BigInt, error)
 	Settle(context.Context, address.Address) error
 	StartAutoCollect(context.Context) error
 }
@@ -46,19 +50,30 @@ type Payments struct {
 
 	stopmu sync.Mutex
 	stop   chan struct{}
+
+	// redeemThreshold is the pending value of inbound vouchers after which we redeem them
+	redeemThreshold filecoin.BigInt
+	rmu             sync.Mutex
+	// redeeming are the channels we are currently redeeming vouchers for
+	redeeming map[string]struct{}
 }
 
 // New creates a new instance of payments manager
-func New(ctx context.Context, api filecoin.API, w wallet.Driver, ds datastore.Batching, bs cbor.IpldBlockstore) *Payments {
+func New(ctx context.Context, api filecoin.API, w wallet.Driver, ds datastore.Batching, bs cbor.IpldBlockstore, opts ...Option) *Payments {
 	store := NewStore(ds)
-	return &Payments{
-		ctx:      ctx,
-		api:      api,
-		wal:      w,
-		store:    store,
-		actStore: cbor.NewCborStore(bs),
-		channels: make(map[string]*channel),
-	}
+	p := &Payments{
+		ctx:       ctx,
+		api:       api,
+		wal:       w,
+		store:     store,
+		actStore:  cbor.NewCborStore(bs),
+		channels:  make(map[string]*channel),
+		redeeming: make(map[string]struct{}),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
 }
 
 // GetChannel adds fund to a new channel in a given direction, if one already exists it will update it
@@ -78,6 +93,48 @@ func (p *Payments) GetChannel(ctx context.Context, from, to address.Address, amt
 	}, nil
 }
 
+// EstimateFundingGas returns the maximum gas fee we expect to pay for the message funding a channel with the given amount.
+// It is zero if we already have an active channel with enough funds.
+func (p *Payments) EstimateFundingGas(ctx context.Context, from, to address.Address, amt filecoin.BigInt) (filecoin.BigInt, error) {
+	if p.api == nil {
+		return filecoin.NewInt(0), errors.New("no filecoin api to estimate gas")
+	}
+	mb := message{from}
+	msg, err := mb.Create(to, amt)
+	if err != nil {
+		return filecoin.NewInt(0), err
+	}
+	ci, err := p.store.OutboundActiveByFromTo(from, to)
+	if err != nil && !errors.Is(err, ErrChannelNotTracked) {
+		return filecoin.NewInt(0), err
+	}
+	if err == nil && ci.Channel != nil {
+		funds, err := p.ChannelAvailableFunds(*ci.Channel)
+		if err != nil {
+			return filecoin.NewInt(0), err
+		}
+		avail := filecoin.BigSub(filecoin.BigAdd(funds.ConfirmedAmt, funds.PendingAmt), funds.VoucherReedeemedAmt)
+		if avail.GreaterThanEqual(amt) {
+			return filecoin.NewInt(0), nil
+		}
+		// We only need to add funds to the existing channel
+		msg = &filecoin.Message{
+			To:     *ci.Channel,
+			From:   from,
+			Value:  filecoin.BigSub(amt, avail),
+			Method: 0,
+		}
+	}
+	msg, err = p.api.GasEstimateMessageGas(ctx, msg, nil, filecoin.EmptyTSK)
+	if err != nil {
+		return filecoin.NewInt(0), err
+	}
+	if msg.GasFeeCap.Int == nil {
+		return filecoin.NewInt(0), nil
+	}
+	return filecoin.BigMul(msg.GasFeeCap, filecoin.NewInt(uint64(msg.GasLimit))), nil
+}
+
 // WaitForChannel to be ready and return the address on chain
 func (p *Payments) WaitForChannel(ctx context.Context, mcid cid.Cid) (address.Address, error) {
 	// Find the channel associated with the message CID
@@ -175,8 +232,14 @@ func (p *Payments) AddVoucherInbound(ctx context.Context, chAddr address.Address
 		return filecoin.BigInt{}, err
 	}
 	ch.lk.Lock()
-	defer ch.lk.Unlock()
-	return ch.addVoucherUnlocked(ctx, chAddr, sv, minDelta)
+	received, err := ch.addVoucherUnlocked(ctx, chAddr, sv, minDelta)
+	ch.lk.Unlock()
+	if err != nil {
+		return received, err
+	}
+	// Submitting vouchers waits on the chain so it doesn't hold the payment
+	go p.redeemOverThreshold(p.ctx, chAddr)
+	return received, nil
 }
 
 // SubmitVoucher gets a channel from the store and submits a new voucher to the chain
@@ -220,11 +283,11 @@ func (p *Payments) Settle(ctx context.Context, addr address.Address) error {
 		defer wg.Done()
 		lookup, err := p.api.StateWaitMsg(ctx, sc, uint64(5))
 		if err != nil {
-			fmt.Printf("settling payment channel %s failed: %v\n", addr, err)
+			log.Error().Err(err).Str("channel", addr.String()).Msg("failed to settle payment channel")
 			return
 		}
 		if lookup.Receipt.ExitCode != 0 {
-			fmt.Printf("payment channel %s execution failed with code: %d\n", addr, lookup.Receipt.ExitCode)
+			log.Error().Str("channel", addr.String()).Int64("code", int64(lookup.Receipt.ExitCode)).Msg("payment channel settle execution failed")
 		}
 	}(mcid)
 
@@ -233,18 +296,18 @@ func (p *Payments) Settle(ctx context.Context, addr address.Address) error {
 	for _, voucher := range best {
 		mcid, err := ch.submitVoucher(ctx, addr, voucher, nil)
 		if err != nil {
-			fmt.Printf("unable to submit voucher: %v\n", err)
+			log.Error().Err(err).Str("channel", addr.String()).Msg("failed to submit voucher")
 			continue
 		}
 		go func(vouch *paych.SignedVoucher, mcid cid.Cid) {
 			defer wg.Done()
 			lookup, err := p.api.StateWaitMsg(ctx, mcid, uint64(5))
 			if err != nil {
-				fmt.Printf("waiting for voucher to submit on channel %s: %v\n", addr, err)
+				log.Error().Err(err).Str("channel", addr.String()).Msg("failed to wait for voucher submission")
 				return
 			}
 			if lookup.Receipt.ExitCode != 0 {
-				fmt.Printf("voucher update execution failed for channel %s with code %d\n", addr, lookup.Receipt.ExitCode)
+				log.Error().Str("channel", addr.String()).Int64("code", int64(lookup.Receipt.ExitCode)).Msg("voucher update execution failed")
 			}
 		}(voucher, mcid)
 	}
@@ -253,7 +316,7 @@ func (p *Payments) Settle(ctx context.Context, addr address.Address) error {
 		wg.Wait()
 		state, err := ch.loadActorState(addr)
 		if err != nil {
-			fmt.Printf("loading actor state: %v\n", err)
+			log.Error().Err(err).Str("channel", addr.String()).Msg("failed to load actor state")
 			return
 		}
 		ci, err := p.store.ByAddress(addr)
@@ -304,7 +367,7 @@ func (p *Payments) collectLoop(ctx context.Context) {
 			head, err := p.api.ChainHead(ctx)
 			// no need to fail the whole routine if the request fails once in a while
 			if err != nil {
-				fmt.Printf("%v\n", err)
+				log.Warn().Err(err).Msg("failed to get chain head")
 				continue
 			}
 			epoch = head.Height()
@@ -0,0 +1,124 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin/paych"
+	"github.com/ipfs/go-cid"
+	"github.com/myelnet/pop/filecoin"
+)
+
+// Option customizes the payments manager
+type Option func(*Payments)
+
+// WithRedeemThreshold submits the vouchers received on an inbound channel as soon as the value not
+// redeemed on chain yet reaches the threshold instead of waiting for the channel to be settled. It limits
+// how much we lose if the payer empties the channel during a long transfer. Nil never redeems early.
+func WithRedeemThreshold(amt filecoin.BigInt) Option {
+	return func(p *Payments) {
+		p.redeemThreshold = amt
+	}
+}
+
+// pendingByLane returns the best voucher of each lane worth more than the best voucher submitted on the
+// same lane, with the value it would redeem
+func (ci *ChannelInfo) pendingByLane() (map[uint64]*paych.SignedVoucher, map[uint64]filecoin.BigInt) {
+	best := make(map[uint64]*paych.SignedVoucher)
+	submitted := make(map[uint64]filecoin.BigInt)
+	for _, vi := range ci.Vouchers {
+		sv := vi.Voucher
+		if vi.Submitted {
+			if s, ok := submitted[sv.Lane]; !ok || sv.Amount.GreaterThan(s) {
+				submitted[sv.Lane] = sv.Amount
+			}
+			continue
+		}
+		if b, ok := best[sv.Lane]; !ok || sv.Amount.GreaterThan(b.Amount) {
+			best[sv.Lane] = sv
+		}
+	}
+	value := make(map[uint64]filecoin.BigInt)
+	for lane, sv := range best {
+		s, ok := submitted[lane]
+		if !ok {
+			value[lane] = sv.Amount
+			continue
+		}
+		if !sv.Amount.GreaterThan(s) {
+			delete(best, lane)
+			continue
+		}
+		value[lane] = filecoin.BigSub(sv.Amount, s)
+	}
+	return best, value
+}
+
+// pendingAmount is the value of the vouchers we could still redeem on chain
+func (ci *ChannelInfo) pendingAmount() filecoin.BigInt {
+	_, value := ci.pendingByLane()
+	total := filecoin.NewInt(0)
+	for _, v := range value {
+		total = filecoin.BigAdd(total, v)
+	}
+	return total
+}
+
+// Redeem submits the best spendable voucher of each lane of an inbound channel without settling it,
+// it returns the CIDs of the messages sent
+func (p *Payments) Redeem(ctx context.Context, addr address.Address) ([]cid.Cid, error) {
+	ch, err := p.channelByAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	best, err := p.bestSpendableByLane(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(best) == 0 {
+		return nil, fmt.Errorf("no vouchers to redeem on channel %s", addr)
+	}
+	var mcids []cid.Cid
+	for _, sv := range best {
+		mcid, err := ch.submitVoucher(ctx, addr, sv, nil)
+		if err != nil {
+			return mcids, err
+		}
+		mcids = append(mcids, mcid)
+	}
+	return mcids, nil
+}
+
+// redeemOverThreshold redeems the vouchers of an inbound channel if their pending value reached the
+// redeem threshold
+func (p *Payments) redeemOverThreshold(ctx context.Context, addr address.Address) {
+	if p.redeemThreshold.Nil() || p.redeemThreshold.IsZero() {
+		return
+	}
+	k := addr.String()
+	p.rmu.Lock()
+	if _, ok := p.redeeming[k]; ok {
+		// The vouchers will be checked again after the next one
+		p.rmu.Unlock()
+		return
+	}
+	p.redeeming[k] = struct{}{}
+	p.rmu.Unlock()
+	defer func() {
+		p.rmu.Lock()
+		delete(p.redeeming, k)
+		p.rmu.Unlock()
+	}()
+
+	ci, err := p.store.ByAddress(addr)
+	if err != nil {
+		return
+	}
+	if ci.Direction != DirInbound || ci.Settling || ci.pendingAmount().LessThan(p.redeemThreshold) {
+		return
+	}
+	if _, err := p.Redeem(ctx, addr); err != nil {
+		log.Error().Err(err).Str("channel", addr.String()).Msg("failed to redeem vouchers")
+	}
+}
@@ -0,0 +1,130 @@
+package payments
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v3/actors/builtin/paych"
+	"github.com/filecoin-project/specs-actors/v3/support/mock"
+	tutils "github.com/filecoin-project/specs-actors/v3/support/testing"
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	fil "github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeemThreshold(t *testing.T) {
+	bgCtx := context.Background()
+
+	ctx, cancel := context.WithTimeout(bgCtx, 10*time.Second)
+	defer cancel()
+
+	api := fil.NewMockLotusAPI()
+
+	ks := keystore.NewMemKeystore()
+
+	w := wallet.NewFromKeystore(ks, api)
+
+	from, err := w.NewKey(ctx, wallet.KTSecp256k1)
+	require.NoError(t, err)
+
+	payerAddr := tutils.NewIDAddr(t, 102)
+	payeeAddr := tutils.NewIDAddr(t, 103)
+
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+
+	mgr := New(bgCtx, api, w, ds, &mockBlocks{make(map[cid.Cid]block.Block)}, WithRedeemThreshold(big.NewInt(3)))
+
+	createAmt := big.NewInt(20)
+	act := &fil.Actor{
+		Code:    blockGen.Next().Cid(),
+		Head:    blockGen.Next().Cid(),
+		Nonce:   0,
+		Balance: createAmt,
+	}
+	api.SetActor(act)
+	chAddr := tutils.NewIDAddr(t, 101)
+
+	initActorAddr := tutils.NewIDAddr(t, 100)
+	hasher := func(data []byte) [32]byte { return [32]byte{} }
+
+	builder := mock.NewBuilder(ctx, chAddr).
+		WithBalance(createAmt, abi.NewTokenAmount(0)).
+		WithEpoch(abi.ChainEpoch(1)).
+		WithCaller(initActorAddr, builtin.InitActorCodeID).
+		WithActorType(payeeAddr, builtin.AccountActorCodeID).
+		WithActorType(payerAddr, builtin.AccountActorCodeID).
+		WithHasher(hasher)
+
+	rt := builder.Build(t)
+	params := &paych.ConstructorParams{To: payeeAddr, From: payerAddr}
+	rt.ExpectValidateCallerType(builtin.InitActorCodeID)
+	actor := paych.Actor{}
+	rt.Call(actor.Constructor, params)
+
+	var st paych.State
+	rt.GetState(&st)
+
+	api.SetActorState(&fil.ActorState{
+		Balance: createAmt,
+		State:   st,
+	})
+	api.SetObjectReader(func(c cid.Cid) []byte {
+		var bg bytesGetter
+		rt.StoreGet(c, &bg)
+		return bg.Bytes()
+	})
+	api.SetAccountKey(from)
+
+	// Vouchers are spendable
+	api.SetInvocResult(&fil.InvocResult{
+		MsgRct: &fil.MessageReceipt{
+			ExitCode: 0,
+		},
+	})
+
+	minDelta := big.NewInt(0)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, createTestVoucher(t, chAddr, 1, 1, big.NewInt(1), from, w), nil, minDelta)
+	require.NoError(t, err)
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, createTestVoucher(t, chAddr, 1, 2, big.NewInt(2), from, w), nil, minDelta)
+	require.NoError(t, err)
+
+	// Under the threshold the vouchers are kept until the channel settles
+	time.Sleep(100 * time.Millisecond)
+	balances, err := mgr.Balances()
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	b := balances[0]
+	require.Equal(t, chAddr, b.Channel)
+	require.Equal(t, uint64(DirInbound), b.Direction)
+	require.EqualValues(t, 2, b.Vouchered.Int64())
+	require.EqualValues(t, 2, b.Pending.Int64())
+	require.Equal(t, 1, b.PendingVouchers)
+
+	// A voucher on another lane brings the pending value over the threshold
+	_, err = mgr.AddVoucherInbound(ctx, chAddr, createTestVoucher(t, chAddr, 2, 1, big.NewInt(2), from, w), nil, minDelta)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		balances, err := mgr.Balances()
+		require.NoError(t, err)
+		return balances[0].Pending.IsZero()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	balances, err = mgr.Balances()
+	require.NoError(t, err)
+	require.EqualValues(t, 4, balances[0].Vouchered.Int64())
+	require.Equal(t, 0, balances[0].PendingVouchers)
+
+	// Nothing is left to redeem
+	_, err = mgr.Redeem(ctx, chAddr)
+	require.Error(t, err)
+}
@@ -2,17 +2,68 @@ package retrieval
 
 import (
 	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/myelnet/pop/retrieval/deal"
 )
 
+// PeerAskTTL is how long the ask made to a given peer is kept for it to start a retrieval
+const PeerAskTTL = time.Hour
+
+type peerAskKey struct {
+	p peer.ID
+	k cid.Cid
+}
+
+type peerAsk struct {
+	ask deal.QueryResponse
+	at  time.Time
+}
+
 // AskStore is actually for storing QueryResponse objects
 // we don't currently need to persist this beyond node restart
 type AskStore struct {
 	lk   sync.RWMutex
 	asks map[cid.Cid]deal.QueryResponse
+	// peerAsks are the asks made to a single peer when the price depends on who is asking
+	peerAsks map[peerAskKey]peerAsk
+	pruned   time.Time
+}
+
+// SetPeerAsk stores the ask made to a given peer for a content root
+func (s *AskStore) SetPeerAsk(p peer.ID, k cid.Cid, ask deal.QueryResponse) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	now := time.Now()
+	if s.peerAsks == nil {
+		s.peerAsks = make(map[peerAskKey]peerAsk)
+	}
+	// Forget the asks peers never followed up on
+	if now.Sub(s.pruned) > PeerAskTTL {
+		for key, pa := range s.peerAsks {
+			if now.Sub(pa.at) > PeerAskTTL {
+				delete(s.peerAsks, key)
+			}
+		}
+		s.pruned = now
+	}
+	s.peerAsks[peerAskKey{p, k}] = peerAsk{ask: ask, at: now}
+	return nil
+}
+
+// GetPeerAsk returns the ask made to a given peer for a content root or the ask for the root if none
+func (s *AskStore) GetPeerAsk(p peer.ID, k cid.Cid) deal.QueryResponse {
+	s.lk.RLock()
+	pa, ok := s.peerAsks[peerAskKey{p, k}]
+	s.lk.RUnlock()
+	if ok && time.Since(pa.at) <= PeerAskTTL {
+		return pa.ask
+	}
+	return s.GetAsk(k)
 }
 
 // SetAsk stores retrieval provider's ask for a given content root
@@ -6,9 +6,12 @@ import (
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/retrieval/deal"
 )
 
+var log = logging.Subsystem(logging.Retrieval)
+
 // EventReceiver is any thing that can receive FSM events
 type EventReceiver interface {
 	Has(id interface{}) (bool, error) // Check if we have any state before sending
@@ -53,7 +56,7 @@ func eventFromDataTransfer(event datatransfer.Event, channelState datatransfer.C
 	case datatransfer.NewVoucherResult:
 		response, ok := deal.ResponseFromVoucherResult(channelState.LastVoucherResult())
 		if !ok {
-			fmt.Println("unexpected voucher result received:", channelState.LastVoucher().Type())
+			log.Warn().Str("type", string(channelState.LastVoucher().Type())).Msg("unexpected voucher result received")
 			return noEvent, nil
 		}
 
@@ -88,6 +91,13 @@ func DataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 			return
 		}
 
+		log.Debug().
+			Str("deal", dealProposal.ID.String()).
+			Str("event", datatransfer.Events[event.Code]).
+			Str("status", datatransfer.Statuses[channelState.Status()]).
+			Uint64("received", channelState.Received()).
+			Msg("client data transfer event")
+
 		retrievalEvent, params := eventFromDataTransfer(event, channelState)
 		if retrievalEvent == noEvent {
 			return
@@ -96,12 +106,11 @@ func DataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 		// data transfer events for progress do not affect deal state
 		err := deals.Send(dealProposal.ID, retrievalEvent, params...)
 		if err != nil {
-			fmt.Printf(
-				"processing dt client event %s for state %s: %v\n",
-				datatransfer.Events[event.Code],
-				datatransfer.Statuses[channelState.Status()],
-				err,
-			)
+			log.Error().
+				Err(err).
+				Str("event", datatransfer.Events[event.Code]).
+				Str("status", datatransfer.Statuses[channelState.Status()]).
+				Msg("processing client data transfer event")
 		}
 	}
 }
@@ -100,6 +100,7 @@ type QueryResponse struct {
 	MaxPaymentIntervalIncrease uint64
 	Message                    string
 	UnsealPrice                abi.TokenAmount
+	Region                     string // name of the region the provider answered from, empty for storage miners
 }
 
 // PieceRetrievalPrice is the total price to retrieve the piece (size * MinPricePerByte + UnsealedPrice)
@@ -107,6 +108,19 @@ func (qr QueryResponse) PieceRetrievalPrice() abi.TokenAmount {
 	return big.Add(big.Mul(qr.MinPricePerByte, abi.NewTokenAmount(int64(qr.Size))), qr.UnsealPrice)
 }
 
+// CostEstimate is the total amount a client should expect to pay for a retrieval before accepting an offer
+type CostEstimate struct {
+	// Transfer is the price of the content including unsealing
+	Transfer abi.TokenAmount
+	// Gas is the maximum fee for creating or funding the payment channel
+	Gas abi.TokenAmount
+}
+
+// Total returns the sum of the transfer and gas costs
+func (ce CostEstimate) Total() abi.TokenAmount {
+	return big.Add(ce.Transfer, ce.Gas)
+}
+
 // Offer is the conditions under which a provider is willing to approve a transfer
 type Offer struct {
 	Provider peer.AddrInfo
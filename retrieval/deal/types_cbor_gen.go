@@ -264,7 +264,7 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
-	if _, err := w.Write([]byte{169}); err != nil {
+	if _, err := w.Write([]byte{170}); err != nil {
 		return err
 	}
 
@@ -420,6 +420,29 @@ func (t *QueryResponse) MarshalCBOR(w io.Writer) error {
 	if err := t.UnsealPrice.MarshalCBOR(w); err != nil {
 		return err
 	}
+
+	// t.Region (string) (string)
+	if len("Region") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Region\" was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len("Region"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Region")); err != nil {
+		return err
+	}
+
+	if len(t.Region) > cbg.MaxLength {
+		return xerrors.Errorf("Value in field t.Region was too long")
+	}
+
+	if err := cbg.WriteMajorTypeHeaderBuf(scratch, w, cbg.MajTextString, uint64(len(t.Region))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(t.Region)); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -572,6 +595,17 @@ func (t *QueryResponse) UnmarshalCBOR(r io.Reader) error {
 				}
 
 			}
+			// t.Region (string) (string)
+		case "Region":
+
+			{
+				sval, err := cbg.ReadStringBuf(br, scratch)
+				if err != nil {
+					return err
+				}
+
+				t.Region = string(sval)
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -101,7 +101,7 @@ type providerValidationEnvironment struct {
 
 // CheckDealParams verifies the given deal params are acceptable
 func (pve *providerValidationEnvironment) CheckDealParams(ds deal.ProviderState) error {
-	ask := pve.p.GetAsk(ds.PayloadCID)
+	ask := pve.p.GetPeerAsk(ds.Receiver, ds.PayloadCID)
 	if ds.PricePerByte.LessThan(ask.MinPricePerByte) {
 		return errors.New("price per byte too low")
 	}
@@ -116,6 +116,15 @@ func (pve *providerValidationEnvironment) CheckDealParams(ds deal.ProviderState)
 
 // RunDealDecisioningLogic runs custom deal decision logic to decide if a deal is accepted, if present
 func (pve *providerValidationEnvironment) RunDealDecisioningLogic(ctx context.Context, state deal.ProviderState) (bool, string, error) {
+	if f := pve.p.access; f != nil {
+		if err := f(state.Receiver, state.PayloadCID); err != nil {
+			return false, err.Error(), nil
+		}
+	}
+	// Reject new deals if we're running out of resources
+	if err := pve.p.guard.Check(); err != nil {
+		return false, err.Error(), nil
+	}
 	return true, "", nil
 }
 
@@ -2,12 +2,15 @@ package retrieval
 
 import (
 	"context"
-	"fmt"
+	"io"
+	"sync"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-multistore"
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"github.com/myelnet/pop/metrics"
 	"github.com/myelnet/pop/retrieval/deal"
 )
 
@@ -22,8 +25,92 @@ type StoreConfigurableTransport interface {
 	UseStore(datatransfer.ChannelID, ipld.Loader, ipld.Storer) error
 }
 
+// BandwidthMeter is implemented by store getters whose transfers are metered and rate limited
+type BandwidthMeter interface {
+	Bandwidth() *metrics.Bandwidth
+}
+
+// TransferContexts holds a context for each metered channel which is cancelled when the channel ends so
+// the blocks waiting for the bandwidth limits release the graphsync workers
+type TransferContexts struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	chans map[datatransfer.ChannelID]transferContext
+}
+
+type transferContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTransferContexts derives the contexts of the channels of a data transfer manager from ctx
+func NewTransferContexts(ctx context.Context, dt datatransfer.Manager) *TransferContexts {
+	tc := &TransferContexts{
+		ctx:   ctx,
+		chans: make(map[datatransfer.ChannelID]transferContext),
+	}
+	dt.SubscribeToEvents(func(event datatransfer.Event, state datatransfer.ChannelState) {
+		switch event.Code {
+		case datatransfer.Cancel, datatransfer.Error, datatransfer.Complete, datatransfer.CleanupComplete:
+			tc.end(state.ChannelID())
+		}
+	})
+	return tc
+}
+
+// Context returns the context of a channel, it is done once the channel ends
+func (tc *TransferContexts) Context(chid datatransfer.ChannelID) context.Context {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if t, ok := tc.chans[chid]; ok {
+		return t.ctx
+	}
+	ctx, cancel := context.WithCancel(tc.ctx)
+	tc.chans[chid] = transferContext{ctx: ctx, cancel: cancel}
+	return ctx
+}
+
+func (tc *TransferContexts) end(chid datatransfer.ChannelID) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if t, ok := tc.chans[chid]; ok {
+		t.cancel()
+		delete(tc.chans, chid)
+	}
+}
+
+// MeterLoader wraps the loader of a transfer so the blocks sent to a peer are accounted for the root and
+// stay within the upload limits. Loads waiting for the limits fail once ctx is done.
+func MeterLoader(ctx context.Context, bw *metrics.Bandwidth, p peer.ID, root cid.Cid, loader ipld.Loader) ipld.Loader {
+	if bw == nil {
+		return loader
+	}
+	return func(lnk ipld.Link, lnkCtx ipld.LinkContext) (io.Reader, error) {
+		r, err := loader(lnk, lnkCtx)
+		if err != nil {
+			return nil, err
+		}
+		return bw.Reader(ctx, p, root, r), nil
+	}
+}
+
+// MeterStorer wraps the storer of a transfer so the blocks received from a peer are accounted for the root
+// and stay within the download limits. Writes waiting for the limits fail once ctx is done.
+func MeterStorer(ctx context.Context, bw *metrics.Bandwidth, p peer.ID, root cid.Cid, storer ipld.Storer) ipld.Storer {
+	if bw == nil {
+		return storer
+	}
+	return func(lnkCtx ipld.LinkContext) (io.Writer, ipld.StoreCommitter, error) {
+		w, commit, err := storer(lnkCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bw.Writer(ctx, p, root, w), commit, nil
+	}
+}
+
 // TransportConfigurer configurers the graphsync transport to use a custom blockstore per deal
-func TransportConfigurer(thisPeer peer.ID, storeGetter StoreGetter) datatransfer.TransportConfigurer {
+func TransportConfigurer(thisPeer peer.ID, storeGetter StoreGetter, tc *TransferContexts) datatransfer.TransportConfigurer {
 	return func(channelID datatransfer.ChannelID, voucher datatransfer.Voucher, transport datatransfer.Transport) {
 		dealProposal, ok := deal.ProposalFromVoucher(voucher)
 		if !ok {
@@ -36,15 +123,26 @@ func TransportConfigurer(thisPeer peer.ID, storeGetter StoreGetter) datatransfer
 		otherPeer := channelID.OtherParty(thisPeer)
 		store, err := storeGetter.Get(otherPeer, dealProposal.ID)
 		if err != nil {
-			fmt.Println("attempting to configure data store:", err)
+			log.Error().Err(err).Msg("failed to configure data store")
 			return
 		}
 		if store == nil {
 			return
 		}
-		err = gsTransport.UseStore(channelID, store.Loader, store.Storer)
+		loader, storer := store.Loader, store.Storer
+		// Retrievals are pulled by the client so only the blocks it receives and the provider sends cross
+		// the network, the other side only reads and writes its own store
+		if bm, ok := storeGetter.(BandwidthMeter); ok {
+			ctx := tc.Context(channelID)
+			if channelID.Initiator == thisPeer {
+				storer = MeterStorer(ctx, bm.Bandwidth(), otherPeer, dealProposal.PayloadCID, storer)
+			} else {
+				loader = MeterLoader(ctx, bm.Bandwidth(), otherPeer, dealProposal.PayloadCID, loader)
+			}
+		}
+		err = gsTransport.UseStore(channelID, loader, storer)
 		if err != nil {
-			fmt.Println("attempting to configure data store:", err)
+			log.Error().Err(err).Msg("failed to configure data store")
 		}
 	}
 }
@@ -54,12 +152,20 @@ type dualStoreGetter struct {
 	p *Provider
 }
 
+// Bandwidth meters the transfers of the client and the provider which share the same transport
+func (dsg *dualStoreGetter) Bandwidth() *metrics.Bandwidth {
+	return dsg.p.bandwidth
+}
+
 // Our transport handles both client and provider as a result we need to try both states see which one works
 // TODO: figure out how to improve so we don't cause unnecessary reads on the client side
 func (dsg *dualStoreGetter) Get(pid peer.ID, did deal.ID) (*multistore.Store, error) {
 	var pstate deal.ProviderState
 	err := dsg.p.stateMachines.GetSync(context.TODO(), deal.ProviderDealIdentifier{Receiver: pid, DealID: did}, &pstate)
 	if err == nil {
+		if so, ok := dsg.p.storeIDGetter.(StoreOpener); ok {
+			return so.OpenStore(pstate.StoreID)
+		}
 		return dsg.p.multiStore.Get(pstate.StoreID)
 	}
 	var cstate deal.ClientState
@@ -16,12 +16,16 @@ import (
 	"github.com/ipfs/go-datastore/namespace"
 	peer "github.com/libp2p/go-libp2p-peer"
 
+	"github.com/myelnet/pop/logging"
+	"github.com/myelnet/pop/metrics"
 	"github.com/myelnet/pop/payments"
 	"github.com/myelnet/pop/retrieval/client"
 	"github.com/myelnet/pop/retrieval/deal"
 	"github.com/myelnet/pop/retrieval/provider"
 )
 
+var log = logging.Subsystem(logging.Retrieval)
+
 // Unsubscribe is a function that unsubscribes a subscriber for either the
 // client or the provider
 type Unsubscribe func()
@@ -37,6 +41,12 @@ type StoreIDGetter interface {
 	GetStoreID(cid.Cid) (multistore.StoreID, error)
 }
 
+// StoreOpener is implemented by store ID getters which need the provided content to be read through
+// their own view of a store instead of the raw multistore
+type StoreOpener interface {
+	OpenStore(multistore.StoreID) (*multistore.Store, error)
+}
+
 // Retrieval manager implementation
 type Retrieval struct {
 	c *Client
@@ -83,8 +93,14 @@ type Provider struct {
 	pay              payments.Manager
 	askStore         *AskStore
 	storeIDGetter    StoreIDGetter
+	guard            *metrics.Guard
+	bandwidth        *metrics.Bandwidth
+	access           AccessFilter
 }
 
+// AccessFilter returns an error if a peer may not retrieve a content ID
+type AccessFilter func(peer.ID, cid.Cid) error
+
 // GetAsk returns the current deal parameters this provider accepts for a given content ID
 func (p *Provider) GetAsk(k cid.Cid) deal.QueryResponse {
 	return p.askStore.GetAsk(k)
@@ -95,10 +111,38 @@ func (p *Provider) SetAsk(k cid.Cid, ask deal.QueryResponse) {
 	err := p.askStore.SetAsk(k, ask)
 
 	if err != nil {
-		fmt.Printf("Error setting retrieval ask: %v\v", err)
+		log.Error().Err(err).Str("root", k.String()).Msg("failed to set retrieval ask")
+	}
+}
+
+// GetPeerAsk returns the deal parameters this provider accepts from a given peer for a content ID
+func (p *Provider) GetPeerAsk(from peer.ID, k cid.Cid) deal.QueryResponse {
+	return p.askStore.GetPeerAsk(from, k)
+}
+
+// SetPeerAsk sets the deal parameters this provider accepts from a given peer only
+func (p *Provider) SetPeerAsk(from peer.ID, k cid.Cid, ask deal.QueryResponse) {
+	if err := p.askStore.SetPeerAsk(from, k, ask); err != nil {
+		log.Error().Err(err).Str("root", k.String()).Str("peer", from.String()).Msg("failed to set retrieval ask")
 	}
 }
 
+// SetGuard sets a resource guard to reject new deals when the node is over budget
+func (p *Provider) SetGuard(g *metrics.Guard) {
+	p.guard = g
+}
+
+// SetBandwidth sets a meter accounting and limiting the blocks of the retrievals sent and received, the
+// client and the provider share the same graphsync transport
+func (p *Provider) SetBandwidth(bw *metrics.Bandwidth) {
+	p.bandwidth = bw
+}
+
+// SetAccessFilter sets a filter to reject the deals of the peers which may not retrieve the content
+func (p *Provider) SetAccessFilter(f AccessFilter) {
+	p.access = f
+}
+
 func (p *Provider) notifySubscribers(eventName fsm.EventName, state fsm.StateType) {
 	evt := eventName.(provider.Event)
 	ds := state.(deal.ProviderState)
@@ -187,7 +231,7 @@ func New(
 	if err != nil {
 		return nil, err
 	}
-	tconfig := TransportConfigurer(self, &dualStoreGetter{c, p})
+	tconfig := TransportConfigurer(self, &dualStoreGetter{c, p}, NewTransferContexts(ctx, dt))
 	err = dt.RegisterTransportConfigurer(&deal.Proposal{}, tconfig)
 	if err != nil {
 		return nil, err
@@ -197,6 +241,7 @@ func New(
 
 	// TODO: might want to use the cleanup function returned
 	SettlePaymentChannels(ctx, pay, p)
+	RecordProviderMetrics(p)
 
 	// Retrievals run the payment channel collection routine
 	if err := pay.StartAutoCollect(ctx); err != nil {
@@ -261,11 +306,46 @@ func (c *Client) Retrieve(
 	return dealState.ID, nil
 }
 
+// EstimateCost returns the total cost we should expect to pay for retrieving content with the given
+// query response. Free transfers do not require a payment channel so they do not cost any gas.
+func (c *Client) EstimateCost(ctx context.Context, clientAddr address.Address, resp deal.QueryResponse) (deal.CostEstimate, error) {
+	est := deal.CostEstimate{
+		Transfer: resp.PieceRetrievalPrice(),
+		Gas:      big.Zero(),
+	}
+	if est.Transfer.Sign() == 0 {
+		return est, nil
+	}
+	gas, err := c.pay.EstimateFundingGas(ctx, clientAddr, resp.PaymentAddress, est.Transfer)
+	if err != nil {
+		return est, err
+	}
+	est.Gas = gas
+	return est, nil
+}
+
 // SubscribeToEvents to listen to transfer state changes on the client side
 func (c *Client) SubscribeToEvents(subscriber client.Subscriber) Unsubscribe {
 	return Unsubscribe(c.subscribers.Subscribe(subscriber))
 }
 
+// CancelDeal stops a deal and closes its data transfer so no more payments are made
+func (c *Client) CancelDeal(id deal.ID) error {
+	return c.stateMachines.Send(id, client.EventCancel)
+}
+
+// RestartDeal restarts the data transfer of a deal which stopped making progress
+func (c *Client) RestartDeal(ctx context.Context, id deal.ID) error {
+	var state deal.ClientState
+	if err := c.stateMachines.Get(id).Get(&state); err != nil {
+		return err
+	}
+	if state.ChannelID.Initiator == "" {
+		return fmt.Errorf("deal %d has no data transfer channel", id)
+	}
+	return c.dataTransfer.RestartDataTransferChannel(ctx, state.ChannelID)
+}
+
 // TryRestartInsufficientFunds attempts to restart any deals stuck in the insufficient funds state
 // after funds are added to a given payment channel
 func (c *Client) TryRestartInsufficientFunds(chAddr address.Address) error {
@@ -296,7 +376,7 @@ func SettlePaymentChannels(ctx context.Context, pay payments.Manager, pro *Provi
 			if state.PayCh != nil {
 				err := pay.Settle(ctx, *state.PayCh)
 				if err != nil {
-					fmt.Printf("settling payment channel: %v\n", err)
+					log.Error().Err(err).Str("channel", state.PayCh.String()).Msg("failed to settle payment channel")
 				}
 			}
 			return
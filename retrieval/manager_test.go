@@ -44,6 +44,10 @@ func (p *mockPayments) GetChannel(ctx context.Context, from, to address.Address,
 	return p.chResponse, nil
 }
 
+func (p *mockPayments) EstimateFundingGas(ctx context.Context, from, to address.Address, amt filecoin.BigInt) (filecoin.BigInt, error) {
+	return filecoin.NewInt(0), nil
+}
+
 func (p *mockPayments) WaitForChannel(ctx context.Context, id cid.Cid) (address.Address, error) {
 	return p.chAddr, nil
 }
@@ -0,0 +1,58 @@
+package retrieval
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/myelnet/pop/filecoin"
+	"github.com/myelnet/pop/metrics"
+	"github.com/myelnet/pop/retrieval/deal"
+	"github.com/myelnet/pop/retrieval/provider"
+)
+
+// providerProgress is the last progress we recorded for an ongoing deal
+type providerProgress struct {
+	sent  uint64
+	funds abi.TokenAmount
+}
+
+// RecordProviderMetrics subscribes to provider deals and reports active retrievals, bytes served
+// and payments received
+func RecordProviderMetrics(pro *Provider) Unsubscribe {
+	var mu sync.Mutex
+	deals := make(map[deal.ProviderDealIdentifier]*providerProgress)
+	return pro.SubscribeToEvents(func(event provider.Event, state deal.ProviderState) {
+		mu.Lock()
+		defer mu.Unlock()
+		id := state.Identifier()
+		prog, ok := deals[id]
+		if !ok {
+			if event != provider.EventDealAccepted {
+				return
+			}
+			prog = &providerProgress{funds: abi.NewTokenAmount(0)}
+			deals[id] = prog
+			metrics.ActiveRetrievals.Inc()
+		}
+		if state.TotalSent > prog.sent {
+			metrics.BytesServed.Add(float64(state.TotalSent - prog.sent))
+			prog.sent = state.TotalSent
+		}
+		if !state.FundsReceived.Nil() && state.FundsReceived.GreaterThan(prog.funds) {
+			metrics.PaymentsReceived.Add(toFIL(filecoin.BigSub(state.FundsReceived, prog.funds)))
+			prog.funds = state.FundsReceived
+		}
+		switch state.Status {
+		case deal.StatusCompleted, deal.StatusCancelled, deal.StatusErrored:
+			delete(deals, id)
+			metrics.ActiveRetrievals.Dec()
+		}
+	})
+}
+
+// toFIL converts an amount in attoFIL to a float amount of FIL
+func toFIL(amt abi.TokenAmount) float64 {
+	f, _ := new(big.Rat).SetFrac(amt.Int, new(big.Int).SetUint64(filecoin.FilecoinPrecision)).Float64()
+	return f
+}
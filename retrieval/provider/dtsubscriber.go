@@ -6,9 +6,12 @@ import (
 
 	datatransfer "github.com/filecoin-project/go-data-transfer"
 	"github.com/filecoin-project/go-statemachine/fsm"
+	"github.com/myelnet/pop/logging"
 	"github.com/myelnet/pop/retrieval/deal"
 )
 
+var log = logging.Subsystem(logging.Retrieval)
+
 // EventReceiver is any thing that can receive FSM events
 type EventReceiver interface {
 	Has(id interface{}) (bool, error)
@@ -52,10 +55,17 @@ func DataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 		if channelState.Status() == datatransfer.Completed {
 			err := deals.Send(deal.ProviderDealIdentifier{DealID: dealProposal.ID, Receiver: channelState.Recipient()}, EventComplete)
 			if err != nil {
-				fmt.Println("processing provider dt event:", err)
+				log.Error().Err(err).Msg("processing provider data transfer completion")
 			}
 		}
 
+		log.Debug().
+			Str("deal", dealProposal.ID.String()).
+			Str("event", datatransfer.Events[event.Code]).
+			Str("status", datatransfer.Statuses[channelState.Status()]).
+			Uint64("sent", channelState.Sent()).
+			Msg("provider data transfer event")
+
 		retrievalEvent, params := eventFromDataTransfer(event, channelState)
 		if retrievalEvent == noProviderEvent {
 			return
@@ -63,7 +73,7 @@ func DataTransferSubscriber(deals EventReceiver) datatransfer.Subscriber {
 
 		err := deals.Send(deal.ProviderDealIdentifier{DealID: dealProposal.ID, Receiver: channelState.Recipient()}, retrievalEvent, params...)
 		if err != nil {
-			fmt.Printf("processing provider dt event %s: %v\n", datatransfer.Events[event.Code], err)
+			log.Error().Err(err).Str("event", datatransfer.Events[event.Code]).Msg("processing provider data transfer event")
 		}
 
 	}
@@ -35,3 +35,42 @@ func Hamt() ipld.Node {
 		),
 	).Node()
 }
+
+// Keys selects the root node and the links and children associated with each of the given keys in a Map
+func Keys(keys ...string) ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	if len(keys) == 0 {
+		return ssb.Matcher().Node()
+	}
+	return ssb.ExploreUnion(ssb.Matcher(),
+		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			for _, key := range keys {
+				efsb.Insert(key, ssb.ExploreRecursive(selector.RecursionLimitNone(),
+					ssb.ExploreAll(ssb.ExploreRecursiveEdge())))
+			}
+		})).Node()
+}
+
+// Entry selects the root node, the entry of a key in a Map and the DAG under the link at each of the
+// given indexes of the nested UnixFS directories. Only the blocks on the path to the last link and
+// under it are reached.
+func Entry(key string, links ...int) ipld.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	next := ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+	for i := len(links) - 1; i >= 0; i-- {
+		hash := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Hash", next)
+		})
+		index := links[i]
+		next = ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("Links", ssb.ExploreIndex(index, hash))
+		})
+	}
+	value := next
+	return ssb.ExploreUnion(ssb.Matcher(),
+		ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert(key, ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+				efsb.Insert("Value", value)
+			}))
+		})).Node()
+}